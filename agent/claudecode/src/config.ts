@@ -12,6 +12,10 @@ export interface AgentConfig {
   // OpenCode settings
   opencodeBaseUrl: string;
   opencodeApiKey?: string;
+  // Responses kept for clients catching up after a disconnect
+  responseHistorySize: number;
+  // Bearer token the platform must send on every RPC; unset accepts any caller
+  authToken?: string;
 }
 
 export function loadConfig(): AgentConfig {
@@ -30,5 +34,10 @@ export function loadConfig(): AgentConfig {
       "acceptEdits",
     opencodeBaseUrl: process.env.OPENCODE_BASE_URL || "http://localhost:4096",
     opencodeApiKey: process.env.OPENCODE_API_KEY,
+    responseHistorySize: parseInt(
+      process.env.RESPONSE_HISTORY_SIZE || "1000",
+      10,
+    ),
+    authToken: process.env.AGENT_AUTH_TOKEN || undefined,
   };
 }
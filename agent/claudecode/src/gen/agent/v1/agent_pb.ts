@@ -10,7 +10,7 @@ import type { Message } from "@bufbuild/protobuf";
  * Describes the file agent/v1/agent.proto.
  */
 export const file_agent_v1_agent: GenFile = /*@__PURE__*/
-  fileDesc("ChRhZ2VudC92MS9hZ2VudC5wcm90bxIIYWdlbnQudjEihwIKDEFnZW50UmVxdWVzdBISCgpyZXF1ZXN0X2lkGAEgASgJEjQKDHNlbmRfbWVzc2FnZRgCIAEoCzIcLmFnZW50LnYxLlNlbmRNZXNzYWdlUmVxdWVzdEgAEi8KCWludGVycnVwdBgDIAEoCzIaLmFnZW50LnYxLkludGVycnVwdFJlcXVlc3RIABJBChNzZXRfcGVybWlzc2lvbl9tb2RlGAQgASgLMiIuYWdlbnQudjEuU2V0UGVybWlzc2lvbk1vZGVSZXF1ZXN0SAASLgoJc2V0X21vZGVsGAUgASgLMhkuYWdlbnQudjEuU2V0TW9kZWxSZXF1ZXN0SABCCQoHY29tbWFuZCIlChJTZW5kTWVzc2FnZVJlcXVlc3QSDwoHY29udGVudBgBIAEoCSISChBJbnRlcnJ1cHRSZXF1ZXN0IigKGFNldFBlcm1pc3Npb25Nb2RlUmVxdWVzdBIMCgRtb2RlGAEgASgJIiAKD1NldE1vZGVsUmVxdWVzdBINCgVtb2RlbBgBIAEoCSKIAgoNQWdlbnRSZXNwb25zZRISCgpyZXF1ZXN0X2lkGAEgASgJEhIKCnNlc3Npb25faWQYAiABKAkSCwoDc2VxGAMgASgEEhEKCXRpbWVzdGFtcBgEIAEoAxInCgVldmVudBgFIAEoCzIWLmFnZW50LnYxLkV2ZW50UGF5bG9hZEgAEicKBWVycm9yGAYgASgLMhYuYWdlbnQudjEuRXJyb3JQYXlsb2FkSAASLQoIY29tcGxldGUYByABKAsyGS5hZ2VudC52MS5Db21wbGV0ZVBheWxvYWRIABIjCgVzdGF0ZRgIIAEoDjIULmFnZW50LnYxLkFnZW50U3RhdGVCCQoHcGF5bG9hZCI2CgxFdmVudFBheWxvYWQSEgoKZXZlbnRfdHlwZRgBIAEoCRISCgpldmVudF9qc29uGAIgASgMIjwKDEVycm9yUGF5bG9hZBIMCgRjb2RlGAEgASgJEg8KB21lc3NhZ2UYAiABKAkSDQoFZmF0YWwYAyABKAgiIgoPQ29tcGxldGVQYXlsb2FkEg8KB3N1Y2Nlc3MYASABKAgiEgoQR2V0U3RhdHVzUmVxdWVzdCK1AQoRR2V0U3RhdHVzUmVzcG9uc2USEAoIYWdlbnRfaWQYASABKAkSEgoKc2Vzc2lvbl9pZBgCIAEoCRIjCgVzdGF0ZRgDIAEoDjIULmFnZW50LnYxLkFnZW50U3RhdGUSEgoKbGF0ZXN0X3NlcRgEIAEoAxIVCg1jdXJyZW50X21vZGVsGAUgASgJEhcKD3Blcm1pc3Npb25fbW9kZRgGIAEoCRIRCgl1cHRpbWVfbXMYByABKAMiIwoPU2h1dGRvd25SZXF1ZXN0EhAKCGdyYWNlZnVsGAEgASgIIiMKEFNodXRkb3duUmVzcG9uc2USDwoHc3VjY2VzcxgBIAEoCCpyCgpBZ2VudFN0YXRlEhsKF0FHRU5UX1NUQVRFX1VOU1BFQ0lGSUVEEAASFAoQQUdFTlRfU1RBVEVfSURMRRABEhoKFkFHRU5UX1NUQVRFX1BST0NFU1NJTkcQAhIVChFBR0VOVF9TVEFURV9FUlJPUhADMtcBCgxBZ2VudFNlcnZpY2USPgoHQ29ubmVjdBIWLmFnZW50LnYxLkFnZW50UmVxdWVzdBoXLmFnZW50LnYxLkFnZW50UmVzcG9uc2UoATABEkQKCUdldFN0YXR1cxIaLmFnZW50LnYxLkdldFN0YXR1c1JlcXVlc3QaGy5hZ2VudC52MS5HZXRTdGF0dXNSZXNwb25zZRJBCghTaHV0ZG93bhIZLmFnZW50LnYxLlNodXRkb3duUmVxdWVzdBoaLmFnZW50LnYxLlNodXRkb3duUmVzcG9uc2VCMFouZ2l0aHViLmNvbS9mb3JnZS9wbGF0Zm9ybS9nZW4vYWdlbnQvdjE7YWdlbnR2MWIGcHJvdG8z");
+  fileDesc("ChRhZ2VudC92MS9hZ2VudC5wcm90bxIIYWdlbnQudjEihwIKDEFnZW50UmVxdWVzdBISCgpyZXF1ZXN0X2lkGAEgASgJEjQKDHNlbmRfbWVzc2FnZRgCIAEoCzIcLmFnZW50LnYxLlNlbmRNZXNzYWdlUmVxdWVzdEgAEi8KCWludGVycnVwdBgDIAEoCzIaLmFnZW50LnYxLkludGVycnVwdFJlcXVlc3RIABJBChNzZXRfcGVybWlzc2lvbl9tb2RlGAQgASgLMiIuYWdlbnQudjEuU2V0UGVybWlzc2lvbk1vZGVSZXF1ZXN0SAASLgoJc2V0X21vZGVsGAUgASgLMhkuYWdlbnQudjEuU2V0TW9kZWxSZXF1ZXN0SABCCQoHY29tbWFuZCIlChJTZW5kTWVzc2FnZVJlcXVlc3QSDwoHY29udGVudBgBIAEoCSISChBJbnRlcnJ1cHRSZXF1ZXN0IigKGFNldFBlcm1pc3Npb25Nb2RlUmVxdWVzdBIMCgRtb2RlGAEgASgJIiAKD1NldE1vZGVsUmVxdWVzdBINCgVtb2RlbBgBIAEoCSK3AgoNQWdlbnRSZXNwb25zZRISCgpyZXF1ZXN0X2lkGAEgASgJEhIKCnNlc3Npb25faWQYAiABKAkSCwoDc2VxGAMgASgEEhEKCXRpbWVzdGFtcBgEIAEoAxInCgVldmVudBgFIAEoCzIWLmFnZW50LnYxLkV2ZW50UGF5bG9hZEgAEicKBWVycm9yGAYgASgLMhYuYWdlbnQudjEuRXJyb3JQYXlsb2FkSAASLQoIY29tcGxldGUYByABKAsyGS5hZ2VudC52MS5Db21wbGV0ZVBheWxvYWRIABItCghhcnRpZmFjdBgJIAEoCzIZLmFnZW50LnYxLkFydGlmYWN0UGF5bG9hZEgAEiMKBXN0YXRlGAggASgOMhQuYWdlbnQudjEuQWdlbnRTdGF0ZUIJCgdwYXlsb2FkIjYKDEV2ZW50UGF5bG9hZBISCgpldmVudF90eXBlGAEgASgJEhIKCmV2ZW50X2pzb24YAiABKAwiPAoMRXJyb3JQYXlsb2FkEgwKBGNvZGUYASABKAkSDwoHbWVzc2FnZRgCIAEoCRINCgVmYXRhbBgDIAEoCCIiCg9Db21wbGV0ZVBheWxvYWQSDwoHc3VjY2VzcxgBIAEoCCJBCg9BcnRpZmFjdFBheWxvYWQSDAoEbmFtZRgBIAEoCRISCgptZWRpYV90eXBlGAIgASgJEgwKBGRhdGEYAyABKAwiEgoQR2V0U3RhdHVzUmVxdWVzdCLPAQoRR2V0U3RhdHVzUmVzcG9uc2USEAoIYWdlbnRfaWQYASABKAkSEgoKc2Vzc2lvbl9pZBgCIAEoCRIjCgVzdGF0ZRgDIAEoDjIULmFnZW50LnYxLkFnZW50U3RhdGUSEgoKbGF0ZXN0X3NlcRgEIAEoAxIVCg1jdXJyZW50X21vZGVsGAUgASgJEhcKD3Blcm1pc3Npb25fbW9kZRgGIAEoCRIRCgl1cHRpbWVfbXMYByABKAMSGAoQcHJvdG9jb2xfdmVyc2lvbhgIIAEoBSJFCg5DYXRjaFVwUmVxdWVzdBIQCghmcm9tX3NlcRgBIAEoBBINCgVsaW1pdBgCIAEoBRISCgpyZXF1ZXN0X2lkGAMgASgJImYKD0NhdGNoVXBSZXNwb25zZRIqCglyZXNwb25zZXMYASADKAsyFy5hZ2VudC52MS5BZ2VudFJlc3BvbnNlEhIKCmxhdGVzdF9zZXEYAiABKAQSEwoLaW5fcHJvZ3Jlc3MYAyABKAgiIwoPU2h1dGRvd25SZXF1ZXN0EhAKCGdyYWNlZnVsGAEgASgIIiMKEFNodXRkb3duUmVzcG9uc2USDwoHc3VjY2VzcxgBIAEoCCpyCgpBZ2VudFN0YXRlEhsKF0FHRU5UX1NUQVRFX1VOU1BFQ0lGSUVEEAASFAoQQUdFTlRfU1RBVEVfSURMRRABEhoKFkFHRU5UX1NUQVRFX1BST0NFU1NJTkcQAhIVChFBR0VOVF9TVEFURV9FUlJPUhADMpcCCgxBZ2VudFNlcnZpY2USPgoHQ29ubmVjdBIWLmFnZW50LnYxLkFnZW50UmVxdWVzdBoXLmFnZW50LnYxLkFnZW50UmVzcG9uc2UoATABEkQKCUdldFN0YXR1cxIaLmFnZW50LnYxLkdldFN0YXR1c1JlcXVlc3QaGy5hZ2VudC52MS5HZXRTdGF0dXNSZXNwb25zZRJBCghTaHV0ZG93bhIZLmFnZW50LnYxLlNodXRkb3duUmVxdWVzdBoaLmFnZW50LnYxLlNodXRkb3duUmVzcG9uc2USPgoHQ2F0Y2hVcBIYLmFnZW50LnYxLkNhdGNoVXBSZXF1ZXN0GhkuYWdlbnQudjEuQ2F0Y2hVcFJlc3BvbnNlQjBaLmdpdGh1Yi5jb20vZm9yZ2UvcGxhdGZvcm0vZ2VuL2FnZW50L3YxO2FnZW50djFiBnByb3RvMw==");
 
 /**
  * Request from platform to agent
@@ -183,6 +183,14 @@ export type AgentResponse = Message<"agent.v1.AgentResponse"> & {
      */
     value: CompletePayload;
     case: "complete";
+  } | {
+    /**
+     * File produced by the agent
+     *
+     * @generated from field: agent.v1.ArtifactPayload artifact = 9;
+     */
+    value: ArtifactPayload;
+    case: "artifact";
   } | { case: undefined; value?: undefined };
 
   /**
@@ -275,6 +283,41 @@ export type CompletePayload = Message<"agent.v1.CompletePayload"> & {
 export const CompletePayloadSchema: GenMessage<CompletePayload> = /*@__PURE__*/
   messageDesc(file_agent_v1_agent, 8);
 
+/**
+ * File produced by the agent while handling a request (e.g., a report or a patch).
+ * The platform stores it and attaches it to the request's final webhook.
+ * Agents should keep artifacts small; the platform drops ones over its size limit.
+ *
+ * @generated from message agent.v1.ArtifactPayload
+ */
+export type ArtifactPayload = Message<"agent.v1.ArtifactPayload"> & {
+  /**
+   * File name, unique per request (e.g., "report.md")
+   *
+   * @generated from field: string name = 1;
+   */
+  name: string;
+
+  /**
+   * e.g., "text/markdown", "application/octet-stream"
+   *
+   * @generated from field: string media_type = 2;
+   */
+  mediaType: string;
+
+  /**
+   * @generated from field: bytes data = 3;
+   */
+  data: Uint8Array;
+};
+
+/**
+ * Describes the message agent.v1.ArtifactPayload.
+ * Use `create(ArtifactPayloadSchema)` to create a new message.
+ */
+export const ArtifactPayloadSchema: GenMessage<ArtifactPayload> = /*@__PURE__*/
+  messageDesc(file_agent_v1_agent, 9);
+
 /**
  * GetStatus - unchanged
  *
@@ -288,7 +331,7 @@ export type GetStatusRequest = Message<"agent.v1.GetStatusRequest"> & {
  * Use `create(GetStatusRequestSchema)` to create a new message.
  */
 export const GetStatusRequestSchema: GenMessage<GetStatusRequest> = /*@__PURE__*/
-  messageDesc(file_agent_v1_agent, 9);
+  messageDesc(file_agent_v1_agent, 10);
 
 /**
  * @generated from message agent.v1.GetStatusResponse
@@ -328,6 +371,13 @@ export type GetStatusResponse = Message<"agent.v1.GetStatusResponse"> & {
    * @generated from field: int64 uptime_ms = 7;
    */
   uptimeMs: bigint;
+
+  /**
+   * Agent protocol version this agent speaks (0 = built before versioning)
+   *
+   * @generated from field: int32 protocol_version = 8;
+   */
+  protocolVersion: number;
 };
 
 /**
@@ -335,7 +385,72 @@ export type GetStatusResponse = Message<"agent.v1.GetStatusResponse"> & {
  * Use `create(GetStatusResponseSchema)` to create a new message.
  */
 export const GetStatusResponseSchema: GenMessage<GetStatusResponse> = /*@__PURE__*/
-  messageDesc(file_agent_v1_agent, 10);
+  messageDesc(file_agent_v1_agent, 11);
+
+/**
+ * CatchUp - responses from from_seq onwards, oldest first
+ *
+ * @generated from message agent.v1.CatchUpRequest
+ */
+export type CatchUpRequest = Message<"agent.v1.CatchUpRequest"> & {
+  /**
+   * @generated from field: uint64 from_seq = 1;
+   */
+  fromSeq: bigint;
+
+  /**
+   * Max responses returned (0 = agent default)
+   *
+   * @generated from field: int32 limit = 2;
+   */
+  limit: number;
+
+  /**
+   * Only return this request's responses (empty = all)
+   *
+   * @generated from field: string request_id = 3;
+   */
+  requestId: string;
+};
+
+/**
+ * Describes the message agent.v1.CatchUpRequest.
+ * Use `create(CatchUpRequestSchema)` to create a new message.
+ */
+export const CatchUpRequestSchema: GenMessage<CatchUpRequest> = /*@__PURE__*/
+  messageDesc(file_agent_v1_agent, 12);
+
+/**
+ * @generated from message agent.v1.CatchUpResponse
+ */
+export type CatchUpResponse = Message<"agent.v1.CatchUpResponse"> & {
+  /**
+   * @generated from field: repeated agent.v1.AgentResponse responses = 1;
+   */
+  responses: AgentResponse[];
+
+  /**
+   * Highest seq the agent has sent
+   *
+   * @generated from field: uint64 latest_seq = 2;
+   */
+  latestSeq: bigint;
+
+  /**
+   * True while the agent is still working on request_id (or on anything, if unset):
+   * responses after latest_seq may follow
+   *
+   * @generated from field: bool in_progress = 3;
+   */
+  inProgress: boolean;
+};
+
+/**
+ * Describes the message agent.v1.CatchUpResponse.
+ * Use `create(CatchUpResponseSchema)` to create a new message.
+ */
+export const CatchUpResponseSchema: GenMessage<CatchUpResponse> = /*@__PURE__*/
+  messageDesc(file_agent_v1_agent, 13);
 
 /**
  * Shutdown - unchanged
@@ -354,7 +469,7 @@ export type ShutdownRequest = Message<"agent.v1.ShutdownRequest"> & {
  * Use `create(ShutdownRequestSchema)` to create a new message.
  */
 export const ShutdownRequestSchema: GenMessage<ShutdownRequest> = /*@__PURE__*/
-  messageDesc(file_agent_v1_agent, 11);
+  messageDesc(file_agent_v1_agent, 14);
 
 /**
  * @generated from message agent.v1.ShutdownResponse
@@ -371,7 +486,7 @@ export type ShutdownResponse = Message<"agent.v1.ShutdownResponse"> & {
  * Use `create(ShutdownResponseSchema)` to create a new message.
  */
 export const ShutdownResponseSchema: GenMessage<ShutdownResponse> = /*@__PURE__*/
-  messageDesc(file_agent_v1_agent, 12);
+  messageDesc(file_agent_v1_agent, 15);
 
 /**
  * @generated from enum agent.v1.AgentState
@@ -443,6 +558,18 @@ export const AgentService: GenService<{
     input: typeof ShutdownRequestSchema;
     output: typeof ShutdownResponseSchema;
   },
+  /**
+   * CatchUp returns responses the agent already sent, so a client whose stream dropped
+   * can pick up where it left off. Agents keep working on a request when its stream
+   * drops, and keep a bounded history of their responses.
+   *
+   * @generated from rpc agent.v1.AgentService.CatchUp
+   */
+  catchUp: {
+    methodKind: "unary";
+    input: typeof CatchUpRequestSchema;
+    output: typeof CatchUpResponseSchema;
+  },
 }> = /*@__PURE__*/
   serviceDesc(file_agent_v1_agent, 0);
 
@@ -5,6 +5,7 @@
  * 1. Manages one OpenCode session per pod
  * 2. Streams OpenCode events as raw JSON to the platform
  * 3. Filters out infrastructure events (heartbeats, etc.)
+ * 4. Keeps a history of its responses for clients catching up after a disconnect
  */
 
 import { create } from "@bufbuild/protobuf";
@@ -12,11 +13,14 @@ import { createOpencodeClient } from "@opencode-ai/sdk";
 import type { AgentConfig } from "../config.ts";
 import {
   type AgentResponse,
+  type CatchUpRequest,
+  type CatchUpResponse,
   type GetStatusResponse,
   type ShutdownRequest,
   type ShutdownResponse,
   AgentResponseSchema,
   AgentState,
+  CatchUpResponseSchema,
   CompletePayloadSchema,
   ErrorPayloadSchema,
   EventPayloadSchema,
@@ -32,6 +36,17 @@ import {
   isErrorEvent,
   getMessageFinishReason,
 } from "../opencode/events.ts";
+import { ResponseLog } from "./response-log.ts";
+
+/**
+ * Agent protocol version this agent speaks, reported in GetStatus.
+ * Must match the platform's agent.ProtocolVersion for the features it implements.
+ */
+export const PROTOCOL_VERSION = 1;
+
+/** Responses returned by a CatchUp that sets no limit, and the most one may return */
+const DEFAULT_CATCH_UP_LIMIT = 100;
+const MAX_CATCH_UP_LIMIT = 1000;
 
 export class AgentService {
   private config: AgentConfig;
@@ -42,11 +57,13 @@ export class AgentService {
   private startTime: number;
   private abortController: AbortController | null = null;
   private currentModel: string;
+  private log: ResponseLog;
 
   constructor(config: AgentConfig) {
     this.config = config;
     this.startTime = Date.now();
     this.currentModel = config.model;
+    this.log = new ResponseLog(config.responseHistorySize);
 
     // Create OpenCode client
     this.client = createOpencodeClient({
@@ -108,11 +125,42 @@ export class AgentService {
     return this.seq;
   }
 
+  /**
+   * Start a SendMessage command and follow its responses.
+   * The message is processed in the background, so it keeps running if the
+   * caller's stream drops; the responses it missed can be read with CatchUp.
+   */
+  sendMessage(
+    requestId: string,
+    content: string,
+  ): AsyncGenerator<AgentResponse> {
+    const fromSeq = this.seq + 1n;
+    this.log.start(requestId);
+    void this.runSendMessage(requestId, content);
+    return this.log.follow(requestId, fromSeq);
+  }
+
+  private async runSendMessage(
+    requestId: string,
+    content: string,
+  ): Promise<void> {
+    try {
+      // Responses are recorded in the log as they are created
+      for await (const _ of this.handleSendMessage(requestId, content)) {
+        // drain
+      }
+    } catch (error) {
+      this.handleError(requestId, error);
+    } finally {
+      this.log.finish(requestId);
+    }
+  }
+
   /**
    * Handle a SendMessage command - sends user input to OpenCode
    * and streams back raw events as JSON.
    */
-  async *handleSendMessage(
+  private async *handleSendMessage(
     requestId: string,
     content: string,
   ): AsyncGenerator<AgentResponse> {
@@ -309,6 +357,22 @@ export class AgentService {
       currentModel: this.currentModel,
       permissionMode: this.config.permissionMode,
       uptimeMs: BigInt(Date.now() - this.startTime),
+      protocolVersion: PROTOCOL_VERSION,
+    });
+  }
+
+  /**
+   * Return the responses sent from request.fromSeq onwards
+   */
+  catchUp(request: CatchUpRequest): CatchUpResponse {
+    const limit =
+      request.limit > 0
+        ? Math.min(request.limit, MAX_CATCH_UP_LIMIT)
+        : DEFAULT_CATCH_UP_LIMIT;
+    return create(CatchUpResponseSchema, {
+      responses: this.log.since(request.fromSeq, limit, request.requestId),
+      latestSeq: this.seq,
+      inProgress: this.log.isRunning(request.requestId),
     });
   }
 
@@ -349,20 +413,22 @@ export class AgentService {
     sessionId: string,
     event: OpencodeEvent,
   ): AgentResponse {
-    return create(AgentResponseSchema, {
-      requestId,
-      sessionId,
-      seq: this.nextSeq(),
-      timestamp: BigInt(Date.now()),
-      state: this.state,
-      payload: {
-        case: "event",
-        value: create(EventPayloadSchema, {
-          eventType: event.type,
-          eventJson: new TextEncoder().encode(JSON.stringify(event)),
-        }),
-      },
-    });
+    return this.log.append(
+      create(AgentResponseSchema, {
+        requestId,
+        sessionId,
+        seq: this.nextSeq(),
+        timestamp: BigInt(Date.now()),
+        state: this.state,
+        payload: {
+          case: "event",
+          value: create(EventPayloadSchema, {
+            eventType: event.type,
+            eventJson: new TextEncoder().encode(JSON.stringify(event)),
+          }),
+        },
+      }),
+    );
   }
 
   private createErrorResponse(
@@ -372,17 +438,19 @@ export class AgentService {
     message: string,
     fatal: boolean,
   ): AgentResponse {
-    return create(AgentResponseSchema, {
-      requestId,
-      sessionId,
-      seq: this.nextSeq(),
-      timestamp: BigInt(Date.now()),
-      state: this.state,
-      payload: {
-        case: "error",
-        value: create(ErrorPayloadSchema, { code, message, fatal }),
-      },
-    });
+    return this.log.append(
+      create(AgentResponseSchema, {
+        requestId,
+        sessionId,
+        seq: this.nextSeq(),
+        timestamp: BigInt(Date.now()),
+        state: this.state,
+        payload: {
+          case: "error",
+          value: create(ErrorPayloadSchema, { code, message, fatal }),
+        },
+      }),
+    );
   }
 
   private createCompleteResponse(
@@ -390,17 +458,19 @@ export class AgentService {
     sessionId: string,
     success: boolean,
   ): AgentResponse {
-    return create(AgentResponseSchema, {
-      requestId,
-      sessionId,
-      seq: this.nextSeq(),
-      timestamp: BigInt(Date.now()),
-      state: this.state,
-      payload: {
-        case: "complete",
-        value: create(CompletePayloadSchema, { success }),
-      },
-    });
+    return this.log.append(
+      create(AgentResponseSchema, {
+        requestId,
+        sessionId,
+        seq: this.nextSeq(),
+        timestamp: BigInt(Date.now()),
+        state: this.state,
+        payload: {
+          case: "complete",
+          value: create(CompletePayloadSchema, { success }),
+        },
+      }),
+    );
   }
 
   /**
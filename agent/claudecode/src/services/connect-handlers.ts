@@ -6,6 +6,8 @@
 import type {
   AgentRequest,
   AgentResponse,
+  CatchUpRequest,
+  CatchUpResponse,
   GetStatusResponse,
   ShutdownRequest,
   ShutdownResponse,
@@ -19,6 +21,7 @@ export interface ConnectHandlers {
   connect(requests: AsyncIterable<AgentRequest>): AsyncIterable<AgentResponse>;
   getStatus(): Promise<GetStatusResponse>;
   shutdown(request: ShutdownRequest): Promise<ShutdownResponse>;
+  catchUp(request: CatchUpRequest): Promise<CatchUpResponse>;
 }
 
 /**
@@ -56,6 +59,13 @@ export function createConnectHandlers(service: AgentService): ConnectHandlers {
     async shutdown(request: ShutdownRequest): Promise<ShutdownResponse> {
       return service.shutdown(request);
     },
+
+    /**
+     * Unary RPC - responses the agent already sent, for clients whose stream dropped
+     */
+    async catchUp(request: CatchUpRequest): Promise<CatchUpResponse> {
+      return service.catchUp(request);
+    },
   };
 }
 
@@ -70,7 +80,7 @@ async function* handleCommand(
   switch (request.command.case) {
     case "sendMessage": {
       const { content } = request.command.value;
-      yield* service.handleSendMessage(requestId, content);
+      yield* service.sendMessage(requestId, content);
       break;
     }
 
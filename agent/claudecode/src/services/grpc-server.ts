@@ -1,4 +1,10 @@
-import type { ConnectRouter } from "@connectrpc/connect";
+import {
+  Code,
+  ConnectError,
+  type ConnectRouter,
+  type Interceptor,
+} from "@connectrpc/connect";
+import { timingSafeEqual } from "node:crypto";
 import { fastifyConnectPlugin } from "@connectrpc/connect-fastify";
 import { fastify, type FastifyInstance } from "fastify";
 import type { Http2Server } from "node:http2";
@@ -43,6 +49,20 @@ function createRoutes(service: AgentService): (router: ConnectRouter) => void {
   };
 }
 
+/**
+ * Rejects RPCs that do not carry the platform's bearer token for this agent
+ */
+function authInterceptor(token: string): Interceptor {
+  const expected = Buffer.from(`Bearer ${token}`);
+  return (next) => async (req) => {
+    const got = Buffer.from(req.header.get("authorization") ?? "");
+    if (got.length !== expected.length || !timingSafeEqual(got, expected)) {
+      throw new ConnectError("invalid agent token", Code.Unauthenticated);
+    }
+    return next(req);
+  };
+}
+
 /**
  * Creates and configures the Fastify server with Connect-RPC and HTTP/2
  */
@@ -64,6 +84,7 @@ export async function createServer(
   // Register Connect-RPC plugin
   await server.register(fastifyConnectPlugin, {
     routes: createRoutes(service),
+    interceptors: config.authToken ? [authInterceptor(config.authToken)] : [],
   });
 
   // Register health check endpoints
@@ -0,0 +1,95 @@
+/**
+ * ResponseLog - bounded history of the responses the agent has sent.
+ *
+ * Requests run independently of the stream that started them, so a platform whose
+ * stream dropped can catch up on the responses it missed (CatchUp) while the agent
+ * keeps working. The oldest responses are dropped once the log is full.
+ */
+
+import type { AgentResponse } from "../gen/agent/v1/agent_pb.ts";
+
+export class ResponseLog {
+  private capacity: number;
+  private responses: AgentResponse[] = [];
+  private running = new Set<string>();
+  private waiters: Array<() => void> = [];
+
+  constructor(capacity: number) {
+    this.capacity = capacity;
+  }
+
+  /**
+   * Records a response, dropping the oldest one if the log is full
+   */
+  append(response: AgentResponse): AgentResponse {
+    this.responses.push(response);
+    if (this.responses.length > this.capacity) {
+      this.responses.shift();
+    }
+    this.notify();
+    return response;
+  }
+
+  /**
+   * Marks a request as running until finish is called
+   */
+  start(requestId: string): void {
+    this.running.add(requestId);
+  }
+
+  finish(requestId: string): void {
+    this.running.delete(requestId);
+    this.notify();
+  }
+
+  /**
+   * Reports whether requestId, or any request if it is empty, is still running
+   */
+  isRunning(requestId: string): boolean {
+    return requestId ? this.running.has(requestId) : this.running.size > 0;
+  }
+
+  /**
+   * Returns up to limit responses from fromSeq onwards, oldest first.
+   * If requestId is set, only that request's responses are returned.
+   */
+  since(fromSeq: bigint, limit: number, requestId: string): AgentResponse[] {
+    const found: AgentResponse[] = [];
+    for (const response of this.responses) {
+      if (found.length >= limit) break;
+      if (response.seq < fromSeq) continue;
+      if (requestId && response.requestId !== requestId) continue;
+      found.push(response);
+    }
+    return found;
+  }
+
+  /**
+   * Yields a request's responses from fromSeq onwards as they are recorded,
+   * until the request is no longer running
+   */
+  async *follow(
+    requestId: string,
+    fromSeq: bigint,
+  ): AsyncGenerator<AgentResponse> {
+    let next = fromSeq;
+    for (;;) {
+      const found = this.since(next, Number.MAX_SAFE_INTEGER, requestId);
+      if (found.length === 0) {
+        if (!this.running.has(requestId)) return;
+        await new Promise<void>((resolve) => this.waiters.push(resolve));
+        continue;
+      }
+      for (const response of found) {
+        next = response.seq + 1n;
+        yield response;
+      }
+    }
+  }
+
+  private notify(): void {
+    const waiters = this.waiters;
+    this.waiters = [];
+    for (const wake of waiters) wake();
+  }
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/logger"
+)
+
+// runLabelAgents runs the label-agents subcommand, which marks the agent objects created
+// before they were labeled as managed by the platform
+func runLabelAgents(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: server label-agents")
+	}
+
+	log, err := logger.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = log.Sync() }()
+
+	containerCfg, err := k8s.NewContainerConfig()
+	if err != nil {
+		return err
+	}
+	mgr, err := k8s.NewManager(k8s.ManagerOpts{
+		KubeConfigPath: cfg.KubeConfigPath,
+		ContainerCfg:   *containerCfg,
+		AgentNamespace: cfg.AgentNamespace,
+		WorkloadKind:   cfg.AgentWorkloadKind,
+		QPS:            cfg.KubeClientQPS,
+		Burst:          cfg.KubeClientBurst,
+	})
+	if err != nil {
+		return err
+	}
+
+	labeled, err := mgr.MigrateManagedLabels(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Info("agent objects labeled", zap.Int("labeled", labeled), zap.String("namespace", mgr.AgentNamespace()))
+	return nil
+}
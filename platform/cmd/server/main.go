@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
+	"go.uber.org/zap"
+
+	agenthandler "github.com/forge/platform/internal/agent/handler"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/db"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/logger"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/server"
+	"github.com/forge/platform/internal/webhook"
+)
+
+func main() {
+	cfg, err := config.New()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "label-agents" {
+		if err := runLabelAgents(cfg, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "label-agents: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fx.New(appOptions(cfg)).Run()
+}
+
+// appOptions composes the platform's fx application. Shutdown flows through the modules'
+// lifecycle hooks.
+func appOptions(cfg *config.Config) fx.Option {
+	return fx.Options(
+		// Provide config
+		fx.Supply(cfg),
+
+		// Shutdown stops taking requests, drains in-flight ones and stops the background
+		// workers, all within SHUTDOWN_TIMEOUT
+		fx.StopTimeout(cfg.ShutdownTimeout),
+
+		// Core modules
+		logger.Module,
+		metrics.Module,
+		db.Module,
+		k8s.Module,
+		webhook.Module,
+		processor.Module,
+		agenthandler.Module,
+		server.Module,
+		handler.Module,
+
+		// Serve last, so requests are taken once everything started and are the first
+		// thing to stop on shutdown
+		fx.Invoke(server.Serve),
+
+		// Configure fx logging
+		fx.WithLogger(func(log *zap.Logger) fxevent.Logger {
+			return &fxevent.ZapLogger{Logger: log}
+		}),
+	)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"go.uber.org/fx"
+
+	"github.com/forge/platform/internal/config"
+)
+
+func TestAppOptions_GraphResolves(t *testing.T) {
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("config.New: %v", err)
+	}
+	if err := fx.ValidateApp(appOptions(cfg)); err != nil {
+		t.Fatalf("fx graph does not resolve: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/db"
+	"github.com/forge/platform/internal/logger"
+)
+
+const migrateUsage = "usage: server migrate [up|down|status]"
+
+// runMigrate runs the migrate subcommand: up applies the pending migrations, down rolls
+// back the latest one and status lists them
+func runMigrate(cfg *config.Config, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if len(args) > 1 {
+		return errors.New(migrateUsage)
+	}
+
+	log, err := logger.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = log.Sync() }()
+
+	ctx := context.Background()
+	poolCfg, err := db.PoolConfig(cfg)
+	if err != nil {
+		return err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return fmt.Errorf("creating connection pool: %w", err)
+	}
+	defer pool.Close()
+
+	return db.WithMigrator(ctx, pool, log, func(m *db.Migrator) error {
+		switch command {
+		case "up":
+			n, err := m.Up(ctx)
+			if err != nil {
+				return err
+			}
+			log.Info("database migrated", zap.Int("applied", n))
+		case "down":
+			rolledBack, err := m.Down(ctx)
+			if err != nil {
+				return err
+			}
+			if !rolledBack {
+				log.Info("no migration to roll back")
+			}
+		case "status":
+			status, err := m.Status(ctx)
+			if err != nil {
+				return err
+			}
+			for _, s := range status {
+				state := "pending"
+				if s.Applied {
+					state = "applied"
+				}
+				fmt.Fprintf(os.Stdout, "%05d_%s\t%s\n", s.Version, s.Name, state)
+			}
+		default:
+			return errors.New(migrateUsage)
+		}
+		return nil
+	})
+}
@@ -377,6 +377,7 @@ type AgentResponse struct {
 	//	*AgentResponse_Event
 	//	*AgentResponse_Error
 	//	*AgentResponse_Complete
+	//	*AgentResponse_Artifact
 	Payload isAgentResponse_Payload `protobuf_oneof:"payload"`
 	// Current agent state - included in every response for real-time state tracking
 	State         AgentState `protobuf:"varint,8,opt,name=state,proto3,enum=agent.v1.AgentState" json:"state,omitempty"`
@@ -476,6 +477,15 @@ func (x *AgentResponse) GetComplete() *CompletePayload {
 	return nil
 }
 
+func (x *AgentResponse) GetArtifact() *ArtifactPayload {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentResponse_Artifact); ok {
+			return x.Artifact
+		}
+	}
+	return nil
+}
+
 func (x *AgentResponse) GetState() AgentState {
 	if x != nil {
 		return x.State
@@ -499,12 +509,18 @@ type AgentResponse_Complete struct {
 	Complete *CompletePayload `protobuf:"bytes,7,opt,name=complete,proto3,oneof"` // Stream complete
 }
 
+type AgentResponse_Artifact struct {
+	Artifact *ArtifactPayload `protobuf:"bytes,9,opt,name=artifact,proto3,oneof"` // File produced by the agent
+}
+
 func (*AgentResponse_Event) isAgentResponse_Payload() {}
 
 func (*AgentResponse_Error) isAgentResponse_Payload() {}
 
 func (*AgentResponse_Complete) isAgentResponse_Payload() {}
 
+func (*AgentResponse_Artifact) isAgentResponse_Payload() {}
+
 // Pass-through OpenCode event as JSON
 // The platform does not parse this - it forwards directly to webhook consumers
 type EventPayload struct {
@@ -663,6 +679,69 @@ func (x *CompletePayload) GetSuccess() bool {
 	return false
 }
 
+// File produced by the agent while handling a request (e.g., a report or a patch).
+// The platform stores it and attaches it to the request's final webhook.
+// Agents should keep artifacts small; the platform drops ones over its size limit.
+type ArtifactPayload struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`                            // File name, unique per request (e.g., "report.md")
+	MediaType     string                 `protobuf:"bytes,2,opt,name=media_type,json=mediaType,proto3" json:"media_type,omitempty"` // e.g., "text/markdown", "application/octet-stream"
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ArtifactPayload) Reset() {
+	*x = ArtifactPayload{}
+	mi := &file_agent_v1_agent_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ArtifactPayload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArtifactPayload) ProtoMessage() {}
+
+func (x *ArtifactPayload) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_v1_agent_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArtifactPayload.ProtoReflect.Descriptor instead.
+func (*ArtifactPayload) Descriptor() ([]byte, []int) {
+	return file_agent_v1_agent_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ArtifactPayload) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ArtifactPayload) GetMediaType() string {
+	if x != nil {
+		return x.MediaType
+	}
+	return ""
+}
+
+func (x *ArtifactPayload) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 // GetStatus - unchanged
 type GetStatusRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -672,7 +751,7 @@ type GetStatusRequest struct {
 
 func (x *GetStatusRequest) Reset() {
 	*x = GetStatusRequest{}
-	mi := &file_agent_v1_agent_proto_msgTypes[9]
+	mi := &file_agent_v1_agent_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -684,7 +763,7 @@ func (x *GetStatusRequest) String() string {
 func (*GetStatusRequest) ProtoMessage() {}
 
 func (x *GetStatusRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_v1_agent_proto_msgTypes[9]
+	mi := &file_agent_v1_agent_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -697,7 +776,7 @@ func (x *GetStatusRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetStatusRequest.ProtoReflect.Descriptor instead.
 func (*GetStatusRequest) Descriptor() ([]byte, []int) {
-	return file_agent_v1_agent_proto_rawDescGZIP(), []int{9}
+	return file_agent_v1_agent_proto_rawDescGZIP(), []int{10}
 }
 
 type GetStatusResponse struct {
@@ -709,13 +788,15 @@ type GetStatusResponse struct {
 	CurrentModel   string                 `protobuf:"bytes,5,opt,name=current_model,json=currentModel,proto3" json:"current_model,omitempty"`
 	PermissionMode string                 `protobuf:"bytes,6,opt,name=permission_mode,json=permissionMode,proto3" json:"permission_mode,omitempty"`
 	UptimeMs       int64                  `protobuf:"varint,7,opt,name=uptime_ms,json=uptimeMs,proto3" json:"uptime_ms,omitempty"`
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	// Agent protocol version this agent speaks (0 = built before versioning)
+	ProtocolVersion int32 `protobuf:"varint,8,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *GetStatusResponse) Reset() {
 	*x = GetStatusResponse{}
-	mi := &file_agent_v1_agent_proto_msgTypes[10]
+	mi := &file_agent_v1_agent_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -727,7 +808,7 @@ func (x *GetStatusResponse) String() string {
 func (*GetStatusResponse) ProtoMessage() {}
 
 func (x *GetStatusResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_v1_agent_proto_msgTypes[10]
+	mi := &file_agent_v1_agent_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -740,7 +821,7 @@ func (x *GetStatusResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetStatusResponse.ProtoReflect.Descriptor instead.
 func (*GetStatusResponse) Descriptor() ([]byte, []int) {
-	return file_agent_v1_agent_proto_rawDescGZIP(), []int{10}
+	return file_agent_v1_agent_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *GetStatusResponse) GetAgentId() string {
@@ -792,6 +873,136 @@ func (x *GetStatusResponse) GetUptimeMs() int64 {
 	return 0
 }
 
+func (x *GetStatusResponse) GetProtocolVersion() int32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+// CatchUp - responses from from_seq onwards, oldest first
+type CatchUpRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FromSeq       uint64                 `protobuf:"varint,1,opt,name=from_seq,json=fromSeq,proto3" json:"from_seq,omitempty"`
+	Limit         int32                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`                         // Max responses returned (0 = agent default)
+	RequestId     string                 `protobuf:"bytes,3,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"` // Only return this request's responses (empty = all)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CatchUpRequest) Reset() {
+	*x = CatchUpRequest{}
+	mi := &file_agent_v1_agent_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CatchUpRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CatchUpRequest) ProtoMessage() {}
+
+func (x *CatchUpRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_v1_agent_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CatchUpRequest.ProtoReflect.Descriptor instead.
+func (*CatchUpRequest) Descriptor() ([]byte, []int) {
+	return file_agent_v1_agent_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *CatchUpRequest) GetFromSeq() uint64 {
+	if x != nil {
+		return x.FromSeq
+	}
+	return 0
+}
+
+func (x *CatchUpRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *CatchUpRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+type CatchUpResponse struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Responses []*AgentResponse       `protobuf:"bytes,1,rep,name=responses,proto3" json:"responses,omitempty"`
+	LatestSeq uint64                 `protobuf:"varint,2,opt,name=latest_seq,json=latestSeq,proto3" json:"latest_seq,omitempty"` // Highest seq the agent has sent
+	// True while the agent is still working on request_id (or on anything, if unset):
+	// responses after latest_seq may follow
+	InProgress    bool `protobuf:"varint,3,opt,name=in_progress,json=inProgress,proto3" json:"in_progress,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CatchUpResponse) Reset() {
+	*x = CatchUpResponse{}
+	mi := &file_agent_v1_agent_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CatchUpResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CatchUpResponse) ProtoMessage() {}
+
+func (x *CatchUpResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_v1_agent_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CatchUpResponse.ProtoReflect.Descriptor instead.
+func (*CatchUpResponse) Descriptor() ([]byte, []int) {
+	return file_agent_v1_agent_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *CatchUpResponse) GetResponses() []*AgentResponse {
+	if x != nil {
+		return x.Responses
+	}
+	return nil
+}
+
+func (x *CatchUpResponse) GetLatestSeq() uint64 {
+	if x != nil {
+		return x.LatestSeq
+	}
+	return 0
+}
+
+func (x *CatchUpResponse) GetInProgress() bool {
+	if x != nil {
+		return x.InProgress
+	}
+	return false
+}
+
 // Shutdown - unchanged
 type ShutdownRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -802,7 +1013,7 @@ type ShutdownRequest struct {
 
 func (x *ShutdownRequest) Reset() {
 	*x = ShutdownRequest{}
-	mi := &file_agent_v1_agent_proto_msgTypes[11]
+	mi := &file_agent_v1_agent_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -814,7 +1025,7 @@ func (x *ShutdownRequest) String() string {
 func (*ShutdownRequest) ProtoMessage() {}
 
 func (x *ShutdownRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_v1_agent_proto_msgTypes[11]
+	mi := &file_agent_v1_agent_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -827,7 +1038,7 @@ func (x *ShutdownRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ShutdownRequest.ProtoReflect.Descriptor instead.
 func (*ShutdownRequest) Descriptor() ([]byte, []int) {
-	return file_agent_v1_agent_proto_rawDescGZIP(), []int{11}
+	return file_agent_v1_agent_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *ShutdownRequest) GetGraceful() bool {
@@ -846,7 +1057,7 @@ type ShutdownResponse struct {
 
 func (x *ShutdownResponse) Reset() {
 	*x = ShutdownResponse{}
-	mi := &file_agent_v1_agent_proto_msgTypes[12]
+	mi := &file_agent_v1_agent_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -858,7 +1069,7 @@ func (x *ShutdownResponse) String() string {
 func (*ShutdownResponse) ProtoMessage() {}
 
 func (x *ShutdownResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_v1_agent_proto_msgTypes[12]
+	mi := &file_agent_v1_agent_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -871,7 +1082,7 @@ func (x *ShutdownResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ShutdownResponse.ProtoReflect.Descriptor instead.
 func (*ShutdownResponse) Descriptor() ([]byte, []int) {
-	return file_agent_v1_agent_proto_rawDescGZIP(), []int{12}
+	return file_agent_v1_agent_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *ShutdownResponse) GetSuccess() bool {
@@ -900,7 +1111,7 @@ const file_agent_v1_agent_proto_rawDesc = "" +
 	"\x18SetPermissionModeRequest\x12\x12\n" +
 	"\x04mode\x18\x01 \x01(\tR\x04mode\"'\n" +
 	"\x0fSetModelRequest\x12\x14\n" +
-	"\x05model\x18\x01 \x01(\tR\x05model\"\xcd\x02\n" +
+	"\x05model\x18\x01 \x01(\tR\x05model\"\x86\x03\n" +
 	"\rAgentResponse\x12\x1d\n" +
 	"\n" +
 	"request_id\x18\x01 \x01(\tR\trequestId\x12\x1d\n" +
@@ -910,7 +1121,8 @@ const file_agent_v1_agent_proto_rawDesc = "" +
 	"\ttimestamp\x18\x04 \x01(\x03R\ttimestamp\x12.\n" +
 	"\x05event\x18\x05 \x01(\v2\x16.agent.v1.EventPayloadH\x00R\x05event\x12.\n" +
 	"\x05error\x18\x06 \x01(\v2\x16.agent.v1.ErrorPayloadH\x00R\x05error\x127\n" +
-	"\bcomplete\x18\a \x01(\v2\x19.agent.v1.CompletePayloadH\x00R\bcomplete\x12*\n" +
+	"\bcomplete\x18\a \x01(\v2\x19.agent.v1.CompletePayloadH\x00R\bcomplete\x127\n" +
+	"\bartifact\x18\t \x01(\v2\x19.agent.v1.ArtifactPayloadH\x00R\bartifact\x12*\n" +
 	"\x05state\x18\b \x01(\x0e2\x14.agent.v1.AgentStateR\x05stateB\t\n" +
 	"\apayload\"L\n" +
 	"\fEventPayload\x12\x1d\n" +
@@ -923,8 +1135,13 @@ const file_agent_v1_agent_proto_rawDesc = "" +
 	"\amessage\x18\x02 \x01(\tR\amessage\x12\x14\n" +
 	"\x05fatal\x18\x03 \x01(\bR\x05fatal\"+\n" +
 	"\x0fCompletePayload\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\"\x12\n" +
-	"\x10GetStatusRequest\"\x83\x02\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"X\n" +
+	"\x0fArtifactPayload\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"media_type\x18\x02 \x01(\tR\tmediaType\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\"\x12\n" +
+	"\x10GetStatusRequest\"\xae\x02\n" +
 	"\x11GetStatusResponse\x12\x19\n" +
 	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12\x1d\n" +
 	"\n" +
@@ -934,7 +1151,19 @@ const file_agent_v1_agent_proto_rawDesc = "" +
 	"latest_seq\x18\x04 \x01(\x03R\tlatestSeq\x12#\n" +
 	"\rcurrent_model\x18\x05 \x01(\tR\fcurrentModel\x12'\n" +
 	"\x0fpermission_mode\x18\x06 \x01(\tR\x0epermissionMode\x12\x1b\n" +
-	"\tuptime_ms\x18\a \x01(\x03R\buptimeMs\"-\n" +
+	"\tuptime_ms\x18\a \x01(\x03R\buptimeMs\x12)\n" +
+	"\x10protocol_version\x18\b \x01(\x05R\x0fprotocolVersion\"`\n" +
+	"\x0eCatchUpRequest\x12\x19\n" +
+	"\bfrom_seq\x18\x01 \x01(\x04R\afromSeq\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x03 \x01(\tR\trequestId\"\x88\x01\n" +
+	"\x0fCatchUpResponse\x125\n" +
+	"\tresponses\x18\x01 \x03(\v2\x17.agent.v1.AgentResponseR\tresponses\x12\x1d\n" +
+	"\n" +
+	"latest_seq\x18\x02 \x01(\x04R\tlatestSeq\x12\x1f\n" +
+	"\vin_progress\x18\x03 \x01(\bR\n" +
+	"inProgress\"-\n" +
 	"\x0fShutdownRequest\x12\x1a\n" +
 	"\bgraceful\x18\x01 \x01(\bR\bgraceful\",\n" +
 	"\x10ShutdownResponse\x12\x18\n" +
@@ -944,11 +1173,12 @@ const file_agent_v1_agent_proto_rawDesc = "" +
 	"\x17AGENT_STATE_UNSPECIFIED\x10\x00\x12\x14\n" +
 	"\x10AGENT_STATE_IDLE\x10\x01\x12\x1a\n" +
 	"\x16AGENT_STATE_PROCESSING\x10\x02\x12\x15\n" +
-	"\x11AGENT_STATE_ERROR\x10\x032\xd7\x01\n" +
+	"\x11AGENT_STATE_ERROR\x10\x032\x97\x02\n" +
 	"\fAgentService\x12>\n" +
 	"\aConnect\x12\x16.agent.v1.AgentRequest\x1a\x17.agent.v1.AgentResponse(\x010\x01\x12D\n" +
 	"\tGetStatus\x12\x1a.agent.v1.GetStatusRequest\x1a\x1b.agent.v1.GetStatusResponse\x12A\n" +
-	"\bShutdown\x12\x19.agent.v1.ShutdownRequest\x1a\x1a.agent.v1.ShutdownResponseB0Z.github.com/forge/platform/gen/agent/v1;agentv1b\x06proto3"
+	"\bShutdown\x12\x19.agent.v1.ShutdownRequest\x1a\x1a.agent.v1.ShutdownResponse\x12>\n" +
+	"\aCatchUp\x12\x18.agent.v1.CatchUpRequest\x1a\x19.agent.v1.CatchUpResponseB0Z.github.com/forge/platform/gen/agent/v1;agentv1b\x06proto3"
 
 var (
 	file_agent_v1_agent_proto_rawDescOnce sync.Once
@@ -963,7 +1193,7 @@ func file_agent_v1_agent_proto_rawDescGZIP() []byte {
 }
 
 var file_agent_v1_agent_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_agent_v1_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_agent_v1_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_agent_v1_agent_proto_goTypes = []any{
 	(AgentState)(0),                  // 0: agent.v1.AgentState
 	(*AgentRequest)(nil),             // 1: agent.v1.AgentRequest
@@ -975,10 +1205,13 @@ var file_agent_v1_agent_proto_goTypes = []any{
 	(*EventPayload)(nil),             // 7: agent.v1.EventPayload
 	(*ErrorPayload)(nil),             // 8: agent.v1.ErrorPayload
 	(*CompletePayload)(nil),          // 9: agent.v1.CompletePayload
-	(*GetStatusRequest)(nil),         // 10: agent.v1.GetStatusRequest
-	(*GetStatusResponse)(nil),        // 11: agent.v1.GetStatusResponse
-	(*ShutdownRequest)(nil),          // 12: agent.v1.ShutdownRequest
-	(*ShutdownResponse)(nil),         // 13: agent.v1.ShutdownResponse
+	(*ArtifactPayload)(nil),          // 10: agent.v1.ArtifactPayload
+	(*GetStatusRequest)(nil),         // 11: agent.v1.GetStatusRequest
+	(*GetStatusResponse)(nil),        // 12: agent.v1.GetStatusResponse
+	(*CatchUpRequest)(nil),           // 13: agent.v1.CatchUpRequest
+	(*CatchUpResponse)(nil),          // 14: agent.v1.CatchUpResponse
+	(*ShutdownRequest)(nil),          // 15: agent.v1.ShutdownRequest
+	(*ShutdownResponse)(nil),         // 16: agent.v1.ShutdownResponse
 }
 var file_agent_v1_agent_proto_depIdxs = []int32{
 	2,  // 0: agent.v1.AgentRequest.send_message:type_name -> agent.v1.SendMessageRequest
@@ -988,19 +1221,23 @@ var file_agent_v1_agent_proto_depIdxs = []int32{
 	7,  // 4: agent.v1.AgentResponse.event:type_name -> agent.v1.EventPayload
 	8,  // 5: agent.v1.AgentResponse.error:type_name -> agent.v1.ErrorPayload
 	9,  // 6: agent.v1.AgentResponse.complete:type_name -> agent.v1.CompletePayload
-	0,  // 7: agent.v1.AgentResponse.state:type_name -> agent.v1.AgentState
-	0,  // 8: agent.v1.GetStatusResponse.state:type_name -> agent.v1.AgentState
-	1,  // 9: agent.v1.AgentService.Connect:input_type -> agent.v1.AgentRequest
-	10, // 10: agent.v1.AgentService.GetStatus:input_type -> agent.v1.GetStatusRequest
-	12, // 11: agent.v1.AgentService.Shutdown:input_type -> agent.v1.ShutdownRequest
-	6,  // 12: agent.v1.AgentService.Connect:output_type -> agent.v1.AgentResponse
-	11, // 13: agent.v1.AgentService.GetStatus:output_type -> agent.v1.GetStatusResponse
-	13, // 14: agent.v1.AgentService.Shutdown:output_type -> agent.v1.ShutdownResponse
-	12, // [12:15] is the sub-list for method output_type
-	9,  // [9:12] is the sub-list for method input_type
-	9,  // [9:9] is the sub-list for extension type_name
-	9,  // [9:9] is the sub-list for extension extendee
-	0,  // [0:9] is the sub-list for field type_name
+	10, // 7: agent.v1.AgentResponse.artifact:type_name -> agent.v1.ArtifactPayload
+	0,  // 8: agent.v1.AgentResponse.state:type_name -> agent.v1.AgentState
+	0,  // 9: agent.v1.GetStatusResponse.state:type_name -> agent.v1.AgentState
+	6,  // 10: agent.v1.CatchUpResponse.responses:type_name -> agent.v1.AgentResponse
+	1,  // 11: agent.v1.AgentService.Connect:input_type -> agent.v1.AgentRequest
+	11, // 12: agent.v1.AgentService.GetStatus:input_type -> agent.v1.GetStatusRequest
+	15, // 13: agent.v1.AgentService.Shutdown:input_type -> agent.v1.ShutdownRequest
+	13, // 14: agent.v1.AgentService.CatchUp:input_type -> agent.v1.CatchUpRequest
+	6,  // 15: agent.v1.AgentService.Connect:output_type -> agent.v1.AgentResponse
+	12, // 16: agent.v1.AgentService.GetStatus:output_type -> agent.v1.GetStatusResponse
+	16, // 17: agent.v1.AgentService.Shutdown:output_type -> agent.v1.ShutdownResponse
+	14, // 18: agent.v1.AgentService.CatchUp:output_type -> agent.v1.CatchUpResponse
+	15, // [15:19] is the sub-list for method output_type
+	11, // [11:15] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
 }
 
 func init() { file_agent_v1_agent_proto_init() }
@@ -1018,6 +1255,7 @@ func file_agent_v1_agent_proto_init() {
 		(*AgentResponse_Event)(nil),
 		(*AgentResponse_Error)(nil),
 		(*AgentResponse_Complete)(nil),
+		(*AgentResponse_Artifact)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -1025,7 +1263,7 @@ func file_agent_v1_agent_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_agent_v1_agent_proto_rawDesc), len(file_agent_v1_agent_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   13,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
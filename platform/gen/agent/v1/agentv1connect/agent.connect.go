@@ -39,6 +39,8 @@ const (
 	AgentServiceGetStatusProcedure = "/agent.v1.AgentService/GetStatus"
 	// AgentServiceShutdownProcedure is the fully-qualified name of the AgentService's Shutdown RPC.
 	AgentServiceShutdownProcedure = "/agent.v1.AgentService/Shutdown"
+	// AgentServiceCatchUpProcedure is the fully-qualified name of the AgentService's CatchUp RPC.
+	AgentServiceCatchUpProcedure = "/agent.v1.AgentService/CatchUp"
 )
 
 // AgentServiceClient is a client for the agent.v1.AgentService service.
@@ -50,6 +52,10 @@ type AgentServiceClient interface {
 	GetStatus(context.Context, *connect.Request[v1.GetStatusRequest]) (*connect.Response[v1.GetStatusResponse], error)
 	// Shutdown gracefully terminates the agent.
 	Shutdown(context.Context, *connect.Request[v1.ShutdownRequest]) (*connect.Response[v1.ShutdownResponse], error)
+	// CatchUp returns responses the agent already sent, so a client whose stream dropped
+	// can pick up where it left off. Agents keep working on a request when its stream
+	// drops, and keep a bounded history of their responses.
+	CatchUp(context.Context, *connect.Request[v1.CatchUpRequest]) (*connect.Response[v1.CatchUpResponse], error)
 }
 
 // NewAgentServiceClient constructs a client for the agent.v1.AgentService service. By default, it
@@ -81,6 +87,12 @@ func NewAgentServiceClient(httpClient connect.HTTPClient, baseURL string, opts .
 			connect.WithSchema(agentServiceMethods.ByName("Shutdown")),
 			connect.WithClientOptions(opts...),
 		),
+		catchUp: connect.NewClient[v1.CatchUpRequest, v1.CatchUpResponse](
+			httpClient,
+			baseURL+AgentServiceCatchUpProcedure,
+			connect.WithSchema(agentServiceMethods.ByName("CatchUp")),
+			connect.WithClientOptions(opts...),
+		),
 	}
 }
 
@@ -89,6 +101,7 @@ type agentServiceClient struct {
 	connect   *connect.Client[v1.AgentRequest, v1.AgentResponse]
 	getStatus *connect.Client[v1.GetStatusRequest, v1.GetStatusResponse]
 	shutdown  *connect.Client[v1.ShutdownRequest, v1.ShutdownResponse]
+	catchUp   *connect.Client[v1.CatchUpRequest, v1.CatchUpResponse]
 }
 
 // Connect calls agent.v1.AgentService.Connect.
@@ -106,6 +119,11 @@ func (c *agentServiceClient) Shutdown(ctx context.Context, req *connect.Request[
 	return c.shutdown.CallUnary(ctx, req)
 }
 
+// CatchUp calls agent.v1.AgentService.CatchUp.
+func (c *agentServiceClient) CatchUp(ctx context.Context, req *connect.Request[v1.CatchUpRequest]) (*connect.Response[v1.CatchUpResponse], error) {
+	return c.catchUp.CallUnary(ctx, req)
+}
+
 // AgentServiceHandler is an implementation of the agent.v1.AgentService service.
 type AgentServiceHandler interface {
 	// Connect establishes a bidirectional stream for real-time communication.
@@ -115,6 +133,10 @@ type AgentServiceHandler interface {
 	GetStatus(context.Context, *connect.Request[v1.GetStatusRequest]) (*connect.Response[v1.GetStatusResponse], error)
 	// Shutdown gracefully terminates the agent.
 	Shutdown(context.Context, *connect.Request[v1.ShutdownRequest]) (*connect.Response[v1.ShutdownResponse], error)
+	// CatchUp returns responses the agent already sent, so a client whose stream dropped
+	// can pick up where it left off. Agents keep working on a request when its stream
+	// drops, and keep a bounded history of their responses.
+	CatchUp(context.Context, *connect.Request[v1.CatchUpRequest]) (*connect.Response[v1.CatchUpResponse], error)
 }
 
 // NewAgentServiceHandler builds an HTTP handler from the service implementation. It returns the
@@ -142,6 +164,12 @@ func NewAgentServiceHandler(svc AgentServiceHandler, opts ...connect.HandlerOpti
 		connect.WithSchema(agentServiceMethods.ByName("Shutdown")),
 		connect.WithHandlerOptions(opts...),
 	)
+	agentServiceCatchUpHandler := connect.NewUnaryHandler(
+		AgentServiceCatchUpProcedure,
+		svc.CatchUp,
+		connect.WithSchema(agentServiceMethods.ByName("CatchUp")),
+		connect.WithHandlerOptions(opts...),
+	)
 	return "/agent.v1.AgentService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case AgentServiceConnectProcedure:
@@ -150,6 +178,8 @@ func NewAgentServiceHandler(svc AgentServiceHandler, opts ...connect.HandlerOpti
 			agentServiceGetStatusHandler.ServeHTTP(w, r)
 		case AgentServiceShutdownProcedure:
 			agentServiceShutdownHandler.ServeHTTP(w, r)
+		case AgentServiceCatchUpProcedure:
+			agentServiceCatchUpHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -170,3 +200,7 @@ func (UnimplementedAgentServiceHandler) GetStatus(context.Context, *connect.Requ
 func (UnimplementedAgentServiceHandler) Shutdown(context.Context, *connect.Request[v1.ShutdownRequest]) (*connect.Response[v1.ShutdownResponse], error) {
 	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("agent.v1.AgentService.Shutdown is not implemented"))
 }
+
+func (UnimplementedAgentServiceHandler) CatchUp(context.Context, *connect.Request[v1.CatchUpRequest]) (*connect.Response[v1.CatchUpResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("agent.v1.AgentService.CatchUp is not implemented"))
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+)
+
+// authAgentService reports the Authorization header of each call it gets
+type authAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	headers chan string
+}
+
+func (s *authAgentService) GetStatus(
+	ctx context.Context,
+	req *connect.Request[agentv1.GetStatusRequest],
+) (*connect.Response[agentv1.GetStatusResponse], error) {
+	s.headers <- req.Header().Get("Authorization")
+	return connect.NewResponse(&agentv1.GetStatusResponse{}), nil
+}
+
+func (s *authAgentService) Shutdown(
+	ctx context.Context,
+	req *connect.Request[agentv1.ShutdownRequest],
+) (*connect.Response[agentv1.ShutdownResponse], error) {
+	s.headers <- req.Header().Get("Authorization")
+	return connect.NewResponse(&agentv1.ShutdownResponse{Success: true}), nil
+}
+
+func (s *authAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	s.headers <- stream.RequestHeader().Get("Authorization")
+	return nil
+}
+
+// callAll makes a GetStatus, Shutdown and Connect call with client
+func callAll(t *testing.T, client agentv1connect.AgentServiceClient) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{})); err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if _, err := client.Shutdown(ctx, connect.NewRequest(&agentv1.ShutdownRequest{})); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	stream := client.Connect(ctx)
+	if err := stream.Send(&agentv1.AgentRequest{RequestId: "req-1"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	_ = stream.CloseRequest()
+	_, _ = stream.Receive()
+}
+
+func TestNewClientWithOptions_SendsAuthToken(t *testing.T) {
+	svc := &authAgentService{headers: make(chan string, 3)}
+	mux := http.NewServeMux()
+	mux.Handle(agentv1connect.NewAgentServiceHandler(svc))
+	server := newH2CServer(mux)
+	defer server.Close()
+
+	callAll(t, NewClientWithOptions(server.URL, ClientOptions{AuthToken: "tok-123"}))
+	for _, call := range []string{"GetStatus", "Shutdown", "Connect"} {
+		if got := <-svc.headers; got != "Bearer tok-123" {
+			t.Errorf("%s: expected the bearer token, got %q", call, got)
+		}
+	}
+
+	// Without a token no Authorization header is sent
+	callAll(t, NewClient(server.URL))
+	for _, call := range []string{"GetStatus", "Shutdown", "Connect"} {
+		if got := <-svc.headers; got != "" {
+			t.Errorf("%s: expected no Authorization header, got %q", call, got)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	token := Token("secret", "user1", "agent1")
+	if len(token) != 64 || token != Token("secret", "user1", "agent1") {
+		t.Errorf("expected a stable hex token, got %q", token)
+	}
+	for _, other := range []string{
+		Token("other", "user1", "agent1"),
+		Token("secret", "user1", "agent2"),
+		Token("secret", "user1a", "gent1"),
+	} {
+		if other == token {
+			t.Errorf("expected tokens of other secrets and agents to differ, got %q twice", token)
+		}
+	}
+}
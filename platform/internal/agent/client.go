@@ -7,8 +7,11 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"connectrpc.com/connect"
 	"golang.org/x/net/http2"
+
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/logger"
 )
 
 // http2Client is an HTTP client configured for HTTP/2 cleartext (h2c).
@@ -31,24 +34,74 @@ var http2Client = &http.Client{
 	Timeout: time.Duration(0),
 }
 
+// ClientOptions configure a client made with NewClientWithOptions
+type ClientOptions struct {
+	// HTTPClient makes the RPCs; nil uses a shared HTTP/2 cleartext (h2c) client
+	HTTPClient *http.Client
+	// AuthToken, if set, is sent as a bearer token on every RPC (see Token)
+	AuthToken string
+	// Interceptors run after the ones sending the request ID and token
+	Interceptors []connect.Interceptor
+}
+
 // NewClient creates a new AgentService client for the given base URL.
 // The baseURL should be in the format "http://<ip>:8080".
 // Clients are stateless and safe to create per-request.
 //
-// Uses HTTP/2 cleartext (h2c) for bidirectional streaming support.
-func NewClient(baseURL string) agentv1connect.AgentServiceClient {
-	return agentv1connect.NewAgentServiceClient(
-		http2Client,
-		baseURL,
-	)
+// Uses HTTP/2 cleartext (h2c) for bidirectional streaming support. interceptors run
+// after the one sending the request ID.
+func NewClient(baseURL string, interceptors ...connect.Interceptor) agentv1connect.AgentServiceClient {
+	return NewClientWithOptions(baseURL, ClientOptions{Interceptors: interceptors})
 }
 
 // NewClientWithHTTPClient creates a new AgentService client with a custom HTTP client.
 // Useful for testing or when custom transport configuration is needed
 // (e.g., timeouts, TLS settings, tracing middleware).
 func NewClientWithHTTPClient(baseURL string, httpClient *http.Client) agentv1connect.AgentServiceClient {
+	return NewClientWithOptions(baseURL, ClientOptions{HTTPClient: httpClient})
+}
+
+// NewClientWithOptions creates a new AgentService client for the given base URL as
+// configured by opts
+func NewClientWithOptions(baseURL string, opts ClientOptions) agentv1connect.AgentServiceClient {
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = http2Client
+	}
+	interceptors := []connect.Interceptor{requestIDInterceptor{}}
+	if opts.AuthToken != "" {
+		interceptors = append(interceptors, authInterceptor{token: opts.AuthToken})
+	}
 	return agentv1connect.NewAgentServiceClient(
 		httpClient,
 		baseURL,
+		connect.WithInterceptors(append(interceptors, opts.Interceptors...)...),
 	)
 }
+
+// requestIDInterceptor sends the ID of the API request an RPC is made for in the
+// X-Request-Id header, so agent logs can be correlated with the platform's
+type requestIDInterceptor struct{}
+
+func (requestIDInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if id := logger.RequestIDFrom(ctx); id != "" && req.Spec().IsClient {
+			req.Header().Set(logger.HeaderRequestID, id)
+		}
+		return next(ctx, req)
+	}
+}
+
+func (requestIDInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		conn := next(ctx, spec)
+		if id := logger.RequestIDFrom(ctx); id != "" {
+			conn.RequestHeader().Set(logger.HeaderRequestID, id)
+		}
+		return conn
+	}
+}
+
+func (requestIDInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
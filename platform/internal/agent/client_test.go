@@ -8,8 +8,13 @@ import (
 	"time"
 
 	"connectrpc.com/connect"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
 	agentv1 "github.com/forge/platform/gen/agent/v1"
 	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/logger"
 )
 
 func TestNewClient_ReturnsValidClient(t *testing.T) {
@@ -62,7 +67,7 @@ func TestNewClient_CanCallGetStatus(t *testing.T) {
 	path, handler := agentv1connect.NewAgentServiceHandler(&mockAgentService{})
 	mux.Handle(path, handler)
 
-	server := httptest.NewServer(mux)
+	server := newH2CServer(mux)
 	defer server.Close()
 
 	// Create client and make a call
@@ -90,7 +95,7 @@ func TestNewClient_CanCallShutdown(t *testing.T) {
 	path, handler := agentv1connect.NewAgentServiceHandler(&mockAgentService{})
 	mux.Handle(path, handler)
 
-	server := httptest.NewServer(mux)
+	server := newH2CServer(mux)
 	defer server.Close()
 
 	client := NewClient(server.URL)
@@ -110,6 +115,42 @@ func TestNewClient_CanCallShutdown(t *testing.T) {
 	}
 }
 
+func TestNewClient_SendsRequestID(t *testing.T) {
+	svc := &requestIDAgentService{headers: make(chan string, 2)}
+	mux := http.NewServeMux()
+	path, handler := agentv1connect.NewAgentServiceHandler(svc)
+	mux.Handle(path, handler)
+	server := newH2CServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = logger.WithRequest(ctx, zap.NewNop(), "req-abc")
+
+	if _, err := client.GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{})); err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	stream := client.Connect(ctx)
+	if err := stream.Send(&agentv1.AgentRequest{RequestId: "req-1"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	_ = stream.CloseRequest()
+	_, _ = stream.Receive()
+
+	for _, call := range []string{"GetStatus", "Connect"} {
+		if got := <-svc.headers; got != "req-abc" {
+			t.Errorf("%s: expected request ID req-abc, got %q", call, got)
+		}
+	}
+}
+
+// newH2CServer starts a test server speaking HTTP/2 cleartext, matching the
+// transport NewClient uses to reach agent pods.
+func newH2CServer(h http.Handler) *httptest.Server {
+	return httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+}
+
 // mockAgentService implements agentv1connect.AgentServiceHandler for testing
 type mockAgentService struct {
 	agentv1connect.UnimplementedAgentServiceHandler
@@ -137,3 +178,25 @@ func (m *mockAgentService) Shutdown(
 		Success: true,
 	}), nil
 }
+
+// requestIDAgentService reports the request ID header of each call it gets
+type requestIDAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	headers chan string
+}
+
+func (s *requestIDAgentService) GetStatus(
+	ctx context.Context,
+	req *connect.Request[agentv1.GetStatusRequest],
+) (*connect.Response[agentv1.GetStatusResponse], error) {
+	s.headers <- req.Header().Get(logger.HeaderRequestID)
+	return connect.NewResponse(&agentv1.GetStatusResponse{}), nil
+}
+
+func (s *requestIDAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	s.headers <- stream.RequestHeader().Get(logger.HeaderRequestID)
+	return nil
+}
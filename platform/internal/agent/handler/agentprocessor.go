@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"connectrpc.com/connect"
+	corev1 "k8s.io/api/core/v1"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// AgentProcessor is the agent processor the handler and the stream proxy serve their routes
+// with, implemented by *processor.Processor. Handler tests stub it to exercise the HTTP
+// concerns on their own.
+type AgentProcessor interface {
+	// Agents
+	CreateAgentWithOptions(ctx context.Context, userID string, opts k8s.PodOptions) (*k8s.PodID, error)
+	CreateAgentAsync(ctx context.Context, userID string, opts k8s.PodOptions) (*k8s.PodID, error)
+	CreateTimeout() time.Duration
+	ListAgentsFiltered(ctx context.Context, userID string, filter processor.AgentFilter) ([]k8s.PodID, error)
+	GetAgent(ctx context.Context, userID, agentID string) (*corev1.Pod, error)
+	CachedStatus(ctx context.Context, userID, agentID string) (*agentv1.GetStatusResponse, error)
+	ConfigureAgent(ctx context.Context, userID, agentID string, settings processor.AgentSettings) error
+	DeleteAgent(ctx context.Context, userID, agentID string, graceful bool) error
+	DeleteAllAgents(ctx context.Context, userID string, graceful bool) ([]processor.AgentDeletion, error)
+	CheckQuarantine(ctx context.Context, userID, agentID string) error
+	Unquarantine(ctx context.Context, userID, agentID string) (bool, error)
+
+	// Agent streams
+	ConnectToAgent(ctx context.Context, userID, agentID string) (*connect.BidiStreamForClient[agentv1.AgentRequest, agentv1.AgentResponse], error)
+	SubscribeAgentDeleted(userID, agentID string) (<-chan struct{}, func())
+	TrackUsage(ctx context.Context, userID, agentID, requestID string) (*webhook.UsageTracker, func())
+
+	// Messages
+	RunJob(parent context.Context, requestID, userID, agentID string, fn func(ctx context.Context)) error
+	CancelJob(ctx context.Context, requestID, userID string) error
+	QueueMessage(userID, agentID, requestID string, wait bool) error
+	DequeueMessage(userID, agentID, requestID string)
+	QueuePosition(requestID string) (position int, activeRequestID string, ok bool)
+	SendMessageWithWebhook(ctx context.Context, userID, agentID, requestID, content string, webhookCfg webhook.Config) error
+	StreamMessage(ctx context.Context, userID, agentID, requestID, content string, includeThinking bool, emit func(webhook.Payload) error) error
+	InterruptWithWebhook(ctx context.Context, userID, agentID, requestID, targetRequestID string, webhookCfg webhook.Config) error
+	CreateBatch(ctx context.Context, batchID, userID, agentID string, stepCount int, continueOnError bool) error
+	SendBatchWithWebhook(ctx context.Context, userID, agentID, batchID string, messages []string, continueOnError bool, webhookCfg webhook.Config) error
+	CatchUp(ctx context.Context, userID, agentID string, fromSeq uint64, limit int32) (*agentv1.CatchUpResponse, error)
+	StoredMessages(ctx context.Context, userID, agentID string, fromSeq uint64, limit int32) ([]webhook.Payload, uint64, error)
+
+	// Webhooks
+	ValidateWebhookURL(ctx context.Context, rawURL string) error
+	CheckWebhookProbe(webhookCfg webhook.Config) ([]string, error)
+
+	// Requests
+	GetRequest(ctx context.Context, userID, requestID string) (*sqlc.WebhookDelivery, error)
+	GetRequestEndpoints(ctx context.Context, requestID string) ([]*sqlc.WebhookDelivery, error)
+	RequestAttemptSummary(ctx context.Context, requestID string) (count, lastStatus int32, err error)
+	ListRequests(ctx context.Context, userID, agentID string, limit int32) ([]*sqlc.WebhookDelivery, error)
+	GetBatch(ctx context.Context, userID, batchID string) (*sqlc.RequestBatch, []*sqlc.WebhookDelivery, error)
+	PrepareRedelivery(ctx context.Context, userID, requestID string, fromSeq int64, override webhook.Config, force bool) (webhook.Config, []webhook.Payload, int, error)
+	RedeliverEvents(ctx context.Context, requestID string, webhookCfg webhook.Config, payloads []webhook.Payload) error
+	RecordReceipt(ctx context.Context, userID, requestID string, ranges []webhook.SeqRange) ([]webhook.SeqRange, error)
+	AcknowledgedRanges(ctx context.Context, requestID string) ([]webhook.SeqRange, error)
+	ListArtifacts(ctx context.Context, requestID, userID string) ([]webhook.Artifact, error)
+	GetArtifact(ctx context.Context, requestID, name, userID string) (*sqlc.RequestArtifact, error)
+
+	// Workspace files and exec
+	FileMaxBytes() int64
+	FileTransferTimeout() time.Duration
+	UploadFiles(ctx context.Context, userID, agentID, dir string, tarball io.Reader) error
+	DownloadFiles(ctx context.Context, userID, agentID, path string, w io.Writer) error
+	ExecInAgent(ctx context.Context, userID, agentID string, command []string, timeout time.Duration) (*k8s.ExecResult, error)
+
+	// Usage and budgets
+	Usage(ctx context.Context, userID string, from, to time.Time, groupBy string) ([]webhook.UsageEntry, error)
+	CheckBudget(ctx context.Context, userID string) (*processor.BudgetStatus, error)
+	BudgetStatus(ctx context.Context, userID string) (*processor.BudgetStatus, error)
+	SetBudget(ctx context.Context, budget webhook.Budget) (*webhook.Budget, error)
+	DeleteBudget(ctx context.Context, userID string) error
+}
+
+var _ AgentProcessor = (*processor.Processor)(nil)
@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// stubProcessor is an AgentProcessor serving agents from memory and recording the
+// messages, interrupts and deletions it is asked for, without running any of them. Its
+// agents are never reachable over a stream. Unimplemented methods panic via the nil
+// embedded interface.
+type stubProcessor struct {
+	AgentProcessor
+
+	mu         sync.Mutex
+	pods       []*corev1.Pod
+	quarantine error
+	messages   []stubCall
+	interrupts []stubCall
+	deleted    []stubCall
+}
+
+// stubCall is a message, interrupt or deletion the stub was asked for
+type stubCall struct {
+	userID, agentID, requestID string
+	content                    string
+	targetRequestID            string
+	graceful                   bool
+	webhookCfg                 webhook.Config
+}
+
+// newStubProcessor returns a stub serving the given agent pods
+func newStubProcessor(pods ...*corev1.Pod) *stubProcessor {
+	return &stubProcessor{pods: pods}
+}
+
+// pod returns the pod of a user's agent, if the stub has it
+func (s *stubProcessor) pod(userID, agentID string) (*corev1.Pod, bool) {
+	for _, pod := range s.pods {
+		if pod.Labels["user-id"] == userID && pod.Labels["agent-id"] == agentID {
+			return pod, true
+		}
+	}
+	return nil, false
+}
+
+// notFound is the error the processor returns for agents without a pod
+func notFound(userID, agentID string) error {
+	return apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, k8s.NewPodID(userID, agentID).Name())
+}
+
+func (s *stubProcessor) ListAgentsFiltered(_ context.Context, userID string, _ processor.AgentFilter) ([]k8s.PodID, error) {
+	var ids []k8s.PodID
+	for _, pod := range s.pods {
+		if pod.Labels["user-id"] == userID {
+			ids = append(ids, *k8s.NewPodID(userID, pod.Labels["agent-id"]))
+		}
+	}
+	return ids, nil
+}
+
+func (s *stubProcessor) GetAgent(_ context.Context, userID, agentID string) (*corev1.Pod, error) {
+	if pod, ok := s.pod(userID, agentID); ok {
+		return pod, nil
+	}
+	return nil, notFound(userID, agentID)
+}
+
+func (s *stubProcessor) DeleteAgent(_ context.Context, userID, agentID string, graceful bool) error {
+	if _, ok := s.pod(userID, agentID); !ok {
+		return notFound(userID, agentID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = append(s.deleted, stubCall{userID: userID, agentID: agentID, graceful: graceful})
+	return nil
+}
+
+func (s *stubProcessor) CheckQuarantine(context.Context, string, string) error { return s.quarantine }
+
+func (s *stubProcessor) SubscribeAgentDeleted(string, string) (<-chan struct{}, func()) {
+	return make(chan struct{}), func() {}
+}
+
+func (s *stubProcessor) ConnectToAgent(context.Context, string, string) (*connect.BidiStreamForClient[agentv1.AgentRequest, agentv1.AgentResponse], error) {
+	return nil, stderrors.New("agent unreachable")
+}
+
+func (s *stubProcessor) CheckBudget(context.Context, string) (*processor.BudgetStatus, error) {
+	return nil, nil
+}
+
+func (s *stubProcessor) ValidateWebhookURL(context.Context, string) error { return nil }
+
+func (s *stubProcessor) CheckWebhookProbe(webhook.Config) ([]string, error) { return nil, nil }
+
+func (s *stubProcessor) QueueMessage(string, string, string, bool) error { return nil }
+
+// RunJob runs the job before returning, so tests see what it did once the request is served
+func (s *stubProcessor) RunJob(_ context.Context, _, _, _ string, fn func(ctx context.Context)) error {
+	fn(context.Background())
+	return nil
+}
+
+func (s *stubProcessor) SendMessageWithWebhook(_ context.Context, userID, agentID, requestID, content string, webhookCfg webhook.Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, stubCall{userID: userID, agentID: agentID, requestID: requestID, content: content, webhookCfg: webhookCfg})
+	return nil
+}
+
+func (s *stubProcessor) InterruptWithWebhook(_ context.Context, userID, agentID, requestID, targetRequestID string, webhookCfg webhook.Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interrupts = append(s.interrupts, stubCall{userID: userID, agentID: agentID, requestID: requestID, targetRequestID: targetRequestID, webhookCfg: webhookCfg})
+	return nil
+}
+
+func TestSendMessage_AcceptedWithStub(t *testing.T) {
+	stub := newStubProcessor()
+	e := setupTestHandler(t, stub)
+
+	rec := postJSON(e, "/api/v1/users/user1/agents/agent1/messages",
+		`{"content": "hi", "request_id": "req_1", "webhook_url": "https://hooks.example.com/customer", "webhook_secret": "s3cret"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	var resp SendMessageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.RequestID != "req_1" || resp.AgentID != "agent1" || resp.Status != "processing" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if len(stub.messages) != 1 {
+		t.Fatalf("expected one message sent, got %d", len(stub.messages))
+	}
+	msg := stub.messages[0]
+	if msg.userID != "user1" || msg.agentID != "agent1" || msg.requestID != "req_1" || msg.content != "hi" ||
+		msg.webhookCfg.URL != "https://hooks.example.com/customer" || msg.webhookCfg.Secret != "s3cret" {
+		t.Errorf("unexpected message sent: %+v", msg)
+	}
+}
+
+func TestSendMessage_ValidatesBodyWithStub(t *testing.T) {
+	stub := newStubProcessor()
+	e := setupTestHandler(t, stub)
+
+	for _, body := range []string{
+		`not json`,
+		`{"webhook_url": "https://hooks.example.com/customer"}`,
+		`{"content": "hi"}`,
+	} {
+		if rec := postJSON(e, "/api/v1/users/user1/agents/agent1/messages", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+	if len(stub.messages) != 0 {
+		t.Errorf("expected no message sent, got %d", len(stub.messages))
+	}
+}
+
+func TestSendMessage_BodyBoundariesWithStub(t *testing.T) {
+	stub := newStubProcessor()
+	e := setupTestHandler(t, stub)
+	path := "/api/v1/users/user1/agents/agent1/messages"
+	body := func(content string) string {
+		return `{"content": "` + content + `", "webhook_url": "https://hooks.example.com/customer"}`
+	}
+
+	if rec := postJSON(e, path, body(strings.Repeat("a", 512<<10))); rec.Code != http.StatusAccepted {
+		t.Errorf("expected content at the limit to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := postJSON(e, path, body(strings.Repeat("a", 512<<10+1)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for content over the limit, got %d", http.StatusBadRequest, rec.Code)
+	}
+	var resp struct {
+		Error   string                    `json:"error"`
+		Details handler.ValidationDetails `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != handler.ErrorCodeValidationFailed || len(resp.Details.Fields) != 1 || resp.Details.Fields[0].Field != "content" {
+		t.Errorf("expected content to fail validation, got %s", rec.Body.String())
+	}
+
+	if rec := postJSON(e, path, body(strings.Repeat("a", 1<<20))); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d for a body over 1MB, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	if len(stub.messages) != 1 {
+		t.Errorf("expected only the message at the limit sent, got %d", len(stub.messages))
+	}
+}
+
+func TestInterrupt_AcceptedWithStub(t *testing.T) {
+	stub := newStubProcessor()
+	e := setupTestHandler(t, stub)
+
+	rec := postJSON(e, "/api/v1/users/user1/agents/agent1/interrupt",
+		`{"request_id": "req_int", "target_request_id": "req_1", "webhook_url": "https://hooks.example.com/customer"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	var resp SendMessageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.RequestID != "req_int" || resp.AgentID != "agent1" || resp.Status != "interrupting" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(stub.interrupts) != 1 || stub.interrupts[0].targetRequestID != "req_1" || stub.interrupts[0].requestID != "req_int" {
+		t.Errorf("expected req_1 to be interrupted by req_int, got %+v", stub.interrupts)
+	}
+
+	if rec := postJSON(e, "/api/v1/users/user1/agents/agent1/interrupt", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without a webhook_url, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestProxy_RejectsUnavailableAgentsWithStub(t *testing.T) {
+	quarantined := newStubProcessor(createReadyPod("user1", "agent1"))
+	quarantined.quarantine = stderrors.New("agent agent1 is quarantined")
+	tests := []struct {
+		name string
+		stub *stubProcessor
+		want int
+	}{
+		{"missing agent", newStubProcessor(), http.StatusNotFound},
+		{"pending pod", newStubProcessor(createPendingPod("user1", "agent1")), http.StatusConflict},
+		{"quarantined", quarantined, http.StatusLocked},
+		{"unreachable agent", newStubProcessor(createReadyPod("user1", "agent1")), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, resp, err := dialStream(t, startProxyServer(t, tt.stub), "/api/v1/users/user1/agents/agent1/stream")
+			if err == nil {
+				t.Fatal("expected the upgrade to be refused")
+			}
+			if resp == nil || resp.StatusCode != tt.want {
+				t.Fatalf("expected %d, got %v", tt.want, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	stderrors "errors"
+	"mime"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// ListArtifactsResponse is the response for listing a request's artifacts
+type ListArtifactsResponse struct {
+	RequestID string             `json:"request_id"`
+	Artifacts []webhook.Artifact `json:"artifacts"` // without content; download each from its url
+	Total     int                `json:"total"`
+}
+
+// ListArtifacts handles GET /api/v1/requests/:request_id/artifacts?user_id=xxx
+func (h *Handler) ListArtifacts(c echo.Context) error {
+	requestID := c.Param("request_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	artifacts, err := h.processor.ListArtifacts(c.Request().Context(), requestID, userID)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ListArtifactsResponse{
+		RequestID: requestID,
+		Artifacts: artifacts,
+		Total:     len(artifacts),
+	})
+}
+
+// DownloadArtifact handles GET /api/v1/requests/:request_id/artifacts/:name?user_id=xxx
+func (h *Handler) DownloadArtifact(c echo.Context) error {
+	requestID := c.Param("request_id")
+	name := c.Param("name")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	artifact, err := h.processor.GetArtifact(c.Request().Context(), requestID, name, userID)
+	if err != nil {
+		// Artifacts of other users' requests are reported as missing
+		if stderrors.Is(err, webhook.ErrArtifactNotFound) {
+			return errors.NotFound("artifact " + name + " not found for request " + requestID)
+		}
+		return errors.InternalError(err.Error())
+	}
+
+	// Agent output is served as a download and never rendered by the browser
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": artifact.Name}))
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	return c.Blob(http.StatusOK, artifact.MediaType, artifact.Data)
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// fakeArtifactQuerier adds in-memory artifact storage to fakeBatchQuerier
+type fakeArtifactQuerier struct {
+	*fakeBatchQuerier
+	artifacts map[string]*sqlc.RequestArtifact // by request_id + "/" + name
+}
+
+func newFakeArtifactQuerier() *fakeArtifactQuerier {
+	return &fakeArtifactQuerier{
+		fakeBatchQuerier: newFakeBatchQuerier(),
+		artifacts:        make(map[string]*sqlc.RequestArtifact),
+	}
+}
+
+func (f *fakeArtifactQuerier) UpsertRequestArtifact(_ context.Context, arg *sqlc.UpsertRequestArtifactParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.artifacts[arg.RequestID+"/"+arg.Name] = &sqlc.RequestArtifact{
+		RequestID: arg.RequestID,
+		Name:      arg.Name,
+		UserID:    arg.UserID,
+		AgentID:   arg.AgentID,
+		MediaType: arg.MediaType,
+		SizeBytes: arg.SizeBytes,
+		Data:      arg.Data,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (f *fakeArtifactQuerier) ListRequestArtifacts(_ context.Context, arg *sqlc.ListRequestArtifactsParams) ([]*sqlc.ListRequestArtifactsRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows := []*sqlc.ListRequestArtifactsRow{}
+	for _, a := range f.artifacts {
+		if a.RequestID == arg.RequestID && a.UserID == arg.UserID {
+			rows = append(rows, &sqlc.ListRequestArtifactsRow{
+				RequestID: a.RequestID,
+				Name:      a.Name,
+				MediaType: a.MediaType,
+				SizeBytes: a.SizeBytes,
+			})
+		}
+	}
+	return rows, nil
+}
+
+func (f *fakeArtifactQuerier) GetRequestArtifact(_ context.Context, arg *sqlc.GetRequestArtifactParams) (*sqlc.RequestArtifact, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.artifacts[arg.RequestID+"/"+arg.Name]
+	if !ok || a.UserID != arg.UserID {
+		return nil, pgx.ErrNoRows
+	}
+	return a, nil
+}
+
+func artifactResponse(seq uint64, name, mediaType, data string) *agentv1.AgentResponse {
+	return &agentv1.AgentResponse{
+		Seq:   seq,
+		State: agentv1.AgentState_AGENT_STATE_PROCESSING,
+		Payload: &agentv1.AgentResponse_Artifact{
+			Artifact: &agentv1.ArtifactPayload{Name: name, MediaType: mediaType, Data: []byte(data)},
+		},
+	}
+}
+
+// artifactAgent produces a small and a large artifact before completing
+func artifactAgent() *scriptedAgentService {
+	return &scriptedAgentService{
+		responses: []*agentv1.AgentResponse{
+			eventResponse(1, "message.updated", `{}`),
+			artifactResponse(2, "summary.txt", "text/plain", "done"),
+			artifactResponse(3, "report.md", "text/markdown", "# A report longer than the inline limit"),
+			{
+				Seq:     4,
+				State:   agentv1.AgentState_AGENT_STATE_IDLE,
+				Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+			},
+		},
+	}
+}
+
+// setupArtifactTest routes agent1 of user1 to svc, inlining artifacts of up to 16 bytes
+func setupArtifactTest(t *testing.T, svc *scriptedAgentService) (*echo.Echo, *fakeArtifactQuerier) {
+	t.Helper()
+	querier := newFakeArtifactQuerier()
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{
+		ArtifactInlineMaxBytes: 16,
+		ArtifactMaxBytes:       1024,
+	}, zap.NewNop())
+	mgr := createNodePortManager(t, "user1", "agent1", startMockAgent(t, svc))
+	return setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop())), querier
+}
+
+// checkArtifactRefs verifies the final payload lists the small artifact inline and the large one by URL
+func checkArtifactRefs(t *testing.T, requestID string, artifacts []webhook.Artifact) {
+	t.Helper()
+	if len(artifacts) != 2 {
+		t.Fatalf("expected 2 artifacts on the final payload, got %+v", artifacts)
+	}
+	small, large := artifacts[0], artifacts[1]
+	if small.Name != "summary.txt" || string(small.Data) != "done" || small.Size != 4 {
+		t.Errorf("expected summary.txt inline, got %+v", small)
+	}
+	if large.Name != "report.md" || large.Data != nil || large.URL != "/api/v1/requests/"+requestID+"/artifacts/report.md" {
+		t.Errorf("expected report.md by URL only, got %+v", large)
+	}
+}
+
+func TestSendMessageSSE_FinalPayloadListsArtifacts(t *testing.T) {
+	e, _ := setupArtifactTest(t, artifactAgent())
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","request_id":"req_art"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// Artifacts are not streamed as events of their own
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 2 {
+		t.Fatalf("expected an event and the completion, got %d: %s", len(events), rec.Body.String())
+	}
+	final := events[1].payload
+	if !final.IsFinal || final.EventType != webhook.EventTypeComplete {
+		t.Fatalf("expected the final completion, got %+v", final)
+	}
+	checkArtifactRefs(t, "req_art", final.Artifacts)
+}
+
+func TestSendMessageWebhook_FinalPayloadListsArtifacts(t *testing.T) {
+	e, querier := setupArtifactTest(t, artifactAgent())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages?user_id=user1",
+		strings.NewReader(`{"content":"hi","request_id":"req_art","webhook_url":"https://hooks.example.com/a"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		payloads := querier.payloads()
+		if n := len(payloads); n > 0 && payloads[n-1].IsFinal {
+			if n != 2 {
+				t.Fatalf("expected an event and the completion to be enqueued, got %+v", payloads)
+			}
+			checkArtifactRefs(t, "req_art", payloads[n-1].Artifacts)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the final payload, got %+v", payloads)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestArtifactEndpoints_ListAndDownload(t *testing.T) {
+	e, _ := setupArtifactTest(t, artifactAgent())
+	if rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","request_id":"req_art"}`); rec.Code != http.StatusOK {
+		t.Fatalf("send failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_art/artifacts?user_id=user1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var list ListArtifactsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if list.Total != 2 || list.RequestID != "req_art" {
+		t.Errorf("expected 2 artifacts for req_art, got %+v", list)
+	}
+
+	// Large artifacts are downloaded in full
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_art/artifacts/report.md?user_id=user1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != "# A report longer than the inline limit" {
+		t.Errorf("unexpected content %q", rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/markdown" {
+		t.Errorf("expected content type text/markdown, got %s", ct)
+	}
+	if cd := rec.Header().Get(echo.HeaderContentDisposition); cd != `attachment; filename=report.md` {
+		t.Errorf("unexpected content disposition %q", cd)
+	}
+}
+
+func TestArtifactEndpoints_RequireOwner(t *testing.T) {
+	e, _ := setupArtifactTest(t, artifactAgent())
+	if rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","request_id":"req_art"}`); rec.Code != http.StatusOK {
+		t.Fatalf("send failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"list without user", "/api/v1/requests/req_art/artifacts", http.StatusBadRequest},
+		{"download without user", "/api/v1/requests/req_art/artifacts/report.md", http.StatusBadRequest},
+		{"download as another user", "/api/v1/requests/req_art/artifacts/report.md?user_id=user2", http.StatusNotFound},
+		{"download unknown artifact", "/api/v1/requests/req_art/artifacts/missing.txt?user_id=user1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Another user sees no artifacts for the request
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_art/artifacts?user_id=user2", nil))
+	var list ListArtifactsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || list.Total != 0 {
+		t.Errorf("expected no artifacts for another user, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stderrors "errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// RequestStatusSkipped is reported for batch steps that never ran because the batch stopped early
+const RequestStatusSkipped = "skipped"
+
+// BatchMessage is a single message of a batch
+type BatchMessage struct {
+	Content string `json:"content" validate:"required,max=524288"`
+}
+
+// SendBatchRequest is the request body for sending a batch of messages to an agent
+type SendBatchRequest struct {
+	Messages      []BatchMessage `json:"messages" validate:"required,min=1,max=20,dive"`
+	WebhookURL    string         `json:"webhook_url" validate:"required,url"`
+	WebhookSecret string         `json:"webhook_secret,omitempty"`
+
+	// WebhookSecondarySecret also signs deliveries while webhook_secret is rotated
+	WebhookSecondarySecret string `json:"webhook_secondary_secret,omitempty"`
+
+	// ContinueOnError runs the remaining messages after a failed one (default false)
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+
+	// IncludeThinking controls whether model reasoning is delivered (default true)
+	IncludeThinking *bool `json:"include_thinking,omitempty"`
+
+	// SchemaVersion pins the schema of the webhook payloads (default the current one)
+	SchemaVersion string `json:"schema_version,omitempty"`
+
+	// WebhookRateLimitRPS and WebhookRateBurst override the rate deliveries are paced at
+	WebhookRateLimitRPS float64 `json:"webhook_rate_limit_rps,omitempty" validate:"gte=0"`
+	WebhookRateBurst    int     `json:"webhook_rate_burst,omitempty" validate:"gte=0"`
+}
+
+// SendBatchResponse is the response for sending a batch of messages
+type SendBatchResponse struct {
+	BatchID    string   `json:"batch_id"`
+	AgentID    string   `json:"agent_id"`
+	Status     string   `json:"status"`
+	RequestIDs []string `json:"request_ids"` // one per message, in order
+
+	// Warnings lists webhook endpoints that failed a recent probe
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// BatchStepStatus is the state of one message of a batch
+type BatchStepStatus struct {
+	Step          int    `json:"step"`
+	RequestID     string `json:"request_id"`
+	Status        string `json:"status"` // request status, or "skipped"
+	LastSeq       int64  `json:"last_seq"`
+	LastEventType string `json:"last_event_type,omitempty"`
+	CompletedAt   string `json:"completed_at,omitempty"`
+}
+
+// BatchStatusResponse is the response for batch status lookups
+type BatchStatusResponse struct {
+	BatchID         string            `json:"batch_id"`
+	AgentID         string            `json:"agent_id"`
+	Status          string            `json:"status"` // "pending", "in_progress", "completed", "failed"
+	ContinueOnError bool              `json:"continue_on_error"`
+	StepCount       int               `json:"step_count"`
+	CurrentStep     int               `json:"current_step"`
+	FailedSteps     int               `json:"failed_steps"`
+	Steps           []BatchStepStatus `json:"steps"`
+	CreatedAt       string            `json:"created_at"`
+	UpdatedAt       string            `json:"updated_at"`
+	CompletedAt     string            `json:"completed_at,omitempty"`
+}
+
+// SendBatch handles POST /api/v1/agents/:agent_id/messages/batch
+//
+// Messages run one at a time, in order, and their events are delivered to a single webhook
+// tagged with batch_id and step. The batch stops at the first failed message unless
+// continue_on_error is set.
+func (h *Handler) SendBatch(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	var req SendBatchRequest
+	if err := handler.Bind(c, &req); err != nil {
+		return err
+	}
+	messages := make([]string, len(req.Messages))
+	for i, m := range req.Messages {
+		messages[i] = m.Content
+	}
+
+	if err := h.checkQuarantine(c, userID, agentID); err != nil {
+		return err
+	}
+	if err := h.checkBudget(c, userID); err != nil {
+		return err
+	}
+	if err := h.validateWebhookURL(c, req.WebhookURL); err != nil {
+		return err
+	}
+	if err := validateWebhookSecrets("webhook_secret", req.WebhookSecret, req.WebhookSecondarySecret); err != nil {
+		return err
+	}
+	if err := validateSchemaVersion(req.SchemaVersion); err != nil {
+		return err
+	}
+	webhookCfg := webhook.Config{
+		URL:             req.WebhookURL,
+		Secret:          req.WebhookSecret,
+		SecondarySecret: req.WebhookSecondarySecret,
+		OmitThinking:    req.IncludeThinking != nil && !*req.IncludeThinking,
+		SchemaVersion:   req.SchemaVersion,
+		RateLimitRPS:    req.WebhookRateLimitRPS,
+		RateBurst:       req.WebhookRateBurst,
+	}
+	warnings, err := h.checkWebhookProbe(webhookCfg)
+	if err != nil {
+		return err
+	}
+
+	batchID := generateBatchID()
+	if err := h.processor.CreateBatch(c.Request().Context(), batchID, userID, agentID, len(messages), req.ContinueOnError); err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	// Start async processing; the job outlives this request, which completes with 202
+	if err := h.processor.RunJob(c.Request().Context(), batchID, userID, agentID, func(ctx context.Context) {
+		_ = h.processor.SendBatchWithWebhook(ctx, userID, agentID, batchID, messages, req.ContinueOnError, webhookCfg)
+	}); err != nil {
+		return errors.ServiceUnavailable(err.Error())
+	}
+
+	requestIDs := make([]string, len(messages))
+	for i := range messages {
+		requestIDs[i] = processor.BatchStepRequestID(batchID, i)
+	}
+
+	return c.JSON(http.StatusAccepted, SendBatchResponse{
+		BatchID:    batchID,
+		AgentID:    agentID,
+		Status:     "processing",
+		RequestIDs: requestIDs,
+		Warnings:   warnings,
+	})
+}
+
+// GetBatch handles GET /api/v1/requests/batches/:batch_id?user_id=xxx
+//
+// Batches other users sent are reported as not found.
+func (h *Handler) GetBatch(c echo.Context) error {
+	batchID := c.Param("batch_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	batch, deliveries, err := h.processor.GetBatch(c.Request().Context(), userID, batchID)
+	if err != nil {
+		if stderrors.Is(err, webhook.ErrBatchNotFound) {
+			return errors.NotFound("batch " + batchID + " not found")
+		}
+		return errors.InternalError(err.Error())
+	}
+
+	return c.JSON(http.StatusOK, batchToStatus(batch, deliveries))
+}
+
+// batchToStatus converts a batch record and its step deliveries to the API representation.
+// Steps without a delivery record are pending, or skipped once the batch has finished.
+func batchToStatus(b *sqlc.RequestBatch, deliveries []*sqlc.WebhookDelivery) BatchStatusResponse {
+	resp := BatchStatusResponse{
+		BatchID:         b.BatchID,
+		AgentID:         b.AgentID,
+		Status:          b.Status,
+		ContinueOnError: b.ContinueOnError,
+		StepCount:       int(b.StepCount),
+		CurrentStep:     int(b.CurrentStep),
+		FailedSteps:     int(b.FailedSteps),
+		Steps:           make([]BatchStepStatus, b.StepCount),
+		CreatedAt:       b.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       b.UpdatedAt.Format(time.RFC3339),
+	}
+	if b.CompletedAt.Valid {
+		resp.CompletedAt = b.CompletedAt.Time.Format(time.RFC3339)
+	}
+
+	notStarted := RequestStatusPending
+	if b.Status == webhook.BatchStatusCompleted || b.Status == webhook.BatchStatusFailed {
+		notStarted = RequestStatusSkipped
+	}
+	for i := range resp.Steps {
+		resp.Steps[i] = BatchStepStatus{
+			Step:      i,
+			RequestID: processor.BatchStepRequestID(b.BatchID, i),
+			Status:    notStarted,
+		}
+	}
+
+	for _, d := range deliveries {
+		step := int(d.BatchStep.Int32)
+		if !d.BatchStep.Valid || step < 0 || step >= len(resp.Steps) {
+			continue
+		}
+		request := deliveryToRequestStatus(d)
+		resp.Steps[step] = BatchStepStatus{
+			Step:          step,
+			RequestID:     request.RequestID,
+			Status:        request.Status,
+			LastSeq:       request.LastSeq,
+			LastEventType: request.LastEventType,
+			CompletedAt:   request.CompletedAt,
+		}
+	}
+
+	return resp
+}
+
+func generateBatchID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "batch_" + hex.EncodeToString(b)
+}
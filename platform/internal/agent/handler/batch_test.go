@@ -0,0 +1,520 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// batchAgentService answers every request on a stream in turn: an event followed by a
+// completion, or an agent.error for messages with content "fail". It records when each
+// request arrived and when each final response was sent.
+type batchAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+
+	mu       sync.Mutex
+	connects int
+	requests []*agentv1.AgentRequest
+	arrived  []time.Time
+	finished []time.Time
+}
+
+func (s *batchAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	s.mu.Lock()
+	s.connects++
+	s.mu.Unlock()
+
+	seq := uint64(0)
+	for {
+		req, err := stream.Receive()
+		if err != nil {
+			// The client closed its side once the batch was done
+			return nil
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.arrived = append(s.arrived, time.Now())
+		s.mu.Unlock()
+
+		seq++
+		event := eventResponse(seq, "message.updated", `{}`)
+		event.RequestId = req.RequestId
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+
+		// Give an eager client time to send the next request before this one finishes
+		time.Sleep(20 * time.Millisecond)
+
+		seq++
+		final := &agentv1.AgentResponse{
+			RequestId: req.RequestId,
+			Seq:       seq,
+			State:     agentv1.AgentState_AGENT_STATE_IDLE,
+			Payload:   &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+		}
+		if req.GetSendMessage().GetContent() == "fail" {
+			final.Payload = &agentv1.AgentResponse_Error{
+				Error: &agentv1.ErrorPayload{Code: "MODEL_ERROR", Message: "boom"},
+			}
+		}
+		s.mu.Lock()
+		s.finished = append(s.finished, time.Now())
+		s.mu.Unlock()
+		if err := stream.Send(final); err != nil {
+			return err
+		}
+	}
+}
+
+// fakeBatchQuerier keeps deliveries, batches and enqueued webhook payloads in memory.
+// Unimplemented querier methods panic via the nil embedded interface.
+type fakeBatchQuerier struct {
+	sqlc.Querier
+	mu         sync.Mutex
+	batches    map[string]*sqlc.RequestBatch
+	deliveries map[string]*sqlc.WebhookDelivery
+	enqueued   []webhook.Payload
+	enqueuedTo []string // webhook URL of each enqueued payload
+	messages   []*sqlc.AgentMessage
+	budgets    map[string]*sqlc.UserBudget
+	spentUSD   float64 // returned as every user's spend
+}
+
+func newFakeBatchQuerier() *fakeBatchQuerier {
+	return &fakeBatchQuerier{
+		batches:    make(map[string]*sqlc.RequestBatch),
+		deliveries: make(map[string]*sqlc.WebhookDelivery),
+		budgets:    make(map[string]*sqlc.UserBudget),
+	}
+}
+
+func (f *fakeBatchQuerier) GetWebhookDeliveryAttemptSummary(context.Context, string) (*sqlc.GetWebhookDeliveryAttemptSummaryRow, error) {
+	return &sqlc.GetWebhookDeliveryAttemptSummaryRow{}, nil
+}
+
+func (f *fakeBatchQuerier) CreateRequestBatch(_ context.Context, arg *sqlc.CreateRequestBatchParams) (*sqlc.RequestBatch, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := &sqlc.RequestBatch{
+		BatchID:         arg.BatchID,
+		AgentID:         arg.AgentID,
+		StepCount:       arg.StepCount,
+		ContinueOnError: arg.ContinueOnError,
+		UserID:          arg.UserID,
+		Status:          webhook.BatchStatusPending,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	f.batches[arg.BatchID] = b
+	return b, nil
+}
+
+func (f *fakeBatchQuerier) GetRequestBatch(_ context.Context, batchID string) (*sqlc.RequestBatch, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.batches[batchID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	copied := *b
+	return &copied, nil
+}
+
+func (f *fakeBatchQuerier) UpdateRequestBatchProgress(_ context.Context, arg *sqlc.UpdateRequestBatchProgressParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.batches[arg.BatchID]
+	b.Status = arg.Status
+	b.CurrentStep = arg.CurrentStep
+	b.FailedSteps = arg.FailedSteps
+	if arg.Status == webhook.BatchStatusCompleted || arg.Status == webhook.BatchStatusFailed {
+		b.CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	return nil
+}
+
+func (f *fakeBatchQuerier) CreateWebhookDelivery(_ context.Context, arg *sqlc.CreateWebhookDeliveryParams) (*sqlc.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := &sqlc.WebhookDelivery{
+		RequestID:  arg.RequestID,
+		AgentID:    arg.AgentID,
+		UserID:     arg.UserID,
+		WebhookUrl: arg.WebhookUrl,
+		Status:     webhook.DeliveryStatusPending,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	f.deliveries[arg.RequestID] = d
+	return d, nil
+}
+
+func (f *fakeBatchQuerier) GetWebhookDelivery(_ context.Context, requestID string) (*sqlc.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.deliveries[requestID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	copied := *d
+	return &copied, nil
+}
+
+func (f *fakeBatchQuerier) ListWebhookDeliveryEndpoints(ctx context.Context, requestID string) ([]*sqlc.WebhookDelivery, error) {
+	d, err := f.GetWebhookDelivery(ctx, requestID)
+	if err != nil {
+		return []*sqlc.WebhookDelivery{}, nil
+	}
+	return []*sqlc.WebhookDelivery{d}, nil
+}
+
+func (f *fakeBatchQuerier) SetDeliveryBatchStep(_ context.Context, arg *sqlc.SetDeliveryBatchStepParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := f.deliveries[arg.RequestID]
+	d.BatchID = sql.NullString{String: arg.BatchID, Valid: true}
+	d.BatchStep = pgtype.Int4{Int32: arg.BatchStep, Valid: true}
+	return nil
+}
+
+func (f *fakeBatchQuerier) ListBatchDeliveries(_ context.Context, batchID sql.NullString) ([]*sqlc.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := []*sqlc.WebhookDelivery{}
+	for _, d := range f.deliveries {
+		if d.BatchID == batchID {
+			copied := *d
+			items = append(items, &copied)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeBatchQuerier) setStatus(requestID, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d, ok := f.deliveries[requestID]; ok {
+		d.Status = status
+	}
+}
+
+func (f *fakeBatchQuerier) MarkDeliveryCompleted(_ context.Context, requestID string) error {
+	f.setStatus(requestID, webhook.DeliveryStatusCompleted)
+	return nil
+}
+
+func (f *fakeBatchQuerier) MarkDeliveryFailed(_ context.Context, requestID string) error {
+	f.setStatus(requestID, webhook.DeliveryStatusFailed)
+	return nil
+}
+
+func (f *fakeBatchQuerier) UpdateDeliverySeq(_ context.Context, arg *sqlc.UpdateDeliverySeqParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d, ok := f.deliveries[arg.RequestID]; ok {
+		d.Seq = arg.Seq
+		d.LastEventType = arg.LastEventType
+		if d.Status == webhook.DeliveryStatusPending {
+			d.Status = webhook.DeliveryStatusDelivering
+		}
+	}
+	return nil
+}
+
+func (f *fakeBatchQuerier) EnqueueOutboxEvent(_ context.Context, arg *sqlc.EnqueueOutboxEventParams) error {
+	var payload webhook.Payload
+	if err := json.Unmarshal(arg.Payload, &payload); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enqueued = append(f.enqueued, payload)
+	f.enqueuedTo = append(f.enqueuedTo, arg.WebhookUrl)
+	return nil
+}
+
+func (f *fakeBatchQuerier) UpsertWebhookEvent(context.Context, *sqlc.UpsertWebhookEventParams) error {
+	return nil
+}
+
+func (f *fakeBatchQuerier) ListRequestArtifacts(context.Context, *sqlc.ListRequestArtifactsParams) ([]*sqlc.ListRequestArtifactsRow, error) {
+	return nil, nil
+}
+
+func (f *fakeBatchQuerier) ListDeliveryReceipts(context.Context, string) ([]*sqlc.DeliveryReceipt, error) {
+	return nil, nil
+}
+
+func (f *fakeBatchQuerier) payloads() []webhook.Payload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]webhook.Payload(nil), f.enqueued...)
+}
+
+// setupBatchTest routes agent1 to svc and records deliveries in the returned fake
+func setupBatchTest(t *testing.T, svc agentv1connect.AgentServiceHandler) (*echo.Echo, *fakeBatchQuerier) {
+	t.Helper()
+	return setupBatchTestWithConfig(t, svc, &config.Config{})
+}
+
+func setupBatchTestWithConfig(t *testing.T, svc agentv1connect.AgentServiceHandler, cfg *config.Config) (*echo.Echo, *fakeBatchQuerier) {
+	t.Helper()
+	querier := newFakeBatchQuerier()
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, cfg, zap.NewNop())
+	mgr := createNodePortManager(t, "user1", "agent1", startMockAgent(t, svc))
+	return setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop())), querier
+}
+
+// postBatch sends a batch and returns the accepted response
+func postBatch(t *testing.T, e *echo.Echo, body string) SendBatchResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages/batch?user_id=user1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	var resp SendBatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return resp
+}
+
+// waitForBatch polls the batch status endpoint until the batch has finished
+func waitForBatch(t *testing.T, e *echo.Echo, batchID string) BatchStatusResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/batches/"+batchID+"?user_id=user1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var resp BatchStatusResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.Status == webhook.BatchStatusCompleted || resp.Status == webhook.BatchStatusFailed {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for batch, last status %+v", resp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func stepStatuses(resp BatchStatusResponse) []string {
+	statuses := make([]string, len(resp.Steps))
+	for i, step := range resp.Steps {
+		statuses[i] = step.Status
+	}
+	return statuses
+}
+
+func TestSendBatch_RunsStepsInOrderOverOneConnection(t *testing.T) {
+	svc := &batchAgentService{}
+	e, _ := setupBatchTest(t, svc)
+
+	accepted := postBatch(t, e, `{
+		"messages": [{"content":"one"},{"content":"two"},{"content":"three"}],
+		"webhook_url": "https://hooks.example.com/forge"
+	}`)
+	if len(accepted.RequestIDs) != 3 {
+		t.Fatalf("expected 3 request ids, got %v", accepted.RequestIDs)
+	}
+
+	batch := waitForBatch(t, e, accepted.BatchID)
+	if batch.Status != webhook.BatchStatusCompleted || batch.FailedSteps != 0 {
+		t.Errorf("expected completed batch with no failures, got %+v", batch)
+	}
+	if got := strings.Join(stepStatuses(batch), ","); got != "completed,completed,completed" {
+		t.Errorf("unexpected step statuses %s", got)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if svc.connects != 1 {
+		t.Errorf("expected a single connection, got %d", svc.connects)
+	}
+	if len(svc.requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(svc.requests))
+	}
+	for i, want := range []string{"one", "two", "three"} {
+		req := svc.requests[i]
+		if req.GetSendMessage().GetContent() != want || req.RequestId != accepted.RequestIDs[i] {
+			t.Errorf("request %d: expected %s/%s, got %s/%s", i, accepted.RequestIDs[i], want, req.RequestId, req.GetSendMessage().GetContent())
+		}
+		if i > 0 && svc.arrived[i].Before(svc.finished[i-1]) {
+			t.Errorf("request %d arrived before request %d finished", i, i-1)
+		}
+	}
+}
+
+func TestSendBatch_PayloadsCarryBatchAndStep(t *testing.T) {
+	e, querier := setupBatchTest(t, &batchAgentService{})
+
+	accepted := postBatch(t, e, `{
+		"messages": [{"content":"one"},{"content":"two"}],
+		"webhook_url": "https://hooks.example.com/forge"
+	}`)
+	waitForBatch(t, e, accepted.BatchID)
+
+	payloads := querier.payloads()
+	if len(payloads) != 4 {
+		t.Fatalf("expected 4 payloads, got %d", len(payloads))
+	}
+	for i, payload := range payloads {
+		step := i / 2
+		if payload.BatchID != accepted.BatchID || payload.Step == nil || *payload.Step != step {
+			t.Errorf("payload %d: expected batch %s step %d, got %s %v", i, accepted.BatchID, step, payload.BatchID, payload.Step)
+		}
+		if payload.RequestID != accepted.RequestIDs[step] {
+			t.Errorf("payload %d: expected request %s, got %s", i, accepted.RequestIDs[step], payload.RequestID)
+		}
+	}
+
+	// Step membership is visible on the individual request too
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/"+accepted.RequestIDs[1]+"?user_id=user1", nil))
+	var request RequestStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &request); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if request.BatchID != accepted.BatchID || request.Step == nil || *request.Step != 1 {
+		t.Errorf("expected request in batch %s at step 1, got %+v", accepted.BatchID, request)
+	}
+}
+
+func TestSendBatch_StopsOnFirstError(t *testing.T) {
+	svc := &batchAgentService{}
+	e, _ := setupBatchTest(t, svc)
+
+	accepted := postBatch(t, e, `{
+		"messages": [{"content":"one"},{"content":"fail"},{"content":"three"}],
+		"webhook_url": "https://hooks.example.com/forge"
+	}`)
+
+	batch := waitForBatch(t, e, accepted.BatchID)
+	if batch.Status != webhook.BatchStatusFailed || batch.FailedSteps != 1 || batch.CurrentStep != 1 {
+		t.Errorf("expected batch failed at step 1, got %+v", batch)
+	}
+	if got := strings.Join(stepStatuses(batch), ","); got != "completed,failed,skipped" {
+		t.Errorf("unexpected step statuses %s", got)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.requests) != 2 {
+		t.Errorf("expected the batch to stop after 2 requests, got %d", len(svc.requests))
+	}
+}
+
+func TestSendBatch_ContinueOnError(t *testing.T) {
+	svc := &batchAgentService{}
+	e, _ := setupBatchTest(t, svc)
+
+	accepted := postBatch(t, e, `{
+		"messages": [{"content":"fail"},{"content":"two"},{"content":"three"}],
+		"webhook_url": "https://hooks.example.com/forge",
+		"continue_on_error": true
+	}`)
+
+	batch := waitForBatch(t, e, accepted.BatchID)
+	if batch.Status != webhook.BatchStatusFailed || batch.FailedSteps != 1 || batch.CurrentStep != 2 {
+		t.Errorf("expected all steps run with 1 failure, got %+v", batch)
+	}
+	if got := strings.Join(stepStatuses(batch), ","); got != "failed,completed,completed" {
+		t.Errorf("unexpected step statuses %s", got)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.requests) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(svc.requests))
+	}
+}
+
+func TestSendBatch_Validation(t *testing.T) {
+	e, _ := setupBatchTest(t, &batchAgentService{})
+
+	// Batches take at most 20 messages
+	tooMany := make([]string, 21)
+	for i := range tooMany {
+		tooMany[i] = `{"content":"hi"}`
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no messages", `{"messages":[],"webhook_url":"https://hooks.example.com"}`},
+		{"too many messages", `{"messages":[` + strings.Join(tooMany, ",") + `],"webhook_url":"https://hooks.example.com"}`},
+		{"empty content", `{"messages":[{"content":"hi"},{"content":""}],"webhook_url":"https://hooks.example.com"}`},
+		{"missing webhook", `{"messages":[{"content":"hi"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages/batch?user_id=user1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetBatch_NotFound(t *testing.T) {
+	e, _ := setupBatchTest(t, &batchAgentService{})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/batches/batch_missing?user_id=user1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestGetBatch_RefusesOtherUsers(t *testing.T) {
+	e, _ := setupBatchTest(t, &batchAgentService{})
+	accepted := postBatch(t, e, `{"messages": [{"content":"one"}], "webhook_url": "https://hooks.example.com/forge"}`)
+	waitForBatch(t, e, accepted.BatchID)
+
+	for _, path := range []string{
+		"/api/v1/requests/batches/" + accepted.BatchID + "?user_id=user2",
+		"/api/v1/requests/" + accepted.RequestIDs[0] + "?user_id=user2",
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusNotFound, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// SetBudgetRequest is the request body for PUT /api/v1/admin/users/:user_id/budget
+type SetBudgetRequest struct {
+	LimitUSD float64 `json:"limit_usd" validate:"gte=0"`
+	// Period is "daily" or "monthly" (default), starting at midnight UTC
+	Period string `json:"period,omitempty" validate:"omitempty,oneof=daily monthly"`
+	// Soft only warns about messages once the budget is spent instead of refusing them
+	Soft bool `json:"soft,omitempty"`
+}
+
+// GetBudget handles GET /api/v1/admin/users/:user_id/budget, returning the user's budget
+// and their spend in its current period
+func (h *Handler) GetBudget(c echo.Context) error {
+	status, err := h.processor.BudgetStatus(c.Request().Context(), c.Param("user_id"))
+	if err != nil {
+		return budgetError(err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// SetBudget handles PUT /api/v1/admin/users/:user_id/budget, replacing the user's budget
+func (h *Handler) SetBudget(c echo.Context) error {
+	var req SetBudgetRequest
+	if err := handler.Bind(c, &req); err != nil {
+		return err
+	}
+	if req.Period == "" {
+		req.Period = webhook.BudgetPeriodMonthly
+	}
+
+	ctx := c.Request().Context()
+	if _, err := h.processor.SetBudget(ctx, webhook.Budget{
+		UserID:   c.Param("user_id"),
+		LimitUSD: req.LimitUSD,
+		Period:   req.Period,
+		Soft:     req.Soft,
+	}); err != nil {
+		return budgetError(err)
+	}
+	status, err := h.processor.BudgetStatus(ctx, c.Param("user_id"))
+	if err != nil {
+		return budgetError(err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// DeleteBudget handles DELETE /api/v1/admin/users/:user_id/budget
+func (h *Handler) DeleteBudget(c echo.Context) error {
+	if err := h.processor.DeleteBudget(c.Request().Context(), c.Param("user_id")); err != nil {
+		return budgetError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// budgetError maps an error of the budget endpoints to its response
+func budgetError(err error) error {
+	switch {
+	case stderrors.Is(err, webhook.ErrBudgetNotFound):
+		return errors.NotFound("user has no budget")
+	case stderrors.Is(err, webhook.ErrInvalidBudget):
+		return errors.BadRequest(err.Error())
+	case stderrors.Is(err, processor.ErrNoBudgetStorage):
+		return errors.ServiceUnavailable("budgets are not available without a database")
+	default:
+		return errors.InternalError(err.Error())
+	}
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+func (f *fakeBatchQuerier) UpsertUserBudget(_ context.Context, arg *sqlc.UpsertUserBudgetParams) (*sqlc.UserBudget, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := &sqlc.UserBudget{UserID: arg.UserID, LimitUsd: arg.LimitUsd, Period: arg.Period, Soft: arg.Soft}
+	f.budgets[arg.UserID] = b
+	return b, nil
+}
+
+func (f *fakeBatchQuerier) GetUserBudget(_ context.Context, userID string) (*sqlc.UserBudget, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.budgets[userID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return b, nil
+}
+
+func (f *fakeBatchQuerier) DeleteUserBudget(_ context.Context, userID string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.budgets[userID]; !ok {
+		return 0, nil
+	}
+	delete(f.budgets, userID)
+	return 1, nil
+}
+
+func (f *fakeBatchQuerier) SumUserCostSince(context.Context, *sqlc.SumUserCostSinceParams) (float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.spentUSD, nil
+}
+
+// doBudget sends a request to the admin budget endpoint of user1
+func doBudget(e *echo.Echo, method, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1/admin/users/user1/budget", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBudgetEndpoints(t *testing.T) {
+	e, querier := setupBatchTest(t, &scriptedAgentService{})
+	querier.spentUSD = 2.5
+
+	if rec := doBudget(e, http.MethodGet, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a budget, got %d", rec.Code)
+	}
+	for _, body := range []string{`{"limit_usd": -1}`, `{"limit_usd": 10, "period": "weekly"}`} {
+		if rec := doBudget(e, http.MethodPut, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+
+	rec := doBudget(e, http.MethodPut, `{"limit_usd": 10}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doBudget(e, http.MethodGet, "")
+	var status processor.BudgetStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the budget status, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if status.Budget.LimitUSD != 10 || status.Budget.Period != "monthly" || status.SpentUSD != 2.5 || status.Budget.Soft {
+		t.Errorf("unexpected budget status: %+v", status)
+	}
+
+	if rec := doBudget(e, http.MethodDelete, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	if rec := doBudget(e, http.MethodDelete, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 once deleted, got %d", rec.Code)
+	}
+}
+
+func TestSendMessage_BudgetExceeded(t *testing.T) {
+	complete := &agentv1.AgentResponse{Seq: 1, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}}
+	e, querier := setupBatchTest(t, &scriptedAgentService{responses: []*agentv1.AgentResponse{complete}})
+	querier.spentUSD = 12
+	if rec := doBudget(e, http.MethodPut, `{"limit_usd": 10}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	const body = `{"content": "hi", "webhook_url": "https://hooks.example.com/customer"}`
+	rec := postJSON(e, "/api/v1/users/user1/agents/agent1/messages", body)
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Error   string                 `json:"error"`
+		Details processor.BudgetStatus `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error != processor.ErrorCodeBudgetExceeded ||
+		resp.Details.SpentUSD != 12 || resp.Details.Budget.LimitUSD != 10 {
+		t.Errorf("expected %s with the spend, got %s", processor.ErrorCodeBudgetExceeded, rec.Body.String())
+	}
+	batch := `{"messages": [{"content": "hi"}], "webhook_url": "https://hooks.example.com/customer"}`
+	if rec := postJSON(e, "/api/v1/users/user1/agents/agent1/messages/batch", batch); rec.Code != http.StatusPaymentRequired {
+		t.Errorf("batch: expected 402, got %d", rec.Code)
+	}
+
+	// A soft budget lets the message through with a warning
+	if rec := doBudget(e, http.MethodPut, `{"limit_usd": 10, "soft": true}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	rec = postJSON(e, "/api/v1/users/user1/agents/agent1/messages", body)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if warning := rec.Header().Get(BudgetWarningHeader); warning != "spent $12.00 of the monthly budget of $10.00" {
+		t.Errorf("expected a budget warning, got %q", warning)
+	}
+}
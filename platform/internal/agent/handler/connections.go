@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/forge/platform/internal/k8s"
+)
+
+// Limits the ConnectionRegistry enforces
+const (
+	// ConnectionLimitUser caps the streams one user has open
+	ConnectionLimitUser = "user"
+	// ConnectionLimitAgent caps the streams open to one agent
+	ConnectionLimitAgent = "agent"
+)
+
+// ErrRegistryClosed is returned for connections registered once the server is shutting down
+var ErrRegistryClosed = stderrors.New("server is shutting down")
+
+// ConnectionLimitError is returned for a connection over one of the registry's limits
+type ConnectionLimitError struct {
+	// Limit is the limit the connection ran into: ConnectionLimitUser or ConnectionLimitAgent
+	Limit string `json:"limit"`
+	// Count is how many streams are open under the limit
+	Count int `json:"count"`
+	// Max is the limit
+	Max int `json:"max"`
+}
+
+func (e *ConnectionLimitError) Error() string {
+	return fmt.Sprintf("%d of at most %d streams per %s already open", e.Count, e.Max, e.Limit)
+}
+
+// StreamConnection is an agent stream client registered with a ConnectionRegistry
+type StreamConnection struct {
+	ID          string
+	UserID      string
+	AgentID     string
+	ConnectedAt time.Time
+
+	toAgent  atomic.Int64
+	toClient atomic.Int64
+	// shutdown is closed when the server shuts down and the client should be let go
+	shutdown chan struct{}
+}
+
+// ConnectionInfo describes an open agent stream
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	AgentID     string    `json:"agent_id"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// MessagesToAgent counts the client's frames sent on to the agent
+	MessagesToAgent int64 `json:"messages_to_agent"`
+	// MessagesToClient counts the agent's responses queued to the client
+	MessagesToClient int64 `json:"messages_to_client"`
+}
+
+// info returns what the connection looks like now
+func (c *StreamConnection) info() ConnectionInfo {
+	return ConnectionInfo{
+		ID:               c.ID,
+		UserID:           c.UserID,
+		AgentID:          c.AgentID,
+		ConnectedAt:      c.ConnectedAt,
+		MessagesToAgent:  c.toAgent.Load(),
+		MessagesToClient: c.toClient.Load(),
+	}
+}
+
+// ConnectionRegistry tracks the open agent streams of this replica, and caps how many one
+// user and one agent may have open (0 disables either cap). Every registered connection
+// must be released once it closes.
+type ConnectionRegistry struct {
+	maxPerUser  int
+	maxPerAgent int
+
+	mu       sync.Mutex
+	conns    map[string]*StreamConnection
+	perUser  map[string]int
+	perAgent map[k8s.PodID]int
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewConnectionRegistry creates a registry allowing maxPerUser streams per user and
+// maxPerAgent per agent
+func NewConnectionRegistry(maxPerUser, maxPerAgent int) *ConnectionRegistry {
+	return &ConnectionRegistry{
+		maxPerUser:  maxPerUser,
+		maxPerAgent: maxPerAgent,
+		conns:       make(map[string]*StreamConnection),
+		perUser:     make(map[string]int),
+		perAgent:    make(map[k8s.PodID]int),
+	}
+}
+
+// Register registers a stream of userID to agentID. It returns a *ConnectionLimitError if
+// the stream would go over a limit, and ErrRegistryClosed once the registry shut down.
+func (r *ConnectionRegistry) Register(userID, agentID string) (*StreamConnection, error) {
+	podID := *k8s.NewPodID(userID, agentID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	if n := r.perUser[userID]; r.maxPerUser > 0 && n >= r.maxPerUser {
+		return nil, &ConnectionLimitError{Limit: ConnectionLimitUser, Count: n, Max: r.maxPerUser}
+	}
+	if n := r.perAgent[podID]; r.maxPerAgent > 0 && n >= r.maxPerAgent {
+		return nil, &ConnectionLimitError{Limit: ConnectionLimitAgent, Count: n, Max: r.maxPerAgent}
+	}
+
+	conn := &StreamConnection{
+		ID:          generateConnectionID(),
+		UserID:      userID,
+		AgentID:     agentID,
+		ConnectedAt: time.Now(),
+		shutdown:    make(chan struct{}),
+	}
+	r.conns[conn.ID] = conn
+	r.perUser[userID]++
+	r.perAgent[podID]++
+	r.wg.Add(1)
+	return conn, nil
+}
+
+// Release unregisters a connection. Releasing one more than once is a no-op.
+func (r *ConnectionRegistry) Release(conn *StreamConnection) {
+	podID := *k8s.NewPodID(conn.UserID, conn.AgentID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.conns[conn.ID]; !ok {
+		return
+	}
+	delete(r.conns, conn.ID)
+	if r.perUser[conn.UserID]--; r.perUser[conn.UserID] == 0 {
+		delete(r.perUser, conn.UserID)
+	}
+	if r.perAgent[podID]--; r.perAgent[podID] == 0 {
+		delete(r.perAgent, podID)
+	}
+	r.wg.Done()
+}
+
+// List returns the open connections, oldest first
+func (r *ConnectionRegistry) List() []ConnectionInfo {
+	r.mu.Lock()
+	infos := make([]ConnectionInfo, 0, len(r.conns))
+	for _, conn := range r.conns {
+		infos = append(infos, conn.info())
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(infos, func(a, b ConnectionInfo) int {
+		if c := a.ConnectedAt.Compare(b.ConnectedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return infos
+}
+
+// Len returns how many connections are open
+func (r *ConnectionRegistry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Shutdown refuses new connections, tells the open ones to close and waits until they are
+// released or ctx is done
+func (r *ConnectionRegistry) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		for _, conn := range r.conns {
+			close(conn.shutdown)
+		}
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func generateConnectionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "conn_" + hex.EncodeToString(b)
+}
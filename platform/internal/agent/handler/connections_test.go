@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectionRegistry_EnforcesLimits(t *testing.T) {
+	r := NewConnectionRegistry(3, 2)
+	register := func(userID, agentID string) (*StreamConnection, error) {
+		t.Helper()
+		return r.Register(userID, agentID)
+	}
+
+	first, _ := register("user1", "agent1")
+	if _, err := register("user1", "agent1"); err != nil {
+		t.Fatalf("expected a second stream to agent1, got %v", err)
+	}
+	var limitErr *ConnectionLimitError
+	if _, err := register("user1", "agent1"); !stderrors.As(err, &limitErr) || limitErr.Limit != ConnectionLimitAgent || limitErr.Count != 2 {
+		t.Errorf("expected the agent limit at 2 streams, got %v", err)
+	}
+	if _, err := register("user1", "agent2"); err != nil {
+		t.Fatalf("expected a stream to agent2, got %v", err)
+	}
+	if _, err := register("user1", "agent3"); !stderrors.As(err, &limitErr) || limitErr.Limit != ConnectionLimitUser || limitErr.Count != 3 {
+		t.Errorf("expected the user limit at 3 streams, got %v", err)
+	}
+	if _, err := register("user2", "agent3"); err != nil {
+		t.Errorf("expected other users unaffected, got %v", err)
+	}
+
+	r.Release(first)
+	r.Release(first)
+	if _, err := register("user1", "agent3"); err != nil {
+		t.Errorf("expected a released stream to make room, got %v", err)
+	}
+	if n := r.Len(); n != 4 {
+		t.Errorf("expected 4 streams open, got %d", n)
+	}
+}
+
+func TestConnectionRegistry_ParallelRegisterAndRelease(t *testing.T) {
+	const maxPerAgent = 3
+	r := NewConnectionRegistry(0, maxPerAgent)
+
+	var open [4]atomic.Int32
+	var wg sync.WaitGroup
+	for worker := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			agent := worker % len(open)
+			for range 200 {
+				conn, err := r.Register("user1", []string{"a0", "a1", "a2", "a3"}[agent])
+				if err != nil {
+					continue
+				}
+				if n := open[agent].Add(1); n > maxPerAgent {
+					t.Errorf("%d streams open to one agent, limit is %d", n, maxPerAgent)
+				}
+				open[agent].Add(-1)
+				r.Release(conn)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := r.Len(); n != 0 {
+		t.Errorf("expected no streams left registered, got %d", n)
+	}
+	if len(r.perUser) != 0 || len(r.perAgent) != 0 {
+		t.Errorf("expected no counts left, got %v and %v", r.perUser, r.perAgent)
+	}
+}
+
+// waitForConnections waits until x has n streams registered
+func waitForConnections(t *testing.T, x *Proxy, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for x.connections.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d streams registered, got %d", n, x.connections.Len())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProxy_LimitsConnections(t *testing.T) {
+	var x *Proxy
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})), func(p *Proxy) {
+		p.connections = NewConnectionRegistry(0, 2)
+		x = p
+	})
+	path := "/api/v1/users/user1/agents/agent1/stream"
+
+	var conns []*websocket.Conn
+	for range 2 {
+		conn, _, err := dialStream(t, server, path)
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	sendMessage(t, conns[0], "req-1")
+	readPayload(t, conns[0])
+	readPayload(t, conns[0])
+
+	_, resp, err := dialStream(t, server, path)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the limit, got %v", err)
+	}
+	var body struct {
+		Error   string               `json:"error"`
+		Details ConnectionLimitError `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Details != (ConnectionLimitError{Limit: ConnectionLimitAgent, Count: 2, Max: 2}) {
+		t.Errorf("expected the agent limit's count in the body, got %+v (%v)", body, err)
+	}
+
+	listResp, err := http.Get(server.URL + "/api/v1/admin/streams")
+	if err != nil {
+		t.Fatalf("failed to list streams: %v", err)
+	}
+	defer listResp.Body.Close()
+	var list ListConnectionsResponse
+	if err := json.NewDecoder(listResp.Body).Decode(&list); err != nil || list.Total != 2 {
+		t.Fatalf("expected 2 streams listed, got %+v (%v)", list, err)
+	}
+	if c := list.Connections[0]; c.UserID != "user1" || c.AgentID != "agent1" || c.ConnectedAt.IsZero() ||
+		c.MessagesToAgent != 1 || c.MessagesToClient != 2 {
+		t.Errorf("expected the first stream with its message counts, got %+v", c)
+	}
+
+	conns[0].Close()
+	waitForConnections(t, x, 1)
+	if _, _, err := dialStream(t, server, path); err != nil {
+		t.Errorf("expected a stream once one closed, got %v", err)
+	}
+}
+
+func TestProxy_ReleasesConnectionsOnEveryClose(t *testing.T) {
+	t.Run("client close and server shutdown", func(t *testing.T) {
+		var x *Proxy
+		server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})), func(p *Proxy) {
+			x = p
+		})
+
+		// Half the clients close their stream, the rest stay open until the shutdown
+		var wg sync.WaitGroup
+		open := make(chan *websocket.Conn, 20)
+		for i := range 40 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+				if err != nil {
+					t.Errorf("failed to open stream: %v", err)
+					return
+				}
+				if i%2 == 0 {
+					conn.Close()
+					return
+				}
+				open <- conn
+			}()
+		}
+		wg.Wait()
+		close(open)
+		waitForConnections(t, x, 20)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := x.connections.Shutdown(ctx); err != nil {
+			t.Fatalf("expected every stream released on shutdown, got %v", err)
+		}
+		for conn := range open {
+			if closeErr := readClose(t, conn); closeErr.Code != websocket.CloseGoingAway {
+				t.Errorf("expected close %d, got %d", websocket.CloseGoingAway, closeErr.Code)
+			}
+		}
+		if n := x.connections.Len(); n != 0 {
+			t.Errorf("expected no streams left registered, got %d", n)
+		}
+		if _, resp, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream"); err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 once shut down, got %v", err)
+		}
+	})
+
+	t.Run("agent error", func(t *testing.T) {
+		var x *Proxy
+		svc := &scriptedAgentService{err: connect.NewError(connect.CodeInternal, stderrors.New("agent crashed"))}
+		server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc)), func(p *Proxy) {
+			x = p
+		})
+		conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+		if err != nil {
+			t.Fatalf("failed to open stream: %v", err)
+		}
+		sendMessage(t, conn, "req-1")
+		readClose(t, conn)
+		waitForConnections(t, x, 0)
+	})
+}
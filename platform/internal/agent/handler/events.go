@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// MIMEEventStream is the content type for Server-Sent Events
+const MIMEEventStream = "text/event-stream"
+
+// acceptsEventStream reports whether the client asked for an SSE response
+func acceptsEventStream(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get(echo.HeaderAccept), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == MIMEEventStream {
+			return true
+		}
+	}
+	return false
+}
+
+// streamMessageEvents sends a message to the agent and streams every payload back to
+// the client as an SSE event. Payloads are the same JSON that webhook consumers receive,
+// with the webhook event type as the SSE event name and the seq as the event id.
+func (h *Handler) streamMessageEvents(c echo.Context, userID, agentID, requestID, content string, includeThinking bool) error {
+	// The stream outlives the server's write timeout, so lift the deadline for this response
+	_ = http.NewResponseController(c.Response()).SetWriteDeadline(time.Time{})
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, MIMEEventStream)
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.Header().Set("X-Forge-Request-Id", requestID)
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	// The request context is cancelled when the client disconnects, which
+	// tears down the agent stream as well.
+	ctx := c.Request().Context()
+	_ = h.processor.StreamMessage(ctx, userID, agentID, requestID, content, includeThinking, func(payload webhook.Payload) error {
+		return writeSSEEvent(res, payload)
+	})
+
+	// Errors have already been sent to the client as the final event
+	return nil
+}
+
+// writeSSEEvent writes a single payload as an SSE event and flushes it
+func writeSSEEvent(res *echo.Response, payload webhook.Payload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling payload: %w", err)
+	}
+
+	if _, err := fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", payload.Seq, payload.EventType, data); err != nil {
+		return fmt.Errorf("writing event: %w", err)
+	}
+	res.Flush()
+
+	return nil
+}
@@ -0,0 +1,622 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// scriptedAgentService replies to every Connect request with a fixed list of responses
+type scriptedAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	responses []*agentv1.AgentResponse
+	// block keeps the stream open after the scripted responses until the client goes away
+	block     bool
+	cancelled chan struct{}
+	// err ends the stream after the scripted responses
+	err error
+}
+
+func (s *scriptedAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	req, err := stream.Receive()
+	if err != nil {
+		return err
+	}
+	for _, resp := range s.responses {
+		resp.RequestId = req.RequestId
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	if s.block {
+		<-ctx.Done()
+		close(s.cancelled)
+		return ctx.Err()
+	}
+	return s.err
+}
+
+// startMockAgent serves the agent service over h2c and returns its port
+func startMockAgent(t *testing.T, svc agentv1connect.AgentServiceHandler) int32 {
+	t.Helper()
+	mux := http.NewServeMux()
+	path, h := agentv1connect.NewAgentServiceHandler(svc)
+	mux.Handle(path, h)
+
+	server := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	t.Cleanup(server.Close)
+
+	_, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to parse mock agent address: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return int32(port)
+}
+
+// createNodePortProcessor creates a processor that reaches agents through NodePort
+// services on localhost, so a ready pod can be routed to a mock agent server.
+func createNodePortProcessor(t *testing.T, userID, agentID string, agentPort int32) *processor.Processor {
+	t.Helper()
+	return processor.NewProcessor(createNodePortManager(t, userID, agentID, agentPort), nil, zap.NewNop())
+}
+
+// createNodePortManager creates the k8s manager behind createNodePortProcessor
+func createNodePortManager(t *testing.T, userID, agentID string, agentPort int32) *k8s.Manager {
+	t.Helper()
+	clientset := createNodePortClientset(userID, agentID, agentPort)
+	return k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1")
+}
+
+// createNodePortClientset holds a ready pod and the NodePort service routing to it
+func createNodePortClientset(userID, agentID string, agentPort int32) *fake.Clientset {
+	podID := k8s.NewPodID(userID, agentID)
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podID.Name(),
+			Namespace: testNamespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{Name: "grpc", Port: k8s.DefaultAgentPort, NodePort: agentPort},
+			},
+		},
+	}
+	return fake.NewSimpleClientset(createReadyPod(userID, agentID), svc)
+}
+
+type sseEvent struct {
+	id      string
+	event   string
+	payload webhook.Payload
+}
+
+// readSSEEvents parses every event from an SSE response body
+func readSSEEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var ev sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				ev.id = value
+			case "event":
+				ev.event = value
+			case "data":
+				if err := json.Unmarshal([]byte(value), &ev.payload); err != nil {
+					t.Fatalf("invalid event data %q: %v", value, err)
+				}
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func eventResponse(seq uint64, eventType, eventJSON string) *agentv1.AgentResponse {
+	return &agentv1.AgentResponse{
+		SessionId: "session-1",
+		Seq:       seq,
+		Timestamp: time.Now().UnixMilli(),
+		State:     agentv1.AgentState_AGENT_STATE_PROCESSING,
+		Payload: &agentv1.AgentResponse_Event{
+			Event: &agentv1.EventPayload{EventType: eventType, EventJson: []byte(eventJSON)},
+		},
+	}
+}
+
+func postSSE(e *echo.Echo, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", MIMEEventStream)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSendMessageSSE_StreamsPayloadsInOrder(t *testing.T) {
+	svc := &scriptedAgentService{
+		responses: []*agentv1.AgentResponse{
+			eventResponse(1, "message.updated", `{"type":"message.updated","n":1}`),
+			eventResponse(2, "message.part.updated", `{"type":"message.part.updated","n":2}`),
+			{
+				Seq:     3,
+				State:   agentv1.AgentState_AGENT_STATE_IDLE,
+				Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+			},
+		},
+	}
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","request_id":"req_sse"}`)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != MIMEEventStream {
+		t.Errorf("expected content type %s, got %s", MIMEEventStream, ct)
+	}
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %s", len(events), rec.Body.String())
+	}
+
+	wantTypes := []webhook.EventType{webhook.EventTypeEvent, webhook.EventTypeEvent, webhook.EventTypeComplete}
+	for i, ev := range events {
+		if ev.event != string(wantTypes[i]) {
+			t.Errorf("event %d: expected event name %s, got %s", i, wantTypes[i], ev.event)
+		}
+		if ev.payload.EventType != wantTypes[i] {
+			t.Errorf("event %d: expected payload event_type %s, got %s", i, wantTypes[i], ev.payload.EventType)
+		}
+		if ev.id != strconv.Itoa(i+1) || ev.payload.Seq != uint64(i+1) {
+			t.Errorf("event %d: expected seq %d, got id %s seq %d", i, i+1, ev.id, ev.payload.Seq)
+		}
+		if ev.payload.RequestID != "req_sse" || ev.payload.AgentID != "agent1" {
+			t.Errorf("event %d: unexpected ids %+v", i, ev.payload)
+		}
+	}
+
+	// Event payloads match what webhook consumers receive
+	want := webhook.AgentResponseToPayload(svc.responses[0], "agent1", "req_sse")
+	if string(events[0].payload.Event) != string(want.Event) || events[0].payload.OpenCodeEventType != want.OpenCodeEventType {
+		t.Errorf("expected webhook-shaped event payload, got %+v", events[0].payload)
+	}
+
+	last := events[len(events)-1].payload
+	if !last.IsFinal || !last.Success {
+		t.Errorf("expected final successful completion, got %+v", last)
+	}
+}
+
+func TestSendMessageSSE_AgentErrorIsFinalEvent(t *testing.T) {
+	svc := &scriptedAgentService{
+		responses: []*agentv1.AgentResponse{
+			eventResponse(1, "message.updated", `{}`),
+			{
+				Seq: 2,
+				Payload: &agentv1.AgentResponse_Error{
+					Error: &agentv1.ErrorPayload{Code: "MODEL_ERROR", Message: "boom", Fatal: true},
+				},
+			},
+		},
+	}
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`)
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %s", len(events), rec.Body.String())
+	}
+	last := events[1]
+	if last.event != string(webhook.EventTypeError) || !last.payload.IsFinal {
+		t.Fatalf("expected final agent.error event, got %+v", last)
+	}
+	if last.payload.Error == nil || last.payload.Error.Code != "MODEL_ERROR" {
+		t.Errorf("expected MODEL_ERROR, got %+v", last.payload.Error)
+	}
+}
+
+func TestSendMessageSSE_EOFWithoutFinalSynthesizesCompletion(t *testing.T) {
+	svc := &scriptedAgentService{
+		responses: []*agentv1.AgentResponse{eventResponse(1, "message.updated", `{}`)},
+	}
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`)
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %s", len(events), rec.Body.String())
+	}
+	last := events[1].payload
+	if last.EventType != webhook.EventTypeComplete || !last.IsFinal || last.Seq != 1 {
+		t.Errorf("expected synthesized final completion at seq 1, got %+v", last)
+	}
+}
+
+func TestSendMessageSSE_AgentUnreachable(t *testing.T) {
+	// No NodePort service exists, so the agent address cannot be resolved
+	clientset := fake.NewSimpleClientset(createReadyPod("user1", "agent1"))
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1")
+	e := setupTestHandler(t, processor.NewProcessor(mgr, nil, zap.NewNop()))
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`)
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d: %s", len(events), rec.Body.String())
+	}
+	if events[0].payload.Error == nil || events[0].payload.Error.Code != "AGENT_UNREACHABLE" || !events[0].payload.IsFinal {
+		t.Errorf("expected final AGENT_UNREACHABLE error, got %+v", events[0].payload)
+	}
+}
+
+func TestSendMessageSSE_DoesNotRequireWebhookURL(t *testing.T) {
+	svc := &scriptedAgentService{
+		responses: []*agentv1.AgentResponse{
+			{Seq: 1, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}},
+		},
+	}
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestSendMessageSSE_ClientDisconnectCancelsAgentStream(t *testing.T) {
+	svc := &scriptedAgentService{
+		responses: []*agentv1.AgentResponse{eventResponse(1, "message.updated", `{}`)},
+		block:     true,
+		cancelled: make(chan struct{}),
+	}
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost,
+		server.URL+"/api/v1/agents/agent1/messages?user_id=user1", strings.NewReader(`{"content":"hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", MIMEEventStream)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read until the first event arrives, proving it was flushed immediately
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed reading first event: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			break
+		}
+	}
+
+	cancel()
+
+	select {
+	case <-svc.cancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected client disconnect to cancel the agent stream")
+	}
+}
+
+func TestSendMessageSSE_IncludeThinkingFalseStripsReasoning(t *testing.T) {
+	reasoning := `{"type":"message.part.updated","properties":{"part":{"type":"reasoning","text":"secret"}}}`
+	svc := &scriptedAgentService{
+		responses: []*agentv1.AgentResponse{
+			eventResponse(1, "message.part.updated", reasoning),
+			eventResponse(2, "message.part.updated", `{"type":"message.part.updated","properties":{"part":{"type":"text","text":"answer"}}}`),
+			{
+				Seq:     3,
+				Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+			},
+		},
+	}
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","include_thinking":false}`)
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %s", len(events), rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("expected reasoning content to be stripped, got %s", rec.Body.String())
+	}
+	if events[0].payload.Seq != 2 || !events[1].payload.IsFinal {
+		t.Errorf("unexpected events: %+v", events)
+	}
+
+	// Reasoning is delivered by default
+	rec = postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`)
+	if events := readSSEEvents(t, rec.Body.String()); len(events) != 3 {
+		t.Fatalf("expected 3 events by default, got %d: %s", len(events), rec.Body.String())
+	}
+}
+
+func TestAcceptsEventStream(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"text/event-stream", true},
+		{"application/json, text/event-stream;q=0.9", true},
+		{"application/json", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Accept", tt.accept)
+		if got := acceptsEventStream(req); got != tt.want {
+			t.Errorf("acceptsEventStream(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// reconnectingAgentService serves each Connect with the next of its scripts and reports
+// the index of every connection it receives
+type reconnectingAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	scripts   []*scriptedAgentService
+	next      atomic.Int32
+	connected chan int
+}
+
+func (s *reconnectingAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	n := int(s.next.Add(1)) - 1
+	s.connected <- n
+	if n >= len(s.scripts) {
+		return connect.NewError(connect.CodeUnavailable, errors.New("unexpected connection"))
+	}
+	return s.scripts[n].Connect(ctx, stream)
+}
+
+// createRelocatableProcessor creates a NodePort processor whose pod watches are fake
+// watchers handed to the test, so it can replace or move the agent's pod mid-stream
+func createRelocatableProcessor(t *testing.T, agentPort int32) (*processor.Processor, *fake.Clientset, <-chan *watch.FakeWatcher) {
+	t.Helper()
+	clientset := createNodePortClientset("user1", "agent1", agentPort)
+	watchers := make(chan *watch.FakeWatcher, 16)
+	clientset.PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1")
+	return processor.NewProcessor(mgr, nil, zap.NewNop()), clientset, watchers
+}
+
+// relocatePod updates the agent's pod and reports the update on the stream's pod watch
+func relocatePod(t *testing.T, clientset *fake.Clientset, watchers <-chan *watch.FakeWatcher, update func(*corev1.Pod)) {
+	t.Helper()
+	var fw *watch.FakeWatcher
+	select {
+	case fw = <-watchers:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for the agent pod to be watched")
+	}
+
+	pods := clientset.CoreV1().Pods(testNamespace)
+	pod, err := pods.Get(context.Background(), k8s.NewPodID("user1", "agent1").Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	update(pod)
+	if pod, err = pods.Update(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+	fw.Modify(pod)
+}
+
+func replacePod(uid string) func(*corev1.Pod) {
+	return func(pod *corev1.Pod) { pod.UID = types.UID(uid) }
+}
+
+func awaitConnection(t *testing.T, svc *reconnectingAgentService, want int) {
+	t.Helper()
+	select {
+	case n := <-svc.connected:
+		if n != want {
+			t.Fatalf("expected connection %d, got %d", want, n)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout waiting for connection %d", want)
+	}
+}
+
+func TestSendMessageSSE_ResendsOnceWhenAgentReplaced(t *testing.T) {
+	svc := &reconnectingAgentService{
+		scripts: []*scriptedAgentService{
+			{block: true, cancelled: make(chan struct{})},
+			{responses: []*agentv1.AgentResponse{
+				eventResponse(1, "message.updated", `{}`),
+				{Seq: 2, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}},
+			}},
+		},
+		connected: make(chan int, 4),
+	}
+	proc, clientset, watchers := createRelocatableProcessor(t, startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`)
+	}()
+
+	// The pod is recreated under the same name before the agent responds
+	awaitConnection(t, svc, 0)
+	relocatePod(t, clientset, watchers, replacePod("pod-2"))
+	awaitConnection(t, svc, 1)
+
+	var rec *httptest.ResponseRecorder
+	select {
+	case rec = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the stream to finish")
+	}
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 2 {
+		t.Fatalf("expected the resent message's 2 events only, got %d: %s", len(events), rec.Body.String())
+	}
+	if last := events[1].payload; last.EventType != webhook.EventTypeComplete || !last.Success {
+		t.Errorf("expected a successful completion, got %+v", last)
+	}
+	select {
+	case <-svc.scripts[0].cancelled:
+	case <-time.After(3 * time.Second):
+		t.Error("expected the stream to the replaced pod to be cancelled")
+	}
+}
+
+func TestSendMessageSSE_ResendsOnlyOnce(t *testing.T) {
+	svc := &reconnectingAgentService{
+		scripts: []*scriptedAgentService{
+			{block: true, cancelled: make(chan struct{})},
+			{block: true, cancelled: make(chan struct{})},
+		},
+		connected: make(chan int, 4),
+	}
+	proc, clientset, watchers := createRelocatableProcessor(t, startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`)
+	}()
+
+	awaitConnection(t, svc, 0)
+	relocatePod(t, clientset, watchers, replacePod("pod-2"))
+	awaitConnection(t, svc, 1)
+	relocatePod(t, clientset, watchers, replacePod("pod-3"))
+
+	var rec *httptest.ResponseRecorder
+	select {
+	case rec = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the stream to finish")
+	}
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 1 {
+		t.Fatalf("expected a single error event, got %d: %s", len(events), rec.Body.String())
+	}
+	if errPayload := events[0].payload.Error; errPayload == nil || errPayload.Code != processor.ErrorCodeAgentRelocated || !errPayload.Recoverable {
+		t.Errorf("expected a recoverable %s error, got %+v", processor.ErrorCodeAgentRelocated, events[0].payload)
+	}
+	if n := svc.next.Load(); n != 2 {
+		t.Errorf("expected the message to be sent twice, got %d connections", n)
+	}
+}
+
+func TestSendMessageSSE_IPChangeAfterResponseFailsRequest(t *testing.T) {
+	svc := &reconnectingAgentService{
+		scripts: []*scriptedAgentService{{
+			responses: []*agentv1.AgentResponse{eventResponse(1, "message.updated", `{}`)},
+			block:     true,
+			cancelled: make(chan struct{}),
+		}},
+		connected: make(chan int, 4),
+	}
+	proc, clientset, watchers := createRelocatableProcessor(t, startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost,
+		server.URL+"/api/v1/agents/agent1/messages?user_id=user1", strings.NewReader(`{"content":"hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", MIMEEventStream)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Wait for the agent's first event, so the request has been partly answered
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed reading first event: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			break
+		}
+	}
+
+	relocatePod(t, clientset, watchers, func(pod *corev1.Pod) { pod.Status.PodIP = "10.0.0.2" })
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed reading the rest of the stream: %v", err)
+	}
+	events := readSSEEvents(t, string(rest))
+	if len(events) != 1 {
+		t.Fatalf("expected a single error event after the first, got %d: %s", len(events), rest)
+	}
+	if errPayload := events[0].payload.Error; errPayload == nil || errPayload.Code != processor.ErrorCodeAgentRelocated {
+		t.Errorf("expected a %s error, got %+v", processor.ErrorCodeAgentRelocated, events[0].payload)
+	}
+	if n := svc.next.Load(); n != 1 {
+		t.Errorf("expected a partly answered message not to be resent, got %d connections", n)
+	}
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// ErrorCodeExecDisabled is the error code of an exec refused because EXEC_ENABLED is not set
+const ErrorCodeExecDisabled = "exec_disabled"
+
+// defaultExecTimeout is how long a command may run if the request does not say
+const defaultExecTimeout = 30 * time.Second
+
+// execResponseGrace is how long an exec's response may take to write after the command's
+// timeout
+const execResponseGrace = 10 * time.Second
+
+// ExecRequest is the body of POST /api/v1/agents/:agent_id/exec
+type ExecRequest struct {
+	// Command is the program to run and its arguments. It is not run through a shell;
+	// send ["sh", "-c", "..."] for one. It may have at most 256 arguments, its program
+	// included, and run for at most 5 minutes.
+	Command        []string `json:"command" validate:"required,min=1,max=256"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty" validate:"gte=0,lte=300"`
+}
+
+// ExecResponse is the outcome of a command run in an agent's container. Output beyond
+// processor.ExecMaxOutputBytes is dropped, and Truncated set.
+type ExecResponse struct {
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// timeout checks the request's program and returns how long its command may run; the
+// validate tags have checked the rest
+func (r *ExecRequest) timeout() (time.Duration, error) {
+	if r.Command[0] == "" {
+		return 0, errors.BadRequest("command is required")
+	}
+	if r.TimeoutSeconds == 0 {
+		return defaultExecTimeout, nil
+	}
+	return time.Duration(r.TimeoutSeconds) * time.Second, nil
+}
+
+// Exec handles POST /api/v1/agents/:agent_id/exec, running a command in the agent's
+// container for admins, if EXEC_ENABLED is set
+func (h *Handler) Exec(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	var req ExecRequest
+	if err := handler.Bind(c, &req); err != nil {
+		return err
+	}
+	timeout, err := req.timeout()
+	if err != nil {
+		return err
+	}
+	// The command may outlast the server's write timeout, so extend the deadline of this
+	// response past the command's timeout
+	_ = http.NewResponseController(c.Response()).SetWriteDeadline(time.Now().Add(timeout + execResponseGrace))
+
+	result, err := h.processor.ExecInAgent(c.Request().Context(), userID, agentID, req.Command, timeout)
+	switch {
+	case stderrors.Is(err, processor.ErrExecDisabled):
+		return errors.Forbidden(err.Error()).WithErrorCode(ErrorCodeExecDisabled)
+	case stderrors.Is(err, processor.ErrAgentNotReady):
+		return errors.Conflict(err.Error())
+	case stderrors.Is(err, k8s.ErrExecUnavailable):
+		return errors.ServiceUnavailable(err.Error())
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return errors.GatewayTimeout(fmt.Sprintf("command did not finish within %s", timeout))
+	case err != nil:
+		return agentError(err, errors.InternalError)
+	}
+
+	return c.JSON(http.StatusOK, ExecResponse{
+		ExitCode:  result.ExitCode,
+		Stdout:    result.Stdout,
+		Stderr:    result.Stderr,
+		Truncated: result.Truncated,
+	})
+}
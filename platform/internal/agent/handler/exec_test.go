@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/k8s"
+)
+
+func postExec(e *echo.Echo, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/exec?user_id=user1", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestExec_RejectsInvalidRequests(t *testing.T) {
+	e := setupTestHandler(t, createTestProcessor(t, createReadyPod("user1", "agent1")))
+
+	for _, body := range []string{
+		`{}`,
+		`{"command":[]}`,
+		`{"command":[""]}`,
+		`{"command":["ls"],"timeout_seconds":-1}`,
+		`{"command":["ls"],"timeout_seconds":301}`,
+		`{"command":"ls"}`,
+	} {
+		if rec := postExec(e, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d: %s", body, http.StatusBadRequest, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestExec_Disabled(t *testing.T) {
+	e := setupTestHandler(t, createTestProcessor(t, createReadyPod("user1", "agent1")))
+
+	rec := postExec(e, `{"command":["ls"]}`)
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if body.Error != ErrorCodeExecDisabled {
+		t.Errorf("expected error %q, got %q", ErrorCodeExecDisabled, body.Error)
+	}
+}
+
+func TestExec_RequiresAdmin(t *testing.T) {
+	e := setupTestHandler(t, createTestProcessor(t, createReadyPod("user1", "agent1")))
+	e.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			handler.SetPrincipal(c, handler.Principal{UserID: "user1"})
+			return next(c)
+		}
+	})
+
+	rec := postExec(e, `{"command":["ls"]}`)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "admin") {
+		t.Errorf("expected the agent's owner refused without an admin key, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// slowExecProcessor runs every command for delay, then reports it exited 0
+type slowExecProcessor struct {
+	*stubProcessor
+	delay time.Duration
+}
+
+func (p *slowExecProcessor) ExecInAgent(ctx context.Context, _, _ string, _ []string, _ time.Duration) (*k8s.ExecResult, error) {
+	select {
+	case <-time.After(p.delay):
+		return &k8s.ExecResult{Stdout: "done"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestExec_OutlastsWriteTimeout(t *testing.T) {
+	e := setupTestHandler(t, &slowExecProcessor{stubProcessor: newStubProcessor(), delay: 300 * time.Millisecond})
+	server := httptest.NewUnstartedServer(e)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+
+	resp, err := http.Post(server.URL+"/api/v1/agents/agent1/exec?user_id=user1", echo.MIMEApplicationJSON,
+		strings.NewReader(`{"command":["sleep","1"],"timeout_seconds":2}`))
+	if err != nil {
+		t.Fatalf("expected the command's response past the write timeout, got %v", err)
+	}
+	defer resp.Body.Close()
+	var body ExecResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", resp.StatusCode, err)
+	}
+	if body.Stdout != "done" {
+		t.Errorf("expected the command's output, got %+v", body)
+	}
+}
@@ -0,0 +1,368 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/k8s"
+)
+
+const (
+	// uploadFormMemory is how much of an upload is held in memory; the rest is spooled to
+	// temporary files
+	uploadFormMemory = 32 << 20
+	// uploadFormOverhead allows for the multipart framing of an upload on top of its files
+	uploadFormOverhead = 1 << 20
+)
+
+// UploadFilesResponse is returned after files are written to an agent's workspace
+type UploadFilesResponse struct {
+	// Path is the directory the files were written to, relative to the agent's working
+	// directory
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// UploadFiles handles POST /api/v1/agents/:agent_id/files, writing the multipart "file"
+// parts to the directory in the path query param (the agent's working directory if
+// unset). With extract=true, .zip, .tar, .tar.gz and .tgz files are extracted there
+// instead. Every file is checked before anything is written to the agent.
+func (h *Handler) UploadFiles(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+	dir, err := k8s.CleanRelativePath(c.QueryParam("path"))
+	if err != nil {
+		return errors.BadRequest(err.Error())
+	}
+	extract := c.QueryParam("extract") == "true"
+
+	// An upload of up to AGENT_FILE_MAX_BYTES may outlast the server's read and write
+	// timeouts, so extend both to AGENT_FILE_TRANSFER_TIMEOUT
+	deadline := h.transferDeadline()
+	rc := http.NewResponseController(c.Response())
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+
+	req := c.Request()
+	maxBytes := h.processor.FileMaxBytes()
+	if maxBytes > 0 {
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, maxBytes+uploadFormOverhead)
+	}
+	if err := req.ParseMultipartForm(uploadFormMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if stderrors.As(err, &tooLarge) {
+			return errors.PayloadTooLarge(fmt.Sprintf("upload exceeds AGENT_FILE_MAX_BYTES of %d", maxBytes))
+		}
+		return errors.BadRequest("invalid multipart upload")
+	}
+	defer req.MultipartForm.RemoveAll()
+	files := req.MultipartForm.File["file"]
+	if len(files) == 0 {
+		return errors.BadRequest("file is required")
+	}
+
+	checked := &uploadArchive{maxBytes: maxBytes}
+	if err := checked.addFiles(files, extract); err != nil {
+		return err
+	}
+
+	// Stream the files to the agent as one tar archive
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		upload := &uploadArchive{tw: tar.NewWriter(pw), maxBytes: maxBytes}
+		err := upload.addFiles(files, extract)
+		if err == nil {
+			err = upload.tw.Close()
+		}
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	err = h.processor.UploadFiles(req.Context(), userID, agentID, dir, pr)
+	pr.Close()
+	if writeErr := <-written; err == nil && writeErr != nil {
+		err = writeErr
+	}
+	if err != nil {
+		return filesError(err)
+	}
+
+	return c.JSON(http.StatusCreated, UploadFilesResponse{
+		Path:  dir,
+		Files: checked.files,
+		Bytes: checked.bytes,
+	})
+}
+
+// DownloadFiles handles GET /api/v1/agents/:agent_id/files?path=..., downloading a file of
+// the agent's workspace as is, or a directory as a tar archive. With format=tar a file is
+// downloaded as a tar archive too. A directory exceeding AGENT_FILE_MAX_BYTES is cut off,
+// leaving its archive unterminated.
+func (h *Handler) DownloadFiles(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+	rel, err := k8s.CleanRelativePath(c.QueryParam("path"))
+	if err != nil {
+		return errors.BadRequest(err.Error())
+	}
+	format := c.QueryParam("format")
+	if format != "" && format != "tar" {
+		return errors.BadRequest(fmt.Sprintf("invalid format %q: must be tar", format))
+	}
+
+	// A download of up to AGENT_FILE_MAX_BYTES may outlast the server's write timeout, so
+	// extend it to AGENT_FILE_TRANSFER_TIMEOUT
+	_ = http.NewResponseController(c.Response()).SetWriteDeadline(h.transferDeadline())
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(h.processor.DownloadFiles(ctx, userID, agentID, rel, pw))
+	}()
+
+	tr := tar.NewReader(pr)
+	hdr, err := tr.Next()
+	if err == io.EOF {
+		return errors.NotFound(fmt.Sprintf("%s not found", rel))
+	}
+	if err != nil {
+		return filesError(err)
+	}
+
+	maxBytes := h.processor.FileMaxBytes()
+	res := c.Response()
+	if hdr.Typeflag == tar.TypeReg && format == "" {
+		if maxBytes > 0 && hdr.Size > maxBytes {
+			return errors.PayloadTooLarge(fmt.Sprintf("%s is %d bytes, AGENT_FILE_MAX_BYTES is %d", rel, hdr.Size, maxBytes))
+		}
+		res.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", hdr.Name))
+		res.Header().Set(echo.HeaderContentLength, strconv.FormatInt(hdr.Size, 10))
+		res.WriteHeader(http.StatusOK)
+		_, err := io.Copy(res, tr)
+		return err
+	}
+
+	name := path.Base(rel)
+	if rel == "." {
+		name = "workspace"
+	}
+	res.Header().Set(echo.HeaderContentType, "application/x-tar")
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name+".tar"))
+	res.WriteHeader(http.StatusOK)
+	tw := tar.NewWriter(res)
+	var total int64
+	for ; err == nil; hdr, err = tr.Next() {
+		if total += hdr.Size; maxBytes > 0 && total > maxBytes {
+			return fmt.Errorf("download of %s exceeds AGENT_FILE_MAX_BYTES of %d", rel, maxBytes)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	if err != io.EOF {
+		return err
+	}
+	return tw.Close()
+}
+
+// transferDeadline returns the read and write deadline of a file transfer starting now, zero
+// if AGENT_FILE_TRANSFER_TIMEOUT sets no limit
+func (h *Handler) transferDeadline() time.Time {
+	timeout := h.processor.FileTransferTimeout()
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// filesError converts an error uploading or downloading files into an API error
+func filesError(err error) error {
+	var appErr *errors.AppError
+	switch {
+	case stderrors.As(err, &appErr):
+		return appErr
+	case stderrors.Is(err, k8s.ErrInvalidPath):
+		return errors.BadRequest(err.Error())
+	case stderrors.Is(err, k8s.ErrFileNotFound):
+		return errors.NotFound(err.Error())
+	case stderrors.Is(err, processor.ErrAgentNotReady):
+		return errors.Conflict(err.Error())
+	case stderrors.Is(err, k8s.ErrExecUnavailable):
+		return errors.ServiceUnavailable(err.Error())
+	}
+	return agentError(err, errors.InternalError)
+}
+
+// uploadArchive turns uploaded files into the entries of a tar archive, refusing entries
+// that are not files or directories, leave the upload's directory, or take the upload past
+// maxBytes
+type uploadArchive struct {
+	tw       *tar.Writer // nil only checks the entries
+	maxBytes int64       // 0 means no cap
+
+	files int
+	bytes int64
+}
+
+// addFiles adds the uploaded files, extracting archives if extract is set
+func (u *uploadArchive) addFiles(files []*multipart.FileHeader, extract bool) error {
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return errors.BadRequest(fmt.Sprintf("failed to read %s", fh.Filename))
+		}
+		err = u.addFile(fh, f, extract)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFile adds one uploaded file
+func (u *uploadArchive) addFile(fh *multipart.FileHeader, f multipart.File, extract bool) error {
+	name := strings.ToLower(fh.Filename)
+	switch {
+	case extract && strings.HasSuffix(name, ".zip"):
+		zr, err := zip.NewReader(f, fh.Size)
+		if err != nil {
+			return errors.BadRequest(fmt.Sprintf("%s is not a valid zip archive", fh.Filename))
+		}
+		return u.addZip(zr)
+	case extract && strings.HasSuffix(name, ".tar"):
+		return u.addTar(tar.NewReader(f), fh.Filename)
+	case extract && (strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.BadRequest(fmt.Sprintf("%s is not a valid gzip archive", fh.Filename))
+		}
+		defer gz.Close()
+		return u.addTar(tar.NewReader(gz), fh.Filename)
+	}
+	return u.add(fh.Filename, tar.TypeReg, 0o644, fh.Size, time.Now(), f)
+}
+
+// addZip adds the entries of a zip archive
+func (u *uploadArchive) addZip(zr *zip.Reader) error {
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		if mode.IsDir() {
+			if err := u.add(zf.Name, tar.TypeDir, 0o755, 0, zf.Modified, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if mode&fs.ModeType != 0 {
+			return errors.BadRequest(fmt.Sprintf("archive entry %s is not a file or directory", zf.Name))
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return errors.BadRequest(fmt.Sprintf("failed to read archive entry %s", zf.Name))
+		}
+		err = u.add(zf.Name, tar.TypeReg, fileMode(mode), int64(zf.UncompressedSize64), zf.Modified, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTar adds the entries of a tar archive
+func (u *uploadArchive) addTar(tr *tar.Reader, archive string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.BadRequest(fmt.Sprintf("%s is not a valid tar archive", archive))
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = u.add(hdr.Name, tar.TypeDir, 0o755, 0, hdr.ModTime, nil)
+		case tar.TypeReg:
+			err = u.add(hdr.Name, tar.TypeReg, fileMode(hdr.FileInfo().Mode()), hdr.Size, hdr.ModTime, tr)
+		default:
+			err = errors.BadRequest(fmt.Sprintf("archive entry %s is not a file or directory", hdr.Name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// add adds an entry, copying size bytes of a file's content from r
+func (u *uploadArchive) add(name string, typeflag byte, mode int64, size int64, modTime time.Time, r io.Reader) error {
+	cleaned, err := k8s.CleanRelativePath(name)
+	if err == nil && cleaned == "." && typeflag == tar.TypeDir {
+		// The archive's own root, such as the "./" of tar -C dir .
+		return nil
+	}
+	if err != nil || cleaned == "." {
+		return errors.BadRequest(fmt.Sprintf("invalid file name %q", name))
+	}
+	if typeflag == tar.TypeReg {
+		u.files++
+		u.bytes += size
+	}
+	if u.maxBytes > 0 && u.bytes > u.maxBytes {
+		return errors.PayloadTooLarge(fmt.Sprintf("upload exceeds AGENT_FILE_MAX_BYTES of %d", u.maxBytes))
+	}
+	if u.tw == nil {
+		return nil
+	}
+
+	hdr := &tar.Header{Name: cleaned, Typeflag: typeflag, Mode: mode, Size: size, ModTime: modTime}
+	if typeflag == tar.TypeDir {
+		hdr.Name += "/"
+	}
+	if err := u.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.CopyN(u.tw, r, size); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// fileMode returns the mode a file is written with: executable if it was, and otherwise
+// readable by everyone and writable by its owner
+func fileMode(mode fs.FileMode) int64 {
+	if mode&0o111 != 0 {
+		return 0o755
+	}
+	return 0o644
+}
@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/exec"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// fakeFilesystem stands in for an agent container's filesystem, running the tar commands
+// the k8s Manager copies files with
+type fakeFilesystem struct {
+	mu    sync.Mutex
+	files map[string]string // path -> content; directories are ""
+	dirs  map[string]bool
+	delay time.Duration // how long each command takes to start
+}
+
+func (f *fakeFilesystem) Exec(_ context.Context, _, _, _ string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case command[0] == "sh": // mkdir -p "$1" && tar -xf - -C "$1"
+		dir := command[len(command)-1]
+		tr := tar.NewReader(stdin)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			name := path.Join(dir, hdr.Name)
+			if hdr.Typeflag == tar.TypeDir {
+				f.dirs[name] = true
+				continue
+			}
+			data, _ := io.ReadAll(tr)
+			f.files[name] = string(data)
+		}
+	case command[0] == "tar": // tar -cf - -C dir base
+		root := path.Join(command[4], command[5])
+		tw := tar.NewWriter(stdout)
+		if data, ok := f.files[root]; ok {
+			tw.WriteHeader(&tar.Header{Name: command[5], Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data))})
+			io.WriteString(tw, data)
+			return tw.Close()
+		}
+		if !f.dirs[root] {
+			fmt.Fprintf(stderr, "tar: %s: Cannot stat: No such file or directory\n", command[5])
+			return exec.CodeExitError{Err: fmt.Errorf("exit 2"), Code: 2}
+		}
+		tw.WriteHeader(&tar.Header{Name: command[5] + "/", Typeflag: tar.TypeDir, Mode: 0o755})
+		var names []string
+		for name := range f.files {
+			if strings.HasPrefix(name, root+"/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			data := f.files[name]
+			rel := path.Join(command[5], strings.TrimPrefix(name, root+"/"))
+			tw.WriteHeader(&tar.Header{Name: rel, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data))})
+			io.WriteString(tw, data)
+		}
+		return tw.Close()
+	}
+	return fmt.Errorf("unexpected command %q", command)
+}
+
+// setupFilesTest serves the agent routes with the agent's filesystem faked
+func setupFilesTest(t *testing.T, objects ...runtime.Object) (*echo.Echo, *fakeFilesystem) {
+	t.Helper()
+	fs := &fakeFilesystem{
+		files: make(map[string]string),
+		dirs:  map[string]bool{k8s.DefaultWorkspaceMountPath: true},
+	}
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(objects...), testNamespace, "test-image:latest", "")
+	mgr.SetExecutor(fs)
+	return setupTestHandler(t, processor.NewProcessor(mgr, nil, zap.NewNop())), fs
+}
+
+// uploadFiles posts files, named by their multipart filename, to the agent's files route
+func uploadFiles(t *testing.T, e *echo.Echo, query string, files map[string][]byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, data := range files {
+		w, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/files?user_id=user1"+query, &body)
+	req.Header.Set(echo.HeaderContentType, mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, data)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestUploadFiles_WritesFilesAndExtractsArchives(t *testing.T) {
+	e, fs := setupFilesTest(t, createReadyPod("user1", "agent1"))
+
+	rec := uploadFiles(t, e, "&path=src&extract=true", map[string][]byte{
+		"notes.txt": []byte("hello"),
+		"repo.zip":  zipArchive(t, map[string]string{"main.go": "package main", "pkg/util.go": "package pkg"}),
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp UploadFilesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Path != "src" || resp.Files != 3 {
+		t.Errorf("unexpected response %s", rec.Body.String())
+	}
+
+	want := map[string]string{
+		"/home/agent/workspace/src/notes.txt":   "hello",
+		"/home/agent/workspace/src/main.go":     "package main",
+		"/home/agent/workspace/src/pkg/util.go": "package pkg",
+	}
+	for name, data := range want {
+		if fs.files[name] != data {
+			t.Errorf("expected %s to hold %q, got %q", name, data, fs.files[name])
+		}
+	}
+}
+
+func TestUploadFiles_RefusesPathTraversal(t *testing.T) {
+	e, fs := setupFilesTest(t, createReadyPod("user1", "agent1"))
+
+	tests := []struct {
+		name  string
+		query string
+		files map[string][]byte
+	}{
+		{"path param", "&path=../../etc", map[string][]byte{"a.txt": []byte("a")}},
+		{"absolute path param", "&path=/etc", map[string][]byte{"a.txt": []byte("a")}},
+		{"zip entry", "&extract=true", map[string][]byte{
+			"ok.txt":   []byte("never written"),
+			"evil.zip": zipArchive(t, map[string]string{"../../.bashrc": "evil"}),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := uploadFiles(t, e, tt.query, tt.files); rec.Code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+	if len(fs.files) != 0 {
+		t.Errorf("expected nothing written, got %v", fs.files)
+	}
+}
+
+func TestUploadFiles_AgentNotReady(t *testing.T) {
+	e, _ := setupFilesTest(t, createPendingPod("user1", "agent1"))
+
+	if rec := uploadFiles(t, e, "", map[string][]byte{"a.txt": []byte("a")}); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadArchive_EnforcesMaxBytes(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "big.bin", Typeflag: tar.TypeReg, Mode: 0o644, Size: 20})
+	tw.Write(make([]byte, 20))
+	tw.Close()
+
+	u := &uploadArchive{maxBytes: 10}
+	err := u.addTar(tar.NewReader(&buf), "big.tar")
+	if err == nil || !strings.Contains(err.Error(), "AGENT_FILE_MAX_BYTES") {
+		t.Errorf("expected the upload refused over the cap, got %v", err)
+	}
+}
+
+func downloadFiles(e *echo.Echo, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents/agent1/files?user_id=user1"+query, nil))
+	return rec
+}
+
+func TestDownloadFiles(t *testing.T) {
+	e, fs := setupFilesTest(t, createReadyPod("user1", "agent1"))
+	fs.dirs["/home/agent/workspace/out"] = true
+	fs.files["/home/agent/workspace/out/result.txt"] = "done"
+	fs.files["/home/agent/workspace/out/log.txt"] = "ok"
+
+	rec := downloadFiles(e, "&path=out/result.txt")
+	if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+		t.Fatalf("expected the file's content, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.Contains(cd, `"result.txt"`) {
+		t.Errorf("expected the file's name in the disposition, got %q", cd)
+	}
+
+	rec = downloadFiles(e, "&path=out")
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "application/x-tar" {
+		t.Fatalf("expected a tar archive, got %d: %s", rec.Code, rec.Body.String())
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(rec.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid archive: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(data)
+	}
+	if got["out/result.txt"] != "done" || got["out/log.txt"] != "ok" {
+		t.Errorf("unexpected archive entries %v", got)
+	}
+}
+
+// startTimeoutServer serves e with read and write timeouts far shorter than the transfers
+// of the test
+func startTimeoutServer(t *testing.T, e *echo.Echo) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(e)
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUploadFiles_OutlastsReadAndWriteTimeouts(t *testing.T) {
+	e, fs := setupFilesTest(t, createReadyPod("user1", "agent1"))
+	fs.delay = 300 * time.Millisecond
+	server := startTimeoutServer(t, e)
+
+	// The client sends the upload slower than the read timeout allows
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		w, _ := mw.CreateFormFile("file", "notes.txt")
+		io.WriteString(w, "hello")
+		time.Sleep(300 * time.Millisecond)
+		pw.CloseWithError(mw.Close())
+	}()
+	resp, err := http.Post(server.URL+"/api/v1/agents/agent1/files?user_id=user1", mw.FormDataContentType(), pr)
+	if err != nil {
+		t.Fatalf("expected the upload's response past the timeouts, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", resp.StatusCode, body)
+	}
+	if got := fs.files["/home/agent/workspace/notes.txt"]; got != "hello" {
+		t.Errorf("expected the file uploaded, got %q", got)
+	}
+}
+
+func TestDownloadFiles_OutlastsWriteTimeout(t *testing.T) {
+	e, fs := setupFilesTest(t, createReadyPod("user1", "agent1"))
+	fs.files["/home/agent/workspace/result.txt"] = "done"
+	fs.delay = 300 * time.Millisecond
+	server := startTimeoutServer(t, e)
+
+	resp, err := http.Get(server.URL + "/api/v1/agents/agent1/files?user_id=user1&path=result.txt")
+	if err != nil {
+		t.Fatalf("expected the download past the write timeout, got %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK || string(body) != "done" {
+		t.Errorf("expected the file's content, got %d: %q, %v", resp.StatusCode, body, err)
+	}
+}
+
+func TestDownloadFiles_Errors(t *testing.T) {
+	e, _ := setupFilesTest(t, createReadyPod("user1", "agent1"))
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"&path=missing.txt", http.StatusNotFound},
+		{"&path=../secrets", http.StatusBadRequest},
+		{"&path=out&format=zip", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := downloadFiles(e, tt.query); rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.query, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+}
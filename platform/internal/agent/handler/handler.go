@@ -1,70 +1,384 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 
 	agentv1 "github.com/forge/platform/gen/agent/v1"
 	"github.com/forge/platform/internal/agent/processor"
 	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/k8s"
 )
 
 // Handler handles agent HTTP endpoints
 type Handler struct {
-	processor *processor.Processor
+	processor  AgentProcessor
+	bodyLimits handler.BodyLimits
 }
 
 // NewHandler creates a new agent handler
-func NewHandler(processor *processor.Processor) *Handler {
+func NewHandler(processor AgentProcessor, bodyLimits handler.BodyLimits) *Handler {
 	return &Handler{
-		processor: processor,
+		processor:  processor,
+		bodyLimits: bodyLimits,
 	}
 }
 
 // Register registers agent routes with Echo
 func (h *Handler) Register(e *echo.Echo) {
-	g := e.Group("/api/v1/agents")
-	g.POST("", h.Create)
+	// Agent routes scoped to their owner. The flat /api/v1/agents routes taking a user_id
+	// query param are deprecated and serve the same handlers.
+	h.registerAgentRoutes(e.Group("/api/v1/users/:user_id/agents", handler.RequireUser))
+	h.registerAgentRoutes(e.Group("/api/v1/agents", handler.RequireUser, deprecatedRoute))
+
+	// Usage of the agents of a user
+	e.GET("/api/v1/users/:user_id/usage", h.GetUsage, handler.RequireUser)
+
+	// Request status routes, for the user in the user_id query param
+	requests := e.Group("/api/v1/requests", handler.RequireUser)
+	requests.GET("/:request_id", h.GetRequest)
+	requests.POST("/:request_id/redeliver", h.Redeliver, handler.BodyLimit(h.bodyLimits.Default))
+	requests.POST("/:request_id/receipts", h.RecordReceipt, handler.BodyLimit(h.bodyLimits.Default))
+	requests.POST("/:request_id/cancel", h.CancelRequest)
+	requests.GET("/:request_id/artifacts", h.ListArtifacts)
+	requests.GET("/:request_id/artifacts/:name", h.DownloadArtifact)
+	requests.GET("/batches/:batch_id", h.GetBatch)
+
+	// Admin routes
+	e.POST("/api/v1/admin/agents/:agent_id/unquarantine", h.Unquarantine)
+	e.GET("/api/v1/admin/users/:user_id/budget", h.GetBudget)
+	e.PUT("/api/v1/admin/users/:user_id/budget", h.SetBudget, handler.BodyLimit(h.bodyLimits.Default))
+	e.DELETE("/api/v1/admin/users/:user_id/budget", h.DeleteBudget)
+}
+
+// registerAgentRoutes registers the agent routes under g. Routes taking a message's
+// content accept larger bodies than the others; file uploads are limited by the processor.
+func (h *Handler) registerAgentRoutes(g *echo.Group) {
+	bodyLimit := handler.BodyLimit(h.bodyLimits.Default)
+	messageBodyLimit := handler.BodyLimit(h.bodyLimits.Message)
+
+	g.POST("", h.Create, bodyLimit)
 	g.GET("", h.List)
-	g.GET("/:id", h.Get)
-	g.DELETE("/:id", h.Delete)
+	g.DELETE("", h.DeleteAll)
+	g.GET("/:agent_id", h.Get)
+	g.PATCH("/:agent_id", h.Update, bodyLimit)
+	g.DELETE("/:agent_id", h.Delete)
 
 	// Message routes
-	g.POST("/:id/messages", h.SendMessage)
-	g.POST("/:id/interrupt", h.Interrupt)
+	g.POST("/:agent_id/messages", h.SendMessage, messageBodyLimit)
+	g.GET("/:agent_id/messages", h.ListMessages)
+	g.POST("/:agent_id/messages/batch", h.SendBatch, messageBodyLimit)
+	g.POST("/:agent_id/interrupt", h.Interrupt, bodyLimit)
+	g.GET("/:agent_id/requests", h.ListRequests)
+
+	// Workspace file routes
+	g.POST("/:agent_id/files", h.UploadFiles)
+	g.GET("/:agent_id/files", h.DownloadFiles)
+
+	// Admin-only agent routes
+	g.POST("/:agent_id/exec", h.Exec, handler.RequireAdmin, bodyLimit)
+}
+
+// deprecatedRoute marks responses of the flat agent routes as deprecated in favor of the
+// user-scoped routes
+func deprecatedRoute(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Deprecation", "true")
+		c.Response().Header().Set("Link", `</api/v1/users/{user_id}/agents>; rel="successor-version"`)
+		return next(c)
+	}
+}
+
+// userIDParam returns the user a request is for: the :user_id path param of the
+// user-scoped routes, or else the user_id query param, or else the authenticated caller.
+// A param naming another user than a caller who is not an admin is refused by
+// handler.RequireUser before this runs.
+func userIDParam(c echo.Context) string {
+	if userID := c.Param("user_id"); userID != "" {
+		return userID
+	}
+	if userID := c.QueryParam("user_id"); userID != "" {
+		return userID
+	}
+	if p, ok := handler.PrincipalFrom(c); ok && !p.Admin {
+		return p.UserID
+	}
+	return ""
+}
+
+// agentError converts an error from the processor into an AppError: one wrapping a
+// Kubernetes API error by its reason (see errors.FromKubernetes), an agent that is starting
+// or terminating into a 409, and any other with fallback
+func agentError(err error, fallback func(msg string) *errors.AppError) *errors.AppError {
+	if appErr := errors.FromKubernetes(err); appErr != nil {
+		return appErr
+	}
+	var notReady *k8s.AgentNotReadyError
+	if stderrors.As(err, &notReady) {
+		return errors.Conflict(notReady.Error())
+	}
+	return fallback(err.Error())
+}
+
+// PodFailureDetails describes why an agent's pod failed to become ready
+type PodFailureDetails struct {
+	Pod     string          `json:"pod"`
+	Phase   corev1.PodPhase `json:"phase"`
+	Reason  string          `json:"reason,omitempty"`
+	Message string          `json:"message,omitempty"`
+	// Events are the pod's latest Kubernetes events, oldest first
+	Events []string `json:"events,omitempty"`
+}
+
+// podFailureError converts a pod failure into a 422 agent_pod_failed if the pod will not
+// become ready without a change (e.g. a bad image), and a 503 agent_pod_not_ready if it was
+// still starting when creation gave up
+func podFailureError(failure *k8s.PodFailureError) *errors.AppError {
+	details := PodFailureDetails{
+		Pod:     failure.Pod,
+		Phase:   failure.Phase,
+		Reason:  failure.Reason,
+		Message: failure.Message,
+		Events:  failure.Events,
+	}
+	if failure.Terminal {
+		return errors.UnprocessableEntity(failure.Error()).WithErrorCode("agent_pod_failed").WithDetails(details)
+	}
+	return errors.ServiceUnavailable(failure.Error()).WithErrorCode("agent_pod_not_ready").WithDetails(details)
 }
 
 // CreateAgentRequest is the request body for creating an agent
 type CreateAgentRequest struct {
-	OwnerID string `json:"owner_id"`
+	OwnerID string `json:"owner_id" validate:"max=128"`
+	// Workspace, if set, gives the agent a persistent workspace volume
+	Workspace *WorkspaceRequest `json:"workspace,omitempty"`
+	// Repository, if set, is cloned into the agent's workspace before the agent starts
+	Repository *RepositoryRequest `json:"repository,omitempty"`
+	// ImageTag, if set, runs the agent image with this tag. Only the tags the platform's
+	// image tag policy allows are accepted.
+	ImageTag string `json:"image_tag,omitempty" validate:"max=128"`
+	// Wait set to false returns 202 once the pod is created, without waiting for it to be
+	// ready (as does ?async=true)
+	Wait *bool `json:"wait,omitempty"`
+	AgentSettingsRequest
+}
+
+// async reports whether the request asks for the agent to be created without waiting for
+// it to be ready
+func (r CreateAgentRequest) async(c echo.Context) bool {
+	return (r.Wait != nil && !*r.Wait) || c.QueryParam("async") == "true"
+}
+
+// AgentSettingsRequest holds the model and permission mode an agent runs with, at creation
+// and in PATCH /api/v1/agents/:agent_id. Models must be in AGENT_MODEL_ALLOWLIST; empty
+// fields leave the agent's setting as it is.
+type AgentSettingsRequest struct {
+	Model          string `json:"model,omitempty" validate:"max=256"`
+	PermissionMode string `json:"permission_mode,omitempty" validate:"omitempty,oneof=default acceptEdits bypassPermissions"`
+}
+
+// settings returns the processor settings of the request
+func (r AgentSettingsRequest) settings() processor.AgentSettings {
+	return processor.AgentSettings{Model: r.Model, PermissionMode: r.PermissionMode}
+}
+
+// WorkspaceRequest asks for a persistent workspace volume surviving the agent's pod
+type WorkspaceRequest struct {
+	// Size is a Kubernetes quantity, e.g. "5Gi"
+	Size         string `json:"size" validate:"required"`
+	StorageClass string `json:"storage_class,omitempty" validate:"max=253"`
+	// Retain keeps the volume when the agent is deleted
+	Retain bool `json:"retain,omitempty"`
+}
+
+// workspace validates the request and returns the workspace it asks for
+func (r *WorkspaceRequest) workspace() (*k8s.Workspace, error) {
+	if r.Size == "" {
+		return nil, errors.BadRequest("workspace.size is required")
+	}
+	size, err := resource.ParseQuantity(r.Size)
+	if err != nil {
+		return nil, errors.BadRequest(fmt.Sprintf("workspace.size %q is not a valid quantity, e.g. 5Gi", r.Size))
+	}
+	if size.Sign() <= 0 {
+		return nil, errors.BadRequest("workspace.size must be positive")
+	}
+	return &k8s.Workspace{Size: size, StorageClass: r.StorageClass, Retain: r.Retain}, nil
+}
+
+// RepositoryRequest asks for a git repository to be cloned into the agent's workspace
+type RepositoryRequest struct {
+	URL string `json:"url" validate:"required,max=2048"`
+	// Ref is a branch, tag or commit; the default branch if empty
+	Ref string `json:"ref,omitempty" validate:"max=256"`
+	// Depth, if positive, makes the clone shallow
+	Depth int `json:"depth,omitempty" validate:"gte=0"`
+	// AuthSecretName names a Kubernetes secret in the agent namespace holding the
+	// credentials of a private repository. Tokens are never accepted in the request.
+	AuthSecretName string `json:"auth_secret_name,omitempty" validate:"max=253"`
+}
+
+// repository validates the request and returns the repository it asks for
+func (r *RepositoryRequest) repository() (*k8s.Repository, error) {
+	repo := &k8s.Repository{URL: r.URL, Ref: r.Ref, Depth: r.Depth, AuthSecretName: r.AuthSecretName}
+	if err := repo.Validate(); err != nil {
+		return nil, errors.BadRequest(err.Error())
+	}
+	return repo, nil
 }
 
 // AgentResponse is the response for agent operations
 type AgentResponse struct {
-	UserID    string          `json:"user_id"`
-	AgentID   string          `json:"agent_id"`
-	PodName   string          `json:"pod_name"`
-	PodIP     string          `json:"pod_ip,omitempty"`
+	UserID  string `json:"user_id"`
+	AgentID string `json:"agent_id"`
+	PodName string `json:"pod_name"`
+	// Image is the image the agent runs
+	Image string `json:"image,omitempty"`
+	PodIP string `json:"pod_ip,omitempty"`
+	// NodePort is the port on NODE_HOST the agent is reached through, if NODE_HOST is set
+	NodePort  int32           `json:"node_port,omitempty"`
 	Phase     corev1.PodPhase `json:"phase"`
 	Ready     bool            `json:"ready"`
 	CreatedAt string          `json:"created_at,omitempty"`
 
-	// Agent internal state (populated when refresh=true)
+	// RestartCount sums the restarts of the pod's containers
+	RestartCount int32  `json:"restart_count"`
+	NodeName     string `json:"node_name,omitempty"`
+	// StartedAt is when the agent container last started running
+	StartedAt string `json:"started_at,omitempty"`
+	// ContainerState is the state of the agent container, once it has one
+	ContainerState *ContainerState `json:"container_state,omitempty"`
+
+	// Agent internal state (populated when refresh=true); Status carries the same fields
 	SessionID      string `json:"session_id,omitempty"`
 	State          string `json:"state,omitempty"` // "idle", "processing", "error"
 	LatestSeq      uint64 `json:"latest_seq,omitempty"`
 	CurrentModel   string `json:"current_model,omitempty"`
 	PermissionMode string `json:"permission_mode,omitempty"`
 	UptimeMs       uint64 `json:"uptime_ms,omitempty"`
+
+	// ProtocolVersion is the agent protocol version, once known from a handshake or refresh
+	ProtocolVersion *int32 `json:"protocol_version,omitempty"`
+
+	// Quarantine is set while the agent is quarantined and refuses new messages
+	Quarantine *processor.Quarantine `json:"quarantine,omitempty"`
+
+	// Status is the agent's real-time status, present when asked for with refresh=true or
+	// include=status. It is null, and StatusError says why, if the agent could not be asked.
+	Status      AgentStatusField `json:"status,omitzero"`
+	StatusError string           `json:"status_error,omitempty"`
 }
 
-// ListAgentsResponse is the response for listing agents
+// ContainerState is the state of a container: "running", "waiting" or "terminated", and
+// for the latter two why
+type ContainerState struct {
+	State    string `json:"state"`
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+	ExitCode *int32 `json:"exit_code,omitempty"`
+	// LastState is how the container's previous run ended, if it restarted
+	LastState *ContainerState `json:"last_state,omitempty"`
+}
+
+// containerState converts a Kubernetes container state, nil if it has none
+func containerState(s corev1.ContainerState) *ContainerState {
+	switch {
+	case s.Running != nil:
+		return &ContainerState{State: "running"}
+	case s.Waiting != nil:
+		return &ContainerState{State: "waiting", Reason: s.Waiting.Reason, Message: s.Waiting.Message}
+	case s.Terminated != nil:
+		exitCode := s.Terminated.ExitCode
+		return &ContainerState{State: "terminated", Reason: s.Terminated.Reason, Message: s.Terminated.Message, ExitCode: &exitCode}
+	default:
+		return nil
+	}
+}
+
+// AgentStatus is an agent's real-time status, as reported by the agent at most
+// processor.StatusCacheTTL ago
+type AgentStatus struct {
+	SessionID       string `json:"session_id"`
+	State           string `json:"state"` // "idle", "processing", "error"
+	LatestSeq       uint64 `json:"latest_seq"`
+	CurrentModel    string `json:"current_model"`
+	PermissionMode  string `json:"permission_mode"`
+	UptimeMs        uint64 `json:"uptime_ms"`
+	ProtocolVersion int32  `json:"protocol_version"`
+}
+
+// AgentStatusField is the status of an AgentResponse: omitted unless Requested, and null if
+// requested without a Status
+type AgentStatusField struct {
+	Requested bool
+	Status    *AgentStatus
+}
+
+// IsZero reports whether the status was not requested, omitting it from the response
+func (f AgentStatusField) IsZero() bool {
+	return !f.Requested
+}
+
+// MarshalJSON encodes the status, or null
+func (f AgentStatusField) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Status)
+}
+
+// UnmarshalJSON decodes a status that is present, if null
+func (f *AgentStatusField) UnmarshalJSON(data []byte) error {
+	f.Requested = true
+	return json.Unmarshal(data, &f.Status)
+}
+
+// ListAgentsResponse is the response for listing agents.
+//
+// If details could not be fetched for some agents, those agents are still listed with
+// their IDs only, Partial is true, and Warnings explains each one. The status code
+// stays 200 so clients that ignore the flag keep working; check Partial before
+// trusting the per-agent fields.
 type ListAgentsResponse struct {
-	Agents []AgentResponse `json:"agents"`
-	Total  int             `json:"total"`
+	Agents   []AgentResponse `json:"agents"`
+	Total    int             `json:"total"`
+	Partial  bool            `json:"partial"`
+	Warnings []ListWarning   `json:"warnings,omitempty"`
+}
+
+// ListWarning describes an agent whose details could not be fetched during List
+type ListWarning struct {
+	AgentID string `json:"agent_id"`
+	Reason  string `json:"reason"`
+}
+
+// Outcomes of the agents deleted by DeleteAll
+const (
+	DeletionShutDown     = "shut_down"
+	DeletionForceDeleted = "force_deleted"
+)
+
+// DeleteAllAgentsResponse is the 207 response for DELETE /api/v1/agents, one result per agent
+type DeleteAllAgentsResponse struct {
+	Agents []AgentDeletionResult `json:"agents"`
+	Total  int                   `json:"total"`
+}
+
+// AgentDeletionResult says how an agent was deleted: shut down gracefully, or force-deleted
+// without a graceful shutdown, which Error explains if one was requested
+type AgentDeletionResult struct {
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
 }
 
 // podToAgentResponse converts a K8s Pod to AgentResponse
@@ -76,12 +390,41 @@ func podToAgentResponse(pod *corev1.Pod) AgentResponse {
 		Phase:   pod.Status.Phase,
 		Ready:   isPodReady(pod),
 	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == k8s.AgentContainerName {
+			resp.Image = c.Image
+		}
+	}
 	if pod.Status.PodIP != "" {
 		resp.PodIP = pod.Status.PodIP
 	}
+	resp.NodePort = k8s.NodePort(pod)
+	resp.NodeName = pod.Spec.NodeName
+	for _, cs := range pod.Status.ContainerStatuses {
+		resp.RestartCount += cs.RestartCount
+		if cs.Name != k8s.AgentContainerName {
+			continue
+		}
+		if state := containerState(cs.State); state != nil {
+			state.LastState = containerState(cs.LastTerminationState)
+			resp.ContainerState = state
+		}
+		if cs.State.Running != nil && !cs.State.Running.StartedAt.IsZero() {
+			resp.StartedAt = cs.State.Running.StartedAt.Format(time.RFC3339)
+		}
+	}
 	if !pod.CreationTimestamp.IsZero() {
 		resp.CreatedAt = pod.CreationTimestamp.Format(time.RFC3339)
 	}
+	if raw, ok := pod.Annotations[k8s.ProtocolVersionAnnotation]; ok {
+		if parsed, err := strconv.ParseInt(raw, 10, 32); err == nil {
+			version := int32(parsed)
+			resp.ProtocolVersion = &version
+		}
+	}
+	if q, ok := processor.QuarantineOf(pod); ok {
+		resp.Quarantine = q
+	}
 	return resp
 }
 
@@ -98,21 +441,87 @@ func isPodReady(pod *corev1.Pod) bool {
 	return true
 }
 
-// Create handles POST /api/v1/agents
+// createResponseGrace is how long a synchronous agent creation's response may take to write
+// after AGENT_CREATE_TIMEOUT
+const createResponseGrace = 10 * time.Second
+
+// Create handles POST /api/v1/agents and POST /api/v1/users/:user_id/agents. With
+// "wait": false or ?async=true it answers 202 once the pod is created.
 func (h *Handler) Create(c echo.Context) error {
 	var req CreateAgentRequest
-	if err := c.Bind(&req); err != nil {
-		return errors.BadRequest("invalid request body")
+	if err := handler.Bind(c, &req); err != nil {
+		return err
 	}
 
+	// On the user-scoped route the owner is the path's user
+	if userID := c.Param("user_id"); userID != "" {
+		if req.OwnerID != "" && req.OwnerID != userID {
+			return errors.BadRequest("owner_id must match the user in the path")
+		}
+		req.OwnerID = userID
+	}
+	// Callers who are not admins create agents for themselves
+	if p, ok := handler.PrincipalFrom(c); ok && !p.Admin {
+		if req.OwnerID != "" && req.OwnerID != p.UserID {
+			return errors.Forbidden("not allowed to act for user " + req.OwnerID)
+		}
+		req.OwnerID = p.UserID
+	}
 	if req.OwnerID == "" {
 		return errors.BadRequest("owner_id is required")
 	}
 
+	opts := k8s.PodOptions{ImageTag: req.ImageTag, Model: req.Model, PermissionMode: req.PermissionMode}
+	if req.Workspace != nil {
+		ws, err := req.Workspace.workspace()
+		if err != nil {
+			return err
+		}
+		opts.Workspace = ws
+	}
+	if req.Repository != nil {
+		repo, err := req.Repository.repository()
+		if err != nil {
+			return err
+		}
+		opts.Repository = repo
+	}
+
 	ctx := c.Request().Context()
-	podID, err := h.processor.CreateAgent(ctx, req.OwnerID)
+	async := req.async(c)
+	create := h.processor.CreateAgentWithOptions
+	if async {
+		create = h.processor.CreateAgentAsync
+	} else {
+		// The readiness wait may outlast the server's write timeout, so extend the deadline
+		// of this response past AGENT_CREATE_TIMEOUT
+		var deadline time.Time
+		if timeout := h.processor.CreateTimeout(); timeout > 0 {
+			deadline = time.Now().Add(timeout + createResponseGrace)
+		}
+		_ = http.NewResponseController(c.Response()).SetWriteDeadline(deadline)
+	}
+	podID, err := create(ctx, req.OwnerID, opts)
 	if err != nil {
-		return errors.ServiceUnavailable(err.Error())
+		if stderrors.Is(err, k8s.ErrImageTagNotAllowed) || stderrors.Is(err, processor.ErrSettingNotAllowed) ||
+			stderrors.Is(err, k8s.ErrRepositorySecret) {
+			return errors.BadRequest(err.Error())
+		}
+		var failure *k8s.PodFailureError
+		if stderrors.As(err, &failure) {
+			return podFailureError(failure)
+		}
+		return agentError(err, errors.ServiceUnavailable)
+	}
+
+	// Readiness is polled with GET or told by the agent.ready lifecycle webhook
+	if async {
+		return c.JSON(http.StatusAccepted, AgentResponse{
+			UserID:  podID.UserID,
+			AgentID: podID.AgentID,
+			PodName: podID.Name(),
+			Phase:   corev1.PodPending,
+		})
 	}
 
 	// Fetch full pod details for the response
@@ -131,45 +540,118 @@ func (h *Handler) Create(c echo.Context) error {
 	return c.JSON(http.StatusCreated, podToAgentResponse(pod))
 }
 
-// List handles GET /api/v1/agents?user_id=xxx
+// List handles GET /api/v1/agents?user_id=xxx, narrowed and ordered by the phase, ready,
+// created_after, created_before and sort query params
 func (h *Handler) List(c echo.Context) error {
-	userID := c.QueryParam("user_id")
+	userID := userIDParam(c)
 	if userID == "" {
 		return errors.BadRequest("user_id query param is required")
 	}
+	filter, err := listFilter(c)
+	if err != nil {
+		return err
+	}
 
 	ctx := c.Request().Context()
-	podIDs, err := h.processor.ListAgents(ctx, userID)
+	podIDs, err := h.processor.ListAgentsFiltered(ctx, userID, filter)
 	if err != nil {
-		return errors.InternalError(err.Error())
+		return agentError(err, errors.InternalError)
 	}
 
 	agents := make([]AgentResponse, 0, len(podIDs))
+	var warnings []ListWarning
 	for _, podID := range podIDs {
 		// Fetch full pod details for each agent
 		pod, err := h.processor.GetAgent(ctx, podID.UserID, podID.AgentID)
 		if err != nil {
-			// If we can't get pod details, include basic info
+			// If we can't get pod details, include basic info and say why
 			agents = append(agents, AgentResponse{
 				UserID:  podID.UserID,
 				AgentID: podID.AgentID,
 				PodName: podID.Name(),
 			})
+			warnings = append(warnings, ListWarning{
+				AgentID: podID.AgentID,
+				Reason:  err.Error(),
+			})
 			continue
 		}
 		agents = append(agents, podToAgentResponse(pod))
 	}
 
+	if wantsStatus(c) {
+		var wg sync.WaitGroup
+		for i := range agents {
+			if agents[i].Phase == "" {
+				continue // no pod details
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.addStatus(ctx, &agents[i])
+			}()
+		}
+		wg.Wait()
+	}
+
 	return c.JSON(http.StatusOK, ListAgentsResponse{
-		Agents: agents,
-		Total:  len(agents),
+		Agents:   agents,
+		Total:    len(agents),
+		Partial:  len(warnings) > 0,
+		Warnings: warnings,
 	})
 }
 
-// Get handles GET /api/v1/agents/:id?user_id=xxx&refresh=true
+// listFilter returns the agent filter of a List request's query params
+func listFilter(c echo.Context) (processor.AgentFilter, error) {
+	var filter processor.AgentFilter
+
+	if phase := c.QueryParam("phase"); phase != "" {
+		switch p := corev1.PodPhase(phase); p {
+		case corev1.PodPending, corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed:
+			filter.Phase = p
+		default:
+			return filter, errors.BadRequest("phase must be one of Pending, Running, Succeeded, Failed")
+		}
+	}
+	if ready := c.QueryParam("ready"); ready != "" {
+		parsed, err := strconv.ParseBool(ready)
+		if err != nil {
+			return filter, errors.BadRequest("ready must be true or false")
+		}
+		filter.Ready = &parsed
+	}
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+	} {
+		if raw := c.QueryParam(param.name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return filter, errors.BadRequest(param.name + " must be an RFC 3339 timestamp")
+			}
+			*param.dst = parsed
+		}
+	}
+
+	switch sort := processor.AgentSort(c.QueryParam("sort")); sort {
+	case "":
+		filter.Sort = processor.SortAgentID
+	case processor.SortAgentID, processor.SortCreatedAsc, processor.SortCreatedDesc:
+		filter.Sort = sort
+	default:
+		return filter, errors.BadRequest("sort must be one of agent_id, created_at, -created_at")
+	}
+	return filter, nil
+}
+
+// Get handles GET /api/v1/agents/:agent_id?user_id=xxx&refresh=true
 func (h *Handler) Get(c echo.Context) error {
-	agentID := c.Param("id")
-	userID := c.QueryParam("user_id")
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
 	if userID == "" {
 		return errors.BadRequest("user_id query param is required")
 	}
@@ -177,28 +659,100 @@ func (h *Handler) Get(c echo.Context) error {
 	ctx := c.Request().Context()
 	pod, err := h.processor.GetAgent(ctx, userID, agentID)
 	if err != nil {
-		return errors.NotFound(err.Error())
+		return agentError(err, errors.InternalError)
 	}
 
 	resp := podToAgentResponse(pod)
+	if wantsStatus(c) {
+		h.addStatus(ctx, &resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
 
-	// Optionally fetch real-time status from the agent via RPC
-	if c.QueryParam("refresh") == "true" && resp.Ready {
-		status, err := h.processor.GetStatus(ctx, userID, agentID)
-		if err == nil {
-			resp.SessionID = status.SessionId
-			resp.State = agentStateToString(status.State)
-			resp.LatestSeq = uint64(status.LatestSeq)
-			resp.CurrentModel = status.CurrentModel
-			resp.PermissionMode = status.PermissionMode
-			resp.UptimeMs = uint64(status.UptimeMs)
+// Update handles PATCH /api/v1/agents/:agent_id?user_id=xxx, changing the model and
+// permission mode of a running agent. It answers with the agent and its new status.
+func (h *Handler) Update(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	var req AgentSettingsRequest
+	if err := handler.Bind(c, &req); err != nil {
+		return err
+	}
+	if req.Model == "" && req.PermissionMode == "" {
+		return errors.BadRequest("model or permission_mode is required")
+	}
+
+	ctx := c.Request().Context()
+	pod, err := h.processor.GetAgent(ctx, userID, agentID)
+	if err != nil {
+		return agentError(err, errors.InternalError)
+	}
+	if err := h.processor.ConfigureAgent(ctx, userID, agentID, req.settings()); err != nil {
+		switch {
+		case stderrors.Is(err, processor.ErrSettingNotAllowed):
+			return errors.BadRequest(err.Error())
+		case stderrors.Is(err, processor.ErrSettingRejected):
+			return errors.UnprocessableEntity(err.Error())
 		}
-		// If GetStatus fails, we still return the pod info without agent state
+		return agentError(err, errors.ServiceUnavailable)
 	}
 
+	resp := podToAgentResponse(pod)
+	h.addStatus(ctx, &resp)
 	return c.JSON(http.StatusOK, resp)
 }
 
+// wantsStatus reports whether a request asks for the agents' real-time status, with
+// refresh=true or include=status
+func wantsStatus(c echo.Context) bool {
+	if c.QueryParam("refresh") == "true" {
+		return true
+	}
+	for _, include := range strings.Split(c.QueryParam("include"), ",") {
+		if strings.TrimSpace(include) == "status" {
+			return true
+		}
+	}
+	return false
+}
+
+// addStatus adds the agent's real-time status to resp, or why it could not be fetched. A
+// failure leaves the rest of resp intact.
+func (h *Handler) addStatus(ctx context.Context, resp *AgentResponse) {
+	resp.Status.Requested = true
+	if !resp.Ready {
+		resp.StatusError = processor.ErrAgentNotReady.Error()
+		return
+	}
+	status, err := h.processor.CachedStatus(ctx, resp.UserID, resp.AgentID)
+	if err != nil {
+		resp.StatusError = err.Error()
+		return
+	}
+
+	resp.Status.Status = &AgentStatus{
+		SessionID:       status.SessionId,
+		State:           agentStateToString(status.State),
+		LatestSeq:       uint64(status.LatestSeq),
+		CurrentModel:    status.CurrentModel,
+		PermissionMode:  status.PermissionMode,
+		UptimeMs:        uint64(status.UptimeMs),
+		ProtocolVersion: status.ProtocolVersion,
+	}
+	resp.SessionID = status.SessionId
+	resp.State = agentStateToString(status.State)
+	resp.LatestSeq = uint64(status.LatestSeq)
+	resp.CurrentModel = status.CurrentModel
+	resp.PermissionMode = status.PermissionMode
+	resp.UptimeMs = uint64(status.UptimeMs)
+	resp.ProtocolVersion = &status.ProtocolVersion
+}
+
 // agentStateToString converts the protobuf AgentState enum to a human-readable string
 func agentStateToString(state agentv1.AgentState) string {
 	switch state {
@@ -213,10 +767,10 @@ func agentStateToString(state agentv1.AgentState) string {
 	}
 }
 
-// Delete handles DELETE /api/v1/agents/:id
+// Delete handles DELETE /api/v1/agents/:agent_id
 func (h *Handler) Delete(c echo.Context) error {
-	agentID := c.Param("id")
-	userID := c.QueryParam("user_id")
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
 	graceful := c.QueryParam("graceful") == "true"
 
 	if userID == "" {
@@ -224,8 +778,62 @@ func (h *Handler) Delete(c echo.Context) error {
 	}
 
 	if err := h.processor.DeleteAgent(c.Request().Context(), userID, agentID, graceful); err != nil {
-		return errors.NotFound(err.Error())
+		return agentError(err, errors.InternalError)
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
+
+// DeleteAll handles DELETE /api/v1/agents?user_id=xxx&graceful=true, deleting all of the
+// user's agents
+func (h *Handler) DeleteAll(c echo.Context) error {
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+	graceful := c.QueryParam("graceful") == "true"
+
+	deletions, err := h.processor.DeleteAllAgents(c.Request().Context(), userID, graceful)
+	if err != nil {
+		return agentError(err, errors.InternalError)
+	}
+
+	resp := DeleteAllAgentsResponse{Agents: make([]AgentDeletionResult, 0, len(deletions)), Total: len(deletions)}
+	for _, d := range deletions {
+		result := AgentDeletionResult{AgentID: d.AgentID, Status: DeletionForceDeleted}
+		if d.Graceful {
+			result.Status = DeletionShutDown
+		}
+		if d.ShutdownErr != nil {
+			result.Error = d.ShutdownErr.Error()
+		}
+		resp.Agents = append(resp.Agents, result)
+	}
+	return c.JSON(http.StatusMultiStatus, resp)
+}
+
+// UnquarantineResponse is the response for POST /api/v1/admin/agents/:agent_id/unquarantine
+type UnquarantineResponse struct {
+	AgentID string `json:"agent_id"`
+	// WasQuarantined is false if the agent was not quarantined, in which case nothing changed
+	WasQuarantined bool `json:"was_quarantined"`
+}
+
+// Unquarantine handles POST /api/v1/admin/agents/:agent_id/unquarantine?user_id=xxx
+func (h *Handler) Unquarantine(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	wasQuarantined, err := h.processor.Unquarantine(c.Request().Context(), userID, agentID)
+	if err != nil {
+		return agentError(err, errors.InternalError)
+	}
+
+	return c.JSON(http.StatusOK, UnquarantineResponse{
+		AgentID:        agentID,
+		WasQuarantined: wasQuarantined,
+	})
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	stderrors "errors"
+	"net/http"
+	"strconv"
+
+	"connectrpc.com/connect"
+	"github.com/labstack/echo/v4"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/forge/platform/internal/agent"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/webhook"
+)
+
+const (
+	defaultListMessagesLimit = 100
+	maxListMessagesLimit     = 1000
+)
+
+// Sources a page of an agent's message history is served from
+const (
+	// MessageSourceAgent is the running agent's own response history
+	MessageSourceAgent = "agent"
+	// MessageSourceDatabase is the history stored as the agent's responses were relayed
+	MessageSourceDatabase = "database"
+)
+
+// ListMessagesResponse is a page of an agent's message history
+type ListMessagesResponse struct {
+	// Messages have the shape of the webhook payloads the agent's responses were relayed as
+	Messages []webhook.Payload `json:"messages"`
+	// LatestSeq is the highest seq the agent has sent
+	LatestSeq uint64 `json:"latest_seq"`
+	// NextSeq is the from_seq of the next page
+	NextSeq uint64 `json:"next_seq"`
+	// InProgress is set while the agent is working on a request, so more messages may follow
+	InProgress bool `json:"in_progress"`
+	// Source is where the page was served from: the agent, or the database once the agent
+	// is not running
+	Source string `json:"source"`
+}
+
+// ListMessages handles GET /api/v1/agents/:agent_id/messages, paging through the responses
+// the agent sent from from_seq onwards. A ready agent serves its own history, which is
+// bounded, so older messages may be gone; otherwise the messages stored as its responses
+// were relayed are served. A from_seq past the latest seq returns no messages.
+func (h *Handler) ListMessages(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	var fromSeq uint64
+	if raw := c.QueryParam("from_seq"); raw != "" {
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return errors.BadRequest("from_seq must be a non-negative integer")
+		}
+		fromSeq = n
+	}
+
+	limit := defaultListMessagesLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxListMessagesLimit {
+			return errors.BadRequest("limit must be between 1 and " + strconv.Itoa(maxListMessagesLimit))
+		}
+		limit = n
+	}
+
+	ctx := c.Request().Context()
+	history, err := h.processor.CatchUp(ctx, userID, agentID, fromSeq, int32(limit))
+	switch {
+	case err == nil:
+	case apierrors.IsNotFound(err), stderrors.Is(err, processor.ErrAgentNotReady):
+		return h.listStoredMessages(c, userID, agentID, fromSeq, int32(limit), err)
+	case connect.CodeOf(err) == connect.CodeUnimplemented:
+		return errors.ServiceUnavailable(err.Error()).WithErrorCode(agent.ErrorCodeUnsupportedByAgent)
+	default:
+		return errors.ServiceUnavailable(err.Error())
+	}
+
+	resp := ListMessagesResponse{
+		Messages:   make([]webhook.Payload, 0, len(history.GetResponses())),
+		LatestSeq:  history.GetLatestSeq(),
+		NextSeq:    max(fromSeq, history.GetLatestSeq()+1),
+		InProgress: history.GetInProgress(),
+		Source:     MessageSourceAgent,
+	}
+	for _, msg := range history.GetResponses() {
+		// Artifacts are listed on their request's final payload instead
+		if msg.GetArtifact() == nil {
+			resp.Messages = append(resp.Messages, webhook.AgentResponseToPayload(msg, agentID, msg.GetRequestId()))
+		}
+	}
+	if n := len(history.GetResponses()); n > 0 {
+		resp.NextSeq = history.GetResponses()[n-1].GetSeq() + 1
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// listStoredMessages serves a page of an agent's stored message history for an agent that
+// is not running, which the agent could not answer with agentErr. An agent that no longer
+// exists and has no stored messages is not found.
+func (h *Handler) listStoredMessages(c echo.Context, userID, agentID string, fromSeq uint64, limit int32, agentErr error) error {
+	messages, latest, err := h.processor.StoredMessages(c.Request().Context(), userID, agentID, fromSeq, limit)
+	switch {
+	case err == nil:
+	case !stderrors.Is(err, processor.ErrNoMessageStorage):
+		return errors.InternalError("failed to list stored messages: " + err.Error())
+	case apierrors.IsNotFound(agentErr):
+		return errors.NotFound(agentErr.Error())
+	default:
+		return errors.Conflict(agentErr.Error())
+	}
+	if latest == 0 && apierrors.IsNotFound(agentErr) {
+		return errors.NotFound(agentErr.Error())
+	}
+
+	resp := ListMessagesResponse{
+		Messages:  messages,
+		LatestSeq: latest,
+		NextSeq:   max(fromSeq, latest+1),
+		Source:    MessageSourceDatabase,
+	}
+	if n := len(messages); n > 0 {
+		resp.NextSeq = messages[n-1].Seq + 1
+	}
+	return c.JSON(http.StatusOK, resp)
+}
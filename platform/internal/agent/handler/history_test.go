@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/agent"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// historyAgentService answers CatchUp from a fixed response history
+type historyAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	history []*agentv1.AgentResponse
+}
+
+func (s *historyAgentService) CatchUp(
+	_ context.Context,
+	req *connect.Request[agentv1.CatchUpRequest],
+) (*connect.Response[agentv1.CatchUpResponse], error) {
+	resp := &agentv1.CatchUpResponse{LatestSeq: s.history[len(s.history)-1].GetSeq()}
+	for _, msg := range s.history {
+		if msg.GetSeq() >= req.Msg.GetFromSeq() && len(resp.Responses) < int(req.Msg.GetLimit()) {
+			resp.Responses = append(resp.Responses, msg)
+		}
+	}
+	return connect.NewResponse(resp), nil
+}
+
+func (f *fakeBatchQuerier) InsertAgentMessage(_ context.Context, arg *sqlc.InsertAgentMessageParams) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.messages {
+		if m.AgentID == arg.AgentID && m.SessionID == arg.SessionID && m.Seq == arg.Seq {
+			return 0, nil
+		}
+	}
+	f.messages = append(f.messages, &sqlc.AgentMessage{
+		ID:        int64(len(f.messages) + 1),
+		UserID:    arg.UserID,
+		AgentID:   arg.AgentID,
+		SessionID: arg.SessionID,
+		RequestID: arg.RequestID,
+		Seq:       arg.Seq,
+		Role:      arg.Role,
+		Payload:   arg.Payload,
+	})
+	return 1, nil
+}
+
+func (f *fakeBatchQuerier) ListAgentMessages(_ context.Context, arg *sqlc.ListAgentMessagesParams) ([]*sqlc.AgentMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := []*sqlc.AgentMessage{}
+	for _, m := range f.messages {
+		if m.UserID == arg.UserID && m.AgentID == arg.AgentID && m.Seq >= arg.FromSeq && len(items) < int(arg.MaxRows) {
+			items = append(items, m)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeBatchQuerier) GetLatestAgentMessageSeq(_ context.Context, arg *sqlc.GetLatestAgentMessageSeqParams) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var latest int64
+	for _, m := range f.messages {
+		if m.UserID == arg.UserID && m.AgentID == arg.AgentID {
+			latest = max(latest, m.Seq)
+		}
+	}
+	return latest, nil
+}
+
+func agentHistory() []*agentv1.AgentResponse {
+	history := []*agentv1.AgentResponse{
+		eventResponse(1, "message.updated", `{"type":"message.updated"}`),
+		artifactResponse(2, "report.md", "text/markdown", "# Report"),
+		eventResponse(3, "message.part.updated", `{"type":"message.part.updated"}`),
+		{Seq: 4, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}},
+	}
+	for _, msg := range history {
+		msg.RequestId = "req-1"
+	}
+	return history
+}
+
+func getMessages(t *testing.T, e *echo.Echo, query string) (int, ListMessagesResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users/user1/agents/agent1/messages"+query, nil))
+	var resp ListMessagesResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestListMessages_PagesThroughHistory(t *testing.T) {
+	port := startMockAgent(t, &historyAgentService{history: agentHistory()})
+	e := setupTestHandler(t, createNodePortProcessor(t, "user1", "agent1", port))
+
+	code, page := getMessages(t, e, "?limit=2")
+	if code != http.StatusOK || page.Source != MessageSourceAgent {
+		t.Fatalf("expected 200 from the agent, got %d from %q", code, page.Source)
+	}
+	// The artifact is left out, as it is from webhook payloads
+	if len(page.Messages) != 1 || page.NextSeq != 3 || page.LatestSeq != 4 {
+		t.Fatalf("expected seq 1 then next_seq 3 of 4, got %+v", page)
+	}
+	first := page.Messages[0]
+	if first.EventType != webhook.EventTypeEvent || first.RequestID != "req-1" || first.AgentID != "agent1" ||
+		first.OpenCodeEventType != "message.updated" || string(first.Event) != `{"type":"message.updated"}` {
+		t.Errorf("expected the event as a webhook payload, got %+v", first)
+	}
+
+	code, page = getMessages(t, e, "?from_seq=3")
+	if code != http.StatusOK || len(page.Messages) != 2 || page.NextSeq != 5 {
+		t.Fatalf("expected seqs 3 and 4, got %d %+v", code, page)
+	}
+	if last := page.Messages[1]; last.EventType != webhook.EventTypeComplete || !last.IsFinal || !last.Success {
+		t.Errorf("expected the final agent.complete, got %+v", last)
+	}
+
+	code, page = getMessages(t, e, "?from_seq=100")
+	if code != http.StatusOK || len(page.Messages) != 0 || page.LatestSeq != 4 || page.NextSeq != 100 {
+		t.Errorf("expected no messages and latest_seq 4 past the history, got %d %+v", code, page)
+	}
+}
+
+func TestListMessages_Errors(t *testing.T) {
+	port := startMockAgent(t, &historyAgentService{history: agentHistory()})
+	e := setupTestHandler(t, createNodePortProcessor(t, "user1", "agent1", port))
+	for _, query := range []string{"?from_seq=-1", "?limit=0", "?limit=1001"} {
+		if code, _ := getMessages(t, e, query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+
+	e = setupTestHandler(t, createTestProcessor(t, createPendingPod("user1", "agent1")))
+	if code, _ := getMessages(t, e, ""); code != http.StatusConflict {
+		t.Errorf("pending pod: expected 409, got %d", code)
+	}
+
+	e = setupTestHandler(t, createTestProcessor(t))
+	if code, _ := getMessages(t, e, ""); code != http.StatusNotFound {
+		t.Errorf("missing agent: expected 404, got %d", code)
+	}
+
+	port = startMockAgent(t, &scriptedAgentService{})
+	e = setupTestHandler(t, createNodePortProcessor(t, "user1", "agent1", port))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users/user1/agents/agent1/messages", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("agent without CatchUp: expected 503, got %d", rec.Code)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != agent.ErrorCodeUnsupportedByAgent {
+		t.Errorf("expected error %s, got %s", agent.ErrorCodeUnsupportedByAgent, rec.Body.String())
+	}
+}
+
+func TestListMessages_FallsBackToStoredMessages(t *testing.T) {
+	querier := newFakeBatchQuerier()
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	ctx := context.Background()
+	for _, msg := range agentHistory() {
+		if msg.GetArtifact() != nil {
+			continue
+		}
+		msg.SessionId = "ses-1"
+		payload := webhook.AgentResponseToPayload(msg, "agent1", msg.GetRequestId())
+		// Stored twice, as after a relay resumed its stream
+		for range 2 {
+			if err := delivery.RecordMessage(ctx, "user1", payload); err != nil {
+				t.Fatalf("RecordMessage: %v", err)
+			}
+		}
+	}
+	if len(querier.messages) != 3 {
+		t.Fatalf("expected each message stored once, got %d", len(querier.messages))
+	}
+
+	newHandler := func(objects ...runtime.Object) *echo.Echo {
+		clientset := fake.NewSimpleClientset(objects...)
+		mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "")
+		return setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop()))
+	}
+
+	for name, e := range map[string]*echo.Echo{
+		"pending pod": newHandler(createPendingPod("user1", "agent1")),
+		"deleted pod": newHandler(),
+	} {
+		code, page := getMessages(t, e, "?from_seq=2&limit=1")
+		if code != http.StatusOK || page.Source != MessageSourceDatabase {
+			t.Fatalf("%s: expected 200 from the database, got %d from %q", name, code, page.Source)
+		}
+		if len(page.Messages) != 1 || page.Messages[0].Seq != 3 || page.NextSeq != 4 || page.LatestSeq != 4 || page.InProgress {
+			t.Errorf("%s: expected seq 3 then next_seq 4 of 4, got %+v", name, page)
+		}
+		if msg := page.Messages[0]; msg.OpenCodeEventType != "message.part.updated" || msg.SessionID != "ses-1" || msg.RequestID != "req-1" {
+			t.Errorf("%s: expected the stored payload, got %+v", name, msg)
+		}
+	}
+
+	e := newHandler()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users/user1/agents/agent2/messages", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("deleted agent without messages: expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// lifecycleAgentEnv makes the test binary run as the agent of the local orchestrator (see
+// runLifecycleAgent)
+const lifecycleAgentEnv = "FORGE_HANDLER_TEST_AGENT"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(lifecycleAgentEnv) != "" {
+		runLifecycleAgent()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// lifecycleAgent is the agent testAgentLifecycle talks to: it reports its status, answers
+// every message with a completion, and exits when asked to shut down
+type lifecycleAgent struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	status  statusAgentService
+	replies scriptedAgentService
+}
+
+func newLifecycleAgent() *lifecycleAgent {
+	return &lifecycleAgent{
+		replies: scriptedAgentService{
+			responses: []*agentv1.AgentResponse{
+				eventResponse(1, "message.updated", `{"type":"message.updated"}`),
+				{
+					Seq:     2,
+					State:   agentv1.AgentState_AGENT_STATE_IDLE,
+					Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+				},
+			},
+		},
+	}
+}
+
+func (a *lifecycleAgent) GetStatus(ctx context.Context, req *connect.Request[agentv1.GetStatusRequest]) (*connect.Response[agentv1.GetStatusResponse], error) {
+	return a.status.GetStatus(ctx, req)
+}
+
+func (a *lifecycleAgent) Connect(ctx context.Context, stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse]) error {
+	return a.replies.Connect(ctx, stream)
+}
+
+func (a *lifecycleAgent) Shutdown(context.Context, *connect.Request[agentv1.ShutdownRequest]) (*connect.Response[agentv1.ShutdownResponse], error) {
+	if os.Getenv(lifecycleAgentEnv) != "" {
+		// Exit once the response is written
+		time.AfterFunc(10*time.Millisecond, func() { os.Exit(0) })
+	}
+	return connect.NewResponse(&agentv1.ShutdownResponse{}), nil
+}
+
+// runLifecycleAgent serves the lifecycle agent on $PORT until it is shut down
+func runLifecycleAgent() {
+	mux := http.NewServeMux()
+	mux.Handle(agentv1connect.NewAgentServiceHandler(newLifecycleAgent()))
+	server := &http.Server{Addr: "127.0.0.1:" + os.Getenv("PORT"), Handler: h2c.NewHandler(mux, &http2.Server{})}
+	_ = server.ListenAndServe()
+	os.Exit(1)
+}
+
+// testAgentLifecycle creates an agent through e, reads it, lists it, messages it, deletes
+// it, and checks it is gone, as a client of the API would
+func testAgentLifecycle(t *testing.T, e *echo.Echo) {
+	t.Helper()
+	rec, created := postCreate(e, "/api/v1/agents", `{"owner_id":"user1"}`)
+	if rec.Code != http.StatusCreated || !created.Ready || created.AgentID == "" {
+		t.Fatalf("expected the ready agent with %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	path := "/api/v1/agents/" + created.AgentID
+
+	got, _ := getAgent(t, e, path+"?user_id=user1&include=status")
+	if got.Status.Status == nil || got.Status.Status.State != "processing" {
+		t.Errorf("expected the agent's status, got %+v (status_error %q)", got.Status.Status, got.StatusError)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents?user_id=user1", nil))
+	var list ListAgentsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("failed to unmarshal the list: %v", err)
+	}
+	if rec.Code != http.StatusOK || list.Total != 1 || list.Agents[0].AgentID != created.AgentID {
+		t.Errorf("expected the list of the agent, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postSSE(e, path+"/messages?user_id=user1", `{"content":"hi","request_id":"req_lifecycle"}`)
+	events := readSSEEvents(t, rec.Body.String())
+	if rec.Code != http.StatusOK || len(events) == 0 {
+		t.Fatalf("expected the agent's events, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if last := events[len(events)-1].payload; !last.IsFinal || !last.Success {
+		t.Errorf("expected a final successful completion, got %+v", last)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path+"?user_id=user1", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the deletion with %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?user_id=user1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected the deleted agent to be gone with %d, got %d: %s", http.StatusNotFound, rec.Code, rec.Body.String())
+	}
+}
+
+func TestAgentLifecycle_Kubernetes(t *testing.T) {
+	// Agent pods are created ready, and their NodePort services route to the mock agent
+	port := startMockAgent(t, newLifecycleAgent())
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).Status = corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			PodIP:             "10.0.0.1",
+			ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+		}
+		return false, nil, nil
+	})
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		svc := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+		for i := range svc.Spec.Ports {
+			svc.Spec.Ports[i].NodePort = port
+		}
+		return false, nil, nil
+	})
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1")
+
+	testAgentLifecycle(t, setupTestHandler(t, processor.NewProcessor(mgr, nil, zap.NewNop())))
+}
+
+func TestAgentLifecycle_Local(t *testing.T) {
+	// The local orchestrator runs the test binary as the agent, on a port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+	t.Setenv(lifecycleAgentEnv, "1")
+	orchestrator, err := k8s.NewLocalOrchestrator(k8s.LocalOrchestratorOpts{
+		Binary:      os.Args[0],
+		FirstPort:   port,
+		LastPort:    port,
+		Namespace:   testNamespace,
+		StopTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orchestrator.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = orchestrator.Stop(context.Background()) })
+	mgr := k8s.NewManagerWithOrchestrator(orchestrator, testNamespace, "test-image:latest")
+
+	testAgentLifecycle(t, setupTestHandler(t, processor.NewProcessor(mgr, nil, zap.NewNop())))
+}
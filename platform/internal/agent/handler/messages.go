@@ -4,20 +4,78 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	stderrors "errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/forge/platform/internal/agent/processor"
 	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
 	"github.com/forge/platform/internal/webhook"
 )
 
+// BudgetWarningHeader is set on the responses to messages of a user who has spent their
+// soft budget
+const BudgetWarningHeader = "X-Forge-Budget-Warning"
+
+// maxWebhookEndpoints is the most webhook endpoints a single message can fan out to
+const maxWebhookEndpoints = 10
+
 // SendMessageRequest is the request body for sending a message to an agent
 type SendMessageRequest struct {
-	Content       string `json:"content"`
-	WebhookURL    string `json:"webhook_url"`
+	Content       string `json:"content" validate:"required,max=524288"`
+	WebhookURL    string `json:"webhook_url" validate:"omitempty,url"`
 	WebhookSecret string `json:"webhook_secret,omitempty"`
-	RequestID     string `json:"request_id,omitempty"`
+	RequestID     string `json:"request_id,omitempty" validate:"max=128"`
+
+	// WebhookSecondarySecret also signs deliveries to webhook_url while its secret is rotated
+	WebhookSecondarySecret string `json:"webhook_secondary_secret,omitempty"`
+
+	// WebhookHeaders and WebhookBearerToken are sent with every delivery to webhook_url
+	WebhookHeaders     map[string]string `json:"webhook_headers,omitempty"`
+	WebhookBearerToken string            `json:"webhook_bearer_token,omitempty"`
+
+	// Webhooks lists endpoints that receive every event, alongside or instead of webhook_url
+	Webhooks []WebhookEndpoint `json:"webhooks,omitempty" validate:"dive"`
+
+	// IncludeThinking controls whether model reasoning is delivered (default true)
+	IncludeThinking *bool `json:"include_thinking,omitempty"`
+
+	// Ordered delivers webhook events one at a time in seq order (default true)
+	Ordered *bool `json:"ordered,omitempty"`
+
+	// EventTypes limits webhook deliveries to these event types (default all); the final
+	// agent.complete or agent.error of the request is delivered regardless
+	EventTypes []string `json:"event_types,omitempty"`
+
+	// SchemaVersion pins the schema of the webhook payloads (default the current one)
+	SchemaVersion string `json:"schema_version,omitempty"`
+
+	// WebhookRateLimitRPS and WebhookRateBurst override the rate deliveries to each endpoint
+	// are paced at (default the server's WEBHOOK_RATE_LIMIT_RPS and WEBHOOK_RATE_BURST)
+	WebhookRateLimitRPS float64 `json:"webhook_rate_limit_rps,omitempty" validate:"gte=0"`
+	WebhookRateBurst    int     `json:"webhook_rate_burst,omitempty" validate:"gte=0"`
+
+	// Queue waits for the message the agent is working on to finish instead of refusing
+	// the message with a 409
+	Queue bool `json:"queue,omitempty"`
+}
+
+// includeThinking returns the effective include_thinking setting
+func (r *SendMessageRequest) includeThinking() bool {
+	return r.IncludeThinking == nil || *r.IncludeThinking
+}
+
+// WebhookEndpoint is one destination of a message's webhook events
+type WebhookEndpoint struct {
+	URL             string            `json:"url" validate:"required,url"`
+	Secret          string            `json:"secret,omitempty"`
+	SecondarySecret string            `json:"secondary_secret,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	BearerToken     string            `json:"bearer_token,omitempty"`
 }
 
 // SendMessageResponse is the response for sending a message
@@ -25,35 +83,45 @@ type SendMessageResponse struct {
 	RequestID string `json:"request_id"`
 	AgentID   string `json:"agent_id"`
 	Status    string `json:"status"`
+
+	// Warnings lists webhook endpoints that failed a recent probe
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // InterruptRequest is the request body for interrupting an agent
 type InterruptRequest struct {
-	WebhookURL    string `json:"webhook_url"`
+	WebhookURL    string `json:"webhook_url" validate:"required,url"`
 	WebhookSecret string `json:"webhook_secret,omitempty"`
-	RequestID     string `json:"request_id,omitempty"`
+	RequestID     string `json:"request_id,omitempty" validate:"max=128"`
+
+	// TargetRequestID limits the interrupt to this in-flight message; by default every
+	// message in flight to the agent is interrupted
+	TargetRequestID string `json:"target_request_id,omitempty" validate:"max=128"`
+
+	// WebhookSecondarySecret also signs deliveries while webhook_secret is rotated
+	WebhookSecondarySecret string `json:"webhook_secondary_secret,omitempty"`
+
+	// WebhookHeaders and WebhookBearerToken are sent with every delivery to webhook_url
+	WebhookHeaders     map[string]string `json:"webhook_headers,omitempty"`
+	WebhookBearerToken string            `json:"webhook_bearer_token,omitempty"`
 }
 
-// SendMessage handles POST /api/v1/agents/:id/messages
+// SendMessage handles POST /api/v1/agents/:agent_id/messages
+//
+// With "Accept: text/event-stream" the response is an SSE stream of webhook payloads
+// and no webhook is required; otherwise events are delivered to webhook_url and every
+// endpoint in webhooks.
 func (h *Handler) SendMessage(c echo.Context) error {
-	agentID := c.Param("id")
-	userID := c.QueryParam("user_id")
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
 
 	if userID == "" {
 		return errors.BadRequest("user_id query param is required")
 	}
 
 	var req SendMessageRequest
-	if err := c.Bind(&req); err != nil {
-		return errors.BadRequest("invalid request body")
-	}
-
-	if req.Content == "" {
-		return errors.BadRequest("content is required")
-	}
-
-	if req.WebhookURL == "" {
-		return errors.BadRequest("webhook_url is required")
+	if err := handler.Bind(c, &req); err != nil {
+		return err
 	}
 
 	// Generate request ID if not provided
@@ -62,42 +130,72 @@ func (h *Handler) SendMessage(c echo.Context) error {
 		requestID = generateRequestID()
 	}
 
-	webhookCfg := webhook.Config{
-		URL:    req.WebhookURL,
-		Secret: req.WebhookSecret,
+	if err := h.checkQuarantine(c, userID, agentID); err != nil {
+		return err
+	}
+	if err := h.checkBudget(c, userID); err != nil {
+		return err
+	}
+
+	// Consumers without a public webhook endpoint can stream events over SSE instead
+	if acceptsEventStream(c.Request()) {
+		if err := h.queueMessage(userID, agentID, requestID, req.Queue); err != nil {
+			return err
+		}
+		return h.streamMessageEvents(c, userID, agentID, requestID, req.Content, req.includeThinking())
+	}
+
+	webhookCfg, err := h.sendMessageWebhookConfig(c, &req)
+	if err != nil {
+		return err
+	}
+	warnings, err := h.checkWebhookProbe(webhookCfg)
+	if err != nil {
+		return err
+	}
+
+	if err := h.queueMessage(userID, agentID, requestID, req.Queue); err != nil {
+		return err
 	}
 
-	// Start async processing
-	go func() {
-		// Use TODO context since HTTP request completes immediately with 202
-		// The request context would be canceled as soon as we return
-		ctx := context.TODO()
+	// Start async processing; the job outlives this request, which completes with 202
+	if err := h.processor.RunJob(c.Request().Context(), requestID, userID, agentID, func(ctx context.Context) {
 		_ = h.processor.SendMessageWithWebhook(ctx, userID, agentID, requestID, req.Content, webhookCfg)
-	}()
+	}); err != nil {
+		h.processor.DequeueMessage(userID, agentID, requestID)
+		return errors.ServiceUnavailable(err.Error())
+	}
 
 	return c.JSON(http.StatusAccepted, SendMessageResponse{
 		RequestID: requestID,
 		AgentID:   agentID,
 		Status:    "processing",
+		Warnings:  warnings,
 	})
 }
 
-// Interrupt handles POST /api/v1/agents/:id/interrupt
+// Interrupt handles POST /api/v1/agents/:agent_id/interrupt
 func (h *Handler) Interrupt(c echo.Context) error {
-	agentID := c.Param("id")
-	userID := c.QueryParam("user_id")
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
 
 	if userID == "" {
 		return errors.BadRequest("user_id query param is required")
 	}
 
 	var req InterruptRequest
-	if err := c.Bind(&req); err != nil {
-		return errors.BadRequest("invalid request body")
+	if err := handler.Bind(c, &req); err != nil {
+		return err
 	}
 
-	if req.WebhookURL == "" {
-		return errors.BadRequest("webhook_url is required")
+	if err := h.validateWebhookURL(c, req.WebhookURL); err != nil {
+		return err
+	}
+	if err := validateWebhookHeaders("", req.WebhookHeaders, req.WebhookBearerToken); err != nil {
+		return err
+	}
+	if err := validateWebhookSecrets("webhook_secret", req.WebhookSecret, req.WebhookSecondarySecret); err != nil {
+		return err
 	}
 
 	// Generate request ID if not provided
@@ -107,25 +205,222 @@ func (h *Handler) Interrupt(c echo.Context) error {
 	}
 
 	webhookCfg := webhook.Config{
-		URL:    req.WebhookURL,
-		Secret: req.WebhookSecret,
+		URL:             req.WebhookURL,
+		Secret:          req.WebhookSecret,
+		SecondarySecret: req.WebhookSecondarySecret,
+		Headers:         req.WebhookHeaders,
+		BearerToken:     req.WebhookBearerToken,
+	}
+	warnings, err := h.checkWebhookProbe(webhookCfg)
+	if err != nil {
+		return err
 	}
 
-	// Start async processing
-	go func() {
-		// Use TODO context since HTTP request completes immediately with 202
-		// The request context would be canceled as soon as we return
-		ctx := context.TODO()
-		_ = h.processor.InterruptWithWebhook(ctx, userID, agentID, requestID, webhookCfg)
-	}()
+	// Start async processing; the job outlives this request, which completes with 202
+	if err := h.processor.RunJob(c.Request().Context(), requestID, userID, agentID, func(ctx context.Context) {
+		_ = h.processor.InterruptWithWebhook(ctx, userID, agentID, requestID, req.TargetRequestID, webhookCfg)
+	}); err != nil {
+		return errors.ServiceUnavailable(err.Error())
+	}
 
 	return c.JSON(http.StatusAccepted, SendMessageResponse{
 		RequestID: requestID,
 		AgentID:   agentID,
 		Status:    "interrupting",
+		Warnings:  warnings,
 	})
 }
 
+// sendMessageWebhookConfig builds the delivery config for a message from webhook_url and
+// webhooks, validating every endpoint. The first endpoint given is the one the request's
+// status and redelivery refer to; the rest are fanned out to.
+func (h *Handler) sendMessageWebhookConfig(c echo.Context, req *SendMessageRequest) (webhook.Config, error) {
+	endpoints := make([]WebhookEndpoint, 0, 1+len(req.Webhooks))
+	if req.WebhookURL != "" {
+		if err := validateWebhookHeaders("", req.WebhookHeaders, req.WebhookBearerToken); err != nil {
+			return webhook.Config{}, err
+		}
+		if err := validateWebhookSecrets("webhook_secret", req.WebhookSecret, req.WebhookSecondarySecret); err != nil {
+			return webhook.Config{}, err
+		}
+		endpoints = append(endpoints, WebhookEndpoint{
+			URL:             req.WebhookURL,
+			Secret:          req.WebhookSecret,
+			SecondarySecret: req.WebhookSecondarySecret,
+			Headers:         req.WebhookHeaders,
+			BearerToken:     req.WebhookBearerToken,
+		})
+	} else if len(req.WebhookHeaders) > 0 || req.WebhookBearerToken != "" || req.WebhookSecondarySecret != "" {
+		return webhook.Config{}, errors.BadRequest("webhook_headers, webhook_bearer_token and webhook_secondary_secret require webhook_url")
+	}
+	for i, e := range req.Webhooks {
+		field := "webhooks[" + strconv.Itoa(i) + "]"
+		if err := validateWebhookHeaders(field+".", e.Headers, e.BearerToken); err != nil {
+			return webhook.Config{}, err
+		}
+		if err := validateWebhookSecrets(field+".secret", e.Secret, e.SecondarySecret); err != nil {
+			return webhook.Config{}, err
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	if len(endpoints) == 0 {
+		return webhook.Config{}, errors.BadRequest("webhook_url or webhooks is required")
+	}
+	eventTypes, err := webhook.ParseEventTypes(req.EventTypes)
+	if err != nil {
+		return webhook.Config{}, errors.BadRequest("event_types: " + err.Error())
+	}
+	if err := validateSchemaVersion(req.SchemaVersion); err != nil {
+		return webhook.Config{}, err
+	}
+	if len(endpoints) > maxWebhookEndpoints {
+		return webhook.Config{}, errors.BadRequest("a message can have at most " + strconv.Itoa(maxWebhookEndpoints) + " webhook endpoints")
+	}
+
+	seen := make(map[string]bool, len(endpoints))
+	for _, e := range endpoints {
+		if seen[e.URL] {
+			return webhook.Config{}, errors.BadRequest("webhook endpoint " + webhook.RedactURL(e.URL) + " is listed more than once")
+		}
+		seen[e.URL] = true
+		if err := h.validateWebhookURL(c, e.URL); err != nil {
+			return webhook.Config{}, err
+		}
+	}
+
+	webhookCfg := webhook.Config{
+		URL:             endpoints[0].URL,
+		Secret:          endpoints[0].Secret,
+		SecondarySecret: endpoints[0].SecondarySecret,
+		Headers:         endpoints[0].Headers,
+		BearerToken:     endpoints[0].BearerToken,
+		OmitThinking:    !req.includeThinking(),
+		EventTypes:      eventTypes,
+		Unordered:       req.Ordered != nil && !*req.Ordered,
+		SchemaVersion:   req.SchemaVersion,
+		RateLimitRPS:    req.WebhookRateLimitRPS,
+		RateBurst:       req.WebhookRateBurst,
+	}
+	for _, e := range endpoints[1:] {
+		webhookCfg.Fanout = append(webhookCfg.Fanout, webhook.Endpoint{
+			URL:             e.URL,
+			Secret:          e.Secret,
+			SecondarySecret: e.SecondarySecret,
+			Headers:         e.Headers,
+			BearerToken:     e.BearerToken,
+		})
+	}
+	return webhookCfg, nil
+}
+
+// validateWebhookHeaders returns a 400 if an endpoint's custom headers cannot be sent.
+// field prefixes the offending request field in the message, e.g. "webhooks[1]."
+func validateWebhookHeaders(field string, headers map[string]string, bearerToken string) error {
+	if err := webhook.ValidateHeaders(headers, bearerToken); err != nil {
+		return errors.BadRequest(field + "headers: " + err.Error())
+	}
+	return nil
+}
+
+// validateWebhookSecrets returns a 400 if an endpoint has a secondary signing secret but
+// no primary one. secretField names the primary secret's request field.
+func validateWebhookSecrets(secretField, secret, secondarySecret string) error {
+	if secondarySecret != "" && secret == "" {
+		return errors.BadRequest("a secondary webhook secret requires " + secretField)
+	}
+	return nil
+}
+
+// SchemaVersionDetails lists the payload schema versions a request may pin, for one
+// pinning another
+type SchemaVersionDetails struct {
+	SupportedVersions []string `json:"supported_versions"`
+}
+
+// validateSchemaVersion returns a 400 listing the supported versions if a requested
+// payload schema version is not one of them
+func validateSchemaVersion(version string) error {
+	if err := webhook.ValidateSchemaVersion(version); err != nil {
+		return errors.BadRequest("schema_version: " + err.Error()).WithDetails(SchemaVersionDetails{
+			SupportedVersions: webhook.SchemaVersions(),
+		})
+	}
+	return nil
+}
+
+// AgentBusyDetails names the message an agent is working on, for a message it refused
+type AgentBusyDetails struct {
+	ActiveRequestID string `json:"active_request_id"`
+	QueueFull       bool   `json:"queue_full,omitempty"`
+}
+
+// queueMessage reserves a message its turn on the agent, or a place in the agent's queue
+// if wait is set. It returns a 409 agent_busy naming the message the agent is working on
+// if the message can do neither.
+func (h *Handler) queueMessage(userID, agentID, requestID string, wait bool) error {
+	err := h.processor.QueueMessage(userID, agentID, requestID, wait)
+	var busy *processor.AgentBusyError
+	if stderrors.As(err, &busy) {
+		return errors.Conflict(err.Error()).WithErrorCode(processor.ErrorCodeAgentBusy).WithDetails(AgentBusyDetails{
+			ActiveRequestID: busy.ActiveRequestID,
+			QueueFull:       busy.QueueFull,
+		})
+	}
+	if stderrors.Is(err, webhook.ErrDuplicateRequest) {
+		return errors.Conflict(err.Error()).WithErrorCode(webhook.ErrorCodeDuplicateRequest)
+	}
+	return err
+}
+
+// checkQuarantine returns a 423 if the agent is quarantined
+func (h *Handler) checkQuarantine(c echo.Context, userID, agentID string) error {
+	if err := h.processor.CheckQuarantine(c.Request().Context(), userID, agentID); err != nil {
+		return errors.Locked(err.Error()).WithErrorCode(processor.ErrorCodeAgentQuarantined)
+	}
+	return nil
+}
+
+// checkBudget returns a 402 with processor.ErrorCodeBudgetExceeded, detailing the user's
+// spend, if the user has spent their budget. A spent soft budget only sets the
+// BudgetWarningHeader on the response.
+func (h *Handler) checkBudget(c echo.Context, userID string) error {
+	status, err := h.processor.CheckBudget(c.Request().Context(), userID)
+	if err != nil {
+		return errors.PaymentRequired(err.Error()).WithErrorCode(processor.ErrorCodeBudgetExceeded).WithDetails(status)
+	}
+	if status != nil {
+		c.Response().Header().Set(BudgetWarningHeader, fmt.Sprintf("spent $%.2f of the %s budget of $%.2f",
+			status.SpentUSD, status.Budget.Period, status.Budget.LimitUSD))
+	}
+	return nil
+}
+
+// validateWebhookURL returns a 400 with webhook.ErrorCodeURLRejected if the platform
+// refuses to deliver webhooks to rawURL
+func (h *Handler) validateWebhookURL(c echo.Context, rawURL string) error {
+	if err := h.processor.ValidateWebhookURL(c.Request().Context(), rawURL); err != nil {
+		if stderrors.Is(err, webhook.ErrURLRejected) {
+			return errors.BadRequest(err.Error()).WithErrorCode(webhook.ErrorCodeURLRejected)
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// checkWebhookProbe returns a warning for each endpoint of webhookCfg that failed a recent
+// probe, or a 400 with webhook.ErrorCodeProbeFailed instead when probes are strict
+func (h *Handler) checkWebhookProbe(webhookCfg webhook.Config) ([]string, error) {
+	warnings, err := h.processor.CheckWebhookProbe(webhookCfg)
+	if err != nil {
+		if stderrors.Is(err, webhook.ErrProbeFailed) {
+			return nil, errors.BadRequest(err.Error()).WithErrorCode(webhook.ErrorCodeProbeFailed)
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	return warnings, nil
+}
+
 func generateRequestID() string {
 	b := make([]byte, 8)
 	_, _ = rand.Read(b)
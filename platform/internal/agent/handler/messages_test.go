@@ -0,0 +1,719 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+func TestSendMessage_FansOutToEveryWebhook(t *testing.T) {
+	e, querier := setupBatchTest(t, &batchAgentService{})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages?user_id=user1", strings.NewReader(`{
+		"content": "hi",
+		"request_id": "req_fan",
+		"webhook_url": "https://hooks.example.com/customer",
+		"webhooks": [{"url": "https://audit.example.com/collect", "secret": "audit"}]
+	}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+
+	// The agent sends an event and a completion, each queued once per endpoint
+	deadline := time.Now().Add(5 * time.Second)
+	for len(querier.payloads()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	querier.mu.Lock()
+	defer querier.mu.Unlock()
+	if len(querier.enqueued) != 4 {
+		t.Fatalf("expected 4 enqueued payloads, got %d", len(querier.enqueued))
+	}
+	for i, url := range []string{
+		"https://hooks.example.com/customer", "https://audit.example.com/collect",
+		"https://hooks.example.com/customer", "https://audit.example.com/collect",
+	} {
+		if querier.enqueuedTo[i] != url || querier.enqueued[i].Seq != uint64(i/2+1) {
+			t.Errorf("payload %d: expected seq %d to %s, got seq %d to %s", i, i/2+1, url, querier.enqueued[i].Seq, querier.enqueuedTo[i])
+		}
+	}
+}
+
+func TestSendMessage_UnreachableAgentFailsRequest(t *testing.T) {
+	// No agent listens on the port the agent's service routes to
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := int32(ln.Addr().(*net.TCPAddr).Port)
+	_ = ln.Close()
+	querier := newFakeBatchQuerier()
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	mgr := createNodePortManager(t, "user1", "agent1", port)
+	e := setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop()))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages?user_id=user1",
+		strings.NewReader(`{"content":"hi","request_id":"req_unreachable","webhook_url":"https://hooks.example.com/customer"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+
+	// The request fails once its stream cannot be opened, rather than staying pending
+	var resp RequestStatusResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_unreachable?user_id=user1", nil))
+		// The request is recorded once the message is processed
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if resp.Status != RequestStatusPending {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the request to leave %s, got %d: %s", RequestStatusPending, rec.Code, rec.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp.Status != RequestStatusFailed {
+		t.Errorf("expected status %s, got %s", RequestStatusFailed, resp.Status)
+	}
+}
+
+func TestSendMessage_EventTypeFilterSkipsEventsButKeepsSeq(t *testing.T) {
+	events := func() []*agentv1.AgentResponse {
+		return []*agentv1.AgentResponse{
+			eventResponse(1, "message.updated", `{}`),
+			eventResponse(2, "message.part.updated", `{}`),
+			eventResponse(3, "message.updated", `{}`),
+		}
+	}
+	complete := &agentv1.AgentResponse{Seq: 4, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}}
+
+	tests := []struct {
+		name      string
+		responses []*agentv1.AgentResponse
+		wantSeqs  []uint64 // of queued payloads
+		wantSeq   int64    // recorded on the delivery once it ends
+	}{
+		// The completion is final, so it is delivered despite the filter
+		{"completed", append(events(), complete), []uint64{4}, 4},
+		// The request still ends with a final payload, after the filtered events
+		{"stream ends early", events(), []uint64{4}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, querier := setupBatchTest(t, &scriptedAgentService{responses: tt.responses})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages?user_id=user1", strings.NewReader(`{
+				"content": "hi",
+				"request_id": "req_filtered",
+				"webhook_url": "https://hooks.example.com/customer",
+				"event_types": ["agent.error"]
+			}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+			}
+
+			var delivery sqlc.WebhookDelivery
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				querier.mu.Lock()
+				if d, ok := querier.deliveries["req_filtered"]; ok {
+					delivery = *d
+				}
+				querier.mu.Unlock()
+				if delivery.Status == webhook.DeliveryStatusCompleted || delivery.Status == webhook.DeliveryStatusFailed {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			var seqs []uint64
+			for _, payload := range querier.payloads() {
+				seqs = append(seqs, payload.Seq)
+			}
+			if fmt.Sprint(seqs) != fmt.Sprint(tt.wantSeqs) {
+				t.Errorf("expected queued seqs %v, got %v", tt.wantSeqs, seqs)
+			}
+			if delivery.Seq != tt.wantSeq {
+				t.Errorf("expected the delivery to record seq %d, got %d (%s)", tt.wantSeq, delivery.Seq, delivery.Status)
+			}
+		})
+	}
+}
+
+func TestSendMessage_EndsWithOneFinalAfterEveryEvent(t *testing.T) {
+	events := func() []*agentv1.AgentResponse {
+		return []*agentv1.AgentResponse{
+			eventResponse(1, "message.updated", `{}`),
+			eventResponse(2, "message.updated", `{}`),
+		}
+	}
+	tests := []struct {
+		name      string
+		svc       *scriptedAgentService
+		wantEvent webhook.EventType
+	}{
+		{"agent error", &scriptedAgentService{responses: events(), err: connect.NewError(connect.CodeInternal, errors.New("boom"))}, webhook.EventTypeError},
+		{"stream closed early", &scriptedAgentService{responses: events()}, webhook.EventTypeComplete},
+		{"final without seq", &scriptedAgentService{responses: append(events(),
+			&agentv1.AgentResponse{Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}})}, webhook.EventTypeComplete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, querier := setupBatchTest(t, tt.svc)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages?user_id=user1", strings.NewReader(`{
+				"content": "hi",
+				"request_id": "req_final",
+				"webhook_url": "https://hooks.example.com/customer"
+			}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for len(querier.payloads()) < 3 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			// Give a stray payload time to be queued after the final
+			time.Sleep(50 * time.Millisecond)
+
+			payloads := querier.payloads()
+			if len(payloads) != 3 {
+				t.Fatalf("expected 2 events and a final, got %d payloads", len(payloads))
+			}
+			for i, payload := range payloads {
+				if payload.Seq != uint64(i+1) || payload.IsFinal != (i == 2) {
+					t.Errorf("payload %d: expected seq %d and is_final %v, got seq %d and is_final %v",
+						i, i+1, i == 2, payload.Seq, payload.IsFinal)
+				}
+			}
+			if payloads[2].EventType != tt.wantEvent {
+				t.Errorf("expected the final to be %s, got %s", tt.wantEvent, payloads[2].EventType)
+			}
+		})
+	}
+}
+
+func TestSendMessage_WebhookEndpointValidation(t *testing.T) {
+	e, _ := setupBatchTest(t, &batchAgentService{})
+
+	tooMany := make([]string, maxWebhookEndpoints+1)
+	for i := range tooMany {
+		tooMany[i] = `{"url":"https://hooks.example.com/` + strconv.Itoa(i) + `"}`
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no webhook", `{"content":"hi"}`},
+		{"endpoint without url", `{"content":"hi","webhooks":[{"secret":"s"}]}`},
+		{"duplicate endpoint", `{"content":"hi","webhook_url":"https://hooks.example.com/a","webhooks":[{"url":"https://hooks.example.com/a"}]}`},
+		{"too many endpoints", `{"content":"hi","webhooks":[` + strings.Join(tooMany, ",") + `]}`},
+		{"reserved header", `{"content":"hi","webhook_url":"https://hooks.example.com/a","webhook_headers":{"X-Forge-Signature":"forged"}}`},
+		{"reserved endpoint header", `{"content":"hi","webhooks":[{"url":"https://hooks.example.com/a","headers":{"Host":"internal"}}]}`},
+		{"authorization with bearer token", `{"content":"hi","webhook_url":"https://hooks.example.com/a","webhook_headers":{"Authorization":"Basic x"},"webhook_bearer_token":"t"}`},
+		{"headers without webhook_url", `{"content":"hi","webhook_headers":{"X-Tenant":"acme"},"webhooks":[{"url":"https://hooks.example.com/a"}]}`},
+		{"secondary secret without secret", `{"content":"hi","webhook_url":"https://hooks.example.com/a","webhook_secondary_secret":"old"}`},
+		{"unknown event type", `{"content":"hi","webhook_url":"https://hooks.example.com/a","event_types":["agent.done"]}`},
+		{"endpoint secondary secret without secret", `{"content":"hi","webhooks":[{"url":"https://hooks.example.com/a","secondary_secret":"old"}]}`},
+		{"negative rate limit", `{"content":"hi","webhook_url":"https://hooks.example.com/a","webhook_rate_limit_rps":-1}`},
+		{"unknown schema version", `{"content":"hi","webhook_url":"https://hooks.example.com/a","schema_version":"2019-06"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages?user_id=user1", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSendMessage_UnknownSchemaVersionListsSupported(t *testing.T) {
+	e, _ := setupBatchTest(t, &batchAgentService{})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/messages?user_id=user1", strings.NewReader(
+		`{"content":"hi","webhook_url":"https://hooks.example.com/a","schema_version":"2019-06"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Details SchemaVersionDetails `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if fmt.Sprint(body.Details.SupportedVersions) != fmt.Sprint(webhook.SchemaVersions()) {
+		t.Errorf("expected supported versions %v, got %s", webhook.SchemaVersions(), rec.Body.String())
+	}
+}
+
+func TestInterrupt_RejectsReservedHeaders(t *testing.T) {
+	e, _ := setupBatchTest(t, &batchAgentService{})
+
+	for _, header := range []string{"Content-Length", "x-forge-timestamp"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/agent1/interrupt?user_id=user1", strings.NewReader(
+			`{"webhook_url":"https://hooks.example.com/a","webhook_headers":{"`+header+`":"1"}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d: %s", header, http.StatusBadRequest, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestSendMessage_QuarantinedAgentIsLockedUntilUnquarantined(t *testing.T) {
+	pod := createReadyPod("user1", "agent1")
+	pod.Annotations = map[string]string{
+		k8s.QuarantineAnnotation: `{"reason":"malformed_events","detail":"20 malformed events within 1m0s","since":"2026-01-01T12:00:00Z"}`,
+	}
+	e := setupTestHandler(t, createTestProcessor(t, pod))
+
+	post := func(path, body string, sse bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if sse {
+			req.Header.Set(echo.HeaderAccept, MIMEEventStream)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tt := range []struct {
+		name, path, body string
+		sse              bool
+	}{
+		{"webhook", "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","webhook_url":"https://hooks.example.com/a"}`, false},
+		{"sse", "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`, true},
+		{"batch", "/api/v1/agents/agent1/messages/batch?user_id=user1", `{"messages":[{"content":"hi"}],"webhook_url":"https://hooks.example.com/a"}`, false},
+	} {
+		rec := post(tt.path, tt.body, tt.sse)
+		if rec.Code != http.StatusLocked {
+			t.Fatalf("%s: expected status %d, got %d: %s", tt.name, http.StatusLocked, rec.Code, rec.Body.String())
+		}
+		var body map[string]string
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		if body["error"] != processor.ErrorCodeAgentQuarantined {
+			t.Errorf("%s: expected error %s, got %q", tt.name, processor.ErrorCodeAgentQuarantined, body["error"])
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents/agent1?user_id=user1", nil))
+	var agentResp AgentResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &agentResp)
+	if agentResp.Quarantine == nil || agentResp.Quarantine.Reason != processor.QuarantineReasonMalformedEvents {
+		t.Errorf("expected the agent to show its quarantine, got %+v", agentResp.Quarantine)
+	}
+
+	rec = post("/api/v1/admin/agents/agent1/unquarantine?user_id=user1", "", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var unquarantined UnquarantineResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &unquarantined)
+	if !unquarantined.WasQuarantined {
+		t.Error("expected was_quarantined to be true")
+	}
+
+	// The agent is no longer locked: the stream fails only because no agent is listening
+	if rec := post("/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi"}`, true); rec.Code == http.StatusLocked {
+		t.Errorf("expected the agent to accept messages again, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := post("/api/v1/admin/agents/missing/unquarantine?user_id=user1", "", false); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a missing agent, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// unreachableWebhookURL returns a URL on a local port nothing listens on
+func unreachableWebhookURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return "http://" + addr + "/hook"
+}
+
+// postUntilProbed repeats a request until its response carries probe warnings, as the
+// first use of a URL only starts its probe
+func postUntilProbed(t *testing.T, e *echo.Echo, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted || strings.Contains(rec.Body.String(), `"warnings"`) || time.Now().After(deadline) {
+			return rec
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWebhookProbe_WarnsAtEachEntryPoint(t *testing.T) {
+	e, _ := setupBatchTestWithConfig(t, &batchAgentService{}, &config.Config{
+		WebhookTimeout:  time.Second,
+		WebhookProbeTTL: time.Minute,
+	})
+	url := unreachableWebhookURL(t)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"message", "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","queue":true,"webhook_url":"` + url + `"}`},
+		{"interrupt", "/api/v1/agents/agent1/interrupt?user_id=user1", `{"webhook_url":"` + url + `"}`},
+		{"batch", "/api/v1/agents/agent1/messages/batch?user_id=user1", `{"messages":[{"content":"hi"}],"webhook_url":"` + url + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postUntilProbed(t, e, tt.path, tt.body)
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				Warnings []string `json:"warnings"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "failed a probe") {
+				t.Errorf("expected one probe warning, got %+v", resp.Warnings)
+			}
+		})
+	}
+}
+
+func TestWebhookProbe_StrictRejectsFailedURL(t *testing.T) {
+	e, _ := setupBatchTestWithConfig(t, &batchAgentService{}, &config.Config{
+		WebhookTimeout:     time.Second,
+		WebhookProbeTTL:    time.Minute,
+		WebhookProbeStrict: true,
+	})
+
+	rec := postUntilProbed(t, e, "/api/v1/agents/agent1/messages?user_id=user1",
+		`{"content":"hi","webhook_url":"`+unreachableWebhookURL(t)+`"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if body["error"] != webhook.ErrorCodeProbeFailed {
+		t.Errorf("expected error code %s, got %q", webhook.ErrorCodeProbeFailed, body["error"])
+	}
+}
+
+// blockingAgentService answers each message with one event and keeps the stream open until
+// the client goes away. The request IDs it is asked to interrupt are sent on interrupted.
+type blockingAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	interrupted chan string
+}
+
+func (s *blockingAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	req, err := stream.Receive()
+	if err != nil {
+		return err
+	}
+	if req.GetInterrupt() != nil {
+		s.interrupted <- req.RequestId
+		return nil
+	}
+	event := eventResponse(1, "message.updated", `{}`)
+	event.RequestId = req.RequestId
+	if err := stream.Send(event); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// setupJobTest is setupBatchTest, also returning the processor running the requests
+func setupJobTest(t *testing.T, svc agentv1connect.AgentServiceHandler) (*echo.Echo, *fakeBatchQuerier, *processor.Processor) {
+	t.Helper()
+	querier := newFakeBatchQuerier()
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	mgr := createNodePortManager(t, "user1", "agent1", startMockAgent(t, svc))
+	p := processor.NewProcessor(mgr, delivery, zap.NewNop())
+	return setupTestHandler(t, p), querier, p
+}
+
+// startBlockedMessage sends a message that the agent never finishes, once its first event
+// is queued
+func startBlockedMessage(t *testing.T, e *echo.Echo, querier *fakeBatchQuerier, requestID string) {
+	t.Helper()
+	rec := postJSON(e, "/api/v1/users/user1/agents/agent1/messages",
+		`{"content": "hi", "request_id": "`+requestID+`", "webhook_url": "https://hooks.example.com/customer"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	waitForPayloads(t, querier, 1)
+}
+
+// waitForPayloads waits until n payloads are queued and returns them
+func waitForPayloads(t *testing.T, querier *fakeBatchQuerier, n int) []webhook.Payload {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(querier.payloads()) < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	payloads := querier.payloads()
+	if len(payloads) < n {
+		t.Fatalf("expected %d queued payloads, got %d", n, len(payloads))
+	}
+	return payloads
+}
+
+func TestShutdown_ReportsInFlightStreamsAsErrors(t *testing.T) {
+	e, querier, p := setupJobTest(t, &blockingAgentService{})
+	startBlockedMessage(t, e, querier, "req_shutdown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx, 0); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	payloads := querier.payloads()
+	if len(payloads) != 2 {
+		t.Fatalf("expected the event and an error, got %d payloads", len(payloads))
+	}
+	final := payloads[1]
+	if final.EventType != webhook.EventTypeError || !final.IsFinal || final.Seq != 2 ||
+		final.Error == nil || final.Error.Code != processor.ErrorCodeShutdown || !final.Error.Recoverable {
+		t.Errorf("expected a recoverable %s error as seq 2, got %+v (error %+v)", processor.ErrorCodeShutdown, final, final.Error)
+	}
+
+	// New messages are refused while shutting down
+	rec := postJSON(e, "/api/v1/users/user1/agents/agent1/messages",
+		`{"content": "hi", "webhook_url": "https://hooks.example.com/customer"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d after shutdown, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+// payloadsFor returns the queued payloads of requestID
+func payloadsFor(querier *fakeBatchQuerier, requestID string) []webhook.Payload {
+	var payloads []webhook.Payload
+	for _, payload := range querier.payloads() {
+		if payload.RequestID == requestID {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads
+}
+
+func TestInterrupt_EndsInFlightMessage(t *testing.T) {
+	svc := &blockingAgentService{interrupted: make(chan string, 1)}
+	e, querier, _ := setupJobTest(t, svc)
+	startBlockedMessage(t, e, querier, "req_long")
+
+	rec := postJSON(e, "/api/v1/agents/agent1/interrupt?user_id=user1",
+		`{"request_id": "req_int", "target_request_id": "req_long", "webhook_url": "https://hooks.example.com/customer"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	select {
+	case requestID := <-svc.interrupted:
+		if requestID != "req_int" {
+			t.Errorf("expected the agent to be interrupted by req_int, got %s", requestID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the agent to be interrupted")
+	}
+
+	waitForPayloads(t, querier, 3)
+	long := payloadsFor(querier, "req_long")
+	if len(long) != 2 {
+		t.Fatalf("expected the event and a result for req_long, got %d payloads", len(long))
+	}
+	if final := long[1]; final.EventType != webhook.EventTypeResult || !final.IsFinal || final.Seq != 2 ||
+		final.Status != webhook.ResultStatusInterrupted || final.Error != nil {
+		t.Errorf("expected a final interrupted agent.result as seq 2, got %+v", final)
+	}
+	if interrupt := payloadsFor(querier, "req_int"); len(interrupt) != 1 ||
+		interrupt[0].EventType != webhook.EventTypeComplete || !interrupt[0].Success {
+		t.Errorf("expected the interrupt to complete, got %+v", interrupt)
+	}
+}
+
+func TestInterrupt_OpensStreamWhenNothingInFlight(t *testing.T) {
+	svc := &blockingAgentService{interrupted: make(chan string, 1)}
+	e, querier, _ := setupJobTest(t, svc)
+
+	// A target no longer in flight also falls back to a stream of its own
+	rec := postJSON(e, "/api/v1/agents/agent1/interrupt?user_id=user1",
+		`{"request_id": "req_int", "target_request_id": "req_done", "webhook_url": "https://hooks.example.com/customer"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	select {
+	case requestID := <-svc.interrupted:
+		if requestID != "req_int" {
+			t.Errorf("expected the interrupt sent as req_int, got %s", requestID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the interrupt sent to the agent")
+	}
+
+	payloads := waitForPayloads(t, querier, 1)
+	if final := payloads[0]; final.RequestID != "req_int" || final.EventType != webhook.EventTypeComplete || !final.Success {
+		t.Errorf("expected the interrupt's stream to complete, got %+v", final)
+	}
+}
+
+// gatedAgentService reports each message it receives and works on it until finish is
+// signalled. Interrupts are answered right away.
+type gatedAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	started chan string
+	finish  chan struct{}
+}
+
+func (s *gatedAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	req, err := stream.Receive()
+	if err != nil || req.GetInterrupt() != nil {
+		return err
+	}
+	s.started <- req.RequestId
+	select {
+	case <-s.finish:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return stream.Send(&agentv1.AgentResponse{
+		RequestId: req.RequestId,
+		Seq:       1,
+		Payload:   &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+	})
+}
+
+func waitForStart(t *testing.T, svc *gatedAgentService, requestID string) {
+	t.Helper()
+	select {
+	case started := <-svc.started:
+		if started != requestID {
+			t.Fatalf("expected %s sent to the agent next, got %s", requestID, started)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s sent to the agent", requestID)
+	}
+}
+
+func TestSendMessage_RejectsWhileAgentBusy(t *testing.T) {
+	e, querier, _ := setupJobTest(t, &blockingAgentService{})
+	startBlockedMessage(t, e, querier, "req_a")
+
+	rec := postJSON(e, "/api/v1/agents/agent1/messages?user_id=user1",
+		`{"content": "hi", "request_id": "req_b", "webhook_url": "https://hooks.example.com/customer"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusConflict, rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Error   string           `json:"error"`
+		Details AgentBusyDetails `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Error != processor.ErrorCodeAgentBusy || resp.Details.ActiveRequestID != "req_a" {
+		t.Errorf("expected agent_busy naming req_a, got %+v", resp)
+	}
+}
+
+func TestSendMessage_QueuesBehindActiveMessage(t *testing.T) {
+	svc := &gatedAgentService{started: make(chan string, 3), finish: make(chan struct{})}
+	e, _, _ := setupJobTest(t, svc)
+
+	for _, requestID := range []string{"req_a", "req_b", "req_c"} {
+		rec := postJSON(e, "/api/v1/agents/agent1/messages?user_id=user1",
+			`{"content": "hi", "queue": true, "request_id": "`+requestID+`", "webhook_url": "https://hooks.example.com/customer"}`)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected status %d, got %d: %s", requestID, http.StatusAccepted, rec.Code, rec.Body.String())
+		}
+	}
+	waitForStart(t, svc, "req_a")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_c?user_id=user1", nil))
+	var status RequestStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if status.Queue == nil || status.Queue.Position != 2 || status.Queue.ActiveRequestID != "req_a" {
+		t.Errorf("expected req_c second in line behind req_a, got %+v", status.Queue)
+	}
+
+	// The queue moves on when a message finishes, and when it fails
+	svc.finish <- struct{}{}
+	waitForStart(t, svc, "req_b")
+	if rec := postJSON(e, "/api/v1/requests/req_b/cancel?user_id=user1", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	waitForStart(t, svc, "req_c")
+}
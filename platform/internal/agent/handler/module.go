@@ -3,10 +3,20 @@ package handler
 import (
 	"go.uber.org/fx"
 
+	"github.com/forge/platform/internal/agent/processor"
 	"github.com/forge/platform/internal/handler"
 )
 
-// Module provides the agent handler to the fx container
+// Module provides the agent handler and the agent stream proxy to the fx container
 var Module = fx.Module("agent.handler",
-	fx.Provide(handler.AsHandler(NewHandler)),
+	fx.Provide(
+		newAgentProcessor,
+		handler.AsHandler(NewHandler),
+		handler.AsHandler(newProxy),
+	),
 )
+
+// newAgentProcessor provides the processor as the AgentProcessor the handler is served with
+func newAgentProcessor(p *processor.Processor) AgentProcessor {
+	return p
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent"
+)
+
+// versionedAgentService is a scripted agent that reports a protocol version from GetStatus
+type versionedAgentService struct {
+	scriptedAgentService
+	version       int32
+	statusCalls   atomic.Int32
+	connectCalled atomic.Bool
+}
+
+func (s *versionedAgentService) GetStatus(
+	context.Context,
+	*connect.Request[agentv1.GetStatusRequest],
+) (*connect.Response[agentv1.GetStatusResponse], error) {
+	s.statusCalls.Add(1)
+	return connect.NewResponse(&agentv1.GetStatusResponse{
+		AgentId:         "agent1",
+		State:           agentv1.AgentState_AGENT_STATE_IDLE,
+		ProtocolVersion: s.version,
+	}), nil
+}
+
+func (s *versionedAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	s.connectCalled.Store(true)
+	return s.scriptedAgentService.Connect(ctx, stream)
+}
+
+func newVersionedAgent(version int32) *versionedAgentService {
+	return &versionedAgentService{
+		version: version,
+		scriptedAgentService: scriptedAgentService{
+			responses: []*agentv1.AgentResponse{{
+				Seq:     1,
+				State:   agentv1.AgentState_AGENT_STATE_IDLE,
+				Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+			}},
+		},
+	}
+}
+
+func TestSendMessageSSE_RefusesAgentBelowMinimumProtocolVersion(t *testing.T) {
+	svc := newVersionedAgent(0)
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","request_id":"req_old"}`)
+
+	events := readSSEEvents(t, rec.Body.String())
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d: %s", len(events), rec.Body.String())
+	}
+	payload := events[0].payload
+	if payload.Error == nil || payload.Error.Code != agent.ErrorCodeUnsupportedByAgent || !payload.IsFinal {
+		t.Errorf("expected final %s error, got %+v", agent.ErrorCodeUnsupportedByAgent, payload)
+	}
+	if svc.connectCalled.Load() {
+		t.Error("expected the message not to be sent to an incompatible agent")
+	}
+}
+
+func TestSendMessageSSE_RecordsProtocolVersionAtHandshake(t *testing.T) {
+	svc := newVersionedAgent(agent.ProtocolVersion)
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	e := setupTestHandler(t, proc)
+
+	for _, requestID := range []string{"req_1", "req_2"} {
+		rec := postSSE(e, "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","request_id":"`+requestID+`"}`)
+		events := readSSEEvents(t, rec.Body.String())
+		if last := events[len(events)-1].payload; !last.IsFinal || !last.Success {
+			t.Fatalf("%s: expected successful completion, got %+v", requestID, last)
+		}
+	}
+
+	// The version is asked once, then read from the pod annotation
+	if calls := svc.statusCalls.Load(); calls != 1 {
+		t.Errorf("expected 1 GetStatus handshake, got %d", calls)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents/agent1?user_id=user1", nil))
+	var resp AgentResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.ProtocolVersion == nil || *resp.ProtocolVersion != agent.ProtocolVersion {
+		t.Errorf("expected protocol_version %d in agent response, got %v", agent.ProtocolVersion, resp.ProtocolVersion)
+	}
+}
+
+func TestRequireFeature_GatesOnReportedVersion(t *testing.T) {
+	tests := []struct {
+		version     int32
+		feature     agent.Feature
+		unsupported bool
+	}{
+		{0, agent.FeatureTargetedInterrupt, true},
+		{1, agent.FeatureCheckpoint, true},
+		{1, agent.FeatureTargetedInterrupt, true},
+		{1, agent.FeatureSessionResume, true},
+		{2, agent.FeatureCheckpoint, false},
+		{2, agent.FeatureTargetedInterrupt, false},
+		{2, agent.FeatureSessionResume, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.feature), func(t *testing.T) {
+			proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, newVersionedAgent(tt.version)))
+
+			err := proc.RequireFeature(context.Background(), "user1", "agent1", tt.feature)
+			if got := agent.IsUnsupported(err); got != tt.unsupported {
+				t.Errorf("version %d, %s: expected unsupported=%v, got err %v", tt.version, tt.feature, tt.unsupported, err)
+			}
+			if !tt.unsupported && err != nil {
+				t.Errorf("version %d, %s: unexpected error %v", tt.version, tt.feature, err)
+			}
+		})
+	}
+}
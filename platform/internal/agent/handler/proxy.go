@@ -0,0 +1,602 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// Frame types clients send over an agent's WebSocket stream
+const (
+	// WSTypeMessage sends a user message to the agent; its payload is a WSSendMessage
+	WSTypeMessage = "message"
+	// WSTypeInterrupt interrupts the request the agent is working on
+	WSTypeInterrupt = "interrupt"
+	// WSTypeCatchUp replays the agent's responses from a seq, as after a reconnect; its
+	// payload is a WSCatchUp
+	WSTypeCatchUp = "catch_up"
+)
+
+// Frame types the proxy sends over an agent's WebSocket stream
+const (
+	// WSTypeAgentMessage carries a response of the agent as a webhook.Payload
+	WSTypeAgentMessage = "agent_message"
+	// WSTypeError reports a problem with the stream or a client frame as a WSError
+	WSTypeError = "error"
+	// WSTypeCatchUpAck ends the replay of a catch_up frame as a WSCatchUpAck
+	WSTypeCatchUpAck = "catch_up_ack"
+	// WSTypeStatus reports a change of the agent's state as a WSStatus, e.g. its deletion
+	// right before the socket closes
+	WSTypeStatus = "status"
+)
+
+// WSStateDeleted is the WSStatus state of an agent that was deleted
+const WSStateDeleted = "deleted"
+
+// Error codes of WSError frames
+const (
+	// WSErrorCodeInvalidFrame is sent for a client frame that cannot be parsed or handled
+	WSErrorCodeInvalidFrame = "INVALID_FRAME"
+	// WSErrorCodeFrameTooLarge is sent for a client frame over the frame size limit
+	WSErrorCodeFrameTooLarge = "FRAME_TOO_LARGE"
+	// WSErrorCodeSendQueueOverflow is the close reason of a client that fell too far behind
+	WSErrorCodeSendQueueOverflow = "SEND_QUEUE_OVERFLOW"
+	// WSErrorCodeCatchUpFailed is sent for a catch_up frame the agent could not answer
+	WSErrorCodeCatchUpFailed = "CATCH_UP_FAILED"
+)
+
+// Defaults of the agent stream keepalive
+const (
+	defaultWSPingInterval = 30 * time.Second
+	defaultWSPongTimeout  = 60 * time.Second
+)
+
+// wsControlTimeout bounds writing a ping or close frame to a client
+const wsControlTimeout = 5 * time.Second
+
+// WSFrame is a frame of an agent's WebSocket stream, in either direction
+type WSFrame struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// WSSendMessage is the payload of a WSTypeMessage frame
+type WSSendMessage struct {
+	Content string `json:"content"`
+}
+
+// WSStatus is the payload of a WSTypeStatus frame
+type WSStatus struct {
+	State string `json:"state"`
+}
+
+// WSError is the payload of a WSTypeError frame
+type WSError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Proxy bridges WebSocket clients to the Connect stream of the agent pod they open a
+// stream to. Client frames become AgentRequests, and the agent's AgentResponses are sent
+// back as webhook payloads, the shape the other agent endpoints use.
+//
+// With an authenticator, upgrades must carry a token of the agent's owner or an admin.
+// Browsers may only open streams from the allowed origins, if any are configured.
+//
+// Open streams are tracked in a ConnectionRegistry, which caps how many one user and one
+// agent may have open.
+//
+// Clients are pinged every pingInterval, and one that answers neither a ping nor with a
+// frame within pongTimeout is deemed gone: its socket and agent stream are closed.
+//
+// Frames to a client wait in a send queue of sendQueueSize frames and are each written
+// within writeTimeout; overflowPolicy decides what happens once a slow client's queue is
+// full. Client frames over maxFrameBytes are refused.
+type Proxy struct {
+	processor     AgentProcessor
+	upgrader      websocket.Upgrader
+	authenticator handler.Authenticator
+	connections   *ConnectionRegistry
+	metrics       proxyMetrics
+	logger        *zap.Logger
+
+	allowedOrigins []string
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+	sendQueueSize  int
+	overflowPolicy string
+	writeTimeout   time.Duration
+	maxFrameBytes  int64
+}
+
+// NewProxy creates a WebSocket proxy to the agents of processor. Upgrades are
+// authenticated by authenticator, unless it is nil.
+func NewProxy(processor AgentProcessor, authenticator handler.Authenticator, logger *zap.Logger) *Proxy {
+	x := &Proxy{
+		processor:      processor,
+		authenticator:  authenticator,
+		connections:    NewConnectionRegistry(0, 0),
+		logger:         logger,
+		pingInterval:   defaultWSPingInterval,
+		pongTimeout:    defaultWSPongTimeout,
+		sendQueueSize:  defaultWSSendQueueSize,
+		overflowPolicy: WSOverflowDropOldest,
+		writeTimeout:   defaultWSWriteTimeout,
+		maxFrameBytes:  defaultWSMaxFrameBytes,
+	}
+	x.upgrader = websocket.Upgrader{
+		CheckOrigin:  x.checkOrigin,
+		Subprotocols: []string{wsBearerProtocol},
+	}
+	return x
+}
+
+// ProxyParams are the dependencies of the Proxy in the fx container; the Authenticator
+// is optional
+type ProxyParams struct {
+	fx.In
+	Lifecycle     fx.Lifecycle
+	Processor     AgentProcessor
+	Authenticator handler.Authenticator `optional:"true"`
+	Config        *config.Config
+	Metrics       *metrics.Metrics
+	Logger        *zap.Logger
+}
+
+// newProxy creates a Proxy using configuration from the fx container, and exports the
+// number of open streams as a metric. On stop, open streams are closed with a going-away
+// closure.
+func newProxy(p ProxyParams) *Proxy {
+	x := NewProxy(p.Processor, p.Authenticator, p.Logger)
+	x.connections = NewConnectionRegistry(p.Config.MaxWSConnectionsPerUser, p.Config.MaxWSConnectionsPerAgent)
+	x.allowedOrigins = p.Config.CORSAllowedOrigins
+	x.pingInterval = p.Config.WSPingInterval
+	x.pongTimeout = p.Config.WSPongTimeout
+	x.sendQueueSize = p.Config.WSSendQueueSize
+	x.overflowPolicy = p.Config.WSOverflowPolicy
+	x.writeTimeout = p.Config.WSWriteTimeout
+	x.maxFrameBytes = p.Config.WSMaxFrameBytes
+	p.Metrics.ObserveWebSocketConnections(x.connections.Len)
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			if err := x.connections.Shutdown(ctx); err != nil {
+				p.Logger.Warn("agent streams did not close before shutdown",
+					zap.Error(err),
+					zap.Int("streams", x.connections.Len()),
+				)
+			}
+			return nil
+		},
+	})
+	return x
+}
+
+// Metrics returns the send queue metrics of the proxy
+func (x *Proxy) Metrics() ProxyMetrics {
+	return x.metrics.snapshot()
+}
+
+// Register registers the agent stream routes with Echo
+func (x *Proxy) Register(e *echo.Echo) {
+	e.GET("/api/v1/users/:user_id/agents/:agent_id/stream", x.HandleWebSocket, x.authenticate, handler.RequireUser)
+	e.GET("/api/v1/agents/:agent_id/stream", x.HandleWebSocket, x.authenticate, handler.RequireUser, deprecatedRoute)
+
+	// Admin routes
+	e.GET("/api/v1/admin/streams", x.ListConnections)
+	e.GET("/api/v1/admin/streams/metrics", x.GetMetrics)
+}
+
+// ListConnectionsResponse lists the agent streams open on this replica
+type ListConnectionsResponse struct {
+	Connections []ConnectionInfo `json:"connections"`
+	Total       int              `json:"total"`
+}
+
+// ListConnections handles GET /api/v1/admin/streams
+func (x *Proxy) ListConnections(c echo.Context) error {
+	conns := x.connections.List()
+	return c.JSON(http.StatusOK, ListConnectionsResponse{Connections: conns, Total: len(conns)})
+}
+
+// GetMetrics handles GET /api/v1/admin/streams/metrics
+func (x *Proxy) GetMetrics(c echo.Context) error {
+	return c.JSON(http.StatusOK, x.Metrics())
+}
+
+// HandleWebSocket handles GET /api/v1/agents/:agent_id/stream, upgrading the request to a
+// WebSocket bridged to a Connect stream of the agent. The agent must be ready; problems
+// found before the upgrade are returned as HTTP errors, later ones as WSTypeError frames.
+// When the agent stream ends the socket is closed with a normal closure, or with an
+// internal error whose reason is the error code if the stream failed. If the agent is
+// deleted meanwhile, the client gets a WSTypeStatus frame and a going-away closure instead.
+func (x *Proxy) HandleWebSocket(c echo.Context) error {
+	if !x.checkOrigin(c.Request()) {
+		return errors.Forbidden("origin not allowed: " + c.Request().Header.Get(echo.HeaderOrigin))
+	}
+
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	principal, authenticated := handler.PrincipalFrom(c)
+	if userID == "" && authenticated {
+		userID = principal.UserID
+	}
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	ctx := c.Request().Context()
+	pod, err := x.processor.GetAgent(ctx, userID, agentID)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return errors.NotFound(fmt.Sprintf("agent %s not found", agentID))
+		}
+		return errors.InternalError(err.Error())
+	}
+	if authenticated && !principal.Admin && pod.Labels["user-id"] != principal.UserID {
+		return errors.Forbidden("not allowed to open a stream to agent " + agentID)
+	}
+	if err := k8s.CheckPodReady(pod); err != nil {
+		return errors.Conflict(err.Error())
+	}
+	if err := x.processor.CheckQuarantine(ctx, userID, agentID); err != nil {
+		return errors.Locked(err.Error()).WithErrorCode(processor.ErrorCodeAgentQuarantined)
+	}
+	registered, err := x.connections.Register(userID, agentID)
+	if err != nil {
+		var limitErr *ConnectionLimitError
+		if stderrors.As(err, &limitErr) {
+			return errors.TooManyRequests(limitErr.Error()).WithDetails(limitErr)
+		}
+		return errors.ServiceUnavailable(err.Error())
+	}
+	defer x.connections.Release(registered)
+
+	deleted, unsubscribe := x.processor.SubscribeAgentDeleted(userID, agentID)
+	defer unsubscribe()
+
+	// The stream outlives the upgrade request's context checks, but ends with the socket
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stream, err := x.processor.ConnectToAgent(streamCtx, userID, agentID)
+	if err != nil {
+		return errors.ServiceUnavailable(err.Error())
+	}
+	defer func() { _ = stream.CloseResponse() }()
+
+	conn, err := x.upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// The upgrader already replied with an HTTP error
+		return nil
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(x.maxFrameBytes * wsReadLimitFactor)
+	ws := x.newWSConn(conn)
+	s := &streamSession{userID: userID, agentID: agentID, conn: registered, ws: ws, stream: stream, deleted: deleted}
+	x.keepAlive(streamCtx, ws)
+	go x.watchStream(streamCtx, s)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer cancel()
+		x.relayAgentResponses(streamCtx, s)
+	}()
+
+	if err := x.relayClientFrames(streamCtx, s); isTimeout(err) {
+		x.logger.Info("agent stream client stopped answering pings",
+			zap.String("agent_id", agentID),
+			zap.Duration("pong_timeout", x.pongTimeout),
+		)
+	}
+	cancel()
+	_ = stream.CloseRequest()
+	<-done
+	<-ws.done
+	s.finishUsage()
+	return nil
+}
+
+// keepAlive pings the client until ctx is done, and expects it to answer within the pong
+// timeout. Each pong and frame of the client extends its read deadline.
+func (x *Proxy) keepAlive(ctx context.Context, ws *wsConn) {
+	_ = ws.extendReadDeadline(x.pongTimeout)
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.extendReadDeadline(x.pongTimeout)
+	})
+
+	go func() {
+		ticker := time.NewTicker(x.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsControlTimeout)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// isClosed reports whether ch is closed
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTimeout reports whether err is a read from a client that missed its deadline
+func isTimeout(err error) bool {
+	var netErr interface{ Timeout() bool }
+	return stderrors.As(err, &netErr) && netErr.Timeout()
+}
+
+// streamSession is a client's WebSocket bridged to an agent stream
+type streamSession struct {
+	userID  string
+	agentID string
+	conn    *StreamConnection
+	ws      *wsConn
+	stream  *connect.BidiStreamForClient[agentv1.AgentRequest, agentv1.AgentResponse]
+	// splice holds the agent's responses back while the client catches up
+	splice catchUpSplice
+	// deleted is closed once the agent is deleted
+	deleted    <-chan struct{}
+	deleteOnce sync.Once
+
+	// usage tracks the usage of each message in flight, by request ID
+	usageMu sync.Mutex
+	usage   map[string]streamUsage
+}
+
+// streamUsage is the usage of a message sent over a stream, stored once finish is called
+type streamUsage struct {
+	tracker *webhook.UsageTracker
+	finish  func()
+}
+
+// trackUsage starts tracking the usage of a message sent over the stream, until its final
+// payload or the end of the stream
+func (x *Proxy) trackUsage(ctx context.Context, s *streamSession, requestID string) {
+	tracker, finish := x.processor.TrackUsage(ctx, s.userID, s.agentID, requestID)
+	s.usageMu.Lock()
+	prev, ok := s.usage[requestID]
+	if s.usage == nil {
+		s.usage = make(map[string]streamUsage)
+	}
+	s.usage[requestID] = streamUsage{tracker: tracker, finish: finish}
+	s.usageMu.Unlock()
+	if ok {
+		prev.finish()
+	}
+}
+
+// observeUsage records the usage a payload reports for its message, storing the message's
+// usage once the payload is final
+func (s *streamSession) observeUsage(payload webhook.Payload) {
+	s.usageMu.Lock()
+	u, ok := s.usage[payload.RequestID]
+	if ok && payload.IsFinal {
+		delete(s.usage, payload.RequestID)
+	}
+	s.usageMu.Unlock()
+	if !ok {
+		return
+	}
+	u.tracker.Observe(payload)
+	if payload.IsFinal {
+		u.finish()
+	}
+}
+
+// finishUsage stores the usage of the messages still in flight once the stream ends
+func (s *streamSession) finishUsage() {
+	s.usageMu.Lock()
+	usage := s.usage
+	s.usage = nil
+	s.usageMu.Unlock()
+	for _, u := range usage {
+		u.finish()
+	}
+}
+
+// watchStream closes the client's socket once the server shuts down or the agent is
+// deleted, until ctx ends
+func (x *Proxy) watchStream(ctx context.Context, s *streamSession) {
+	select {
+	case <-s.conn.shutdown:
+		s.ws.closeWith(websocket.CloseGoingAway, "server shutting down")
+	case <-s.deleted:
+		x.closeDeleted(s)
+	case <-ctx.Done():
+	}
+}
+
+// closeDeleted tells the client its agent was deleted with a final status frame, then
+// closes its socket as going away
+func (x *Proxy) closeDeleted(s *streamSession) {
+	s.deleteOnce.Do(func() {
+		_ = s.ws.writeFrame(WSTypeStatus, "", WSStatus{State: WSStateDeleted}, false)
+		s.ws.closeWith(websocket.CloseGoingAway, "agent deleted")
+	})
+}
+
+// relayAgentResponses sends the agent's responses to the client until the agent stream ends
+func (x *Proxy) relayAgentResponses(ctx context.Context, s *streamSession) {
+	ws := s.ws
+	for {
+		resp, err := s.stream.Receive()
+		if err != nil {
+			// The stream is cancelled once the client goes away, and ends once the agent
+			// is deleted, which the client is told about instead
+			switch {
+			case isClosed(s.deleted):
+				x.closeDeleted(s)
+			case ctx.Err() != nil:
+				ws.close()
+			case stderrors.Is(err, io.EOF):
+				ws.closeWith(websocket.CloseNormalClosure, "agent stream ended")
+			default:
+				code, _ := processor.StreamErrorCode(err)
+				_ = ws.writeError("", code, err.Error())
+				ws.closeWith(websocket.CloseInternalServerErr, code)
+			}
+			return
+		}
+		if err := s.splice.relay(resp, func(resp *agentv1.AgentResponse) error {
+			return x.sendAgentResponse(s, resp)
+		}); err != nil {
+			return
+		}
+	}
+}
+
+// sendAgentResponse queues an agent's response to the client as it streams in. A client
+// too slow to keep up with the agent is disconnected.
+func (x *Proxy) sendAgentResponse(s *streamSession, resp *agentv1.AgentResponse) error {
+	// Artifacts are stored with the request when it is sent with a webhook; a stream
+	// does not carry them
+	if resp.GetArtifact() != nil {
+		return nil
+	}
+	payload := webhook.AgentResponseToPayload(resp, s.agentID, resp.GetRequestId())
+	s.observeUsage(payload)
+	err := s.ws.writeFrame(WSTypeAgentMessage, resp.GetRequestId(), payload, droppablePayload(payload))
+	if err == nil {
+		s.conn.toClient.Add(1)
+	}
+	if stderrors.Is(err, errSendQueueOverflow) {
+		x.metrics.overflows.Add(1)
+		x.logger.Info("agent stream client fell behind, disconnecting",
+			zap.String("agent_id", s.agentID),
+			zap.Int("send_queue_size", x.sendQueueSize),
+		)
+		s.ws.abort(websocket.ClosePolicyViolation, WSErrorCodeSendQueueOverflow)
+	}
+	return err
+}
+
+// relayClientFrames sends the client's frames to the agent until the client goes away,
+// returning the error that ended reading from the client
+func (x *Proxy) relayClientFrames(ctx context.Context, s *streamSession) error {
+	ws, agentID := s.ws, s.agentID
+	for {
+		_, r, err := ws.conn.NextReader()
+		if err != nil {
+			return err
+		}
+		raw, err := io.ReadAll(io.LimitReader(r, x.maxFrameBytes+1))
+		if err != nil {
+			return err
+		}
+		_ = ws.extendReadDeadline(x.pongTimeout)
+		if int64(len(raw)) > x.maxFrameBytes {
+			// The rest of the frame is discarded by the next read
+			x.metrics.oversized.Add(1)
+			_ = ws.writeError("", WSErrorCodeFrameTooLarge, fmt.Sprintf("frames may be at most %d bytes", x.maxFrameBytes))
+			continue
+		}
+		var frame WSFrame
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			_ = ws.writeError("", WSErrorCodeInvalidFrame, "frame is not a valid JSON frame")
+			continue
+		}
+		if frame.Type == WSTypeCatchUp {
+			if err := x.catchUp(ctx, s, frame); err != nil {
+				return err
+			}
+			// The client's pongs wait unread while it catches up
+			_ = ws.extendReadDeadline(x.pongTimeout)
+			continue
+		}
+
+		req, err := agentRequest(frame)
+		if err != nil {
+			_ = ws.writeError(frame.RequestID, WSErrorCodeInvalidFrame, err.Error())
+			continue
+		}
+		if frame.Type == WSTypeMessage {
+			if code, err := x.checkMessage(ctx, s); err != nil {
+				_ = ws.writeError(req.GetRequestId(), code, err.Error())
+				continue
+			}
+			x.trackUsage(ctx, s, req.GetRequestId())
+		}
+		if err := s.stream.Send(req); err != nil {
+			x.logger.Debug("failed to send to agent stream",
+				zap.Error(err),
+				zap.String("agent_id", agentID),
+			)
+			return nil
+		}
+		s.conn.toAgent.Add(1)
+	}
+}
+
+// checkMessage checks a message of the client against the agent's quarantine and the
+// user's budget, which either may have changed since the stream was opened, returning the
+// error code of the WSError frame that refuses it
+func (x *Proxy) checkMessage(ctx context.Context, s *streamSession) (string, error) {
+	if err := x.processor.CheckQuarantine(ctx, s.userID, s.agentID); err != nil {
+		return processor.ErrorCodeAgentQuarantined, err
+	}
+	if _, err := x.processor.CheckBudget(ctx, s.userID); err != nil {
+		return processor.ErrorCodeBudgetExceeded, err
+	}
+	return "", nil
+}
+
+// agentRequest converts a client frame to the AgentRequest it stands for. Messages without
+// a request ID are given one.
+func agentRequest(frame WSFrame) (*agentv1.AgentRequest, error) {
+	switch frame.Type {
+	case WSTypeMessage:
+		var msg WSSendMessage
+		if err := json.Unmarshal(frame.Payload, &msg); err != nil || msg.Content == "" {
+			return nil, stderrors.New("message frames need a payload with content")
+		}
+		requestID := frame.RequestID
+		if requestID == "" {
+			requestID = generateRequestID()
+		}
+		return &agentv1.AgentRequest{
+			RequestId: requestID,
+			Command:   &agentv1.AgentRequest_SendMessage{SendMessage: &agentv1.SendMessageRequest{Content: msg.Content}},
+		}, nil
+	case WSTypeInterrupt:
+		return &agentv1.AgentRequest{
+			RequestId: frame.RequestID,
+			Command:   &agentv1.AgentRequest_Interrupt{Interrupt: &agentv1.InterruptRequest{}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown frame type %q", frame.Type)
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	stderrors "errors"
+	"net/http"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+)
+
+// wsBearerProtocol is the subprotocol a stream upgrade offers ahead of its token
+const wsBearerProtocol = handler.WSBearerProtocol
+
+// authenticate is route middleware resolving the Principal of a stream upgrade from its
+// token, for RequireUser and the ownership check that follow. Upgrades without a valid
+// token are refused with 401 before they complete. Upgrades the server already
+// authenticated, and all upgrades without an Authenticator, pass unchanged.
+func (x *Proxy) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := handler.PrincipalFrom(c); ok || x.authenticator == nil {
+			return next(c)
+		}
+		token := handler.RequestToken(c.Request())
+		if token == "" {
+			return errors.Unauthorized("a token is required to open an agent stream")
+		}
+		principal, err := x.authenticator.Authenticate(c.Request().Context(), token)
+		if err != nil {
+			if stderrors.Is(err, handler.ErrInvalidToken) {
+				return errors.Unauthorized(err.Error())
+			}
+			x.logger.Warn("failed to authenticate agent stream", zap.Error(err))
+			return errors.ServiceUnavailable("failed to authenticate")
+		}
+		handler.SetPrincipal(c, principal)
+		return next(c)
+	}
+}
+
+// checkOrigin reports whether a stream upgrade may come from its origin: any origin unless
+// allowed origins are configured, and otherwise one of them. Clients that are not browsers
+// send no origin and are let through.
+func (x *Proxy) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get(echo.HeaderOrigin)
+	if origin == "" || len(x.allowedOrigins) == 0 {
+		return true
+	}
+	return slices.Contains(x.allowedOrigins, "*") || slices.Contains(x.allowedOrigins, origin)
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"connectrpc.com/connect"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// WSCatchUp is the payload of a WSTypeCatchUp frame
+type WSCatchUp struct {
+	// FromSeq is the first seq to replay; a reconnecting client passes one past the last
+	// seq it saw
+	FromSeq uint64 `json:"from_seq"`
+	// Limit is the most responses replayed, 100 by default
+	Limit int `json:"limit,omitempty"`
+}
+
+// WSCatchUpAck is the payload of a WSTypeCatchUpAck frame
+type WSCatchUpAck struct {
+	// LatestSeq is the highest seq the agent has sent
+	LatestSeq uint64 `json:"latest_seq"`
+	// NextSeq is the from_seq of the next catch_up, if HasMore
+	NextSeq uint64 `json:"next_seq"`
+	// HasMore is set if responses up to LatestSeq were left out for the limit
+	HasMore bool `json:"has_more"`
+}
+
+// catchUp answers a catch_up frame: the agent's responses from the frame's from_seq are
+// sent as agent_message frames tagged with the frame's request ID, followed by a
+// catch_up_ack. The agent's live responses are held back meanwhile and sent after, less
+// the ones the catch-up replayed, so the client sees every seq once across the splice. It
+// returns an error only if the client is gone.
+func (x *Proxy) catchUp(ctx context.Context, s *streamSession, frame WSFrame) error {
+	var req WSCatchUp
+	if len(frame.Payload) > 0 {
+		if err := json.Unmarshal(frame.Payload, &req); err != nil {
+			_ = s.ws.writeError(frame.RequestID, WSErrorCodeInvalidFrame, "catch_up frames need a payload with from_seq")
+			return nil
+		}
+	}
+	if req.Limit < 0 || req.Limit > maxListMessagesLimit {
+		_ = s.ws.writeError(frame.RequestID, WSErrorCodeInvalidFrame, fmt.Sprintf("limit must be between 1 and %d", maxListMessagesLimit))
+		return nil
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultListMessagesLimit
+	}
+	requestID := frame.RequestID
+	if requestID == "" {
+		requestID = generateRequestID()
+	}
+
+	s.splice.hold()
+	replayed, err := x.replay(ctx, s, requestID, req)
+	if flushErr := s.splice.release(replayed, func(resp *agentv1.AgentResponse) error {
+		return x.sendAgentResponse(s, resp)
+	}); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// replay sends the agent's responses for a catch-up and its ack, returning the seqs sent
+func (x *Proxy) replay(ctx context.Context, s *streamSession, requestID string, req WSCatchUp) ([]uint64, error) {
+	history, err := x.processor.CatchUp(ctx, s.userID, s.agentID, req.FromSeq, int32(req.Limit))
+	if err != nil {
+		code := WSErrorCodeCatchUpFailed
+		if connect.CodeOf(err) == connect.CodeUnimplemented {
+			code = agent.ErrorCodeUnsupportedByAgent
+		}
+		_ = s.ws.writeError(requestID, code, err.Error())
+		return nil, nil
+	}
+
+	// The replay waits for room in the send queue: the client asked for every response
+	replayed := make([]uint64, 0, len(history.GetResponses()))
+	ack := WSCatchUpAck{LatestSeq: history.GetLatestSeq(), NextSeq: max(req.FromSeq, history.GetLatestSeq()+1)}
+	for _, resp := range history.GetResponses() {
+		replayed = append(replayed, resp.GetSeq())
+		ack.NextSeq = resp.GetSeq() + 1
+		// Artifacts are not streamed live either
+		if resp.GetArtifact() != nil {
+			continue
+		}
+		payload := webhook.AgentResponseToPayload(resp, s.agentID, resp.GetRequestId())
+		if err := s.ws.sendFrame(WSTypeAgentMessage, requestID, payload); err != nil {
+			return replayed, err
+		}
+		s.conn.toClient.Add(1)
+	}
+	ack.HasMore = ack.NextSeq <= ack.LatestSeq
+	return replayed, s.ws.sendFrame(WSTypeCatchUpAck, requestID, ack)
+}
+
+// catchUpSplice splices a catch-up into an agent's live responses. While a catch-up is
+// replayed the live responses are held; once it is done they are released, less those
+// the catch-up replayed. Responses the catch-up replayed are also skipped if they arrive
+// live later, as the agent may have sent them while it answered the catch-up.
+type catchUpSplice struct {
+	mu       sync.Mutex
+	holding  bool
+	held     []*agentv1.AgentResponse
+	replayed map[uint64]struct{}
+	last     uint64 // highest seq replayed
+}
+
+// relay sends a live response with send, unless a catch-up holds it or already replayed it
+func (c *catchUpSplice) relay(resp *agentv1.AgentResponse, send func(*agentv1.AgentResponse) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.holding {
+		c.held = append(c.held, resp)
+		return nil
+	}
+	if c.wasReplayed(resp.GetSeq()) {
+		return nil
+	}
+	return send(resp)
+}
+
+// hold holds live responses back until release
+func (c *catchUpSplice) hold() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holding = true
+}
+
+// release sends the live responses held back with send, less the replayed seqs, and lets
+// the next ones through. Sending under the lock keeps live responses in order.
+func (c *catchUpSplice) release(replayed []uint64, send func(*agentv1.AgentResponse) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.holding = false
+	c.replayed = make(map[uint64]struct{}, len(replayed))
+	c.last = 0
+	for _, seq := range replayed {
+		c.replayed[seq] = struct{}{}
+		c.last = max(c.last, seq)
+	}
+
+	held := c.held
+	c.held = nil
+	for _, resp := range held {
+		if c.wasReplayed(resp.GetSeq()) {
+			continue
+		}
+		if err := send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wasReplayed reports whether the last catch-up replayed seq; c.mu must be held. Seqs only
+// grow, so the replayed seqs are forgotten once a later one arrives.
+func (c *catchUpSplice) wasReplayed(seq uint64) bool {
+	if c.replayed == nil || seq == 0 {
+		return false
+	}
+	if seq > c.last {
+		c.replayed = nil
+		return false
+	}
+	_, ok := c.replayed[seq]
+	return ok
+}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/gorilla/websocket"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+)
+
+// spliceAgentService has sent seqs 1-3 before a client connects, and answers each message
+// with the next seq. When a client first catches up it sends two more seqs live, so they
+// race the catch-up that replays them.
+type spliceAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+
+	live     chan chan struct{}
+	liveOnce sync.Once
+
+	mu      sync.Mutex
+	history []*agentv1.AgentResponse
+}
+
+func newSpliceAgentService() *spliceAgentService {
+	s := &spliceAgentService{live: make(chan chan struct{})}
+	for seq := range uint64(3) {
+		s.history = append(s.history, spliceResponse(seq+1, "req-0"))
+	}
+	return s
+}
+
+func spliceResponse(seq uint64, requestID string) *agentv1.AgentResponse {
+	return &agentv1.AgentResponse{Seq: seq, RequestId: requestID, SessionId: "ses-1", Payload: &agentv1.AgentResponse_Event{
+		Event: &agentv1.EventPayload{EventType: "message.updated", EventJson: []byte(`{}`)},
+	}}
+}
+
+// send sends the next seq for requestID
+func (s *spliceAgentService) send(stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse], requestID string) error {
+	s.mu.Lock()
+	resp := spliceResponse(uint64(len(s.history)+1), requestID)
+	s.history = append(s.history, resp)
+	s.mu.Unlock()
+	return stream.Send(resp)
+}
+
+func (s *spliceAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	reqs := make(chan *agentv1.AgentRequest)
+	go func() {
+		defer close(reqs)
+		for {
+			req, err := stream.Receive()
+			if err != nil {
+				return
+			}
+			reqs <- req
+		}
+	}()
+
+	for {
+		select {
+		case done := <-s.live:
+			for range 2 {
+				if err := s.send(stream, "req-1"); err != nil {
+					return err
+				}
+			}
+			close(done)
+		case req, ok := <-reqs:
+			if !ok {
+				return nil
+			}
+			if err := s.send(stream, req.GetRequestId()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *spliceAgentService) CatchUp(
+	ctx context.Context,
+	req *connect.Request[agentv1.CatchUpRequest],
+) (*connect.Response[agentv1.CatchUpResponse], error) {
+	s.liveOnce.Do(func() {
+		done := make(chan struct{})
+		s.live <- done
+		<-done
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &agentv1.CatchUpResponse{LatestSeq: s.history[len(s.history)-1].GetSeq()}
+	for _, msg := range s.history {
+		if msg.GetSeq() >= req.Msg.GetFromSeq() && len(resp.Responses) < int(req.Msg.GetLimit()) {
+			resp.Responses = append(resp.Responses, msg)
+		}
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// frameSummary reads the next frame from conn as "<type> <request_id> <seq or ack>"
+func frameSummary(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	frame := readFrame(t, conn)
+	switch frame.Type {
+	case WSTypeAgentMessage:
+		var payload struct {
+			Seq uint64 `json:"seq"`
+		}
+		_ = json.Unmarshal(frame.Payload, &payload)
+		return fmt.Sprintf("%s %s %d", frame.Type, frame.RequestID, payload.Seq)
+	case WSTypeCatchUpAck:
+		var ack WSCatchUpAck
+		_ = json.Unmarshal(frame.Payload, &ack)
+		return fmt.Sprintf("%s %s %+v", frame.Type, frame.RequestID, ack)
+	default:
+		return fmt.Sprintf("%s %s %s", frame.Type, frame.RequestID, frame.Payload)
+	}
+}
+
+func TestProxy_CatchesUpWithoutGapsOrDuplicates(t *testing.T) {
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, newSpliceAgentService())))
+	conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+
+	sendMessage(t, conn, "req-1")
+	if got := frameSummary(t, conn); got != "agent_message req-1 4" {
+		t.Fatalf("expected seq 4 live, got %s", got)
+	}
+
+	// Seqs 5 and 6 stream in while the catch-up runs, and are replayed by it
+	if err := conn.WriteJSON(WSFrame{Type: WSTypeCatchUp, RequestID: "cu-1", Payload: json.RawMessage(`{"from_seq":1}`)}); err != nil {
+		t.Fatalf("failed to send catch_up: %v", err)
+	}
+	sendMessage(t, conn, "req-2")
+	var got []string
+	for range 8 {
+		got = append(got, frameSummary(t, conn))
+	}
+	if err := conn.WriteJSON(WSFrame{Type: WSTypeCatchUp, RequestID: "cu-2", Payload: json.RawMessage(`{"from_seq":2,"limit":2}`)}); err != nil {
+		t.Fatalf("failed to send catch_up: %v", err)
+	}
+	for range 3 {
+		got = append(got, frameSummary(t, conn))
+	}
+
+	want := []string{
+		"agent_message cu-1 1",
+		"agent_message cu-1 2",
+		"agent_message cu-1 3",
+		"agent_message cu-1 4",
+		"agent_message cu-1 5",
+		"agent_message cu-1 6",
+		"catch_up_ack cu-1 {LatestSeq:6 NextSeq:7 HasMore:false}",
+		"agent_message req-2 7",
+		"agent_message cu-2 2",
+		"agent_message cu-2 3",
+		"catch_up_ack cu-2 {LatestSeq:7 NextSeq:4 HasMore:true}",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected frames:\n%v\ngot:\n%v", want, got)
+	}
+}
+
+func TestProxy_RejectsInvalidCatchUps(t *testing.T) {
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})))
+	conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+
+	for _, payload := range []string{`{"from_seq":-1}`, `{"limit":1001}`} {
+		if err := conn.WriteJSON(WSFrame{Type: WSTypeCatchUp, RequestID: "cu-1", Payload: json.RawMessage(payload)}); err != nil {
+			t.Fatalf("failed to send catch_up: %v", err)
+		}
+		frame := readFrame(t, conn)
+		var wsErr WSError
+		if err := json.Unmarshal(frame.Payload, &wsErr); err != nil || frame.Type != WSTypeError || wsErr.Code != WSErrorCodeInvalidFrame {
+			t.Errorf("%s: expected an %s error frame, got %s %s", payload, WSErrorCodeInvalidFrame, frame.Type, frame.Payload)
+		}
+	}
+
+	// chatAgentService does not implement CatchUp
+	if err := conn.WriteJSON(WSFrame{Type: WSTypeCatchUp, RequestID: "cu-2"}); err != nil {
+		t.Fatalf("failed to send catch_up: %v", err)
+	}
+	frame := readFrame(t, conn)
+	var wsErr WSError
+	if err := json.Unmarshal(frame.Payload, &wsErr); err != nil || frame.RequestID != "cu-2" || wsErr.Code == "" {
+		t.Errorf("expected an error frame for cu-2, got %s %s %s", frame.Type, frame.RequestID, frame.Payload)
+	}
+
+	// The stream is still live
+	sendMessage(t, conn, "req-1")
+	if event := readPayload(t, conn); event.RequestID != "req-1" {
+		t.Errorf("expected the agent's event, got %+v", event)
+	}
+}
+
+func TestCatchUpSplice_SkipsReplayedResponses(t *testing.T) {
+	var splice catchUpSplice
+	var sent []uint64
+	send := func(resp *agentv1.AgentResponse) error {
+		sent = append(sent, resp.GetSeq())
+		return nil
+	}
+	relay := func(seqs ...uint64) {
+		for _, seq := range seqs {
+			_ = splice.relay(&agentv1.AgentResponse{Seq: seq}, send)
+		}
+	}
+
+	relay(1)
+	splice.hold()
+	relay(4, 5)
+	if len(sent) != 1 {
+		t.Fatalf("expected responses held during a catch-up, sent %v", sent)
+	}
+	_ = splice.release([]uint64{2, 3, 4, 5, 6}, send)
+	// Seq 6 reached the catch-up before it streamed in
+	relay(6, 7)
+
+	splice.hold()
+	relay(8, 9)
+	_ = splice.release([]uint64{8}, send)
+
+	if want := []uint64{1, 7, 9}; !slices.Equal(sent, want) {
+		t.Errorf("expected seqs %v sent live, got %v", want, sent)
+	}
+}
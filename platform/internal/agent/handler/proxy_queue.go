@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// Policies for a stream client's full send queue
+const (
+	// WSOverflowDropOldest drops the oldest progress event queued to make room, and
+	// disconnects the client only if nothing queued may be dropped
+	WSOverflowDropOldest = "drop_oldest"
+	// WSOverflowDisconnect disconnects the client as soon as its queue is full
+	WSOverflowDisconnect = "disconnect"
+)
+
+// Defaults of the stream send queues
+const (
+	defaultWSSendQueueSize = 256
+	defaultWSWriteTimeout  = 10 * time.Second
+	defaultWSMaxFrameBytes = 64 << 10
+)
+
+// wsReadLimitFactor is how far past the frame size limit a single client frame may go
+// before the client is disconnected, rather than answered with an error frame
+const wsReadLimitFactor = 16
+
+// errSendQueueOverflow is returned for a frame that does not fit a client's send queue
+var errSendQueueOverflow = stderrors.New("send queue overflow")
+
+// ProxyMetrics counts how the stream send queues fared on this replica since it started
+type ProxyMetrics struct {
+	// QueuedFrames is how many frames wait in send queues now
+	QueuedFrames int64 `json:"queued_frames"`
+	// MaxQueueDepth is the most frames one send queue has held
+	MaxQueueDepth int64 `json:"max_queue_depth"`
+	// DroppedEvents counts progress events dropped from full queues
+	DroppedEvents int64 `json:"dropped_events"`
+	// Overflows counts clients disconnected for a full queue
+	Overflows int64 `json:"overflows"`
+	// OversizedFrames counts client frames refused for their size
+	OversizedFrames int64 `json:"oversized_frames"`
+}
+
+// proxyMetrics holds the ProxyMetrics counters
+type proxyMetrics struct {
+	queued    atomic.Int64
+	maxDepth  atomic.Int64
+	dropped   atomic.Int64
+	overflows atomic.Int64
+	oversized atomic.Int64
+}
+
+// recordDepth raises the max queue depth to depth
+func (m *proxyMetrics) recordDepth(depth int64) {
+	for {
+		current := m.maxDepth.Load()
+		if depth <= current || m.maxDepth.CompareAndSwap(current, depth) {
+			return
+		}
+	}
+}
+
+// snapshot returns the current counts
+func (m *proxyMetrics) snapshot() ProxyMetrics {
+	return ProxyMetrics{
+		QueuedFrames:    m.queued.Load(),
+		MaxQueueDepth:   m.maxDepth.Load(),
+		DroppedEvents:   m.dropped.Load(),
+		Overflows:       m.overflows.Load(),
+		OversizedFrames: m.oversized.Load(),
+	}
+}
+
+// droppablePayload reports whether an agent message may be dropped for a slow client:
+// progress events may, as later ones supersede them, but message updates, final payloads
+// and errors are always delivered
+func droppablePayload(payload webhook.Payload) bool {
+	return payload.EventType == webhook.EventTypeEvent && !payload.IsFinal &&
+		payload.OpenCodeEventType != "message.updated"
+}
+
+// queuedFrame is a frame waiting in a send queue
+type queuedFrame struct {
+	data      []byte
+	droppable bool
+}
+
+// closeFrame is the close frame sent once a send queue drains
+type closeFrame struct {
+	code   int
+	reason string
+}
+
+// wsConn is a stream client's WebSocket. Frames to the client wait in a bounded queue
+// that one writer drains with a deadline per frame, so a slow client holds up neither
+// the agent stream nor the proxy; the writer closes the socket if a write fails.
+type wsConn struct {
+	conn         *websocket.Conn
+	size         int
+	policy       string
+	writeTimeout time.Duration
+	metrics      *proxyMetrics
+
+	mu      sync.Mutex
+	room    *sync.Cond // signaled as frames leave the queue
+	queue   []queuedFrame
+	closing *closeFrame
+	stopped bool
+	wake    chan struct{}
+	done    chan struct{} // closed once the writer is done
+}
+
+// newWSConn wraps conn with the send queue settings of x and starts its writer
+func (x *Proxy) newWSConn(conn *websocket.Conn) *wsConn {
+	w := &wsConn{
+		conn:         conn,
+		size:         x.sendQueueSize,
+		policy:       x.overflowPolicy,
+		writeTimeout: x.writeTimeout,
+		metrics:      &x.metrics,
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	w.room = sync.NewCond(&w.mu)
+	go w.writeLoop()
+	return w
+}
+
+// writeFrame queues a frame with payload marshaled as JSON. A droppable frame may be
+// dropped for a later one if the client falls behind. It returns errSendQueueOverflow if
+// the frame does not fit the queue; the client should then be disconnected.
+func (w *wsConn) writeFrame(frameType, requestID string, payload any, droppable bool) error {
+	data, err := marshalFrame(frameType, requestID, payload)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped || w.closing != nil {
+		return websocket.ErrCloseSent
+	}
+	if len(w.queue) >= w.size {
+		if w.policy != WSOverflowDropOldest {
+			return errSendQueueOverflow
+		}
+		i := w.oldestDroppable()
+		switch {
+		case i >= 0:
+			w.queue = append(w.queue[:i], w.queue[i+1:]...)
+			w.metrics.queued.Add(-1)
+			w.metrics.dropped.Add(1)
+		case droppable:
+			w.metrics.dropped.Add(1)
+			return nil
+		default:
+			return errSendQueueOverflow
+		}
+	}
+	w.push(queuedFrame{data: data, droppable: droppable})
+	return nil
+}
+
+// sendFrame queues a frame that may not be dropped, waiting for room in the queue rather
+// than overflowing it. The wait is bounded by the writer, which writes a frame within the
+// write timeout or closes the socket.
+func (w *wsConn) sendFrame(frameType, requestID string, payload any) error {
+	data, err := marshalFrame(frameType, requestID, payload)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for !w.stopped && w.closing == nil && len(w.queue) >= w.size {
+		w.room.Wait()
+	}
+	if w.stopped || w.closing != nil {
+		return websocket.ErrCloseSent
+	}
+	w.push(queuedFrame{data: data})
+	return nil
+}
+
+// push appends frame to the queue and wakes the writer; w.mu must be held
+func (w *wsConn) push(frame queuedFrame) {
+	w.queue = append(w.queue, frame)
+	w.metrics.queued.Add(1)
+	w.metrics.recordDepth(int64(len(w.queue)))
+	w.signal()
+}
+
+// marshalFrame encodes a frame with payload marshaled as JSON
+func marshalFrame(frameType, requestID string, payload any) ([]byte, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(WSFrame{Type: frameType, RequestID: requestID, Payload: raw})
+}
+
+// oldestDroppable returns the index of the oldest droppable frame queued, or -1
+func (w *wsConn) oldestDroppable() int {
+	for i, f := range w.queue {
+		if f.droppable {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeError queues a WSTypeError frame
+func (w *wsConn) writeError(requestID, code, message string) error {
+	return w.writeFrame(WSTypeError, requestID, WSError{Code: code, Message: message}, false)
+}
+
+// signal wakes the writer
+func (w *wsConn) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes queued frames until the connection is stopped or closed
+func (w *wsConn) writeLoop() {
+	defer close(w.done)
+	for {
+		w.mu.Lock()
+		switch {
+		case w.stopped:
+			w.mu.Unlock()
+			return
+		case len(w.queue) > 0:
+			frame := w.queue[0]
+			w.queue = w.queue[1:]
+			w.metrics.queued.Add(-1)
+			w.room.Broadcast()
+			w.mu.Unlock()
+
+			_ = w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+			if err := w.conn.WriteMessage(websocket.TextMessage, frame.data); err != nil {
+				w.stop()
+				_ = w.conn.Close()
+				return
+			}
+		case w.closing != nil:
+			closing := *w.closing
+			w.mu.Unlock()
+			w.stop()
+			_ = w.writeClose(closing.code, closing.reason)
+			_ = w.conn.Close()
+			return
+		default:
+			w.mu.Unlock()
+			<-w.wake
+		}
+	}
+}
+
+// closeWith closes the WebSocket with code and reason once the frames queued before were
+// written
+func (w *wsConn) closeWith(code int, reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closing == nil {
+		w.closing = &closeFrame{code: code, reason: reason}
+	}
+	w.room.Broadcast()
+	w.signal()
+}
+
+// abort closes the WebSocket with code and reason right away, dropping the frames queued
+func (w *wsConn) abort(code int, reason string) {
+	w.stop()
+	_ = w.writeClose(code, reason)
+	_ = w.conn.Close()
+}
+
+// close closes the WebSocket of a client that went away
+func (w *wsConn) close() {
+	w.stop()
+	_ = w.conn.Close()
+}
+
+// stop ends the writer, dropping the frames queued
+func (w *wsConn) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		w.metrics.queued.Add(-int64(len(w.queue)))
+		w.queue = nil
+	}
+	w.room.Broadcast()
+	w.signal()
+}
+
+// writeClose sends a close frame with code and reason
+func (w *wsConn) writeClose(code int, reason string) error {
+	msg := websocket.FormatCloseMessage(code, reason)
+	return w.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsControlTimeout))
+}
+
+// extendReadDeadline gives the client timeout to send its next frame or pong
+func (w *wsConn) extendReadDeadline(timeout time.Duration) error {
+	return w.conn.SetReadDeadline(time.Now().Add(timeout))
+}
@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// pipeResponseWriter hands the server end of a net.Pipe to a WebSocket upgrade
+type pipeResponseWriter struct {
+	httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (w *pipeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// pipeClient is the client end of a stream over a net.Pipe. Nothing is read from the
+// server until the test reads, so the server's writes block as on a stalled client.
+type pipeClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// readFrame reads the next frame the server wrote, returning its opcode and payload
+func (c *pipeClient) readFrame(t *testing.T) (int, []byte) {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatalf("failed to read frame header: %v", err)
+	}
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, _ = io.ReadFull(c.r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, _ = io.ReadFull(c.r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatalf("failed to read frame payload: %v", err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+// readSeq reads the next agent message the server wrote and returns its seq
+func (c *pipeClient) readSeq(t *testing.T) uint64 {
+	t.Helper()
+	opcode, data := c.readFrame(t)
+	if opcode != websocket.TextMessage {
+		t.Fatalf("expected a text frame, got opcode %d", opcode)
+	}
+	var frame WSFrame
+	var payload webhook.Payload
+	if err := json.Unmarshal(data, &frame); err != nil || json.Unmarshal(frame.Payload, &payload) != nil {
+		t.Fatalf("failed to decode frame %s", data)
+	}
+	return payload.Seq
+}
+
+// pipeStream upgrades the server end of a net.Pipe for x and returns its wsConn and the
+// client end
+func pipeStream(t *testing.T, x *Proxy) (*wsConn, *pipeClient) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close(); client.Close() })
+
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	pc := &pipeClient{conn: client, r: bufio.NewReader(client)}
+	handshake := make(chan error, 1)
+	go func() {
+		resp, err := http.ReadResponse(pc.r, req)
+		if err == nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			err = stderrors.New(resp.Status)
+		}
+		handshake <- err
+	}()
+
+	conn, err := x.upgrader.Upgrade(&pipeResponseWriter{conn: server}, req, nil)
+	if err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+	if err := <-handshake; err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	ws := x.newWSConn(conn)
+	t.Cleanup(ws.close)
+	return ws, pc
+}
+
+// progressEvent is a droppable agent message
+func progressEvent(seq uint64) webhook.Payload {
+	return webhook.Payload{EventType: webhook.EventTypeEvent, OpenCodeEventType: "message.part.updated", Seq: seq}
+}
+
+// waitForQueue waits until the writer took every queued frame
+func waitForQueue(t *testing.T, ws *wsConn) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ws.mu.Lock()
+		n := len(ws.queue)
+		ws.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("writer did not take the queued frames, %d left", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func queueFrame(t *testing.T, ws *wsConn, payload webhook.Payload) error {
+	t.Helper()
+	return ws.writeFrame(WSTypeAgentMessage, "req-1", payload, droppablePayload(payload))
+}
+
+func TestSendQueue_DropsOldestProgressEvents(t *testing.T) {
+	x := NewProxy(nil, nil, zap.NewNop())
+	x.sendQueueSize = 4
+	ws, client := pipeStream(t, x)
+
+	// The writer blocks on seq 1 until the client reads
+	if err := queueFrame(t, ws, progressEvent(1)); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+	waitForQueue(t, ws)
+
+	update := webhook.Payload{EventType: webhook.EventTypeEvent, OpenCodeEventType: "message.updated", Seq: 2}
+	complete := webhook.Payload{EventType: webhook.EventTypeComplete, IsFinal: true, Success: true, Seq: 8}
+	for _, payload := range []webhook.Payload{
+		update, progressEvent(3), progressEvent(4), progressEvent(5), progressEvent(6), progressEvent(7), complete,
+	} {
+		if err := queueFrame(t, ws, payload); err != nil {
+			t.Fatalf("seq %d: %v", payload.Seq, err)
+		}
+	}
+
+	want := []uint64{1, 2, 6, 7, 8}
+	for _, seq := range want {
+		if got := client.readSeq(t); got != seq {
+			t.Fatalf("expected seqs %v, got %d for %d", want, got, seq)
+		}
+	}
+	metrics := x.Metrics()
+	if metrics.DroppedEvents != 3 || metrics.MaxQueueDepth != 4 || metrics.Overflows != 0 {
+		t.Errorf("expected 3 dropped events at queue depth 4, got %+v", metrics)
+	}
+	waitForQueue(t, ws)
+	if queued := x.Metrics().QueuedFrames; queued != 0 {
+		t.Errorf("expected no frames left queued, got %d", queued)
+	}
+}
+
+func TestSendQueue_Overflows(t *testing.T) {
+	final := webhook.Payload{EventType: webhook.EventTypeComplete, IsFinal: true}
+	tests := []struct {
+		name    string
+		policy  string
+		payload webhook.Payload
+	}{
+		{"disconnect policy", WSOverflowDisconnect, progressEvent(2)},
+		{"nothing to drop", WSOverflowDropOldest, final},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := NewProxy(nil, nil, zap.NewNop())
+			x.sendQueueSize = 2
+			x.overflowPolicy = tt.policy
+			ws, _ := pipeStream(t, x)
+
+			if err := queueFrame(t, ws, progressEvent(1)); err != nil {
+				t.Fatalf("writeFrame: %v", err)
+			}
+			waitForQueue(t, ws)
+			for range 2 {
+				if err := queueFrame(t, ws, final); err != nil {
+					t.Fatalf("writeFrame: %v", err)
+				}
+			}
+			if err := queueFrame(t, ws, tt.payload); !stderrors.Is(err, errSendQueueOverflow) {
+				t.Errorf("expected errSendQueueOverflow, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSendQueue_WriteDeadlineClosesStalledClient(t *testing.T) {
+	x := NewProxy(nil, nil, zap.NewNop())
+	x.writeTimeout = 50 * time.Millisecond
+	ws, _ := pipeStream(t, x)
+
+	if err := queueFrame(t, ws, progressEvent(1)); err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+	select {
+	case <-ws.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the writer to give up on a client that reads nothing")
+	}
+	if err := queueFrame(t, ws, progressEvent(2)); err == nil {
+		t.Error("expected frames refused once the client was closed")
+	}
+}
+
+func TestProxy_RefusesOversizedFrames(t *testing.T) {
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})), func(x *Proxy) {
+		x.maxFrameBytes = 1024
+	})
+	// A write buffer this large sends each frame whole rather than in fragments
+	dialer := websocket.Dialer{WriteBufferSize: 64 << 10}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/users/user1/agents/agent1/stream", nil)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	big := `{"type":"message","payload":{"content":"` + strings.Repeat("x", 2048) + `"}}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatalf("failed to send frame: %v", err)
+	}
+	frame := readFrame(t, conn)
+	var wsErr WSError
+	if err := json.Unmarshal(frame.Payload, &wsErr); err != nil || frame.Type != WSTypeError || wsErr.Code != WSErrorCodeFrameTooLarge {
+		t.Fatalf("expected a %s error frame, got %s %s", WSErrorCodeFrameTooLarge, frame.Type, frame.Payload)
+	}
+
+	// The stream is still usable
+	sendMessage(t, conn, "req-1")
+	if event := readPayload(t, conn); event.RequestID != "req-1" {
+		t.Errorf("expected the agent's event, got %+v", event)
+	}
+
+	// Frames far over the limit disconnect the client
+	huge := make([]byte, 1024*wsReadLimitFactor+1)
+	if err := conn.WriteMessage(websocket.TextMessage, huge); err != nil {
+		t.Fatalf("failed to send frame: %v", err)
+	}
+	readPayload(t, conn) // the agent's completion of req-1
+	if closeErr := readClose(t, conn); closeErr.Code != websocket.CloseMessageTooBig {
+		t.Errorf("expected close %d, got %d", websocket.CloseMessageTooBig, closeErr.Code)
+	}
+}
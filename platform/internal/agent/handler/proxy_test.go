@@ -0,0 +1,640 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// chatAgentService answers each message on a Connect stream with an event and a
+// completion, and each interrupt with a failed completion
+type chatAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+
+	mu       sync.Mutex
+	requests []*agentv1.AgentRequest
+}
+
+func (s *chatAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	var seq uint64
+	for {
+		req, err := stream.Receive()
+		if err != nil {
+			return nil
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		var responses []*agentv1.AgentResponse
+		if msg := req.GetSendMessage(); msg != nil {
+			seq++
+			responses = append(responses, &agentv1.AgentResponse{Seq: seq, Payload: &agentv1.AgentResponse_Event{
+				Event: &agentv1.EventPayload{EventType: "message.updated", EventJson: []byte(`{"text":"` + msg.GetContent() + `"}`)},
+			}})
+		}
+		seq++
+		responses = append(responses, &agentv1.AgentResponse{Seq: seq, Payload: &agentv1.AgentResponse_Complete{
+			Complete: &agentv1.CompletePayload{Success: req.GetSendMessage() != nil},
+		}})
+		for _, resp := range responses {
+			resp.RequestId = req.GetRequestId()
+			resp.SessionId = "ses-1"
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// startProxyServer serves the stream proxy for proc over HTTP, after applying configure
+func startProxyServer(t *testing.T, proc AgentProcessor, configure ...func(*Proxy)) *httptest.Server {
+	t.Helper()
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	x := NewProxy(proc, nil, zap.NewNop())
+	for _, f := range configure {
+		f(x)
+	}
+	x.Register(e)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialStream opens a WebSocket to path on server
+func dialStream(t *testing.T, server *httptest.Server, path string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// readFrame reads the next frame from conn
+func readFrame(t *testing.T, conn *websocket.Conn) WSFrame {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame WSFrame
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	return frame
+}
+
+// readPayload reads the next frame from conn, which must be an agent message
+func readPayload(t *testing.T, conn *websocket.Conn) webhook.Payload {
+	t.Helper()
+	frame := readFrame(t, conn)
+	if frame.Type != WSTypeAgentMessage {
+		t.Fatalf("expected an %s frame, got %s: %s", WSTypeAgentMessage, frame.Type, frame.Payload)
+	}
+	var payload webhook.Payload
+	if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.RequestID != frame.RequestID {
+		t.Errorf("expected the frame tagged with request %s, got %s", payload.RequestID, frame.RequestID)
+	}
+	return payload
+}
+
+func TestProxy_BridgesAgentStream(t *testing.T) {
+	svc := &chatAgentService{}
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc)))
+
+	for _, path := range []string{
+		"/api/v1/users/user1/agents/agent1/stream",
+		"/api/v1/agents/agent1/stream?user_id=user1",
+	} {
+		conn, _, err := dialStream(t, server, path)
+		if err != nil {
+			t.Fatalf("%s: failed to open stream: %v", path, err)
+		}
+
+		if err := conn.WriteJSON(WSFrame{Type: WSTypeMessage, RequestID: "req-1", Payload: json.RawMessage(`{"content":"hi"}`)}); err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+		event := readPayload(t, conn)
+		if event.EventType != webhook.EventTypeEvent || event.RequestID != "req-1" || event.AgentID != "agent1" ||
+			event.Seq != 1 || string(event.Event) != `{"text":"hi"}` {
+			t.Errorf("%s: expected the agent's event, got %+v", path, event)
+		}
+		if complete := readPayload(t, conn); complete.EventType != webhook.EventTypeComplete || !complete.Success || !complete.IsFinal {
+			t.Errorf("%s: expected agent.complete, got %+v", path, complete)
+		}
+
+		// A second message goes over the same agent stream, with a generated request ID
+		if err := conn.WriteJSON(WSFrame{Type: WSTypeMessage, Payload: json.RawMessage(`{"content":"again"}`)}); err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+		if event := readPayload(t, conn); !strings.HasPrefix(event.RequestID, "req_") || event.Seq != 3 {
+			t.Errorf("%s: expected a generated request ID and seq 3, got %+v", path, event)
+		}
+		readPayload(t, conn)
+
+		if err := conn.WriteJSON(WSFrame{Type: WSTypeInterrupt, RequestID: "req-2"}); err != nil {
+			t.Fatalf("failed to send interrupt: %v", err)
+		}
+		if complete := readPayload(t, conn); complete.RequestID != "req-2" || complete.Success {
+			t.Errorf("%s: expected the interrupted completion, got %+v", path, complete)
+		}
+		conn.Close()
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.requests) != 6 || svc.requests[2].GetInterrupt() == nil {
+		t.Errorf("expected two messages and an interrupt per stream, got %v", svc.requests)
+	}
+}
+
+func TestProxy_RejectsInvalidFrames(t *testing.T) {
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})))
+	conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+
+	for _, raw := range []string{
+		`not json`,
+		`{"type":"unknown"}`,
+		`{"type":"message","request_id":"req-1","payload":{}}`,
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(raw)); err != nil {
+			t.Fatalf("failed to send frame: %v", err)
+		}
+		frame := readFrame(t, conn)
+		var wsErr WSError
+		if err := json.Unmarshal(frame.Payload, &wsErr); err != nil || frame.Type != WSTypeError || wsErr.Code != WSErrorCodeInvalidFrame {
+			t.Errorf("%s: expected an %s error frame, got %s %s", raw, WSErrorCodeInvalidFrame, frame.Type, frame.Payload)
+		}
+	}
+
+	// The stream is still usable
+	if err := conn.WriteJSON(WSFrame{Type: WSTypeMessage, RequestID: "req-1", Payload: json.RawMessage(`{"content":"hi"}`)}); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	if event := readPayload(t, conn); event.RequestID != "req-1" {
+		t.Errorf("expected the agent's event, got %+v", event)
+	}
+}
+
+func TestProxy_RejectsUnavailableAgentsBeforeUpgrade(t *testing.T) {
+	tests := []struct {
+		name string
+		proc *processor.Processor
+		path string
+		want int
+	}{
+		{"missing user", createTestProcessor(t), "/api/v1/agents/agent1/stream", http.StatusBadRequest},
+		{"missing agent", createTestProcessor(t), "/api/v1/users/user1/agents/agent1/stream", http.StatusNotFound},
+		{"pending pod", createTestProcessor(t, createPendingPod("user1", "agent1")), "/api/v1/users/user1/agents/agent1/stream", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, resp, err := dialStream(t, startProxyServer(t, tt.proc), tt.path)
+			if err == nil {
+				t.Fatal("expected the upgrade to be refused")
+			}
+			if resp == nil || resp.StatusCode != tt.want {
+				t.Fatalf("expected %d, got %v", tt.want, resp)
+			}
+		})
+	}
+}
+
+// fastKeepalive pings stream clients every 20ms and drops them after 100ms without a pong
+func fastKeepalive(x *Proxy) {
+	x.pingInterval = 20 * time.Millisecond
+	x.pongTimeout = 100 * time.Millisecond
+}
+
+// sendMessage sends a message frame for requestID
+func sendMessage(t *testing.T, conn *websocket.Conn, requestID string) {
+	t.Helper()
+	if err := conn.WriteJSON(WSFrame{Type: WSTypeMessage, RequestID: requestID, Payload: json.RawMessage(`{"content":"hi"}`)}); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+}
+
+// readClose reads frames from conn until it is closed and returns the close error
+func readClose(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if !stderrors.As(err, &closeErr) {
+				t.Fatalf("expected a close frame, got %v", err)
+			}
+			return closeErr
+		}
+	}
+}
+
+func TestProxy_KeepsAnsweringClientsAlive(t *testing.T) {
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})), fastKeepalive)
+	conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+
+	var pings atomic.Int32
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	frames := make(chan WSFrame)
+	go func() {
+		defer close(frames)
+		for {
+			var frame WSFrame
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	// Idle for several pong timeouts, answering pings only
+	time.Sleep(400 * time.Millisecond)
+	if pings.Load() < 5 {
+		t.Errorf("expected a ping every 20ms, got %d", pings.Load())
+	}
+	sendMessage(t, conn, "req-1")
+	select {
+	case frame, ok := <-frames:
+		if !ok || frame.Type != WSTypeAgentMessage {
+			t.Fatalf("expected the stream to stay open, got %+v (open %v)", frame, ok)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the agent's response")
+	}
+}
+
+func TestProxy_DisconnectsClientsMissingPongs(t *testing.T) {
+	svc := &scriptedAgentService{responses: []*agentv1.AgentResponse{eventResponse(1, "message.updated", `{}`)}, block: true, cancelled: make(chan struct{})}
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc)), fastKeepalive)
+	conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	conn.SetPingHandler(func(string) error { return nil })
+
+	sendMessage(t, conn, "req-1")
+	readPayload(t, conn)
+
+	// The client never answers a ping, so the proxy drops it and closes the agent stream
+	select {
+	case <-svc.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the agent stream closed after the client missed its pongs")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil || isTimeout(err) {
+		t.Errorf("expected the proxy to close the socket, got %v", err)
+	}
+}
+
+func TestProxy_ClosesWithAgentStreamOutcome(t *testing.T) {
+	complete := &agentv1.AgentResponse{Seq: 1, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}}
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   int
+		wantReason string
+	}{
+		{"agent stream ends", nil, websocket.CloseNormalClosure, "agent stream ended"},
+		{"agent unavailable", connect.NewError(connect.CodeUnavailable, stderrors.New("connection reset by peer")),
+			websocket.CloseInternalServerErr, processor.ErrorCodeAgentUnavailable},
+		{"agent error", connect.NewError(connect.CodeInternal, stderrors.New("agent crashed")),
+			websocket.CloseInternalServerErr, processor.ErrorCodeStreamError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &scriptedAgentService{responses: []*agentv1.AgentResponse{complete}, err: tt.err}
+			server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc)))
+			conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+			if err != nil {
+				t.Fatalf("failed to open stream: %v", err)
+			}
+
+			sendMessage(t, conn, "req-1")
+			readPayload(t, conn)
+			if tt.err != nil {
+				frame := readFrame(t, conn)
+				var wsErr WSError
+				if err := json.Unmarshal(frame.Payload, &wsErr); err != nil || frame.Type != WSTypeError || wsErr.Code != tt.wantReason {
+					t.Errorf("expected an error frame %s, got %s %s", tt.wantReason, frame.Type, frame.Payload)
+				}
+			}
+			if closeErr := readClose(t, conn); closeErr.Code != tt.wantCode || closeErr.Text != tt.wantReason {
+				t.Errorf("expected close %d %q, got %d %q", tt.wantCode, tt.wantReason, closeErr.Code, closeErr.Text)
+			}
+		})
+	}
+}
+
+// tokenAuthenticator accepts a fixed set of tokens
+type tokenAuthenticator map[string]handler.Principal
+
+func (a tokenAuthenticator) Authenticate(_ context.Context, token string) (handler.Principal, error) {
+	p, ok := a[token]
+	if !ok {
+		return handler.Principal{}, handler.ErrInvalidToken
+	}
+	return p, nil
+}
+
+func TestProxy_AuthenticatesUpgrades(t *testing.T) {
+	auth := tokenAuthenticator{
+		"tok-user1": {UserID: "user1"},
+		"tok-user2": {UserID: "user2"},
+		"tok-admin": {UserID: "ops", Admin: true},
+	}
+	server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})), func(x *Proxy) {
+		x.authenticator = auth
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"missing token", "/api/v1/users/user1/agents/agent1/stream", nil, http.StatusUnauthorized},
+		{"invalid token", "/api/v1/users/user1/agents/agent1/stream?token=nope", nil, http.StatusUnauthorized},
+		{"wrong user", "/api/v1/users/user1/agents/agent1/stream?token=tok-user2", nil, http.StatusForbidden},
+		{"wrong user on flat route", "/api/v1/agents/agent1/stream?user_id=user1&token=tok-user2", nil, http.StatusForbidden},
+		{"other user's own agents", "/api/v1/agents/agent1/stream?token=tok-user2", nil, http.StatusNotFound},
+		{"query token", "/api/v1/users/user1/agents/agent1/stream?token=tok-user1", nil, http.StatusSwitchingProtocols},
+		{"subprotocol token", "/api/v1/users/user1/agents/agent1/stream",
+			http.Header{"Sec-WebSocket-Protocol": {"bearer, tok-user1"}}, http.StatusSwitchingProtocols},
+		{"authorization header", "/api/v1/users/user1/agents/agent1/stream",
+			http.Header{"Authorization": {"Bearer tok-user1"}}, http.StatusSwitchingProtocols},
+		{"user from token", "/api/v1/agents/agent1/stream?token=tok-user1", nil, http.StatusSwitchingProtocols},
+		{"admin", "/api/v1/users/user1/agents/agent1/stream?token=tok-admin", nil, http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+tt.path, tt.header)
+			if resp == nil {
+				t.Fatalf("upgrade failed without a response: %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, resp.StatusCode)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if tt.header.Get("Sec-WebSocket-Protocol") != "" && conn.Subprotocol() != wsBearerProtocol {
+				t.Errorf("expected the bearer subprotocol accepted, got %q", conn.Subprotocol())
+			}
+			sendMessage(t, conn, "req-1")
+			readPayload(t, conn)
+		})
+	}
+}
+
+func TestProxy_ChecksOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    int
+	}{
+		{"no allowed origins", nil, "https://evil.example.com", http.StatusSwitchingProtocols},
+		{"allowed origin", []string{"https://app.example.com"}, "https://app.example.com", http.StatusSwitchingProtocols},
+		{"other origin", []string{"https://app.example.com"}, "https://evil.example.com", http.StatusForbidden},
+		{"no origin", []string{"https://app.example.com"}, "", http.StatusSwitchingProtocols},
+		{"wildcard", []string{"*"}, "https://evil.example.com", http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startProxyServer(t, createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, &chatAgentService{})), func(x *Proxy) {
+				x.allowedOrigins = tt.allowed
+			})
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/users/user1/agents/agent1/stream", header)
+			if err == nil {
+				conn.Close()
+			}
+			if resp == nil || resp.StatusCode != tt.want {
+				t.Fatalf("expected %d, got %v (%v)", tt.want, resp, err)
+			}
+		})
+	}
+}
+
+func TestProxy_ClosesStreamsOfDeletedAgents(t *testing.T) {
+	svc := &scriptedAgentService{responses: []*agentv1.AgentResponse{eventResponse(1, "message.updated", `{}`)}, block: true, cancelled: make(chan struct{})}
+	proc := createNodePortProcessor(t, "user1", "agent1", startMockAgent(t, svc))
+	server := startProxyServer(t, proc)
+	conn, _, err := dialStream(t, server, "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	sendMessage(t, conn, "req-1")
+	readPayload(t, conn)
+
+	if err := proc.DeleteAgent(context.Background(), "user1", "agent1", false); err != nil {
+		t.Fatalf("DeleteAgent: %v", err)
+	}
+
+	frame := readFrame(t, conn)
+	var status WSStatus
+	if err := json.Unmarshal(frame.Payload, &status); err != nil || frame.Type != WSTypeStatus || status.State != WSStateDeleted {
+		t.Errorf("expected a deleted status frame, got %s %s", frame.Type, frame.Payload)
+	}
+	if closeErr := readClose(t, conn); closeErr.Code != websocket.CloseGoingAway || closeErr.Text != "agent deleted" {
+		t.Errorf("expected close %d %q, got %d %q", websocket.CloseGoingAway, "agent deleted", closeErr.Code, closeErr.Text)
+	}
+	select {
+	case <-svc.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the agent stream closed")
+	}
+}
+
+// costlyAgentService answers each message on a Connect stream with an assistant message
+// costing $4 and a completion
+type costlyAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+
+	mu       sync.Mutex
+	requests []*agentv1.AgentRequest
+}
+
+func (s *costlyAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	var seq uint64
+	for {
+		req, err := stream.Receive()
+		if err != nil {
+			return nil
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		event := fmt.Sprintf(`{"type":"message.updated","properties":{"info":{"id":"msg-%s","role":"assistant","cost":4,"tokens":{"input":10,"output":20}}}}`, req.GetRequestId())
+		for _, resp := range []*agentv1.AgentResponse{
+			eventResponse(seq+1, "message.updated", event),
+			{Seq: seq + 2, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}},
+		} {
+			resp.RequestId = req.GetRequestId()
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+		seq += 2
+	}
+}
+
+// usageQuerier is a fakeBatchQuerier that records the usage stored for each request
+type usageQuerier struct {
+	*fakeBatchQuerier
+	usage chan *sqlc.UpsertRequestUsageParams
+}
+
+func (f *usageQuerier) UpsertRequestUsage(_ context.Context, arg *sqlc.UpsertRequestUsageParams) error {
+	f.usage <- arg
+	return nil
+}
+
+// readError reads the next frame from conn, which must be an error frame
+func readError(t *testing.T, conn *websocket.Conn) (string, WSError) {
+	t.Helper()
+	frame := readFrame(t, conn)
+	if frame.Type != WSTypeError {
+		t.Fatalf("expected an %s frame, got %s: %s", WSTypeError, frame.Type, frame.Payload)
+	}
+	var wsErr WSError
+	if err := json.Unmarshal(frame.Payload, &wsErr); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	return frame.RequestID, wsErr
+}
+
+func TestProxy_TracksUsageAndBudgetOfEachMessage(t *testing.T) {
+	svc := &costlyAgentService{}
+	querier := &usageQuerier{fakeBatchQuerier: newFakeBatchQuerier(), usage: make(chan *sqlc.UpsertRequestUsageParams, 10)}
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	proc := processor.NewProcessor(createNodePortManager(t, "user1", "agent1", startMockAgent(t, svc)), delivery, zap.NewNop())
+	if _, err := proc.SetBudget(context.Background(), webhook.Budget{UserID: "user1", LimitUSD: 5, Period: webhook.BudgetPeriodMonthly}); err != nil {
+		t.Fatalf("SetBudget: %v", err)
+	}
+	conn, _, err := dialStream(t, startProxyServer(t, proc), "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+
+	// Each message's usage is stored once its final payload is relayed, and counts toward
+	// the budget the next message is checked against
+	for _, requestID := range []string{"req-1", "req-2"} {
+		sendMessage(t, conn, requestID)
+		readPayload(t, conn)
+		readPayload(t, conn)
+		select {
+		case usage := <-querier.usage:
+			if usage.RequestID != requestID || usage.UserID != "user1" || usage.AgentID != "agent1" ||
+				usage.CostUsd != 4 || usage.InputTokens != 10 || usage.OutputTokens != 20 {
+				t.Errorf("expected the usage of %s stored, got %+v", requestID, usage)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the usage of %s stored", requestID)
+		}
+	}
+
+	sendMessage(t, conn, "req-3")
+	requestID, wsErr := readError(t, conn)
+	if requestID != "req-3" || wsErr.Code != processor.ErrorCodeBudgetExceeded {
+		t.Errorf("expected req-3 refused with %s, got %s %+v", processor.ErrorCodeBudgetExceeded, requestID, wsErr)
+	}
+
+	// The socket stays open for interrupts
+	if err := conn.WriteJSON(WSFrame{Type: WSTypeInterrupt, RequestID: "req-2"}); err != nil {
+		t.Fatalf("failed to send interrupt: %v", err)
+	}
+	readPayload(t, conn)
+	readPayload(t, conn)
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.requests) != 3 || svc.requests[2].GetInterrupt() == nil {
+		t.Errorf("expected two messages and an interrupt sent to the agent, got %v", svc.requests)
+	}
+}
+
+func TestProxy_RefusesMessagesOnceAgentIsQuarantined(t *testing.T) {
+	svc := &chatAgentService{}
+	clientset := createNodePortClientset("user1", "agent1", startMockAgent(t, svc))
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1")
+	conn, _, err := dialStream(t, startProxyServer(t, processor.NewProcessor(mgr, nil, zap.NewNop())), "/api/v1/users/user1/agents/agent1/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	sendMessage(t, conn, "req-1")
+	readPayload(t, conn)
+	readPayload(t, conn)
+
+	ctx := context.Background()
+	pods := clientset.CoreV1().Pods(testNamespace)
+	pod, err := pods.Get(ctx, k8s.NewPodID("user1", "agent1").Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	pod.Annotations = map[string]string{
+		k8s.QuarantineAnnotation: `{"reason":"malformed_events","detail":"20 malformed events within 1m0s","since":"2026-01-01T12:00:00Z"}`,
+	}
+	if _, err := pods.Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to quarantine pod: %v", err)
+	}
+
+	sendMessage(t, conn, "req-2")
+	requestID, wsErr := readError(t, conn)
+	if requestID != "req-2" || wsErr.Code != processor.ErrorCodeAgentQuarantined {
+		t.Errorf("expected req-2 refused with %s, got %s %+v", processor.ErrorCodeAgentQuarantined, requestID, wsErr)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.requests) != 1 {
+		t.Errorf("expected only the message before the quarantine sent, got %v", svc.requests)
+	}
+}
@@ -0,0 +1,412 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+const (
+	defaultListRequestsLimit = 20
+	maxListRequestsLimit     = 100
+)
+
+// Request statuses reported by the API
+const (
+	RequestStatusPending    = "pending"
+	RequestStatusInProgress = "in_progress"
+	RequestStatusCompleted  = "completed"
+	RequestStatusFailed     = "failed"
+)
+
+// RequestStatusResponse is the response for request status lookups
+type RequestStatusResponse struct {
+	RequestID       string `json:"request_id"`
+	AgentID         string `json:"agent_id"`
+	Status          string `json:"status"` // "pending", "in_progress", "completed", "failed"
+	LastSeq         int64  `json:"last_seq"`
+	LastEventType   string `json:"last_event_type,omitempty"`
+	WebhookURL      string `json:"webhook_url"` // path and query are redacted
+	IncludeThinking bool   `json:"include_thinking"`
+	// WebhookHeaders names the custom headers sent to webhook_url; values are never stored
+	WebhookHeaders []string `json:"webhook_headers,omitempty"`
+	CreatedAt      string   `json:"created_at"`
+	UpdatedAt      string   `json:"updated_at"`
+	CompletedAt    string   `json:"completed_at,omitempty"`
+
+	// Set for messages sent as part of a batch
+	BatchID string `json:"batch_id,omitempty"`
+	Step    *int   `json:"step,omitempty"`
+
+	// Delivery state of each webhook endpoint, webhook_url first (request lookups only)
+	Webhooks []WebhookEndpointStatus `json:"webhooks,omitempty"`
+
+	// AckedRanges are the seq ranges the consumer acknowledged with receipts, merged
+	// (request lookups only)
+	AckedRanges []webhook.SeqRange `json:"acked_ranges,omitempty"`
+
+	// AttemptCount is the number of webhook delivery attempts made for the request, to any
+	// endpoint, and LastStatus the HTTP status of the latest, omitted if it got no response
+	// (request lookups only)
+	AttemptCount *int32 `json:"attempt_count,omitempty"`
+	LastStatus   *int32 `json:"last_status,omitempty"`
+
+	// Queue is where a message waiting for or holding its agent's turn stands (request
+	// lookups only)
+	Queue *QueueStatus `json:"queue,omitempty"`
+}
+
+// QueueStatus is where a message stands in its agent's queue
+type QueueStatus struct {
+	// Position is how many messages are ahead of it, 0 once it is being sent
+	Position        int    `json:"position"`
+	ActiveRequestID string `json:"active_request_id"`
+}
+
+// WebhookEndpointStatus is the delivery state of one webhook endpoint of a request
+type WebhookEndpointStatus struct {
+	WebhookURL          string `json:"webhook_url"` // path and query are redacted
+	AttemptCount        int32  `json:"attempt_count"`
+	ConsecutiveFailures int32  `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+	LastAttemptAt       string `json:"last_attempt_at,omitempty"`
+	NextRetryAt         string `json:"next_retry_at,omitempty"`
+}
+
+// ListRequestsResponse is the response for listing an agent's requests
+type ListRequestsResponse struct {
+	Requests []RequestStatusResponse `json:"requests"`
+	Total    int                     `json:"total"`
+}
+
+// RedeliverRequest is the request body for replaying a request's webhook events
+type RedeliverRequest struct {
+	// FromSeq replays only events with seq >= from_seq (default: all)
+	FromSeq int64 `json:"from_seq,omitempty" validate:"gte=0"`
+	// WebhookURL overrides the original webhook URL
+	WebhookURL string `json:"webhook_url,omitempty" validate:"omitempty,url"`
+	// WebhookSecret signs the redelivered events. It must match the original secret,
+	// also when webhook_url is overridden.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+	// WebhookSecondarySecret also signs the redelivered events while the secret is rotated
+	WebhookSecondarySecret string `json:"webhook_secondary_secret,omitempty"`
+	// WebhookHeaders are sent with the redelivered events; the original values are not stored
+	WebhookHeaders map[string]string `json:"webhook_headers,omitempty"`
+	// WebhookBearerToken must match the original token, also when webhook_url is overridden
+	WebhookBearerToken string `json:"webhook_bearer_token,omitempty"`
+	// SchemaVersion is the payload schema to redeliver the events in (default the current one)
+	SchemaVersion string `json:"schema_version,omitempty"`
+	// Force also replays events the consumer acknowledged with a receipt
+	Force bool `json:"force,omitempty"`
+}
+
+// RedeliverResponse is the response for a redelivery request
+type RedeliverResponse struct {
+	RequestID  string `json:"request_id"`
+	Status     string `json:"status"`
+	FromSeq    int64  `json:"from_seq"`
+	EventCount int    `json:"event_count"`
+	// SkippedCount is the number of acknowledged events left out of the replay
+	SkippedCount int `json:"skipped_count"`
+}
+
+// CancelResponse is the response for cancelling an in-flight request
+type CancelResponse struct {
+	RequestID string `json:"request_id"`
+	Status    string `json:"status"`
+}
+
+// ReceiptRequest is the request body for acknowledging a request's events
+type ReceiptRequest struct {
+	// Ranges are inclusive seq ranges of events the consumer durably processed
+	Ranges []webhook.SeqRange `json:"ranges" validate:"required,min=1,max=100"`
+}
+
+// ReceiptResponse is the response for acknowledging a request's events
+type ReceiptResponse struct {
+	RequestID string `json:"request_id"`
+	// AckedRanges are all ranges acknowledged for the request so far, merged
+	AckedRanges []webhook.SeqRange `json:"acked_ranges"`
+}
+
+// GetRequest handles GET /api/v1/requests/:request_id?user_id=xxx
+//
+// Requests other users sent are reported as not found.
+func (h *Handler) GetRequest(c echo.Context) error {
+	requestID := c.Param("request_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	delivery, err := h.processor.GetRequest(c.Request().Context(), userID, requestID)
+	if err != nil {
+		if stderrors.Is(err, webhook.ErrDeliveryNotFound) {
+			return errors.NotFound("request " + requestID + " not found")
+		}
+		return errors.InternalError(err.Error())
+	}
+
+	endpoints, err := h.processor.GetRequestEndpoints(c.Request().Context(), requestID)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	acked, err := h.processor.AcknowledgedRanges(c.Request().Context(), requestID)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	attemptCount, lastStatus, err := h.processor.RequestAttemptSummary(c.Request().Context(), requestID)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	resp := deliveryToRequestStatus(delivery)
+	for _, e := range endpoints {
+		resp.Webhooks = append(resp.Webhooks, deliveryToEndpointStatus(e))
+	}
+	resp.AckedRanges = acked
+	resp.AttemptCount = &attemptCount
+	if lastStatus != 0 {
+		resp.LastStatus = &lastStatus
+	}
+	if position, active, ok := h.processor.QueuePosition(requestID); ok {
+		resp.Queue = &QueueStatus{Position: position, ActiveRequestID: active}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Redeliver handles POST /api/v1/requests/:request_id/redeliver?user_id=xxx
+//
+// Only the user who sent a request, or an admin acting for them, can replay it, to its
+// original URL or another; requests other users sent are reported as not found.
+func (h *Handler) Redeliver(c echo.Context) error {
+	requestID := c.Param("request_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	var req RedeliverRequest
+	if err := handler.Bind(c, &req); err != nil {
+		return err
+	}
+	if err := validateWebhookHeaders("", req.WebhookHeaders, req.WebhookBearerToken); err != nil {
+		return err
+	}
+	if err := validateWebhookSecrets("webhook_secret", req.WebhookSecret, req.WebhookSecondarySecret); err != nil {
+		return err
+	}
+	if err := validateSchemaVersion(req.SchemaVersion); err != nil {
+		return err
+	}
+
+	override := webhook.Config{
+		URL:             req.WebhookURL,
+		Secret:          req.WebhookSecret,
+		SecondarySecret: req.WebhookSecondarySecret,
+		Headers:         req.WebhookHeaders,
+		BearerToken:     req.WebhookBearerToken,
+		SchemaVersion:   req.SchemaVersion,
+	}
+	webhookCfg, payloads, skipped, err := h.processor.PrepareRedelivery(c.Request().Context(), userID, requestID, req.FromSeq, override, req.Force)
+	if err != nil {
+		switch {
+		case stderrors.Is(err, webhook.ErrDeliveryNotFound):
+			return errors.NotFound("request " + requestID + " not found")
+		case stderrors.Is(err, webhook.ErrNoStoredEvents):
+			return errors.NotFound("no stored events for request " + requestID)
+		case stderrors.Is(err, webhook.ErrSecretMismatch), stderrors.Is(err, webhook.ErrBearerTokenMismatch):
+			return errors.BadRequest(err.Error())
+		case stderrors.Is(err, webhook.ErrURLRejected):
+			return errors.BadRequest(err.Error()).WithErrorCode(webhook.ErrorCodeURLRejected)
+		default:
+			return errors.InternalError(err.Error())
+		}
+	}
+
+	// Start async redelivery, unless every event was acknowledged
+	if len(payloads) > 0 {
+		if err := h.processor.RunJob(c.Request().Context(), "", "", "", func(ctx context.Context) {
+			_ = h.processor.RedeliverEvents(ctx, requestID, webhookCfg, payloads)
+		}); err != nil {
+			return errors.ServiceUnavailable(err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusAccepted, RedeliverResponse{
+		RequestID:    requestID,
+		Status:       "redelivering",
+		FromSeq:      req.FromSeq,
+		EventCount:   len(payloads),
+		SkippedCount: skipped,
+	})
+}
+
+// CancelRequest handles POST /api/v1/requests/:request_id/cancel?user_id=xxx
+//
+// Stops relaying an in-flight message, interrupt or batch (by its batch ID) and interrupts
+// the agent. The consumer is sent an agent.error with code REQUEST_CANCELLED. Only the user
+// owning the request's agent can cancel it.
+func (h *Handler) CancelRequest(c echo.Context) error {
+	requestID := c.Param("request_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	if err := h.processor.CancelJob(c.Request().Context(), requestID, userID); err != nil {
+		if stderrors.Is(err, processor.ErrJobNotFound) {
+			return errors.NotFound("request " + requestID + " is not in flight")
+		}
+		return errors.InternalError(err.Error())
+	}
+
+	return c.JSON(http.StatusAccepted, CancelResponse{
+		RequestID: requestID,
+		Status:    "cancelling",
+	})
+}
+
+// RecordReceipt handles POST /api/v1/requests/:request_id/receipts?user_id=xxx
+//
+// Consumers acknowledge the seqs they durably processed so redeliveries can skip them.
+// Only the user owning the request's agent can acknowledge its events.
+func (h *Handler) RecordReceipt(c echo.Context) error {
+	requestID := c.Param("request_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	var req ReceiptRequest
+	if err := handler.Bind(c, &req); err != nil {
+		return err
+	}
+
+	acked, err := h.processor.RecordReceipt(c.Request().Context(), userID, requestID, req.Ranges)
+	if err != nil {
+		switch {
+		case stderrors.Is(err, webhook.ErrDeliveryNotFound):
+			return errors.NotFound("request " + requestID + " not found")
+		case stderrors.Is(err, webhook.ErrInvalidReceipt):
+			return errors.BadRequest(err.Error())
+		default:
+			return errors.InternalError(err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, ReceiptResponse{
+		RequestID:   requestID,
+		AckedRanges: acked,
+	})
+}
+
+// ListRequests handles GET /api/v1/agents/:agent_id/requests?user_id=xxx&limit=20
+func (h *Handler) ListRequests(c echo.Context) error {
+	agentID := c.Param("agent_id")
+	userID := userIDParam(c)
+	if userID == "" {
+		return errors.BadRequest("user_id query param is required")
+	}
+
+	limit := defaultListRequestsLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxListRequestsLimit {
+			return errors.BadRequest("limit must be between 1 and " + strconv.Itoa(maxListRequestsLimit))
+		}
+		limit = n
+	}
+
+	deliveries, err := h.processor.ListRequests(c.Request().Context(), userID, agentID, int32(limit))
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	requests := make([]RequestStatusResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		requests = append(requests, deliveryToRequestStatus(d))
+	}
+
+	return c.JSON(http.StatusOK, ListRequestsResponse{
+		Requests: requests,
+		Total:    len(requests),
+	})
+}
+
+// deliveryToRequestStatus converts a delivery record to the API representation
+func deliveryToRequestStatus(d *sqlc.WebhookDelivery) RequestStatusResponse {
+	resp := RequestStatusResponse{
+		RequestID:       d.RequestID,
+		AgentID:         d.AgentID,
+		Status:          deliveryStatusToRequestStatus(d.Status),
+		LastSeq:         d.Seq,
+		WebhookURL:      webhook.RedactURL(d.WebhookUrl),
+		IncludeThinking: d.IncludeThinking,
+		CreatedAt:       d.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       d.UpdatedAt.Format(time.RFC3339),
+	}
+	if d.LastEventType.Valid {
+		resp.LastEventType = d.LastEventType.String
+	}
+	if len(d.WebhookHeaderNames) > 0 || d.WebhookBearerTokenHash.Valid {
+		resp.WebhookHeaders = append([]string{}, d.WebhookHeaderNames...)
+		if d.WebhookBearerTokenHash.Valid {
+			resp.WebhookHeaders = append(resp.WebhookHeaders, "Authorization")
+		}
+	}
+	if d.CompletedAt.Valid {
+		resp.CompletedAt = d.CompletedAt.Time.Format(time.RFC3339)
+	}
+	if d.BatchID.Valid && d.BatchStep.Valid {
+		step := int(d.BatchStep.Int32)
+		resp.BatchID = d.BatchID.String
+		resp.Step = &step
+	}
+	return resp
+}
+
+// deliveryToEndpointStatus converts an endpoint's delivery record to the API representation
+func deliveryToEndpointStatus(d *sqlc.WebhookDelivery) WebhookEndpointStatus {
+	status := WebhookEndpointStatus{
+		WebhookURL:          webhook.RedactURL(d.WebhookUrl),
+		AttemptCount:        d.AttemptCount,
+		ConsecutiveFailures: d.ConsecutiveFailures,
+	}
+	if d.LastError.Valid {
+		status.LastError = d.LastError.String
+	}
+	if d.LastAttemptAt.Valid {
+		status.LastAttemptAt = d.LastAttemptAt.Time.Format(time.RFC3339)
+	}
+	if d.NextRetryAt.Valid {
+		status.NextRetryAt = d.NextRetryAt.Time.Format(time.RFC3339)
+	}
+	return status
+}
+
+// deliveryStatusToRequestStatus maps the stored delivery status to the API status
+func deliveryStatusToRequestStatus(status string) string {
+	switch status {
+	case webhook.DeliveryStatusDelivering:
+		return RequestStatusInProgress
+	case webhook.DeliveryStatusCompleted:
+		return RequestStatusCompleted
+	case webhook.DeliveryStatusFailed:
+		return RequestStatusFailed
+	default:
+		return RequestStatusPending
+	}
+}
@@ -0,0 +1,670 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// fakeDeliveryQuerier serves delivery records from memory. Unimplemented
+// querier methods panic via the nil embedded interface.
+type fakeDeliveryQuerier struct {
+	sqlc.Querier
+	deliveries map[string]*sqlc.WebhookDelivery
+	events     []*sqlc.WebhookEvent
+	// endpoints are further fan-out endpoints of the deliveries
+	endpoints []*sqlc.WebhookDelivery
+	receipts  []*sqlc.DeliveryReceipt
+	// attemptSummary is returned for every request
+	attemptSummary sqlc.GetWebhookDeliveryAttemptSummaryRow
+}
+
+func (f *fakeDeliveryQuerier) GetWebhookDeliveryAttemptSummary(context.Context, string) (*sqlc.GetWebhookDeliveryAttemptSummaryRow, error) {
+	summary := f.attemptSummary
+	return &summary, nil
+}
+
+func (f *fakeDeliveryQuerier) InsertDeliveryReceipt(_ context.Context, arg *sqlc.InsertDeliveryReceiptParams) error {
+	f.receipts = append(f.receipts, &sqlc.DeliveryReceipt{RequestID: arg.RequestID, FromSeq: arg.FromSeq, ToSeq: arg.ToSeq})
+	return nil
+}
+
+func (f *fakeDeliveryQuerier) ListDeliveryReceipts(_ context.Context, requestID string) ([]*sqlc.DeliveryReceipt, error) {
+	items := []*sqlc.DeliveryReceipt{}
+	for _, r := range f.receipts {
+		if r.RequestID == requestID {
+			items = append(items, r)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].FromSeq < items[j].FromSeq })
+	return items, nil
+}
+
+func (f *fakeDeliveryQuerier) ListWebhookEvents(_ context.Context, arg *sqlc.ListWebhookEventsParams) ([]*sqlc.WebhookEvent, error) {
+	items := []*sqlc.WebhookEvent{}
+	for _, e := range f.events {
+		if e.RequestID == arg.RequestID && e.Seq >= arg.FromSeq {
+			items = append(items, e)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeDeliveryQuerier) GetWebhookDelivery(_ context.Context, requestID string) (*sqlc.WebhookDelivery, error) {
+	d, ok := f.deliveries[requestID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return d, nil
+}
+
+// GetUserBudget reports that no user has a budget
+func (f *fakeDeliveryQuerier) GetUserBudget(context.Context, string) (*sqlc.UserBudget, error) {
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeDeliveryQuerier) ListWebhookDeliveryEndpoints(_ context.Context, requestID string) ([]*sqlc.WebhookDelivery, error) {
+	items := []*sqlc.WebhookDelivery{}
+	for _, d := range f.deliveries {
+		if d.RequestID == requestID {
+			items = append(items, d)
+		}
+	}
+	for _, d := range f.endpoints {
+		if d.RequestID == requestID {
+			items = append(items, d)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeDeliveryQuerier) ListDeliveriesByAgent(_ context.Context, arg *sqlc.ListDeliveriesByAgentParams) ([]*sqlc.WebhookDelivery, error) {
+	items := []*sqlc.WebhookDelivery{}
+	for _, d := range f.deliveries {
+		if d.AgentID == arg.AgentID && (d.UserID.String == arg.UserID || !d.UserID.Valid) {
+			items = append(items, d)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.After(items[j].CreatedAt) })
+	if len(items) > int(arg.Limit) {
+		items = items[:arg.Limit]
+	}
+	return items, nil
+}
+
+// createDeliveryProcessor creates a processor whose delivery records come from the given fake
+func createDeliveryProcessor(t *testing.T, deliveries ...*sqlc.WebhookDelivery) *processor.Processor {
+	t.Helper()
+	return createDeliveryProcessorWithEvents(t, nil, deliveries...)
+}
+
+// createDeliveryProcessorWithEvents is createDeliveryProcessor with stored webhook events.
+// The agent pod of user1/agent1 exists, so user1 owns agent1's requests.
+func createDeliveryProcessorWithEvents(t *testing.T, events []*sqlc.WebhookEvent, deliveries ...*sqlc.WebhookDelivery) *processor.Processor {
+	t.Helper()
+	querier := &fakeDeliveryQuerier{deliveries: make(map[string]*sqlc.WebhookDelivery), events: events}
+	for _, d := range deliveries {
+		querier.deliveries[d.RequestID] = d
+	}
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(createReadyPod("user1", "agent1")), testNamespace, "test-image:latest", "")
+	return processor.NewProcessor(mgr, delivery, zap.NewNop())
+}
+
+func testDelivery(requestID, agentID, status string, seq int64, createdAt time.Time) *sqlc.WebhookDelivery {
+	return &sqlc.WebhookDelivery{
+		RequestID:       requestID,
+		AgentID:         agentID,
+		UserID:          sql.NullString{String: "user1", Valid: true},
+		WebhookUrl:      "https://hooks.example.com/forge/abc123?token=secret",
+		Status:          status,
+		Seq:             seq,
+		IncludeThinking: true,
+		CreatedAt:       createdAt,
+		UpdatedAt:       createdAt,
+	}
+}
+
+func TestGetRequest_InProgress(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusDelivering, 7, time.Now())
+	d.LastEventType = sql.NullString{String: string(webhook.EventTypeEvent), Valid: true}
+	e := setupTestHandler(t, createDeliveryProcessor(t, d))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_1?user_id=user1", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var resp RequestStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Status != RequestStatusInProgress {
+		t.Errorf("expected status %s, got %s", RequestStatusInProgress, resp.Status)
+	}
+	if resp.LastSeq != 7 || resp.LastEventType != string(webhook.EventTypeEvent) {
+		t.Errorf("expected last seq 7 / agent.event, got %d / %s", resp.LastSeq, resp.LastEventType)
+	}
+	if resp.WebhookURL != "https://hooks.example.com/[redacted]" {
+		t.Errorf("expected redacted webhook URL, got %s", resp.WebhookURL)
+	}
+	if resp.CompletedAt != "" {
+		t.Errorf("expected no completed_at, got %s", resp.CompletedAt)
+	}
+}
+
+func TestGetRequest_SummarizesDeliveryAttempts(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusDelivering, 3, time.Now())
+	querier := &fakeDeliveryQuerier{deliveries: map[string]*sqlc.WebhookDelivery{"req_1": d}}
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(), testNamespace, "test-image:latest", "")
+	e := setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop()))
+
+	get := func() map[string]any {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_1?user_id=user1", nil))
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		return body
+	}
+
+	body := get()
+	if body["attempt_count"] != float64(0) || body["last_status"] != nil {
+		t.Errorf("expected 0 attempts and no last_status, got %v / %v", body["attempt_count"], body["last_status"])
+	}
+
+	querier.attemptSummary = sqlc.GetWebhookDeliveryAttemptSummaryRow{AttemptCount: 4, LastStatus: http.StatusServiceUnavailable}
+	body = get()
+	if body["attempt_count"] != float64(4) || body["last_status"] != float64(http.StatusServiceUnavailable) {
+		t.Errorf("expected 4 attempts, last 503, got %v / %v", body["attempt_count"], body["last_status"])
+	}
+}
+
+func TestGetRequest_Completed(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusCompleted, 12, time.Now())
+	d.CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	e := setupTestHandler(t, createDeliveryProcessor(t, d))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_1?user_id=user1", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var resp RequestStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Status != RequestStatusCompleted || resp.CompletedAt == "" {
+		t.Errorf("expected completed request with completed_at, got %+v", resp)
+	}
+}
+
+func TestGetRequest_NotFound(t *testing.T) {
+	e := setupTestHandler(t, createDeliveryProcessor(t))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_missing?user_id=user1", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d: %s", http.StatusNotFound, rec.Code, rec.Body.String())
+	}
+}
+
+func TestRequestRoutes_RefuseOtherUsers(t *testing.T) {
+	now := time.Now()
+	owned := testDelivery("req_1", "agent1", webhook.DeliveryStatusFailed, 2, now)
+	owned.WebhookUrl = "http://127.0.0.1:0/unreachable"
+	// A record made before owners were stored belongs to whoever owns its agent
+	legacy := testDelivery("req_legacy", "agent1", webhook.DeliveryStatusCompleted, 1, now.Add(-time.Minute))
+	legacy.UserID = sql.NullString{}
+	e := setupTestHandler(t, createDeliveryProcessorWithEvents(t, storedEvents("req_1", 2), owned, legacy))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		rec        *httptest.ResponseRecorder
+		wantStatus int
+	}{
+		{"get without user_id", get("/api/v1/requests/req_1"), http.StatusBadRequest},
+		{"get own request", get("/api/v1/requests/req_1?user_id=user1"), http.StatusOK},
+		{"get another user's request", get("/api/v1/requests/req_1?user_id=user2"), http.StatusNotFound},
+		{"get own legacy request", get("/api/v1/requests/req_legacy?user_id=user1"), http.StatusOK},
+		{"get another user's legacy request", get("/api/v1/requests/req_legacy?user_id=user2"), http.StatusNotFound},
+		{"redeliver without user_id", postJSON(e, "/api/v1/requests/req_1/redeliver", `{}`), http.StatusBadRequest},
+		{"redeliver another user's request", postJSON(e, "/api/v1/requests/req_1/redeliver?user_id=user2", `{}`), http.StatusNotFound},
+		{"redeliver another user's request elsewhere", postJSON(e, "/api/v1/requests/req_1/redeliver?user_id=user2", `{"webhook_url":"https://attacker.example.com/hook"}`), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, tt.rec.Code, tt.rec.Body.String())
+			}
+		})
+	}
+
+	for userID, want := range map[string]int{"user1": 2, "user2": 0} {
+		rec := get("/api/v1/agents/agent1/requests?user_id=" + userID)
+		var resp ListRequestsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.Total != want {
+			t.Errorf("expected %s to list %d requests, got %d: %+v", userID, want, resp.Total, resp.Requests)
+		}
+	}
+}
+
+func TestListRequests_NewestFirstWithLimit(t *testing.T) {
+	now := time.Now()
+	e := setupTestHandler(t, createDeliveryProcessor(t,
+		testDelivery("req_old", "agent1", webhook.DeliveryStatusCompleted, 3, now.Add(-2*time.Minute)),
+		testDelivery("req_mid", "agent1", webhook.DeliveryStatusFailed, 1, now.Add(-time.Minute)),
+		testDelivery("req_new", "agent1", webhook.DeliveryStatusPending, 0, now),
+		testDelivery("req_other", "agent2", webhook.DeliveryStatusPending, 0, now),
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/agents/agent1/requests?user_id=user1&limit=2", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var resp ListRequestsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Total != 2 {
+		t.Fatalf("expected 2 requests, got %d", resp.Total)
+	}
+	if resp.Requests[0].RequestID != "req_new" || resp.Requests[0].Status != RequestStatusPending {
+		t.Errorf("expected newest pending request first, got %+v", resp.Requests[0])
+	}
+	if resp.Requests[1].RequestID != "req_mid" || resp.Requests[1].Status != RequestStatusFailed {
+		t.Errorf("expected failed request second, got %+v", resp.Requests[1])
+	}
+}
+
+func TestListRequests_InvalidLimit(t *testing.T) {
+	e := setupTestHandler(t, createDeliveryProcessor(t))
+
+	for _, limit := range []string{"0", "abc", "1000"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/agents/agent1/requests?user_id=user1&limit="+limit, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: expected status %d, got %d", limit, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestRedeliver_Accepted(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusFailed, 3, time.Now())
+	d.WebhookUrl = "http://127.0.0.1:0/unreachable"
+	var events []*sqlc.WebhookEvent
+	for seq := int64(1); seq <= 3; seq++ {
+		events = append(events, &sqlc.WebhookEvent{
+			RequestID: "req_1",
+			Seq:       seq,
+			EventType: string(webhook.EventTypeEvent),
+			Payload:   []byte(`{"event_type":"agent.event","request_id":"req_1","seq":` + strconv.FormatInt(seq, 10) + `}`),
+		})
+	}
+	e := setupTestHandler(t, createDeliveryProcessorWithEvents(t, events, d))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/requests/req_1/redeliver?user_id=user1", strings.NewReader(`{"from_seq":2}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	var resp RedeliverResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.EventCount != 2 || resp.FromSeq != 2 || resp.Status != "redelivering" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestRedeliver_Errors(t *testing.T) {
+	withSecret := testDelivery("req_secret", "agent1", webhook.DeliveryStatusFailed, 1, time.Now())
+	hash := sha256.Sum256([]byte("original"))
+	withSecret.WebhookSecretHash = sql.NullString{String: hex.EncodeToString(hash[:]), Valid: true}
+	noEvents := testDelivery("req_empty", "agent1", webhook.DeliveryStatusFailed, 0, time.Now())
+	events := []*sqlc.WebhookEvent{{RequestID: "req_secret", Seq: 1, Payload: []byte(`{}`)}}
+	e := setupTestHandler(t, createDeliveryProcessorWithEvents(t, events, withSecret, noEvents))
+
+	tests := []struct {
+		name       string
+		requestID  string
+		body       string
+		wantStatus int
+	}{
+		{"unknown request", "req_missing", `{}`, http.StatusNotFound},
+		{"no stored events", "req_empty", `{}`, http.StatusNotFound},
+		{"secret mismatch", "req_secret", `{"webhook_secret":"wrong"}`, http.StatusBadRequest},
+		{"override URL with another secret", "req_secret", `{"webhook_url":"https://other.example.com/hook","webhook_secret":"new"}`, http.StatusBadRequest},
+		{"negative from_seq", "req_secret", `{"from_seq":-1}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/requests/"+tt.requestID+"/redeliver?user_id=user1", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWebhookURLRejected(t *testing.T) {
+	// req_private was sent before private networks were blocked
+	private := testDelivery("req_private", "agent1", webhook.DeliveryStatusFailed, 1, time.Now())
+	private.WebhookUrl = "http://10.0.0.5/hook"
+	querier := &fakeDeliveryQuerier{deliveries: map[string]*sqlc.WebhookDelivery{
+		"req_1":       testDelivery("req_1", "agent1", webhook.DeliveryStatusFailed, 1, time.Now()),
+		"req_private": private,
+	}}
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{WebhookBlockPrivateNetworks: true}, zap.NewNop())
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(), testNamespace, "test-image:latest", "")
+	e := setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop()))
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"message", "/api/v1/agents/agent1/messages?user_id=user1", `{"content":"hi","webhook_url":"http://169.254.169.254/latest/meta-data"}`},
+		{"interrupt", "/api/v1/agents/agent1/interrupt?user_id=user1", `{"webhook_url":"http://127.0.0.1:8080/hook"}`},
+		{"batch", "/api/v1/agents/agent1/messages/batch?user_id=user1", `{"messages":[{"content":"hi"}],"webhook_url":"http://10.0.0.5/hook"}`},
+		{"redeliver override", "/api/v1/requests/req_1/redeliver?user_id=user1", `{"webhook_url":"file:///etc/passwd"}`},
+		{"redeliver to original", "/api/v1/requests/req_private/redeliver?user_id=user1", `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if body["error"] != webhook.ErrorCodeURLRejected {
+				t.Errorf("expected error code %q, got %v", webhook.ErrorCodeURLRejected, body["error"])
+			}
+		})
+	}
+}
+
+func TestGetRequest_ListsEndpointStates(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusDelivering, 3, time.Now())
+	d.AttemptCount = 3
+	audit := testDelivery("req_1", "agent1", webhook.DeliveryStatusDelivering, 3, time.Now())
+	audit.WebhookUrl = "https://audit.example.com/collect"
+	audit.EndpointIndex = 1
+	audit.AttemptCount = 2
+	audit.ConsecutiveFailures = 2
+	audit.LastError = sql.NullString{String: "webhook returned status 500", Valid: true}
+	audit.NextRetryAt = sql.NullTime{Time: time.Now().Add(time.Minute), Valid: true}
+
+	querier := &fakeDeliveryQuerier{
+		deliveries: map[string]*sqlc.WebhookDelivery{"req_1": d},
+		endpoints:  []*sqlc.WebhookDelivery{audit},
+	}
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(), testNamespace, "test-image:latest", "")
+	e := setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop()))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_1?user_id=user1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp RequestStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(resp.Webhooks) != 2 {
+		t.Fatalf("expected 2 endpoints, got %+v", resp.Webhooks)
+	}
+	if primary := resp.Webhooks[0]; primary.WebhookURL != resp.WebhookURL || primary.AttemptCount != 3 || primary.LastError != "" {
+		t.Errorf("unexpected primary endpoint %+v", primary)
+	}
+	if failing := resp.Webhooks[1]; failing.WebhookURL != "https://audit.example.com/[redacted]" ||
+		failing.ConsecutiveFailures != 2 || failing.LastError == "" || failing.NextRetryAt == "" {
+		t.Errorf("unexpected failing endpoint %+v", failing)
+	}
+}
+
+func TestGetRequest_RedactsWebhookHeaders(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusDelivering, 3, time.Now())
+	d.WebhookHeaderNames = []string{"X-Tenant-Id"}
+	d.WebhookBearerTokenHash = sql.NullString{String: "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8", Valid: true}
+
+	querier := &fakeDeliveryQuerier{deliveries: map[string]*sqlc.WebhookDelivery{"req_1": d}}
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(), testNamespace, "test-image:latest", "")
+	e := setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop()))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_1?user_id=user1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp RequestStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if strings.Join(resp.WebhookHeaders, ",") != "X-Tenant-Id,Authorization" {
+		t.Errorf("expected the header names, got %v", resp.WebhookHeaders)
+	}
+	if strings.Contains(rec.Body.String(), d.WebhookBearerTokenHash.String) {
+		t.Error("expected the bearer token hash not to be exposed")
+	}
+}
+
+// storedEvents returns stored agent.event payloads with seqs 1 to n for a request
+func storedEvents(requestID string, n int64) []*sqlc.WebhookEvent {
+	var events []*sqlc.WebhookEvent
+	for seq := int64(1); seq <= n; seq++ {
+		events = append(events, &sqlc.WebhookEvent{
+			RequestID: requestID,
+			Seq:       seq,
+			EventType: string(webhook.EventTypeEvent),
+			Payload:   []byte(`{"event_type":"agent.event","request_id":"` + requestID + `","seq":` + strconv.FormatInt(seq, 10) + `}`),
+		})
+	}
+	return events
+}
+
+func postJSON(e *echo.Echo, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRecordReceipt_MergesAcknowledgedRanges(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusDelivering, 10, time.Now())
+	e := setupTestHandler(t, createDeliveryProcessor(t, d))
+
+	steps := []struct {
+		body string
+		want []webhook.SeqRange
+	}{
+		{`{"ranges":[{"from":3,"to":5},{"from":1,"to":3},{"from":8,"to":8}]}`, []webhook.SeqRange{{From: 1, To: 5}, {From: 8, To: 8}}},
+		{`{"ranges":[{"from":2,"to":4}]}`, []webhook.SeqRange{{From: 1, To: 5}, {From: 8, To: 8}}},
+		{`{"ranges":[{"from":6,"to":7}]}`, []webhook.SeqRange{{From: 1, To: 8}}},
+	}
+	for i, step := range steps {
+		rec := postJSON(e, "/api/v1/requests/req_1/receipts?user_id=user1", step.body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("receipt %d: expected status %d, got %d: %s", i, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var resp ReceiptResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if !reflect.DeepEqual(resp.AckedRanges, step.want) {
+			t.Errorf("receipt %d: expected acked ranges %v, got %v", i, step.want, resp.AckedRanges)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/requests/req_1?user_id=user1", nil))
+	var status RequestStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if want := []webhook.SeqRange{{From: 1, To: 8}}; !reflect.DeepEqual(status.AckedRanges, want) {
+		t.Errorf("expected the request to show acked ranges %v, got %v", want, status.AckedRanges)
+	}
+}
+
+func TestRecordReceipt_Errors(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusDelivering, 10, time.Now())
+	e := setupTestHandler(t, createDeliveryProcessor(t, d))
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"missing user_id", "/api/v1/requests/req_1/receipts", `{"ranges":[{"from":1,"to":2}]}`, http.StatusBadRequest},
+		{"another user's request", "/api/v1/requests/req_1/receipts?user_id=user2", `{"ranges":[{"from":1,"to":2}]}`, http.StatusNotFound},
+		{"unknown request", "/api/v1/requests/req_missing/receipts?user_id=user1", `{"ranges":[{"from":1,"to":2}]}`, http.StatusNotFound},
+		{"no ranges", "/api/v1/requests/req_1/receipts?user_id=user1", `{"ranges":[]}`, http.StatusBadRequest},
+		{"seq 0", "/api/v1/requests/req_1/receipts?user_id=user1", `{"ranges":[{"from":0,"to":2}]}`, http.StatusBadRequest},
+		{"reversed range", "/api/v1/requests/req_1/receipts?user_id=user1", `{"ranges":[{"from":5,"to":4}]}`, http.StatusBadRequest},
+		{"past last delivered seq", "/api/v1/requests/req_1/receipts?user_id=user1", `{"ranges":[{"from":9,"to":11}]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postJSON(e, tt.path, tt.body); rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRedeliver_SkipsAcknowledgedEvents(t *testing.T) {
+	d := testDelivery("req_1", "agent1", webhook.DeliveryStatusFailed, 5, time.Now())
+	d.WebhookUrl = "http://127.0.0.1:0/unreachable"
+	e := setupTestHandler(t, createDeliveryProcessorWithEvents(t, storedEvents("req_1", 5), d))
+
+	if rec := postJSON(e, "/api/v1/requests/req_1/receipts?user_id=user1", `{"ranges":[{"from":2,"to":3},{"from":5,"to":5}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		body                string
+		wantCount, wantSkip int
+	}{
+		{`{}`, 2, 3},
+		{`{"from_seq":3}`, 1, 2},
+		{`{"force":true}`, 5, 0},
+	}
+	for _, tt := range tests {
+		rec := postJSON(e, "/api/v1/requests/req_1/redeliver?user_id=user1", tt.body)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected status %d, got %d: %s", tt.body, http.StatusAccepted, rec.Code, rec.Body.String())
+		}
+		var resp RedeliverResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.EventCount != tt.wantCount || resp.SkippedCount != tt.wantSkip {
+			t.Errorf("%s: expected %d events and %d skipped, got %+v", tt.body, tt.wantCount, tt.wantSkip, resp)
+		}
+	}
+}
+
+func TestCancelRequest_EndsStreamAndInterruptsAgent(t *testing.T) {
+	svc := &blockingAgentService{interrupted: make(chan string, 1)}
+	e, querier, _ := setupJobTest(t, svc)
+	startBlockedMessage(t, e, querier, "req_cancel")
+
+	// Only the user owning the agent can cancel the request
+	if rec := postJSON(e, "/api/v1/requests/req_cancel/cancel?user_id=user2", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for another user, got %d", rec.Code)
+	}
+
+	rec := postJSON(e, "/api/v1/requests/req_cancel/cancel?user_id=user1", "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case requestID := <-svc.interrupted:
+		if requestID != "req_cancel" {
+			t.Errorf("expected the agent to be interrupted for req_cancel, got %s", requestID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the agent to be interrupted")
+	}
+
+	payloads := waitForPayloads(t, querier, 2)
+	final := payloads[1]
+	if !final.IsFinal || final.Error == nil || final.Error.Code != processor.ErrorCodeRequestCancelled {
+		t.Errorf("expected a final %s error, got %+v (error %+v)", processor.ErrorCodeRequestCancelled, final, final.Error)
+	}
+
+	// The request is no longer in flight
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if rec = postJSON(e, "/api/v1/requests/req_cancel/cancel?user_id=user1", ""); rec.Code == http.StatusNotFound {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 once the request ended, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// defaultUsagePeriod is how far back usage is summed without a from query param
+const defaultUsagePeriod = 30 * 24 * time.Hour
+
+// UsageResponse is a user's agent usage over a period
+type UsageResponse struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	GroupBy string `json:"group_by"`
+	// Entries are the usage of the requests that ended, per agent or per UTC day, followed
+	// by a pending entry with the usage so far of each request still in progress
+	Entries []webhook.UsageEntry `json:"entries"`
+	// Total sums every entry, pending ones included
+	Total webhook.Usage `json:"total"`
+}
+
+// GetUsage handles GET /api/v1/users/:user_id/usage, summing the tokens, cost and time a
+// user's agents used for the requests made from from up to to, grouped by agent or by
+// UTC day (group_by=agent|day, agent by default). from and to are RFC 3339 timestamps or
+// days (2006-01-02, a to day is included) and default to the last 30 days.
+func (h *Handler) GetUsage(c echo.Context) error {
+	userID := c.Param("user_id")
+
+	to := time.Now().UTC()
+	if raw := c.QueryParam("to"); raw != "" {
+		parsed, isDay, err := parseUsageTime(raw)
+		if err != nil {
+			return errors.BadRequest("to must be an RFC 3339 timestamp or a day (YYYY-MM-DD)")
+		}
+		if to = parsed; isDay {
+			to = to.Add(24 * time.Hour)
+		}
+	}
+	from := to.Add(-defaultUsagePeriod)
+	if raw := c.QueryParam("from"); raw != "" {
+		parsed, _, err := parseUsageTime(raw)
+		if err != nil {
+			return errors.BadRequest("from must be an RFC 3339 timestamp or a day (YYYY-MM-DD)")
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		return errors.BadRequest("from must be before to")
+	}
+
+	groupBy := c.QueryParam("group_by")
+	switch groupBy {
+	case "":
+		groupBy = webhook.UsageGroupByAgent
+	case webhook.UsageGroupByAgent, webhook.UsageGroupByDay:
+	default:
+		return errors.BadRequest("group_by must be one of agent, day")
+	}
+
+	entries, err := h.processor.Usage(c.Request().Context(), userID, from, to, groupBy)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	resp := UsageResponse{
+		From:    from.Format(time.RFC3339),
+		To:      to.Format(time.RFC3339),
+		GroupBy: groupBy,
+		Entries: entries,
+	}
+	for _, entry := range entries {
+		resp.Total.Add(entry.Usage)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// parseUsageTime parses an RFC 3339 timestamp or a UTC day, reporting which it was
+func parseUsageTime(raw string) (time.Time, bool, error) {
+	if day, err := time.Parse(webhook.UsageDayFormat, raw); err == nil {
+		return day, true, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	return parsed, false, err
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// fakeUsageQuerier answers SumRequestUsage with fixed rows, recording its params
+type fakeUsageQuerier struct {
+	sqlc.Querier
+	rows []*sqlc.SumRequestUsageRow
+	args *sqlc.SumRequestUsageParams
+}
+
+func (f *fakeUsageQuerier) SumRequestUsage(_ context.Context, arg *sqlc.SumRequestUsageParams) ([]*sqlc.SumRequestUsageRow, error) {
+	f.args = arg
+	return f.rows, nil
+}
+
+func getUsage(t *testing.T, e *echo.Echo, query string) (int, UsageResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users/user1/usage"+query, nil))
+	var resp UsageResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestGetUsage(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	querier := &fakeUsageQuerier{rows: []*sqlc.SumRequestUsageRow{
+		{AgentID: "agent-a", Day: day, Requests: 2, InputTokens: 30, OutputTokens: 3, CostUsd: 0.5, DurationMs: 3000},
+		{AgentID: "agent-b", Day: day, Requests: 1, InputTokens: 10, CostUsd: 0.25, DurationMs: 1000},
+		{AgentID: "agent-a", Day: day.Add(24 * time.Hour), Requests: 1, InputTokens: 5, CostUsd: 0.125, DurationMs: 500},
+	}}
+	delivery := webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(), testNamespace, "test-image:latest", "")
+	e := setupTestHandler(t, processor.NewProcessor(mgr, delivery, zap.NewNop()))
+
+	code, resp := getUsage(t, e, "?from=2024-03-01&to=2024-03-02&group_by=day")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	// A to day is included
+	if !querier.args.FromTime.Equal(day) || !querier.args.ToTime.Equal(day.Add(48*time.Hour)) || querier.args.UserID != "user1" {
+		t.Errorf("unexpected query params: %+v", querier.args)
+	}
+	if len(resp.Entries) != 2 || resp.Entries[0].Day != "2024-03-01" || resp.Entries[0].Requests != 3 ||
+		resp.Entries[0].InputTokens != 40 || resp.Entries[0].CostUSD != 0.75 || resp.Entries[1].Day != "2024-03-02" {
+		t.Errorf("expected usage per day, got %+v", resp.Entries)
+	}
+	if resp.Total.InputTokens != 45 || resp.Total.CostUSD != 0.875 || resp.Total.DurationMs != 4500 {
+		t.Errorf("unexpected total: %+v", resp.Total)
+	}
+
+	code, resp = getUsage(t, e, "?from=2024-03-01T00:00:00Z&to=2024-03-03T00:00:00Z")
+	if code != http.StatusOK || resp.GroupBy != webhook.UsageGroupByAgent || len(resp.Entries) != 2 ||
+		resp.Entries[0].AgentID != "agent-a" || resp.Entries[0].Requests != 3 || resp.Entries[0].InputTokens != 35 {
+		t.Errorf("expected usage per agent by default, got %d %+v", code, resp)
+	}
+
+	// Without a period, the last 30 days are summed
+	if code, _ := getUsage(t, e, ""); code != http.StatusOK || querier.args.ToTime.Sub(querier.args.FromTime) != 30*24*time.Hour {
+		t.Errorf("expected the last 30 days, got %d %+v", code, querier.args)
+	}
+
+	for _, query := range []string{"?group_by=user", "?from=yesterday", "?to=1709251200", "?from=2024-03-02&to=2024-03-01"} {
+		if code, _ := getUsage(t, e, query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/metrics"
+)
+
+// DefaultConnIdleTimeout closes a connection to an agent left idle unless
+// AGENT_CONN_IDLE_TIMEOUT says otherwise
+const DefaultConnIdleTimeout = 90 * time.Second
+
+// A connection to an agent that goes connReadIdleTimeout without a frame is pinged, and
+// closed as dead if the ping is not answered within connPingTimeout, so streams to a pod
+// that vanished without closing its connections fail instead of hanging
+const (
+	connReadIdleTimeout = 30 * time.Second
+	connPingTimeout     = 15 * time.Second
+)
+
+// errHostInvalidated is returned for a dial to an agent invalidated while it was dialed
+var errHostInvalidated = errors.New("agent connections invalidated")
+
+// PoolConfig configures a ClientPool
+type PoolConfig struct {
+	// IdleTimeout closes connections to an agent idle that long; 0 uses
+	// DefaultConnIdleTimeout
+	IdleTimeout time.Duration
+	// MaxConnsPerHost caps the connections open to one agent, RPCs needing another waiting
+	// for one to close; 0 means no cap. One connection carries many RPCs, so more are only
+	// dialed once the agent's concurrent stream limit is reached.
+	MaxConnsPerHost int
+	// Metrics records dials and reused connections; nil records nothing
+	Metrics *metrics.Metrics
+}
+
+// ClientPool hands out clients of agents that share HTTP/2 cleartext (h2c) connections
+// per agent base URL. Connections to an agent that is deleted or replaced are closed with
+// Invalidate rather than left to time out. It is safe for concurrent use.
+type ClientPool struct {
+	cfg    PoolConfig
+	dialer net.Dialer
+	now    func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*poolHost
+	swept time.Time
+}
+
+// poolHost is the transport of one agent base URL and the connections it opened
+type poolHost struct {
+	client    *http.Client
+	transport *http2.Transport
+	slots     chan struct{} // one per open connection; nil if uncapped
+	conns     map[*poolConn]struct{}
+	lastUsed  time.Time
+	closed    bool
+}
+
+// NewClientPool creates a pool of agent connections configured by cfg
+func NewClientPool(cfg PoolConfig) *ClientPool {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultConnIdleTimeout
+	}
+	return &ClientPool{
+		cfg:   cfg,
+		now:   time.Now,
+		hosts: make(map[string]*poolHost),
+	}
+}
+
+// Get returns a client of the agent at baseURL, in the format "http://<ip>:8080", as
+// configured by opts. Its RPCs go over the pool's connections to baseURL, whatever
+// opts.HTTPClient is.
+func (p *ClientPool) Get(baseURL string, opts ClientOptions) agentv1connect.AgentServiceClient {
+	opts.HTTPClient = p.httpClient(baseURL)
+	return NewClientWithOptions(baseURL, opts)
+}
+
+// Invalidate closes the connections to baseURL, including those RPCs are still using, so
+// an agent's deleted or replaced pod is not talked to again. The next Get dials anew.
+func (p *ClientPool) Invalidate(baseURL string) {
+	p.mu.Lock()
+	h, ok := p.hosts[baseURL]
+	if ok {
+		delete(p.hosts, baseURL)
+	}
+	p.mu.Unlock()
+	if ok {
+		p.closeHost(h)
+	}
+}
+
+// Close closes every connection of the pool
+func (p *ClientPool) Close() {
+	p.mu.Lock()
+	hosts := p.hosts
+	p.hosts = make(map[string]*poolHost)
+	p.mu.Unlock()
+	for _, h := range hosts {
+		p.closeHost(h)
+	}
+}
+
+// closeHost closes the connections of a host removed from the pool
+func (p *ClientPool) closeHost(h *poolHost) {
+	p.mu.Lock()
+	h.closed = true
+	conns := make([]*poolConn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	p.mu.Unlock()
+
+	h.transport.CloseIdleConnections()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// httpClient returns the HTTP client of baseURL's connections, creating it on first use
+func (p *ClientPool) httpClient(baseURL string) *http.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.sweep(now)
+	h, ok := p.hosts[baseURL]
+	if !ok {
+		h = p.newHost()
+		p.hosts[baseURL] = h
+	}
+	h.lastUsed = now
+	return h.client
+}
+
+// sweep forgets, at most once per idle timeout, the hosts with no connections left that
+// have not been used for an idle timeout, so the pool does not grow with every pod the
+// platform ever talked to. p.mu must be held.
+func (p *ClientPool) sweep(now time.Time) {
+	if now.Sub(p.swept) < p.cfg.IdleTimeout {
+		return
+	}
+	p.swept = now
+	for baseURL, h := range p.hosts {
+		if len(h.conns) == 0 && now.Sub(h.lastUsed) >= p.cfg.IdleTimeout {
+			delete(p.hosts, baseURL)
+		}
+	}
+}
+
+// newHost creates the transport of one agent base URL
+func (p *ClientPool) newHost() *poolHost {
+	h := &poolHost{conns: make(map[*poolConn]struct{})}
+	if p.cfg.MaxConnsPerHost > 0 {
+		h.slots = make(chan struct{}, p.cfg.MaxConnsPerHost)
+	}
+	h.transport = &http2.Transport{
+		// Allow h2c (HTTP/2 without TLS), dialing a plain connection
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return p.dial(ctx, h, network, addr)
+		},
+		IdleConnTimeout: p.cfg.IdleTimeout,
+		ReadIdleTimeout: connReadIdleTimeout,
+		PingTimeout:     connPingTimeout,
+	}
+	// As with http2Client, no Timeout: streams are bounded by their contexts
+	h.client = &http.Client{Transport: &reuseTracingTransport{next: h.transport, metrics: p.cfg.Metrics}}
+	return h
+}
+
+// dial opens a connection for h, waiting for a free slot if its connections are capped
+func (p *ClientPool) dial(ctx context.Context, h *poolHost, network, addr string) (net.Conn, error) {
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if h.slots != nil {
+			<-h.slots
+		}
+	}
+
+	conn, err := p.dialer.DialContext(ctx, network, addr)
+	p.cfg.Metrics.AgentConnDialed(err)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	pc := &poolConn{Conn: conn}
+	pc.onClose = func() {
+		p.mu.Lock()
+		delete(h.conns, pc)
+		p.mu.Unlock()
+		release()
+	}
+	p.mu.Lock()
+	closed := h.closed
+	if !closed {
+		h.conns[pc] = struct{}{}
+	}
+	p.mu.Unlock()
+	if closed {
+		pc.Close()
+		return nil, errHostInvalidated
+	}
+	return pc, nil
+}
+
+// poolConn is a connection of a pool, leaving its host when closed
+type poolConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
+
+// reuseTracingTransport records the RPCs sent over a connection that was already open
+type reuseTracingTransport struct {
+	next    http.RoundTripper
+	metrics *metrics.Metrics
+}
+
+func (t *reuseTracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.metrics == nil {
+		return t.next.RoundTrip(req)
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.metrics.AgentConnReused()
+			}
+		},
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
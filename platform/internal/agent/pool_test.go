@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/metrics"
+)
+
+// connCountingServer is an h2c agent server counting the connections opened and closed
+type connCountingServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	opened int
+	closed int
+}
+
+func newConnCountingServer(t *testing.T) *connCountingServer {
+	mux := http.NewServeMux()
+	mux.Handle(agentv1connect.NewAgentServiceHandler(&mockAgentService{}))
+	s := &connCountingServer{Server: httptest.NewUnstartedServer(h2c.NewHandler(mux, &http2.Server{}))}
+	s.Listener = &countingListener{Listener: s.Listener, s: s}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *connCountingServer) counts() (opened, closed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opened, s.closed
+}
+
+// countingListener counts the connections of a connCountingServer. Connection states
+// cannot, as h2c hijacks the connections it serves.
+type countingListener struct {
+	net.Listener
+	s *connCountingServer
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.s.mu.Lock()
+	l.s.opened++
+	l.s.mu.Unlock()
+	return &countingConn{Conn: conn, s: l.s}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	s    *connCountingServer
+	once sync.Once
+}
+
+func (c *countingConn) Close() error {
+	c.once.Do(func() {
+		c.s.mu.Lock()
+		c.s.closed++
+		c.s.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+func getStatus(t *testing.T, client agentv1connect.AgentServiceClient) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{})); err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+}
+
+func TestClientPool_ReusesConnections(t *testing.T) {
+	server := newConnCountingServer(t)
+	reg := prometheus.NewRegistry()
+	pool := NewClientPool(PoolConfig{Metrics: metrics.New(reg)})
+	defer pool.Close()
+
+	for i := 0; i < 5; i++ {
+		getStatus(t, pool.Get(server.URL, ClientOptions{}))
+	}
+
+	if opened, _ := server.counts(); opened != 1 {
+		t.Errorf("expected a single connection for repeated calls, got %d", opened)
+	}
+	want := `
+# HELP forge_agent_connection_dials_total Connections dialed to agents, by outcome.
+# TYPE forge_agent_connection_dials_total counter
+forge_agent_connection_dials_total{outcome="success"} 1
+# HELP forge_agent_connection_reuses_total RPCs to agents sent over an already open connection.
+# TYPE forge_agent_connection_reuses_total counter
+forge_agent_connection_reuses_total 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"forge_agent_connection_dials_total", "forge_agent_connection_reuses_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientPool_Invalidate(t *testing.T) {
+	server := newConnCountingServer(t)
+	other := newConnCountingServer(t)
+	pool := NewClientPool(PoolConfig{})
+	defer pool.Close()
+
+	getStatus(t, pool.Get(server.URL, ClientOptions{}))
+	getStatus(t, pool.Get(other.URL, ClientOptions{}))
+	pool.Invalidate(server.URL)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, closed := server.counts(); closed != 1; _, closed = server.counts() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the invalidated connection to be closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, closed := other.counts(); closed != 0 {
+		t.Errorf("expected connections to other agents to stay open, got %d closed", closed)
+	}
+
+	getStatus(t, pool.Get(server.URL, ClientOptions{}))
+	if opened, _ := server.counts(); opened != 2 {
+		t.Errorf("expected a new connection after invalidation, got %d opened", opened)
+	}
+}
+
+func TestClientPool_MaxConnsPerHost(t *testing.T) {
+	server := newConnCountingServer(t)
+	pool := NewClientPool(PoolConfig{MaxConnsPerHost: 1})
+	defer pool.Close()
+
+	getStatus(t, pool.Get(server.URL, ClientOptions{}))
+	pool.mu.Lock()
+	h := pool.hosts[server.URL]
+	pool.mu.Unlock()
+
+	// With the only slot taken, another dial waits until its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.dial(ctx, h, "tcp", server.Listener.Addr().String()); err != context.DeadlineExceeded {
+		t.Errorf("expected the capped dial to wait for its deadline, got %v", err)
+	}
+
+	// Invalidating frees the slot
+	pool.Invalidate(server.URL)
+	conn, err := pool.dial(context.Background(), h, "tcp", server.Listener.Addr().String())
+	if err != errHostInvalidated {
+		t.Errorf("expected dials for an invalidated host to fail, got %v", err)
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	if n := len(h.slots); n != 0 {
+		t.Errorf("expected every slot released, got %d taken", n)
+	}
+}
+
+func TestClientPool_SweepsIdleHosts(t *testing.T) {
+	pool := NewClientPool(PoolConfig{IdleTimeout: time.Minute})
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	pool.Get("http://10.0.0.1:8080", ClientOptions{})
+	now = now.Add(2 * time.Minute)
+	pool.Get("http://10.0.0.2:8080", ClientOptions{})
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if _, ok := pool.hosts["http://10.0.0.1:8080"]; ok || len(pool.hosts) != 1 {
+		t.Errorf("expected the idle host to be forgotten, got %d hosts", len(pool.hosts))
+	}
+}
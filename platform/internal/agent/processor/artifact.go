@@ -0,0 +1,45 @@
+package processor
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// ListArtifacts returns the artifacts stored for a request owned by userID
+func (p *Processor) ListArtifacts(ctx context.Context, requestID, userID string) ([]webhook.Artifact, error) {
+	return p.webhookDelivery.ListArtifacts(ctx, requestID, userID)
+}
+
+// GetArtifact returns a stored artifact with its content
+func (p *Processor) GetArtifact(ctx context.Context, requestID, name, userID string) (*sqlc.RequestArtifact, error) {
+	return p.webhookDelivery.GetArtifact(ctx, requestID, name, userID)
+}
+
+// saveArtifact stores an artifact the agent produced for a request and returns it as listed
+// on the request's final payload. Artifacts that cannot be stored are logged and left out.
+func (p *Processor) saveArtifact(ctx context.Context, userID, agentID, requestID string, artifact *agentv1.ArtifactPayload) (webhook.Artifact, bool) {
+	if p.webhookDelivery == nil {
+		p.logger.Warn("dropping artifact, no artifact storage configured",
+			zap.String("request_id", requestID),
+			zap.String("artifact", artifact.GetName()),
+		)
+		return webhook.Artifact{}, false
+	}
+
+	ref, err := p.webhookDelivery.SaveArtifact(ctx, userID, agentID, requestID, artifact)
+	if err != nil {
+		p.logger.Warn("dropping artifact",
+			zap.Error(err),
+			zap.String("request_id", requestID),
+			zap.String("artifact", artifact.GetName()),
+			zap.Int("size", len(artifact.GetData())),
+		)
+		return webhook.Artifact{}, false
+	}
+	return ref, true
+}
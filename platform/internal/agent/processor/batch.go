@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// BatchStepRequestID returns the request ID used for a step of a batch
+func BatchStepRequestID(batchID string, step int) string {
+	return fmt.Sprintf("%s_%d", batchID, step)
+}
+
+// CreateBatch records a batch of stepCount messages a user sends before it starts, so its
+// state can be queried as soon as the batch is accepted.
+func (p *Processor) CreateBatch(ctx context.Context, batchID, userID, agentID string, stepCount int, continueOnError bool) error {
+	return p.webhookDelivery.CreateBatch(ctx, batchID, userID, agentID, stepCount, continueOnError)
+}
+
+// GetBatch returns a batch a user sent and the delivery records of the steps started so far.
+// It returns webhook.ErrBatchNotFound if the batch was never recorded or another user sent it.
+func (p *Processor) GetBatch(ctx context.Context, userID, batchID string) (*sqlc.RequestBatch, []*sqlc.WebhookDelivery, error) {
+	batch, deliveries, err := p.webhookDelivery.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.ownsRecord(ctx, userID, batch.UserID, batch.AgentID) {
+		return nil, nil, webhook.ErrBatchNotFound
+	}
+	return batch, deliveries, nil
+}
+
+// SendBatchWithWebhook sends messages to an agent one at a time over a single connection,
+// starting each only after the previous one's final event. Every step is tracked as its own
+// request (see BatchStepRequestID) and all events go to webhookCfg, tagged with the batch ID
+// and step index. The batch stops at the first failed step unless continueOnError is set;
+// connection failures always stop it.
+func (p *Processor) SendBatchWithWebhook(
+	ctx context.Context,
+	userID, agentID, batchID string,
+	messages []string,
+	continueOnError bool,
+	webhookCfg webhook.Config,
+) error {
+	p.logger.Info("sending message batch to agent",
+		zap.String("agent_id", agentID),
+		zap.String("batch_id", batchID),
+		zap.Int("steps", len(messages)),
+	)
+
+	failed := 0
+	finish := func(step int, stopErr error) error {
+		status := webhook.BatchStatusCompleted
+		if failed > 0 || stopErr != nil {
+			status = webhook.BatchStatusFailed
+		}
+		p.updateBatch(ctx, batchID, status, step, failed)
+		p.logger.Info("message batch finished",
+			zap.String("batch_id", batchID),
+			zap.String("status", status),
+			zap.Int("failed_steps", failed),
+		)
+		return stopErr
+	}
+
+	// failStep reports a step that could not run to completion
+	failStep := func(step int, requestID, code string, err error) {
+		failed++
+		errPayload := webhook.ErrorToPayload(agentID, requestID, 0, code, err.Error(), false)
+		annotateBatchStep(batchID, step)(&errPayload)
+		p.deliverErrorAsync(webhookCfg, errPayload)
+		_ = p.webhookDelivery.MarkDeliveryFailed(ctx, requestID)
+	}
+
+	p.updateBatch(ctx, batchID, webhook.BatchStatusInProgress, 0, 0)
+	p.startBatchStep(ctx, userID, agentID, batchID, 0, webhookCfg)
+
+	stream, err := p.connectPinned(ctx, userID, agentID)
+	if err != nil {
+		err = fmt.Errorf("failed to connect to agent: %w", err)
+		failStep(0, BatchStepRequestID(batchID, 0), connectErrorCode(err), err)
+		return finish(0, err)
+	}
+	// Closing the request side tells the agent no more requests are coming
+	defer func() {
+		_ = stream.CloseRequest()
+		_ = stream.CloseResponse()
+		stream.close()
+	}()
+
+	for step, content := range messages {
+		requestID := BatchStepRequestID(batchID, step)
+		if step > 0 {
+			p.updateBatch(ctx, batchID, webhook.BatchStatusInProgress, step, failed)
+			p.startBatchStep(ctx, userID, agentID, batchID, step, webhookCfg)
+		}
+
+		if err := stream.Send(newSendMessageRequest(requestID, content)); err != nil {
+			err = fmt.Errorf("failed to send request: %w", err)
+			failStep(step, requestID, "SEND_FAILED", err)
+			return finish(step, err)
+		}
+
+		queue := p.webhookDelivery.NewRequestQueue(requestID, webhookCfg)
+		final, err := p.relayToWebhook(ctx, stream, userID, agentID, requestID, queue, annotateBatchStep(batchID, step))
+		if err != nil {
+			// The error has been reported or no endpoint can receive it, the connection is unusable
+			failed++
+			return finish(step, err)
+		}
+		if final == nil {
+			// Sent through the step's queue, after the events it already relayed
+			failed++
+			err := fmt.Errorf("agent closed the stream before step %d finished", step)
+			errPayload := webhook.ErrorToPayload(agentID, requestID, queue.NextSeq(), "STREAM_CLOSED", err.Error(), false)
+			annotateBatchStep(batchID, step)(&errPayload)
+			if deliveryErr := queue.Send(ctx, errPayload); deliveryErr != nil {
+				p.logger.Error("failed to deliver error webhook", zap.Error(deliveryErr))
+			}
+			_ = p.webhookDelivery.MarkDeliveryFailed(ctx, requestID)
+			return finish(step, err)
+		}
+
+		// The step ended in agent.error or an unsuccessful agent.complete
+		if !final.Success {
+			failed++
+			_ = p.webhookDelivery.MarkDeliveryFailed(ctx, requestID)
+			if !continueOnError {
+				return finish(step, nil)
+			}
+		}
+	}
+
+	return finish(len(messages)-1, nil)
+}
+
+// startBatchStep creates the delivery record for a step and links it to its batch
+func (p *Processor) startBatchStep(ctx context.Context, userID, agentID, batchID string, step int, webhookCfg webhook.Config) {
+	requestID := BatchStepRequestID(batchID, step)
+	if err := p.webhookDelivery.CreateDeliveryRecord(ctx, requestID, userID, agentID, webhookCfg); err != nil {
+		p.logger.Error("failed to create delivery record", zap.Error(err))
+		// Continue anyway - we can still deliver webhooks without DB tracking
+		return
+	}
+	if err := p.webhookDelivery.AttachToBatch(ctx, requestID, batchID, step); err != nil {
+		p.logger.Error("failed to link delivery record to batch",
+			zap.Error(err),
+			zap.String("batch_id", batchID),
+			zap.Int("step", step),
+		)
+	}
+}
+
+// updateBatch records batch progress, logging rather than failing the batch on error
+func (p *Processor) updateBatch(ctx context.Context, batchID, status string, step, failed int) {
+	if err := p.webhookDelivery.UpdateBatchProgress(ctx, batchID, status, step, failed); err != nil {
+		p.logger.Error("failed to update batch progress",
+			zap.Error(err),
+			zap.String("batch_id", batchID),
+		)
+	}
+}
+
+// annotateBatchStep tags payloads with their batch and step index
+func annotateBatchStep(batchID string, step int) func(*webhook.Payload) {
+	return func(payload *webhook.Payload) {
+		payload.BatchID = batchID
+		payload.Step = &step
+	}
+}
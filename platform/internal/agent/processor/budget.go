@@ -0,0 +1,175 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// ErrBudgetExceeded is returned for new messages of a user who has spent their budget
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// ErrNoBudgetStorage is returned for budgets when no budget storage is configured
+var ErrNoBudgetStorage = errors.New("no budget storage configured")
+
+// ErrorCodeBudgetExceeded is the error code reported when a message is refused because its
+// user has spent their budget
+const ErrorCodeBudgetExceeded = "BUDGET_EXCEEDED"
+
+// budgetRefreshInterval is how long a user's cached budget and spend are trusted before
+// they are read again, picking up changes made through other replicas
+const budgetRefreshInterval = time.Minute
+
+// BudgetStatus is a user's spend against their budget in the current period
+type BudgetStatus struct {
+	Budget      webhook.Budget `json:"budget"`
+	SpentUSD    float64        `json:"spent_usd"`
+	PeriodStart time.Time      `json:"period_start"`
+	PeriodEnd   time.Time      `json:"period_end"`
+}
+
+// Exceeded reports whether the budget is spent
+func (s *BudgetStatus) Exceeded() bool {
+	return s.SpentUSD >= s.Budget.LimitUSD
+}
+
+// budgetCache keeps each user's budget and their spend in its current period, so checking
+// a message against it does not read the database. The spend is added to as requests end,
+// and read again every budgetRefreshInterval and once the period rolls over.
+type budgetCache struct {
+	mu    sync.Mutex
+	users map[string]*cachedBudget
+}
+
+// cachedBudget is a user's cached budget status; status is nil for users without a budget
+type cachedBudget struct {
+	status   *BudgetStatus
+	loadedAt time.Time
+}
+
+// CheckBudget checks a new message of a user against their budget. It returns the user's
+// budget status if the budget is spent, along with ErrBudgetExceeded unless the budget is
+// soft; otherwise, and for users without a budget, it returns nil. The spend of requests
+// still in progress is only counted once they end, so concurrent messages may overshoot
+// the budget. A budget that cannot be read lets messages through.
+func (p *Processor) CheckBudget(ctx context.Context, userID string) (*BudgetStatus, error) {
+	if p.webhookDelivery == nil {
+		return nil, nil
+	}
+	now := p.now()
+
+	p.budgets.mu.Lock()
+	cached, ok := p.budgets.users[userID]
+	p.budgets.mu.Unlock()
+	if !ok || now.Sub(cached.loadedAt) >= budgetRefreshInterval ||
+		(cached.status != nil && !now.Before(cached.status.PeriodEnd)) {
+		status, err := p.loadBudget(ctx, userID, now)
+		if err != nil {
+			p.logger.Warn("failed to check budget", zap.Error(err), zap.String("user_id", userID))
+			return nil, nil
+		}
+		cached = &cachedBudget{status: status, loadedAt: now}
+		p.budgets.mu.Lock()
+		if p.budgets.users == nil {
+			p.budgets.users = make(map[string]*cachedBudget)
+		}
+		p.budgets.users[userID] = cached
+		p.budgets.mu.Unlock()
+	}
+
+	p.budgets.mu.Lock()
+	defer p.budgets.mu.Unlock()
+	if cached.status == nil || !cached.status.Exceeded() {
+		return nil, nil
+	}
+	status := *cached.status
+	if status.Budget.Soft {
+		return &status, nil
+	}
+	return &status, fmt.Errorf("%w: spent $%.2f of the %s budget of $%.2f", ErrBudgetExceeded,
+		status.SpentUSD, status.Budget.Period, status.Budget.LimitUSD)
+}
+
+// BudgetStatus returns a user's budget and their spend in its current period, read from
+// the database, or webhook.ErrBudgetNotFound if they have no budget
+func (p *Processor) BudgetStatus(ctx context.Context, userID string) (*BudgetStatus, error) {
+	if p.webhookDelivery == nil {
+		return nil, ErrNoBudgetStorage
+	}
+	status, err := p.loadBudget(ctx, userID, p.now())
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, webhook.ErrBudgetNotFound
+	}
+	return status, nil
+}
+
+// SetBudget stores a user's budget, which their next message is checked against
+func (p *Processor) SetBudget(ctx context.Context, budget webhook.Budget) (*webhook.Budget, error) {
+	if p.webhookDelivery == nil {
+		return nil, ErrNoBudgetStorage
+	}
+	stored, err := p.webhookDelivery.SetBudget(ctx, budget)
+	if err != nil {
+		return nil, err
+	}
+	p.forgetBudget(budget.UserID)
+	return stored, nil
+}
+
+// DeleteBudget removes a user's budget, or returns webhook.ErrBudgetNotFound if they have none
+func (p *Processor) DeleteBudget(ctx context.Context, userID string) error {
+	if p.webhookDelivery == nil {
+		return ErrNoBudgetStorage
+	}
+	if err := p.webhookDelivery.DeleteBudget(ctx, userID); err != nil {
+		return err
+	}
+	p.forgetBudget(userID)
+	return nil
+}
+
+// loadBudget reads a user's budget and their spend in the period now falls in; the status
+// is nil for users without a budget
+func (p *Processor) loadBudget(ctx context.Context, userID string, now time.Time) (*BudgetStatus, error) {
+	budget, err := p.webhookDelivery.GetBudget(ctx, userID)
+	if errors.Is(err, webhook.ErrBudgetNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	start, end := budget.PeriodBounds(now)
+	spent, err := p.webhookDelivery.SumCostSince(ctx, userID, start)
+	if err != nil {
+		return nil, err
+	}
+	return &BudgetStatus{Budget: *budget, SpentUSD: spent, PeriodStart: start, PeriodEnd: end}, nil
+}
+
+// addBudgetSpend adds the cost of a request made at the given time to its user's cached
+// spend, if the request falls in the cached period
+func (p *Processor) addBudgetSpend(userID string, cost float64, at time.Time) {
+	p.budgets.mu.Lock()
+	defer p.budgets.mu.Unlock()
+	cached, ok := p.budgets.users[userID]
+	if !ok || cached.status == nil || at.Before(cached.status.PeriodStart) || !at.Before(cached.status.PeriodEnd) {
+		return
+	}
+	cached.status.SpentUSD += cost
+}
+
+// forgetBudget drops a user's cached budget, so it is read again on their next message
+func (p *Processor) forgetBudget(userID string) {
+	p.budgets.mu.Lock()
+	defer p.budgets.mu.Unlock()
+	delete(p.budgets.users, userID)
+}
@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// fakeBudgetQuerier stores budgets and request usage, summing costs as SumUserCostSince does
+type fakeBudgetQuerier struct {
+	sqlc.Querier
+	budgets map[string]*sqlc.UserBudget
+	usage   []*sqlc.UpsertRequestUsageParams
+}
+
+func (f *fakeBudgetQuerier) UpsertUserBudget(_ context.Context, arg *sqlc.UpsertUserBudgetParams) (*sqlc.UserBudget, error) {
+	b := &sqlc.UserBudget{UserID: arg.UserID, LimitUsd: arg.LimitUsd, Period: arg.Period, Soft: arg.Soft}
+	f.budgets[arg.UserID] = b
+	return b, nil
+}
+
+func (f *fakeBudgetQuerier) GetUserBudget(_ context.Context, userID string) (*sqlc.UserBudget, error) {
+	b, ok := f.budgets[userID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return b, nil
+}
+
+func (f *fakeBudgetQuerier) UpsertRequestUsage(_ context.Context, arg *sqlc.UpsertRequestUsageParams) error {
+	f.usage = append(f.usage, arg)
+	return nil
+}
+
+func (f *fakeBudgetQuerier) SumUserCostSince(_ context.Context, arg *sqlc.SumUserCostSinceParams) (float64, error) {
+	var cost float64
+	for _, u := range f.usage {
+		if u.UserID == arg.UserID && !u.CreatedAt.Before(arg.Since) {
+			cost += u.CostUsd
+		}
+	}
+	return cost, nil
+}
+
+// newBudgetTestProcessor returns a processor on a settable clock starting at now
+func newBudgetTestProcessor(t *testing.T, now time.Time) (*Processor, *fakeBudgetQuerier, *time.Time) {
+	querier := &fakeBudgetQuerier{budgets: make(map[string]*sqlc.UserBudget)}
+	p := NewProcessor(createTestK8sManager(t), webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+	clock := now
+	p.now = func() time.Time { return clock }
+	return p, querier, &clock
+}
+
+// finishRequest relays a request costing cost through the processor's usage tracking
+func finishRequest(p *Processor, requestID string, cost float64) {
+	usage, finish := p.trackUsage(context.Background(), "user1", "agent1", requestID)
+	usage.Observe(webhook.AgentResponseToPayload(usageEvent(1, "msg-"+requestID, 100, 10, cost), "agent1", requestID))
+	finish()
+}
+
+func TestCheckBudget_BlocksOnceSpent(t *testing.T) {
+	p, querier, _ := newBudgetTestProcessor(t, time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	if _, err := p.SetBudget(ctx, webhook.Budget{UserID: "user1", LimitUSD: 1, Period: webhook.BudgetPeriodMonthly}); err != nil {
+		t.Fatalf("SetBudget: %v", err)
+	}
+
+	finishRequest(p, "req-1", 0.75)
+	if status, err := p.CheckBudget(ctx, "user1"); status != nil || err != nil {
+		t.Fatalf("expected $0.75 of $1 to pass, got %+v, %v", status, err)
+	}
+
+	// The cached spend is added to as requests end, without reading it again
+	finishRequest(p, "req-2", 0.5)
+	querier.usage = nil
+	status, err := p.CheckBudget(ctx, "user1")
+	if !errors.Is(err, ErrBudgetExceeded) || status == nil || status.SpentUSD != 1.25 {
+		t.Fatalf("expected ErrBudgetExceeded at $1.25, got %+v, %v", status, err)
+	}
+	if !status.PeriodStart.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) ||
+		!status.PeriodEnd.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the March period, got %s - %s", status.PeriodStart, status.PeriodEnd)
+	}
+
+	if status, err := p.CheckBudget(ctx, "user2"); status != nil || err != nil {
+		t.Errorf("expected a user without a budget to pass, got %+v, %v", status, err)
+	}
+}
+
+func TestCheckBudget_SoftBudgetWarns(t *testing.T) {
+	p, _, _ := newBudgetTestProcessor(t, time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	if _, err := p.SetBudget(ctx, webhook.Budget{UserID: "user1", LimitUSD: 1, Period: webhook.BudgetPeriodMonthly, Soft: true}); err != nil {
+		t.Fatalf("SetBudget: %v", err)
+	}
+	finishRequest(p, "req-1", 2)
+
+	status, err := p.CheckBudget(ctx, "user1")
+	if err != nil || status == nil || status.SpentUSD != 2 || !status.Budget.Soft {
+		t.Fatalf("expected a warning without an error, got %+v, %v", status, err)
+	}
+}
+
+func TestCheckBudget_PeriodRollover(t *testing.T) {
+	p, querier, clock := newBudgetTestProcessor(t, time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+	if _, err := p.SetBudget(ctx, webhook.Budget{UserID: "user1", LimitUSD: 1, Period: webhook.BudgetPeriodMonthly}); err != nil {
+		t.Fatalf("SetBudget: %v", err)
+	}
+	finishRequest(p, "req-1", 1)
+	if _, err := p.CheckBudget(ctx, "user1"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded in March, got %v", err)
+	}
+
+	// April starts with nothing spent, well within the refresh interval
+	*clock = time.Date(2024, 4, 1, 0, 0, 1, 0, time.UTC)
+	if status, err := p.CheckBudget(ctx, "user1"); status != nil || err != nil {
+		t.Fatalf("expected the new period to pass, got %+v, %v", status, err)
+	}
+
+	// Spend recorded through another replica is read once the cache is refreshed
+	querier.usage = append(querier.usage, &sqlc.UpsertRequestUsageParams{UserID: "user1", CostUsd: 5, CreatedAt: *clock})
+	if _, err := p.CheckBudget(ctx, "user1"); err != nil {
+		t.Fatalf("expected the cached spend within the refresh interval, got %v", err)
+	}
+	*clock = clock.Add(budgetRefreshInterval)
+	if _, err := p.CheckBudget(ctx, "user1"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded once refreshed, got %v", err)
+	}
+}
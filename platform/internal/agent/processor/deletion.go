@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/forge/platform/internal/k8s"
+)
+
+// ErrAgentDeleted is the cause a stream is cancelled with when its agent is deleted
+// while the stream is open
+var ErrAgentDeleted = errors.New("agent deleted")
+
+// ErrorCodeAgentDeleted is the error code reported to consumers when a request is lost to
+// the deletion of its agent. It is not recoverable: the agent is gone.
+const ErrorCodeAgentDeleted = "AGENT_DELETED"
+
+// deletionHub is an in-process pub/sub of agent deletions, keyed by PodID. Subscribers
+// are called once when the agent is deleted through this processor; deletions on other
+// replicas are not seen.
+type deletionHub struct {
+	mu   sync.Mutex
+	next uint64
+	subs map[k8s.PodID]map[uint64]func()
+}
+
+// subscribe calls notify once podID is deleted, unless the returned func is called first
+func (h *deletionHub) subscribe(podID k8s.PodID, notify func()) (unsubscribe func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[k8s.PodID]map[uint64]func())
+	}
+	if h.subs[podID] == nil {
+		h.subs[podID] = make(map[uint64]func())
+	}
+	id := h.next
+	h.next++
+	h.subs[podID][id] = notify
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[podID], id)
+		if len(h.subs[podID]) == 0 {
+			delete(h.subs, podID)
+		}
+	}
+}
+
+// publish notifies and drops the subscribers of podID
+func (h *deletionHub) publish(podID k8s.PodID) {
+	h.mu.Lock()
+	subs := h.subs[podID]
+	delete(h.subs, podID)
+	h.mu.Unlock()
+
+	for _, notify := range subs {
+		notify()
+	}
+}
+
+// SubscribeAgentDeleted returns a channel closed once the agent is deleted through the
+// processor, and a func ending the subscription, which the caller must call once done.
+func (p *Processor) SubscribeAgentDeleted(userID, agentID string) (<-chan struct{}, func()) {
+	deleted := make(chan struct{})
+	unsubscribe := p.deletions.subscribe(*k8s.NewPodID(userID, agentID), func() { close(deleted) })
+	return deleted, unsubscribe
+}
+
+// cancelOnDeletion cancels ctx with an ErrAgentDeleted cause if podID is deleted before
+// ctx ends
+func (p *Processor) cancelOnDeletion(ctx context.Context, podID k8s.PodID, cancel context.CancelCauseFunc) {
+	unsubscribe := p.deletions.subscribe(podID, func() { cancel(ErrAgentDeleted) })
+	context.AfterFunc(ctx, unsubscribe)
+}
+
+// deleted returns the error the stream was cancelled with if its agent was deleted, or nil
+func (s *agentStream) deleted() error {
+	if cause := context.Cause(s.ctx); errors.Is(cause, ErrAgentDeleted) {
+		return cause
+	}
+	return nil
+}
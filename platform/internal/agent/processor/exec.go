@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/k8s"
+)
+
+// ErrExecDisabled is returned by ExecInAgent unless EXEC_ENABLED is set
+var ErrExecDisabled = errors.New("exec is disabled")
+
+// ExecMaxOutputBytes caps each of the stdout and stderr captured from a command run with
+// ExecInAgent
+const ExecMaxOutputBytes = 1 << 20
+
+// ExecInAgent runs command in the agent's container and returns its exit code and output.
+// The command is killed after timeout. It returns ErrExecDisabled unless EXEC_ENABLED is
+// set, and ErrAgentNotReady if the agent's pod is not ready.
+func (p *Processor) ExecInAgent(ctx context.Context, userID, agentID string, command []string, timeout time.Duration) (*k8s.ExecResult, error) {
+	if !p.execEnabled {
+		return nil, ErrExecDisabled
+	}
+	if err := p.requireReady(ctx, userID, agentID); err != nil {
+		return nil, err
+	}
+
+	// Commands run with the agent's access to its workspace and credentials
+	p.logger.Info("running command in agent",
+		zap.String("agent_id", agentID),
+		zap.String("user_id", userID),
+		zap.Strings("command", command),
+	)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return p.k8m.ExecInPod(ctx, *k8s.NewPodID(userID, agentID), command, ExecMaxOutputBytes)
+}
+
+// requireReady returns ErrAgentNotReady unless the agent's pod is ready
+func (p *Processor) requireReady(ctx context.Context, userID, agentID string) error {
+	pod, err := p.GetAgent(ctx, userID, agentID)
+	if err != nil {
+		return err
+	}
+	return k8s.CheckPodReady(pod)
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/exec"
+)
+
+// fakeExecutor answers commands with scripted output and error
+type fakeExecutor struct {
+	stdout, stderr string
+	err            error
+
+	pod, container string
+	command        []string
+}
+
+func (f *fakeExecutor) Exec(_ context.Context, _, pod, container string, command []string, _ io.Reader, stdout, stderr io.Writer) error {
+	f.pod, f.container, f.command = pod, container, command
+	_, _ = io.WriteString(stdout, f.stdout)
+	_, _ = io.WriteString(stderr, f.stderr)
+	return f.err
+}
+
+func TestExecInAgent(t *testing.T) {
+	mgr := createTestK8sManager(t, createReadyPod("user1", "agent1"))
+	executor := &fakeExecutor{stdout: "hello\n", stderr: "warning\n", err: exec.CodeExitError{Err: stderrors.New("exit 3"), Code: 3}}
+	mgr.SetExecutor(executor)
+	p := createTestProcessor(t, mgr)
+
+	if _, err := p.ExecInAgent(context.Background(), "user1", "agent1", []string{"ls"}, time.Second); !stderrors.Is(err, ErrExecDisabled) {
+		t.Fatalf("expected ErrExecDisabled, got %v", err)
+	}
+
+	p.execEnabled = true
+	result, err := p.ExecInAgent(context.Background(), "user1", "agent1", []string{"sh", "-c", "exit 3"}, time.Second)
+	if err != nil {
+		t.Fatalf("ExecInAgent failed: %v", err)
+	}
+	if result.ExitCode != 3 || result.Stdout != "hello\n" || result.Stderr != "warning\n" || result.Truncated {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if executor.container != "forge-agent" || strings.Join(executor.command, " ") != "sh -c exit 3" {
+		t.Errorf("expected the command run in the agent container, got %q in %q", executor.command, executor.container)
+	}
+
+	executor.err = stderrors.New("stream reset")
+	if _, err := p.ExecInAgent(context.Background(), "user1", "agent1", []string{"ls"}, time.Second); err == nil {
+		t.Error("expected a failed stream to be an error")
+	}
+}
+
+func TestExecInAgent_TruncatesOutput(t *testing.T) {
+	mgr := createTestK8sManager(t, createReadyPod("user1", "agent1"))
+	mgr.SetExecutor(&fakeExecutor{stdout: strings.Repeat("x", ExecMaxOutputBytes+10)})
+	p := createTestProcessor(t, mgr)
+	p.execEnabled = true
+
+	result, err := p.ExecInAgent(context.Background(), "user1", "agent1", []string{"cat", "big"}, time.Second)
+	if err != nil {
+		t.Fatalf("ExecInAgent failed: %v", err)
+	}
+	if len(result.Stdout) != ExecMaxOutputBytes || !result.Truncated {
+		t.Errorf("expected stdout truncated to %d bytes, got %d (truncated %v)", ExecMaxOutputBytes, len(result.Stdout), result.Truncated)
+	}
+}
+
+func TestExecInAgent_NotReady(t *testing.T) {
+	pod := createReadyPod("user1", "agent1")
+	pod.Status.ContainerStatuses[0].Ready = false
+	mgr := createTestK8sManager(t, pod)
+	executor := &fakeExecutor{}
+	mgr.SetExecutor(executor)
+	p := createTestProcessor(t, mgr)
+	p.execEnabled = true
+
+	if _, err := p.ExecInAgent(context.Background(), "user1", "agent1", []string{"ls"}, time.Second); !stderrors.Is(err, ErrAgentNotReady) {
+		t.Fatalf("expected ErrAgentNotReady, got %v", err)
+	}
+	if executor.command != nil {
+		t.Error("expected no command run in an unready pod")
+	}
+}
@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/k8s"
+)
+
+// DefaultAgentFileMaxBytes caps the bytes of a workspace upload or download unless
+// AGENT_FILE_MAX_BYTES says otherwise
+const DefaultAgentFileMaxBytes = 100 << 20
+
+// DefaultAgentFileTransferTimeout bounds an upload or download of an agent's files unless
+// AGENT_FILE_TRANSFER_TIMEOUT says otherwise
+const DefaultAgentFileTransferTimeout = 10 * time.Minute
+
+// FileTransferTimeout returns how long an upload or download of an agent's files may take,
+// 0 if there is no limit
+func (p *Processor) FileTransferTimeout() time.Duration {
+	return p.fileTransferTimeout
+}
+
+// FileMaxBytes returns the most bytes uploaded to or downloaded from an agent's workspace
+// in one request, 0 if there is no cap
+func (p *Processor) FileMaxBytes() int64 {
+	return p.fileMaxBytes
+}
+
+// UploadFiles extracts tarball, a tar archive, into dir, a directory relative to the
+// agent's working directory. It returns k8s.ErrInvalidPath for a dir outside the
+// workspace, and ErrAgentNotReady if the agent's pod is not ready.
+func (p *Processor) UploadFiles(ctx context.Context, userID, agentID, dir string, tarball io.Reader) error {
+	dest, err := k8s.WorkspacePath(dir)
+	if err != nil {
+		return err
+	}
+	if err := p.requireReady(ctx, userID, agentID); err != nil {
+		return err
+	}
+
+	p.logger.Info("uploading files to agent",
+		zap.String("agent_id", agentID),
+		zap.String("user_id", userID),
+		zap.String("dir", dest),
+	)
+	return p.k8m.CopyToPod(ctx, *k8s.NewPodID(userID, agentID), dest, tarball)
+}
+
+// DownloadFiles writes a tar archive of path, a file or directory relative to the agent's
+// working directory, to w. It returns k8s.ErrInvalidPath for a path outside the workspace,
+// k8s.ErrFileNotFound if it does not exist, and ErrAgentNotReady if the agent's pod is not
+// ready.
+func (p *Processor) DownloadFiles(ctx context.Context, userID, agentID, path string, w io.Writer) error {
+	src, err := k8s.WorkspacePath(path)
+	if err != nil {
+		return err
+	}
+	if err := p.requireReady(ctx, userID, agentID); err != nil {
+		return err
+	}
+	return p.k8m.CopyFromPod(ctx, *k8s.NewPodID(userID, agentID), src, w)
+}
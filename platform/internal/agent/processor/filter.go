@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/forge/platform/internal/k8s"
+)
+
+// AgentSort orders listed agents. Every order is total, so listing the same agents twice
+// gives the same order.
+type AgentSort string
+
+const (
+	// SortAgentID orders agents by ID; it is the default
+	SortAgentID AgentSort = "agent_id"
+	// SortCreatedAsc orders agents oldest first, and SortCreatedDesc newest first. Agents
+	// created at the same time are ordered by ID.
+	SortCreatedAsc  AgentSort = "created_at"
+	SortCreatedDesc AgentSort = "-created_at"
+)
+
+// AgentFilter selects and orders the agents ListAgentsFiltered returns. Zero fields match
+// every agent.
+type AgentFilter struct {
+	Phase         corev1.PodPhase
+	Ready         *bool
+	CreatedAfter  time.Time // exclusive
+	CreatedBefore time.Time // exclusive
+	Sort          AgentSort
+}
+
+// matches reports whether pod's agent is selected by f
+func (f AgentFilter) matches(pod *corev1.Pod) bool {
+	if f.Phase != "" && pod.Status.Phase != f.Phase {
+		return false
+	}
+	if f.Ready != nil && k8s.IsPodReady(pod) != *f.Ready {
+		return false
+	}
+	created := pod.CreationTimestamp.Time
+	if !f.CreatedAfter.IsZero() && !created.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !created.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// ListAgentsFiltered returns the agents of userID that filter selects, in its order. The
+// phase is pushed into the pod list; the other conditions are applied to the listed pods.
+func (p *Processor) ListAgentsFiltered(ctx context.Context, userID string, filter AgentFilter) ([]k8s.PodID, error) {
+	podList, err := p.k8m.ListPodsForUserInPhase(ctx, userID, filter.Phase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents for user %s: %w", userID, err)
+	}
+
+	pods := make([]*corev1.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Labels["user-id"] == "" || pod.Labels["agent-id"] == "" || !filter.matches(pod) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		a, b := pods[i], pods[j]
+		if filter.Sort == SortCreatedAsc || filter.Sort == SortCreatedDesc {
+			at, bt := a.CreationTimestamp.Time, b.CreationTimestamp.Time
+			if !at.Equal(bt) {
+				return at.Before(bt) == (filter.Sort == SortCreatedAsc)
+			}
+		}
+		return a.Labels["agent-id"] < b.Labels["agent-id"]
+	})
+
+	podIDs := make([]k8s.PodID, 0, len(pods))
+	for _, pod := range pods {
+		podIDs = append(podIDs, k8s.PodID{
+			UserID:  pod.Labels["user-id"],
+			AgentID: pod.Labels["agent-id"],
+		})
+	}
+	return podIDs, nil
+}
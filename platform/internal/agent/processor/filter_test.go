@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// filterPods returns user1's agents: ready agents a and c, pending agent b, and ready agent d
+// created along with a
+func filterPods(base time.Time) []*corev1.Pod {
+	created := func(pod *corev1.Pod, at time.Time) *corev1.Pod {
+		pod.CreationTimestamp = metav1.NewTime(at)
+		return pod
+	}
+	return []*corev1.Pod{
+		created(createReadyPod("user1", "c"), base.Add(2*time.Hour)),
+		created(createPendingPod("user1", "b"), base.Add(time.Hour)),
+		created(createReadyPod("user1", "d"), base),
+		created(createReadyPod("user1", "a"), base),
+		created(createReadyPod("user2", "e"), base.Add(time.Hour)),
+	}
+}
+
+func agentIDs(t *testing.T, p *Processor, filter AgentFilter) []string {
+	t.Helper()
+	podIDs, err := p.ListAgentsFiltered(context.Background(), "user1", filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(podIDs))
+	for _, podID := range podIDs {
+		ids = append(ids, podID.AgentID)
+	}
+	return ids
+}
+
+func TestListAgentsFiltered(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	pods := filterPods(base)
+	mgr := createTestK8sManager(t, pods[0], pods[1], pods[2], pods[3], pods[4])
+	p := createTestProcessor(t, mgr)
+	ready, notReady := true, false
+
+	tests := []struct {
+		name   string
+		filter AgentFilter
+		want   []string
+	}{
+		{"all by ID", AgentFilter{}, []string{"a", "b", "c", "d"}},
+		{"oldest first", AgentFilter{Sort: SortCreatedAsc}, []string{"a", "d", "b", "c"}},
+		{"newest first", AgentFilter{Sort: SortCreatedDesc}, []string{"c", "b", "a", "d"}},
+		{"pending", AgentFilter{Phase: corev1.PodPending}, []string{"b"}},
+		{"running", AgentFilter{Phase: corev1.PodRunning, Sort: SortCreatedDesc}, []string{"c", "a", "d"}},
+		{"ready", AgentFilter{Ready: &ready}, []string{"a", "c", "d"}},
+		{"not ready", AgentFilter{Ready: &notReady}, []string{"b"}},
+		{"created after", AgentFilter{CreatedAfter: base}, []string{"b", "c"}},
+		{"created before", AgentFilter{CreatedBefore: base.Add(2 * time.Hour)}, []string{"a", "b", "d"}},
+		{"ready in window", AgentFilter{Ready: &ready, CreatedAfter: base.Add(-time.Minute), CreatedBefore: base.Add(time.Hour)}, []string{"a", "d"}},
+		{"failed", AgentFilter{Phase: corev1.PodFailed}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := agentIDs(t, p, tt.filter)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// ErrInterrupted is the cause a message's context is cancelled with when an interrupt
+// ends it. The message ends in an agent.result with status "interrupted", not an error.
+var ErrInterrupted = errors.New("request interrupted")
+
+// activeMessage is a message in flight to an agent
+type activeMessage struct {
+	requestID string
+	cancel    context.CancelCauseFunc
+}
+
+// messageRegistry keeps the messages in flight to each agent, so an interrupt can end
+// the streams relaying them instead of opening one of its own
+type messageRegistry struct {
+	mu       sync.Mutex
+	messages map[k8s.PodID][]*activeMessage
+}
+
+// track registers requestID as in flight to podID until the returned func is called. The
+// returned context is cancelled with ErrInterrupted when the message is interrupted.
+func (r *messageRegistry) track(ctx context.Context, podID k8s.PodID, requestID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	m := &activeMessage{requestID: requestID, cancel: cancel}
+
+	r.mu.Lock()
+	if r.messages == nil {
+		r.messages = make(map[k8s.PodID][]*activeMessage)
+	}
+	r.messages[podID] = append(r.messages[podID], m)
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		r.messages[podID] = slices.DeleteFunc(r.messages[podID], func(other *activeMessage) bool { return other == m })
+		if len(r.messages[podID]) == 0 {
+			delete(r.messages, podID)
+		}
+		r.mu.Unlock()
+		cancel(context.Canceled)
+	}
+}
+
+// interrupt cancels the messages in flight to podID with ErrInterrupted, only requestID's
+// if set, and returns the request IDs of those it cancelled
+func (r *messageRegistry) interrupt(podID k8s.PodID, requestID string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var interrupted []string
+	for _, m := range r.messages[podID] {
+		if requestID != "" && m.requestID != requestID {
+			continue
+		}
+		m.cancel(ErrInterrupted)
+		interrupted = append(interrupted, m.requestID)
+	}
+	return interrupted
+}
+
+// interrupted reports whether ctx was cancelled by an interrupt of its message
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrInterrupted)
+}
+
+// InterruptWithWebhook interrupts an agent and delivers the response via webhook. Messages
+// in flight to the agent through the processor, only targetRequestID if set, are ended
+// first: their streams are cancelled and their consumers sent an agent.result with status
+// "interrupted". The agent is then told to stop, and the interrupt's consumer sent a
+// successful agent.complete. Only if no such message is in flight is the interrupt sent
+// on a stream of its own, whose responses are delivered.
+func (p *Processor) InterruptWithWebhook(ctx context.Context, userID, agentID, requestID, targetRequestID string, webhookCfg webhook.Config) error {
+	p.logger.Info("interrupting agent",
+		zap.String("agent_id", agentID),
+		zap.String("request_id", requestID),
+		zap.String("target_request_id", targetRequestID),
+	)
+
+	// Create webhook delivery record
+	if err := p.webhookDelivery.CreateDeliveryRecord(ctx, requestID, userID, agentID, webhookCfg); err != nil {
+		if errors.Is(err, webhook.ErrDuplicateRequest) {
+			p.logger.Info("interrupt already sent, not sending it again", zap.String("request_id", requestID))
+			return nil
+		}
+		p.logger.Error("failed to create delivery record", zap.Error(err))
+	}
+
+	targets := p.messages.interrupt(*k8s.NewPodID(userID, agentID), targetRequestID)
+	if len(targets) == 0 {
+		return p.interruptOnNewStream(ctx, userID, agentID, requestID, webhookCfg)
+	}
+	p.logger.Info("interrupted in-flight messages",
+		zap.String("agent_id", agentID),
+		zap.Strings("interrupted", targets),
+	)
+
+	// Cancelling the streams does not stop the agent working on the messages
+	p.interruptAgent(ctx, userID, agentID, requestID)
+
+	queue := p.webhookDelivery.NewRequestQueue(requestID, webhookCfg)
+	if err := queue.Send(ctx, webhook.CompleteToPayload(agentID, requestID, queue.NextSeq(), true)); err != nil {
+		p.logger.Error("failed to deliver completion webhook", zap.Error(err))
+		_ = p.webhookDelivery.MarkDeliveryFailed(ctx, requestID)
+		return err
+	}
+	_ = p.webhookDelivery.MarkDeliveryCompleted(ctx, requestID)
+	return nil
+}
+
+// deliverInterrupted ends an interrupted request with an agent.result with status
+// "interrupted" listing the request's artifacts, which it returns. It runs even though
+// ctx was cancelled.
+func (p *Processor) deliverInterrupted(
+	ctx context.Context,
+	agentID, requestID string,
+	queue *webhook.RequestQueue,
+	artifacts []webhook.Artifact,
+	annotate func(*webhook.Payload),
+) *webhook.Payload {
+	ctx = context.WithoutCancel(ctx)
+	result := webhook.ResultToPayload(agentID, requestID, queue.NextSeq(), webhook.ResultStatusInterrupted)
+	result.Artifacts = artifacts
+	if annotate != nil {
+		annotate(&result)
+	}
+	if err := queue.Send(ctx, result); err != nil {
+		p.logger.Error("failed to deliver interrupted result webhook", zap.Error(err))
+	}
+	_ = p.webhookDelivery.MarkDeliveryCompleted(ctx, requestID)
+	return &result
+}
@@ -0,0 +1,206 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+)
+
+// ErrorCodeRequestTimeout is the error code reported to consumers when a request runs
+// longer than MESSAGE_MAX_DURATION
+const ErrorCodeRequestTimeout = "REQUEST_TIMEOUT"
+
+// ErrorCodeShutdown is the error code reported to consumers when a request is cut short
+// because the platform is shutting down. The request can be sent again once it is back.
+const ErrorCodeShutdown = "PLATFORM_SHUTDOWN"
+
+// interruptTimeout bounds how long cancelling a request waits for the agent to take the interrupt
+const interruptTimeout = 5 * time.Second
+
+var (
+	// ErrShuttingDown is returned by RunJob once the platform has started shutting down
+	ErrShuttingDown = errors.New("platform is shutting down")
+	// ErrJobNotFound is returned by CancelJob for a request that is not in flight
+	ErrJobNotFound = errors.New("request is not in flight")
+
+	// Causes a job's context is cancelled with
+	errJobCancelled = errors.New("request cancelled")
+	errJobTimedOut  = errors.New("request exceeded its maximum duration")
+)
+
+// job is the background work of one accepted request
+type job struct {
+	userID  string
+	agentID string
+	cancel  context.CancelCauseFunc
+}
+
+// jobTracker keeps the background work of requests that were answered before they
+// finished, so it can be cancelled one request at a time or all at once on shutdown
+type jobTracker struct {
+	ctx  context.Context // ends when the platform shuts down
+	stop context.CancelCauseFunc
+
+	// maxDuration bounds how long each job may run; 0 means no limit
+	maxDuration time.Duration
+
+	mu      sync.Mutex
+	jobs    map[string]*job // by request ID
+	running int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newJobTracker() *jobTracker {
+	ctx, stop := context.WithCancelCause(context.Background())
+	return &jobTracker{ctx: ctx, stop: stop, jobs: make(map[string]*job)}
+}
+
+// RunJob runs fn in the background as the work of requestID on userID's agent. fn's
+// context keeps the values of parent, such as request IDs and traces, but not its
+// cancellation: it ends when the job is cancelled with CancelJob, after
+// MESSAGE_MAX_DURATION, or when the platform shuts down. Jobs without a request ID are
+// tracked for shutdown only. RunJob returns ErrShuttingDown once shutdown has begun.
+func (p *Processor) RunJob(parent context.Context, requestID, userID, agentID string, fn func(ctx context.Context)) error {
+	t := p.jobs
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+	j := &job{userID: userID, agentID: agentID, cancel: cancel}
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		cancel(ErrShuttingDown)
+		return ErrShuttingDown
+	}
+	t.wg.Add(1)
+	t.running++
+	if requestID != "" {
+		// A request ID sent again replaces the earlier job as the one cancelled by ID
+		t.jobs[requestID] = j
+	}
+	t.mu.Unlock()
+
+	stopOnShutdown := context.AfterFunc(t.ctx, func() { cancel(context.Cause(t.ctx)) })
+	go func() {
+		defer func() {
+			stopOnShutdown()
+			cancel(context.Canceled)
+			t.mu.Lock()
+			if t.jobs[requestID] == j {
+				delete(t.jobs, requestID)
+			}
+			t.running--
+			t.mu.Unlock()
+			t.wg.Done()
+		}()
+
+		jobCtx := ctx
+		if t.maxDuration > 0 {
+			var cancelTimeout context.CancelFunc
+			jobCtx, cancelTimeout = context.WithTimeoutCause(ctx, t.maxDuration, errJobTimedOut)
+			defer cancelTimeout()
+		}
+		fn(jobCtx)
+	}()
+	return nil
+}
+
+// CancelJob cancels the in-flight request requestID of userID's agent and interrupts the
+// agent. The request's consumer is sent an agent.error with code REQUEST_CANCELLED. It
+// returns ErrJobNotFound if the request is not in flight or belongs to another user.
+func (p *Processor) CancelJob(ctx context.Context, requestID, userID string) error {
+	p.jobs.mu.Lock()
+	j, ok := p.jobs.jobs[requestID]
+	p.jobs.mu.Unlock()
+	if !ok || j.userID != userID {
+		return ErrJobNotFound
+	}
+
+	j.cancel(errJobCancelled)
+	p.interruptAgent(ctx, userID, j.agentID, requestID)
+	return nil
+}
+
+// interruptAgent asks the agent to stop working, sending the interrupt as requestID and
+// waiting up to interruptTimeout for it to close the stream. Failures are logged: the
+// streams of the requests it ends are already cancelled.
+func (p *Processor) interruptAgent(ctx context.Context, userID, agentID, requestID string) {
+	ctx, cancel := context.WithTimeout(ctx, interruptTimeout)
+	defer cancel()
+
+	stream, _, err := p.openRequestStream(ctx, userID, agentID, &agentv1.AgentRequest{
+		RequestId: requestID,
+		Command:   &agentv1.AgentRequest_Interrupt{Interrupt: &agentv1.InterruptRequest{}},
+	})
+	if err != nil {
+		p.logger.Warn("failed to interrupt agent",
+			zap.Error(err),
+			zap.String("agent_id", agentID),
+			zap.String("request_id", requestID),
+		)
+		return
+	}
+	defer stream.close()
+
+	// The agent's response to the interrupt has no consumer
+	for {
+		if _, err := stream.Receive(); err != nil {
+			return
+		}
+	}
+}
+
+// Shutdown stops accepting jobs and waits up to drain for the running ones to finish.
+// Jobs still running then are cancelled, which reports PLATFORM_SHUTDOWN to their
+// consumers, and Shutdown waits for them to return until ctx ends.
+func (p *Processor) Shutdown(ctx context.Context, drain time.Duration) error {
+	t := p.jobs
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(drain)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	t.mu.Lock()
+	running := t.running
+	t.mu.Unlock()
+	p.logger.Warn("cancelling in-flight requests for shutdown", zap.Int("requests", running))
+	t.stop(ErrShuttingDown)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancelErrorCode returns the error code reporting why a job's ctx ended, and whether
+// the request can be sent again
+func cancelErrorCode(ctx context.Context) (code string, recoverable bool) {
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, ErrShuttingDown):
+		return ErrorCodeShutdown, true
+	case errors.Is(cause, errJobTimedOut):
+		return ErrorCodeRequestTimeout, false
+	default:
+		return ErrorCodeRequestCancelled, false
+	}
+}
@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/webhook"
+)
+
+type ctxKey struct{}
+
+// runJob starts a job that waits for its context to end and reports the error code the
+// request would fail with
+func runJob(t *testing.T, p *Processor, parent context.Context, requestID string) <-chan string {
+	t.Helper()
+	codes := make(chan string, 1)
+	err := p.RunJob(parent, requestID, "user1", "agent1", func(ctx context.Context) {
+		<-ctx.Done()
+		code, _ := cancelErrorCode(ctx)
+		codes <- code
+	})
+	if err != nil {
+		t.Fatalf("RunJob: %v", err)
+	}
+	return codes
+}
+
+func waitCode(t *testing.T, codes <-chan string) string {
+	t.Helper()
+	select {
+	case code := <-codes:
+		return code
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not end")
+		return ""
+	}
+}
+
+func TestRunJob_OutlivesParentAndKeepsItsValues(t *testing.T) {
+	p := createTestProcessor(t, createTestK8sManager(t))
+
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "trace-1"))
+	values := make(chan any, 1)
+	done := make(chan error, 1)
+	err := p.RunJob(parent, "req-1", "user1", "agent1", func(ctx context.Context) {
+		values <- ctx.Value(ctxKey{})
+		cancel() // the HTTP request completing
+		select {
+		case <-ctx.Done():
+			done <- ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			done <- nil
+		}
+	})
+	if err != nil {
+		t.Fatalf("RunJob: %v", err)
+	}
+	if v := <-values; v != "trace-1" {
+		t.Errorf("expected the parent's values, got %v", v)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expected the job to outlive its parent, got %v", err)
+	}
+}
+
+func TestRunJob_EndsWithMaxDurationOrCancel(t *testing.T) {
+	p := createTestProcessor(t, createTestK8sManager(t))
+	p.jobs.maxDuration = 20 * time.Millisecond
+	if code := waitCode(t, runJob(t, p, context.Background(), "req-slow")); code != ErrorCodeRequestTimeout {
+		t.Errorf("expected %s, got %s", ErrorCodeRequestTimeout, code)
+	}
+
+	p.jobs.maxDuration = 0
+	codes := runJob(t, p, context.Background(), "req-1")
+	if err := p.CancelJob(context.Background(), "req-1", "user2"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected another user's cancel to get ErrJobNotFound, got %v", err)
+	}
+	// The agent is not reachable, so only the job is cancelled
+	if err := p.CancelJob(context.Background(), "req-1", "user1"); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	if code := waitCode(t, codes); code != ErrorCodeRequestCancelled {
+		t.Errorf("expected %s, got %s", ErrorCodeRequestCancelled, code)
+	}
+}
+
+func TestShutdown_DrainsThenCancelsJobs(t *testing.T) {
+	p := createTestProcessor(t, createTestK8sManager(t))
+
+	finished := make(chan struct{})
+	if err := p.RunJob(context.Background(), "req-quick", "user1", "agent1", func(context.Context) {
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	}); err != nil {
+		t.Fatalf("RunJob: %v", err)
+	}
+	codes := runJob(t, p, context.Background(), "req-stuck")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx, 100*time.Millisecond); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("expected the quick job to finish within the drain timeout")
+	}
+	if code := waitCode(t, codes); code != ErrorCodeShutdown {
+		t.Errorf("expected %s, got %s", ErrorCodeShutdown, code)
+	}
+
+	err := p.RunJob(context.Background(), "req-late", "user1", "agent1", func(context.Context) {
+		t.Error("expected no job to start after shutdown")
+	})
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected ErrShuttingDown, got %v", err)
+	}
+}
+
+func TestStop_ReportsStreamsCutOffByShutdown(t *testing.T) {
+	querier := &fakeStreamQuerier{}
+	cfg := &config.Config{MessageDrainTimeout: 50 * time.Millisecond}
+	lc := fxtest.NewLifecycle(t)
+	p := newProcessor(lc, createTestK8sManager(t), webhook.NewDeliveryServiceWithQuerier(querier, cfg, zap.NewNop()), nil, cfg, zap.NewNop())
+	lc.RequireStart()
+
+	// A long stream: the agent sends one event and then nothing until the stream is cancelled
+	streaming := make(chan struct{})
+	errs := make(chan error, 1)
+	if err := p.RunJob(context.Background(), "req-1", "user1", "agent1", func(ctx context.Context) {
+		streamCtx, cancel := context.WithCancelCause(ctx)
+		stream := &agentStream{
+			bidiStream: &ctxStream{fakeStream: fakeStream{responses: []*agentv1.AgentResponse{streamEvent(1)}}, ctx: streamCtx},
+			ctx:        streamCtx,
+			cancel:     cancel,
+		}
+		close(streaming)
+		errs <- p.streamToWebhook(ctx, stream, "user1", "agent1", "req-1", webhook.Config{URL: "https://example.com/hook"})
+	}); err != nil {
+		t.Fatalf("RunJob: %v", err)
+	}
+	<-streaming
+
+	start := time.Now()
+	lc.RequireStop()
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("expected stop within the drain timeout, took %s", took)
+	}
+	if err := <-errs; err == nil {
+		t.Error("expected the stream cut off")
+	}
+	if len(querier.queued) != 2 {
+		t.Fatalf("expected the event and one final payload, got %d payloads", len(querier.queued))
+	}
+	final := querier.queued[1]
+	if final.EventType != webhook.EventTypeError || final.Error == nil ||
+		final.Error.Code != ErrorCodeShutdown || !final.Error.Recoverable {
+		t.Errorf("expected a recoverable agent.error %s, got %+v (error %+v)", ErrorCodeShutdown, final, final.Error)
+	}
+}
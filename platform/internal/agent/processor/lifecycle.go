@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// Reasons reported with agent lifecycle events
+const (
+	LifecycleReasonCreated = "created"
+	LifecycleReasonReady   = "ready"
+	// LifecycleReasonShutDown is reported for an agent deleted after it shut down gracefully,
+	// and LifecycleReasonDeleted for any other deletion
+	LifecycleReasonShutDown = "shut_down"
+	LifecycleReasonDeleted  = "deleted"
+)
+
+// lifecycleEvent describes a change to an agent's lifecycle
+type lifecycleEvent struct {
+	podID     k8s.PodID
+	eventType webhook.EventType
+	phase     corev1.PodPhase
+	reason    string
+	createdAt time.Time // zero if unknown
+}
+
+// emitLifecycle publishes a lifecycle event to SubscribeLifecycle subscribers and delivers
+// it to the webhooks registered for the agent, if any. Delivery is queued and outlives
+// ctx; failing to queue it is logged and never fails the operation the event is about.
+func (p *Processor) emitLifecycle(ctx context.Context, event lifecycleEvent) {
+	now := p.now()
+	p.lifecycle.publish(LifecycleEvent{
+		Type:      event.eventType,
+		UserID:    event.podID.UserID,
+		AgentID:   event.podID.AgentID,
+		Phase:     event.phase,
+		Reason:    event.reason,
+		CreatedAt: event.createdAt,
+		At:        now,
+	})
+	if p.webhookDelivery == nil {
+		return
+	}
+
+	payload := webhook.Payload{
+		EventType: event.eventType,
+		AgentID:   event.podID.AgentID,
+		Timestamp: now,
+		Lifecycle: &webhook.LifecyclePayload{
+			UserID:   event.podID.UserID,
+			PodPhase: string(event.phase),
+			Reason:   event.reason,
+		},
+	}
+	if !event.createdAt.IsZero() {
+		payload.Lifecycle.AgentCreatedAt = &event.createdAt
+	}
+
+	if _, err := p.webhookDelivery.DeliverLifecycleEvent(context.WithoutCancel(ctx), event.podID.UserID, payload); err != nil {
+		p.logger.Warn("failed to deliver agent lifecycle event",
+			zap.Error(err),
+			zap.String("event_type", string(event.eventType)),
+			zap.String("user_id", event.podID.UserID),
+			zap.String("agent_id", event.podID.AgentID),
+		)
+	}
+}
+
+// failedEvent describes an agent whose pod failed to become ready with err
+func failedEvent(podID k8s.PodID, createdAt time.Time, err error) lifecycleEvent {
+	event := lifecycleEvent{
+		podID:     podID,
+		eventType: webhook.EventTypeAgentFailed,
+		reason:    err.Error(),
+		createdAt: createdAt,
+	}
+	var failure *k8s.PodFailureError
+	if errors.As(err, &failure) {
+		event.phase = failure.Phase
+		if failure.Reason != "" {
+			event.reason = failure.Reason
+		}
+	}
+	return event
+}
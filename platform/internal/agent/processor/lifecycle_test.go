@@ -0,0 +1,236 @@
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// fakeLifecycleQuerier serves the registered lifecycle webhooks and records the payloads
+// queued for delivery. Unimplemented querier methods panic via the nil embedded interface.
+type fakeLifecycleQuerier struct {
+	sqlc.Querier
+	hooks []*sqlc.LifecycleWebhook
+
+	mu     sync.Mutex
+	queued []webhook.Payload
+}
+
+// payloads returns the payloads queued so far
+func (f *fakeLifecycleQuerier) payloads() []webhook.Payload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]webhook.Payload(nil), f.queued...)
+}
+
+func (f *fakeLifecycleQuerier) ListLifecycleWebhooksForAgent(_ context.Context, arg *sqlc.ListLifecycleWebhooksForAgentParams) ([]*sqlc.LifecycleWebhook, error) {
+	var hooks []*sqlc.LifecycleWebhook
+	for _, hook := range f.hooks {
+		if hook.UserID == arg.UserID && (!hook.AgentID.Valid || hook.AgentID == arg.AgentID) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (f *fakeLifecycleQuerier) UpdateDeliverySeq(context.Context, *sqlc.UpdateDeliverySeqParams) error {
+	return nil
+}
+
+func (f *fakeLifecycleQuerier) EnqueueOutboxEvent(_ context.Context, arg *sqlc.EnqueueOutboxEventParams) error {
+	var payload webhook.Payload
+	if err := json.Unmarshal(arg.Payload, &payload); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queued = append(f.queued, payload)
+	return nil
+}
+
+func (f *fakeLifecycleQuerier) UpsertWebhookEvent(context.Context, *sqlc.UpsertWebhookEventParams) error {
+	return nil
+}
+
+// lifecycleProcessor returns a processor delivering lifecycle events through querier, whose
+// agent pods are created with status
+func lifecycleProcessor(t *testing.T, querier *fakeLifecycleQuerier, status corev1.PodStatus, objects ...runtime.Object) *Processor {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).Status = status
+		return false, nil, nil
+	})
+	deleteCollectionReactor(clientset)
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "")
+	return NewProcessor(mgr, webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+}
+
+// queuedTypes returns the event types of the queued payloads, in order
+func queuedTypes(payloads []webhook.Payload) []webhook.EventType {
+	types := make([]webhook.EventType, 0, len(payloads))
+	for _, payload := range payloads {
+		types = append(types, payload.EventType)
+	}
+	return types
+}
+
+func expectQueued(t *testing.T, querier *fakeLifecycleQuerier, want ...webhook.EventType) {
+	t.Helper()
+	got := queuedTypes(querier.queued)
+	if len(got) != len(want) {
+		t.Fatalf("expected events %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected events %v, got %v", want, got)
+		}
+	}
+}
+
+func TestLifecycle_CreateAgentReady(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user1", Url: "https://example.com/lifecycle"}}}
+	p := lifecycleProcessor(t, querier, corev1.PodStatus{
+		Phase:             corev1.PodRunning,
+		PodIP:             "10.0.0.5",
+		ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+	})
+
+	podID, err := p.CreateAgent(context.Background(), "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectQueued(t, querier, webhook.EventTypeAgentCreated, webhook.EventTypeAgentReady)
+	created, ready := querier.queued[0], querier.queued[1]
+	if created.AgentID != podID.AgentID || created.Lifecycle.UserID != "user1" || created.Lifecycle.PodPhase != string(corev1.PodPending) {
+		t.Errorf("expected agent.created for %s while pending, got %+v", podID.AgentID, created.Lifecycle)
+	}
+	if ready.Lifecycle.PodPhase != string(corev1.PodRunning) || ready.Lifecycle.AgentCreatedAt == nil {
+		t.Errorf("expected agent.ready while running with the creation time, got %+v", ready.Lifecycle)
+	}
+	if created.RequestID != ready.RequestID || created.Seq >= ready.Seq {
+		t.Errorf("expected the events in order under one request, got %s/%d and %s/%d",
+			created.RequestID, created.Seq, ready.RequestID, ready.Seq)
+	}
+}
+
+func TestLifecycle_CreateAgentFailed(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user1", Url: "https://example.com/lifecycle"}}}
+	p := lifecycleProcessor(t, querier, corev1.PodStatus{
+		Phase: corev1.PodFailed,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+		}},
+	})
+
+	if _, err := p.CreateAgent(context.Background(), "user1"); err == nil {
+		t.Fatal("expected the failed pod to fail creation")
+	}
+
+	expectQueued(t, querier, webhook.EventTypeAgentCreated, webhook.EventTypeAgentFailed)
+	if failed := querier.queued[1].Lifecycle; failed.Reason != "OOMKilled" || failed.PodPhase != string(corev1.PodFailed) {
+		t.Errorf("expected the failure reason and phase, got %+v", failed)
+	}
+}
+
+func TestLifecycle_CreateAgentAsync(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user1", Url: "https://example.com/lifecycle"}}}
+	p := lifecycleProcessor(t, querier, corev1.PodStatus{
+		Phase:             corev1.PodRunning,
+		PodIP:             "10.0.0.5",
+		ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+	})
+
+	podID, err := p.CreateAgentAsync(context.Background(), "user1", k8s.PodOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Shutdown waits for the background wait to finish
+	if err := p.Shutdown(context.Background(), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	expectQueued(t, querier, webhook.EventTypeAgentCreated, webhook.EventTypeAgentReady)
+	if ready := querier.queued[1]; ready.AgentID != podID.AgentID {
+		t.Errorf("expected agent.ready for %s, got %s", podID.AgentID, ready.AgentID)
+	}
+}
+
+func TestLifecycle_CreateAgentAsyncFailed(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user1", Url: "https://example.com/lifecycle"}}}
+	p := lifecycleProcessor(t, querier, corev1.PodStatus{
+		Phase: corev1.PodFailed,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+		}},
+	})
+
+	podID, err := p.CreateAgentAsync(context.Background(), "user1", k8s.PodOptions{})
+	if err != nil {
+		t.Fatalf("expected the pod created before it fails, got %v", err)
+	}
+	if err := p.Shutdown(context.Background(), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	expectQueued(t, querier, webhook.EventTypeAgentCreated, webhook.EventTypeAgentFailed)
+	if failed := querier.queued[1].Lifecycle; failed.Reason != "OOMKilled" {
+		t.Errorf("expected the failure reason, got %+v", failed)
+	}
+	if _, err := p.GetAgent(context.Background(), podID.UserID, podID.AgentID); err == nil {
+		t.Error("expected the failed pod deleted")
+	}
+}
+
+func TestLifecycle_DeleteAgent(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{
+		{ID: 1, UserID: "user1", AgentID: sql.NullString{String: "agent1", Valid: true}, Url: "https://example.com/agent1"},
+		{ID: 2, UserID: "user1", AgentID: sql.NullString{String: "agent2", Valid: true}, Url: "https://example.com/agent2"},
+	}}
+	p := lifecycleProcessor(t, querier, corev1.PodStatus{}, createReadyPod("user1", "agent1"))
+
+	if err := p.DeleteAgent(context.Background(), "user1", "agent1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the webhook registered for the deleted agent is told
+	expectQueued(t, querier, webhook.EventTypeAgentDeleted)
+	if deleted := querier.queued[0]; deleted.AgentID != "agent1" || deleted.Lifecycle.Reason != LifecycleReasonDeleted {
+		t.Errorf("expected agent1's deletion, got %s: %+v", deleted.AgentID, deleted.Lifecycle)
+	}
+}
+
+func TestLifecycle_DeleteAllAgents(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user1", Url: "https://example.com/lifecycle"}}}
+	p := lifecycleProcessor(t, querier, corev1.PodStatus{}, createReadyPod("user1", "agent1"), createReadyPod("user1", "agent2"))
+
+	if _, err := p.DeleteAllAgents(context.Background(), "user1", false); err != nil {
+		t.Fatal(err)
+	}
+	expectQueued(t, querier, webhook.EventTypeAgentDeleted, webhook.EventTypeAgentDeleted)
+}
+
+func TestLifecycle_DeleteWithoutWebhookIsNoop(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user2", Url: "https://example.com/lifecycle"}}}
+	p := lifecycleProcessor(t, querier, corev1.PodStatus{}, createReadyPod("user1", "agent1"))
+
+	if err := p.DeleteAgent(context.Background(), "user1", "agent1", false); err != nil {
+		t.Fatal(err)
+	}
+	expectQueued(t, querier)
+}
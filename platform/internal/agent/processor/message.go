@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// ErrNoMessageStorage is returned for stored messages when no message storage is configured
+var ErrNoMessageStorage = errors.New("no message storage configured")
+
+// StoredMessages returns up to limit of the messages stored for an agent from fromSeq
+// onwards, oldest first, along with the highest seq stored. They outlive the agent's pod,
+// so they answer for agents that are not running.
+func (p *Processor) StoredMessages(ctx context.Context, userID, agentID string, fromSeq uint64, limit int32) ([]webhook.Payload, uint64, error) {
+	if p.webhookDelivery == nil {
+		return nil, 0, ErrNoMessageStorage
+	}
+	return p.webhookDelivery.ListMessages(ctx, userID, agentID, fromSeq, limit)
+}
+
+// recordMessage stores a payload relayed from an agent in its message history. Messages
+// that cannot be stored are logged; the relay carries on regardless.
+func (p *Processor) recordMessage(ctx context.Context, userID string, payload webhook.Payload) {
+	if p.webhookDelivery == nil {
+		return
+	}
+	if err := p.webhookDelivery.RecordMessage(ctx, userID, payload); err != nil {
+		p.logger.Warn("failed to store agent message",
+			zap.Error(err),
+			zap.String("agent_id", payload.AgentID),
+			zap.String("request_id", payload.RequestID),
+			zap.Uint64("seq", payload.Seq),
+		)
+	}
+}
@@ -1,8 +1,75 @@
 package processor
 
-import "go.uber.org/fx"
+import (
+	"context"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/agent"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/webhook"
+)
 
 // Module provides the processor to the fx container
 var Module = fx.Module("agent.processor",
-	fx.Provide(NewProcessor),
+	fx.Provide(newProcessor),
+	fx.Invoke(registerMonitor),
 )
+
+// newProcessor creates a Processor using configuration from the fx container. Its
+// background jobs live as long as the app: on stop they get MESSAGE_DRAIN_TIMEOUT to
+// finish before they are cancelled. Webhook delivery stops after the processor, so the
+// errors reported for cancelled jobs are queued.
+func newProcessor(lc fx.Lifecycle, k8sManager *k8s.Manager, webhookDelivery *webhook.DeliveryService, m *metrics.Metrics, cfg *config.Config, logger *zap.Logger) *Processor {
+	p := NewProcessor(k8sManager, webhookDelivery, logger)
+	p.minProtocolVersion = cfg.AgentMinProtocolVersion
+	p.models = cfg.AgentModelAllowlist
+	p.createTimeout = cfg.AgentCreateTimeout
+	p.agentAuthSecret = cfg.AgentAuthSecret
+	p.pool = agent.NewClientPool(agent.PoolConfig{
+		IdleTimeout:     cfg.AgentConnIdleTimeout,
+		MaxConnsPerHost: cfg.AgentMaxConnsPerHost,
+		Metrics:         m,
+	})
+	p.rpcTimeout = cfg.AgentRPCTimeout
+	p.rpcRetries = cfg.AgentRPCRetries
+	p.execEnabled = cfg.ExecEnabled
+	p.fileMaxBytes = cfg.AgentFileMaxBytes
+	p.fileTransferTimeout = cfg.AgentFileTransferTimeout
+	p.quarantine = quarantineConfig(cfg)
+	p.resume = resumeConfig(cfg)
+	p.jobs.maxDuration = cfg.MessageMaxDuration
+	p.queues.depth = cfg.MessageQueueDepth
+	p.metrics = m
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			if err := p.Shutdown(ctx, cfg.MessageDrainTimeout); err != nil {
+				logger.Warn("in-flight requests did not finish before shutdown", zap.Error(err))
+			}
+			p.pool.Close()
+			return nil
+		},
+	})
+	return p
+}
+
+// registerMonitor runs the agent health monitor with the app, unless AGENT_FAILURE_WINDOW
+// is 0. It starts once the agent pod cache has synced and stops before it.
+func registerMonitor(lc fx.Lifecycle, p *Processor, cfg *config.Config, logger *zap.Logger) {
+	monitorCfg := monitorConfig(cfg)
+	if monitorCfg.FailureWindow <= 0 {
+		return
+	}
+	m := NewMonitor(p, monitorCfg, logger)
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error { return m.Start() },
+		OnStop: func(context.Context) error {
+			m.Stop()
+			return nil
+		},
+	})
+}
@@ -0,0 +1,325 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// monitorProbeTimeout bounds the status probe sent to an agent whose pod is not ready
+const monitorProbeTimeout = 5 * time.Second
+
+// LifecycleReasonUnresponsive is reported for a running agent found dead whose pod gives no
+// reason of its own
+const LifecycleReasonUnresponsive = "unresponsive"
+
+// MonitorConfig holds how the health monitor reacts to agents whose pods stop being ready
+type MonitorConfig struct {
+	// FailureWindow is how long a pod may be unready, with its agent not answering a status
+	// probe, before the agent is reported failed; 0 disables the monitor
+	FailureWindow time.Duration
+	// AutoRestart restarts failed agents, at most MaxRestarts times each, after a delay
+	// starting at RestartBackoff and doubling with each restart
+	AutoRestart    bool
+	MaxRestarts    int
+	RestartBackoff time.Duration
+}
+
+// monitorConfig returns the health monitor settings from cfg
+func monitorConfig(cfg *config.Config) MonitorConfig {
+	return MonitorConfig{
+		FailureWindow:  cfg.AgentFailureWindow,
+		AutoRestart:    cfg.AgentAutoRestart,
+		MaxRestarts:    cfg.AgentMaxRestarts,
+		RestartBackoff: cfg.AgentRestartBackoff,
+	}
+}
+
+// monitoredAgent is what the monitor knows of one agent
+type monitoredAgent struct {
+	pod *corev1.Pod // latest state of the agent's pod
+	// ready is true once the pod was seen ready; pods still starting are left to CreateAgent
+	ready bool
+	// check fires once the pod has been unready for the failure window
+	check *time.Timer
+	// failed is true from the failure report until the pod is ready again or restarted
+	failed bool
+	// restarting is true while the pod is being replaced, whose deletion is expected
+	restarting bool
+	restarts   int
+}
+
+// Monitor watches the agent pods and reports agents whose pods stop being ready and that
+// stop answering: it emits agent.failed and optionally restarts them. Deletions are not
+// failures, and only pods seen ready are watched.
+type Monitor struct {
+	p      *Processor
+	cfg    MonitorConfig
+	logger *zap.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu          sync.Mutex
+	agents      map[k8s.PodID]*monitoredAgent
+	unsubscribe func()
+	stopped     bool
+}
+
+// NewMonitor creates a health monitor of p's agents
+func NewMonitor(p *Processor, cfg MonitorConfig, logger *zap.Logger) *Monitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Monitor{
+		p:      p,
+		cfg:    cfg,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		agents: make(map[k8s.PodID]*monitoredAgent),
+	}
+}
+
+// Start subscribes the monitor to the agent pod cache, which must be started
+func (m *Monitor) Start() error {
+	unsubscribe, err := m.p.k8m.SubscribeAgentPods(m.observe)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.unsubscribe = unsubscribe
+	m.mu.Unlock()
+	return nil
+}
+
+// Stop unsubscribes the monitor and waits for the probes and restarts in progress, which
+// are cancelled
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	m.stopped = true
+	if m.unsubscribe != nil {
+		m.unsubscribe()
+		m.unsubscribe = nil
+	}
+	for _, agent := range m.agents {
+		if agent.check != nil {
+			agent.check.Stop()
+		}
+	}
+	m.mu.Unlock()
+
+	m.cancel()
+	m.wg.Wait()
+}
+
+// observe records a change to an agent pod, scheduling a check of an agent whose pod was
+// ready and no longer is
+func (m *Monitor) observe(pod *corev1.Pod, deleted bool) {
+	podID := k8s.PodID{UserID: pod.Labels["user-id"], AgentID: pod.Labels["agent-id"]}
+	if podID.UserID == "" || podID.AgentID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent, ok := m.agents[podID]
+	if deleted {
+		// A Deployment may already run the agent's next pod
+		if ok && agent.pod.Name == pod.Name && !agent.restarting {
+			m.forget(podID, agent)
+		}
+		return
+	}
+	if !ok {
+		agent = &monitoredAgent{}
+		m.agents[podID] = agent
+	}
+	agent.pod = pod
+
+	switch {
+	case k8s.IsPodReady(pod):
+		agent.ready = true
+		agent.failed = false
+		if agent.check != nil {
+			agent.check.Stop()
+			agent.check = nil
+		}
+	case agent.ready && !agent.failed && agent.check == nil:
+		agent.check = m.schedule(m.cfg.FailureWindow, func() { m.checkAgent(podID) })
+	}
+}
+
+// forget stops watching an agent. The caller must hold mu.
+func (m *Monitor) forget(podID k8s.PodID, agent *monitoredAgent) {
+	if agent.check != nil {
+		agent.check.Stop()
+	}
+	delete(m.agents, podID)
+}
+
+// schedule runs fn after delay unless the monitor stops first. The caller must hold mu.
+func (m *Monitor) schedule(delay time.Duration, fn func()) *time.Timer {
+	return time.AfterFunc(delay, func() {
+		m.mu.Lock()
+		if m.stopped {
+			m.mu.Unlock()
+			return
+		}
+		m.wg.Add(1)
+		m.mu.Unlock()
+
+		defer m.wg.Done()
+		fn()
+	})
+}
+
+// checkAgent probes an agent whose pod has been unready for the failure window, reporting
+// it failed unless it answers or its pod became ready again
+func (m *Monitor) checkAgent(podID k8s.PodID) {
+	m.mu.Lock()
+	agent, ok := m.agents[podID]
+	if !ok || agent.check == nil {
+		m.mu.Unlock()
+		return
+	}
+	agent.check = nil
+	pod := agent.pod
+	m.mu.Unlock()
+
+	if err := m.probe(podID); err == nil {
+		// Unready but answering, e.g. a failing readiness probe: look again later
+		m.mu.Lock()
+		if agent, ok := m.agents[podID]; ok && !k8s.IsPodReady(agent.pod) && agent.check == nil {
+			agent.check = m.schedule(m.cfg.FailureWindow, func() { m.checkAgent(podID) })
+		}
+		m.mu.Unlock()
+		return
+	}
+
+	m.mu.Lock()
+	agent, ok = m.agents[podID]
+	if !ok || k8s.IsPodReady(agent.pod) || agent.failed {
+		m.mu.Unlock()
+		return
+	}
+	agent.failed = true
+	pod = agent.pod
+	restarts := agent.restarts
+	m.mu.Unlock()
+
+	m.reportFailure(podID, pod)
+	if m.cfg.AutoRestart && restarts < m.cfg.MaxRestarts {
+		m.scheduleRestart(podID, restarts)
+	}
+}
+
+// probe asks the agent for its status
+func (m *Monitor) probe(podID k8s.PodID) error {
+	ctx, cancel := context.WithTimeout(m.ctx, monitorProbeTimeout)
+	defer cancel()
+	address, err := m.p.k8m.GetPodAddress(ctx, podID)
+	if err != nil {
+		return fmt.Errorf("failed to get agent address: %w", err)
+	}
+	if _, err := m.p.agentClient(podID, address).GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{})); err != nil {
+		return fmt.Errorf("failed to get agent status: %w", err)
+	}
+	return nil
+}
+
+// reportFailure records a dead agent and emits agent.failed
+func (m *Monitor) reportFailure(podID k8s.PodID, pod *corev1.Pod) {
+	reason, _ := k8s.UnreadyReason(pod)
+	if reason == "" {
+		reason = LifecycleReasonUnresponsive
+	}
+	m.logger.Warn("agent failed",
+		zap.String("user_id", podID.UserID),
+		zap.String("agent_id", podID.AgentID),
+		zap.String("pod_phase", string(pod.Status.Phase)),
+		zap.String("reason", reason),
+	)
+	m.p.metrics.AgentFailureDetected()
+	m.p.emitLifecycle(m.ctx, lifecycleEvent{
+		podID:     podID,
+		eventType: webhook.EventTypeAgentFailed,
+		phase:     pod.Status.Phase,
+		reason:    reason,
+		createdAt: pod.CreationTimestamp.Time,
+	})
+}
+
+// scheduleRestart restarts a failed agent after the backoff of its restarts so far. A
+// restart that fails is retried until the agent runs out of restarts.
+func (m *Monitor) scheduleRestart(podID k8s.PodID, restarts int) {
+	delay := m.cfg.RestartBackoff << restarts
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.agents[podID]; !ok {
+		return
+	}
+	m.schedule(delay, func() { m.restart(podID) })
+}
+
+// restart restarts a failed agent whose pod is still not ready. The restarted agent is
+// checked again once the failure window has passed.
+func (m *Monitor) restart(podID k8s.PodID) {
+	m.mu.Lock()
+	agent, ok := m.agents[podID]
+	if !ok || !agent.failed || k8s.IsPodReady(agent.pod) {
+		m.mu.Unlock()
+		return
+	}
+	agent.restarts++
+	agent.restarting = true
+	restarts := agent.restarts
+	m.mu.Unlock()
+
+	addresses := m.p.agentAddresses(m.ctx, podID)
+	err := m.p.k8m.RestartPod(m.ctx, podID)
+	m.p.invalidateConns(addresses)
+	m.p.metrics.AgentRestarted(err)
+
+	m.mu.Lock()
+	agent.restarting = false
+	if err == nil && !m.stopped {
+		agent.failed = false
+		if agent.check == nil {
+			agent.check = m.schedule(m.cfg.FailureWindow, func() { m.checkAgent(podID) })
+		}
+	}
+	m.mu.Unlock()
+
+	if err == nil {
+		m.logger.Info("restarted failed agent",
+			zap.String("user_id", podID.UserID),
+			zap.String("agent_id", podID.AgentID),
+			zap.Int("restarts", restarts),
+		)
+		return
+	}
+	if m.ctx.Err() != nil {
+		return
+	}
+	m.logger.Warn("failed to restart failed agent",
+		zap.Error(err),
+		zap.String("user_id", podID.UserID),
+		zap.String("agent_id", podID.AgentID),
+		zap.Int("restarts", restarts),
+	)
+	if restarts < m.cfg.MaxRestarts {
+		m.scheduleRestart(podID, restarts)
+	}
+}
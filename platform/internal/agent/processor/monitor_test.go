@@ -0,0 +1,201 @@
+package processor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// statusAgentService answers status probes
+type statusAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	probes atomic.Int32
+}
+
+func (s *statusAgentService) GetStatus(
+	context.Context,
+	*connect.Request[agentv1.GetStatusRequest],
+) (*connect.Response[agentv1.GetStatusResponse], error) {
+	s.probes.Add(1)
+	return connect.NewResponse(&agentv1.GetStatusResponse{State: agentv1.AgentState_AGENT_STATE_IDLE}), nil
+}
+
+// startMonitor starts the pod cache of p's manager and a monitor of p's agents, both
+// stopped when the test ends
+func startMonitor(t *testing.T, p *Processor, cfg MonitorConfig) {
+	t.Helper()
+	if err := p.k8m.StartCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.k8m.StopCache)
+
+	m := NewMonitor(p, cfg, zap.NewNop())
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Stop)
+}
+
+// crashLoopingPod returns user1/agent1's pod with its container restarting
+func crashLoopingPod() *corev1.Pod {
+	pod := createReadyPod("user1", "agent1")
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}},
+	}
+	return pod
+}
+
+func waitForPayloads(t *testing.T, querier *fakeLifecycleQuerier, n int) []webhook.Payload {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if payloads := querier.payloads(); len(payloads) >= n {
+			return payloads
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d lifecycle events, got %v", n, queuedTypes(querier.payloads()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMonitor_ReportsAndRestartsCrashLoopingAgent(t *testing.T) {
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user1", Url: "https://example.com/lifecycle"}}}
+	clientset := fake.NewSimpleClientset(createReadyPod("user1", "agent1"))
+	var creates atomic.Int32
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		creates.Add(1)
+		action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).Status = createReadyPod("user1", "agent1").Status
+		return false, nil, nil
+	})
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "")
+	p := NewProcessor(mgr, webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+	reg := prometheus.NewRegistry()
+	p.metrics = metrics.New(reg)
+
+	startMonitor(t, p, MonitorConfig{
+		FailureWindow:  20 * time.Millisecond,
+		AutoRestart:    true,
+		MaxRestarts:    1,
+		RestartBackoff: time.Millisecond,
+	})
+
+	if _, err := clientset.CoreV1().Pods(testNamespace).UpdateStatus(context.Background(), crashLoopingPod(), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := waitForPayloads(t, querier, 1)
+	failed := payloads[0]
+	if failed.EventType != webhook.EventTypeAgentFailed || failed.AgentID != "agent1" {
+		t.Fatalf("expected agent1 reported failed, got %s for %s", failed.EventType, failed.AgentID)
+	}
+	if failed.Lifecycle.Reason != "CrashLoopBackOff" || failed.Lifecycle.PodPhase != string(corev1.PodRunning) {
+		t.Errorf("expected the crash loop reported, got %+v", failed.Lifecycle)
+	}
+
+	// The restart replaces the pod with a ready one
+	deadline := time.Now().Add(5 * time.Second)
+	for creates.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed agent's pod to be recreated")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	want := `
+# HELP forge_agent_failures_detected_total Running agents the health monitor found dead.
+# TYPE forge_agent_failures_detected_total counter
+forge_agent_failures_detected_total 1
+# HELP forge_agent_restarts_total Restarts of dead agents by the health monitor, by outcome.
+# TYPE forge_agent_restarts_total counter
+forge_agent_restarts_total{outcome="success"} 1
+`
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		err := testutil.GatherAndCompare(reg, strings.NewReader(want), "forge_agent_failures_detected_total", "forge_agent_restarts_total")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The restarted agent is ready, so it is not reported again
+	time.Sleep(100 * time.Millisecond)
+	if payloads := querier.payloads(); len(payloads) != 1 {
+		t.Errorf("expected one failure reported, got %v", queuedTypes(payloads))
+	}
+}
+
+func TestMonitor_IgnoresUnreadyAgentThatAnswers(t *testing.T) {
+	svc := &statusAgentService{}
+	mux := http.NewServeMux()
+	path, h := agentv1connect.NewAgentServiceHandler(svc)
+	mux.Handle(path, h)
+	server := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	t.Cleanup(server.Close)
+	_, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	podID := k8s.NewPodID("user1", "agent1")
+	clientset := fake.NewSimpleClientset(createReadyPod("user1", "agent1"), &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: podID.Name(), Namespace: testNamespace},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "grpc", Port: k8s.DefaultAgentPort, NodePort: int32(port)}},
+		},
+	})
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1")
+	querier := &fakeLifecycleQuerier{hooks: []*sqlc.LifecycleWebhook{{ID: 1, UserID: "user1", Url: "https://example.com/lifecycle"}}}
+	p := NewProcessor(mgr, webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+
+	startMonitor(t, p, MonitorConfig{FailureWindow: 10 * time.Millisecond, AutoRestart: true, MaxRestarts: 1})
+
+	// A failing readiness probe leaves the agent answering
+	pod := createReadyPod("user1", "agent1")
+	pod.Status.ContainerStatuses[0].Ready = false
+	if _, err := clientset.CoreV1().Pods(testNamespace).UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for svc.probes.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the unready agent to be probed repeatedly")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if payloads := querier.payloads(); len(payloads) != 0 {
+		t.Errorf("expected no failure reported, got %v", queuedTypes(payloads))
+	}
+}
@@ -2,16 +2,24 @@ package processor
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"time"
 
 	"connectrpc.com/connect"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
 
 	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
 	"github.com/forge/platform/internal/agent"
 	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/sqlc/gen"
 	"github.com/forge/platform/internal/webhook"
 )
 
@@ -22,6 +30,73 @@ type Processor struct {
 	k8m             *k8s.Manager
 	webhookDelivery *webhook.DeliveryService
 	logger          *zap.Logger
+
+	// minProtocolVersion is the oldest agent protocol version the processor talks to
+	minProtocolVersion int32
+
+	// models are the models callers may pick for their agents
+	models []string
+
+	// agentAuthSecret mints the token sent on RPCs to each agent; empty sends none
+	agentAuthSecret string
+
+	// pool holds the connections to agents, shared by their clients
+	pool *agent.ClientPool
+
+	// rpcTimeout bounds each attempt of a unary RPC to an agent, and rpcRetries is how
+	// often idempotent ones are retried (see agent.RetryInterceptor)
+	rpcTimeout time.Duration
+	rpcRetries int
+
+	// execEnabled allows running commands in agent pods (see ExecInAgent)
+	execEnabled bool
+
+	// fileMaxBytes caps the bytes uploaded to or downloaded from an agent's workspace in
+	// one request; 0 means no cap
+	fileMaxBytes int64
+	// fileTransferTimeout bounds an upload or download of an agent's files; 0 means no
+	// limit
+	fileTransferTimeout time.Duration
+
+	// createTimeout bounds how long creating an agent waits for it to be ready; 0 means
+	// no limit
+	createTimeout time.Duration
+
+	// quarantine holds the thresholds at which misbehaving agents are quarantined, and
+	// health the counts they are compared against
+	quarantine QuarantineConfig
+	health     healthTracker
+	now        func() time.Time
+
+	// resume bounds how webhook relays whose agent stream drops are resumed
+	resume ResumeConfig
+
+	// jobs is the background work of accepted requests
+	jobs *jobTracker
+
+	// deletions notifies the streams of agents deleted through the processor
+	deletions deletionHub
+
+	// lifecycle fans the agents' lifecycle events out to SubscribeLifecycle subscribers
+	lifecycle lifecycleHub
+
+	// statuses keeps the agents' latest answers to CachedStatus
+	statuses statusCache
+
+	// messages keeps the messages in flight to each agent, for interrupts to end
+	messages messageRegistry
+
+	// queues lets each agent work on one message at a time
+	queues messageQueues
+
+	// usage keeps the usage of the requests in flight, stored once each ends
+	usage usageRegistry
+
+	// budgets caches each user's budget and spend, which new messages are checked against
+	budgets budgetCache
+
+	// metrics records agent operations and RPC errors; nil records nothing
+	metrics *metrics.Metrics
 }
 
 // NewProcessor creates a new agent processor
@@ -30,29 +105,58 @@ func NewProcessor(k8sManager *k8s.Manager, webhookDelivery *webhook.DeliveryServ
 		k8m:             k8sManager,
 		webhookDelivery: webhookDelivery,
 		logger:          logger,
+
+		minProtocolVersion:  agent.DefaultMinProtocolVersion,
+		createTimeout:       DefaultAgentCreateTimeout,
+		fileMaxBytes:        DefaultAgentFileMaxBytes,
+		fileTransferTimeout: DefaultAgentFileTransferTimeout,
+		health:              healthTracker{agents: make(map[string]*agentHealth)},
+		now:                 time.Now,
+		jobs:                newJobTracker(),
+		pool:                agent.NewClientPool(agent.PoolConfig{}),
+		rpcTimeout:          agent.DefaultRPCTimeout,
+		rpcRetries:          agent.DefaultRPCRetries,
+		queues:              messageQueues{depth: DefaultMessageQueueDepth},
 	}
 }
 
-// ListAgents returns all agent pods belonging to a specific user.
-func (p *Processor) ListAgents(ctx context.Context, userID string) ([]k8s.PodID, error) {
-	podList, err := p.k8m.ListPodsForUser(ctx, userID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list agents for user %s: %w", userID, err)
+// agentClient returns a client of podID's agent at address over the pool's connections,
+// authenticating with the agent's token, bounding and retrying unary RPCs, and counting
+// the attempts that fail
+func (p *Processor) agentClient(podID k8s.PodID, address string) agentv1connect.AgentServiceClient {
+	opts := agent.ClientOptions{Interceptors: []connect.Interceptor{
+		agent.RetryInterceptor(p.rpcTimeout, p.rpcRetries),
+		p.metrics.AgentRPCInterceptor(),
+	}}
+	if p.agentAuthSecret != "" {
+		opts.AuthToken = agent.Token(p.agentAuthSecret, podID.UserID, podID.AgentID)
 	}
+	return p.pool.Get(address, opts)
+}
 
-	podIDs := make([]k8s.PodID, 0, len(podList.Items))
-	for _, pod := range podList.Items {
-		userID := pod.Labels["user-id"]
-		agentID := pod.Labels["agent-id"]
-		if userID != "" && agentID != "" {
-			podIDs = append(podIDs, k8s.PodID{
-				UserID:  userID,
-				AgentID: agentID,
-			})
+// agentAddresses returns the addresses of the agents of podIDs that have one, looked up
+// before their pods are deleted or replaced so that their connections can be invalidated
+// after
+func (p *Processor) agentAddresses(ctx context.Context, podIDs ...k8s.PodID) []string {
+	var addresses []string
+	for _, podID := range podIDs {
+		if address, err := p.k8m.GetPodAddress(ctx, podID); err == nil {
+			addresses = append(addresses, address)
 		}
 	}
+	return addresses
+}
+
+// invalidateConns closes the pooled connections to addresses
+func (p *Processor) invalidateConns(addresses []string) {
+	for _, address := range addresses {
+		p.pool.Invalidate(address)
+	}
+}
 
-	return podIDs, nil
+// ListAgents returns all agent pods belonging to a specific user, ordered by agent ID.
+func (p *Processor) ListAgents(ctx context.Context, userID string) ([]k8s.PodID, error) {
+	return p.ListAgentsFiltered(ctx, userID, AgentFilter{})
 }
 
 // GetAgent returns detailed pod information for a specific agent.
@@ -69,12 +173,12 @@ func (p *Processor) GetAgent(ctx context.Context, userID, agentID string) (*core
 func (p *Processor) GetStatus(ctx context.Context, userID, agentID string) (*agentv1.GetStatusResponse, error) {
 	podID := k8s.NewPodID(userID, agentID)
 
-	address, err := p.k8m.GetPodAddress(ctx, *podID)
+	address, err := p.k8m.GetReadyPodAddress(ctx, *podID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get agent address: %w", err)
 	}
 
-	client := agent.NewClient(address)
+	client := p.agentClient(*podID, address)
 	resp, err := client.GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get agent status: %w", err)
@@ -83,72 +187,532 @@ func (p *Processor) GetStatus(ctx context.Context, userID, agentID string) (*age
 	return resp.Msg, nil
 }
 
+// ErrAgentNotReady is matched by the errors of calls to an agent whose pod is not ready or
+// is terminating, which are *k8s.AgentNotReadyError
+var ErrAgentNotReady = k8s.ErrAgentNotReady
+
+// CatchUp returns up to limit responses the agent sent from fromSeq onwards, oldest first,
+// along with the highest seq it has sent. Agents keep a bounded history, so older responses
+// may be gone. It returns a *k8s.AgentNotReadyError, matching ErrAgentNotReady, if the
+// agent's pod is not ready or is terminating.
+func (p *Processor) CatchUp(ctx context.Context, userID, agentID string, fromSeq uint64, limit int32) (*agentv1.CatchUpResponse, error) {
+	podID := k8s.NewPodID(userID, agentID)
+	address, err := p.k8m.GetReadyPodAddress(ctx, *podID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent address: %w", err)
+	}
+
+	client := p.agentClient(*podID, address)
+	resp, err := client.CatchUp(ctx, connect.NewRequest(&agentv1.CatchUpRequest{
+		FromSeq: fromSeq,
+		Limit:   limit,
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to catch up on agent messages: %w", err)
+	}
+
+	return resp.Msg, nil
+}
+
 // CreateAgent creates a new agent pod and waits for it to be ready.
 func (p *Processor) CreateAgent(ctx context.Context, userID string) (*k8s.PodID, error) {
+	return p.CreateAgentWithImage(ctx, userID, "")
+}
+
+// CreateAgentWithImage creates a new agent pod running image, or the configured agent image
+// if image is empty, and waits for it to be ready.
+func (p *Processor) CreateAgentWithImage(ctx context.Context, userID, image string) (*k8s.PodID, error) {
+	return p.CreateAgentWithOptions(ctx, userID, k8s.PodOptions{Image: image})
+}
+
+// CreateAgentWithOptions creates a new agent pod with opts, e.g. a persistent workspace,
+// and waits for it to be ready. Lifecycle webhooks are told when the agent is created, and
+// then when it is ready or failed.
+func (p *Processor) CreateAgentWithOptions(ctx context.Context, userID string, opts k8s.PodOptions) (*k8s.PodID, error) {
+	podID, start, err := p.createAgentPod(ctx, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.awaitAgentReady(ctx, *podID, start); err != nil {
+		return nil, err
+	}
+	return podID, nil
+}
+
+// CreateAgentAsync creates a new agent pod with opts like CreateAgentWithOptions, but
+// returns once the pod is created. Its readiness is awaited in the background for up to
+// AGENT_CREATE_TIMEOUT, and observed by polling the agent or through the agent.ready and
+// agent.failed lifecycle webhooks. A pod that fails to become ready is deleted.
+func (p *Processor) CreateAgentAsync(ctx context.Context, userID string, opts k8s.PodOptions) (*k8s.PodID, error) {
+	podID, start, err := p.createAgentPod(ctx, userID, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = p.RunJob(ctx, "", userID, podID.AgentID, func(ctx context.Context) {
+		if err := p.awaitAgentReady(ctx, *podID, start); err != nil {
+			p.logger.Warn("agent created in the background failed to become ready",
+				zap.String("agent_id", podID.AgentID),
+				zap.Error(err),
+			)
+		}
+	})
+	if err != nil {
+		p.failAgentStart(ctx, *podID, start, err)
+		return nil, err
+	}
+	return podID, nil
+}
+
+// createAgentPod checks opts and creates the pod of a new agent, returning it and when its
+// creation started
+func (p *Processor) createAgentPod(ctx context.Context, userID string, opts k8s.PodOptions) (*k8s.PodID, time.Time, error) {
+	if err := p.CheckSettings(AgentSettings{Model: opts.Model, PermissionMode: opts.PermissionMode}); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	start := p.now()
 	podID := k8s.NewPodID(userID, generateAgentID())
 
-	if err := p.k8m.CreatePod(ctx, *podID); err != nil {
-		return nil, fmt.Errorf("failed to create agent pod: %w", err)
+	if err := p.k8m.CreatePodWithOptions(ctx, *podID, opts); err != nil {
+		err = fmt.Errorf("failed to create agent pod: %w", err)
+		p.metrics.AgentCreated(p.now().Sub(start), err)
+		return nil, start, err
 	}
+	p.emitLifecycle(ctx, lifecycleEvent{
+		podID:     *podID,
+		eventType: webhook.EventTypeAgentCreated,
+		phase:     corev1.PodPending,
+		reason:    LifecycleReasonCreated,
+		createdAt: start,
+	})
+	return podID, start, nil
+}
+
+// DefaultAgentCreateTimeout is how long creating an agent waits for it to be ready unless
+// AGENT_CREATE_TIMEOUT says otherwise
+const DefaultAgentCreateTimeout = 2 * time.Minute
+
+// CreateTimeout returns how long creating an agent waits for it to be ready, 0 if there
+// is no limit
+func (p *Processor) CreateTimeout() time.Duration {
+	return p.createTimeout
+}
 
-	// Wait for the pod to be ready
-	_, err := p.k8m.WaitForPodReady(ctx, *podID)
+// awaitAgentReady waits for a created agent's pod to be ready, deleting it if it fails to.
+// The wait lasts up to AGENT_CREATE_TIMEOUT whatever ctx's deadline, but ends if ctx is
+// cancelled.
+func (p *Processor) awaitAgentReady(ctx context.Context, podID k8s.PodID, start time.Time) error {
+	waitCtx, cancel := p.readyWaitContext(ctx)
+	defer cancel()
+	pod, err := p.k8m.WaitForPodReady(waitCtx, podID)
 	if err != nil {
-		// Best-effort cleanup - use background context to avoid cancellation issues
-		_ = p.k8m.ClosePod(context.Background(), *podID)
-		return nil, fmt.Errorf("agent pod created but failed to become ready: %w", err)
+		p.failAgentStart(ctx, podID, start, err)
+		return fmt.Errorf("agent pod created but failed to become ready: %w", err)
 	}
+	p.metrics.AgentCreated(p.now().Sub(start), nil)
+	p.emitLifecycle(ctx, lifecycleEvent{
+		podID:     podID,
+		eventType: webhook.EventTypeAgentReady,
+		phase:     pod.Status.Phase,
+		reason:    LifecycleReasonReady,
+		createdAt: start,
+	})
+	return nil
+}
 
-	return podID, nil
+// readyWaitContext returns the context an agent's readiness is awaited with. It keeps
+// ctx's values and cancellation but not its deadline, e.g. the HTTP server's or a job's,
+// and ends after createTimeout with a cause stating it.
+func (p *Processor) readyWaitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	waitCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			cancel(context.Cause(ctx))
+		}
+	})
+	cancelTimeout := context.CancelFunc(func() {})
+	if p.createTimeout > 0 {
+		waitCtx, cancelTimeout = context.WithTimeoutCause(waitCtx, p.createTimeout,
+			fmt.Errorf("agent not ready within AGENT_CREATE_TIMEOUT of %s: %w", p.createTimeout, context.DeadlineExceeded))
+	}
+	return waitCtx, func() {
+		stop()
+		cancelTimeout()
+		cancel(context.Canceled)
+	}
+}
+
+// failAgentStart deletes the pod of an agent that failed to start with err
+func (p *Processor) failAgentStart(ctx context.Context, podID k8s.PodID, start time.Time, err error) {
+	p.metrics.AgentCreated(p.now().Sub(start), err)
+	// Best-effort cleanup - use background context to avoid cancellation issues
+	p.k8m.RecordStartFailure(context.Background(), podID)
+	_ = p.k8m.ClosePod(context.Background(), podID)
+	p.emitLifecycle(ctx, failedEvent(podID, start, err))
 }
 
 // DeleteAgent removes an agent, optionally with graceful shutdown via RPC.
 // If graceful is true, it attempts to send a shutdown RPC to the agent first.
 // The pod is always deleted regardless of whether graceful shutdown succeeds.
+// Open streams to the agent are told first: webhook relays fail with AGENT_DELETED, and
+// SubscribeAgentDeleted subscribers are notified. Lifecycle webhooks are told once the
+// agent is deleted.
 func (p *Processor) DeleteAgent(ctx context.Context, userID, agentID string, graceful bool) error {
 	podID := k8s.NewPodID(userID, agentID)
 
+	// Streams to the agent are ended before it shuts down, so they can tell why
+	p.deletions.publish(*podID)
+
+	reason := LifecycleReasonDeleted
 	if graceful {
-		// Try graceful shutdown, but don't fail if agent is unreachable
-		if address, err := p.k8m.GetPodAddress(ctx, *podID); err == nil {
-			client := agent.NewClient(address)
-			shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
-			// Ignore errors - pod might already be terminating
-			_, _ = client.Shutdown(shutdownCtx, connect.NewRequest(&agentv1.ShutdownRequest{
-				Graceful: true,
-			}))
+		// Try graceful shutdown, but don't fail if agent is unreachable or the pod is
+		// already terminating
+		if err := p.shutdownAgent(ctx, *podID); err == nil {
+			reason = LifecycleReasonShutDown
 		}
 	}
 
-	if err := p.k8m.ClosePod(ctx, *podID); err != nil {
+	addresses := p.agentAddresses(ctx, *podID)
+	err := p.k8m.ClosePod(ctx, *podID)
+	p.invalidateConns(addresses)
+	p.metrics.AgentDeleted(err)
+	if err != nil {
 		return fmt.Errorf("failed to delete agent pod: %w", err)
 	}
+	p.emitLifecycle(ctx, lifecycleEvent{podID: *podID, eventType: webhook.EventTypeAgentDeleted, reason: reason})
+
+	return nil
+}
+
+// shutdownTimeout bounds the graceful shutdown RPC sent to an agent before it is deleted
+const shutdownTimeout = 10 * time.Second
 
+// deleteAllConcurrency bounds the graceful shutdowns DeleteAllAgents sends at once
+const deleteAllConcurrency = 8
+
+// shutdownAgent asks the agent to shut down gracefully
+func (p *Processor) shutdownAgent(ctx context.Context, podID k8s.PodID) error {
+	address, err := p.k8m.GetPodAddress(ctx, podID)
+	if err != nil {
+		return fmt.Errorf("failed to get agent address: %w", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	_, err = p.agentClient(podID, address).Shutdown(shutdownCtx, connect.NewRequest(&agentv1.ShutdownRequest{
+		Graceful: true,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to shut down agent: %w", err)
+	}
 	return nil
 }
 
+// AgentDeletion is the outcome of deleting one agent in DeleteAllAgents
+type AgentDeletion struct {
+	AgentID string
+	// Graceful is true if the agent acknowledged a graceful shutdown before its pod was
+	// deleted, and false if it was only force-deleted
+	Graceful bool
+	// ShutdownErr says why a graceful shutdown requested for the agent failed
+	ShutdownErr error
+}
+
+// DeleteAllAgents removes all of userID's agents, optionally after asking each to shut down
+// gracefully, a few at a time. Their pods are deleted whether or not the agents shut down,
+// and the outcome for each agent is returned, also when the deletion fails.
+func (p *Processor) DeleteAllAgents(ctx context.Context, userID string, graceful bool) ([]AgentDeletion, error) {
+	podIDs, err := p.ListAgents(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	deletions := make([]AgentDeletion, len(podIDs))
+	for i, podID := range podIDs {
+		deletions[i].AgentID = podID.AgentID
+		p.deletions.publish(podID)
+	}
+
+	if graceful {
+		var g errgroup.Group
+		g.SetLimit(deleteAllConcurrency)
+		for i, podID := range podIDs {
+			g.Go(func() error {
+				if err := p.shutdownAgent(ctx, podID); err != nil {
+					deletions[i].ShutdownErr = err
+				} else {
+					deletions[i].Graceful = true
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+	}
+
+	addresses := p.agentAddresses(ctx, podIDs...)
+	err = p.k8m.ClosePodsForUser(ctx, userID)
+	p.invalidateConns(addresses)
+	for range podIDs {
+		p.metrics.AgentDeleted(err)
+	}
+	if err != nil {
+		return deletions, fmt.Errorf("failed to delete agents of user %s: %w", userID, err)
+	}
+	for i, podID := range podIDs {
+		reason := LifecycleReasonDeleted
+		if deletions[i].Graceful {
+			reason = LifecycleReasonShutDown
+		}
+		p.emitLifecycle(ctx, lifecycleEvent{podID: podID, eventType: webhook.EventTypeAgentDeleted, reason: reason})
+	}
+	return deletions, nil
+}
+
 // ConnectToAgent establishes a bidirectional streaming connection to an agent.
 // The caller is responsible for managing the stream lifecycle (closing when done).
+// It returns a *k8s.AgentNotReadyError if the agent's pod is not ready or is terminating.
 func (p *Processor) ConnectToAgent(ctx context.Context, userID, agentID string) (*connect.BidiStreamForClient[agentv1.AgentRequest, agentv1.AgentResponse], error) {
 	podID := k8s.NewPodID(userID, agentID)
 
-	address, err := p.k8m.GetPodAddress(ctx, *podID)
+	address, err := p.k8m.GetReadyPodAddress(ctx, *podID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get agent address: %w", err)
 	}
 
-	client := agent.NewClient(address)
+	if err := p.checkProtocolVersion(ctx, userID, agentID); err != nil {
+		return nil, err
+	}
+
+	client := p.agentClient(*podID, address)
 	stream := client.Connect(ctx)
 
 	return stream, nil
 }
 
+// AgentProtocolVersion returns the protocol version an agent speaks.
+// The version is read from the pod annotation written at the first handshake;
+// if there is none yet, the agent is asked via GetStatus and the annotation is written.
+func (p *Processor) AgentProtocolVersion(ctx context.Context, userID, agentID string) (int32, error) {
+	podID := k8s.NewPodID(userID, agentID)
+	pod, err := p.k8m.GetPod(ctx, *podID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get agent %s for user %s: %w", agentID, userID, err)
+	}
+	if raw, ok := pod.Annotations[k8s.ProtocolVersionAnnotation]; ok {
+		if version, err := strconv.ParseInt(raw, 10, 32); err == nil {
+			return int32(version), nil
+		}
+	}
+
+	status, err := p.GetStatus(ctx, userID, agentID)
+	if err != nil {
+		return 0, err
+	}
+
+	version := status.GetProtocolVersion()
+	if err := p.k8m.AnnotatePod(ctx, *podID, map[string]string{
+		k8s.ProtocolVersionAnnotation: strconv.Itoa(int(version)),
+	}); err != nil {
+		p.logger.Warn("failed to record agent protocol version",
+			zap.Error(err),
+			zap.String("agent_id", agentID),
+		)
+	}
+
+	return version, nil
+}
+
+// RequireFeature returns an *agent.UnsupportedError if the agent's protocol version
+// does not support feature.
+func (p *Processor) RequireFeature(ctx context.Context, userID, agentID string, feature agent.Feature) error {
+	version, err := p.AgentProtocolVersion(ctx, userID, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to determine agent protocol version: %w", err)
+	}
+	return agent.CheckFeature(version, feature)
+}
+
+// checkProtocolVersion refuses agents older than the minimum protocol version.
+// Agents whose version can't be determined are let through; the RPC that follows
+// surfaces the underlying problem.
+func (p *Processor) checkProtocolVersion(ctx context.Context, userID, agentID string) error {
+	version, err := p.AgentProtocolVersion(ctx, userID, agentID)
+	if err != nil {
+		p.logger.Debug("could not determine agent protocol version",
+			zap.Error(err),
+			zap.String("agent_id", agentID),
+		)
+		return nil
+	}
+	return agent.CheckCompatible(version, p.minProtocolVersion)
+}
+
+// ValidateWebhookURL checks that the platform may deliver webhooks to rawURL.
+// Errors wrap webhook.ErrURLRejected when the URL is refused.
+func (p *Processor) ValidateWebhookURL(ctx context.Context, rawURL string) error {
+	return p.webhookDelivery.ValidateURL(ctx, rawURL)
+}
+
+// CheckWebhookProbe reports a recent failed probe of each endpoint of webhookCfg as a
+// warning, or as an error wrapping webhook.ErrProbeFailed when probes are strict. Endpoints
+// not probed recently are probed in the background.
+func (p *Processor) CheckWebhookProbe(webhookCfg webhook.Config) ([]string, error) {
+	var warnings []string
+	for _, endpoint := range webhookCfg.Endpoints() {
+		warning, err := p.webhookDelivery.CheckProbe(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings, nil
+}
+
+// GetRequest returns the delivery record tracking a message or interrupt request a user sent.
+// It returns webhook.ErrDeliveryNotFound if the request was never recorded or another user
+// sent it.
+func (p *Processor) GetRequest(ctx context.Context, userID, requestID string) (*sqlc.WebhookDelivery, error) {
+	delivery, err := p.webhookDelivery.GetDelivery(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if !p.ownsRecord(ctx, userID, delivery.UserID, delivery.AgentID) {
+		return nil, webhook.ErrDeliveryNotFound
+	}
+	return delivery, nil
+}
+
+// ownsRecord reports whether a request or batch recorded with owner belongs to userID.
+// Records made before owners were stored belong to whoever owns their agent.
+func (p *Processor) ownsRecord(ctx context.Context, userID string, owner sql.NullString, agentID string) bool {
+	if owner.Valid {
+		return owner.String == userID
+	}
+	_, err := p.k8m.GetPod(ctx, *k8s.NewPodID(userID, agentID))
+	return err == nil
+}
+
+// GetRequestEndpoints returns the delivery record of each webhook endpoint of a request,
+// in the order they were given.
+func (p *Processor) GetRequestEndpoints(ctx context.Context, requestID string) ([]*sqlc.WebhookDelivery, error) {
+	return p.webhookDelivery.ListDeliveryEndpoints(ctx, requestID)
+}
+
+// RequestAttemptSummary returns how many webhook delivery attempts were made for a request
+// and the HTTP status of the latest one, 0 if it got no response.
+func (p *Processor) RequestAttemptSummary(ctx context.Context, requestID string) (count, lastStatus int32, err error) {
+	return p.webhookDelivery.AttemptSummary(ctx, requestID)
+}
+
+// ListRequests returns the most recent requests a user sent to an agent, newest first.
+func (p *Processor) ListRequests(ctx context.Context, userID, agentID string, limit int32) ([]*sqlc.WebhookDelivery, error) {
+	deliveries, err := p.webhookDelivery.ListDeliveriesForAgent(ctx, userID, agentID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Records made before owners were stored are listed for every user
+	owned := deliveries[:0]
+	for _, d := range deliveries {
+		if p.ownsRecord(ctx, userID, d.UserID, d.AgentID) {
+			owned = append(owned, d)
+		}
+	}
+	return owned, nil
+}
+
+// PrepareRedelivery loads the stored events from fromSeq onwards of a request a user sent
+// and resolves the webhook config to replay them to (see webhook.RedeliveryConfig). The URL
+// they go to, original or overridden, must pass the webhook URL policy. Events the consumer
+// acknowledged with a receipt are left out unless force is set; the number left out is
+// returned as skipped. Requests another user sent are reported as webhook.ErrDeliveryNotFound.
+func (p *Processor) PrepareRedelivery(
+	ctx context.Context,
+	userID, requestID string,
+	fromSeq int64,
+	override webhook.Config,
+	force bool,
+) (webhookCfg webhook.Config, payloads []webhook.Payload, skipped int, err error) {
+	delivery, err := p.GetRequest(ctx, userID, requestID)
+	if err != nil {
+		return webhook.Config{}, nil, 0, err
+	}
+
+	webhookCfg, err = webhook.RedeliveryConfig(delivery, override)
+	if err != nil {
+		return webhook.Config{}, nil, 0, err
+	}
+	if err := p.webhookDelivery.ValidateURL(ctx, webhookCfg.URL); err != nil {
+		return webhook.Config{}, nil, 0, err
+	}
+
+	payloads, err = p.webhookDelivery.StoredEvents(ctx, requestID, fromSeq)
+	if err != nil {
+		return webhook.Config{}, nil, 0, err
+	}
+	if force {
+		return webhookCfg, payloads, 0, nil
+	}
+
+	acked, err := p.webhookDelivery.AcknowledgedRanges(ctx, requestID)
+	if err != nil {
+		return webhook.Config{}, nil, 0, err
+	}
+	kept := webhook.SkipAcknowledged(payloads, acked)
+	return webhookCfg, kept, len(payloads) - len(kept), nil
+}
+
+// RecordReceipt stores seq ranges the consumer of a request acknowledged processing and
+// returns all of the request's acknowledged ranges, merged. Ranges must lie within the
+// seqs delivered so far. Requests another user sent are reported as
+// webhook.ErrDeliveryNotFound.
+func (p *Processor) RecordReceipt(ctx context.Context, userID, requestID string, ranges []webhook.SeqRange) ([]webhook.SeqRange, error) {
+	delivery, err := p.GetRequest(ctx, userID, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := webhook.ValidateReceipt(ranges, uint64(max(delivery.Seq, 0))); err != nil {
+		return nil, err
+	}
+	return p.webhookDelivery.RecordReceipt(ctx, requestID, ranges)
+}
+
+// AcknowledgedRanges returns the seq ranges the consumer of a request acknowledged, merged
+func (p *Processor) AcknowledgedRanges(ctx context.Context, requestID string) ([]webhook.SeqRange, error) {
+	return p.webhookDelivery.AcknowledgedRanges(ctx, requestID)
+}
+
+// RedeliverEvents re-sends previously stored payloads in order.
+func (p *Processor) RedeliverEvents(ctx context.Context, requestID string, webhookCfg webhook.Config, payloads []webhook.Payload) error {
+	delivered, err := p.webhookDelivery.ReplayEvents(ctx, webhookCfg, payloads)
+	if err != nil {
+		p.logger.Error("webhook redelivery failed",
+			zap.Error(err),
+			zap.String("request_id", requestID),
+			zap.Int("delivered", delivered),
+			zap.Int("total", len(payloads)),
+		)
+		return err
+	}
+
+	p.logger.Info("webhook redelivery completed",
+		zap.String("request_id", requestID),
+		zap.Int("delivered", delivered),
+	)
+	return nil
+}
+
 func generateAgentID() string {
 	return fmt.Sprintf("agent-%d", time.Now().UnixNano())
 }
 
-// SendMessageWithWebhook sends a message to an agent and delivers responses via webhook
+// SendMessageWithWebhook sends a message to an agent and delivers responses via webhook.
+// If the agent relocates before responding, the message is sent once more to its new address.
+// An interrupt of the message (see InterruptWithWebhook) ends its stream and delivery.
+// The message waits for its turn on the agent first (see QueueMessage). A request ID that
+// already has a delivery record is not sent again.
 func (p *Processor) SendMessageWithWebhook(ctx context.Context, userID, agentID, requestID, content string, webhookCfg webhook.Config) error {
 	p.logger.Info("sending message to agent",
 		zap.String("agent_id", agentID),
@@ -156,22 +720,206 @@ func (p *Processor) SendMessageWithWebhook(ctx context.Context, userID, agentID,
 	)
 
 	// Create webhook delivery record
-	if err := p.webhookDelivery.CreateDeliveryRecord(ctx, requestID, agentID, webhookCfg); err != nil {
+	if err := p.webhookDelivery.CreateDeliveryRecord(ctx, requestID, userID, agentID, webhookCfg); err != nil {
+		if errors.Is(err, webhook.ErrDuplicateRequest) {
+			// A replay of a request already sent; its consumer gets the first stream's events
+			p.logger.Info("request already sent, not sending it again", zap.String("request_id", requestID))
+			p.DequeueMessage(userID, agentID, requestID)
+			return nil
+		}
 		p.logger.Error("failed to create delivery record", zap.Error(err))
 		// Continue anyway - we can still deliver webhooks without DB tracking
 	}
 
-	// Connect to agent
-	stream, err := p.ConnectToAgent(ctx, userID, agentID)
+	podID := *k8s.NewPodID(userID, agentID)
+	ctx, untrack := p.messages.track(ctx, podID, requestID)
+	defer untrack()
+
+	release, err := p.awaitTurn(ctx, podID, requestID)
 	if err != nil {
-		// Send error webhook
-		errPayload := webhook.ErrorToPayload(agentID, requestID, 0, "AGENT_UNREACHABLE", err.Error(), false)
-		p.webhookDelivery.DeliverAsync(webhookCfg, errPayload)
-		return fmt.Errorf("failed to connect to agent: %w", err)
+		// Cancelled while queued behind another message
+		if interrupted(ctx) {
+			p.deliverInterrupted(ctx, agentID, requestID, p.webhookDelivery.NewRequestQueue(requestID, webhookCfg), nil, nil)
+			return nil
+		}
+		errCode, recoverable := cancelErrorCode(ctx)
+		p.deliverErrorAsync(webhookCfg, webhook.ErrorToPayload(agentID, requestID, 0, errCode, context.Cause(ctx).Error(), recoverable))
+		_ = p.webhookDelivery.MarkDeliveryFailed(context.WithoutCancel(ctx), requestID)
+		return err
 	}
+	defer release()
 
-	// Send the message request
-	req := &agentv1.AgentRequest{
+	for attempt := 1; ; attempt++ {
+		stream, errCode, err := p.openRequestStream(ctx, userID, agentID, newSendMessageRequest(requestID, content))
+		if err != nil {
+			// Send error webhook
+			recoverable := false
+			if ctx.Err() != nil {
+				errCode, recoverable = cancelErrorCode(ctx)
+			}
+			errPayload := webhook.ErrorToPayload(agentID, requestID, 0, errCode, err.Error(), recoverable)
+			p.deliverErrorAsync(webhookCfg, errPayload)
+			_ = p.webhookDelivery.MarkDeliveryFailed(context.WithoutCancel(ctx), requestID)
+			return err
+		}
+		stream.resendable = attempt == 1
+
+		// Stream responses to webhook until completion
+		err = p.streamToWebhook(ctx, stream, userID, agentID, requestID, webhookCfg)
+		if !p.resendAfterRelocation(err, agentID, requestID) {
+			return err
+		}
+	}
+}
+
+// StreamMessage sends a message to an agent and passes each converted payload to emit
+// in seq order, without involving webhooks. The last payload emitted is always final:
+// the agent's own complete/error payload, or a synthesized one if the stream ends early.
+// Artifacts the agent produces are stored and listed on the final payload.
+// Cancelling ctx (e.g. on client disconnect) cancels the agent stream. An interrupt of the
+// message (see InterruptWithWebhook) ends it with an agent.result with status "interrupted".
+// The message waits for its turn on the agent first (see QueueMessage).
+// If includeThinking is false, model reasoning is stripped before emitting.
+// Malformed events are dropped, and if the stream trips the agent's quarantine the
+// request fails with AGENT_QUARANTINED.
+// If the agent relocates before responding, the message is sent once more to its new address.
+func (p *Processor) StreamMessage(ctx context.Context, userID, agentID, requestID, content string, includeThinking bool, emit func(webhook.Payload) error) error {
+	p.logger.Info("streaming message to agent",
+		zap.String("agent_id", agentID),
+		zap.String("request_id", requestID),
+	)
+
+	podID := *k8s.NewPodID(userID, agentID)
+	ctx, untrack := p.messages.track(ctx, podID, requestID)
+	defer untrack()
+
+	release, err := p.awaitTurn(ctx, podID, requestID)
+	if err != nil {
+		// Cancelled while queued behind another message
+		if interrupted(ctx) {
+			return emit(webhook.ResultToPayload(agentID, requestID, 0, webhook.ResultStatusInterrupted))
+		}
+		return err
+	}
+	defer release()
+
+	for attempt := 1; ; attempt++ {
+		err := p.streamMessageOnce(ctx, userID, agentID, requestID, content, includeThinking, attempt == 1, emit)
+		if !p.resendAfterRelocation(err, agentID, requestID) {
+			return err
+		}
+	}
+}
+
+// streamMessageOnce sends a message for StreamMessage over a new connection. If resendable
+// is set and the agent relocates before responding, it emits nothing and returns
+// errResendAfterRelocation.
+func (p *Processor) streamMessageOnce(
+	ctx context.Context,
+	userID, agentID, requestID, content string,
+	includeThinking, resendable bool,
+	emit func(webhook.Payload) error,
+) error {
+	stream, errCode, err := p.openRequestStream(ctx, userID, agentID, newSendMessageRequest(requestID, content))
+	if err != nil {
+		if emitErr := emit(webhook.ErrorToPayload(agentID, requestID, 0, errCode, err.Error(), false)); emitErr != nil {
+			return emitErr
+		}
+		return err
+	}
+	defer stream.close()
+
+	usage, finishUsage := p.trackUsage(ctx, userID, agentID, requestID)
+	defer finishUsage()
+
+	responded := false
+	var lastSeq uint64
+	var artifacts []webhook.Artifact
+	for {
+		resp, err := stream.Receive()
+		if err != nil {
+			if interrupted(ctx) {
+				result := webhook.ResultToPayload(agentID, requestID, lastSeq, webhook.ResultStatusInterrupted)
+				result.Artifacts = artifacts
+				return emit(result)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				p.trackStreamEnd(ctx, userID, agentID, false)
+				complete := webhook.CompleteToPayload(agentID, requestID, lastSeq, true)
+				complete.Artifacts = artifacts
+				return emit(complete)
+			}
+			if delErr := stream.deleted(); delErr != nil {
+				if emitErr := emit(webhook.ErrorToPayload(agentID, requestID, lastSeq, ErrorCodeAgentDeleted, delErr.Error(), false)); emitErr != nil {
+					return emitErr
+				}
+				return delErr
+			}
+			if relocErr := stream.relocated(); relocErr != nil {
+				if resendable && !responded {
+					return fmt.Errorf("%w: %w", errResendAfterRelocation, relocErr)
+				}
+				if emitErr := emit(webhook.ErrorToPayload(agentID, requestID, lastSeq, ErrorCodeAgentRelocated, relocErr.Error(), true)); emitErr != nil {
+					return emitErr
+				}
+				return relocErr
+			}
+			p.trackStreamEnd(ctx, userID, agentID, true)
+			errCode, recoverable := StreamErrorCode(err)
+			if emitErr := emit(webhook.ErrorToPayload(agentID, requestID, lastSeq, errCode, err.Error(), recoverable)); emitErr != nil {
+				return emitErr
+			}
+			return fmt.Errorf("stream receive error: %w", err)
+		}
+
+		responded = true
+		lastSeq = resp.GetSeq()
+		if artifact := resp.GetArtifact(); artifact != nil {
+			if ref, ok := p.saveArtifact(ctx, userID, agentID, requestID, artifact); ok {
+				artifacts = append(artifacts, ref)
+			}
+			continue
+		}
+		malformed := malformedEvent(resp)
+		if q := p.trackResponse(ctx, userID, agentID, resp, malformed != ""); q != nil {
+			err := fmt.Errorf("%w: %s", ErrAgentQuarantined, q)
+			if emitErr := emit(webhook.ErrorToPayload(agentID, requestID, lastSeq, ErrorCodeAgentQuarantined, err.Error(), false)); emitErr != nil {
+				return emitErr
+			}
+			return err
+		}
+		if malformed != "" {
+			p.dropMalformedEvent(requestID, resp, malformed)
+			continue
+		}
+		payload := webhook.AgentResponseToPayload(resp, agentID, requestID)
+		if payload.IsFinal {
+			payload.Artifacts = artifacts
+			p.trackStreamEnd(ctx, userID, agentID, false)
+		}
+		p.recordMessage(ctx, userID, payload)
+		usage.Observe(payload)
+		if !includeThinking {
+			var keep bool
+			if payload, keep = webhook.StripThinking(payload); !keep {
+				continue
+			}
+		}
+		if err := emit(payload); err != nil {
+			return err
+		}
+		if payload.IsFinal {
+			return nil
+		}
+	}
+}
+
+// newSendMessageRequest builds the AgentRequest for a user message
+func newSendMessageRequest(requestID, content string) *agentv1.AgentRequest {
+	return &agentv1.AgentRequest{
 		RequestId: requestID,
 		Command: &agentv1.AgentRequest_SendMessage{
 			SendMessage: &agentv1.SendMessageRequest{
@@ -179,12 +927,49 @@ func (p *Processor) SendMessageWithWebhook(ctx context.Context, userID, agentID,
 			},
 		},
 	}
+}
+
+// connectErrorCode returns the error code reported to consumers when ConnectToAgent fails
+func connectErrorCode(err error) string {
+	if agent.IsUnsupported(err) {
+		return agent.ErrorCodeUnsupportedByAgent
+	}
+	return "AGENT_UNREACHABLE"
+}
+
+// StreamErrorCode returns the error code reported to consumers when an agent's stream
+// fails for a reason other than the request's own cancellation, and whether sending the
+// request again may succeed
+func StreamErrorCode(err error) (code string, recoverable bool) {
+	switch connect.CodeOf(err) {
+	case connect.CodeUnavailable:
+		// The connection to the agent was lost, e.g. its pod restarted
+		return ErrorCodeAgentUnavailable, true
+	case connect.CodeCanceled:
+		// The agent cancelled the stream, e.g. while shutting down
+		return ErrorCodeStreamCancelled, true
+	default:
+		return ErrorCodeStreamError, false
+	}
+}
+
+// openRequestStream connects to the agent, sends a single request, and closes the
+// request side. On failure it returns the error code to report to consumers.
+// The caller must close the returned stream.
+func (p *Processor) openRequestStream(
+	ctx context.Context,
+	userID, agentID string,
+	req *agentv1.AgentRequest,
+) (*agentStream, string, error) {
+	stream, err := p.connectPinned(ctx, userID, agentID)
+	if err != nil {
+		return nil, connectErrorCode(err), fmt.Errorf("failed to connect to agent: %w", err)
+	}
 
 	if err := stream.Send(req); err != nil {
 		stream.CloseRequest()
-		errPayload := webhook.ErrorToPayload(agentID, requestID, 0, "SEND_FAILED", err.Error(), false)
-		p.webhookDelivery.DeliverAsync(webhookCfg, errPayload)
-		return fmt.Errorf("failed to send message request: %w", err)
+		stream.close()
+		return nil, "SEND_FAILED", fmt.Errorf("failed to send request: %w", err)
 	}
 
 	// Close the request side immediately - we only send one request per connection.
@@ -194,27 +979,17 @@ func (p *Processor) SendMessageWithWebhook(ctx context.Context, userID, agentID,
 		p.logger.Warn("failed to close request stream", zap.Error(err))
 	}
 
-	// Stream responses to webhook until completion
-	return p.streamToWebhook(ctx, stream, agentID, requestID, webhookCfg)
+	return stream, "", nil
 }
 
-// InterruptWithWebhook interrupts an agent and delivers response via webhook
-func (p *Processor) InterruptWithWebhook(ctx context.Context, userID, agentID, requestID string, webhookCfg webhook.Config) error {
-	p.logger.Info("interrupting agent",
-		zap.String("agent_id", agentID),
-		zap.String("request_id", requestID),
-	)
-
-	// Create webhook delivery record
-	if err := p.webhookDelivery.CreateDeliveryRecord(ctx, requestID, agentID, webhookCfg); err != nil {
-		p.logger.Error("failed to create delivery record", zap.Error(err))
-	}
-
+// interruptOnNewStream sends an interrupt to an agent on a stream of its own and delivers
+// the response via webhook
+func (p *Processor) interruptOnNewStream(ctx context.Context, userID, agentID, requestID string, webhookCfg webhook.Config) error {
 	// Connect to agent
-	stream, err := p.ConnectToAgent(ctx, userID, agentID)
+	stream, err := p.connectPinned(ctx, userID, agentID)
 	if err != nil {
-		errPayload := webhook.ErrorToPayload(agentID, requestID, 0, "AGENT_UNREACHABLE", err.Error(), false)
-		p.webhookDelivery.DeliverAsync(webhookCfg, errPayload)
+		errPayload := webhook.ErrorToPayload(agentID, requestID, 0, connectErrorCode(err), err.Error(), false)
+		p.deliverErrorAsync(webhookCfg, errPayload)
 		return fmt.Errorf("failed to connect to agent: %w", err)
 	}
 
@@ -228,8 +1003,9 @@ func (p *Processor) InterruptWithWebhook(ctx context.Context, userID, agentID, r
 
 	if err := stream.Send(req); err != nil {
 		stream.CloseRequest()
+		stream.close()
 		errPayload := webhook.ErrorToPayload(agentID, requestID, 0, "SEND_FAILED", err.Error(), false)
-		p.webhookDelivery.DeliverAsync(webhookCfg, errPayload)
+		p.deliverErrorAsync(webhookCfg, errPayload)
 		return fmt.Errorf("failed to send interrupt request: %w", err)
 	}
 
@@ -239,35 +1015,152 @@ func (p *Processor) InterruptWithWebhook(ctx context.Context, userID, agentID, r
 	}
 
 	// Stream responses to webhook until completion
-	return p.streamToWebhook(ctx, stream, agentID, requestID, webhookCfg)
+	return p.streamToWebhook(ctx, stream, userID, agentID, requestID, webhookCfg)
+}
+
+// deliverErrorAsync queues an error payload for async delivery, logging if it is dropped
+func (p *Processor) deliverErrorAsync(webhookCfg webhook.Config, payload webhook.Payload) {
+	if err := p.webhookDelivery.DeliverAsync(webhookCfg, payload); err != nil {
+		p.logger.Error("failed to queue error webhook",
+			zap.Error(err),
+			zap.String("request_id", payload.RequestID),
+		)
+	}
 }
 
-// streamToWebhook reads from the agent gRPC stream and delivers events to the webhook.
+// streamToWebhook reads from the agent gRPC stream and queues events for webhook delivery,
+// closing the stream once done. If the agent closes the stream without a final message, a
+// successful agent.complete listing the request's artifacts is sent in its place.
 // The platform acts as a "dumb pipe" - it does not parse the OpenCode event JSON,
 // just forwards it to the webhook consumer.
 func (p *Processor) streamToWebhook(
 	ctx context.Context,
-	stream *connect.BidiStreamForClient[agentv1.AgentRequest, agentv1.AgentResponse],
-	agentID, requestID string,
+	stream *agentStream,
+	userID, agentID, requestID string,
 	webhookCfg webhook.Config,
 ) error {
+	defer stream.close()
+
+	queue := p.webhookDelivery.NewRequestQueue(requestID, webhookCfg)
+	final, err := p.relayToWebhook(ctx, stream, userID, agentID, requestID, queue, nil)
+	if err != nil || final != nil {
+		return err
+	}
+
+	// The agent closed the stream without a final message
+	complete := webhook.CompleteToPayload(agentID, requestID, queue.NextSeq(), true)
+	if complete.Artifacts, err = p.webhookDelivery.ListArtifacts(ctx, requestID, userID); err != nil {
+		p.logger.Warn("failed to list artifacts for synthesized completion",
+			zap.Error(err),
+			zap.String("request_id", requestID),
+		)
+	}
+	if err := queue.Send(ctx, complete); err != nil {
+		p.logger.Error("failed to deliver completion webhook", zap.Error(err))
+	}
+	_ = p.webhookDelivery.MarkDeliveryCompleted(ctx, requestID)
+	return nil
+}
+
+// relayToWebhook queues one request's events from the stream for webhook delivery until
+// its final message, which it returns. It returns nil and no error if the stream ends
+// before a final message arrives, leaving the caller to end the request. If set, annotate
+// is applied to every payload.
+// Artifacts are stored instead of relayed, and listed on the final payload. Payloads
+// the queue's event type filter excludes are not relayed, but their seq is recorded.
+// Every payload goes to each endpoint of the queue's webhook config; a failing endpoint
+// only stops the relay if the payload could reach none of them.
+// If the agent relocates, the request fails with AGENT_RELOCATED, unless nothing has been
+// received yet and the stream is resendable: then errResendAfterRelocation is returned
+// and nothing is reported. Malformed events are dropped, and if the stream trips the
+// agent's quarantine the request fails with AGENT_QUARANTINED. If the stream drops, e.g.
+// because the connection to the agent was lost, it is resumed through the agent's CatchUp
+// RPC within the processor's ResumeConfig (see resumeStream); if it cannot be, the request
+// fails with the error the stream was lost with. If the request is interrupted it ends
+// with an agent.result with status "interrupted" (see InterruptWithWebhook), which is
+// returned as its final payload. If ctx is otherwise cancelled the
+// request fails with REQUEST_CANCELLED, REQUEST_TIMEOUT or PLATFORM_SHUTDOWN, depending on
+// why it was cancelled (see RunJob). Errors ending the request are sent through the
+// queue, so they are delivered after every event relayed before them.
+func (p *Processor) relayToWebhook(
+	ctx context.Context,
+	stream *agentStream,
+	userID, agentID, requestID string,
+	queue *webhook.RequestQueue,
+	annotate func(*webhook.Payload),
+) (*webhook.Payload, error) {
+	webhookCfg := queue.Config()
+
+	// fail reports the request's failure to the consumer. It runs even if ctx was
+	// cancelled, so the request still ends with a final payload.
+	fail := func(errCode string, err error, recoverable bool) {
+		ctx := context.WithoutCancel(ctx)
+		errPayload := webhook.ErrorToPayload(agentID, requestID, queue.NextSeq(), errCode, err.Error(), recoverable)
+		if annotate != nil {
+			annotate(&errPayload)
+		}
+		if deliveryErr := queue.Send(ctx, errPayload); deliveryErr != nil {
+			p.logger.Error("failed to deliver error webhook", zap.Error(deliveryErr))
+		}
+		_ = p.webhookDelivery.MarkDeliveryFailed(ctx, requestID)
+		p.k8m.RecordRequestError(ctx, *k8s.NewPodID(userID, agentID))
+	}
+
+	usage, finishUsage := p.trackUsage(ctx, userID, agentID, requestID)
+	defer finishUsage()
+
+	responded := false
+	var lastSeq uint64
+	var resume streamResume
+	var artifacts []webhook.Artifact
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			if interrupted(ctx) {
+				return p.deliverInterrupted(ctx, agentID, requestID, queue, artifacts, annotate), nil
+			}
+			errCode, recoverable := cancelErrorCode(ctx)
+			fail(errCode, context.Cause(ctx), recoverable)
+			return nil, ctx.Err()
 		default:
 		}
 
 		resp, err := stream.Receive()
 		if err != nil {
-			// Check if it's a normal EOF (stream completed)
-			if err.Error() == "EOF" {
+			// The agent closed the stream; connect wraps io.EOF in its errors
+			if errors.Is(err, io.EOF) {
 				p.logger.Debug("stream completed",
 					zap.String("request_id", requestID),
 				)
-				// Mark delivery as completed
-				_ = p.webhookDelivery.MarkDeliveryCompleted(ctx, requestID)
-				return nil
+				p.trackStreamEnd(ctx, userID, agentID, false)
+				return nil, nil
+			}
+
+			var errCode string
+			var recoverable bool
+			if relocErr := stream.relocated(); relocErr != nil {
+				if stream.resendable && !responded {
+					return nil, fmt.Errorf("%w: %w", errResendAfterRelocation, relocErr)
+				}
+				errCode, recoverable, err = ErrorCodeAgentRelocated, true, relocErr
+			} else if delErr := stream.deleted(); delErr != nil {
+				errCode, recoverable, err = ErrorCodeAgentDeleted, false, delErr
+			} else if interrupted(ctx) {
+				return p.deliverInterrupted(ctx, agentID, requestID, queue, artifacts, annotate), nil
+			} else if ctx.Err() != nil {
+				errCode, recoverable = cancelErrorCode(ctx)
+				err = context.Cause(ctx)
+			} else if resumed := p.resumeStream(ctx, &resume, stream, err, userID, agentID, requestID, lastSeq+1); resumed != nil {
+				stream.close()
+				stream = resumed
+				defer resumed.close()
+				continue
+			} else {
+				if resume.lost != nil && resume.lost != err {
+					err = fmt.Errorf("%w; resuming the stream failed: %w", resume.lost, err)
+				}
+				errCode, recoverable = StreamErrorCode(err)
+				p.trackStreamEnd(ctx, userID, agentID, true)
 			}
 
 			p.logger.Error("stream receive error",
@@ -275,30 +1168,83 @@ func (p *Processor) streamToWebhook(
 				zap.String("request_id", requestID),
 			)
 
-			// Send error webhook
-			errPayload := webhook.ErrorToPayload(agentID, requestID, 0, "STREAM_ERROR", err.Error(), false)
-			if deliveryErr := p.webhookDelivery.Deliver(ctx, webhookCfg, errPayload); deliveryErr != nil {
-				p.logger.Error("failed to deliver error webhook", zap.Error(deliveryErr))
+			fail(errCode, err, recoverable)
+			return nil, fmt.Errorf("stream receive error: %w", err)
+		}
+		responded = true
+		lastSeq = max(lastSeq, resp.GetSeq())
+
+		if artifact := resp.GetArtifact(); artifact != nil {
+			if ref, ok := p.saveArtifact(ctx, userID, agentID, requestID, artifact); ok {
+				artifacts = append(artifacts, ref)
 			}
+			continue
+		}
 
-			_ = p.webhookDelivery.MarkDeliveryFailed(ctx, requestID)
-			return fmt.Errorf("stream receive error: %w", err)
+		if err := p.webhookDelivery.ArchiveResponse(ctx, requestID, resp); err != nil {
+			p.logger.Warn("failed to archive agent response",
+				zap.Error(err),
+				zap.String("request_id", requestID),
+				zap.Uint64("seq", resp.GetSeq()),
+			)
+		}
+
+		// Agents flooding or garbling their streams are quarantined; malformed events are dropped
+		malformed := malformedEvent(resp)
+		if q := p.trackResponse(ctx, userID, agentID, resp, malformed != ""); q != nil {
+			err := fmt.Errorf("%w: %s", ErrAgentQuarantined, q)
+			fail(ErrorCodeAgentQuarantined, err, false)
+			return nil, err
+		}
+		if malformed != "" {
+			p.dropMalformedEvent(requestID, resp, malformed)
+			continue
 		}
 
 		// Convert response to webhook payload (pass-through)
 		payload := webhook.AgentResponseToPayload(resp, agentID, requestID)
+		if payload.IsFinal {
+			payload.Artifacts = artifacts
+			p.trackStreamEnd(ctx, userID, agentID, false)
+		}
+		p.recordMessage(ctx, userID, payload)
+		usage.Observe(payload)
+		if annotate != nil {
+			annotate(&payload)
+		}
 
-		// Update delivery tracking
-		_ = p.webhookDelivery.UpdateDeliverySeq(ctx, requestID, int64(resp.GetSeq()), payload.EventType)
+		// Drop reasoning content if the consumer opted out of it
+		if webhookCfg.OmitThinking {
+			var keep bool
+			if payload, keep = webhook.StripThinking(payload); !keep {
+				continue
+			}
+		}
+
+		// Skip event types the consumer filtered out, still recording the seq they reached
+		if !webhookCfg.Delivers(payload) {
+			queue.Skip(payload.Seq)
+			if err := p.webhookDelivery.UpdateDeliverySeq(ctx, requestID, int64(resp.GetSeq()), payload.EventType); err != nil {
+				p.logger.Warn("failed to record seq of filtered event",
+					zap.Error(err),
+					zap.String("request_id", requestID),
+					zap.Uint64("seq", resp.GetSeq()),
+				)
+			}
+			continue
+		}
 
-		// Deliver to webhook
-		if err := p.webhookDelivery.Deliver(ctx, webhookCfg, payload); err != nil {
-			p.logger.Error("failed to deliver webhook",
+		// Queue for delivery; the outbox workers send it with retries
+		if err := queue.Send(ctx, payload); err != nil {
+			// Send only fails when no endpoint received the event, and it is not
+			// queued for retry, so there is no one left to relay the stream to
+			p.logger.Error("failed to deliver webhook to any endpoint",
 				zap.Error(err),
 				zap.String("request_id", requestID),
 				zap.Uint64("seq", resp.GetSeq()),
 			)
-			// Continue processing - don't fail the whole stream on delivery failure
+			_ = p.webhookDelivery.MarkDeliveryFailed(ctx, requestID)
+			return nil, fmt.Errorf("webhook delivery failed: %w", err)
 		}
 
 		// Check if this is the final message
@@ -307,7 +1253,7 @@ func (p *Processor) streamToWebhook(
 				zap.String("request_id", requestID),
 			)
 			_ = p.webhookDelivery.MarkDeliveryCompleted(ctx, requestID)
-			return nil
+			return &payload, nil
 		}
 	}
 }
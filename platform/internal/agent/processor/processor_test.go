@@ -2,13 +2,28 @@ package processor
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
@@ -16,7 +31,12 @@ import (
 
 	agentv1 "github.com/forge/platform/gen/agent/v1"
 	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/agent"
+	"github.com/forge/platform/internal/config"
 	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
 )
 
 const testNamespace = "test-ns"
@@ -34,6 +54,30 @@ func createTestProcessor(t *testing.T, mgr *k8s.Manager) *Processor {
 	return NewProcessor(mgr, nil, zap.NewNop())
 }
 
+// createAgentProcessor creates a processor reaching a mock agent served by svc for
+// user1/agent1, along with the fake clientset holding its pod and NodePort service
+func createAgentProcessor(t *testing.T, svc agentv1connect.AgentServiceHandler) (*Processor, *fake.Clientset) {
+	t.Helper()
+	mux := http.NewServeMux()
+	path, h := agentv1connect.NewAgentServiceHandler(svc)
+	mux.Handle(path, h)
+	server := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	t.Cleanup(server.Close)
+	_, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	podID := k8s.NewPodID("user1", "agent1")
+	clientset := fake.NewSimpleClientset(createReadyPod("user1", "agent1"), &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: podID.Name(), Namespace: testNamespace},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "grpc", Port: k8s.DefaultAgentPort, NodePort: int32(port)}},
+		},
+	})
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1")
+	return NewProcessor(mgr, nil, zap.NewNop()), clientset
+}
+
 // createReadyPod creates a pod that is in ready state
 func createReadyPod(userID, agentID string) *corev1.Pod {
 	podID := k8s.NewPodID(userID, agentID)
@@ -42,8 +86,10 @@ func createReadyPod(userID, agentID string) *corev1.Pod {
 			Name:      podID.Name(),
 			Namespace: testNamespace,
 			Labels: map[string]string{
-				"user-id":  userID,
-				"agent-id": agentID,
+				"user-id":          userID,
+				"agent-id":         agentID,
+				k8s.ManagedByLabel: k8s.ManagedBy,
+				k8s.ComponentLabel: k8s.ComponentAgent,
 			},
 		},
 		Status: corev1.PodStatus{
@@ -64,8 +110,10 @@ func createPendingPod(userID, agentID string) *corev1.Pod {
 			Name:      podID.Name(),
 			Namespace: testNamespace,
 			Labels: map[string]string{
-				"user-id":  userID,
-				"agent-id": agentID,
+				"user-id":          userID,
+				"agent-id":         agentID,
+				k8s.ManagedByLabel: k8s.ManagedBy,
+				k8s.ComponentLabel: k8s.ComponentAgent,
 			},
 		},
 		Status: corev1.PodStatus{
@@ -290,6 +338,147 @@ func TestDeleteAgent_GracefulWithUnreachableAgent(t *testing.T) {
 	}
 }
 
+// deleteCollectionReactor makes the fake clientset delete the pods matching a
+// delete-collection's label selector, which it does not implement
+func deleteCollectionReactor(clientset *fake.Clientset) {
+	pods := corev1.SchemeGroupVersion.WithResource("pods")
+	clientset.PrependReactor("delete-collection", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.DeleteCollectionAction).GetListRestrictions().Labels
+		list, err := clientset.Tracker().List(pods, corev1.SchemeGroupVersion.WithKind("Pod"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		for _, pod := range list.(*corev1.PodList).Items {
+			if selector.Matches(labels.Set(pod.Labels)) {
+				if err := clientset.Tracker().Delete(pods, action.GetNamespace(), pod.Name); err != nil {
+					return true, nil, err
+				}
+			}
+		}
+		return true, nil, nil
+	})
+}
+
+func TestDeleteAllAgents_PartialShutdownFailures(t *testing.T) {
+	// agent1 answers through a NodePort service; agent2 has none and is unreachable
+	svc := &mockAgentService{}
+	mux := http.NewServeMux()
+	path, h := agentv1connect.NewAgentServiceHandler(svc)
+	mux.Handle(path, h)
+	server := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	t.Cleanup(server.Close)
+	_, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	reachable := k8s.NewPodID("user1", "agent1")
+	clientset := fake.NewSimpleClientset(
+		createReadyPod("user1", "agent1"),
+		createReadyPod("user1", "agent2"),
+		createReadyPod("user2", "agent3"),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: reachable.Name(), Namespace: testNamespace},
+			Spec: corev1.ServiceSpec{
+				Type:  corev1.ServiceTypeNodePort,
+				Ports: []corev1.ServicePort{{Name: "grpc", Port: k8s.DefaultAgentPort, NodePort: int32(port)}},
+			},
+		},
+	)
+	deleteCollectionReactor(clientset)
+	proc := NewProcessor(k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "127.0.0.1"), nil, zap.NewNop())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	deletions, err := proc.DeleteAllAgents(ctx, "user1", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	byAgent := make(map[string]AgentDeletion)
+	for _, d := range deletions {
+		byAgent[d.AgentID] = d
+	}
+	if len(byAgent) != 2 {
+		t.Fatalf("expected user1's 2 agents, got %+v", deletions)
+	}
+	if d := byAgent["agent1"]; !d.Graceful || d.ShutdownErr != nil || !svc.shutdownGraceful {
+		t.Errorf("expected agent1 to shut down gracefully, got %+v", d)
+	}
+	if d := byAgent["agent2"]; d.Graceful || d.ShutdownErr == nil {
+		t.Errorf("expected agent2 to be force-deleted with the shutdown error, got %+v", d)
+	}
+
+	remaining, err := proc.ListAgents(ctx, "user1")
+	if err != nil || len(remaining) != 0 {
+		t.Errorf("expected user1's agents to be deleted, got %v, %v", remaining, err)
+	}
+	if others, _ := proc.ListAgents(ctx, "user2"); len(others) != 1 {
+		t.Errorf("expected user2's agent to remain, got %v", others)
+	}
+}
+
+func TestDeleteAgent_InvalidatesConnections(t *testing.T) {
+	svc := &mockAgentService{}
+	mux := http.NewServeMux()
+	mux.Handle(agentv1connect.NewAgentServiceHandler(svc))
+	server := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	t.Cleanup(server.Close)
+	_, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	podID := k8s.NewPodID("user1", "agent1")
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(createReadyPod("user1", "agent1"), &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: podID.Name(), Namespace: testNamespace},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{{Name: "grpc", Port: k8s.DefaultAgentPort, NodePort: int32(port)}},
+		},
+	}), testNamespace, "test-image:latest", "127.0.0.1")
+	proc := NewProcessor(mgr, nil, zap.NewNop())
+	reg := prometheus.NewRegistry()
+	proc.pool = agent.NewClientPool(agent.PoolConfig{Metrics: metrics.New(reg)})
+	t.Cleanup(proc.pool.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	address, err := mgr.GetPodAddress(ctx, *podID)
+	if err != nil {
+		t.Fatalf("failed to get address: %v", err)
+	}
+	if _, err := proc.GetStatus(ctx, "user1", "agent1"); err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if err := proc.DeleteAgent(ctx, "user1", "agent1", true); err != nil {
+		t.Fatalf("DeleteAgent failed: %v", err)
+	}
+	if !svc.shutdownGraceful {
+		t.Error("expected the shutdown to reuse the agent's connection")
+	}
+
+	// The deleted agent's connection is gone, so reaching its address dials again
+	if _, err := proc.pool.Get(address, agent.ClientOptions{}).GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{})); err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	want := `
+# HELP forge_agent_connection_dials_total Connections dialed to agents, by outcome.
+# TYPE forge_agent_connection_dials_total counter
+forge_agent_connection_dials_total{outcome="success"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "forge_agent_connection_dials_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteAllAgents_NoAgents(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	deleteCollectionReactor(clientset)
+	proc := NewProcessor(k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", ""), nil, zap.NewNop())
+
+	deletions, err := proc.DeleteAllAgents(context.Background(), "user1", true)
+	if err != nil || len(deletions) != 0 {
+		t.Errorf("expected nothing to delete, got %+v, %v", deletions, err)
+	}
+}
+
 // --- CreateAgent Tests ---
 
 func TestCreateAgent_Success(t *testing.T) {
@@ -354,6 +543,79 @@ func TestCreateAgent_Success(t *testing.T) {
 	}
 }
 
+func TestCreateAgent_OutlivesRequestDeadline(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	fakeWatcher := watch.NewFake()
+	clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(fakeWatcher, nil))
+
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "")
+	proc := createTestProcessor(t, mgr)
+	proc.createTimeout = 5 * time.Second
+
+	// A deadline like the HTTP server's write timeout, shorter than the create timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := proc.CreateAgent(ctx, "user1")
+		errCh <- err
+	}()
+
+	// The pod becomes ready after the request's deadline passed
+	<-ctx.Done()
+	time.Sleep(100 * time.Millisecond)
+	pods, err := clientset.CoreV1().Pods(testNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(pods.Items) != 1 {
+		t.Fatalf("expected 1 pod, got %v (%v)", pods, err)
+	}
+	readyPod := pods.Items[0].DeepCopy()
+	readyPod.Status.Phase = corev1.PodRunning
+	readyPod.Status.PodIP = "10.0.0.5"
+	readyPod.Status.ContainerStatuses = []corev1.ContainerStatus{{Ready: true}}
+	fakeWatcher.Modify(readyPod)
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("expected the agent created within AGENT_CREATE_TIMEOUT, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for CreateAgent to return")
+	}
+}
+
+func TestCreateAgent_CreateTimeout(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	// Set up watch reactor but don't send ready event
+	fakeWatcher := watch.NewFake()
+	clientset.PrependWatchReactor("pods", k8stesting.DefaultWatchReactor(fakeWatcher, nil))
+
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*corev1.Pod).Status.Phase = corev1.PodPending
+		return false, nil, nil
+	})
+
+	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "")
+	proc := createTestProcessor(t, mgr)
+	proc.createTimeout = 200 * time.Millisecond
+
+	_, err := proc.CreateAgent(context.Background(), "user1")
+	var failure *k8s.PodFailureError
+	if !errors.As(err, &failure) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a pod failure for the deadline, got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "AGENT_CREATE_TIMEOUT of 200ms") || !strings.Contains(msg, "phase Pending") {
+		t.Errorf("expected the error to state the timeout and the pod's phase, got %q", msg)
+	}
+
+	pods, _ := clientset.CoreV1().Pods(testNamespace).List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("expected the pod cleaned up, got %d", len(pods.Items))
+	}
+}
+
 func TestCreateAgent_ContextCancelled(t *testing.T) {
 	clientset := fake.NewSimpleClientset()
 
@@ -364,18 +626,14 @@ func TestCreateAgent_ContextCancelled(t *testing.T) {
 	mgr := k8s.NewManagerWithClientset(clientset, testNamespace, "test-image:latest", "")
 	proc := createTestProcessor(t, mgr)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
-	defer cancel()
+	// A cancelled request (e.g. the client went away) ends the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
 
 	_, err := proc.CreateAgent(ctx, "user1")
-	if err == nil {
-		t.Fatal("expected error when context times out")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation, got %v", err)
 	}
-
-	// Verify pod was cleaned up (best effort, may or may not be deleted depending on timing)
-	time.Sleep(100 * time.Millisecond)
-	pods, _ := clientset.CoreV1().Pods(testNamespace).List(context.Background(), metav1.ListOptions{})
-	t.Logf("Pods remaining after failed create: %d (cleanup may be async)", len(pods.Items))
 }
 
 // --- ConnectToAgent Tests ---
@@ -398,8 +656,30 @@ func TestConnectToAgent_AgentNotReady(t *testing.T) {
 
 	ctx := context.Background()
 	_, err := proc.ConnectToAgent(ctx, "user1", "agent1")
-	if err == nil {
-		t.Fatal("expected error for agent without IP")
+	var notReady *k8s.AgentNotReadyError
+	if !errors.As(err, &notReady) || notReady.Phase != corev1.PodPending || notReady.Terminating {
+		t.Fatalf("expected the agent to be starting, got %v", err)
+	}
+	if !errors.Is(err, ErrAgentNotReady) {
+		t.Errorf("expected %v to match ErrAgentNotReady", err)
+	}
+}
+
+func TestConnectToAgent_AgentTerminating(t *testing.T) {
+	// A terminating pod keeps its IP and ready containers until it is gone
+	pod := createReadyPod("user1", "agent1")
+	pod.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	pod.Finalizers = []string{"test/finalizer"}
+	proc := createTestProcessor(t, createTestK8sManager(t, pod))
+
+	ctx := context.Background()
+	_, err := proc.ConnectToAgent(ctx, "user1", "agent1")
+	var notReady *k8s.AgentNotReadyError
+	if !errors.As(err, &notReady) || !notReady.Terminating {
+		t.Fatalf("expected the agent to be terminating, got %v", err)
+	}
+	if _, err := proc.CatchUp(ctx, "user1", "agent1", 0, 10); !errors.As(err, &notReady) || !notReady.Terminating {
+		t.Errorf("expected CatchUp to find the agent terminating, got %v", err)
 	}
 }
 
@@ -433,3 +713,260 @@ func TestGenerateAgentID_Unique(t *testing.T) {
 		time.Sleep(time.Nanosecond) // Ensure different timestamps
 	}
 }
+
+// --- streamToWebhook Tests ---
+
+// fakeStream returns its responses, then err
+type fakeStream struct {
+	responses []*agentv1.AgentResponse
+	err       error
+}
+
+func (s *fakeStream) Send(*agentv1.AgentRequest) error { return nil }
+func (s *fakeStream) CloseRequest() error              { return nil }
+func (s *fakeStream) CloseResponse() error             { return nil }
+
+func (s *fakeStream) Receive() (*agentv1.AgentResponse, error) {
+	if len(s.responses) == 0 {
+		return nil, s.err
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, nil
+}
+
+// fakeStreamQuerier records the payloads queued for delivery, the delivery status, the
+// agent messages stored and the request usage stored. Unimplemented querier methods panic
+// via the nil embedded interface.
+type fakeStreamQuerier struct {
+	sqlc.Querier
+	queued   []webhook.Payload
+	status   string
+	messages []*sqlc.InsertAgentMessageParams
+	usage    []*sqlc.UpsertRequestUsageParams
+}
+
+// InsertAgentMessage skips messages already stored, as the unique agent/session/seq key does
+func (f *fakeStreamQuerier) InsertAgentMessage(_ context.Context, arg *sqlc.InsertAgentMessageParams) (int64, error) {
+	for _, m := range f.messages {
+		if m.AgentID == arg.AgentID && m.SessionID == arg.SessionID && m.Seq == arg.Seq {
+			return 0, nil
+		}
+	}
+	f.messages = append(f.messages, arg)
+	return 1, nil
+}
+
+func (f *fakeStreamQuerier) ListLifecycleWebhooksForAgent(context.Context, *sqlc.ListLifecycleWebhooksForAgentParams) ([]*sqlc.LifecycleWebhook, error) {
+	return nil, nil
+}
+
+func (f *fakeStreamQuerier) UpdateDeliverySeq(context.Context, *sqlc.UpdateDeliverySeqParams) error {
+	return nil
+}
+
+func (f *fakeStreamQuerier) EnqueueOutboxEvent(_ context.Context, arg *sqlc.EnqueueOutboxEventParams) error {
+	var payload webhook.Payload
+	if err := json.Unmarshal(arg.Payload, &payload); err != nil {
+		return err
+	}
+	f.queued = append(f.queued, payload)
+	return nil
+}
+
+func (f *fakeStreamQuerier) UpsertWebhookEvent(context.Context, *sqlc.UpsertWebhookEventParams) error {
+	return nil
+}
+
+func (f *fakeStreamQuerier) ListRequestArtifacts(context.Context, *sqlc.ListRequestArtifactsParams) ([]*sqlc.ListRequestArtifactsRow, error) {
+	return nil, nil
+}
+
+func (f *fakeStreamQuerier) MarkDeliveryCompleted(context.Context, string) error {
+	f.status = webhook.DeliveryStatusCompleted
+	return nil
+}
+
+func (f *fakeStreamQuerier) MarkDeliveryFailed(context.Context, string) error {
+	f.status = webhook.DeliveryStatusFailed
+	return nil
+}
+
+func streamEvent(seq uint64) *agentv1.AgentResponse {
+	return &agentv1.AgentResponse{
+		Seq:     seq,
+		Payload: &agentv1.AgentResponse_Event{Event: &agentv1.EventPayload{EventType: "message.updated", EventJson: []byte(`{}`)}},
+	}
+}
+
+func TestStreamToWebhook_ClassifiesStreamEnd(t *testing.T) {
+	complete := &agentv1.AgentResponse{Seq: 2, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}}
+
+	tests := []struct {
+		name        string
+		responses   []*agentv1.AgentResponse
+		err         error
+		wantStatus  string
+		wantCode    string // of the final agent.error, "" for a successful agent.complete
+		recoverable bool
+	}{
+		{"EOF", nil, io.EOF, webhook.DeliveryStatusCompleted, "", false},
+		{"wrapped EOF", nil, fmt.Errorf("reading frame: %w", io.EOF), webhook.DeliveryStatusCompleted, "", false},
+		{"connect EOF", nil, connect.NewError(connect.CodeUnknown, fmt.Errorf("final message has protocol-specific flags: %w", io.EOF)),
+			webhook.DeliveryStatusCompleted, "", false},
+		{"EOF after final", []*agentv1.AgentResponse{complete}, io.EOF, webhook.DeliveryStatusCompleted, "", false},
+		{"error after final", []*agentv1.AgentResponse{complete}, connect.NewError(connect.CodeInternal, errors.New("boom")),
+			webhook.DeliveryStatusCompleted, "", false},
+		{"agent cancelled", nil, connect.NewError(connect.CodeCanceled, errors.New("context canceled")),
+			webhook.DeliveryStatusFailed, ErrorCodeStreamCancelled, true},
+		{"connection lost", nil, connect.NewError(connect.CodeUnavailable, errors.New("read: connection reset by peer")),
+			webhook.DeliveryStatusFailed, ErrorCodeAgentUnavailable, true},
+		{"unexpected EOF", nil, io.ErrUnexpectedEOF, webhook.DeliveryStatusFailed, ErrorCodeStreamError, false},
+		{"agent error", nil, connect.NewError(connect.CodeInternal, errors.New("agent crashed")),
+			webhook.DeliveryStatusFailed, ErrorCodeStreamError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			querier := &fakeStreamQuerier{}
+			p := NewProcessor(createTestK8sManager(t), webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			stream := &agentStream{
+				bidiStream: &fakeStream{responses: append([]*agentv1.AgentResponse{streamEvent(1)}, tt.responses...), err: tt.err},
+				ctx:        ctx,
+				cancel:     cancel,
+			}
+			err := p.streamToWebhook(context.Background(), stream, "user1", "agent1", "req-1", webhook.Config{URL: "https://example.com/hook"})
+			if (err != nil) != (tt.wantCode != "") {
+				t.Errorf("expected an error only for a failed stream, got %v", err)
+			}
+
+			if querier.status != tt.wantStatus {
+				t.Errorf("expected delivery %s, got %q", tt.wantStatus, querier.status)
+			}
+			if len(querier.queued) != 2 {
+				t.Fatalf("expected the event and one final payload, got %d payloads", len(querier.queued))
+			}
+			final := querier.queued[1]
+			if !final.IsFinal || final.Seq != 2 {
+				t.Errorf("expected a final payload as seq 2, got %+v", final)
+			}
+			if tt.wantCode == "" {
+				if final.EventType != webhook.EventTypeComplete || !final.Success {
+					t.Errorf("expected a successful agent.complete, got %+v", final)
+				}
+				return
+			}
+			if final.EventType != webhook.EventTypeError || final.Error == nil ||
+				final.Error.Code != tt.wantCode || final.Error.Recoverable != tt.recoverable {
+				t.Errorf("expected agent.error %s (recoverable %v), got %+v (error %+v)", tt.wantCode, tt.recoverable, final, final.Error)
+			}
+		})
+	}
+}
+
+func TestStreamToWebhook_StoresMessagesOnce(t *testing.T) {
+	querier := &fakeStreamQuerier{}
+	p := NewProcessor(createTestK8sManager(t), webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+
+	// The agent replays seq 2, as it does when a stream is caught up from too early
+	replayed := streamEvent(2)
+	responses := []*agentv1.AgentResponse{
+		streamEvent(1), streamEvent(2), replayed,
+		{Seq: 3, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}},
+	}
+	for _, resp := range responses {
+		resp.SessionId = "ses-1"
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stream := &agentStream{bidiStream: &fakeStream{responses: responses, err: io.EOF}, ctx: ctx, cancel: cancel}
+	if err := p.streamToWebhook(context.Background(), stream, "user1", "agent1", "req-1", webhook.Config{URL: "https://example.com/hook"}); err != nil {
+		t.Fatalf("streamToWebhook: %v", err)
+	}
+
+	if len(querier.messages) != 3 {
+		t.Fatalf("expected seqs 1-3 stored once, got %d messages", len(querier.messages))
+	}
+	for i, m := range querier.messages {
+		if m.Seq != int64(i+1) || m.UserID != "user1" || m.AgentID != "agent1" || m.SessionID != "ses-1" ||
+			m.RequestID != "req-1" || m.Role != webhook.MessageRoleAssistant {
+			t.Errorf("unexpected stored message %d: %+v", i, m)
+		}
+	}
+}
+
+// ctxStream returns its responses, then blocks until ctx ends, as an agent's stream does
+// until its connection is cancelled
+type ctxStream struct {
+	fakeStream
+	ctx context.Context
+}
+
+func (s *ctxStream) Receive() (*agentv1.AgentResponse, error) {
+	if len(s.responses) > 0 {
+		return s.fakeStream.Receive()
+	}
+	<-s.ctx.Done()
+	return nil, connect.NewError(connect.CodeCanceled, s.ctx.Err())
+}
+
+func TestStreamToWebhook_ReportsDeletedAgent(t *testing.T) {
+	querier := &fakeStreamQuerier{}
+	p := NewProcessor(createTestK8sManager(t, createReadyPod("user1", "agent1")), webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	p.cancelOnDeletion(ctx, *k8s.NewPodID("user1", "agent1"), cancel)
+	stream := &agentStream{
+		bidiStream: &ctxStream{fakeStream: fakeStream{responses: []*agentv1.AgentResponse{streamEvent(1)}}, ctx: ctx},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.streamToWebhook(context.Background(), stream, "user1", "agent1", "req-1", webhook.Config{URL: "https://example.com/hook"})
+	}()
+
+	if err := p.DeleteAgent(context.Background(), "user1", "agent1", false); err != nil {
+		t.Fatalf("DeleteAgent: %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrAgentDeleted) {
+		t.Errorf("expected ErrAgentDeleted, got %v", err)
+	}
+
+	if len(querier.queued) != 2 {
+		t.Fatalf("expected the event and one final payload, got %d payloads", len(querier.queued))
+	}
+	final := querier.queued[1]
+	if final.EventType != webhook.EventTypeError || final.Error == nil ||
+		final.Error.Code != ErrorCodeAgentDeleted || final.Error.Recoverable {
+		t.Errorf("expected an unrecoverable agent.error %s, got %+v (error %+v)", ErrorCodeAgentDeleted, final, final.Error)
+	}
+	if querier.status != webhook.DeliveryStatusFailed {
+		t.Errorf("expected the delivery failed, got %q", querier.status)
+	}
+}
+
+func TestMessageRegistry_InterruptsTargetOrAll(t *testing.T) {
+	var r messageRegistry
+	podID := *k8s.NewPodID("user1", "agent1")
+	ctx1, untrack1 := r.track(context.Background(), podID, "req-1")
+	defer untrack1()
+	ctx2, untrack2 := r.track(context.Background(), podID, "req-2")
+	defer untrack2()
+
+	if got := r.interrupt(podID, "req-2"); !slices.Equal(got, []string{"req-2"}) {
+		t.Errorf("expected only req-2 interrupted, got %v", got)
+	}
+	if interrupted(ctx1) || !interrupted(ctx2) {
+		t.Errorf("expected only req-2's context interrupted, got causes %v and %v", context.Cause(ctx1), context.Cause(ctx2))
+	}
+	if got := r.interrupt(*k8s.NewPodID("user1", "agent2"), ""); len(got) != 0 {
+		t.Errorf("expected nothing in flight to agent2, got %v", got)
+	}
+
+	untrack2()
+	if got := r.interrupt(podID, ""); !slices.Equal(got, []string{"req-1"}) || !interrupted(ctx1) {
+		t.Errorf("expected the remaining req-1 interrupted, got %v", got)
+	}
+}
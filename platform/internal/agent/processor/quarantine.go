@@ -0,0 +1,294 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// ErrAgentQuarantined is returned for new messages to a quarantined agent
+var ErrAgentQuarantined = errors.New("agent quarantined")
+
+// ErrorCodeAgentQuarantined is the error code reported when a request is refused, or its
+// stream abandoned, because the agent is quarantined
+const ErrorCodeAgentQuarantined = "AGENT_QUARANTINED"
+
+// Reasons an agent is quarantined
+const (
+	QuarantineReasonMalformedEvents = "malformed_events"
+	QuarantineReasonPayloadRate     = "payload_rate"
+	QuarantineReasonStreamFailures  = "stream_failures"
+	// QuarantineReasonManual is reported for quarantines set on the pod by hand
+	QuarantineReasonManual = "manual"
+)
+
+// Quarantine describes why and since when an agent is quarantined
+type Quarantine struct {
+	Reason string    `json:"reason"`
+	Detail string    `json:"detail,omitempty"`
+	Since  time.Time `json:"since"`
+	// Until is when the cool-down lifts the quarantine; nil leaves lifting it to an admin
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// String describes the quarantine for error messages
+func (q *Quarantine) String() string {
+	s := q.Reason
+	if q.Detail != "" {
+		s += " (" + q.Detail + ")"
+	}
+	if q.Until != nil {
+		s += " until " + q.Until.Format(time.RFC3339)
+	}
+	return s
+}
+
+// QuarantineOf returns the pod's quarantine, if it has one
+func QuarantineOf(pod *corev1.Pod) (*Quarantine, bool) {
+	raw, ok := pod.Annotations[k8s.QuarantineAnnotation]
+	if !ok {
+		return nil, false
+	}
+	var q Quarantine
+	if err := json.Unmarshal([]byte(raw), &q); err != nil || q.Reason == "" {
+		return &Quarantine{Reason: QuarantineReasonManual, Detail: raw}, true
+	}
+	return &q, true
+}
+
+// QuarantineConfig holds the thresholds at which an agent is quarantined.
+// A zero threshold disables its trigger.
+type QuarantineConfig struct {
+	// Window is the period malformed events and event bytes are counted over
+	Window time.Duration
+	// MalformedEvents is the number of malformed events within a window that trips quarantine
+	MalformedEvents int
+	// BytesPerSecond is the event payload rate, averaged over a window, that trips quarantine
+	BytesPerSecond int64
+	// StreamFailures is the number of consecutive failed streams that trips quarantine
+	StreamFailures int
+	// Cooldown lifts a quarantine automatically once it has lasted this long; 0 never does
+	Cooldown time.Duration
+}
+
+// quarantineConfig returns the quarantine thresholds from cfg
+func quarantineConfig(cfg *config.Config) QuarantineConfig {
+	return QuarantineConfig{
+		Window:          cfg.AgentQuarantineWindow,
+		MalformedEvents: cfg.AgentQuarantineMalformedEvents,
+		BytesPerSecond:  cfg.AgentQuarantineBytesPerSecond,
+		StreamFailures:  cfg.AgentQuarantineStreamFailures,
+		Cooldown:        cfg.AgentQuarantineCooldown,
+	}
+}
+
+// agentHealth counts one agent's misbehavior. Malformed events and event bytes are counted
+// over a fixed window; stream failures until a stream succeeds.
+type agentHealth struct {
+	windowStart     time.Time
+	malformedEvents int
+	bytes           int64
+	streamFailures  int
+}
+
+// healthTracker holds the health of the agents the processor streams from, by pod name
+type healthTracker struct {
+	mu     sync.Mutex
+	agents map[string]*agentHealth
+}
+
+// get returns the health of the agent, starting a new window if the current one has ended.
+// The caller must hold mu.
+func (t *healthTracker) get(podName string, now time.Time, window time.Duration) *agentHealth {
+	health, ok := t.agents[podName]
+	if !ok {
+		health = &agentHealth{windowStart: now}
+		t.agents[podName] = health
+	}
+	if now.Sub(health.windowStart) >= window {
+		health.windowStart = now
+		health.malformedEvents = 0
+		health.bytes = 0
+	}
+	return health
+}
+
+// malformedEvent returns why resp is a malformed event, or "" if it is not one
+func malformedEvent(resp *agentv1.AgentResponse) string {
+	if raw := resp.GetEvent().GetEventJson(); len(raw) > 0 && !json.Valid(raw) {
+		return "event JSON is invalid"
+	}
+	return ""
+}
+
+// dropMalformedEvent logs a malformed event that is not relayed
+func (p *Processor) dropMalformedEvent(requestID string, resp *agentv1.AgentResponse, reason string) {
+	p.logger.Warn("dropping malformed agent event",
+		zap.String("request_id", requestID),
+		zap.Uint64("seq", resp.GetSeq()),
+		zap.String("reason", reason),
+	)
+}
+
+// trackResponse counts an agent response toward the agent's quarantine triggers, returning
+// the quarantine it trips, if any
+func (p *Processor) trackResponse(ctx context.Context, userID, agentID string, resp *agentv1.AgentResponse, malformed bool) *Quarantine {
+	cfg := p.quarantine
+	if cfg.Window <= 0 || (cfg.MalformedEvents <= 0 && cfg.BytesPerSecond <= 0) {
+		return nil
+	}
+	podID := k8s.NewPodID(userID, agentID)
+
+	var reason, detail string
+	p.health.mu.Lock()
+	health := p.health.get(podID.Name(), p.now(), cfg.Window)
+	health.bytes += int64(len(resp.GetEvent().GetEventJson()))
+	if malformed {
+		health.malformedEvents++
+	}
+	switch {
+	case cfg.MalformedEvents > 0 && health.malformedEvents >= cfg.MalformedEvents:
+		reason = QuarantineReasonMalformedEvents
+		detail = fmt.Sprintf("%d malformed events within %s", health.malformedEvents, cfg.Window)
+	case cfg.BytesPerSecond > 0 && float64(health.bytes) > float64(cfg.BytesPerSecond)*cfg.Window.Seconds():
+		reason = QuarantineReasonPayloadRate
+		detail = fmt.Sprintf("%d event bytes within %s, over %d bytes/s", health.bytes, cfg.Window, cfg.BytesPerSecond)
+	}
+	if reason != "" {
+		delete(p.health.agents, podID.Name())
+	}
+	p.health.mu.Unlock()
+
+	if reason == "" {
+		return nil
+	}
+	return p.tripQuarantine(ctx, *podID, reason, detail)
+}
+
+// trackStreamEnd counts how an agent's stream ended toward its consecutive failures,
+// returning the quarantine a failure trips, if any
+func (p *Processor) trackStreamEnd(ctx context.Context, userID, agentID string, failed bool) *Quarantine {
+	cfg := p.quarantine
+	if cfg.StreamFailures <= 0 {
+		return nil
+	}
+	podID := k8s.NewPodID(userID, agentID)
+
+	p.health.mu.Lock()
+	if !failed {
+		if health, ok := p.health.agents[podID.Name()]; ok {
+			health.streamFailures = 0
+		}
+		p.health.mu.Unlock()
+		return nil
+	}
+	health := p.health.get(podID.Name(), p.now(), cfg.Window)
+	health.streamFailures++
+	failures := health.streamFailures
+	if failures >= cfg.StreamFailures {
+		delete(p.health.agents, podID.Name())
+	}
+	p.health.mu.Unlock()
+
+	if failures < cfg.StreamFailures {
+		return nil
+	}
+	return p.tripQuarantine(ctx, *podID, QuarantineReasonStreamFailures,
+		fmt.Sprintf("%d consecutive failed streams", failures))
+}
+
+// tripQuarantine quarantines the agent, recording the quarantine on its pod
+func (p *Processor) tripQuarantine(ctx context.Context, podID k8s.PodID, reason, detail string) *Quarantine {
+	q := &Quarantine{Reason: reason, Detail: detail, Since: p.now().UTC()}
+	if p.quarantine.Cooldown > 0 {
+		until := q.Since.Add(p.quarantine.Cooldown)
+		q.Until = &until
+	}
+
+	p.logger.Warn("quarantining agent",
+		zap.String("pod", podID.Name()),
+		zap.String("reason", reason),
+		zap.String("detail", detail),
+	)
+
+	raw, err := json.Marshal(q)
+	if err == nil {
+		err = p.k8m.AnnotatePod(ctx, podID, map[string]string{k8s.QuarantineAnnotation: string(raw)})
+	}
+	if err != nil {
+		// The stream that tripped it is still abandoned, but new messages are not refused
+		p.logger.Error("failed to record agent quarantine",
+			zap.Error(err),
+			zap.String("pod", podID.Name()),
+		)
+	}
+	return q
+}
+
+// CheckQuarantine returns an error wrapping ErrAgentQuarantined if the agent is quarantined.
+// A quarantine whose cool-down has ended is lifted instead. Failing to look the agent up is
+// not an error here; sending to it reports that.
+func (p *Processor) CheckQuarantine(ctx context.Context, userID, agentID string) error {
+	podID := k8s.NewPodID(userID, agentID)
+	pod, err := p.k8m.GetPod(ctx, *podID)
+	if err != nil {
+		return nil
+	}
+	q, ok := QuarantineOf(pod)
+	if !ok {
+		return nil
+	}
+
+	if q.Until != nil && !p.now().Before(*q.Until) {
+		p.logger.Info("agent quarantine cool-down ended",
+			zap.String("pod", podID.Name()),
+			zap.String("reason", q.Reason),
+		)
+		if err := p.liftQuarantine(ctx, *podID); err != nil {
+			p.logger.Error("failed to lift agent quarantine", zap.Error(err), zap.String("pod", podID.Name()))
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrAgentQuarantined, q)
+}
+
+// Unquarantine lifts the agent's quarantine, reporting whether it was quarantined
+func (p *Processor) Unquarantine(ctx context.Context, userID, agentID string) (bool, error) {
+	podID := k8s.NewPodID(userID, agentID)
+	pod, err := p.k8m.GetPod(ctx, *podID)
+	if err != nil {
+		return false, err
+	}
+	q, ok := QuarantineOf(pod)
+	if !ok {
+		return false, nil
+	}
+
+	if err := p.liftQuarantine(ctx, *podID); err != nil {
+		return false, err
+	}
+	p.logger.Info("agent unquarantined",
+		zap.String("pod", podID.Name()),
+		zap.String("reason", q.Reason),
+	)
+	return true, nil
+}
+
+// liftQuarantine removes the agent's quarantine and starts its health counts afresh
+func (p *Processor) liftQuarantine(ctx context.Context, podID k8s.PodID) error {
+	p.health.mu.Lock()
+	delete(p.health.agents, podID.Name())
+	p.health.mu.Unlock()
+
+	return p.k8m.RemovePodAnnotation(ctx, podID, k8s.QuarantineAnnotation)
+}
@@ -0,0 +1,282 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// abusiveAgentService serves each Connect with the next of its scripts, repeating the
+// last one once they run out. A script sends its responses, then fails the stream if set.
+type abusiveAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	scripts []abusiveScript
+	next    atomic.Int32
+}
+
+type abusiveScript struct {
+	responses []*agentv1.AgentResponse
+	fail      bool
+}
+
+func (s *abusiveAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	n := min(int(s.next.Add(1))-1, len(s.scripts)-1)
+	script := s.scripts[n]
+
+	if _, err := stream.Receive(); err != nil {
+		return err
+	}
+	for _, resp := range script.responses {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	if script.fail {
+		return connect.NewError(connect.CodeInternal, errors.New("agent crashed"))
+	}
+	return nil
+}
+
+// createQuarantineProcessor creates a processor reaching a mock agent for user1/agent1
+// through a NodePort service, with the given quarantine thresholds and a settable clock
+func createQuarantineProcessor(t *testing.T, svc agentv1connect.AgentServiceHandler, cfg QuarantineConfig) (*Processor, *fake.Clientset, *time.Time) {
+	t.Helper()
+	p, clientset := createAgentProcessor(t, svc)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	p.quarantine = cfg
+	p.now = func() time.Time { return now }
+	return p, clientset, &now
+}
+
+func quarantineEvent(seq uint64, eventJSON string) *agentv1.AgentResponse {
+	return &agentv1.AgentResponse{
+		Seq: seq,
+		Payload: &agentv1.AgentResponse_Event{
+			Event: &agentv1.EventPayload{EventType: "message.part.updated", EventJson: []byte(eventJSON)},
+		},
+	}
+}
+
+func quarantineComplete(seq uint64) *agentv1.AgentResponse {
+	return &agentv1.AgentResponse{
+		Seq:     seq,
+		Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+	}
+}
+
+// streamOnce sends a message over StreamMessage, returning its error and emitted payloads
+func streamOnce(p *Processor, requestID string) ([]webhook.Payload, error) {
+	var emitted []webhook.Payload
+	err := p.StreamMessage(context.Background(), "user1", "agent1", requestID, "hi", true, func(payload webhook.Payload) error {
+		emitted = append(emitted, payload)
+		return nil
+	})
+	return emitted, err
+}
+
+// podQuarantine returns the quarantine recorded on the agent's pod, if any
+func podQuarantine(t *testing.T, clientset *fake.Clientset) (*Quarantine, bool) {
+	t.Helper()
+	pod, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), k8s.NewPodID("user1", "agent1").Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	return QuarantineOf(pod)
+}
+
+func TestQuarantine_MalformedEventsTripQuarantine(t *testing.T) {
+	svc := &abusiveAgentService{scripts: []abusiveScript{{responses: []*agentv1.AgentResponse{
+		quarantineEvent(1, `{"ok":true}`),
+		quarantineEvent(2, `{"truncated":`),
+		quarantineEvent(3, `not json`),
+		quarantineEvent(4, `{"never":"relayed"}`),
+		quarantineComplete(5),
+	}}}}
+	p, clientset, _ := createQuarantineProcessor(t, svc, QuarantineConfig{Window: time.Minute, MalformedEvents: 2})
+
+	emitted, err := streamOnce(p, "req-1")
+	if !errors.Is(err, ErrAgentQuarantined) {
+		t.Fatalf("expected ErrAgentQuarantined, got %v", err)
+	}
+	if len(emitted) != 2 || emitted[0].Seq != 1 {
+		t.Fatalf("expected the valid event then a final error, got %+v", emitted)
+	}
+	if final := emitted[1]; !final.IsFinal || final.Error == nil || final.Error.Code != ErrorCodeAgentQuarantined {
+		t.Errorf("expected a final %s error, got %+v", ErrorCodeAgentQuarantined, final)
+	}
+
+	q, ok := podQuarantine(t, clientset)
+	if !ok || q.Reason != QuarantineReasonMalformedEvents {
+		t.Fatalf("expected the pod to be quarantined for malformed events, got %+v", q)
+	}
+	if err := p.CheckQuarantine(context.Background(), "user1", "agent1"); !errors.Is(err, ErrAgentQuarantined) {
+		t.Errorf("expected new messages to be refused, got %v", err)
+	}
+}
+
+func TestQuarantine_MalformedEventsBelowThresholdAreDropped(t *testing.T) {
+	svc := &abusiveAgentService{scripts: []abusiveScript{{responses: []*agentv1.AgentResponse{
+		quarantineEvent(1, `{"ok":`),
+		quarantineEvent(2, `{"ok":true}`),
+		quarantineComplete(3),
+	}}}}
+	p, clientset, _ := createQuarantineProcessor(t, svc, QuarantineConfig{Window: time.Minute, MalformedEvents: 5})
+
+	emitted, err := streamOnce(p, "req-1")
+	if err != nil {
+		t.Fatalf("StreamMessage: %v", err)
+	}
+	if len(emitted) != 2 || emitted[0].Seq != 2 || !emitted[1].IsFinal {
+		t.Errorf("expected the malformed event to be dropped, got %+v", emitted)
+	}
+	if _, ok := podQuarantine(t, clientset); ok {
+		t.Error("expected the agent not to be quarantined")
+	}
+}
+
+func TestQuarantine_PayloadFloodTripsQuarantine(t *testing.T) {
+	chunk := `{"text":"` + strings.Repeat("x", 500) + `"}`
+	responses := make([]*agentv1.AgentResponse, 0, 11)
+	for seq := uint64(1); seq <= 10; seq++ {
+		responses = append(responses, quarantineEvent(seq, chunk))
+	}
+	svc := &abusiveAgentService{scripts: []abusiveScript{{responses: append(responses, quarantineComplete(11))}}}
+	// 1000 bytes/s over a 2s window allows 2000 bytes: the fourth event trips it
+	p, clientset, _ := createQuarantineProcessor(t, svc, QuarantineConfig{Window: 2 * time.Second, BytesPerSecond: 1000})
+
+	emitted, err := streamOnce(p, "req-1")
+	if !errors.Is(err, ErrAgentQuarantined) {
+		t.Fatalf("expected ErrAgentQuarantined, got %v", err)
+	}
+	if len(emitted) != 4 || emitted[3].Error == nil || emitted[3].Error.Code != ErrorCodeAgentQuarantined {
+		t.Errorf("expected three events then a quarantine error, got %+v", emitted)
+	}
+	if q, ok := podQuarantine(t, clientset); !ok || q.Reason != QuarantineReasonPayloadRate {
+		t.Errorf("expected the pod to be quarantined for its payload rate, got %+v", q)
+	}
+}
+
+func TestQuarantine_ConsecutiveStreamFailures(t *testing.T) {
+	crash := abusiveScript{fail: true}
+	healthy := abusiveScript{responses: []*agentv1.AgentResponse{quarantineComplete(1)}}
+	svc := &abusiveAgentService{scripts: []abusiveScript{crash, crash, healthy, crash, crash, crash}}
+	p, clientset, _ := createQuarantineProcessor(t, svc, QuarantineConfig{StreamFailures: 3})
+
+	// A successful stream resets the count, so only the last three failures are consecutive
+	for i := 1; i <= 5; i++ {
+		_, _ = streamOnce(p, "req-"+strconv.Itoa(i))
+		if _, quarantined := podQuarantine(t, clientset); quarantined {
+			t.Fatalf("expected no quarantine after stream %d", i)
+		}
+	}
+	emitted, _ := streamOnce(p, "req-6")
+	if len(emitted) != 1 || emitted[0].Error == nil || emitted[0].Error.Code != "STREAM_ERROR" {
+		t.Errorf("expected the failing stream to be reported as a stream error, got %+v", emitted)
+	}
+	q, quarantined := podQuarantine(t, clientset)
+	if !quarantined || q.Reason != QuarantineReasonStreamFailures {
+		t.Fatalf("expected the pod to be quarantined for stream failures, got %+v", q)
+	}
+}
+
+func TestQuarantine_WindowResetsCounts(t *testing.T) {
+	p, clientset, now := createQuarantineProcessor(t, &abusiveAgentService{}, QuarantineConfig{Window: time.Minute, MalformedEvents: 3})
+	ctx := context.Background()
+	malformed := quarantineEvent(1, `{`)
+
+	for _, advance := range []time.Duration{0, 30 * time.Second, 31 * time.Second, 10 * time.Second} {
+		*now = now.Add(advance)
+		if q := p.trackResponse(ctx, "user1", "agent1", malformed, true); q != nil {
+			t.Fatalf("expected counts to restart with each window, tripped %+v", q)
+		}
+	}
+	if q := p.trackResponse(ctx, "user1", "agent1", malformed, true); q == nil {
+		t.Fatal("expected the third malformed event within a window to trip quarantine")
+	}
+	if _, ok := podQuarantine(t, clientset); !ok {
+		t.Error("expected the quarantine to be recorded on the pod")
+	}
+}
+
+func TestQuarantine_CooldownLiftsQuarantine(t *testing.T) {
+	p, clientset, now := createQuarantineProcessor(t, &abusiveAgentService{}, QuarantineConfig{StreamFailures: 1, Cooldown: 10 * time.Minute})
+	ctx := context.Background()
+
+	q := p.trackStreamEnd(ctx, "user1", "agent1", true)
+	if q == nil || q.Until == nil || !q.Until.Equal(now.Add(10*time.Minute)) {
+		t.Fatalf("expected a quarantine lasting the cool-down, got %+v", q)
+	}
+
+	*now = now.Add(9 * time.Minute)
+	if err := p.CheckQuarantine(ctx, "user1", "agent1"); !errors.Is(err, ErrAgentQuarantined) {
+		t.Fatalf("expected the agent to stay quarantined during the cool-down, got %v", err)
+	}
+
+	*now = now.Add(time.Minute)
+	if err := p.CheckQuarantine(ctx, "user1", "agent1"); err != nil {
+		t.Fatalf("expected the cool-down to lift the quarantine, got %v", err)
+	}
+	if _, ok := podQuarantine(t, clientset); ok {
+		t.Error("expected the quarantine annotation to be removed")
+	}
+}
+
+func TestUnquarantine(t *testing.T) {
+	p, clientset, _ := createQuarantineProcessor(t, &abusiveAgentService{}, QuarantineConfig{StreamFailures: 2})
+	ctx := context.Background()
+
+	// Without a cool-down only an admin lifts the quarantine
+	p.trackStreamEnd(ctx, "user1", "agent1", true)
+	if q := p.trackStreamEnd(ctx, "user1", "agent1", true); q == nil || q.Until != nil {
+		t.Fatalf("expected an indefinite quarantine, got %+v", q)
+	}
+
+	was, err := p.Unquarantine(ctx, "user1", "agent1")
+	if err != nil || !was {
+		t.Fatalf("expected the quarantine to be lifted, got %v, %v", was, err)
+	}
+	if _, ok := podQuarantine(t, clientset); ok {
+		t.Error("expected the quarantine annotation to be removed")
+	}
+	if err := p.CheckQuarantine(ctx, "user1", "agent1"); err != nil {
+		t.Errorf("expected new messages to be accepted, got %v", err)
+	}
+
+	// Failure counts start afresh, so one more failure does not quarantine it again
+	if q := p.trackStreamEnd(ctx, "user1", "agent1", true); q != nil {
+		t.Errorf("expected counts to be reset, tripped %+v", q)
+	}
+
+	if was, err := p.Unquarantine(ctx, "user1", "agent1"); err != nil || was {
+		t.Errorf("expected unquarantining a healthy agent to change nothing, got %v, %v", was, err)
+	}
+	if _, err := p.Unquarantine(ctx, "user1", "missing"); err == nil {
+		t.Error("expected an error for a missing agent")
+	}
+}
+
+func TestQuarantineOf_HandSetAnnotation(t *testing.T) {
+	pod := createReadyPod("user1", "agent1")
+	pod.Annotations = map[string]string{k8s.QuarantineAnnotation: "investigating"}
+
+	q, ok := QuarantineOf(pod)
+	if !ok || q.Reason != QuarantineReasonManual || q.Detail != "investigating" || q.Until != nil {
+		t.Errorf("expected a manual quarantine, got %+v", q)
+	}
+}
@@ -0,0 +1,195 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// DefaultMessageQueueDepth is how many messages may wait for each agent unless
+// MESSAGE_QUEUE_DEPTH says otherwise
+const DefaultMessageQueueDepth = 10
+
+// ErrorCodeAgentBusy is the error code of a message refused because its agent is working
+// on another one
+const ErrorCodeAgentBusy = "agent_busy"
+
+// ErrAgentBusy is returned for a message to an agent working on another one, when the
+// message may not queue or the agent's queue is full
+var ErrAgentBusy = errors.New("agent is busy with another message")
+
+// AgentBusyError is the ErrAgentBusy refusing a message, naming the message the agent is
+// working on
+type AgentBusyError struct {
+	ActiveRequestID string
+	QueueFull       bool
+}
+
+func (e *AgentBusyError) Error() string {
+	if e.QueueFull {
+		return fmt.Sprintf("%s: %s is in progress and the agent's message queue is full", ErrAgentBusy, e.ActiveRequestID)
+	}
+	return fmt.Sprintf("%s: %s is in progress", ErrAgentBusy, e.ActiveRequestID)
+}
+
+func (e *AgentBusyError) Unwrap() error { return ErrAgentBusy }
+
+// queuedMessage is a message holding or waiting for its turn on an agent
+type queuedMessage struct {
+	requestID string
+	turn      chan struct{} // closed once the message may be sent
+}
+
+// agentMessages are the message sent to one agent and those queued behind it, oldest first
+type agentMessages struct {
+	active  *queuedMessage
+	waiting []*queuedMessage
+}
+
+// messageQueues lets each agent work on one message at a time, queueing the messages sent
+// to it meanwhile in FIFO order
+type messageQueues struct {
+	// depth is the most messages that may wait for each agent
+	depth int
+
+	mu     sync.Mutex
+	agents map[k8s.PodID]*agentMessages
+}
+
+// enqueue gives requestID the agent's turn if it is free. Otherwise, if wait is set, the
+// message is queued unless depth messages already wait, or force is set; it returns an
+// AgentBusyError if not. It returns webhook.ErrDuplicateRequest if requestID already holds
+// or waits for the agent's turn.
+func (q *messageQueues) enqueue(podID k8s.PodID, requestID string, wait, force bool) (*queuedMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	m := &queuedMessage{requestID: requestID, turn: make(chan struct{})}
+	agent, ok := q.agents[podID]
+	if ok && (agent.active.requestID == requestID ||
+		slices.ContainsFunc(agent.waiting, func(other *queuedMessage) bool { return other.requestID == requestID })) {
+		return nil, fmt.Errorf("%s is already in progress: %w", requestID, webhook.ErrDuplicateRequest)
+	}
+	if !ok {
+		if q.agents == nil {
+			q.agents = make(map[k8s.PodID]*agentMessages)
+		}
+		close(m.turn)
+		q.agents[podID] = &agentMessages{active: m}
+		return m, nil
+	}
+	if !wait {
+		return nil, &AgentBusyError{ActiveRequestID: agent.active.requestID}
+	}
+	if !force && len(agent.waiting) >= q.depth {
+		return nil, &AgentBusyError{ActiveRequestID: agent.active.requestID, QueueFull: true}
+	}
+	agent.waiting = append(agent.waiting, m)
+	return m, nil
+}
+
+// find returns requestID's message to podID, queued or holding its turn
+func (q *messageQueues) find(podID k8s.PodID, requestID string) *queuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	agent, ok := q.agents[podID]
+	if !ok {
+		return nil
+	}
+	if agent.active.requestID == requestID {
+		return agent.active
+	}
+	for _, m := range agent.waiting {
+		if m.requestID == requestID {
+			return m
+		}
+	}
+	return nil
+}
+
+// release gives up m's turn, or its place in the queue, passing the turn on to the next
+// message waiting
+func (q *messageQueues) release(podID k8s.PodID, m *queuedMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	agent, ok := q.agents[podID]
+	if !ok {
+		return
+	}
+	if agent.active != m {
+		agent.waiting = slices.DeleteFunc(agent.waiting, func(other *queuedMessage) bool { return other == m })
+		return
+	}
+	if len(agent.waiting) == 0 {
+		delete(q.agents, podID)
+		return
+	}
+	agent.active, agent.waiting = agent.waiting[0], agent.waiting[1:]
+	close(agent.active.turn)
+}
+
+// position returns how many messages are ahead of requestID's in its agent's queue, 0 once
+// it holds the turn, and the message holding it
+func (q *messageQueues) position(requestID string) (position int, activeRequestID string, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, agent := range q.agents {
+		if agent.active.requestID == requestID {
+			return 0, requestID, true
+		}
+		for i, m := range agent.waiting {
+			if m.requestID == requestID {
+				return i + 1, agent.active.requestID, true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// QueueMessage reserves requestID a turn on the agent ahead of sending it: it gets the
+// agent's turn if the agent is free, or else, if wait is set, a place in the agent's queue.
+// It returns an AgentBusyError if the agent is working on another message and wait is not
+// set, or MESSAGE_QUEUE_DEPTH messages already wait, and webhook.ErrDuplicateRequest if
+// requestID is already being sent or queued. A reserved message must be sent with
+// SendMessageWithWebhook or StreamMessage, or given up with DequeueMessage.
+func (p *Processor) QueueMessage(userID, agentID, requestID string, wait bool) error {
+	_, err := p.queues.enqueue(*k8s.NewPodID(userID, agentID), requestID, wait, false)
+	return err
+}
+
+// DequeueMessage gives up the turn or place QueueMessage reserved for requestID
+func (p *Processor) DequeueMessage(userID, agentID, requestID string) {
+	podID := *k8s.NewPodID(userID, agentID)
+	if m := p.queues.find(podID, requestID); m != nil {
+		p.queues.release(podID, m)
+	}
+}
+
+// QueuePosition returns how many messages are ahead of requestID on its agent, 0 if it is
+// the one being sent, and the request ID of the one being sent. ok is false for requests
+// not sent or queued through the processor.
+func (p *Processor) QueuePosition(requestID string) (position int, activeRequestID string, ok bool) {
+	return p.queues.position(requestID)
+}
+
+// awaitTurn waits until requestID may be sent to the agent, queueing it if QueueMessage
+// did not, and returns the func giving up its turn. It returns ctx's error if ctx ends first.
+func (p *Processor) awaitTurn(ctx context.Context, podID k8s.PodID, requestID string) (func(), error) {
+	m := p.queues.find(podID, requestID)
+	if m == nil {
+		m, _ = p.queues.enqueue(podID, requestID, true, true)
+	}
+	release := func() { p.queues.release(podID, m) }
+	select {
+	case <-m.turn:
+		return release, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+func TestMessageQueues_RefusesBeyondDepth(t *testing.T) {
+	q := messageQueues{depth: 1}
+	podID := *k8s.NewPodID("user1", "agent1")
+
+	first, err := q.enqueue(podID, "req-1", false, false)
+	if err != nil {
+		t.Fatalf("expected the idle agent's turn, got %v", err)
+	}
+	if _, err := q.enqueue(podID, "req-2", true, false); err != nil {
+		t.Fatalf("expected req-2 queued, got %v", err)
+	}
+	var busy *AgentBusyError
+	if _, err := q.enqueue(podID, "req-3", true, false); !errors.As(err, &busy) || !busy.QueueFull || busy.ActiveRequestID != "req-1" {
+		t.Errorf("expected a full queue behind req-1, got %v", err)
+	}
+	if _, err := q.enqueue(podID, "req-2", true, true); !errors.Is(err, webhook.ErrDuplicateRequest) {
+		t.Errorf("expected req-2 refused as a duplicate, got %v", err)
+	}
+	if _, err := q.enqueue(*k8s.NewPodID("user1", "agent2"), "req-4", false, false); err != nil {
+		t.Errorf("expected agent2 free, got %v", err)
+	}
+
+	q.release(podID, first)
+	if position, active, ok := q.position("req-2"); !ok || position != 0 || active != "req-2" {
+		t.Errorf("expected req-2 to hold the turn, got position %d behind %s", position, active)
+	}
+}
+
+func TestAwaitTurn_GivesUpPlaceWhenCancelled(t *testing.T) {
+	p := NewProcessor(nil, nil, nil)
+	podID := *k8s.NewPodID("user1", "agent1")
+	if err := p.QueueMessage("user1", "agent1", "req-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.QueueMessage("user1", "agent1", "req-2", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.awaitTurn(ctx, podID, "req-2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected req-2 to give up waiting, got %v", err)
+	}
+	if _, _, ok := p.QueuePosition("req-2"); ok {
+		t.Error("expected req-2 to leave the queue")
+	}
+
+	p.DequeueMessage("user1", "agent1", "req-1")
+	release, err := p.awaitTurn(context.Background(), podID, "req-3")
+	if err != nil {
+		t.Fatalf("expected the free agent's turn, got %v", err)
+	}
+	release()
+}
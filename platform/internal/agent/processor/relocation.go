@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// ErrAgentRelocated is the cause a stream is cancelled with when the agent's pod is replaced
+// (e.g. recreated under the same name) or moves to a new IP while the stream is open. The
+// stream's connection no longer reaches the agent, but a new one to its new address would.
+var ErrAgentRelocated = errors.New("agent relocated")
+
+// ErrorCodeAgentRelocated is the error code reported to consumers when a request is lost
+// to a relocation. It is recoverable: sending the request again reaches the new pod.
+const ErrorCodeAgentRelocated = "AGENT_RELOCATED"
+
+// Error codes reported to consumers when an agent's stream fails: STREAM_ERROR in
+// general, AGENT_UNAVAILABLE when the connection to the agent is lost and
+// STREAM_CANCELLED when the agent cancels the stream. The last two are recoverable.
+const (
+	ErrorCodeStreamError      = "STREAM_ERROR"
+	ErrorCodeAgentUnavailable = "AGENT_UNAVAILABLE"
+	ErrorCodeStreamCancelled  = "STREAM_CANCELLED"
+)
+
+// ErrorCodeRequestCancelled is the error code reported to consumers when a request's
+// context is cancelled before the agent finished it
+const ErrorCodeRequestCancelled = "REQUEST_CANCELLED"
+
+// errResendAfterRelocation is returned for streams whose agent relocated before responding,
+// when the request may be sent again to the new address without the consumer noticing
+var errResendAfterRelocation = errors.New("agent relocated before responding")
+
+// relocationRewatchDelay is how long to wait before replacing a pod watch that ended
+const relocationRewatchDelay = time.Second
+
+// bidiStream is the client side of an agent's Connect stream
+type bidiStream interface {
+	Send(*agentv1.AgentRequest) error
+	Receive() (*agentv1.AgentResponse, error)
+	CloseRequest() error
+	CloseResponse() error
+}
+
+// agentStream is a stream to one instance of an agent's pod. It is cancelled with an
+// ErrAgentRelocated cause once that instance stops being the one the address reaches, and
+// with an ErrAgentDeleted cause if the agent is deleted.
+type agentStream struct {
+	bidiStream
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// podUID is the pod instance the stream is pinned to
+	podUID types.UID
+
+	// resendable is set if a relocation before the first response should be returned as
+	// errResendAfterRelocation instead of reported to the consumer
+	resendable bool
+}
+
+// relocated returns the error the stream was cancelled with if its agent relocated, or nil
+func (s *agentStream) relocated() error {
+	if cause := context.Cause(s.ctx); errors.Is(cause, ErrAgentRelocated) {
+		return cause
+	}
+	return nil
+}
+
+// close releases the stream's connection and stops watching the pod
+func (s *agentStream) close() {
+	s.cancel(context.Canceled)
+}
+
+// connectPinned connects to an agent like ConnectToAgent, pinning the stream to the pod
+// instance the address currently reaches: if the pod is replaced or its IP changes while
+// the stream is open, the stream is cancelled with an ErrAgentRelocated cause.
+// The caller must close the stream once done with it.
+func (p *Processor) connectPinned(ctx context.Context, userID, agentID string) (*agentStream, error) {
+	client, stream, err := p.pinAgent(ctx, userID, agentID)
+	if err != nil {
+		return nil, err
+	}
+	stream.bidiStream = client.Connect(stream.ctx)
+	return stream, nil
+}
+
+// pinAgent returns a client of the agent and an agentStream, without a bidiStream yet,
+// pinned to the pod instance the agent's address currently reaches
+func (p *Processor) pinAgent(ctx context.Context, userID, agentID string) (agentv1connect.AgentServiceClient, *agentStream, error) {
+	podID := k8s.NewPodID(userID, agentID)
+
+	pod, address, err := p.k8m.GetReadyPodEndpoint(ctx, *podID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get agent address: %w", err)
+	}
+
+	if err := p.checkProtocolVersion(ctx, userID, agentID); err != nil {
+		return nil, nil, err
+	}
+
+	streamCtx, cancel := context.WithCancelCause(ctx)
+	go p.watchRelocation(streamCtx, *podID, pod, cancel)
+	p.cancelOnDeletion(streamCtx, *podID, cancel)
+	context.AfterFunc(streamCtx, p.metrics.MessageStreamOpened())
+
+	return p.agentClient(*podID, address), &agentStream{
+		ctx:    streamCtx,
+		cancel: cancel,
+		podUID: pod.UID,
+	}, nil
+}
+
+// watchRelocation watches the agent's pod until ctx ends, cancelling it with an
+// ErrAgentRelocated cause if the pod stops being the pinned instance. Watches end
+// server-side after a few minutes, so they are replaced for as long as the stream lasts.
+func (p *Processor) watchRelocation(ctx context.Context, podID k8s.PodID, pinned *corev1.Pod, cancel context.CancelCauseFunc) {
+	for {
+		events, err := p.k8m.WatchPod(ctx, podID)
+		if err != nil {
+			p.logger.Debug("failed to watch agent pod for relocation",
+				zap.Error(err),
+				zap.String("pod", podID.Name()),
+			)
+		} else {
+			// The channel is closed once the watch ends, and must be drained until then
+			for event := range events {
+				if event.Err != nil || event.Pod == nil || event.Pod.Name != podID.Name() || ctx.Err() != nil {
+					continue
+				}
+				if reason := relocation(pinned, event.Pod); reason != "" {
+					p.logger.Warn("agent relocated, abandoning its stream",
+						zap.String("pod", podID.Name()),
+						zap.String("reason", reason),
+					)
+					cancel(fmt.Errorf("%w: %s", ErrAgentRelocated, reason))
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relocationRewatchDelay):
+		}
+	}
+}
+
+// relocation returns why pod is no longer the pinned instance, or "" if it still is
+func relocation(pinned, pod *corev1.Pod) string {
+	switch {
+	case pod.UID != pinned.UID:
+		return "pod was replaced"
+	case pod.Status.PodIP != "" && pod.Status.PodIP != pinned.Status.PodIP:
+		return fmt.Sprintf("pod IP changed from %s to %s", pinned.Status.PodIP, pod.Status.PodIP)
+	}
+	return ""
+}
+
+// resendAfterRelocation reports whether err allows a request to be sent again after its
+// agent relocated, logging the retry if so
+func (p *Processor) resendAfterRelocation(err error, agentID, requestID string) bool {
+	if !errors.Is(err, errResendAfterRelocation) {
+		return false
+	}
+	p.logger.Info("agent relocated before responding, resending request",
+		zap.Error(err),
+		zap.String("agent_id", agentID),
+		zap.String("request_id", requestID),
+	)
+	return true
+}
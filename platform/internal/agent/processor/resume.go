@@ -0,0 +1,192 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/config"
+)
+
+// defaultResumeInterval is how long a relay waits before resuming its stream, and how
+// often a resumed stream polls the agent while it is still working on the request
+const defaultResumeInterval = 500 * time.Millisecond
+
+// catchUpPageSize is how many responses a resumed stream asks the agent for at a time
+const catchUpPageSize = 100
+
+// errAgentRestarted is returned by a resumed stream whose agent lost its response history,
+// so the responses the relay missed cannot be recovered
+var errAgentRestarted = errors.New("agent restarted and lost the request's responses")
+
+// ResumeConfig bounds how a webhook relay whose agent stream drops is resumed.
+type ResumeConfig struct {
+	// Attempts is how many times one relay's stream may be resumed; 0 disables resuming
+	Attempts int
+	// Timeout bounds how long a relay keeps resuming after its stream was first lost
+	Timeout time.Duration
+	// Interval is how long a relay waits before each attempt, and how often a resumed
+	// stream polls the agent for new responses
+	Interval time.Duration
+}
+
+// resumeConfig returns the stream resume limits from cfg
+func resumeConfig(cfg *config.Config) ResumeConfig {
+	return ResumeConfig{
+		Attempts: cfg.StreamResumeAttempts,
+		Timeout:  cfg.StreamResumeTimeout,
+		Interval: defaultResumeInterval,
+	}
+}
+
+// streamResume tracks the attempts to resume one relay's stream
+type streamResume struct {
+	attempts int
+	deadline time.Time
+	// lost is the error the stream was first lost with
+	lost error
+}
+
+// resumeStream replaces stream, which failed with err, by one resumed through the agent's
+// CatchUp RPC from fromSeq: the agent keeps working on a request whose stream dropped, and
+// returns the responses the relay missed. Only streams lost to a transient error, such as
+// a dropped connection, are resumed, and only while the relay has attempts and time left.
+// It returns nil if the stream cannot be resumed, e.g. because the agent does not support
+// CatchUp, its pod was replaced, or it restarted and lost the request's responses.
+func (p *Processor) resumeStream(
+	ctx context.Context,
+	resume *streamResume,
+	stream *agentStream,
+	err error,
+	userID, agentID, requestID string,
+	fromSeq uint64,
+) *agentStream {
+	if p.resume.Attempts <= 0 {
+		return nil
+	}
+	switch connect.CodeOf(err) {
+	case connect.CodeUnimplemented, connect.CodeDataLoss:
+		return nil
+	}
+	if resume.lost == nil {
+		if _, recoverable := StreamErrorCode(err); !recoverable {
+			return nil
+		}
+		resume.lost = err
+		resume.deadline = time.Now().Add(p.resume.Timeout)
+	}
+
+	for resume.attempts < p.resume.Attempts && time.Now().Before(resume.deadline) {
+		resume.attempts++
+		p.logger.Warn("agent stream lost, resuming",
+			zap.Error(err),
+			zap.String("agent_id", agentID),
+			zap.String("request_id", requestID),
+			zap.Int("attempt", resume.attempts),
+			zap.Uint64("from_seq", fromSeq),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(p.resume.Interval):
+		}
+
+		deadlineCtx, cancelDeadline := context.WithDeadline(ctx, resume.deadline)
+		client, resumed, pinErr := p.pinAgent(deadlineCtx, userID, agentID)
+		if pinErr != nil {
+			cancelDeadline()
+			err = pinErr
+			continue
+		}
+		if stream.podUID != "" && resumed.podUID != stream.podUID {
+			// A new pod has none of the old one's responses
+			resumed.close()
+			cancelDeadline()
+			return nil
+		}
+
+		cancel := resumed.cancel
+		resumed.cancel = func(cause error) {
+			cancel(cause)
+			cancelDeadline()
+		}
+		resumed.bidiStream = &catchUpStream{
+			ctx:       resumed.ctx,
+			client:    client,
+			requestID: requestID,
+			nextSeq:   fromSeq,
+			interval:  p.resume.Interval,
+		}
+		return resumed
+	}
+	return nil
+}
+
+// catchUpStream reads a request's responses through the agent's CatchUp RPC, from nextSeq
+// onwards. While the agent is still working on the request it polls for new responses;
+// once the agent is done and every response was read it returns io.EOF.
+type catchUpStream struct {
+	ctx       context.Context
+	client    agentv1connect.AgentServiceClient
+	requestID string
+	nextSeq   uint64
+	interval  time.Duration
+
+	pending []*agentv1.AgentResponse
+	checked bool // the agent's history was found to reach back to nextSeq
+}
+
+func (s *catchUpStream) Send(*agentv1.AgentRequest) error {
+	return errors.New("a resumed stream does not take requests")
+}
+
+func (s *catchUpStream) CloseRequest() error  { return nil }
+func (s *catchUpStream) CloseResponse() error { return nil }
+
+func (s *catchUpStream) Receive() (*agentv1.AgentResponse, error) {
+	for len(s.pending) == 0 {
+		resp, err := s.client.CatchUp(s.ctx, connect.NewRequest(&agentv1.CatchUpRequest{
+			FromSeq:   s.nextSeq,
+			Limit:     catchUpPageSize,
+			RequestId: s.requestID,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		if !s.checked {
+			// Seqs only go back if the agent restarted
+			if latest := resp.Msg.GetLatestSeq(); latest+1 < s.nextSeq {
+				return nil, connect.NewError(connect.CodeDataLoss,
+					fmt.Errorf("%w: latest seq %d, expected at least %d", errAgentRestarted, latest, s.nextSeq-1))
+			}
+			s.checked = true
+		}
+
+		s.pending = resp.Msg.GetResponses()
+		if len(s.pending) > 0 {
+			break
+		}
+		if !resp.Msg.GetInProgress() {
+			return nil, io.EOF
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return nil, context.Cause(s.ctx)
+		case <-time.After(s.interval):
+		}
+	}
+
+	resp := s.pending[0]
+	s.pending = s.pending[1:]
+	s.nextSeq = resp.GetSeq() + 1
+	return resp, nil
+}
@@ -0,0 +1,214 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// droppingAgentService sends the first drop responses of its script over Connect, then
+// fails the stream with connectErr (Unavailable if unset). It keeps working on the request
+// regardless: CatchUp returns the rest of the script.
+type droppingAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	responses  []*agentv1.AgentResponse
+	drop       int
+	connectErr error
+
+	// The first CatchUp calls fail with catchUpErrs, then the next busyCatchUps report the
+	// request in progress without responses. If restarted is set, CatchUp reports an empty
+	// history.
+	catchUpErrs  []error
+	busyCatchUps int
+	restarted    bool
+
+	mu       sync.Mutex
+	catchUps []*agentv1.CatchUpRequest
+}
+
+func (s *droppingAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	if _, err := stream.Receive(); err != nil {
+		return err
+	}
+	for _, resp := range s.responses[:s.drop] {
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	if s.connectErr != nil {
+		return s.connectErr
+	}
+	return connect.NewError(connect.CodeUnavailable, errors.New("connection reset by peer"))
+}
+
+func (s *droppingAgentService) CatchUp(
+	_ context.Context,
+	req *connect.Request[agentv1.CatchUpRequest],
+) (*connect.Response[agentv1.CatchUpResponse], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.catchUps)
+	s.catchUps = append(s.catchUps, req.Msg)
+
+	if n < len(s.catchUpErrs) {
+		return nil, s.catchUpErrs[n]
+	}
+	if s.restarted {
+		return connect.NewResponse(&agentv1.CatchUpResponse{}), nil
+	}
+	latest := s.responses[len(s.responses)-1].GetSeq()
+	if n < len(s.catchUpErrs)+s.busyCatchUps {
+		return connect.NewResponse(&agentv1.CatchUpResponse{LatestSeq: latest, InProgress: true}), nil
+	}
+	var missed []*agentv1.AgentResponse
+	for _, resp := range s.responses {
+		if resp.GetSeq() >= req.Msg.GetFromSeq() {
+			missed = append(missed, resp)
+		}
+	}
+	return connect.NewResponse(&agentv1.CatchUpResponse{Responses: missed, LatestSeq: latest}), nil
+}
+
+func (s *droppingAgentService) catchUpRequests() []*agentv1.CatchUpRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*agentv1.CatchUpRequest(nil), s.catchUps...)
+}
+
+func TestStreamToWebhook_ResumesDroppedStream(t *testing.T) {
+	script := []*agentv1.AgentResponse{
+		streamEvent(1), streamEvent(2), streamEvent(3), streamEvent(4),
+		{Seq: 5, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}},
+	}
+	unavailable := connect.NewError(connect.CodeUnavailable, errors.New("agent restarting"))
+
+	tests := []struct {
+		name         string
+		svc          *droppingAgentService
+		wantSeqs     []uint64 // queued; the last is the final payload
+		wantCode     string   // of the final agent.error, "" for the agent's agent.complete
+		wantCatchUps int
+	}{
+		{
+			name:         "missed responses are caught up",
+			svc:          &droppingAgentService{responses: script, drop: 2},
+			wantSeqs:     []uint64{1, 2, 3, 4, 5},
+			wantCatchUps: 1,
+		},
+		{
+			name:         "polls while the agent is still working",
+			svc:          &droppingAgentService{responses: script, drop: 2, busyCatchUps: 2},
+			wantSeqs:     []uint64{1, 2, 3, 4, 5},
+			wantCatchUps: 3,
+		},
+		{
+			name:         "failed catch-up is retried",
+			svc:          &droppingAgentService{responses: script, drop: 3, catchUpErrs: []error{unavailable}},
+			wantSeqs:     []uint64{1, 2, 3, 4, 5},
+			wantCatchUps: 2,
+		},
+		{
+			name:         "gives up after the attempts",
+			svc:          &droppingAgentService{responses: script, drop: 2, catchUpErrs: []error{unavailable, unavailable, unavailable, unavailable}},
+			wantSeqs:     []uint64{1, 2, 3},
+			wantCode:     ErrorCodeAgentUnavailable,
+			wantCatchUps: 3,
+		},
+		{
+			name:         "agent without catch-up",
+			svc:          &droppingAgentService{responses: script, drop: 2, catchUpErrs: []error{connect.NewError(connect.CodeUnimplemented, errors.New("not implemented"))}},
+			wantSeqs:     []uint64{1, 2, 3},
+			wantCode:     ErrorCodeAgentUnavailable,
+			wantCatchUps: 1,
+		},
+		{
+			name:         "agent restarted",
+			svc:          &droppingAgentService{responses: script, drop: 2, restarted: true},
+			wantSeqs:     []uint64{1, 2, 3},
+			wantCode:     ErrorCodeAgentUnavailable,
+			wantCatchUps: 1,
+		},
+		{
+			name:         "non-retryable error is not resumed",
+			svc:          &droppingAgentService{responses: script, drop: 2, connectErr: connect.NewError(connect.CodeInternal, errors.New("agent crashed"))},
+			wantSeqs:     []uint64{1, 2, 3},
+			wantCode:     ErrorCodeStreamError,
+			wantCatchUps: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, _ := createQuarantineProcessor(t, tt.svc, QuarantineConfig{})
+			querier := &fakeStreamQuerier{}
+			p.webhookDelivery = webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop())
+			p.resume = ResumeConfig{Attempts: 3, Timeout: 5 * time.Second, Interval: 10 * time.Millisecond}
+			// Count the resume attempts alone, not the RPC retries within them
+			p.rpcRetries = 0
+
+			ctx := context.Background()
+			stream, _, err := p.openRequestStream(ctx, "user1", "agent1", newSendMessageRequest("req-1", "hi"))
+			if err != nil {
+				t.Fatalf("openRequestStream: %v", err)
+			}
+			err = p.streamToWebhook(ctx, stream, "user1", "agent1", "req-1", webhook.Config{URL: "https://example.com/hook"})
+			if (err != nil) != (tt.wantCode != "") {
+				t.Errorf("expected an error only for a failed stream, got %v", err)
+			}
+
+			var seqs []uint64
+			for _, payload := range querier.queued {
+				seqs = append(seqs, payload.Seq)
+			}
+			if len(seqs) != len(tt.wantSeqs) {
+				t.Fatalf("expected seqs %v, got %v", tt.wantSeqs, seqs)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.wantSeqs[i] {
+					t.Fatalf("expected seqs %v, got %v", tt.wantSeqs, seqs)
+				}
+			}
+
+			final := querier.queued[len(querier.queued)-1]
+			if tt.wantCode == "" {
+				if final.EventType != webhook.EventTypeComplete || !final.Success || querier.status != webhook.DeliveryStatusCompleted {
+					t.Errorf("expected the agent's agent.complete, got %+v (delivery %q)", final, querier.status)
+				}
+			} else if final.EventType != webhook.EventTypeError || final.Error == nil || final.Error.Code != tt.wantCode {
+				t.Errorf("expected agent.error %s, got %+v (error %+v)", tt.wantCode, final, final.Error)
+			}
+
+			// Every response the agent sent is stored once, the caught-up ones included
+			stored := len(tt.wantSeqs)
+			if tt.wantCode != "" {
+				stored--
+			}
+			if len(querier.messages) != stored {
+				t.Errorf("expected %d stored messages, got %d", stored, len(querier.messages))
+			}
+
+			catchUps := tt.svc.catchUpRequests()
+			if len(catchUps) != tt.wantCatchUps {
+				t.Fatalf("expected %d CatchUp calls, got %d", tt.wantCatchUps, len(catchUps))
+			}
+			if len(catchUps) > 0 {
+				first := catchUps[0]
+				if first.GetRequestId() != "req-1" || first.GetFromSeq() != uint64(tt.svc.drop)+1 {
+					t.Errorf("expected a catch-up of req-1 from seq %d, got %+v", tt.svc.drop+1, first)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// ErrSettingNotAllowed is returned for a model or permission mode callers may not pick
+var ErrSettingNotAllowed = errors.New("agent setting not allowed")
+
+// ErrSettingRejected is returned when an agent answers a change of its settings with an error
+var ErrSettingRejected = errors.New("agent rejected setting")
+
+// PermissionModes are the permission modes agents run with
+var PermissionModes = []string{"default", "acceptEdits", "bypassPermissions"}
+
+// AgentSettings are the model and permission mode an agent runs with. Empty fields are left
+// as they are.
+type AgentSettings struct {
+	Model          string
+	PermissionMode string
+}
+
+// CheckSettings returns an error wrapping ErrSettingNotAllowed unless the model is in
+// AGENT_MODEL_ALLOWLIST and the permission mode is one of PermissionModes
+func (p *Processor) CheckSettings(settings AgentSettings) error {
+	if settings.Model != "" && !slices.Contains(p.models, settings.Model) {
+		return fmt.Errorf("%w: model %q is not allowed", ErrSettingNotAllowed, settings.Model)
+	}
+	if settings.PermissionMode != "" && !slices.Contains(PermissionModes, settings.PermissionMode) {
+		return fmt.Errorf("%w: permission mode %q is not one of %v", ErrSettingNotAllowed, settings.PermissionMode, PermissionModes)
+	}
+	return nil
+}
+
+// ConfigureAgent changes the settings of a running agent, sending it a SetModel and a
+// SetPermissionMode command for the settings given. Each is sent once the previous one is
+// acknowledged. The agent keeps them until its pod restarts, when it goes back to the
+// settings it was created with.
+func (p *Processor) ConfigureAgent(ctx context.Context, userID, agentID string, settings AgentSettings) error {
+	if err := p.CheckSettings(settings); err != nil {
+		return err
+	}
+
+	var commands []*agentv1.AgentRequest
+	if settings.Model != "" {
+		commands = append(commands, &agentv1.AgentRequest{
+			Command: &agentv1.AgentRequest_SetModel{SetModel: &agentv1.SetModelRequest{Model: settings.Model}},
+		})
+	}
+	if settings.PermissionMode != "" {
+		commands = append(commands, &agentv1.AgentRequest{
+			Command: &agentv1.AgentRequest_SetPermissionMode{SetPermissionMode: &agentv1.SetPermissionModeRequest{Mode: settings.PermissionMode}},
+		})
+	}
+	// A status cached before the change no longer holds
+	defer p.statuses.forget(*k8s.NewPodID(userID, agentID))
+	for _, req := range commands {
+		req.RequestId = fmt.Sprintf("settings_%s_%d", agentID, p.now().UnixNano())
+		if err := p.sendCommand(ctx, userID, agentID, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendCommand sends the agent a command and waits for it to complete
+func (p *Processor) sendCommand(ctx context.Context, userID, agentID string, req *agentv1.AgentRequest) error {
+	stream, _, err := p.openRequestStream(ctx, userID, agentID, req)
+	if err != nil {
+		return err
+	}
+	defer stream.close()
+	defer stream.CloseResponse()
+
+	for {
+		resp, err := stream.Receive()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("agent closed the stream before completing %s", req.RequestId)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive from agent: %w", err)
+		}
+		switch payload := resp.Payload.(type) {
+		case *agentv1.AgentResponse_Error:
+			return fmt.Errorf("%w: %s: %s", ErrSettingRejected, payload.Error.Code, payload.Error.Message)
+		case *agentv1.AgentResponse_Complete:
+			if !payload.Complete.Success {
+				return fmt.Errorf("%w: the command did not succeed", ErrSettingRejected)
+			}
+			return nil
+		}
+	}
+}
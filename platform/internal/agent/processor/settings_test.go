@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// settingsAgentService records the commands it receives and completes them
+type settingsAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	mu       sync.Mutex
+	commands []*agentv1.AgentRequest
+}
+
+func (s *settingsAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	req, err := stream.Receive()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.commands = append(s.commands, req)
+	s.mu.Unlock()
+	return stream.Send(&agentv1.AgentResponse{
+		RequestId: req.RequestId,
+		Payload:   &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}},
+	})
+}
+
+func TestCheckSettings(t *testing.T) {
+	p := NewProcessor(nil, nil, nil)
+	p.models = []string{"anthropic/claude-sonnet-4"}
+
+	for _, settings := range []AgentSettings{
+		{},
+		{Model: "anthropic/claude-sonnet-4"},
+		{PermissionMode: "acceptEdits"},
+		{Model: "anthropic/claude-sonnet-4", PermissionMode: "bypassPermissions"},
+	} {
+		if err := p.CheckSettings(settings); err != nil {
+			t.Errorf("expected %+v to be allowed, got %v", settings, err)
+		}
+	}
+	for _, settings := range []AgentSettings{
+		{Model: "anthropic/claude-opus-4"},
+		{PermissionMode: "plan"},
+	} {
+		if err := p.CheckSettings(settings); !errors.Is(err, ErrSettingNotAllowed) {
+			t.Errorf("expected %+v to be refused, got %v", settings, err)
+		}
+	}
+}
+
+func TestCreateAgentWithOptions_Settings(t *testing.T) {
+	querier := &fakeLifecycleQuerier{}
+	p := lifecycleProcessor(t, querier, createReadyPod("user1", "agent1").Status)
+	p.models = []string{"anthropic/claude-sonnet-4"}
+	ctx := context.Background()
+
+	if _, err := p.CreateAgentWithOptions(ctx, "user1", k8s.PodOptions{Model: "anthropic/claude-opus-4"}); !errors.Is(err, ErrSettingNotAllowed) {
+		t.Fatalf("expected a model outside the allowlist to be refused, got %v", err)
+	}
+
+	podID, err := p.CreateAgentWithOptions(ctx, "user1", k8s.PodOptions{Model: "anthropic/claude-sonnet-4", PermissionMode: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod, err := p.GetAgent(ctx, podID.UserID, podID.AgentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	for _, e := range pod.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env[k8s.AgentModelEnv] != "anthropic/claude-sonnet-4" || env[k8s.PermissionModeEnv] != "default" {
+		t.Errorf("expected the agent started with the settings, got %v", pod.Spec.Containers[0].Env)
+	}
+}
+
+func TestConfigureAgent_SendsCommandsInOrder(t *testing.T) {
+	svc := &settingsAgentService{}
+	p, _, _ := createQuarantineProcessor(t, svc, QuarantineConfig{})
+	p.models = []string{"anthropic/claude-sonnet-4"}
+
+	if err := p.ConfigureAgent(context.Background(), "user1", "agent1", AgentSettings{Model: "anthropic/claude-sonnet-4", PermissionMode: "acceptEdits"}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if len(svc.commands) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(svc.commands))
+	}
+	if model := svc.commands[0].GetSetModel().GetModel(); model != "anthropic/claude-sonnet-4" {
+		t.Errorf("expected set_model first, got %v", svc.commands[0])
+	}
+	if mode := svc.commands[1].GetSetPermissionMode().GetMode(); mode != "acceptEdits" {
+		t.Errorf("expected set_permission_mode second, got %v", svc.commands[1])
+	}
+}
@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// StatusCacheTTL is how long CachedStatus reuses what an agent last answered
+const StatusCacheTTL = 2 * time.Second
+
+// statusEntry is an agent's answer to a status request, or the error asking it
+type statusEntry struct {
+	status  *agentv1.GetStatusResponse
+	err     error
+	fetched time.Time
+}
+
+// statusCache keeps the agents' latest statuses for StatusCacheTTL, so listing agents with
+// their status does not ask every agent on every request
+type statusCache struct {
+	mu      sync.Mutex
+	entries map[k8s.PodID]statusEntry
+}
+
+// get returns podID's entry unless it is older than StatusCacheTTL at now
+func (c *statusCache) get(podID k8s.PodID, now time.Time) (statusEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[podID]
+	if !ok || now.Sub(entry.fetched) >= StatusCacheTTL {
+		return statusEntry{}, false
+	}
+	return entry, true
+}
+
+// put stores podID's entry, dropping the expired ones
+func (c *statusCache) put(podID k8s.PodID, entry statusEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[k8s.PodID]statusEntry)
+	}
+	for id, e := range c.entries {
+		if entry.fetched.Sub(e.fetched) >= StatusCacheTTL {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[podID] = entry
+}
+
+// forget drops podID's entry, whose status changed
+func (c *statusCache) forget(podID k8s.PodID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, podID)
+}
+
+// CachedStatus returns the agent's real-time status like GetStatus, reusing an answer, or
+// failure, up to StatusCacheTTL old
+func (p *Processor) CachedStatus(ctx context.Context, userID, agentID string) (*agentv1.GetStatusResponse, error) {
+	podID := *k8s.NewPodID(userID, agentID)
+	now := p.now()
+	if entry, ok := p.statuses.get(podID, now); ok {
+		return entry.status, entry.err
+	}
+
+	status, err := p.GetStatus(ctx, userID, agentID)
+	if ctx.Err() != nil {
+		// The caller gave up, which says nothing about the agent
+		return status, err
+	}
+	p.statuses.put(podID, statusEntry{status: status, err: err, fetched: now})
+	return status, err
+}
@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/k8s"
+)
+
+func TestCachedStatus_ConcurrentWithList(t *testing.T) {
+	svc := &statusAgentService{}
+	p, _ := createAgentProcessor(t, svc)
+	podID := *k8s.NewPodID("user1", "agent1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				status, err := p.CachedStatus(ctx, "user1", "agent1")
+				if err != nil {
+					t.Errorf("CachedStatus failed: %v", err)
+					return
+				}
+				_ = status.State
+				_ = status.LatestSeq
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := p.ListAgents(ctx, "user1"); err != nil {
+					t.Errorf("ListAgents failed: %v", err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p.statuses.forget(podID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if svc.probes.Load() == 0 {
+		t.Error("expected the agent to be asked for its status")
+	}
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// LifecycleSubscriberBuffer is how many events a lifecycle subscriber may fall behind by
+// before its oldest unread events are dropped
+const LifecycleSubscriberBuffer = 64
+
+// LifecycleEvent is a change to an agent's lifecycle, as also sent to lifecycle webhooks
+type LifecycleEvent struct {
+	Type    webhook.EventType
+	UserID  string
+	AgentID string
+	// Phase is the agent pod's phase, if known
+	Phase corev1.PodPhase
+	// Reason says why the event happened, e.g. LifecycleReasonShutDown
+	Reason string
+	// CreatedAt is when the agent was created; zero if unknown
+	CreatedAt time.Time
+	At        time.Time
+}
+
+// lifecycleHub fans lifecycle events out to subscribers. Events are published under its
+// lock, so every subscriber sees the events of an agent in the order they happened.
+type lifecycleHub struct {
+	mu   sync.Mutex
+	subs map[*lifecycleSubscriber]struct{}
+}
+
+type lifecycleSubscriber struct {
+	ch chan LifecycleEvent
+}
+
+// subscribe adds a subscriber buffering up to buffer events, removed and closed once ctx
+// ends
+func (h *lifecycleHub) subscribe(ctx context.Context, buffer int) <-chan LifecycleEvent {
+	sub := &lifecycleSubscriber{ch: make(chan LifecycleEvent, max(buffer, 1))}
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*lifecycleSubscriber]struct{})
+	}
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs, sub)
+		close(sub.ch)
+	})
+	return sub.ch
+}
+
+// publish sends event to every subscriber without waiting: a subscriber whose buffer is
+// full loses its oldest unread event instead
+func (h *lifecycleHub) publish(event LifecycleEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		for sent := false; !sent; {
+			select {
+			case sub.ch <- event:
+				sent = true
+			default:
+				select {
+				case <-sub.ch:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// SubscribeLifecycle returns a channel of the lifecycle events of every agent from now
+// on: created, ready, failed and deleted. The events of one agent arrive in order. A
+// subscriber falling LifecycleSubscriberBuffer events behind loses the oldest, so slow
+// subscribers never hold up the agents' operations. The channel is closed once ctx ends.
+func (p *Processor) SubscribeLifecycle(ctx context.Context) <-chan LifecycleEvent {
+	return p.lifecycle.subscribe(ctx, LifecycleSubscriberBuffer)
+}
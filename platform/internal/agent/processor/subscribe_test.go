@@ -0,0 +1,148 @@
+package processor
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// receiveLifecycle reads n events from events, failing the test if they do not arrive
+func receiveLifecycle(t *testing.T, events <-chan LifecycleEvent, n int) []LifecycleEvent {
+	t.Helper()
+	var got []LifecycleEvent
+	for len(got) < n {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d events, got %d", n, len(got))
+		}
+	}
+	return got
+}
+
+func TestSubscribeLifecycle_MultipleSubscribers(t *testing.T) {
+	p := lifecycleProcessor(t, &fakeLifecycleQuerier{}, corev1.PodStatus{
+		Phase:             corev1.PodRunning,
+		PodIP:             "10.0.0.5",
+		ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	first, second := p.SubscribeLifecycle(ctx), p.SubscribeLifecycle(ctx)
+
+	podID, err := p.CreateAgent(context.Background(), "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DeleteAgent(context.Background(), "user1", podID.AgentID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, events := range []<-chan LifecycleEvent{first, second} {
+		got := receiveLifecycle(t, events, 3)
+		want := []webhook.EventType{webhook.EventTypeAgentCreated, webhook.EventTypeAgentReady, webhook.EventTypeAgentDeleted}
+		for i, event := range got {
+			if event.Type != want[i] || event.UserID != "user1" || event.AgentID != podID.AgentID {
+				t.Errorf("event %d: expected %s for %s, got %+v", i, want[i], podID.AgentID, event)
+			}
+		}
+		if got[1].Phase != corev1.PodRunning || got[2].Reason != LifecycleReasonDeleted {
+			t.Errorf("expected the agent's snapshot with each event, got %+v", got)
+		}
+	}
+
+	// Cancelling the subscription closes its channel
+	cancel()
+	for _, events := range []<-chan LifecycleEvent{first, second} {
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Error("expected no more events")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the channel closed")
+		}
+	}
+	if len(p.lifecycle.subs) != 0 {
+		t.Errorf("expected the subscribers removed, got %d", len(p.lifecycle.subs))
+	}
+}
+
+func TestSubscribeLifecycle_SlowSubscriberLosesOldest(t *testing.T) {
+	var hub lifecycleHub
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := hub.subscribe(ctx, 4)
+
+	// Publishing never waits for the subscriber
+	for i := 0; i < 10; i++ {
+		hub.publish(LifecycleEvent{AgentID: "agent1", Reason: strconv.Itoa(i)})
+	}
+
+	for i, event := range receiveLifecycle(t, events, 4) {
+		if want := strconv.Itoa(6 + i); event.Reason != want {
+			t.Errorf("expected the newest events in order, got %s at %d", event.Reason, i)
+		}
+	}
+}
+
+func TestSubscribeLifecycle_FastSubscriberLosesNothing(t *testing.T) {
+	var hub lifecycleHub
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := hub.subscribe(ctx, 16)
+
+	const agents, perAgent = 4, 200
+	done := make(chan map[string][]int)
+	go func() {
+		seen := make(map[string][]int)
+		for n := 0; n < agents*perAgent; n++ {
+			event := <-events
+			i, _ := strconv.Atoi(event.Reason)
+			seen[event.AgentID] = append(seen[event.AgentID], i)
+		}
+		done <- seen
+	}()
+
+	// Publishers stay within the buffer of what the subscriber read, as a fast one would
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for a := 0; a < agents; a++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perAgent; i++ {
+				mu.Lock()
+				for len(events) >= cap(events)-1 {
+					time.Sleep(time.Millisecond)
+				}
+				hub.publish(LifecycleEvent{AgentID: "agent" + strconv.Itoa(a), Reason: strconv.Itoa(i)})
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var seen map[string][]int
+	select {
+	case seen = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected every event received")
+	}
+	for agent, seqs := range seen {
+		if len(seqs) != perAgent {
+			t.Errorf("%s: expected %d events, got %d", agent, perAgent, len(seqs))
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("%s: expected its events in order, got %d at %d", agent, seq, i)
+			}
+		}
+	}
+}
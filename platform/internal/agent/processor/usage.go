@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// usageRegistry keeps the usage of the requests in flight through the processor, which
+// Usage reports as pending until they end
+type usageRegistry struct {
+	mu       sync.Mutex
+	requests map[string]*requestUsage
+}
+
+// requestUsage is the usage of one request in flight
+type requestUsage struct {
+	userID  string
+	agentID string
+	start   time.Time
+	tracker *webhook.UsageTracker
+}
+
+// trackUsage registers requestID as in flight until the returned func is called, which
+// stores the usage the tracker observed, unless the agent's model used nothing. It stores
+// it even if ctx was cancelled.
+func (p *Processor) trackUsage(ctx context.Context, userID, agentID, requestID string) (*webhook.UsageTracker, func()) {
+	u := &requestUsage{
+		userID:  userID,
+		agentID: agentID,
+		start:   p.now(),
+		tracker: webhook.NewUsageTracker(),
+	}
+
+	p.usage.mu.Lock()
+	if p.usage.requests == nil {
+		p.usage.requests = make(map[string]*requestUsage)
+	}
+	p.usage.requests[requestID] = u
+	p.usage.mu.Unlock()
+
+	return u.tracker, func() {
+		p.usage.mu.Lock()
+		if p.usage.requests[requestID] == u {
+			delete(p.usage.requests, requestID)
+		}
+		p.usage.mu.Unlock()
+
+		if p.webhookDelivery == nil || u.tracker.Total().IsZero() {
+			return
+		}
+		usage := u.total(p.now())
+		if err := p.webhookDelivery.RecordUsage(context.WithoutCancel(ctx), userID, agentID, requestID, usage, u.start); err != nil {
+			p.logger.Warn("failed to store request usage",
+				zap.Error(err),
+				zap.String("agent_id", agentID),
+				zap.String("request_id", requestID),
+			)
+			return
+		}
+		p.addBudgetSpend(userID, usage.CostUSD, u.start)
+	}
+}
+
+// TrackUsage registers a request sent to the agent over a stream the processor does not
+// read, e.g. a client's WebSocket, as trackUsage does for the requests it sends itself.
+// The caller observes the request's payloads with the tracker and calls the returned func
+// once the request ends.
+func (p *Processor) TrackUsage(ctx context.Context, userID, agentID, requestID string) (*webhook.UsageTracker, func()) {
+	return p.trackUsage(ctx, userID, agentID, requestID)
+}
+
+// total returns the request's usage so far, as of now
+func (u *requestUsage) total(now time.Time) webhook.Usage {
+	usage := u.tracker.Total()
+	usage.DurationMs = now.Sub(u.start).Milliseconds()
+	return usage
+}
+
+// Usage returns the usage of a user's requests made in [from, to), summed per agent or per
+// UTC day depending on groupBy (see webhook.DeliveryService.SumUsage), followed by a pending
+// entry for each of those requests still in progress, oldest first
+func (p *Processor) Usage(ctx context.Context, userID string, from, to time.Time, groupBy string) ([]webhook.UsageEntry, error) {
+	entries := []webhook.UsageEntry{}
+	if p.webhookDelivery != nil {
+		stored, err := p.webhookDelivery.SumUsage(ctx, userID, from, to, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, stored...)
+	}
+
+	now := p.now()
+	p.usage.mu.Lock()
+	type pending struct {
+		start time.Time
+		entry webhook.UsageEntry
+	}
+	var inFlight []pending
+	for requestID, u := range p.usage.requests {
+		if u.userID != userID || u.start.Before(from) || !u.start.Before(to) {
+			continue
+		}
+		inFlight = append(inFlight, pending{start: u.start, entry: webhook.UsageEntry{
+			AgentID:   u.agentID,
+			Day:       u.start.UTC().Format(webhook.UsageDayFormat),
+			RequestID: requestID,
+			Requests:  1,
+			Pending:   true,
+			Usage:     u.total(now),
+		}})
+	}
+	p.usage.mu.Unlock()
+
+	sort.Slice(inFlight, func(i, j int) bool {
+		if !inFlight[i].start.Equal(inFlight[j].start) {
+			return inFlight[i].start.Before(inFlight[j].start)
+		}
+		return inFlight[i].entry.RequestID < inFlight[j].entry.RequestID
+	})
+	for _, pending := range inFlight {
+		entries = append(entries, pending.entry)
+	}
+	return entries, nil
+}
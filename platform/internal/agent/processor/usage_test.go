@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+func (f *fakeStreamQuerier) UpsertRequestUsage(_ context.Context, arg *sqlc.UpsertRequestUsageParams) error {
+	f.usage = append(f.usage, arg)
+	return nil
+}
+
+func (f *fakeStreamQuerier) SumRequestUsage(context.Context, *sqlc.SumRequestUsageParams) ([]*sqlc.SumRequestUsageRow, error) {
+	return []*sqlc.SumRequestUsageRow{}, nil
+}
+
+// usageEvent returns an OpenCode message.updated event for an assistant message
+func usageEvent(seq uint64, messageID string, input, output int64, cost float64) *agentv1.AgentResponse {
+	event := fmt.Sprintf(`{"type":"message.updated","properties":{"info":{"id":%q,"role":"assistant","cost":%g,"tokens":{"input":%d,"output":%d,"cache":{"read":5,"write":0}}}}}`,
+		messageID, cost, input, output)
+	return &agentv1.AgentResponse{
+		Seq:     seq,
+		Payload: &agentv1.AgentResponse_Event{Event: &agentv1.EventPayload{EventType: "message.updated", EventJson: []byte(event)}},
+	}
+}
+
+func TestStreamToWebhook_RecordsUsage(t *testing.T) {
+	querier := &fakeStreamQuerier{}
+	p := NewProcessor(createTestK8sManager(t), webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	responses := []*agentv1.AgentResponse{
+		usageEvent(1, "msg-1", 100, 10, 0.01),
+		usageEvent(2, "msg-1", 100, 30, 0.02),
+		usageEvent(3, "msg-2", 50, 5, 0.005),
+		{Seq: 4, Payload: &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}},
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stream := &agentStream{bidiStream: &fakeStream{responses: responses, err: io.EOF}, ctx: ctx, cancel: cancel}
+	if err := p.streamToWebhook(context.Background(), stream, "user1", "agent1", "req-1", webhook.Config{URL: "https://example.com/hook"}); err != nil {
+		t.Fatalf("streamToWebhook: %v", err)
+	}
+
+	if len(querier.usage) != 1 {
+		t.Fatalf("expected the request's usage stored once, got %d rows", len(querier.usage))
+	}
+	got := querier.usage[0]
+	if got.RequestID != "req-1" || got.UserID != "user1" || got.AgentID != "agent1" ||
+		got.InputTokens != 150 || got.OutputTokens != 35 || got.CacheReadTokens != 10 ||
+		got.CostUsd != 0.025 || got.DurationMs <= 0 || !got.CreatedAt.Equal(time.Date(2024, 3, 1, 12, 0, 1, 0, time.UTC)) {
+		t.Errorf("unexpected usage stored: %+v", got)
+	}
+}
+
+func TestUsage_ReportsRequestsInFlightAsPending(t *testing.T) {
+	querier := &fakeStreamQuerier{}
+	p := NewProcessor(createTestK8sManager(t), webhook.NewDeliveryServiceWithQuerier(querier, &config.Config{}, zap.NewNop()), zap.NewNop())
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stream := &agentStream{
+		bidiStream: &ctxStream{fakeStream: fakeStream{responses: []*agentv1.AgentResponse{usageEvent(1, "msg-1", 100, 10, 0.01)}}, ctx: ctx},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.streamToWebhook(context.Background(), stream, "user1", "agent1", "req-1", webhook.Config{URL: "https://example.com/hook"})
+	}()
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	var entries []webhook.UsageEntry
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		if entries, err = p.Usage(context.Background(), "user1", from, to, webhook.UsageGroupByAgent); err != nil {
+			t.Fatalf("Usage: %v", err)
+		}
+		if len(entries) == 1 && entries[0].InputTokens > 0 {
+			break
+		}
+	}
+	if len(entries) != 1 || !entries[0].Pending || entries[0].RequestID != "req-1" || entries[0].AgentID != "agent1" ||
+		entries[0].Requests != 1 || entries[0].InputTokens != 100 || entries[0].OutputTokens != 10 {
+		t.Fatalf("expected req-1 pending with its usage so far, got %+v", entries)
+	}
+
+	if entries, _ := p.Usage(context.Background(), "user2", from, to, webhook.UsageGroupByAgent); len(entries) != 0 {
+		t.Errorf("expected no entries for another user, got %+v", entries)
+	}
+
+	cancel(context.Canceled)
+	<-done
+	if entries, _ := p.Usage(context.Background(), "user1", from, to, webhook.UsageGroupByAgent); len(entries) != 0 {
+		t.Errorf("expected no pending entries once the request ended, got %+v", entries)
+	}
+	if len(querier.usage) != 1 || querier.usage[0].InputTokens != 100 {
+		t.Errorf("expected the partial usage stored once the request ended, got %+v", querier.usage)
+	}
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ProtocolVersion is the newest agent protocol version this platform understands.
+// Agents report the version they speak in GetStatusResponse.protocol_version;
+// agents built before versioning report 0.
+//
+// Versions:
+//
+//	1: baseline (Connect, GetStatus, Shutdown, untargeted interrupt)
+//	2: checkpoints, targeted interrupt, session resume
+const ProtocolVersion int32 = 1
+
+// DefaultMinProtocolVersion is the oldest agent protocol version accepted by default
+const DefaultMinProtocolVersion int32 = 1
+
+// ErrorCodeUnsupportedByAgent is the error code reported to consumers when an agent
+// is too old for the platform or for a requested feature
+const ErrorCodeUnsupportedByAgent = "UNSUPPORTED_BY_AGENT"
+
+// Feature is an optional agent capability gated on the agent's protocol version
+type Feature string
+
+const (
+	FeatureCheckpoint        Feature = "checkpoint"
+	FeatureTargetedInterrupt Feature = "targeted_interrupt"
+	FeatureSessionResume     Feature = "session_resume"
+)
+
+// featureVersions maps each optional feature to the first protocol version that supports it
+var featureVersions = map[Feature]int32{
+	FeatureCheckpoint:        2,
+	FeatureTargetedInterrupt: 2,
+	FeatureSessionResume:     2,
+}
+
+// UnsupportedError is returned when an agent's protocol version is below what the
+// platform or a feature requires
+type UnsupportedError struct {
+	// Feature is empty when the agent is below the platform's minimum version
+	Feature         Feature
+	AgentVersion    int32
+	RequiredVersion int32
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Feature == "" {
+		return fmt.Sprintf("agent protocol version %d is not supported (requires %d or newer)", e.AgentVersion, e.RequiredVersion)
+	}
+	return fmt.Sprintf("agent protocol version %d does not support %s (requires %d or newer)", e.AgentVersion, e.Feature, e.RequiredVersion)
+}
+
+// IsUnsupported reports whether err is, or wraps, an UnsupportedError
+func IsUnsupported(err error) bool {
+	var unsupported *UnsupportedError
+	return errors.As(err, &unsupported)
+}
+
+// CheckCompatible returns an UnsupportedError if version is older than minVersion
+func CheckCompatible(version, minVersion int32) error {
+	if version < minVersion {
+		return &UnsupportedError{AgentVersion: version, RequiredVersion: minVersion}
+	}
+	return nil
+}
+
+// CheckFeature returns an UnsupportedError if an agent speaking version lacks feature.
+// Unknown features are reported as an error.
+func CheckFeature(version int32, feature Feature) error {
+	required, ok := featureVersions[feature]
+	if !ok {
+		return fmt.Errorf("unknown agent feature %q", feature)
+	}
+	if version < required {
+		return &UnsupportedError{Feature: feature, AgentVersion: version, RequiredVersion: required}
+	}
+	return nil
+}
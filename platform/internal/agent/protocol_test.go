@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCheckFeature(t *testing.T) {
+	features := []Feature{FeatureCheckpoint, FeatureTargetedInterrupt, FeatureSessionResume}
+
+	for _, feature := range features {
+		for version := int32(0); version <= 3; version++ {
+			err := CheckFeature(version, feature)
+			wantSupported := version >= featureVersions[feature]
+			if wantSupported && err != nil {
+				t.Errorf("%s at version %d: unexpected error %v", feature, version, err)
+			}
+			if !wantSupported {
+				var unsupported *UnsupportedError
+				if !errors.As(err, &unsupported) {
+					t.Fatalf("%s at version %d: expected UnsupportedError, got %v", feature, version, err)
+				}
+				if unsupported.Feature != feature || unsupported.AgentVersion != version || unsupported.RequiredVersion != featureVersions[feature] {
+					t.Errorf("unexpected error fields: %+v", unsupported)
+				}
+			}
+		}
+	}
+
+	if err := CheckFeature(ProtocolVersion, "teleport"); err == nil || IsUnsupported(err) {
+		t.Errorf("expected a plain error for an unknown feature, got %v", err)
+	}
+}
+
+func TestCheckCompatible(t *testing.T) {
+	if err := CheckCompatible(1, 1); err != nil {
+		t.Errorf("expected version at the minimum to be accepted, got %v", err)
+	}
+	if err := CheckCompatible(0, 0); err != nil {
+		t.Errorf("expected legacy agents to be accepted with minimum 0, got %v", err)
+	}
+
+	err := CheckCompatible(0, 1)
+	if !IsUnsupported(err) {
+		t.Fatalf("expected UnsupportedError, got %v", err)
+	}
+	if want := "agent protocol version 0 is not supported (requires 1 or newer)"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+	if !IsUnsupported(fmt.Errorf("connecting: %w", err)) {
+		t.Error("expected IsUnsupported to see through wrapping")
+	}
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+)
+
+// Defaults of AGENT_RPC_TIMEOUT and AGENT_RPC_RETRIES
+const (
+	DefaultRPCTimeout = 10 * time.Second
+	DefaultRPCRetries = 2
+)
+
+// Retries of an RPC wait rpcRetryBase, doubling with each retry up to rpcRetryMaxDelay,
+// the upper half of each delay randomized so calls failing together spread out
+const (
+	rpcRetryBase     = 100 * time.Millisecond
+	rpcRetryMaxDelay = 2 * time.Second
+)
+
+// idempotentProcedures are the unary RPCs safe to send again after a failure. Shutdown is
+// not: an agent that shut down without answering would be told twice.
+var idempotentProcedures = map[string]bool{
+	agentv1connect.AgentServiceGetStatusProcedure: true,
+	agentv1connect.AgentServiceCatchUpProcedure:   true,
+}
+
+// RetryInterceptor returns a client interceptor bounding each attempt of a unary RPC to
+// timeout (0 = no bound beyond the caller's context), and retrying GetStatus and CatchUp
+// up to retries times when the agent is unavailable or an attempt times out. A caller's
+// shorter deadline still applies, and no retry is made that could not finish before it.
+// Streams are left alone, as they live as long as their callers want.
+func RetryInterceptor(timeout time.Duration, retries int) connect.Interceptor {
+	return &retryInterceptor{timeout: timeout, retries: max(retries, 0), rand: rand.Float64}
+}
+
+type retryInterceptor struct {
+	timeout time.Duration
+	retries int
+	// rand returns a float in [0, 1); replaced in tests
+	rand func() float64
+}
+
+func (i *retryInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if !req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		retries := 0
+		if idempotentProcedures[req.Spec().Procedure] {
+			retries = i.retries
+		}
+		for retry := 1; ; retry++ {
+			resp, err := i.attempt(ctx, next, req)
+			if err == nil || retry > retries || ctx.Err() != nil || !isRetryableRPCError(err) {
+				return resp, err
+			}
+			delay := i.delay(retry)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+				return resp, err
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return resp, err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// attempt sends req once, within the per-attempt timeout
+func (i *retryInterceptor) attempt(ctx context.Context, next connect.UnaryFunc, req connect.AnyRequest) (connect.AnyResponse, error) {
+	if i.timeout <= 0 {
+		return next(ctx, req)
+	}
+	ctx, cancel := context.WithTimeout(ctx, i.timeout)
+	defer cancel()
+	return next(ctx, req)
+}
+
+// delay returns how long to wait before retry number retry (1 for the first retry)
+func (i *retryInterceptor) delay(retry int) time.Duration {
+	exp := min(rpcRetryBase<<min(retry-1, 16), rpcRetryMaxDelay)
+	return exp/2 + time.Duration(i.rand()*float64(exp/2))
+}
+
+func (*retryInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (*retryInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+// isRetryableRPCError reports whether an RPC failing with err may succeed if sent again:
+// the agent could not be reached, or took too long to answer
+func isRetryableRPCError(err error) bool {
+	code := connect.CodeOf(err)
+	return code == connect.CodeUnavailable || code == connect.CodeDeadlineExceeded
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+)
+
+// flakyAgentService fails its first failures calls with code, then answers. With hang
+// set, it never answers unary calls until they are cancelled.
+type flakyAgentService struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	failures int32
+	code     connect.Code
+	hang     bool
+	calls    atomic.Int32
+}
+
+func (s *flakyAgentService) answer(ctx context.Context) error {
+	n := s.calls.Add(1)
+	if s.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if n <= s.failures {
+		return connect.NewError(s.code, errors.New("flaky"))
+	}
+	return nil
+}
+
+func (s *flakyAgentService) GetStatus(
+	ctx context.Context,
+	req *connect.Request[agentv1.GetStatusRequest],
+) (*connect.Response[agentv1.GetStatusResponse], error) {
+	if err := s.answer(ctx); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&agentv1.GetStatusResponse{AgentId: "test-agent"}), nil
+}
+
+func (s *flakyAgentService) Shutdown(
+	ctx context.Context,
+	req *connect.Request[agentv1.ShutdownRequest],
+) (*connect.Response[agentv1.ShutdownResponse], error) {
+	if err := s.answer(ctx); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&agentv1.ShutdownResponse{Success: true}), nil
+}
+
+func (s *flakyAgentService) Connect(
+	ctx context.Context,
+	stream *connect.BidiStream[agentv1.AgentRequest, agentv1.AgentResponse],
+) error {
+	// Answer later than the unary timeout
+	time.Sleep(100 * time.Millisecond)
+	return stream.Send(&agentv1.AgentResponse{RequestId: "req-1"})
+}
+
+func newFlakyClient(t *testing.T, svc *flakyAgentService, timeout time.Duration, retries int) agentv1connect.AgentServiceClient {
+	mux := http.NewServeMux()
+	mux.Handle(agentv1connect.NewAgentServiceHandler(svc))
+	server := newH2CServer(mux)
+	t.Cleanup(server.Close)
+	return NewClient(server.URL, RetryInterceptor(timeout, retries))
+}
+
+func TestRetryInterceptor_RetriesUnavailable(t *testing.T) {
+	svc := &flakyAgentService{failures: 2, code: connect.CodeUnavailable}
+	client := newFlakyClient(t, svc, time.Second, 2)
+
+	resp, err := client.GetStatus(context.Background(), connect.NewRequest(&agentv1.GetStatusRequest{}))
+	if err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if resp.Msg.AgentId != "test-agent" || svc.calls.Load() != 3 {
+		t.Errorf("expected 3 calls and the agent's answer, got %d calls and %+v", svc.calls.Load(), resp.Msg)
+	}
+}
+
+func TestRetryInterceptor_GivesUp(t *testing.T) {
+	svc := &flakyAgentService{failures: 2, code: connect.CodeUnavailable}
+	client := newFlakyClient(t, svc, time.Second, 1)
+
+	_, err := client.GetStatus(context.Background(), connect.NewRequest(&agentv1.GetStatusRequest{}))
+	if connect.CodeOf(err) != connect.CodeUnavailable || svc.calls.Load() != 2 {
+		t.Errorf("expected Unavailable after 2 calls, got %v after %d", err, svc.calls.Load())
+	}
+}
+
+func TestRetryInterceptor_DoesNotRetryOtherErrors(t *testing.T) {
+	svc := &flakyAgentService{failures: 2, code: connect.CodeInternal}
+	client := newFlakyClient(t, svc, time.Second, 2)
+
+	_, err := client.GetStatus(context.Background(), connect.NewRequest(&agentv1.GetStatusRequest{}))
+	if connect.CodeOf(err) != connect.CodeInternal || svc.calls.Load() != 1 {
+		t.Errorf("expected Internal after 1 call, got %v after %d", err, svc.calls.Load())
+	}
+}
+
+func TestRetryInterceptor_NeverRetriesShutdown(t *testing.T) {
+	svc := &flakyAgentService{failures: 2, code: connect.CodeUnavailable}
+	client := newFlakyClient(t, svc, time.Second, 2)
+
+	_, err := client.Shutdown(context.Background(), connect.NewRequest(&agentv1.ShutdownRequest{}))
+	if connect.CodeOf(err) != connect.CodeUnavailable || svc.calls.Load() != 1 {
+		t.Errorf("expected Unavailable after 1 call, got %v after %d", err, svc.calls.Load())
+	}
+}
+
+func TestRetryInterceptor_TimesOutHangingAgent(t *testing.T) {
+	svc := &flakyAgentService{hang: true}
+	client := newFlakyClient(t, svc, 50*time.Millisecond, 2)
+
+	start := time.Now()
+	_, err := client.GetStatus(context.Background(), connect.NewRequest(&agentv1.GetStatusRequest{}))
+	if connect.CodeOf(err) != connect.CodeDeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if svc.calls.Load() != 3 {
+		t.Errorf("expected every attempt to time out, got %d calls", svc.calls.Load())
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("expected the hanging agent to be given up on, took %v", took)
+	}
+}
+
+func TestRetryInterceptor_RespectsShorterCallerDeadline(t *testing.T) {
+	svc := &flakyAgentService{hang: true}
+	client := newFlakyClient(t, svc, 10*time.Second, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{}))
+	if connect.CodeOf(err) != connect.CodeDeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if took := time.Since(start); took > time.Second || svc.calls.Load() != 1 {
+		t.Errorf("expected one attempt ending with the caller's deadline, got %d calls in %v", svc.calls.Load(), took)
+	}
+}
+
+func TestRetryInterceptor_LeavesStreamsAlone(t *testing.T) {
+	svc := &flakyAgentService{}
+	client := newFlakyClient(t, svc, 20*time.Millisecond, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := client.Connect(ctx)
+	if err := stream.Send(&agentv1.AgentRequest{RequestId: "req-1"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	resp, err := stream.Receive()
+	if err != nil || resp.RequestId != "req-1" {
+		t.Errorf("expected the stream to outlive the unary timeout, got %+v, %v", resp, err)
+	}
+	_ = stream.CloseRequest()
+	_ = stream.CloseResponse()
+}
+
+func TestRetryInterceptor_Delay(t *testing.T) {
+	i := &retryInterceptor{rand: func() float64 { return 0 }}
+	for retry, want := range map[int]time.Duration{1: 50 * time.Millisecond, 2: 100 * time.Millisecond, 10: time.Second, 100: time.Second} {
+		if got := i.delay(retry); got != want {
+			t.Errorf("retry %d: expected the delay to start at %v, got %v", retry, want, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caarlos0/env/v11"
+)
+
+// defaults returns the config of an empty environment
+func defaults(t *testing.T) *Config {
+	t.Helper()
+	cfg := &Config{}
+	if err := env.ParseWithOptions(cfg, env.Options{Environment: map[string]string{}}); err != nil {
+		t.Fatalf("failed to parse defaults: %v", err)
+	}
+	return cfg
+}
+
+func TestValidate_DefaultsAreValid(t *testing.T) {
+	if err := defaults(t).Validate(); err != nil {
+		t.Errorf("expected the defaults to be valid, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string // empty if valid
+	}{
+		{"port zero", func(c *Config) { c.Port = 0 }, "PORT must be between 1 and 65535"},
+		{"port too high", func(c *Config) { c.Port = 70000 }, "PORT must be between 1 and 65535"},
+		{"no shutdown timeout", func(c *Config) { c.ShutdownTimeout = 0 }, "SHUTDOWN_TIMEOUT must be positive"},
+		{"negative timeout", func(c *Config) { c.ReadTimeout = -time.Second }, "READ_TIMEOUT must not be negative"},
+		{"negative count", func(c *Config) { c.WebhookMaxRetries = -1 }, "WEBHOOK_MAX_RETRIES must not be negative"},
+		{"no webhook workers", func(c *Config) { c.WebhookWorkers = 0 }, "WEBHOOK_WORKERS must be at least 1"},
+		{"min conns above max", func(c *Config) { c.DBMinConns = 30 }, "DB_MIN_CONNS (30) must not exceed DB_MAX_CONNS (25)"},
+		{"negative kube client qps", func(c *Config) { c.KubeClientQPS = -1 }, "KUBE_CLIENT_QPS must not be negative"},
+		{"negative pod cache check interval", func(c *Config) { c.AgentPodCacheCheckInterval = -time.Minute }, "AGENT_POD_CACHE_CHECK_INTERVAL must not be negative"},
+		{"unknown orchestrator", func(c *Config) { c.Orchestrator = "nomad" }, `ORCHESTRATOR must be kubernetes or local, got "nomad"`},
+		{"local orchestrator without binary", func(c *Config) { c.Orchestrator = "local" }, "LOCAL_AGENT_BINARY must be set when ORCHESTRATOR is local"},
+		{"local orchestrator", func(c *Config) { c.Orchestrator, c.LocalAgentBinary = "local", "/usr/local/bin/agent" }, ""},
+		{"node host with kubeconfig", func(c *Config) { c.NodeHost, c.KubeConfigPath = "localhost", "/kube/config" }, ""},
+		{"pong before ping", func(c *Config) { c.WSPongTimeout = 10 * time.Second }, "WS_PONG_TIMEOUT (10s) must exceed WS_PING_INTERVAL (30s)"},
+		{"unknown overflow policy", func(c *Config) { c.WSOverflowPolicy = "block" }, `WS_OVERFLOW_POLICY must be drop_oldest or disconnect, got "block"`},
+		{"retry base above max", func(c *Config) { c.WebhookRetryBase = 2 * time.Minute }, "WEBHOOK_RETRY_BASE (2m0s) must not exceed"},
+		{"shrinking retries", func(c *Config) { c.WebhookRetryMultiplier = 0.5 }, "WEBHOOK_RETRY_MULTIPLIER must be at least 1"},
+		{"jitter above 1", func(c *Config) { c.WebhookRetryJitter = 1.5 }, "WEBHOOK_RETRY_JITTER must be between 0 and 1"},
+		{"no body limit", func(c *Config) { c.MessageBodyMaxBytes = 0 }, "MESSAGE_BODY_MAX_BYTES must be positive"},
+		{"no purge interval", func(c *Config) { c.WebhookPurgeInterval = 0 }, "WEBHOOK_PURGE_INTERVAL must be positive"},
+		{"negative rate limit", func(c *Config) { c.WebhookRateLimitRPS = -1 }, "WEBHOOK_RATE_LIMIT_RPS must not be negative"},
+		{"no rate burst", func(c *Config) { c.WebhookRateBurst = 0 }, "WEBHOOK_RATE_BURST must be at least 1"},
+		{"negative dead letter rate", func(c *Config) { c.WebhookDeadLetterRateFailing = -1 }, "WEBHOOK_DEAD_LETTER_RATE_FAILING must not be negative"},
+		{"cors wildcard and origins", func(c *Config) {
+			c.CORSAllowedOrigins = []string{"*", "https://app.example.com", "http://localhost:3000"}
+		}, ""},
+		{"cors garbage", func(c *Config) { c.CORSAllowedOrigins = []string{"app.example.com"} }, `CORS_ORIGINS entry "app.example.com"`},
+		{"cors with path", func(c *Config) { c.CORSAllowedOrigins = []string{"https://app.example.com/login"} }, "CORS_ORIGINS entry"},
+		{"allowed hosts", func(c *Config) { c.WebhookAllowedHosts = []string{"hooks.internal", "10.0.0.7", "::1"} }, ""},
+		{"allowed host with scheme", func(c *Config) { c.WebhookAllowedHosts = []string{"https://hooks.internal"} }, `WEBHOOK_ALLOWED_HOSTS entry "https://hooks.internal"`},
+		{"blocked cidrs", func(c *Config) { c.WebhookBlockedCIDRs = []string{"10.96.0.0/12", "192.168.1.1", "fd00::/8"} }, ""},
+		{"blocked cidr garbage", func(c *Config) { c.WebhookBlockedCIDRs = []string{"10.96.0.0/40"} }, `WEBHOOK_BLOCKED_CIDRS entry "10.96.0.0/40"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaults(t)
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected valid config, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidate_ListsEveryProblem(t *testing.T) {
+	cfg := defaults(t)
+	cfg.Port = -1
+	cfg.ShutdownTimeout = 0
+	cfg.WSOverflowPolicy = ""
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected one line per problem, got %q", err)
+	}
+	if err.Error() != cfg.Validate().Error() {
+		t.Error("expected the problems in a stable order")
+	}
+}
+
+func TestNew_FailsOnInvalidConfig(t *testing.T) {
+	t.Setenv("PORT", "0")
+	t.Setenv("SHUTDOWN_TIMEOUT", "0s")
+	_, err := New()
+	if err == nil || !strings.Contains(err.Error(), "PORT") || !strings.Contains(err.Error(), "SHUTDOWN_TIMEOUT") {
+		t.Errorf("expected New to fail listing both problems, got %v", err)
+	}
+}
@@ -16,32 +16,90 @@ var Module = fx.Module("db",
 	fx.Provide(NewPool),
 )
 
-// NewPool creates a new PostgreSQL connection pool
-func NewPool(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) (*pgxpool.Pool, error) {
+// PoolConfig returns the pool configuration for cfg: its database URL with the configured
+// pool sizing and timeouts
+func PoolConfig(cfg *config.Config) (*pgxpool.Config, error) {
 	poolCfg, err := pgxpool.ParseConfig(cfg.DatabaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("parsing database URL: %w", err)
 	}
+	if cfg.DBMaxConns > 0 && cfg.DBMinConns > cfg.DBMaxConns {
+		return nil, fmt.Errorf("DB_MIN_CONNS (%d) is above DB_MAX_CONNS (%d)", cfg.DBMinConns, cfg.DBMaxConns)
+	}
+
+	// Settings left zero keep pgx's defaults
+	if cfg.DBMaxConns > 0 {
+		poolCfg.MaxConns = cfg.DBMaxConns
+	}
+	poolCfg.MinConns = cfg.DBMinConns
+	if cfg.DBMaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.DBMaxConnLifetime
+	}
+	if cfg.DBHealthCheckPeriod > 0 {
+		poolCfg.HealthCheckPeriod = cfg.DBHealthCheckPeriod
+	}
+	if cfg.DBConnectTimeout > 0 {
+		poolCfg.ConnConfig.ConnectTimeout = cfg.DBConnectTimeout
+	}
+	return poolCfg, nil
+}
 
-	// Configure pool settings
-	poolCfg.MaxConns = 25
-	poolCfg.MinConns = 5
+// NewPool creates a new PostgreSQL connection pool. Startup fails if the database cannot
+// be reached within DB_CONNECT_TIMEOUT, unless DB_LAZY_CONNECT is set: then the pool
+// connects once the database is up, and /readyz reports it down meanwhile. With
+// AUTO_MIGRATE, pending migrations are applied before the pool is returned.
+func NewPool(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) (*pgxpool.Pool, error) {
+	poolCfg, err := PoolConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("database pool settings",
+		zap.Int32("max_conns", poolCfg.MaxConns),
+		zap.Int32("min_conns", poolCfg.MinConns),
+		zap.Duration("max_conn_lifetime", poolCfg.MaxConnLifetime),
+		zap.Duration("health_check_period", poolCfg.HealthCheckPeriod),
+		zap.Duration("connect_timeout", poolCfg.ConnConfig.ConnectTimeout),
+		zap.Bool("lazy_connect", cfg.DBLazyConnect),
+	)
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
 	if err != nil {
 		return nil, fmt.Errorf("creating connection pool: %w", err)
 	}
 
-	// Verify connection
-	if err := pool.Ping(context.Background()); err != nil {
-		pool.Close()
-		return nil, fmt.Errorf("pinging database: %w", err)
+	// Verify connection, within the connect timeout if there is one
+	ctx := context.Background()
+	if timeout := poolCfg.ConnConfig.ConnectTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	connected := true
+	if err := pool.Ping(ctx); err != nil {
+		if !cfg.DBLazyConnect {
+			pool.Close()
+			return nil, fmt.Errorf("database at %s:%d not reachable within %s: %w",
+				poolCfg.ConnConfig.Host, poolCfg.ConnConfig.Port, poolCfg.ConnConfig.ConnectTimeout, err)
+		}
+		connected = false
+		logger.Warn("database not reachable, starting without it", zap.Error(err))
+	} else {
+		logger.Info("connected to database")
 	}
 
-	logger.Info("connected to database",
-		zap.Int32("max_conns", poolCfg.MaxConns),
-		zap.Int32("min_conns", poolCfg.MinConns),
-	)
+	// Migrations are applied before any consumer gets the pool
+	if cfg.AutoMigrate {
+		if !connected {
+			logger.Warn("skipping AUTO_MIGRATE while the database is not reachable; run `server migrate up` once it is")
+		} else {
+			n, err := Migrate(context.Background(), pool, logger)
+			if err != nil {
+				pool.Close()
+				return nil, fmt.Errorf("migrating database: %w", err)
+			}
+			logger.Info("database migrated", zap.Int("applied", n))
+		}
+	}
 
 	lc.Append(fx.Hook{
 		OnStop: func(ctx context.Context) error {
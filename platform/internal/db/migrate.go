@@ -0,0 +1,267 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/migrations"
+)
+
+// versionTable records applied migrations. It is the table goose uses, so databases
+// migrated with the goose CLI and with the binary agree on what has been applied.
+const versionTable = "goose_db_version"
+
+// migrationLockID is the advisory lock held while migrating, so that replicas starting
+// together with AUTO_MIGRATE apply each migration once
+const migrationLockID = 7_283_649_151
+
+// Migration is one schema change, read from a goose SQL file named "<version>_<name>.sql"
+// with "-- +goose Up" and "-- +goose Down" sections
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// LoadMigrations reads the migrations in the root of fsys, ordered by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []Migration
+	for _, file := range files {
+		version, name, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), "_")
+		v, err := strconv.ParseInt(version, 10, 64)
+		if !ok || err != nil || v <= 0 {
+			return nil, fmt.Errorf("migration %s: name is not <version>_<name>.sql", file)
+		}
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		up, down, err := parseMigration(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", file, err)
+		}
+		loaded = append(loaded, Migration{Version: v, Name: name, Up: up, Down: down})
+	}
+
+	slices.SortFunc(loaded, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+	for i := 1; i < len(loaded); i++ {
+		if loaded[i].Version == loaded[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", loaded[i-1].Name, loaded[i].Name)
+		}
+	}
+	return loaded, nil
+}
+
+// parseMigration splits a migration file into its Up and Down sections
+func parseMigration(content string) (up, down string, err error) {
+	var section *strings.Builder
+	var upSQL, downSQL strings.Builder
+	for line := range strings.Lines(content) {
+		switch annotation := strings.TrimSpace(line); {
+		case annotation == "-- +goose Up":
+			section = &upSQL
+		case annotation == "-- +goose Down":
+			section = &downSQL
+		case strings.HasPrefix(annotation, "-- +goose StatementBegin"), strings.HasPrefix(annotation, "-- +goose StatementEnd"):
+			// Statements are sent as one multi-statement query, so need no delimiting
+		case strings.HasPrefix(annotation, "-- +goose"):
+			return "", "", fmt.Errorf("unsupported annotation %q", annotation)
+		case section != nil:
+			section.WriteString(line)
+		}
+	}
+	if strings.TrimSpace(upSQL.String()) == "" {
+		return "", "", fmt.Errorf("no -- +goose Up section")
+	}
+	return upSQL.String(), downSQL.String(), nil
+}
+
+// migrationStore is the database migrations are applied to
+type migrationStore interface {
+	// AppliedVersions returns the versions of the migrations applied
+	AppliedVersions(ctx context.Context) (map[int64]bool, error)
+	// Apply runs sql and records version as applied, or as not applied if up is false,
+	// in one transaction
+	Apply(ctx context.Context, version int64, sql string, up bool) error
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	store      migrationStore
+	migrations []Migration
+	logger     *zap.Logger
+}
+
+// NewMigrator creates a Migrator applying migrations to store
+func NewMigrator(store migrationStore, migrations []Migration, logger *zap.Logger) *Migrator {
+	return &Migrator{store: store, migrations: migrations, logger: logger}
+}
+
+// Up applies the pending migrations in order, returning how many it applied. Running it
+// again once they are applied does nothing.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied, err := m.store.AppliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, mig := range m.migrations {
+		if applied[mig.Version] {
+			continue
+		}
+		if err := m.store.Apply(ctx, mig.Version, mig.Up, true); err != nil {
+			return n, fmt.Errorf("applying migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		m.logger.Info("applied migration", zap.Int64("version", mig.Version), zap.String("name", mig.Name))
+		n++
+	}
+	return n, nil
+}
+
+// Down rolls back the latest applied migration, returning false if none is applied
+func (m *Migrator) Down(ctx context.Context) (bool, error) {
+	applied, err := m.store.AppliedVersions(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, mig := range slices.Backward(m.migrations) {
+		if !applied[mig.Version] {
+			continue
+		}
+		if err := m.store.Apply(ctx, mig.Version, mig.Down, false); err != nil {
+			return false, fmt.Errorf("rolling back migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		m.logger.Info("rolled back migration", zap.Int64("version", mig.Version), zap.String("name", mig.Name))
+		return true, nil
+	}
+	return false, nil
+}
+
+// MigrationStatus is whether one migration is applied
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Status returns every migration and whether it is applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.store.AppliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status = append(status, MigrationStatus{Migration: mig, Applied: applied[mig.Version]})
+	}
+	return status, nil
+}
+
+// WithMigrator runs fn with a Migrator of the embedded migrations on a connection of pool,
+// holding the migration lock so no other replica migrates meanwhile
+func WithMigrator(ctx context.Context, pool *pgxpool.Pool, logger *zap.Logger, fn func(*Migrator) error) error {
+	loaded, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return fmt.Errorf("loading migrations: %w", err)
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("taking migration lock: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}()
+
+	store := &pgMigrationStore{conn: conn.Conn()}
+	if err := store.ensureVersionTable(ctx); err != nil {
+		return err
+	}
+	return fn(NewMigrator(store, loaded, logger))
+}
+
+// Migrate applies the pending embedded migrations to pool
+func Migrate(ctx context.Context, pool *pgxpool.Pool, logger *zap.Logger) (int, error) {
+	var n int
+	err := WithMigrator(ctx, pool, logger, func(m *Migrator) (err error) {
+		n, err = m.Up(ctx)
+		return err
+	})
+	return n, err
+}
+
+// pgMigrationStore records migrations in the goose version table
+type pgMigrationStore struct {
+	conn *pgx.Conn
+}
+
+func (s *pgMigrationStore) ensureVersionTable(ctx context.Context) error {
+	_, err := s.conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+versionTable+` (
+		id SERIAL PRIMARY KEY,
+		version_id BIGINT NOT NULL,
+		is_applied BOOLEAN NOT NULL,
+		tstamp TIMESTAMP DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", versionTable, err)
+	}
+	return nil
+}
+
+// AppliedVersions implements migrationStore. A version's latest row says whether it is
+// applied, as goose reads them.
+func (s *pgMigrationStore) AppliedVersions(ctx context.Context) (map[int64]bool, error) {
+	rows, err := s.conn.Query(ctx, "SELECT version_id, is_applied FROM "+versionTable+" ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", versionTable, err)
+	}
+	applied := make(map[int64]bool)
+	var version int64
+	var isApplied bool
+	_, err = pgx.ForEachRow(rows, []any{&version, &isApplied}, func() error {
+		applied[version] = isApplied
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", versionTable, err)
+	}
+	return applied, nil
+}
+
+// Apply implements migrationStore. Rolled back versions have their rows deleted, as goose
+// does.
+func (s *pgMigrationStore) Apply(ctx context.Context, version int64, sql string, up bool) error {
+	return pgx.BeginFunc(ctx, s.conn, func(tx pgx.Tx) error {
+		if strings.TrimSpace(sql) != "" {
+			if _, err := tx.Exec(ctx, sql); err != nil {
+				return err
+			}
+		}
+		if up {
+			_, err := tx.Exec(ctx, "INSERT INTO "+versionTable+" (version_id, is_applied) VALUES ($1, TRUE)", version)
+			return err
+		}
+		_, err := tx.Exec(ctx, "DELETE FROM "+versionTable+" WHERE version_id = $1", version)
+		return err
+	})
+}
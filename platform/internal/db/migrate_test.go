@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/migrations"
+)
+
+// fakeMigrationStore keeps applied versions in memory and records the SQL it ran
+type fakeMigrationStore struct {
+	applied map[int64]bool
+	ran     []string
+	failOn  string
+}
+
+func newFakeMigrationStore() *fakeMigrationStore {
+	return &fakeMigrationStore{applied: make(map[int64]bool)}
+}
+
+func (f *fakeMigrationStore) AppliedVersions(context.Context) (map[int64]bool, error) {
+	applied := make(map[int64]bool, len(f.applied))
+	for v, ok := range f.applied {
+		applied[v] = ok
+	}
+	return applied, nil
+}
+
+func (f *fakeMigrationStore) Apply(_ context.Context, version int64, sql string, up bool) error {
+	if f.failOn != "" && strings.Contains(sql, f.failOn) {
+		return errors.New("syntax error")
+	}
+	f.ran = append(f.ran, strings.TrimSpace(sql))
+	if up {
+		f.applied[version] = true
+	} else {
+		delete(f.applied, version)
+	}
+	return nil
+}
+
+var testMigrations = fstest.MapFS{
+	"00002_b.sql": {Data: []byte("-- +goose Up\nCREATE TABLE b ();\n\n-- +goose Down\nDROP TABLE b;\n")},
+	"00001_a.sql": {Data: []byte("-- +goose Up\n-- +goose StatementBegin\nCREATE TABLE a ();\n-- +goose StatementEnd\n-- +goose Down\nDROP TABLE a;\n")},
+	"README.md":   {Data: []byte("not a migration")},
+}
+
+func TestLoadMigrations(t *testing.T) {
+	loaded, err := LoadMigrations(testMigrations)
+	if err != nil {
+		t.Fatalf("LoadMigrations: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].Version != 1 || loaded[0].Name != "a" || loaded[1].Version != 2 {
+		t.Fatalf("expected migrations 1 and 2 in order, got %+v", loaded)
+	}
+	if strings.TrimSpace(loaded[0].Up) != "CREATE TABLE a ();" || strings.TrimSpace(loaded[0].Down) != "DROP TABLE a;" {
+		t.Errorf("unexpected sections %q and %q", loaded[0].Up, loaded[0].Down)
+	}
+
+	for name, content := range map[string]string{
+		"x_bad.sql":   "-- +goose Up\nSELECT 1;",
+		"00003_c.sql": "-- +goose Down\nSELECT 1;",
+		"00004_d.sql": "-- +goose Up\n-- +goose NO TRANSACTION\nSELECT 1;",
+	} {
+		if _, err := LoadMigrations(fstest.MapFS{name: {Data: []byte(content)}}); err == nil {
+			t.Errorf("expected %s refused", name)
+		}
+	}
+}
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	loaded, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatalf("LoadMigrations: %v", err)
+	}
+	if len(loaded) == 0 {
+		t.Fatal("expected embedded migrations")
+	}
+	for i, m := range loaded {
+		if m.Version != int64(i+1) {
+			t.Errorf("expected version %d, got %d_%s", i+1, m.Version, m.Name)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			t.Errorf("expected migration %d_%s to have a down section", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrator_UpIsIdempotent(t *testing.T) {
+	loaded, _ := LoadMigrations(testMigrations)
+	store := newFakeMigrationStore()
+	m := NewMigrator(store, loaded, zap.NewNop())
+
+	if n, err := m.Up(context.Background()); err != nil || n != 2 {
+		t.Fatalf("expected 2 migrations applied, got %d (%v)", n, err)
+	}
+	if n, err := m.Up(context.Background()); err != nil || n != 0 {
+		t.Errorf("expected a re-run to apply nothing, got %d (%v)", n, err)
+	}
+	if len(store.ran) != 2 || store.ran[0] != "CREATE TABLE a ();" {
+		t.Errorf("expected each migration run once in order, ran %q", store.ran)
+	}
+
+	// Rolling back undoes the latest migration only, which Up then applies again
+	if ok, err := m.Down(context.Background()); err != nil || !ok || store.applied[2] || !store.applied[1] {
+		t.Fatalf("expected migration 2 rolled back, got %v (%v) and %v", ok, err, store.applied)
+	}
+	if n, _ := m.Up(context.Background()); n != 1 {
+		t.Errorf("expected migration 2 applied again, got %d", n)
+	}
+}
+
+func TestMigrator_StopsAtFailedMigration(t *testing.T) {
+	loaded, _ := LoadMigrations(testMigrations)
+	store := newFakeMigrationStore()
+	store.failOn = "TABLE b"
+	m := NewMigrator(store, loaded, zap.NewNop())
+
+	n, err := m.Up(context.Background())
+	if err == nil || n != 1 || !strings.Contains(err.Error(), "2_b") {
+		t.Fatalf("expected migration 2 to fail after 1 applied, got %d (%v)", n, err)
+	}
+	status, _ := m.Status(context.Background())
+	if !status[0].Applied || status[1].Applied {
+		t.Errorf("expected only migration 1 applied, got %+v", status)
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/logger"
 )
 
 // AppError is a structured application error
@@ -14,6 +16,9 @@ type AppError struct {
 	ErrorCode      string `json:"error"`
 	DisplayMessage string `json:"display_message,omitempty"`
 	Message        string `json:"message"`
+	Details        any    `json:"details,omitempty"`
+	// RequestID is the ID of the request the error answers, set by HTTPErrorHandler
+	RequestID string `json:"request_id,omitempty"`
 }
 
 func (e *AppError) Error() string { return e.Message }
@@ -24,6 +29,18 @@ func (e *AppError) WithDisplayMessage(displayMsg string) *AppError {
 	return e
 }
 
+// WithErrorCode replaces the generic error code, for errors callers need to tell apart
+func (e *AppError) WithErrorCode(code string) *AppError {
+	e.ErrorCode = code
+	return e
+}
+
+// WithDetails attaches details callers can act on, such as the limit a request ran into
+func (e *AppError) WithDetails(details any) *AppError {
+	e.Details = details
+	return e
+}
+
 // NotFound creates a 404 error
 func NotFound(msg string) *AppError {
 	return &AppError{Code: http.StatusNotFound, ErrorCode: "not_found", Message: msg}
@@ -39,6 +56,41 @@ func Unauthorized(msg string) *AppError {
 	return &AppError{Code: http.StatusUnauthorized, ErrorCode: "unauthorized", Message: msg}
 }
 
+// PaymentRequired creates a 402 error
+func PaymentRequired(msg string) *AppError {
+	return &AppError{Code: http.StatusPaymentRequired, ErrorCode: "payment_required", Message: msg}
+}
+
+// Forbidden creates a 403 error
+func Forbidden(msg string) *AppError {
+	return &AppError{Code: http.StatusForbidden, ErrorCode: "forbidden", Message: msg}
+}
+
+// Conflict creates a 409 error
+func Conflict(msg string) *AppError {
+	return &AppError{Code: http.StatusConflict, ErrorCode: "conflict", Message: msg}
+}
+
+// PayloadTooLarge creates a 413 error
+func PayloadTooLarge(msg string) *AppError {
+	return &AppError{Code: http.StatusRequestEntityTooLarge, ErrorCode: "payload_too_large", Message: msg}
+}
+
+// UnprocessableEntity creates a 422 error
+func UnprocessableEntity(msg string) *AppError {
+	return &AppError{Code: http.StatusUnprocessableEntity, ErrorCode: "unprocessable_entity", Message: msg}
+}
+
+// Locked creates a 423 error
+func Locked(msg string) *AppError {
+	return &AppError{Code: http.StatusLocked, ErrorCode: "locked", Message: msg}
+}
+
+// TooManyRequests creates a 429 error
+func TooManyRequests(msg string) *AppError {
+	return &AppError{Code: http.StatusTooManyRequests, ErrorCode: "too_many_requests", Message: msg}
+}
+
 // InternalError creates a 500 error
 func InternalError(msg string) *AppError {
 	return &AppError{Code: http.StatusInternalServerError, ErrorCode: "internal_server_error", Message: msg}
@@ -49,39 +101,55 @@ func ServiceUnavailable(msg string) *AppError {
 	return &AppError{Code: http.StatusServiceUnavailable, ErrorCode: "service_unavailable", Message: msg}
 }
 
-// HTTPErrorHandler returns a custom Echo error handler
-func HTTPErrorHandler(logger *zap.Logger) echo.HTTPErrorHandler {
+// GatewayTimeout creates a 504 error
+func GatewayTimeout(msg string) *AppError {
+	return &AppError{Code: http.StatusGatewayTimeout, ErrorCode: "gateway_timeout", Message: msg}
+}
+
+// HTTPErrorHandler returns a custom Echo error handler. Every error response carries the
+// request's ID, as in its X-Request-Id header, for correlating it with the logs.
+func HTTPErrorHandler(fallback *zap.Logger) echo.HTTPErrorHandler {
 	return func(err error, c echo.Context) {
 		if c.Response().Committed {
 			return
 		}
+		requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+		log := logger.FromContext(c.Request().Context(), fallback)
 
 		var appErr *AppError
 		if errors.As(err, &appErr) {
-			if jsonErr := c.JSON(appErr.Code, appErr); jsonErr != nil {
-				logger.Error("Failed to send error response", zap.Error(jsonErr))
+			body := *appErr
+			body.RequestID = requestID
+			if jsonErr := c.JSON(appErr.Code, &body); jsonErr != nil {
+				log.Error("Failed to send error response", zap.Error(jsonErr))
 			}
 			return
 		}
 
 		var echoErr *echo.HTTPError
 		if errors.As(err, &echoErr) {
-			if jsonErr := c.JSON(echoErr.Code, map[string]any{
-				"error":   http.StatusText(echoErr.Code),
-				"message": echoErr.Message,
-			}); jsonErr != nil {
-				logger.Error("Failed to send error response", zap.Error(jsonErr))
+			if jsonErr := c.JSON(echoErr.Code, errorBody(http.StatusText(echoErr.Code), echoErr.Message, requestID)); jsonErr != nil {
+				log.Error("Failed to send error response", zap.Error(jsonErr))
 			}
 			return
 		}
 
 		// Log unexpected errors
-		logger.Error("Unexpected error", zap.Error(err))
-		if jsonErr := c.JSON(http.StatusInternalServerError, map[string]any{
-			"error":   "internal_server_error",
-			"message": "An unexpected error occurred",
-		}); jsonErr != nil {
-			logger.Error("Failed to send error response", zap.Error(jsonErr))
+		log.Error("Unexpected error", zap.Error(err))
+		if jsonErr := c.JSON(http.StatusInternalServerError, errorBody("internal_server_error", "An unexpected error occurred", requestID)); jsonErr != nil {
+			log.Error("Failed to send error response", zap.Error(jsonErr))
 		}
 	}
 }
+
+// errorBody is the response body of an error that is not an AppError
+func errorBody(code string, message any, requestID string) map[string]any {
+	body := map[string]any{
+		"error":   code,
+		"message": message,
+	}
+	if requestID != "" {
+		body["request_id"] = requestID
+	}
+	return body
+}
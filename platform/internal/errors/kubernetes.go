@@ -0,0 +1,40 @@
+package errors
+
+import (
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// KubernetesReason is the details of an error from the Kubernetes API that has no status
+// of its own
+type KubernetesReason struct {
+	Reason string `json:"reason"`
+}
+
+// FromKubernetes converts an error wrapping a Kubernetes API error into the AppError it
+// amounts to: 404 for a missing object, 409 for one that already exists or changed, 403
+// when the request was forbidden (including quota violations), 400 for an invalid object
+// and 504 when the API timed out. Other API errors become 500, with the API's reason in
+// the details. It returns nil if err does not wrap a Kubernetes API error.
+func FromKubernetes(err error) *AppError {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return nil
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return NotFound(err.Error())
+	case apierrors.IsAlreadyExists(err), apierrors.IsConflict(err):
+		return Conflict(err.Error())
+	case apierrors.IsForbidden(err):
+		return Forbidden(err.Error())
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return BadRequest(err.Error())
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err):
+		return GatewayTimeout(err.Error())
+	default:
+		return InternalError(err.Error()).WithDetails(KubernetesReason{Reason: string(apierrors.ReasonForError(err))})
+	}
+}
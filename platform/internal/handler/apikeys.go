@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to spot
+const apiKeyPrefix = "fk_"
+
+// apiKeyStore stores API keys by their hash
+type apiKeyStore interface {
+	CreateAPIKey(ctx context.Context, arg *sqlc.CreateAPIKeyParams) (*sqlc.ApiKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) (int64, error)
+}
+
+// CreateAPIKeyRequest is the request body for POST /api/v1/admin/api-keys
+type CreateAPIKeyRequest struct {
+	UserID string `json:"user_id" validate:"required,max=128"`
+	Name   string `json:"name" validate:"max=256"`
+	Admin  bool   `json:"admin"` // may act for any user
+}
+
+// APIKeyResponse is the response for POST /api/v1/admin/api-keys. Key is only ever
+// returned here; the platform keeps a hash of it.
+type APIKeyResponse struct {
+	ID        int64  `json:"id"`
+	Key       string `json:"key"`
+	UserID    string `json:"user_id"`
+	Name      string `json:"name,omitempty"`
+	Admin     bool   `json:"admin"`
+	CreatedAt string `json:"created_at"`
+}
+
+// APIKeyHandler issues and revokes API keys for admins
+type APIKeyHandler struct {
+	keys       apiKeyStore
+	bodyLimits BodyLimits
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(pool *pgxpool.Pool, bodyLimits BodyLimits) *APIKeyHandler {
+	return &APIKeyHandler{keys: sqlc.New(pool), bodyLimits: bodyLimits}
+}
+
+// Register registers API key routes
+func (h *APIKeyHandler) Register(e *echo.Echo) {
+	e.POST("/api/v1/admin/api-keys", h.CreateAPIKey, BodyLimit(h.bodyLimits.Default))
+	e.DELETE("/api/v1/admin/api-keys/:id", h.RevokeAPIKey)
+}
+
+// CreateAPIKey handles POST /api/v1/admin/api-keys
+func (h *APIKeyHandler) CreateAPIKey(c echo.Context) error {
+	var req CreateAPIKeyRequest
+	if err := Bind(c, &req); err != nil {
+		return err
+	}
+
+	key := GenerateAPIKey()
+	stored, err := h.keys.CreateAPIKey(c.Request().Context(), &sqlc.CreateAPIKeyParams{
+		UserID:  req.UserID,
+		Name:    req.Name,
+		KeyHash: HashAPIKey(key),
+		Admin:   req.Admin,
+	})
+	if err != nil {
+		return errors.InternalError("failed to create API key")
+	}
+
+	return c.JSON(http.StatusCreated, APIKeyResponse{
+		ID:        stored.ID,
+		Key:       key,
+		UserID:    stored.UserID,
+		Name:      stored.Name,
+		Admin:     stored.Admin,
+		CreatedAt: stored.CreatedAt.Format(time.RFC3339),
+	})
+}
+
+// RevokeAPIKey handles DELETE /api/v1/admin/api-keys/:id. Requests with a revoked key
+// are refused from then on.
+func (h *APIKeyHandler) RevokeAPIKey(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return errors.BadRequest("invalid API key id")
+	}
+
+	revoked, err := h.keys.RevokeAPIKey(c.Request().Context(), id)
+	if err != nil {
+		return errors.InternalError("failed to revoke API key")
+	}
+	if revoked == 0 {
+		return errors.NotFound("API key not found or already revoked")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GenerateAPIKey returns a new random API key
+func GenerateAPIKey() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return apiKeyPrefix + hex.EncodeToString(b)
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up by
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
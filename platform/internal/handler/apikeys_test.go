@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeAPIKeyStore keeps API keys in memory
+type fakeAPIKeyStore struct {
+	keys []*sqlc.ApiKey
+}
+
+func (f *fakeAPIKeyStore) CreateAPIKey(_ context.Context, arg *sqlc.CreateAPIKeyParams) (*sqlc.ApiKey, error) {
+	key := &sqlc.ApiKey{
+		ID:        int64(len(f.keys) + 1),
+		UserID:    arg.UserID,
+		Name:      arg.Name,
+		KeyHash:   arg.KeyHash,
+		Admin:     arg.Admin,
+		CreatedAt: time.Now(),
+	}
+	f.keys = append(f.keys, key)
+	return key, nil
+}
+
+func (f *fakeAPIKeyStore) RevokeAPIKey(_ context.Context, id int64) (int64, error) {
+	for _, key := range f.keys {
+		if key.ID == id && !key.RevokedAt.Valid {
+			key.RevokedAt.Valid = true
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func TestAPIKeys_CreateAndRevoke(t *testing.T) {
+	store := &fakeAPIKeyStore{}
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	(&APIKeyHandler{keys: store, bodyLimits: BodyLimits{Default: 64 << 10}}).Register(e)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/api/v1/admin/api-keys", `{"name":"ci"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without a user_id, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec := serve(http.MethodPost, "/api/v1/admin/api-keys", `{"user_id":"user1","name":"ci"}`)
+	var created APIKeyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(created.Key, apiKeyPrefix) || created.UserID != "user1" || created.Admin {
+		t.Errorf("expected a user key for user1, got %+v", created)
+	}
+	if stored := store.keys[0]; stored.KeyHash != HashAPIKey(created.Key) || stored.KeyHash == created.Key {
+		t.Errorf("expected only the key's hash stored, got %q", stored.KeyHash)
+	}
+
+	if rec := serve(http.MethodDelete, "/api/v1/admin/api-keys/1", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d revoking the key, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/api/v1/admin/api-keys/1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d revoking it again, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/api/v1/admin/api-keys/abc", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid id, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+// deliveryStatuses are the values the status filter accepts
+var deliveryStatuses = []string{
+	webhook.DeliveryStatusPending,
+	webhook.DeliveryStatusDelivering,
+	webhook.DeliveryStatusCompleted,
+	webhook.DeliveryStatusFailed,
+}
+
+// deliveryInspector lists webhook delivery records and the attempts made for them
+type deliveryInspector interface {
+	ListDeliveries(ctx context.Context, filter webhook.DeliveryFilter, limit, offset int32) ([]*sqlc.WebhookDelivery, error)
+	ListDeliveryEndpoints(ctx context.Context, requestID string) ([]*sqlc.WebhookDelivery, error)
+	ListAttempts(ctx context.Context, requestID string) ([]*sqlc.WebhookDeliveryAttempt, error)
+	RedactError(webhookURL, msg string) string
+}
+
+// DeliveryRecordResponse is the delivery state of one webhook endpoint of a request.
+// The webhook URL and errors are redacted, and secrets are never returned.
+type DeliveryRecordResponse struct {
+	RequestID           string     `json:"request_id"`
+	AgentID             string     `json:"agent_id"`
+	WebhookURL          string     `json:"webhook_url"`
+	EndpointIndex       int32      `json:"endpoint_index"`
+	Status              string     `json:"status"`
+	Seq                 int64      `json:"seq"`
+	LastEventType       string     `json:"last_event_type,omitempty"`
+	AttemptCount        int32      `json:"attempt_count"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastAttemptAt       *time.Time `json:"last_attempt_at,omitempty"`
+	NextRetryAt         *time.Time `json:"next_retry_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	CompletedAt         *time.Time `json:"completed_at,omitempty"`
+}
+
+// ListDeliveriesResponse is the response for GET /api/v1/admin/deliveries.
+// NextOffset is set when there may be more deliveries.
+type ListDeliveriesResponse struct {
+	Deliveries []DeliveryRecordResponse `json:"deliveries"`
+	Total      int                      `json:"total"`
+	NextOffset *int                     `json:"next_offset,omitempty"`
+}
+
+// AttemptResponse is one HTTP attempt to deliver an event. StatusCode is omitted when no
+// response was received.
+type AttemptResponse struct {
+	Seq        int64     `json:"seq"`
+	WebhookURL string    `json:"webhook_url"`
+	Attempt    int32     `json:"attempt"`
+	DeliveryID string    `json:"delivery_id,omitempty"` // the attempt's X-Forge-Delivery-Id
+	StatusCode *int32    `json:"status_code,omitempty"`
+	DurationMs int32     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DeliveryDetailResponse is the response for GET /api/v1/admin/deliveries/:request_id
+type DeliveryDetailResponse struct {
+	RequestID string                   `json:"request_id"`
+	Endpoints []DeliveryRecordResponse `json:"endpoints"`
+	Attempts  []AttemptResponse        `json:"attempts"`
+}
+
+// DeliveryAdminHandler lets operators inspect webhook delivery health across requests
+type DeliveryAdminHandler struct {
+	deliveries deliveryInspector
+}
+
+// NewDeliveryAdminHandler creates a new delivery admin handler
+func NewDeliveryAdminHandler(webhookDelivery *webhook.DeliveryService) *DeliveryAdminHandler {
+	return &DeliveryAdminHandler{deliveries: webhookDelivery}
+}
+
+// Register registers delivery admin routes
+func (h *DeliveryAdminHandler) Register(e *echo.Echo) {
+	e.GET("/api/v1/admin/deliveries", h.ListDeliveries)
+	e.GET("/api/v1/admin/deliveries/:request_id", h.GetDelivery)
+}
+
+// ListDeliveries handles GET /api/v1/admin/deliveries?agent_id=...&status=failed
+// &created_after=2025-01-15T10:00:00Z&webhook_url=https://example.com&limit=50&offset=0.
+// Each webhook endpoint of a request is listed separately, newest first.
+func (h *DeliveryAdminHandler) ListDeliveries(c echo.Context) error {
+	filter := webhook.DeliveryFilter{
+		AgentID:          c.QueryParam("agent_id"),
+		Status:           c.QueryParam("status"),
+		WebhookURLPrefix: c.QueryParam("webhook_url"),
+	}
+	if filter.Status != "" && !slices.Contains(deliveryStatuses, filter.Status) {
+		return errors.BadRequest("status must be one of pending, delivering, completed, failed")
+	}
+	if raw := c.QueryParam("created_after"); raw != "" {
+		createdAfter, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return errors.BadRequest("created_after must be an RFC 3339 timestamp")
+		}
+		filter.CreatedAfter = createdAfter
+	}
+
+	limit := defaultDeliveriesLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxDeliveriesLimit {
+			return errors.BadRequest("limit must be between 1 and " + strconv.Itoa(maxDeliveriesLimit))
+		}
+		limit = n
+	}
+	offset := 0
+	if raw := c.QueryParam("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return errors.BadRequest("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	records, err := h.deliveries.ListDeliveries(c.Request().Context(), filter, int32(limit), int32(offset))
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	resp := ListDeliveriesResponse{
+		Deliveries: make([]DeliveryRecordResponse, 0, len(records)),
+		Total:      len(records),
+	}
+	for _, d := range records {
+		resp.Deliveries = append(resp.Deliveries, h.recordToResponse(d))
+	}
+	if len(records) == limit {
+		next := offset + limit
+		resp.NextOffset = &next
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetDelivery handles GET /api/v1/admin/deliveries/:request_id, returning the request's
+// endpoints and its first webhook.MaxListedAttempts delivery attempts
+func (h *DeliveryAdminHandler) GetDelivery(c echo.Context) error {
+	requestID := c.Param("request_id")
+	ctx := c.Request().Context()
+
+	endpoints, err := h.deliveries.ListDeliveryEndpoints(ctx, requestID)
+	if err != nil {
+		if stderrors.Is(err, webhook.ErrDeliveryNotFound) {
+			return errors.NotFound("no delivery for request " + requestID)
+		}
+		return errors.InternalError(err.Error())
+	}
+	attempts, err := h.deliveries.ListAttempts(ctx, requestID)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	resp := DeliveryDetailResponse{
+		RequestID: requestID,
+		Endpoints: make([]DeliveryRecordResponse, 0, len(endpoints)),
+		Attempts:  make([]AttemptResponse, 0, len(attempts)),
+	}
+	for _, d := range endpoints {
+		resp.Endpoints = append(resp.Endpoints, h.recordToResponse(d))
+	}
+	for _, a := range attempts {
+		attempt := AttemptResponse{
+			Seq:        a.Seq,
+			WebhookURL: webhook.RedactURL(a.WebhookUrl),
+			Attempt:    a.Attempt,
+			DeliveryID: a.DeliveryID,
+			DurationMs: a.DurationMs,
+			Error:      h.deliveries.RedactError(a.WebhookUrl, a.Error.String),
+			CreatedAt:  a.CreatedAt,
+		}
+		if a.StatusCode.Valid {
+			attempt.StatusCode = &a.StatusCode.Int32
+		}
+		resp.Attempts = append(resp.Attempts, attempt)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// recordToResponse converts a delivery record to the API representation
+func (h *DeliveryAdminHandler) recordToResponse(d *sqlc.WebhookDelivery) DeliveryRecordResponse {
+	resp := DeliveryRecordResponse{
+		RequestID:           d.RequestID,
+		AgentID:             d.AgentID,
+		WebhookURL:          webhook.RedactURL(d.WebhookUrl),
+		EndpointIndex:       d.EndpointIndex,
+		Status:              d.Status,
+		Seq:                 d.Seq,
+		LastEventType:       d.LastEventType.String,
+		AttemptCount:        d.AttemptCount,
+		ConsecutiveFailures: d.ConsecutiveFailures,
+		LastError:           h.deliveries.RedactError(d.WebhookUrl, d.LastError.String),
+		CreatedAt:           d.CreatedAt,
+		UpdatedAt:           d.UpdatedAt,
+	}
+	if d.LastAttemptAt.Valid {
+		resp.LastAttemptAt = &d.LastAttemptAt.Time
+	}
+	if d.NextRetryAt.Valid {
+		resp.NextRetryAt = &d.NextRetryAt.Time
+	}
+	if d.CompletedAt.Valid {
+		resp.CompletedAt = &d.CompletedAt.Time
+	}
+	return resp
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+type fakeDeliveryInspector struct {
+	*webhook.DeliveryService // for RedactError
+	records                  []*sqlc.WebhookDelivery
+	attempts                 []*sqlc.WebhookDeliveryAttempt
+	lastFilter               webhook.DeliveryFilter
+}
+
+func (f *fakeDeliveryInspector) ListDeliveries(_ context.Context, filter webhook.DeliveryFilter, limit, offset int32) ([]*sqlc.WebhookDelivery, error) {
+	f.lastFilter = filter
+	end := min(int(offset)+int(limit), len(f.records))
+	if int(offset) >= end {
+		return []*sqlc.WebhookDelivery{}, nil
+	}
+	return f.records[offset:end], nil
+}
+
+func (f *fakeDeliveryInspector) ListDeliveryEndpoints(_ context.Context, requestID string) ([]*sqlc.WebhookDelivery, error) {
+	var endpoints []*sqlc.WebhookDelivery
+	for _, d := range f.records {
+		if d.RequestID == requestID {
+			endpoints = append(endpoints, d)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, webhook.ErrDeliveryNotFound
+	}
+	return endpoints, nil
+}
+
+func (f *fakeDeliveryInspector) ListAttempts(context.Context, string) ([]*sqlc.WebhookDeliveryAttempt, error) {
+	return f.attempts, nil
+}
+
+func setupDeliveryAdmin(inspector *fakeDeliveryInspector) *echo.Echo {
+	inspector.DeliveryService = webhook.NewDeliveryServiceWithQuerier(nil, &config.Config{VercelBypassToken: "bypass-secret"}, zap.NewNop())
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	(&DeliveryAdminHandler{deliveries: inspector}).Register(e)
+	return e
+}
+
+func TestListDeliveries_FiltersAndPaginates(t *testing.T) {
+	inspector := &fakeDeliveryInspector{}
+	for _, id := range []string{"req-1", "req-2", "req-3"} {
+		inspector.records = append(inspector.records, &sqlc.WebhookDelivery{
+			RequestID:         id,
+			AgentID:           "agent-1",
+			WebhookUrl:        "https://example.com/hooks/token-123",
+			WebhookSecretHash: sql.NullString{String: "secret-hash", Valid: true},
+			Status:            webhook.DeliveryStatusFailed,
+			LastError:         sql.NullString{String: "webhook returned status 500: " + strings.Repeat("x", 2048), Valid: true},
+		})
+	}
+	e := setupDeliveryAdmin(inspector)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/api/v1/admin/deliveries?agent_id=agent-1&status=failed&created_after=2025-01-15T10:00:00Z&webhook_url=https://example.com&limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	wantFilter := webhook.DeliveryFilter{
+		AgentID:          "agent-1",
+		Status:           webhook.DeliveryStatusFailed,
+		CreatedAfter:     time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC),
+		WebhookURLPrefix: "https://example.com",
+	}
+	if !inspector.lastFilter.CreatedAfter.Equal(wantFilter.CreatedAfter) || inspector.lastFilter.AgentID != wantFilter.AgentID ||
+		inspector.lastFilter.Status != wantFilter.Status || inspector.lastFilter.WebhookURLPrefix != wantFilter.WebhookURLPrefix {
+		t.Errorf("expected filter %+v, got %+v", wantFilter, inspector.lastFilter)
+	}
+
+	body := rec.Body.String()
+	if strings.Contains(body, "token-123") || strings.Contains(body, "secret-hash") {
+		t.Errorf("expected the URL redacted and no secret hash, got %s", body)
+	}
+	var resp ListDeliveriesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Total != 2 || resp.NextOffset == nil || *resp.NextOffset != 2 {
+		t.Fatalf("expected a full first page with next_offset 2, got %+v", resp)
+	}
+	if got := len(resp.Deliveries[0].LastError); got > webhook.MaxAttemptError {
+		t.Errorf("expected last_error truncated to %d bytes, got %d", webhook.MaxAttemptError, got)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/deliveries?limit=2&offset=2", nil))
+	resp = ListDeliveriesResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Total != 1 || resp.NextOffset != nil || resp.Deliveries[0].RequestID != "req-3" {
+		t.Errorf("expected the last page to hold req-3 only, got %+v", resp)
+	}
+}
+
+func TestListDeliveries_InvalidParams(t *testing.T) {
+	e := setupDeliveryAdmin(&fakeDeliveryInspector{})
+	for _, query := range []string{"status=lost", "created_after=yesterday", "limit=0", "limit=501", "offset=-1"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/deliveries?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestGetDelivery_ReturnsAttemptHistory(t *testing.T) {
+	url := "https://example.com/hooks/token-123"
+	inspector := &fakeDeliveryInspector{
+		records: []*sqlc.WebhookDelivery{{RequestID: "req-1", AgentID: "agent-1", WebhookUrl: url, Status: webhook.DeliveryStatusCompleted}},
+		attempts: []*sqlc.WebhookDeliveryAttempt{
+			{RequestID: "req-1", Seq: 1, WebhookUrl: url, Attempt: 1, DurationMs: 30,
+				Error: sql.NullString{String: `sending request: Post "` + url + `?bypass_token=bypass-secret": connection refused`, Valid: true}},
+			{RequestID: "req-1", Seq: 1, WebhookUrl: url, Attempt: 2, DurationMs: 12,
+				StatusCode: pgtype.Int4{Int32: http.StatusOK, Valid: true}},
+		},
+	}
+	e := setupDeliveryAdmin(inspector)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/deliveries/req-1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); strings.Contains(body, "token-123") || strings.Contains(body, "bypass-secret") {
+		t.Errorf("expected the URL and bypass token redacted, got %s", body)
+	}
+
+	var resp DeliveryDetailResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Endpoints) != 1 || len(resp.Attempts) != 2 {
+		t.Fatalf("expected 1 endpoint and 2 attempts, got %+v", resp)
+	}
+	if resp.Attempts[0].StatusCode != nil || resp.Attempts[0].Error == "" {
+		t.Errorf("expected the first attempt to have an error and no status, got %+v", resp.Attempts[0])
+	}
+	if resp.Attempts[1].StatusCode == nil || *resp.Attempts[1].StatusCode != http.StatusOK || resp.Attempts[1].DurationMs != 12 {
+		t.Errorf("expected the second attempt to succeed with 200, got %+v", resp.Attempts[1])
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/deliveries/req-unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown request, got %d", rec.Code)
+	}
+}
@@ -1,17 +1,85 @@
 package handler
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+const (
+	// readinessCheckTimeout bounds each dependency check of /readyz
+	readinessCheckTimeout = 2 * time.Second
+	// readinessCacheTTL is how long a readiness report is served before dependencies are
+	// checked again, so frequent probes do not load them
+	readinessCacheTTL = 5 * time.Second
 )
 
+// Dependencies checked by /readyz
+const (
+	DependencyKubernetes = "kubernetes"
+	DependencyDatabase   = "database"
+	DependencyWebhook    = "webhook"
+)
+
+// webhookHealthReporter reports the health of webhook delivery
+type webhookHealthReporter interface {
+	Health(ctx context.Context) webhook.HealthReport
+}
+
+// pinger checks that a dependency is reachable
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// DependencyStatus is the result of checking one dependency
+type DependencyStatus struct {
+	// Status is "ok" or "failing"
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadyzResponse is the response for GET /readyz
+type ReadyzResponse struct {
+	// Status is "ready", "degraded" (still 200) or "not_ready" (503)
+	Status string `json:"status"`
+	// Failing names the dependencies that made the platform not ready
+	Failing    []string             `json:"failing,omitempty"`
+	Kubernetes DependencyStatus     `json:"kubernetes"`
+	Database   DependencyStatus     `json:"database"`
+	Webhook    webhook.HealthReport `json:"webhook"`
+}
+
 // HealthHandler handles health check endpoints
-type HealthHandler struct{}
+type HealthHandler struct {
+	webhooks   webhookHealthReporter
+	kubernetes pinger
+	database   pinger
+	now        func() time.Time // replaced in tests
+
+	// The last readiness report, served until it is readinessCacheTTL old. mu is held
+	// while dependencies are checked, so concurrent probes share one check.
+	mu        sync.Mutex
+	cached    ReadyzResponse
+	cachedAt  time.Time
+	hasCached bool
+}
 
-// NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+// NewHealthHandler creates a new health handler whose readiness check covers the
+// Kubernetes API, the database and webhook delivery
+func NewHealthHandler(webhookDelivery *webhook.DeliveryService, k8sManager *k8s.Manager, pool *pgxpool.Pool) *HealthHandler {
+	return &HealthHandler{
+		webhooks:   webhookDelivery,
+		kubernetes: k8sManager,
+		database:   pool,
+		now:        time.Now,
+	}
 }
 
 // Register registers health check routes
@@ -20,14 +88,82 @@ func (h *HealthHandler) Register(e *echo.Echo) {
 	e.GET("/readyz", h.Readyz)
 }
 
-// Healthz handles GET /healthz
+// Healthz handles GET /healthz, a liveness probe that checks no dependencies
 func (h *HealthHandler) Healthz(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// Readyz handles GET /readyz
+// Readyz handles GET /readyz. The platform is not ready (503) if the Kubernetes API or
+// the database cannot be reached, or webhook delivery is failing; a degraded webhook
+// delivery still reports ready. Reports are cached for readinessCacheTTL.
 func (h *HealthHandler) Readyz(c echo.Context) error {
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"status": "ready",
-	})
+	resp := h.readiness(c.Request().Context())
+	code := http.StatusOK
+	if resp.Status == "not_ready" {
+		code = http.StatusServiceUnavailable
+	}
+	return c.JSON(code, resp)
+}
+
+// readiness returns the cached readiness report, checking dependencies again if it expired
+func (h *HealthHandler) readiness(ctx context.Context) ReadyzResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.hasCached && h.now().Sub(h.cachedAt) < readinessCacheTTL {
+		return h.cached
+	}
+	h.cached = h.checkDependencies(ctx)
+	h.cachedAt = h.now()
+	h.hasCached = true
+	return h.cached
+}
+
+// checkDependencies checks every dependency concurrently, each within readinessCheckTimeout
+func (h *HealthHandler) checkDependencies(ctx context.Context) ReadyzResponse {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readinessCheckTimeout)
+	defer cancel()
+
+	var resp ReadyzResponse
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		resp.Kubernetes = checkDependency(ctx, h.kubernetes)
+	}()
+	go func() {
+		defer wg.Done()
+		resp.Database = checkDependency(ctx, h.database)
+	}()
+	go func() {
+		defer wg.Done()
+		resp.Webhook = h.webhooks.Health(ctx)
+	}()
+	wg.Wait()
+
+	resp.Status = "ready"
+	if resp.Webhook.Status == webhook.HealthDegraded {
+		resp.Status = "degraded"
+	}
+	if resp.Kubernetes.Status != "ok" {
+		resp.Failing = append(resp.Failing, DependencyKubernetes)
+	}
+	if resp.Database.Status != "ok" {
+		resp.Failing = append(resp.Failing, DependencyDatabase)
+	}
+	if resp.Webhook.Status == webhook.HealthFailing {
+		resp.Failing = append(resp.Failing, DependencyWebhook)
+	}
+	if len(resp.Failing) > 0 {
+		resp.Status = "not_ready"
+	}
+	return resp
+}
+
+// checkDependency pings a dependency
+func checkDependency(ctx context.Context, p pinger) DependencyStatus {
+	if err := p.Ping(ctx); err != nil {
+		return DependencyStatus{Status: "failing", Error: err.Error()}
+	}
+	return DependencyStatus{Status: "ok"}
 }
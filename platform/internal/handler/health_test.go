@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/webhook"
+)
+
+type staticWebhookHealth webhook.HealthReport
+
+func (s staticWebhookHealth) Health(context.Context) webhook.HealthReport {
+	return webhook.HealthReport(s)
+}
+
+// stubPinger is a dependency whose Ping returns err
+type stubPinger struct {
+	err   error
+	calls int
+}
+
+func (s *stubPinger) Ping(context.Context) error {
+	s.calls++
+	return s.err
+}
+
+// allowedClientset is a fake clientset allowed to manage pods, or failing reviews with err
+func allowedClientset(err error) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if err != nil {
+			return true, nil, err
+		}
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = true
+		return true, review, nil
+	})
+	return clientset
+}
+
+func newTestHealthHandler(webhooks webhookHealthReporter, kubernetes, database pinger) *HealthHandler {
+	return &HealthHandler{webhooks: webhooks, kubernetes: kubernetes, database: database, now: time.Now}
+}
+
+func serveReadyz(t *testing.T, h *HealthHandler) (int, ReadyzResponse) {
+	t.Helper()
+	e := echo.New()
+	h.Register(e)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var resp ReadyzResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestReadyz_WebhookStatus(t *testing.T) {
+	tests := []struct {
+		status     webhook.HealthStatus
+		wantCode   int
+		wantStatus string
+	}{
+		{webhook.HealthOK, http.StatusOK, "ready"},
+		{webhook.HealthDegraded, http.StatusOK, "degraded"},
+		{webhook.HealthFailing, http.StatusServiceUnavailable, "not_ready"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			h := newTestHealthHandler(staticWebhookHealth{Status: tt.status, Reasons: []string{"x"}}, &stubPinger{}, &stubPinger{})
+			code, resp := serveReadyz(t, h)
+
+			if code != tt.wantCode {
+				t.Errorf("expected status %d, got %d", tt.wantCode, code)
+			}
+			if resp.Status != tt.wantStatus || resp.Webhook.Status != tt.status {
+				t.Errorf("expected %s/%s, got %s/%s", tt.wantStatus, tt.status, resp.Status, resp.Webhook.Status)
+			}
+		})
+	}
+}
+
+func TestReadyz_NamesFailingDependencies(t *testing.T) {
+	webhooks := staticWebhookHealth{Status: webhook.HealthOK}
+	unreachable := k8s.NewManagerWithClientset(allowedClientset(stderrors.New("connection refused")), "agents", "agent:latest", "")
+	reachable := k8s.NewManagerWithClientset(allowedClientset(nil), "agents", "agent:latest", "")
+
+	tests := []struct {
+		name        string
+		kubernetes  pinger
+		database    pinger
+		wantCode    int
+		wantFailing []string
+	}{
+		{"all reachable", reachable, &stubPinger{}, http.StatusOK, nil},
+		{"database down", reachable, &stubPinger{err: stderrors.New("dial tcp: connection refused")}, http.StatusServiceUnavailable, []string{DependencyDatabase}},
+		{"kubernetes down", unreachable, &stubPinger{}, http.StatusServiceUnavailable, []string{DependencyKubernetes}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := serveReadyz(t, newTestHealthHandler(webhooks, tt.kubernetes, tt.database))
+			if code != tt.wantCode || !slices.Equal(resp.Failing, tt.wantFailing) {
+				t.Fatalf("expected %d failing %v, got %d failing %v", tt.wantCode, tt.wantFailing, code, resp.Failing)
+			}
+			for name, dep := range map[string]DependencyStatus{DependencyKubernetes: resp.Kubernetes, DependencyDatabase: resp.Database} {
+				if failing := slices.Contains(tt.wantFailing, name); failing != (dep.Status == "failing") || failing != (dep.Error != "") {
+					t.Errorf("unexpected %s status %+v", name, dep)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyz_CachesReports(t *testing.T) {
+	database := &stubPinger{err: stderrors.New("down")}
+	h := newTestHealthHandler(staticWebhookHealth{Status: webhook.HealthOK}, &stubPinger{}, database)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	if code, _ := serveReadyz(t, h); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", code)
+	}
+	database.err = nil
+	now = now.Add(readinessCacheTTL - time.Second)
+	if code, _ := serveReadyz(t, h); code != http.StatusServiceUnavailable || database.calls != 1 {
+		t.Errorf("expected the cached 503 and one check, got %d and %d checks", code, database.calls)
+	}
+	now = now.Add(time.Second)
+	if code, _ := serveReadyz(t, h); code != http.StatusOK || database.calls != 2 {
+		t.Errorf("expected dependencies checked again once the report expired, got %d and %d checks", code, database.calls)
+	}
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// lifecycleWebhookStore stores the lifecycle webhooks users register
+type lifecycleWebhookStore interface {
+	CreateLifecycleWebhook(ctx context.Context, arg *sqlc.CreateLifecycleWebhookParams) (*sqlc.LifecycleWebhook, error)
+	GetLifecycleWebhook(ctx context.Context, arg *sqlc.GetLifecycleWebhookParams) (*sqlc.LifecycleWebhook, error)
+	ListLifecycleWebhooks(ctx context.Context, userID string) ([]*sqlc.LifecycleWebhook, error)
+	UpdateLifecycleWebhook(ctx context.Context, arg *sqlc.UpdateLifecycleWebhookParams) (*sqlc.LifecycleWebhook, error)
+	DeleteLifecycleWebhook(ctx context.Context, arg *sqlc.DeleteLifecycleWebhookParams) (int64, error)
+}
+
+// urlValidator refuses webhook destinations the platform does not deliver to
+type urlValidator interface {
+	ValidateURL(ctx context.Context, rawURL string) error
+}
+
+// LifecycleWebhookRequest is the request body for POST and PUT /api/v1/webhooks. AgentID
+// is only read on creation; without it the webhook receives the events of all of the
+// user's agents.
+type LifecycleWebhookRequest struct {
+	AgentID string `json:"agent_id,omitempty" validate:"max=128"`
+	URL     string `json:"url" validate:"required,url"`
+	Secret  string `json:"secret,omitempty"` // optional HMAC secret
+}
+
+// LifecycleWebhookResponse is a registered lifecycle webhook. Its secret is never returned.
+type LifecycleWebhookResponse struct {
+	ID        int64  `json:"id"`
+	UserID    string `json:"user_id"`
+	AgentID   string `json:"agent_id,omitempty"`
+	URL       string `json:"url"`
+	HasSecret bool   `json:"has_secret"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// ListLifecycleWebhooksResponse is the response for GET /api/v1/webhooks
+type ListLifecycleWebhooksResponse struct {
+	Webhooks []LifecycleWebhookResponse `json:"webhooks"`
+	Total    int                        `json:"total"`
+}
+
+// LifecycleWebhookHandler manages the webhooks users are told about agent lifecycle events
+// with (agent.created, agent.ready, agent.deleted, agent.failed)
+type LifecycleWebhookHandler struct {
+	webhooks   lifecycleWebhookStore
+	urls       urlValidator
+	bodyLimits BodyLimits
+}
+
+// NewLifecycleWebhookHandler creates a new lifecycle webhook handler
+func NewLifecycleWebhookHandler(pool *pgxpool.Pool, webhookDelivery *webhook.DeliveryService, bodyLimits BodyLimits) *LifecycleWebhookHandler {
+	return &LifecycleWebhookHandler{webhooks: sqlc.New(pool), urls: webhookDelivery, bodyLimits: bodyLimits}
+}
+
+// Register registers lifecycle webhook routes. They act for the user_id query param, or
+// else the authenticated caller.
+func (h *LifecycleWebhookHandler) Register(e *echo.Echo) {
+	g := e.Group("/api/v1/webhooks", RequireUser)
+	g.POST("", h.Create, BodyLimit(h.bodyLimits.Default))
+	g.GET("", h.List)
+	g.GET("/:id", h.Get)
+	g.PUT("/:id", h.Update, BodyLimit(h.bodyLimits.Default))
+	g.DELETE("/:id", h.Delete)
+}
+
+// Create handles POST /api/v1/webhooks?user_id=xxx
+func (h *LifecycleWebhookHandler) Create(c echo.Context) error {
+	userID, err := lifecycleUserID(c)
+	if err != nil {
+		return err
+	}
+	var req LifecycleWebhookRequest
+	if err := Bind(c, &req); err != nil {
+		return err
+	}
+	if err := h.validateURL(c, req.URL); err != nil {
+		return err
+	}
+
+	hook, err := h.webhooks.CreateLifecycleWebhook(c.Request().Context(), &sqlc.CreateLifecycleWebhookParams{
+		UserID:  userID,
+		AgentID: sql.NullString{String: req.AgentID, Valid: req.AgentID != ""},
+		Url:     req.URL,
+		Secret:  sql.NullString{String: req.Secret, Valid: req.Secret != ""},
+	})
+	if err != nil {
+		return errors.InternalError("failed to create lifecycle webhook")
+	}
+	return c.JSON(http.StatusCreated, lifecycleWebhookToResponse(hook))
+}
+
+// List handles GET /api/v1/webhooks?user_id=xxx
+func (h *LifecycleWebhookHandler) List(c echo.Context) error {
+	userID, err := lifecycleUserID(c)
+	if err != nil {
+		return err
+	}
+	hooks, err := h.webhooks.ListLifecycleWebhooks(c.Request().Context(), userID)
+	if err != nil {
+		return errors.InternalError("failed to list lifecycle webhooks")
+	}
+
+	resp := ListLifecycleWebhooksResponse{Webhooks: make([]LifecycleWebhookResponse, 0, len(hooks)), Total: len(hooks)}
+	for _, hook := range hooks {
+		resp.Webhooks = append(resp.Webhooks, lifecycleWebhookToResponse(hook))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Get handles GET /api/v1/webhooks/:id?user_id=xxx
+func (h *LifecycleWebhookHandler) Get(c echo.Context) error {
+	userID, id, err := lifecycleWebhookID(c)
+	if err != nil {
+		return err
+	}
+	hook, err := h.webhooks.GetLifecycleWebhook(c.Request().Context(), &sqlc.GetLifecycleWebhookParams{ID: id, UserID: userID})
+	if stderrors.Is(err, pgx.ErrNoRows) {
+		return errors.NotFound("lifecycle webhook not found")
+	}
+	if err != nil {
+		return errors.InternalError("failed to get lifecycle webhook")
+	}
+	return c.JSON(http.StatusOK, lifecycleWebhookToResponse(hook))
+}
+
+// Update handles PUT /api/v1/webhooks/:id?user_id=xxx, replacing the webhook's URL and
+// secret
+func (h *LifecycleWebhookHandler) Update(c echo.Context) error {
+	userID, id, err := lifecycleWebhookID(c)
+	if err != nil {
+		return err
+	}
+	var req LifecycleWebhookRequest
+	if err := Bind(c, &req); err != nil {
+		return err
+	}
+	if err := h.validateURL(c, req.URL); err != nil {
+		return err
+	}
+
+	hook, err := h.webhooks.UpdateLifecycleWebhook(c.Request().Context(), &sqlc.UpdateLifecycleWebhookParams{
+		ID:     id,
+		UserID: userID,
+		Url:    req.URL,
+		Secret: sql.NullString{String: req.Secret, Valid: req.Secret != ""},
+	})
+	if stderrors.Is(err, pgx.ErrNoRows) {
+		return errors.NotFound("lifecycle webhook not found")
+	}
+	if err != nil {
+		return errors.InternalError("failed to update lifecycle webhook")
+	}
+	return c.JSON(http.StatusOK, lifecycleWebhookToResponse(hook))
+}
+
+// Delete handles DELETE /api/v1/webhooks/:id?user_id=xxx
+func (h *LifecycleWebhookHandler) Delete(c echo.Context) error {
+	userID, id, err := lifecycleWebhookID(c)
+	if err != nil {
+		return err
+	}
+	deleted, err := h.webhooks.DeleteLifecycleWebhook(c.Request().Context(), &sqlc.DeleteLifecycleWebhookParams{ID: id, UserID: userID})
+	if err != nil {
+		return errors.InternalError("failed to delete lifecycle webhook")
+	}
+	if deleted == 0 {
+		return errors.NotFound("lifecycle webhook not found")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// validateURL returns a 400 unless rawURL is a URL the platform delivers webhooks to
+func (h *LifecycleWebhookHandler) validateURL(c echo.Context, rawURL string) error {
+	if err := h.urls.ValidateURL(c.Request().Context(), rawURL); err != nil {
+		if stderrors.Is(err, webhook.ErrURLRejected) {
+			return errors.BadRequest(err.Error()).WithErrorCode(webhook.ErrorCodeURLRejected)
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// lifecycleUserID returns the user a request is for: the user_id query param, or else the
+// authenticated caller
+func lifecycleUserID(c echo.Context) (string, error) {
+	if userID := c.QueryParam("user_id"); userID != "" {
+		return userID, nil
+	}
+	if p, ok := PrincipalFrom(c); ok && !p.Admin {
+		return p.UserID, nil
+	}
+	return "", errors.BadRequest("user_id query param is required")
+}
+
+// lifecycleWebhookID returns the user a request is for and the :id path param
+func lifecycleWebhookID(c echo.Context) (string, int64, error) {
+	userID, err := lifecycleUserID(c)
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return "", 0, errors.BadRequest("invalid webhook id")
+	}
+	return userID, id, nil
+}
+
+// lifecycleWebhookToResponse converts a stored lifecycle webhook to the API representation
+func lifecycleWebhookToResponse(hook *sqlc.LifecycleWebhook) LifecycleWebhookResponse {
+	return LifecycleWebhookResponse{
+		ID:        hook.ID,
+		UserID:    hook.UserID,
+		AgentID:   hook.AgentID.String,
+		URL:       hook.Url,
+		HasSecret: hook.Secret.Valid,
+		CreatedAt: hook.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: hook.UpdatedAt.Format(time.RFC3339),
+	}
+}
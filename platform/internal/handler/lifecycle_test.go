@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// fakeLifecycleWebhookStore keeps lifecycle webhooks in memory
+type fakeLifecycleWebhookStore struct {
+	hooks []*sqlc.LifecycleWebhook
+}
+
+func (f *fakeLifecycleWebhookStore) CreateLifecycleWebhook(_ context.Context, arg *sqlc.CreateLifecycleWebhookParams) (*sqlc.LifecycleWebhook, error) {
+	hook := &sqlc.LifecycleWebhook{
+		ID:        int64(len(f.hooks) + 1),
+		UserID:    arg.UserID,
+		AgentID:   arg.AgentID,
+		Url:       arg.Url,
+		Secret:    arg.Secret,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	f.hooks = append(f.hooks, hook)
+	return hook, nil
+}
+
+func (f *fakeLifecycleWebhookStore) find(id int64, userID string) *sqlc.LifecycleWebhook {
+	for _, hook := range f.hooks {
+		if hook.ID == id && hook.UserID == userID {
+			return hook
+		}
+	}
+	return nil
+}
+
+func (f *fakeLifecycleWebhookStore) GetLifecycleWebhook(_ context.Context, arg *sqlc.GetLifecycleWebhookParams) (*sqlc.LifecycleWebhook, error) {
+	if hook := f.find(arg.ID, arg.UserID); hook != nil {
+		return hook, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeLifecycleWebhookStore) ListLifecycleWebhooks(_ context.Context, userID string) ([]*sqlc.LifecycleWebhook, error) {
+	var hooks []*sqlc.LifecycleWebhook
+	for _, hook := range f.hooks {
+		if hook.UserID == userID {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (f *fakeLifecycleWebhookStore) UpdateLifecycleWebhook(_ context.Context, arg *sqlc.UpdateLifecycleWebhookParams) (*sqlc.LifecycleWebhook, error) {
+	hook := f.find(arg.ID, arg.UserID)
+	if hook == nil {
+		return nil, pgx.ErrNoRows
+	}
+	hook.Url, hook.Secret = arg.Url, arg.Secret
+	return hook, nil
+}
+
+func (f *fakeLifecycleWebhookStore) DeleteLifecycleWebhook(_ context.Context, arg *sqlc.DeleteLifecycleWebhookParams) (int64, error) {
+	for i, hook := range f.hooks {
+		if hook.ID == arg.ID && hook.UserID == arg.UserID {
+			f.hooks = append(f.hooks[:i], f.hooks[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// hostValidator rejects URLs on one host
+type hostValidator string
+
+func (h hostValidator) ValidateURL(_ context.Context, rawURL string) error {
+	if strings.Contains(rawURL, string(h)) {
+		return fmt.Errorf("%w: %s is refused", webhook.ErrURLRejected, h)
+	}
+	return nil
+}
+
+func TestLifecycleWebhooks_CRUD(t *testing.T) {
+	store := &fakeLifecycleWebhookStore{}
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	(&LifecycleWebhookHandler{webhooks: store, urls: hostValidator("10.0.0.1"), bodyLimits: BodyLimits{Default: 64 << 10}}).Register(e)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/api/v1/webhooks", `{"url":"https://example.com/hook"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without a user_id, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/api/v1/webhooks?user_id=user1", `{"url":"http://10.0.0.1/hook"}`); rec.Code != http.StatusBadRequest ||
+		!strings.Contains(rec.Body.String(), webhook.ErrorCodeURLRejected) {
+		t.Errorf("expected the refused URL to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := serve(http.MethodPost, "/api/v1/webhooks?user_id=user1", `{"agent_id":"agent1","url":"https://example.com/hook","secret":"s3cret"}`)
+	var created LifecycleWebhookResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	if created.AgentID != "agent1" || !created.HasSecret || strings.Contains(rec.Body.String(), "s3cret") {
+		t.Errorf("expected agent1's webhook without its secret, got %s", rec.Body.String())
+	}
+
+	if rec := serve(http.MethodGet, "/api/v1/webhooks/1?user_id=user2", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for another user's webhook, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	rec = serve(http.MethodPut, "/api/v1/webhooks/1?user_id=user1", `{"url":"https://example.com/other"}`)
+	var updated LifecycleWebhookResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &updated); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if updated.URL != "https://example.com/other" || updated.HasSecret {
+		t.Errorf("expected the URL and secret replaced, got %+v", updated)
+	}
+
+	rec = serve(http.MethodGet, "/api/v1/webhooks?user_id=user1", "")
+	var list ListLifecycleWebhooksResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); rec.Code != http.StatusOK || err != nil || list.Total != 1 {
+		t.Errorf("expected one webhook, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := serve(http.MethodDelete, "/api/v1/webhooks/1?user_id=user1", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d deleting the webhook, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/api/v1/webhooks/1?user_id=user1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d deleting it again, got %d", http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// deliveryPurger deletes delivery records past their retention
+type deliveryPurger interface {
+	PurgeDeliveries(ctx context.Context, dryRun bool) (webhook.PurgeResult, error)
+}
+
+// MaintenanceHandler runs maintenance jobs on demand for operators
+type MaintenanceHandler struct {
+	purger deliveryPurger
+}
+
+// NewMaintenanceHandler creates a new maintenance handler
+func NewMaintenanceHandler(webhookDelivery *webhook.DeliveryService) *MaintenanceHandler {
+	return &MaintenanceHandler{purger: webhookDelivery}
+}
+
+// Register registers maintenance routes
+func (h *MaintenanceHandler) Register(e *echo.Echo) {
+	e.POST("/api/v1/admin/maintenance/purge", h.Purge)
+}
+
+// Purge handles POST /api/v1/admin/maintenance/purge, deleting the delivery records past
+// their retention as the background purge does. With ?dry_run=true it only counts them.
+func (h *MaintenanceHandler) Purge(c echo.Context) error {
+	dryRun := false
+	if raw := c.QueryParam("dry_run"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			return errors.BadRequest("dry_run must be true or false")
+		}
+	}
+
+	result, err := h.purger.PurgeDeliveries(c.Request().Context(), dryRun)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/webhook"
+)
+
+type fakePurger struct {
+	calls  int
+	dryRun bool
+}
+
+func (f *fakePurger) PurgeDeliveries(_ context.Context, dryRun bool) (webhook.PurgeResult, error) {
+	f.calls++
+	f.dryRun = dryRun
+	return webhook.PurgeResult{DryRun: dryRun, Completed: 4, Failed: 1}, nil
+}
+
+func setupMaintenance(purger deliveryPurger) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	(&MaintenanceHandler{purger: purger}).Register(e)
+	return e
+}
+
+func TestPurge(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantDryRun bool
+	}{
+		{"purges by default", "", false},
+		{"dry run", "?dry_run=true", true},
+		{"explicitly not a dry run", "?dry_run=false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			purger := &fakePurger{}
+			e := setupMaintenance(purger)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/maintenance/purge"+tt.query, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if purger.calls != 1 || purger.dryRun != tt.wantDryRun {
+				t.Errorf("expected one purge with dry run %v, got %d with %v", tt.wantDryRun, purger.calls, purger.dryRun)
+			}
+			var resp webhook.PurgeResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if resp != (webhook.PurgeResult{DryRun: tt.wantDryRun, Completed: 4, Failed: 1}) {
+				t.Errorf("unexpected response %+v", resp)
+			}
+		})
+	}
+}
+
+func TestPurge_InvalidDryRun(t *testing.T) {
+	purger := &fakePurger{}
+	e := setupMaintenance(purger)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/maintenance/purge?dry_run=maybe", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+	if purger.calls != 0 {
+		t.Error("expected no purge")
+	}
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsHandler serves the metrics of the platform for Prometheus to scrape
+type MetricsHandler struct {
+	registry *prometheus.Registry
+}
+
+// NewMetricsHandler creates a handler serving the metrics in registry
+func NewMetricsHandler(registry *prometheus.Registry) *MetricsHandler {
+	return &MetricsHandler{registry: registry}
+}
+
+// Register registers the metrics route
+func (h *MetricsHandler) Register(e *echo.Echo) {
+	e.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(h.registry, promhttp.HandlerOpts{Registry: h.registry})))
+}
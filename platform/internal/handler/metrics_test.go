@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/metrics"
+)
+
+func TestMetrics_ServesRegistry(t *testing.T) {
+	reg := metrics.NewRegistry()
+	m := metrics.New(reg)
+	m.WebhookAttempted(http.StatusInternalServerError)
+
+	e := echo.New()
+	NewMetricsHandler(reg).Register(e)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`forge_webhook_delivery_attempts_total{status="500"} 1`, "go_goroutines"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the scrape", want)
+		}
+	}
+}
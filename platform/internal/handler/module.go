@@ -3,6 +3,8 @@ package handler
 import (
 	"github.com/labstack/echo/v4"
 	"go.uber.org/fx"
+
+	"github.com/forge/platform/internal/selftest"
 )
 
 // AsHandler annotates a handler constructor to be part of the handlers group
@@ -30,8 +32,19 @@ func RegisterAll(p RegisterHandlersParams) {
 
 // Module provides all handlers to the fx container
 var Module = fx.Module("handler",
+	selftest.Module,
 	fx.Provide(
+		NewBodyLimits,
 		AsHandler(NewHealthHandler),
+		AsHandler(NewWebhookAdminHandler),
+		AsHandler(NewDeliveryAdminHandler),
+		AsHandler(NewSelftestHandler),
+		AsHandler(NewRolloutHandler),
+		AsHandler(NewAPIKeyHandler),
+		AsHandler(NewLifecycleWebhookHandler),
+		AsHandler(NewWebhookTestHandler),
+		AsHandler(NewMetricsHandler),
+		AsHandler(NewMaintenanceHandler),
 	),
 	fx.Invoke(RegisterAll),
 )
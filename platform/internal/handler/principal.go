@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+)
+
+// principalContextKey is the echo.Context key holding the request's Principal
+const principalContextKey = "forge.principal"
+
+// HeaderAPIKey is the header callers may pass their API key in instead of an Authorization
+// bearer header
+const HeaderAPIKey = "X-API-Key"
+
+// WSBearerProtocol is the WebSocket subprotocol browsers, which cannot set headers on an
+// upgrade, offer ahead of their token: "Sec-WebSocket-Protocol: bearer, <token>"
+const WSBearerProtocol = "bearer"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID string
+	Admin  bool // may act for any user
+}
+
+// ErrInvalidToken is returned by an Authenticator for a token it does not accept
+var ErrInvalidToken = stderrors.New("invalid token")
+
+// Authenticator resolves the caller a bearer token was issued to
+type Authenticator interface {
+	// Authenticate returns the Principal token belongs to, or an error wrapping
+	// ErrInvalidToken if the token is not valid
+	Authenticate(ctx context.Context, token string) (Principal, error)
+}
+
+// SetPrincipal records the authenticated caller of a request, for RequireUser and handlers
+func SetPrincipal(c echo.Context, p Principal) {
+	c.Set(principalContextKey, p)
+}
+
+// PrincipalFrom returns the authenticated caller of a request, if it has one
+func PrincipalFrom(c echo.Context) (Principal, bool) {
+	p, ok := c.Get(principalContextKey).(Principal)
+	return p, ok
+}
+
+// RequireUser is route middleware refusing with 403 a caller that is neither the user the
+// request is for (the :user_id path param, or else the user_id query param) nor an admin.
+// Requests without a Principal, which only routes left unauthenticated have, pass unchanged.
+func RequireUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p, ok := PrincipalFrom(c)
+		if !ok || p.Admin {
+			return next(c)
+		}
+		userID := c.Param("user_id")
+		if userID == "" {
+			userID = c.QueryParam("user_id")
+		}
+		if userID != "" && userID != p.UserID {
+			return errors.Forbidden("not allowed to act for user " + userID)
+		}
+		return next(c)
+	}
+}
+
+// RequireAdmin is route middleware refusing with 403 a caller that is not an admin.
+// Requests without a Principal, which only routes left unauthenticated have, pass unchanged.
+func RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if p, ok := PrincipalFrom(c); ok && !p.Admin {
+			return errors.Forbidden("admin API key required")
+		}
+		return next(c)
+	}
+}
+
+// RequestToken returns the token a request authenticates with: an Authorization bearer
+// header or an X-API-Key header. WebSocket upgrades may instead pass it in the token query
+// param or after the bearer subprotocol, which take precedence.
+func RequestToken(r *http.Request) string {
+	if isWebSocketUpgrade(r) {
+		if token := r.URL.Query().Get("token"); token != "" {
+			return token
+		}
+		protocols := WebSocketProtocols(r)
+		if i := slices.Index(protocols, WSBearerProtocol); i >= 0 && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	if auth := r.Header.Get(echo.HeaderAuthorization); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return auth[len("Bearer "):]
+	}
+	return r.Header.Get(HeaderAPIKey)
+}
+
+// WebSocketProtocols returns the subprotocols a client offered on its upgrade
+func WebSocketProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+// isWebSocketUpgrade reports whether r asks to upgrade to a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(echo.HeaderUpgrade), "websocket")
+}
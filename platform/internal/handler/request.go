@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+)
+
+// BodyLimits are the most bytes accepted in the request bodies of the API's routes. Routes
+// taking a message's content get Message; the other routes with a JSON body get Default.
+// File uploads are limited by AGENT_FILE_MAX_BYTES instead.
+type BodyLimits struct {
+	Default int64
+	Message int64
+}
+
+// NewBodyLimits returns the body limits set by REQUEST_BODY_MAX_BYTES and MESSAGE_BODY_MAX_BYTES
+func NewBodyLimits(cfg *config.Config) BodyLimits {
+	return BodyLimits{Default: cfg.RequestBodyMaxBytes, Message: cfg.MessageBodyMaxBytes}
+}
+
+// ErrorCodeValidationFailed is the error code of a request body whose fields fail validation
+const ErrorCodeValidationFailed = "validation_failed"
+
+// BodyLimit is route middleware refusing with 413 a request body larger than limit bytes.
+// Bodies announcing their length are refused up front; others once reading passes limit,
+// which Bind reports.
+func BodyLimit(limit int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := limitBody(c, limit); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// limitBody refuses a request body announcing more than limit bytes and fails reads of
+// the body once they pass limit
+func limitBody(c echo.Context, limit int64) error {
+	req := c.Request()
+	if req.ContentLength > limit {
+		return bodyTooLarge(limit)
+	}
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+	return nil
+}
+
+// ReadBody reads a whole request body of at most limit bytes, for middleware that needs it
+// before the handler, and leaves it in place to be read again. A larger body is a 413.
+func ReadBody(c echo.Context, limit int64) ([]byte, error) {
+	if err := limitBody(c, limit); err != nil {
+		return nil, err
+	}
+	req := c.Request()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if stderrors.As(err, &tooLarge) {
+			return nil, bodyTooLarge(tooLarge.Limit)
+		}
+		return nil, errors.BadRequest("failed to read request body")
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// bodyTooLarge is the 413 of a request body larger than limit bytes
+func bodyTooLarge(limit int64) *errors.AppError {
+	return errors.PayloadTooLarge(fmt.Sprintf("request body exceeds %d bytes", limit))
+}
+
+// Bind binds the request body into req and validates it with Validate. A body that cannot
+// be bound is a 400, or a 413 if it is larger than the route's BodyLimit.
+func Bind(c echo.Context, req any) error {
+	if err := c.Bind(req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if stderrors.As(err, &tooLarge) {
+			return bodyTooLarge(tooLarge.Limit)
+		}
+		return errors.BadRequest("invalid request body")
+	}
+	return Validate(req)
+}
+
+// FieldError is a request field failing validation
+type FieldError struct {
+	// Field is the field's JSON path, e.g. "webhooks[1].url"
+	Field string `json:"field"`
+	// Rule is the validation rule the field fails, e.g. "required" or "max"
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationDetails are the details of a 400 validation_failed, listing each failing field
+type ValidationDetails struct {
+	Fields []FieldError `json:"fields"`
+}
+
+// validate checks the `validate` tags of request structs, naming fields by their JSON names
+var validate = newValidate()
+
+// embeddedName names embedded structs, which have no JSON name of their own since the JSON
+// encoding flattens their fields
+const embeddedName = "~"
+
+func newValidate() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" {
+			return embeddedName
+		}
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// Validate checks req's `validate` tags, returning a 400 validation_failed listing each
+// failing field
+func Validate(req any) error {
+	err := validate.Struct(req)
+	if err == nil {
+		return nil
+	}
+	var invalid validator.ValidationErrors
+	if !stderrors.As(err, &invalid) {
+		return errors.InternalError("failed to validate request: " + err.Error())
+	}
+
+	details := ValidationDetails{Fields: make([]FieldError, 0, len(invalid))}
+	messages := make([]string, 0, len(invalid))
+	for _, fe := range invalid {
+		field := fieldPath(fe)
+		msg := field + " " + ruleMessage(fe)
+		details.Fields = append(details.Fields, FieldError{Field: field, Rule: fe.Tag(), Message: msg})
+		messages = append(messages, msg)
+	}
+	return errors.BadRequest(strings.Join(messages, "; ")).
+		WithErrorCode(ErrorCodeValidationFailed).
+		WithDetails(details)
+}
+
+// fieldPath returns the JSON path of a failing field, without the request struct's name.
+// Fields of embedded structs are named as the JSON encoding flattens them.
+func fieldPath(fe validator.FieldError) string {
+	_, path, _ := strings.Cut(fe.Namespace(), ".")
+	parts := strings.Split(path, ".")
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != embeddedName {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, ".")
+}
+
+// ruleMessage describes the rule a field fails
+func ruleMessage(fe validator.FieldError) string {
+	counted := fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map || fe.Kind() == reflect.Array
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max", "lte":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		if counted {
+			return "must have at most " + fe.Param() + " items"
+		}
+		return "must be at most " + fe.Param()
+	case "min", "gte":
+		if fe.Kind() == reflect.String {
+			return "must be at least " + fe.Param() + " characters"
+		}
+		if counted && fe.Param() == "1" {
+			return "must not be empty"
+		}
+		if counted {
+			return "must have at least " + fe.Param() + " items"
+		}
+		return "must be at least " + fe.Param()
+	case "url":
+		return "must be a URL"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	default:
+		return "fails " + fe.Tag()
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+)
+
+type testSettings struct {
+	Mode string `json:"mode,omitempty" validate:"omitempty,oneof=fast slow"`
+}
+
+type testEndpoint struct {
+	URL string `json:"url" validate:"required,url"`
+}
+
+type testRequest struct {
+	Name      string         `json:"name" validate:"required,max=5"`
+	Count     int            `json:"count,omitempty" validate:"gte=0,lte=10"`
+	Endpoints []testEndpoint `json:"endpoints,omitempty" validate:"max=2,dive"`
+	testSettings
+}
+
+// bindTestServer serves POST /test, binding testRequest behind a body limit of limit bytes
+func bindTestServer(limit int64) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	e.POST("/test", func(c echo.Context) error {
+		var req testRequest
+		if err := Bind(c, &req); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, req)
+	}, BodyLimit(limit))
+	return e
+}
+
+// postTest posts body to /test, with its length unknown up front if chunked
+func postTest(e *echo.Echo, body string, chunked bool) *httptest.ResponseRecorder {
+	var reader io.Reader = strings.NewReader(body)
+	if chunked {
+		reader = io.MultiReader(reader)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/test", reader)
+	if chunked {
+		req.ContentLength = -1
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBodyLimit(t *testing.T) {
+	e := bindTestServer(64)
+	fits := `{"name":"ab","mode":"fast"}`
+	tooLarge := `{"name":"ab","mode":"fast","count":1` + strings.Repeat(" ", 64) + `}`
+
+	for _, chunked := range []bool{false, true} {
+		if rec := postTest(e, fits, chunked); rec.Code != http.StatusOK {
+			t.Errorf("chunked=%v: expected a body within the limit to pass, got %d: %s", chunked, rec.Code, rec.Body.String())
+		}
+		rec := postTest(e, tooLarge, chunked)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked=%v: expected status %d, got %d: %s", chunked, http.StatusRequestEntityTooLarge, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := postTest(e, `{"name":`, false); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for malformed JSON, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestBind_ListsEachFailingField(t *testing.T) {
+	e := bindTestServer(64 << 10)
+
+	rec := postTest(e, `{"name":"abcdef","count":11,"endpoints":[{"url":"https://a.example"},{"url":""}],"mode":"medium"}`, false)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Error   string            `json:"error"`
+		Message string            `json:"message"`
+		Details ValidationDetails `json:"details"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != ErrorCodeValidationFailed {
+		t.Errorf("expected error code %q, got %q", ErrorCodeValidationFailed, body.Error)
+	}
+	want := []FieldError{
+		{Field: "name", Rule: "max", Message: "name must be at most 5 characters"},
+		{Field: "count", Rule: "lte", Message: "count must be at most 10"},
+		{Field: "endpoints[1].url", Rule: "required", Message: "endpoints[1].url is required"},
+		{Field: "mode", Rule: "oneof", Message: "mode must be one of fast, slow"},
+	}
+	if len(body.Details.Fields) != len(want) {
+		t.Fatalf("expected fields %+v, got %+v", want, body.Details.Fields)
+	}
+	for i := range want {
+		if body.Details.Fields[i] != want[i] {
+			t.Errorf("field %d: expected %+v, got %+v", i, want[i], body.Details.Fields[i])
+		}
+	}
+	if !strings.Contains(body.Message, "name must be at most 5 characters; count must be at most 10") {
+		t.Errorf("expected the message to list the fields, got %q", body.Message)
+	}
+}
+
+func TestValidate_Boundaries(t *testing.T) {
+	endpoint := testEndpoint{URL: "https://a.example/hook"}
+	tests := []struct {
+		name  string
+		req   testRequest
+		valid bool
+	}{
+		{"at the limits", testRequest{Name: "abcde", Count: 10, Endpoints: []testEndpoint{endpoint, endpoint}}, true},
+		{"name too long", testRequest{Name: "abcdef"}, false},
+		{"name missing", testRequest{}, false},
+		{"negative count", testRequest{Name: "a", Count: -1}, false},
+		{"too many endpoints", testRequest{Name: "a", Endpoints: []testEndpoint{endpoint, endpoint, endpoint}}, false},
+		{"endpoint not a URL", testRequest{Name: "a", Endpoints: []testEndpoint{{URL: "not a url"}}}, false},
+		{"mode unset", testRequest{Name: "a", testSettings: testSettings{}}, true},
+		{"known mode", testRequest{Name: "a", testSettings: testSettings{Mode: "slow"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(&tt.req); (err == nil) != tt.valid {
+				t.Errorf("expected valid=%v, got %v", tt.valid, err)
+			}
+		})
+	}
+}
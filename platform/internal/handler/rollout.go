@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/k8s"
+)
+
+// rolloutManager manages the agent image rollout policy and reports how its cohorts fare
+type rolloutManager interface {
+	GetRollout(ctx context.Context) (*k8s.Rollout, error)
+	SetRollout(ctx context.Context, imageTag string, percent int, users []string) (*k8s.Rollout, error)
+	DeleteRollout(ctx context.Context) error
+	RolloutMetrics() []k8s.RolloutMetrics
+}
+
+// SetRolloutRequest is the request body for PUT /api/v1/admin/rollout
+type SetRolloutRequest struct {
+	ImageTag string   `json:"image_tag" validate:"required,max=128"`
+	Percent  int      `json:"percent" validate:"gte=0,lte=100"`
+	Users    []string `json:"users,omitempty" validate:"max=1000,dive,required"` // always run the rollout's tag
+}
+
+// RolloutMetricsResponse is the response for GET /api/v1/admin/rollout/metrics
+type RolloutMetricsResponse struct {
+	Metrics []k8s.RolloutMetrics `json:"metrics"`
+}
+
+// RolloutHandler manages the gradual rollout of new agent image tags
+type RolloutHandler struct {
+	rollouts   rolloutManager
+	bodyLimits BodyLimits
+}
+
+// NewRolloutHandler creates a new rollout handler
+func NewRolloutHandler(k8sManager *k8s.Manager, bodyLimits BodyLimits) *RolloutHandler {
+	return &RolloutHandler{rollouts: k8sManager, bodyLimits: bodyLimits}
+}
+
+// Register registers rollout routes
+func (h *RolloutHandler) Register(e *echo.Echo) {
+	e.GET("/api/v1/admin/rollout", h.GetRollout)
+	e.PUT("/api/v1/admin/rollout", h.SetRollout, BodyLimit(h.bodyLimits.Default))
+	e.DELETE("/api/v1/admin/rollout", h.DeleteRollout)
+	e.GET("/api/v1/admin/rollout/metrics", h.GetRolloutMetrics)
+}
+
+// GetRollout handles GET /api/v1/admin/rollout
+func (h *RolloutHandler) GetRollout(c echo.Context) error {
+	rollout, err := h.rollouts.GetRollout(c.Request().Context())
+	if err != nil {
+		if stderrors.Is(err, k8s.ErrNoRollout) {
+			return errors.NotFound("no rollout is active")
+		}
+		return errors.InternalError(err.Error())
+	}
+	return c.JSON(http.StatusOK, rollout)
+}
+
+// SetRollout handles PUT /api/v1/admin/rollout. Keeping the image tag and changing the
+// percentage ramps the current rollout; a new image tag starts a new one.
+func (h *RolloutHandler) SetRollout(c echo.Context) error {
+	var req SetRolloutRequest
+	if err := Bind(c, &req); err != nil {
+		return err
+	}
+	rollout, err := h.rollouts.SetRollout(c.Request().Context(), req.ImageTag, req.Percent, req.Users)
+	if err != nil {
+		if stderrors.Is(err, k8s.ErrInvalidRollout) {
+			return errors.BadRequest(err.Error())
+		}
+		return errors.InternalError(err.Error())
+	}
+	return c.JSON(http.StatusOK, rollout)
+}
+
+// DeleteRollout handles DELETE /api/v1/admin/rollout
+func (h *RolloutHandler) DeleteRollout(c echo.Context) error {
+	if err := h.rollouts.DeleteRollout(c.Request().Context()); err != nil {
+		if stderrors.Is(err, k8s.ErrNoRollout) {
+			return errors.NotFound("no rollout is active")
+		}
+		return errors.InternalError(err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetRolloutMetrics handles GET /api/v1/admin/rollout/metrics. Metrics are per replica
+// and reset when it restarts.
+func (h *RolloutHandler) GetRolloutMetrics(c echo.Context) error {
+	return c.JSON(http.StatusOK, RolloutMetricsResponse{Metrics: h.rollouts.RolloutMetrics()})
+}
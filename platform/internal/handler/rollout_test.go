@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/k8s"
+)
+
+func TestRollout_AdminRoutes(t *testing.T) {
+	mgr := k8s.NewManagerWithClientset(fake.NewSimpleClientset(), "default", "forge-agent:stable", "")
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	NewRolloutHandler(mgr, BodyLimits{Default: 64 << 10}).Register(e)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(http.MethodGet, "/api/v1/admin/rollout", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d without a rollout, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := serve(http.MethodPut, "/api/v1/admin/rollout", `{"image_tag":"v2","percent":150}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid percent, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec := serve(http.MethodPut, "/api/v1/admin/rollout", `{"image_tag":"v2","percent":100}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var rollout k8s.Rollout
+	if err := json.Unmarshal(rec.Body.Bytes(), &rollout); err != nil {
+		t.Fatalf("failed to decode rollout: %v", err)
+	}
+	if rollout.ID == "" || rollout.ImageTag != "v2" || rollout.Percent != 100 {
+		t.Errorf("unexpected rollout %+v", rollout)
+	}
+
+	if err := mgr.CreatePod(t.Context(), k8s.PodID{UserID: "user", AgentID: "agent"}); err != nil {
+		t.Fatalf("CreatePod failed: %v", err)
+	}
+	rec = serve(http.MethodGet, "/api/v1/admin/rollout/metrics", "")
+	var metrics RolloutMetricsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("failed to decode metrics: %v", err)
+	}
+	if len(metrics.Metrics) != 1 || metrics.Metrics[0].RolloutID != rollout.ID || metrics.Metrics[0].ImageTag != "v2" || metrics.Metrics[0].AgentsCreated != 1 {
+		t.Errorf("expected one agent created on rollout %s, got %+v", rollout.ID, metrics.Metrics)
+	}
+
+	if rec := serve(http.MethodDelete, "/api/v1/admin/rollout", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/api/v1/admin/rollout", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d deleting again, got %d", http.StatusNotFound, rec.Code)
+	}
+}
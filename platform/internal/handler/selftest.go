@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/selftest"
+)
+
+// selfTester starts self tests and reports how the last one went
+type selfTester interface {
+	Start(trigger string) error
+	Latest(ctx context.Context) (*selftest.Report, error)
+}
+
+// StartSelftestResponse is the response for POST /api/v1/admin/selftest
+type StartSelftestResponse struct {
+	Status string `json:"status"` // "running"
+}
+
+// SelftestHandler runs the end-to-end self test for operators
+type SelftestHandler struct {
+	runner selfTester
+}
+
+// NewSelftestHandler creates a new self test handler
+func NewSelftestHandler(runner *selftest.Runner) *SelftestHandler {
+	return &SelftestHandler{runner: runner}
+}
+
+// Register registers self test routes
+func (h *SelftestHandler) Register(e *echo.Echo) {
+	e.POST("/api/v1/admin/selftest", h.StartSelftest)
+	e.GET("/api/v1/admin/selftest/latest", h.GetLatestSelftest)
+}
+
+// StartSelftest handles POST /api/v1/admin/selftest. The self test runs in the background,
+// since it takes longer than a request may; its report is at GET /api/v1/admin/selftest/latest.
+func (h *SelftestHandler) StartSelftest(c echo.Context) error {
+	if err := h.runner.Start(selftest.TriggerManual); err != nil {
+		if stderrors.Is(err, selftest.ErrRunning) {
+			return errors.Conflict("a self test is already running")
+		}
+		return errors.InternalError(err.Error())
+	}
+	return c.JSON(http.StatusAccepted, StartSelftestResponse{Status: "running"})
+}
+
+// GetLatestSelftest handles GET /api/v1/admin/selftest/latest
+func (h *SelftestHandler) GetLatestSelftest(c echo.Context) error {
+	report, err := h.runner.Latest(c.Request().Context())
+	if err != nil {
+		if stderrors.Is(err, selftest.ErrNoReport) {
+			return errors.NotFound("no self test has been run")
+		}
+		return errors.InternalError(err.Error())
+	}
+	return c.JSON(http.StatusOK, report)
+}
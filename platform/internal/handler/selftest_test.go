@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/selftest"
+)
+
+type fakeSelfTester struct {
+	running bool
+	started []string
+	latest  *selftest.Report
+}
+
+func (f *fakeSelfTester) Start(trigger string) error {
+	if f.running {
+		return selftest.ErrRunning
+	}
+	f.running = true
+	f.started = append(f.started, trigger)
+	return nil
+}
+
+func (f *fakeSelfTester) Latest(context.Context) (*selftest.Report, error) {
+	if f.latest == nil {
+		return nil, selftest.ErrNoReport
+	}
+	return f.latest, nil
+}
+
+func setupSelftest(runner selfTester) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	(&SelftestHandler{runner: runner}).Register(e)
+	return e
+}
+
+func TestSelftest_StartAndLatest(t *testing.T) {
+	runner := &fakeSelfTester{}
+	e := setupSelftest(runner)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := serve(http.MethodGet, "/api/v1/admin/selftest/latest"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d before any self test, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	if rec := serve(http.MethodPost, "/api/v1/admin/selftest"); rec.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	if len(runner.started) != 1 || runner.started[0] != selftest.TriggerManual {
+		t.Errorf("expected one manual self test, got %v", runner.started)
+	}
+	if rec := serve(http.MethodPost, "/api/v1/admin/selftest"); rec.Code != http.StatusConflict {
+		t.Errorf("expected status %d while a self test runs, got %d", http.StatusConflict, rec.Code)
+	}
+
+	runner.latest = &selftest.Report{
+		Trigger:   selftest.TriggerManual,
+		Outcome:   selftest.OutcomeFailed,
+		RequestID: "selftest-1",
+		Steps: []selftest.StepResult{
+			{Step: selftest.StepCreateAgent, Outcome: selftest.OutcomeFailed, DurationMs: 1200, Error: "image pull backoff"},
+		},
+	}
+	rec := serve(http.MethodGet, "/api/v1/admin/selftest/latest")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var report selftest.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if report.Outcome != selftest.OutcomeFailed || len(report.Steps) != 1 || report.Steps[0].Error != "image pull backoff" {
+		t.Errorf("expected the latest report, got %+v", report)
+	}
+}
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+const (
+	defaultDeadLettersLimit = 50
+	maxDeadLettersLimit     = 500
+)
+
+// deadLetterLister lists webhook events the outbox gave up on
+type deadLetterLister interface {
+	DeadLetters(ctx context.Context, limit int32) ([]*sqlc.WebhookOutbox, error)
+}
+
+// circuitManager inspects and resets the per-URL webhook circuit breakers
+type circuitManager interface {
+	Circuits() []webhook.CircuitInfo
+	ResetCircuit(id string) (webhook.CircuitInfo, bool)
+}
+
+// requestSnapshotter assembles what is stored about one event of a request
+type requestSnapshotter interface {
+	SnapshotAt(ctx context.Context, requestID string, seq int64) (*webhook.RequestSnapshot, error)
+}
+
+// DeadLetterResponse is a webhook event that exhausted its delivery attempts.
+// The webhook URL is redacted and the signing secret is never returned.
+type DeadLetterResponse struct {
+	ID           int64           `json:"id"`
+	RequestID    string          `json:"request_id"`
+	Seq          int64           `json:"seq"`
+	WebhookURL   string          `json:"webhook_url"`
+	Payload      json.RawMessage `json:"payload"`
+	AttemptCount int32           `json:"attempt_count"`
+	LastError    string          `json:"last_error,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	FailedAt     time.Time       `json:"failed_at"`
+}
+
+// ListDeadLettersResponse is the response for GET /api/v1/admin/webhooks/dead-letters
+type ListDeadLettersResponse struct {
+	DeadLetters []DeadLetterResponse `json:"dead_letters"`
+	Total       int                  `json:"total"`
+}
+
+// CircuitResponse is the circuit breaker state of one webhook URL.
+// The URL is redacted; use the ID to reset the circuit.
+type CircuitResponse struct {
+	ID            string     `json:"id"`
+	WebhookURL    string     `json:"webhook_url"`
+	State         string     `json:"state"` // "closed", "open", "half_open"
+	Failures      int        `json:"failures"`
+	OpenUntil     *time.Time `json:"open_until,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+}
+
+// ListCircuitsResponse is the response for GET /api/v1/admin/webhooks/circuits
+type ListCircuitsResponse struct {
+	Circuits []CircuitResponse `json:"circuits"`
+	Total    int               `json:"total"`
+}
+
+// EventSummaryResponse briefly describes a stored event
+type EventSummaryResponse struct {
+	Seq               int64     `json:"seq"`
+	EventType         string    `json:"event_type"`
+	OpenCodeEventType string    `json:"opencode_event_type,omitempty"`
+	IsFinal           bool      `json:"is_final,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// DeliveryAttemptsResponse is the delivery state of an event for one webhook URL.
+// The webhook URL is redacted.
+type DeliveryAttemptsResponse struct {
+	WebhookURL    string     `json:"webhook_url"`
+	Status        string     `json:"status"` // "pending", "delivered", "dead_letter"
+	AttemptCount  int32      `json:"attempt_count"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // pending events only
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// RequestSnapshotResponse is the response for GET /api/v1/admin/requests/:request_id/at.
+// Payload and Raw are null when their store does not have the event.
+type RequestSnapshotResponse struct {
+	RequestID  string                     `json:"request_id"`
+	Seq        int64                      `json:"seq"`
+	Payload    json.RawMessage            `json:"payload"`
+	Raw        json.RawMessage            `json:"raw"`
+	Deliveries []DeliveryAttemptsResponse `json:"deliveries"`
+	Before     []EventSummaryResponse     `json:"before"`
+	After      []EventSummaryResponse     `json:"after"`
+}
+
+// WebhookAdminHandler exposes webhook delivery internals for operators
+type WebhookAdminHandler struct {
+	deadLetters deadLetterLister
+	circuits    circuitManager
+	snapshots   requestSnapshotter
+}
+
+// NewWebhookAdminHandler creates a new webhook admin handler
+func NewWebhookAdminHandler(webhookDelivery *webhook.DeliveryService) *WebhookAdminHandler {
+	return &WebhookAdminHandler{
+		deadLetters: webhookDelivery,
+		circuits:    webhookDelivery,
+		snapshots:   webhookDelivery,
+	}
+}
+
+// Register registers webhook admin routes
+func (h *WebhookAdminHandler) Register(e *echo.Echo) {
+	e.GET("/api/v1/admin/webhooks/dead-letters", h.ListDeadLetters)
+	e.GET("/api/v1/admin/webhooks/circuits", h.ListCircuits)
+	e.POST("/api/v1/admin/webhooks/circuits/:id/reset", h.ResetCircuit)
+	e.GET("/api/v1/admin/requests/:request_id/at", h.GetRequestSnapshot)
+}
+
+// ListDeadLetters handles GET /api/v1/admin/webhooks/dead-letters?limit=50
+func (h *WebhookAdminHandler) ListDeadLetters(c echo.Context) error {
+	limit := defaultDeadLettersLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxDeadLettersLimit {
+			return errors.BadRequest("limit must be between 1 and " + strconv.Itoa(maxDeadLettersLimit))
+		}
+		limit = n
+	}
+
+	events, err := h.deadLetters.DeadLetters(c.Request().Context(), int32(limit))
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	deadLetters := make([]DeadLetterResponse, 0, len(events))
+	for _, event := range events {
+		deadLetters = append(deadLetters, DeadLetterResponse{
+			ID:           event.ID,
+			RequestID:    event.RequestID,
+			Seq:          event.Seq,
+			WebhookURL:   webhook.RedactURL(event.WebhookUrl),
+			Payload:      event.Payload,
+			AttemptCount: event.AttemptCount,
+			LastError:    event.LastError.String,
+			CreatedAt:    event.CreatedAt,
+			FailedAt:     event.UpdatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, ListDeadLettersResponse{
+		DeadLetters: deadLetters,
+		Total:       len(deadLetters),
+	})
+}
+
+// ListCircuits handles GET /api/v1/admin/webhooks/circuits
+func (h *WebhookAdminHandler) ListCircuits(c echo.Context) error {
+	infos := h.circuits.Circuits()
+
+	circuits := make([]CircuitResponse, 0, len(infos))
+	for _, info := range infos {
+		circuits = append(circuits, circuitToResponse(info))
+	}
+
+	return c.JSON(http.StatusOK, ListCircuitsResponse{
+		Circuits: circuits,
+		Total:    len(circuits),
+	})
+}
+
+// ResetCircuit handles POST /api/v1/admin/webhooks/circuits/:id/reset
+func (h *WebhookAdminHandler) ResetCircuit(c echo.Context) error {
+	id := c.Param("id")
+	info, ok := h.circuits.ResetCircuit(id)
+	if !ok {
+		return errors.NotFound("circuit " + id + " not found")
+	}
+	return c.JSON(http.StatusOK, circuitToResponse(info))
+}
+
+// circuitToResponse converts circuit breaker state to the API representation
+func circuitToResponse(info webhook.CircuitInfo) CircuitResponse {
+	resp := CircuitResponse{
+		ID:         info.ID,
+		WebhookURL: webhook.RedactURL(info.URL),
+		State:      string(info.State),
+		Failures:   info.Failures,
+	}
+	if !info.OpenUntil.IsZero() {
+		resp.OpenUntil = &info.OpenUntil
+	}
+	if !info.LastFailure.IsZero() {
+		resp.LastFailureAt = &info.LastFailure
+	}
+	return resp
+}
+
+// GetRequestSnapshot handles GET /api/v1/admin/requests/:request_id/at?seq=45
+func (h *WebhookAdminHandler) GetRequestSnapshot(c echo.Context) error {
+	requestID := c.Param("request_id")
+	seq, err := strconv.ParseInt(c.QueryParam("seq"), 10, 64)
+	if err != nil || seq < 0 {
+		return errors.BadRequest("seq query param must be a non-negative integer")
+	}
+
+	snapshot, err := h.snapshots.SnapshotAt(c.Request().Context(), requestID, seq)
+	if err != nil {
+		if stderrors.Is(err, webhook.ErrSnapshotNotFound) {
+			return errors.NotFound("nothing stored for request " + requestID + " at seq " + strconv.FormatInt(seq, 10))
+		}
+		return errors.InternalError(err.Error())
+	}
+
+	resp := RequestSnapshotResponse{
+		RequestID:  snapshot.RequestID,
+		Seq:        snapshot.Seq,
+		Payload:    snapshot.Payload,
+		Raw:        snapshot.Raw,
+		Deliveries: make([]DeliveryAttemptsResponse, 0, len(snapshot.Deliveries)),
+		Before:     summariesToResponse(snapshot.Before),
+		After:      summariesToResponse(snapshot.After),
+	}
+	for _, d := range snapshot.Deliveries {
+		attempts := DeliveryAttemptsResponse{
+			WebhookURL:   webhook.RedactURL(d.WebhookUrl),
+			Status:       d.Status,
+			AttemptCount: d.AttemptCount,
+			LastError:    d.LastError.String,
+			CreatedAt:    d.CreatedAt,
+		}
+		if d.Status == webhook.OutboxStatusPending {
+			attempts.NextAttemptAt = &d.NextAttemptAt
+		}
+		if d.DeliveredAt.Valid {
+			attempts.DeliveredAt = &d.DeliveredAt.Time
+		}
+		resp.Deliveries = append(resp.Deliveries, attempts)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// summariesToResponse converts event summaries to the API representation
+func summariesToResponse(summaries []webhook.EventSummary) []EventSummaryResponse {
+	resp := make([]EventSummaryResponse, 0, len(summaries))
+	for _, s := range summaries {
+		resp = append(resp, EventSummaryResponse{
+			Seq:               s.Seq,
+			EventType:         s.EventType,
+			OpenCodeEventType: s.OpenCodeEventType,
+			IsFinal:           s.IsFinal,
+			CreatedAt:         s.CreatedAt,
+		})
+	}
+	return resp
+}
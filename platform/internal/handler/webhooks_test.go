@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+type fakeDeadLetters struct {
+	events    []*sqlc.WebhookOutbox
+	lastLimit int32
+}
+
+func (f *fakeDeadLetters) DeadLetters(_ context.Context, limit int32) ([]*sqlc.WebhookOutbox, error) {
+	f.lastLimit = limit
+	return f.events, nil
+}
+
+type fakeCircuits struct {
+	circuits []webhook.CircuitInfo
+	reset    []string
+}
+
+func (f *fakeCircuits) Circuits() []webhook.CircuitInfo {
+	return f.circuits
+}
+
+func (f *fakeCircuits) ResetCircuit(id string) (webhook.CircuitInfo, bool) {
+	for _, c := range f.circuits {
+		if c.ID == id {
+			f.reset = append(f.reset, id)
+			return webhook.CircuitInfo{ID: id, URL: c.URL, State: webhook.CircuitClosed}, true
+		}
+	}
+	return webhook.CircuitInfo{}, false
+}
+
+func setupWebhookAdmin(lister deadLetterLister) *echo.Echo {
+	return setupWebhookAdminWithCircuits(lister, &fakeCircuits{})
+}
+
+func setupWebhookAdminWithCircuits(lister deadLetterLister, circuits circuitManager) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	(&WebhookAdminHandler{deadLetters: lister, circuits: circuits}).Register(e)
+	return e
+}
+
+func TestListDeadLetters(t *testing.T) {
+	lister := &fakeDeadLetters{events: []*sqlc.WebhookOutbox{{
+		ID:            7,
+		RequestID:     "req-1",
+		Seq:           3,
+		WebhookUrl:    "https://example.com/hooks/token-123",
+		WebhookSecret: sql.NullString{String: "whsec_secret", Valid: true},
+		Payload:       []byte(`{"request_id":"req-1","seq":3}`),
+		Status:        "dead_letter",
+		AttemptCount:  5,
+		LastError:     sql.NullString{String: "webhook returned status 500", Valid: true},
+	}}}
+	e := setupWebhookAdmin(lister)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/webhooks/dead-letters?limit=10", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if lister.lastLimit != 10 {
+		t.Errorf("expected limit 10, got %d", lister.lastLimit)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "whsec_secret") || strings.Contains(body, "token-123") {
+		t.Errorf("response leaks webhook credentials: %s", body)
+	}
+
+	var resp ListDeadLettersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Total != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", resp.Total)
+	}
+	got := resp.DeadLetters[0]
+	if got.RequestID != "req-1" || got.Seq != 3 || got.AttemptCount != 5 || got.LastError != "webhook returned status 500" {
+		t.Errorf("unexpected dead letter: %+v", got)
+	}
+	if got.WebhookURL != "https://example.com/[redacted]" {
+		t.Errorf("expected redacted URL, got %s", got.WebhookURL)
+	}
+	if string(got.Payload) != `{"request_id":"req-1","seq":3}` {
+		t.Errorf("unexpected payload: %s", got.Payload)
+	}
+}
+
+func TestListDeadLetters_InvalidLimit(t *testing.T) {
+	e := setupWebhookAdmin(&fakeDeadLetters{})
+
+	for _, limit := range []string{"0", "501", "abc"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/webhooks/dead-letters?limit="+limit, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s: expected status 400, got %d", limit, rec.Code)
+		}
+	}
+}
+
+func TestListCircuits(t *testing.T) {
+	openUntil := time.Date(2025, 1, 1, 12, 5, 0, 0, time.UTC)
+	lastFailure := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	circuits := &fakeCircuits{circuits: []webhook.CircuitInfo{
+		{ID: "aaa", URL: "https://a.example.com/hooks/token-123", State: webhook.CircuitOpen, Failures: 5, OpenUntil: openUntil, LastFailure: lastFailure},
+		{ID: "bbb", URL: "https://b.example.com/hook", State: webhook.CircuitClosed},
+	}}
+	e := setupWebhookAdminWithCircuits(&fakeDeadLetters{}, circuits)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/webhooks/circuits", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); strings.Contains(body, "token-123") {
+		t.Errorf("response leaks webhook credentials: %s", body)
+	}
+
+	var resp ListCircuitsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.Total != 2 {
+		t.Fatalf("expected 2 circuits, got %d", resp.Total)
+	}
+	open := resp.Circuits[0]
+	if open.ID != "aaa" || open.State != "open" || open.Failures != 5 || open.WebhookURL != "https://a.example.com/[redacted]" {
+		t.Errorf("unexpected circuit: %+v", open)
+	}
+	if open.OpenUntil == nil || !open.OpenUntil.Equal(openUntil) || open.LastFailureAt == nil || !open.LastFailureAt.Equal(lastFailure) {
+		t.Errorf("expected open_until and last_failure_at, got %+v", open)
+	}
+	if closed := resp.Circuits[1]; closed.OpenUntil != nil || closed.LastFailureAt != nil {
+		t.Errorf("expected no timestamps for a closed circuit that never failed, got %+v", closed)
+	}
+}
+
+func TestResetCircuit(t *testing.T) {
+	circuits := &fakeCircuits{circuits: []webhook.CircuitInfo{
+		{ID: "aaa", URL: "https://a.example.com/hook", State: webhook.CircuitOpen, Failures: 5},
+	}}
+	e := setupWebhookAdminWithCircuits(&fakeDeadLetters{}, circuits)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/webhooks/circuits/aaa/reset", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp CircuitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp.State != "closed" || len(circuits.reset) != 1 {
+		t.Errorf("expected the circuit to be reset, got %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/webhooks/circuits/zzz/reset", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for an unknown circuit, got %d", rec.Code)
+	}
+}
+
+type fakeSnapshots struct {
+	snapshot *webhook.RequestSnapshot
+}
+
+func (f *fakeSnapshots) SnapshotAt(_ context.Context, requestID string, seq int64) (*webhook.RequestSnapshot, error) {
+	if f.snapshot == nil || f.snapshot.RequestID != requestID || f.snapshot.Seq != seq {
+		return nil, webhook.ErrSnapshotNotFound
+	}
+	return f.snapshot, nil
+}
+
+func setupRequestSnapshots(snapshots requestSnapshotter) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	(&WebhookAdminHandler{snapshots: snapshots}).Register(e)
+	return e
+}
+
+func TestGetRequestSnapshot(t *testing.T) {
+	created := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := setupRequestSnapshots(&fakeSnapshots{snapshot: &webhook.RequestSnapshot{
+		RequestID: "req-1",
+		Seq:       45,
+		Payload:   json.RawMessage(`{"request_id":"req-1","seq":45}`),
+		Deliveries: []*sqlc.WebhookOutbox{{
+			RequestID:     "req-1",
+			Seq:           45,
+			WebhookUrl:    "https://example.com/hooks/token-123",
+			Status:        webhook.OutboxStatusPending,
+			AttemptCount:  3,
+			NextAttemptAt: created.Add(time.Minute),
+			LastError:     sql.NullString{String: "webhook returned status 503", Valid: true},
+			CreatedAt:     created,
+		}},
+		Before: []webhook.EventSummary{{Seq: 44, EventType: "agent.event", OpenCodeEventType: "message.updated", CreatedAt: created}},
+		After:  []webhook.EventSummary{{Seq: 46, EventType: "agent.complete", IsFinal: true, CreatedAt: created}},
+	}})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/requests/req-1/at?seq=45", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "token-123") {
+		t.Errorf("response leaks the webhook URL path: %s", rec.Body.String())
+	}
+
+	var resp RequestSnapshotResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if string(resp.Payload) != `{"request_id":"req-1","seq":45}` {
+		t.Errorf("unexpected payload %s", resp.Payload)
+	}
+	// The raw response was not archived
+	if !strings.Contains(rec.Body.String(), `"raw":null`) {
+		t.Errorf("expected raw to be null, got %s", rec.Body.String())
+	}
+	if len(resp.Deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %+v", resp.Deliveries)
+	}
+	d := resp.Deliveries[0]
+	if d.WebhookURL != "https://example.com/[redacted]" || d.AttemptCount != 3 || d.LastError == "" || d.NextAttemptAt == nil || d.DeliveredAt != nil {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+	if len(resp.Before) != 1 || resp.Before[0].OpenCodeEventType != "message.updated" || len(resp.After) != 1 || !resp.After[0].IsFinal {
+		t.Errorf("unexpected surrounding events: before %+v after %+v", resp.Before, resp.After)
+	}
+}
+
+func TestGetRequestSnapshot_Errors(t *testing.T) {
+	e := setupRequestSnapshots(&fakeSnapshots{})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/v1/admin/requests/req-1/at", http.StatusBadRequest},
+		{"/api/v1/admin/requests/req-1/at?seq=-1", http.StatusBadRequest},
+		{"/api/v1/admin/requests/req-1/at?seq=abc", http.StatusBadRequest},
+		{"/api/v1/admin/requests/req-1/at?seq=45", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.path, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+}
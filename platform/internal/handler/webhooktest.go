@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// webhookTester sends sample payloads to webhook endpoints
+type webhookTester interface {
+	SendTestDelivery(ctx context.Context, webhookCfg webhook.Config, eventType webhook.EventType) (webhook.TestDeliveryResult, error)
+}
+
+// TestWebhookRequest is the request body for POST /api/v1/webhooks/test
+type TestWebhookRequest struct {
+	URL    string `json:"url" validate:"required,url"`
+	Secret string `json:"secret,omitempty"` // optional HMAC secret
+	// EventType is the type of sample payload to send (default agent.event)
+	EventType string `json:"event_type,omitempty"`
+}
+
+// WebhookTestHandler lets users check that an endpoint receives and verifies deliveries
+// before pointing real requests at it
+type WebhookTestHandler struct {
+	tester     webhookTester
+	bodyLimits BodyLimits
+}
+
+// NewWebhookTestHandler creates a new webhook test handler
+func NewWebhookTestHandler(webhookDelivery *webhook.DeliveryService, bodyLimits BodyLimits) *WebhookTestHandler {
+	return &WebhookTestHandler{tester: webhookDelivery, bodyLimits: bodyLimits}
+}
+
+// Register registers webhook test routes
+func (h *WebhookTestHandler) Register(e *echo.Echo) {
+	e.POST("/api/v1/webhooks/test", h.Test, BodyLimit(h.bodyLimits.Default))
+}
+
+// Test handles POST /api/v1/webhooks/test, sending a sample payload to the URL once and
+// returning how the endpoint answered. An endpoint that fails still returns 200, with the
+// failure in the result.
+func (h *WebhookTestHandler) Test(c echo.Context) error {
+	var req TestWebhookRequest
+	if err := Bind(c, &req); err != nil {
+		return err
+	}
+
+	webhookCfg := webhook.Config{URL: req.URL, Secret: req.Secret}
+	result, err := h.tester.SendTestDelivery(c.Request().Context(), webhookCfg, webhook.EventType(req.EventType))
+	switch {
+	case stderrors.Is(err, webhook.ErrURLRejected):
+		return errors.BadRequest(err.Error()).WithErrorCode(webhook.ErrorCodeURLRejected)
+	case stderrors.Is(err, webhook.ErrUnknownEventType):
+		return errors.BadRequest("event_type: " + err.Error())
+	case err != nil:
+		return errors.InternalError(err.Error())
+	}
+	return c.JSON(http.StatusOK, result)
+}
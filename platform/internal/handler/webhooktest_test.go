@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/webhook"
+)
+
+func setupWebhookTest(t *testing.T, cfg *config.Config) *echo.Echo {
+	t.Helper()
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	NewWebhookTestHandler(webhook.NewDeliveryServiceWithQuerier(nil, cfg, zap.NewNop()), BodyLimits{Default: 64 << 10}).Register(e)
+	return e
+}
+
+func postWebhookTest(e *echo.Echo, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/test", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookTest_Delivers(t *testing.T) {
+	var signature string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(webhook.SignatureHeader)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("down for maintenance"))
+	}))
+	t.Cleanup(receiver.Close)
+	e := setupWebhookTest(t, &config.Config{WebhookTimeout: time.Second})
+
+	rec := postWebhookTest(e, `{"url":"`+receiver.URL+`","secret":"s3cret","event_type":"agent.result"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var result webhook.TestDeliveryResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if result.Delivered || result.StatusCode != http.StatusServiceUnavailable || !result.WouldRetry {
+		t.Errorf("expected a failed delivery that would be retried, got %+v", result)
+	}
+	if result.Response != "down for maintenance" || result.EventType != webhook.EventTypeResult || !result.Signed {
+		t.Errorf("expected the response snippet of a signed agent.result, got %+v", result)
+	}
+	if signature == "" {
+		t.Error("expected the delivery to be signed")
+	}
+}
+
+func TestWebhookTest_Rejects(t *testing.T) {
+	e := setupWebhookTest(t, &config.Config{WebhookTimeout: time.Second, WebhookBlockPrivateNetworks: true})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode string
+	}{
+		{"no url", `{}`, ""},
+		{"unknown event type", `{"url":"https://hooks.example.com/a","event_type":"agent.done"}`, ""},
+		{"private address", `{"url":"http://127.0.0.1:8080/hook"}`, webhook.ErrorCodeURLRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postWebhookTest(e, tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if tt.wantCode != "" && !strings.Contains(rec.Body.String(), tt.wantCode) {
+				t.Errorf("expected error code %s, got %s", tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
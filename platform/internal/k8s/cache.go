@@ -0,0 +1,562 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Defaults of the pod cache watchdog
+const (
+	// DefaultCacheCheckInterval is how often the pod cache is spot-checked against the API
+	// server
+	DefaultCacheCheckInterval = time.Minute
+	// DefaultCacheStaleAfter is how long the pod cache may disagree with the API server
+	// before it is rebuilt
+	DefaultCacheStaleAfter = 2 * time.Minute
+)
+
+// podCache is a shared informer cache of the agent pods. Reads are served by its lister,
+// and readiness waits subscribe to the changes of their agent's pods instead of opening
+// watches of their own.
+type podCache struct {
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   listersv1.PodLister
+	synced   cache.InformerSynced
+	stop     chan struct{}
+
+	// subs outlive the cache: the cache that replaces it on a rebuild serves them on
+	subs *podSubscribers
+	// lastEvent is when the informer last delivered a change, in Unix nanoseconds
+	lastEvent atomic.Int64
+}
+
+// podSubscribers are the readiness waits and SubscribeAgentPods callers subscribed to the
+// changes of the agent pods
+type podSubscribers struct {
+	mu       sync.Mutex
+	byPod    map[string]map[*podSubscription]struct{} // by PodID.Name() of the agent
+	handlers map[*podHandler]struct{}
+}
+
+func newPodSubscribers() *podSubscribers {
+	return &podSubscribers{
+		byPod:    make(map[string]map[*podSubscription]struct{}),
+		handlers: make(map[*podHandler]struct{}),
+	}
+}
+
+// podHandler is a SubscribeAgentPods subscription, registered with the informer of the
+// current cache, if any
+type podHandler struct {
+	handler      cache.ResourceEventHandler
+	informer     cache.SharedIndexInformer
+	registration cache.ResourceEventHandlerRegistration
+}
+
+// podChange is a change to one of an agent's pods
+type podChange struct {
+	pod     *corev1.Pod
+	deleted bool
+}
+
+// podSubscription receives the latest change to an agent's pods. Only the latest change
+// is kept, which is all a readiness wait needs.
+type podSubscription struct {
+	changes chan podChange
+}
+
+// StartCache starts the shared pod informer and waits for its cache to sync, then watches
+// it for going stale (see watchCache). Until it is started, and once it is stopped, reads
+// go to the API server.
+func (m *Manager) StartCache(ctx context.Context) error {
+	subs := newPodSubscribers()
+	c, err := newPodCache(ctx, m.clientset, m.agentNamespace, subs)
+	if err != nil {
+		return err
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	m.cacheMu.Lock()
+	m.cache = c
+	m.cacheSubs = subs
+	m.cacheStop, m.cacheDone = stop, done
+	m.cacheMu.Unlock()
+
+	watchCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		defer close(done)
+		m.watchCache(watchCtx)
+	}()
+	return nil
+}
+
+// newPodCache starts an informer of the agent pods in namespace and waits for it to sync
+func newPodCache(ctx context.Context, clientset kubernetes.Interface, namespace string, subs *podSubscribers) (*podCache, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = ownerSelector
+		}),
+	)
+	informer := factory.Core().V1().Pods()
+	c := &podCache{
+		factory:  factory,
+		informer: informer.Informer(),
+		lister:   informer.Lister(),
+		synced:   informer.Informer().HasSynced,
+		stop:     make(chan struct{}),
+		subs:     subs,
+	}
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { c.notify(obj, false) },
+		UpdateFunc: func(_, obj any) { c.notify(obj, false) },
+		DeleteFunc: func(obj any) { c.notify(obj, true) },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch agent pods: %w", err)
+	}
+
+	factory.Start(c.stop)
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		c.close()
+		return nil, fmt.Errorf("agent pod cache did not sync: %w", ctx.Err())
+	}
+	return c, nil
+}
+
+// close stops the cache's informer
+func (c *podCache) close() {
+	if c.factory == nil {
+		return
+	}
+	close(c.stop)
+	c.factory.Shutdown()
+}
+
+// StopCache stops the shared pod informer and its watchdog; reads go to the API server again
+func (m *Manager) StopCache() {
+	m.cacheMu.RLock()
+	stop, done := m.cacheStop, m.cacheDone
+	m.cacheMu.RUnlock()
+	if stop != nil {
+		// The watchdog installs no cache once it is done
+		close(stop)
+		<-done
+	}
+
+	m.cacheMu.Lock()
+	c := m.cache
+	m.cache = nil
+	m.cacheSubs = nil
+	m.cacheStop, m.cacheDone = nil, nil
+	m.cacheMu.Unlock()
+	if c != nil {
+		c.close()
+	}
+}
+
+// podCache returns the started pod cache, or nil
+func (m *Manager) podCache() *podCache {
+	m.cacheMu.RLock()
+	defer m.cacheMu.RUnlock()
+	return m.cache
+}
+
+// registerCache starts the pod cache with the fx lifecycle and stops it on shutdown
+func registerCache(lc fx.Lifecycle, m *Manager) {
+	lc.Append(fx.Hook{
+		OnStart: m.StartCache,
+		OnStop: func(context.Context) error {
+			m.StopCache()
+			return nil
+		},
+	})
+}
+
+// cacheWatchdog is what watchCache remembers between its checks
+type cacheWatchdog struct {
+	// sample picks the selector of the next check. It moves on once the cache agrees with
+	// the API server on the current one, so a diverged sample is checked until it is rebuilt.
+	sample int
+	// divergedSince is when the cache started to disagree with the API server, zero while
+	// it agrees
+	divergedSince time.Time
+}
+
+// watchCache checks the pod cache every check interval until ctx is done (see checkCache).
+// Informers may stop receiving events, e.g. after the API server restarts, without failing,
+// leaving every read served by the cache wrong.
+func (m *Manager) watchCache(ctx context.Context) {
+	interval := m.cacheCheckInterval
+	if interval <= 0 {
+		interval = DefaultCacheCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var w cacheWatchdog
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkCache(ctx, &w)
+		}
+	}
+}
+
+// checkCache spot-checks the pod cache against a direct list of a sample of the agent pods.
+// Once the cache has disagreed with the API server for longer than the stale-after
+// threshold, it is rebuilt. A rebuild that failed is tried again.
+func (m *Manager) checkCache(ctx context.Context, w *cacheWatchdog) {
+	c := m.podCache()
+	if c == nil {
+		if err := m.rebuildCache(ctx); err != nil {
+			m.log().Error("failed to rebuild the agent pod cache; reads still go to the API server", zap.Error(err))
+		}
+		return
+	}
+
+	selector := c.sampleSelector(m.agentNamespace, w.sample)
+	agrees, err := c.agreesWithAPI(ctx, m.clientset, m.agentNamespace, selector)
+	if err != nil {
+		m.log().Warn("failed to spot-check the agent pod cache", zap.Error(err), zap.String("selector", selector.String()))
+		return
+	}
+	if agrees {
+		if !w.divergedSince.IsZero() {
+			m.log().Info("agent pod cache agrees with the API server again")
+		}
+		w.sample++
+		w.divergedSince = time.Time{}
+		m.metrics.PodCacheStale(0)
+		return
+	}
+
+	now := time.Now()
+	if w.divergedSince.IsZero() {
+		w.divergedSince = now
+	}
+	stale := now.Sub(w.divergedSince)
+	m.metrics.PodCacheStale(stale)
+	fields := []zap.Field{
+		zap.String("selector", selector.String()),
+		zap.Duration("diverged_for", stale),
+		zap.Time("last_event", c.lastEventTime()),
+		zap.String("last_sync_resource_version", c.informer.LastSyncResourceVersion()),
+	}
+	staleAfter := m.cacheStaleAfter
+	if staleAfter <= 0 {
+		staleAfter = DefaultCacheStaleAfter
+	}
+	if stale < staleAfter {
+		m.log().Warn("agent pod cache disagrees with the API server", fields...)
+		return
+	}
+
+	m.log().Error("agent pod cache is stale, rebuilding it; reads go to the API server meanwhile", fields...)
+	m.metrics.PodCacheRebuilt()
+	w.divergedSince = time.Time{}
+	if err := m.rebuildCache(ctx); err != nil {
+		m.log().Error("failed to rebuild the agent pod cache; reads still go to the API server", zap.Error(err))
+		return
+	}
+	m.metrics.PodCacheStale(0)
+}
+
+// rebuildCache replaces the pod cache with a new one. Reads go to the API server until the
+// new cache has synced, and the subscribers of the old cache carry over to it, starting
+// with an add of each pod.
+func (m *Manager) rebuildCache(ctx context.Context) error {
+	m.cacheMu.Lock()
+	old, subs := m.cache, m.cacheSubs
+	m.cache = nil
+	m.cacheMu.Unlock()
+	if old != nil {
+		old.close()
+	}
+
+	c, err := newPodCache(ctx, m.clientset, m.agentNamespace, subs)
+	if err != nil {
+		return err
+	}
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	m.cacheMu.Lock()
+	m.cache = c
+	m.cacheMu.Unlock()
+	for h := range subs.handlers {
+		if err := h.register(c); err != nil {
+			m.log().Error("failed to resubscribe to the rebuilt agent pod cache", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// sampleSelector returns the selector of the pods the n-th spot-check compares: those of
+// each user the cache has agents of in turn, then all of the agent pods, which catches the
+// pods of users the cache has not seen
+func (c *podCache) sampleSelector(namespace string, n int) labels.Selector {
+	all, _ := labels.Parse(ownerSelector)
+	pods, err := c.lister.Pods(namespace).List(all)
+	if err != nil {
+		return all
+	}
+	users := make(map[string]struct{})
+	for _, pod := range pods {
+		users[pod.Labels["user-id"]] = struct{}{}
+	}
+	sorted := make([]string, 0, len(users))
+	for user := range users {
+		sorted = append(sorted, user)
+	}
+	sort.Strings(sorted)
+	if i := n % (len(sorted) + 1); i < len(sorted) {
+		if selector, err := labels.Parse(ownerSelector + "," + UserIDLabel(sorted[i])); err == nil {
+			return selector
+		}
+	}
+	return all
+}
+
+// agreesWithAPI reports whether the cache holds the same versions of the pods matching
+// selector as a direct list from the API server
+func (c *podCache) agreesWithAPI(ctx context.Context, clientset kubernetes.Interface, namespace string, selector labels.Selector) (bool, error) {
+	cached, err := c.lister.Pods(namespace).List(selector)
+	if err != nil {
+		return false, err
+	}
+	listed, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return false, fmt.Errorf("failed to list agent pods: %w", err)
+	}
+	direct := make([]*corev1.Pod, 0, len(listed.Items))
+	for i := range listed.Items {
+		direct = append(direct, &listed.Items[i])
+	}
+	return resourceVersionsHash(cached) == resourceVersionsHash(direct), nil
+}
+
+// resourceVersionsHash hashes the names and resource versions of pods, in any order
+func resourceVersionsHash(pods []*corev1.Pod) string {
+	versions := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		versions = append(versions, pod.Name+"@"+pod.ResourceVersion)
+	}
+	sort.Strings(versions)
+	h := sha256.New()
+	for _, v := range versions {
+		h.Write([]byte(v + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lastEventTime returns when the informer last delivered a change, zero if it never did
+func (c *podCache) lastEventTime() time.Time {
+	if ns := c.lastEvent.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// SubscribeAgentPods calls fn with every change to the agent pods, starting with an add of
+// each pod already cached, until the returned func is called. Calls are made one at a time
+// and must not block for long; once the cache is rebuilt, they start over with an add of
+// each pod. It fails if the pod cache is not started.
+func (m *Manager) SubscribeAgentPods(fn func(pod *corev1.Pod, deleted bool)) (func(), error) {
+	m.cacheMu.RLock()
+	subs := m.cacheSubs
+	m.cacheMu.RUnlock()
+	if subs == nil {
+		return nil, fmt.Errorf("agent pod cache is not started")
+	}
+	call := func(obj any, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if pod, ok := obj.(*corev1.Pod); ok {
+			fn(pod.DeepCopy(), deleted)
+		}
+	}
+	h := &podHandler{handler: cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj any) { call(obj, false) },
+		UpdateFunc: func(_, obj any) { call(obj, false) },
+		DeleteFunc: func(obj any) { call(obj, true) },
+	}}
+
+	// A rebuild registers the handler with the new cache once it is installed
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	if c := m.podCache(); c != nil {
+		if err := h.register(c); err != nil {
+			return nil, fmt.Errorf("failed to subscribe to agent pods: %w", err)
+		}
+	}
+	subs.handlers[h] = struct{}{}
+	return func() {
+		subs.mu.Lock()
+		defer subs.mu.Unlock()
+		delete(subs.handlers, h)
+		h.unregister()
+	}, nil
+}
+
+// register moves the handler to c's informer
+func (h *podHandler) register(c *podCache) error {
+	h.unregister()
+	registration, err := c.informer.AddEventHandler(h.handler)
+	if err != nil {
+		return err
+	}
+	h.informer, h.registration = c.informer, registration
+	return nil
+}
+
+// unregister removes the handler from its informer, if any
+func (h *podHandler) unregister() {
+	if h.informer != nil {
+		_ = h.informer.RemoveEventHandler(h.registration)
+		h.informer, h.registration = nil, nil
+	}
+}
+
+// cachedPod returns podID's pod from the cache, or an error satisfying apierrors.IsNotFound
+func (c *podCache) cachedPod(namespace string, podID PodID) (*corev1.Pod, error) {
+	pod, err := c.lister.Pods(namespace).Get(podID.Name())
+	if err != nil {
+		return nil, err
+	}
+	return pod.DeepCopy(), nil
+}
+
+// cachedPods returns the cached pods matching selector
+func (c *podCache) cachedPods(namespace string, selector labels.Selector) ([]corev1.Pod, error) {
+	pods, err := c.lister.Pods(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		items = append(items, *pod.DeepCopy())
+	}
+	return items, nil
+}
+
+// subscribe subscribes to the changes of podID's pods; the returned func unsubscribes
+func (c *podCache) subscribe(podID PodID) (*podSubscription, func()) {
+	sub := &podSubscription{changes: make(chan podChange, 1)}
+	key := podID.Name()
+
+	subs := c.subs
+	subs.mu.Lock()
+	if subs.byPod[key] == nil {
+		subs.byPod[key] = make(map[*podSubscription]struct{})
+	}
+	subs.byPod[key][sub] = struct{}{}
+	subs.mu.Unlock()
+
+	return sub, func() {
+		subs.mu.Lock()
+		defer subs.mu.Unlock()
+		delete(subs.byPod[key], sub)
+		if len(subs.byPod[key]) == 0 {
+			delete(subs.byPod, key)
+		}
+	}
+}
+
+// notify passes a change to an agent pod to its agent's subscribers
+func (c *podCache) notify(obj any, deleted bool) {
+	c.lastEvent.Store(time.Now().UnixNano())
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	key := PodID{UserID: pod.Labels["user-id"], AgentID: pod.Labels["agent-id"]}.Name()
+	change := podChange{pod: pod.DeepCopy(), deleted: deleted}
+
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	for sub := range c.subs.byPod[key] {
+		// Replace a change the subscriber has not read yet
+		select {
+		case <-sub.changes:
+		default:
+		}
+		sub.changes <- change
+	}
+}
+
+// waitUntilReady waits on the cache for podID's pod to become ready, fail, or be deleted
+// (outside deployment mode)
+func (m *Manager) waitUntilReady(ctx context.Context, c *podCache, podID PodID) (*corev1.Pod, error) {
+	sub, unsubscribe := c.subscribe(podID)
+	defer unsubscribe()
+
+	// The pod may have changed before the subscription
+	if pod, err := m.GetPod(ctx, podID); err == nil {
+		if ready, err := m.readyOrFailed(pod, false); ready != nil || err != nil {
+			return ready, err
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case change := <-sub.changes:
+			if m.deployments() && change.pod.Name != "" {
+				// Only the Deployment's current pod counts
+				if current, err := m.getDeploymentPod(ctx, podID); err == nil && current.Name != change.pod.Name {
+					continue
+				}
+			}
+			if ready, err := m.readyOrFailed(change.pod, change.deleted); ready != nil || err != nil {
+				return ready, err
+			}
+		}
+	}
+}
+
+// readyOrFailed returns pod if it is ready, or an error if it failed or was deleted (outside
+// deployment mode), and neither if it may still become ready
+func (m *Manager) readyOrFailed(pod *corev1.Pod, deleted bool) (*corev1.Pod, error) {
+	switch {
+	case deleted && !m.deployments():
+		return nil, fmt.Errorf("pod %s was deleted while waiting for it to become ready", pod.Name)
+	case deleted:
+		// A Deployment replaces its deleted pods
+		return nil, nil
+	case IsPodReady(pod):
+		return pod, nil
+	}
+	if failure := podFailure(pod); failure != nil {
+		return nil, m.describeFailure(failure)
+	}
+	return nil, nil
+}
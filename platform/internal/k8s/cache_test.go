@@ -0,0 +1,356 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/fx/fxtest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/forge/platform/internal/metrics"
+)
+
+// startedCacheManager returns a manager whose pod cache runs with a test fx lifecycle
+func startedCacheManager(t *testing.T) (*Manager, *fake.Clientset) {
+	t.Helper()
+	clientset := fake.NewSimpleClientset()
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	lc := fxtest.NewLifecycle(t)
+	registerCache(lc, m)
+	lc.RequireStart()
+	t.Cleanup(lc.RequireStop)
+	return m, clientset
+}
+
+// createCachedPod creates podID's pod and waits for the cache to see it
+func createCachedPod(t *testing.T, m *Manager, podID PodID) *corev1.Pod {
+	t.Helper()
+	if err := m.CreatePod(context.Background(), podID); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if pod, err := m.podCache().cachedPod("test-ns", podID); err == nil {
+			return pod
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the cache to see the pod")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForSubscriber waits for a readiness wait to subscribe to podID's pod changes
+func waitForSubscriber(t *testing.T, m *Manager, podID PodID) {
+	t.Helper()
+	c := m.podCache()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.subs.mu.Lock()
+		n := len(c.subs.byPod[podID.Name()])
+		c.subs.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the readiness wait to subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCache_Lifecycle(t *testing.T) {
+	m := NewManagerWithClientset(fake.NewSimpleClientset(), "test-ns", "test-image:latest", "")
+	lc := fxtest.NewLifecycle(t)
+	registerCache(lc, m)
+
+	if m.podCache() != nil {
+		t.Fatal("expected no cache before start")
+	}
+	lc.RequireStart()
+	if m.podCache() == nil {
+		t.Fatal("expected the cache to be started")
+	}
+	lc.RequireStop()
+	if m.podCache() != nil {
+		t.Error("expected the cache to be stopped")
+	}
+}
+
+func TestGetPod_CacheMissFallsBackToAPI(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	m.cache = &podCache{lister: listersv1.NewPodLister(indexer)}
+
+	cached := PodID{UserID: "user-1", AgentID: "cached"}
+	pod := m.buildPod(cached, "test-image:latest", nil)
+	pod.Namespace = "test-ns"
+	if err := indexer.Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetPod(context.Background(), cached); err != nil {
+		t.Errorf("expected the cached pod, got %v", err)
+	}
+
+	// A pod the cache has not seen yet, as right after it is created
+	created := PodID{UserID: "user-1", AgentID: "created"}
+	if err := m.CreatePod(context.Background(), created); err != nil {
+		t.Fatal(err)
+	}
+	pod, err := m.GetPod(context.Background(), created)
+	if err != nil || pod.Name != created.Name() {
+		t.Errorf("expected the created pod from the API server, got %v, %v", pod, err)
+	}
+}
+
+func TestWaitForPodReady_Cached(t *testing.T) {
+	m, clientset := startedCacheManager(t)
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := createCachedPod(t, m, podID)
+
+	results := startWaiters(context.Background(), m, podID, 1)
+	waitForSubscriber(t, m, podID)
+	if _, err := clientset.CoreV1().Pods("test-ns").UpdateStatus(context.Background(), readyPodFrom(pod, "10.0.0.1"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-results:
+		if r.err != nil || r.pod.Status.PodIP != "10.0.0.1" {
+			t.Errorf("expected the ready pod, got %v, %v", r.pod, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the pod to become ready")
+	}
+	if stats := m.ReadinessWatchStats(); stats.Peak != 0 {
+		t.Errorf("expected no readiness watches, got %+v", stats)
+	}
+}
+
+func TestWaitForPodReady_CachedDeletion(t *testing.T) {
+	m, clientset := startedCacheManager(t)
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	createCachedPod(t, m, podID)
+
+	results := startWaiters(context.Background(), m, podID, 1)
+	waitForSubscriber(t, m, podID)
+	if err := clientset.CoreV1().Pods("test-ns").Delete(context.Background(), podID.Name(), metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-results:
+		if r.err == nil || !strings.Contains(r.err.Error(), "deleted") {
+			t.Errorf("expected the deletion to be reported, got %v", r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the deletion")
+	}
+}
+
+func TestListPodsForUser_Cached(t *testing.T) {
+	m, _ := startedCacheManager(t)
+	createCachedPod(t, m, PodID{UserID: "user-1", AgentID: "agent-1"})
+	createCachedPod(t, m, PodID{UserID: "user-2", AgentID: "agent-1"})
+
+	pods, err := m.ListPodsForUser(context.Background(), "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Labels["user-id"] != "user-1" {
+		t.Errorf("expected user-1's pod, got %+v", pods.Items)
+	}
+}
+
+// versionedPod returns podID's agent pod in test-ns at resourceVersion
+func versionedPod(m *Manager, podID PodID, resourceVersion string) *corev1.Pod {
+	pod := m.buildPod(podID, "test-image:latest", nil)
+	pod.Namespace = "test-ns"
+	pod.ResourceVersion = resourceVersion
+	return pod
+}
+
+// staleCacheManager returns a manager whose pod cache is an informer of stale, a clientset
+// that never sees the changes made through the manager's own clientset
+func staleCacheManager(t *testing.T, clientset kubernetes.Interface, stale *fake.Clientset) *Manager {
+	t.Helper()
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	subs := newPodSubscribers()
+	c, err := newPodCache(context.Background(), stale, "test-ns", subs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.cache, m.cacheSubs = c, subs
+	t.Cleanup(func() {
+		if c := m.podCache(); c != nil {
+			c.close()
+		}
+	})
+	return m
+}
+
+// blockingClientset blocks listing pods while its gate is set, as an API server slow to
+// serve a new informer
+type blockingClientset struct {
+	kubernetes.Interface
+	mu   sync.Mutex
+	gate chan struct{}
+}
+
+func (c *blockingClientset) block() (release func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gate := make(chan struct{})
+	c.gate = gate
+	return func() {
+		c.mu.Lock()
+		c.gate = nil
+		c.mu.Unlock()
+		close(gate)
+	}
+}
+
+func (c *blockingClientset) CoreV1() corev1client.CoreV1Interface {
+	return blockingCoreV1{CoreV1Interface: c.Interface.CoreV1(), c: c}
+}
+
+type blockingCoreV1 struct {
+	corev1client.CoreV1Interface
+	c *blockingClientset
+}
+
+func (v blockingCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return blockingPods{PodInterface: v.CoreV1Interface.Pods(namespace), c: v.c}
+}
+
+type blockingPods struct {
+	corev1client.PodInterface
+	c *blockingClientset
+}
+
+func (p blockingPods) List(ctx context.Context, opts metav1.ListOptions) (*corev1.PodList, error) {
+	p.c.mu.Lock()
+	gate := p.c.gate
+	p.c.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	return p.PodInterface.List(ctx, opts)
+}
+
+// rebuilds is the exposition of n pod cache rebuilds
+func rebuilds(n int) string {
+	return fmt.Sprintf(`
+# HELP forge_agent_pod_cache_rebuilds_total Rebuilds of the agent pod cache after it went stale.
+# TYPE forge_agent_pod_cache_rebuilds_total counter
+forge_agent_pod_cache_rebuilds_total %d
+`, n)
+}
+
+func TestCheckCache_RebuildsStaleCache(t *testing.T) {
+	user1 := PodID{UserID: "user-1", AgentID: "agent-1"}
+	user2 := PodID{UserID: "user-2", AgentID: "agent-1"}
+	m := NewManagerWithClientset(nil, "test-ns", "test-image:latest", "")
+	clientset := fake.NewSimpleClientset(versionedPod(m, user1, "2"), versionedPod(m, user2, "1"))
+	stale := fake.NewSimpleClientset(versionedPod(m, user1, "1"), versionedPod(m, user2, "1"))
+	m = staleCacheManager(t, clientset, stale)
+	reg := prometheus.NewRegistry()
+	m.metrics = metrics.New(reg)
+	m.cacheStaleAfter = time.Millisecond
+
+	changes := make(chan string, 10)
+	unsubscribe, err := m.SubscribeAgentPods(func(pod *corev1.Pod, _ bool) {
+		changes <- pod.Name + "@" + pod.ResourceVersion
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	for range 2 {
+		<-changes
+	}
+
+	var w cacheWatchdog
+	m.checkCache(context.Background(), &w)
+	if w.divergedSince.IsZero() {
+		t.Fatal("expected the stale cache to be found diverged")
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(rebuilds(0)), "forge_agent_pod_cache_rebuilds_total"); err != nil {
+		t.Errorf("expected no rebuild before the threshold: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	m.checkCache(context.Background(), &w)
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(rebuilds(1)), "forge_agent_pod_cache_rebuilds_total"); err != nil {
+		t.Fatalf("expected one rebuild: %v", err)
+	}
+	pod, err := m.podCache().cachedPod("test-ns", user1)
+	if err != nil || pod.ResourceVersion != "2" {
+		t.Fatalf("expected the rebuilt cache to hold the current pod, got %v, %v", pod, err)
+	}
+
+	// The subscription carries over to the rebuilt cache, which starts with an add of each pod
+	seen := make(map[string]bool)
+	for range 2 {
+		select {
+		case change := <-changes:
+			seen[change] = true
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the rebuilt cache to replay its pods")
+		}
+	}
+	if !seen[user1.Name()+"@2"] {
+		t.Errorf("expected the current pod to be replayed, got %v", seen)
+	}
+
+	for range 2 {
+		m.checkCache(context.Background(), &w)
+	}
+	if !w.divergedSince.IsZero() {
+		t.Error("expected the rebuilt cache to agree with the API server")
+	}
+}
+
+func TestRebuildCache_ReadsFallBackToAPI(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	m := NewManagerWithClientset(nil, "test-ns", "test-image:latest", "")
+	clientset := &blockingClientset{Interface: fake.NewSimpleClientset(versionedPod(m, podID, "2"))}
+	m = staleCacheManager(t, clientset, fake.NewSimpleClientset())
+
+	release := clientset.block()
+	rebuilt := make(chan error, 1)
+	go func() { rebuilt <- m.rebuildCache(context.Background()) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for m.podCache() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the rebuild to drop the stale cache")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	pod, err := m.GetPod(context.Background(), podID)
+	if err != nil || pod.ResourceVersion != "2" {
+		t.Errorf("expected the pod from the API server during the rebuild, got %v, %v", pod, err)
+	}
+	release()
+
+	if err := <-rebuilt; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.podCache().cachedPod("test-ns", podID); err != nil {
+		t.Errorf("expected the rebuilt cache to hold the pod, got %v", err)
+	}
+}
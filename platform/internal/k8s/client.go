@@ -2,14 +2,28 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
+	"go.uber.org/zap"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/forge/platform/internal/agent"
+	"github.com/forge/platform/internal/metrics"
 )
 
 const (
@@ -50,30 +64,129 @@ type ManagerOpts struct {
 	// NodeHost is the host/IP to use for NodePort services (e.g., "localhost", "192.168.1.100")
 	// If empty, falls back to using pod IPs (requires in-cluster access)
 	NodeHost string
+	// MaxReadinessWatches caps concurrent WaitForPodReady watches (0 uses the default)
+	MaxReadinessWatches int
+	// PodTemplate is merged into every agent pod
+	PodTemplate PodTemplateConfig
+	// WorkloadKind is WorkloadKindPod (the default if empty) or WorkloadKindDeployment
+	WorkloadKind string
+	// WorkspaceMountPath is where agent workspaces are mounted (empty uses the default)
+	WorkspaceMountPath string
+	// WatchRetryWindow bounds how long WatchPod retries a failing watch (0 uses the default)
+	WatchRetryWindow time.Duration
+	// CacheCheckInterval is how often the pod cache is spot-checked, and CacheStaleAfter how
+	// long it may disagree with the API server before it is rebuilt (0 uses the defaults)
+	CacheCheckInterval time.Duration
+	CacheStaleAfter    time.Duration
+	// AgentAuthSecret, if set, mints the token each agent requires on its RPCs
+	AgentAuthSecret string
+	// QPS and Burst limit the requests of the Kubernetes client (0 uses client-go's
+	// defaults)
+	QPS   float32
+	Burst int
 }
 
 type Manager struct {
 	agentNamespace string
+	// kubeConfigMode is how the client config was loaded (see KubeConfigMode)
+	kubeConfigMode string
 	clientset      kubernetes.Interface
 	agentImage     string
 	nodeHost       string // Host for NodePort access, empty means use pod IPs
+	readiness      readinessWatches
+	rolloutMetrics rolloutMetrics
+	metrics        *metrics.Metrics // nil records nothing
+	logger         *zap.Logger      // nil logs nothing
+	podTemplate    PodTemplateConfig
+	workloadKind   string
+	// workspaceMountPath is where workspaces are mounted, DefaultWorkspaceMountPath if empty
+	workspaceMountPath string
+	imagePullSecret    string
+	imageTags          ImageTagPolicy
+	// watchRetryWindow bounds how long WatchPod retries a failing watch, and watchBackoff
+	// and watchMaxBackoff the delay between retries (zero uses the defaults)
+	watchRetryWindow time.Duration
+	watchBackoff     time.Duration
+	watchMaxBackoff  time.Duration
+
+	// agentAuthSecret mints the RPC token injected into each agent pod; empty injects none
+	agentAuthSecret string
+
+	// executor runs commands in pods (see ExecInPod); nil if exec is not available
+	executor PodExecutor
+
+	// cache serves reads and readiness waits once started (see StartCache). It is nil while
+	// it is rebuilt, and cacheSubs keeps its subscribers meanwhile.
+	cacheMu   sync.RWMutex
+	cache     *podCache
+	cacheSubs *podSubscribers
+	// cacheStop stops the cache's watchdog, which closes cacheDone once it has
+	cacheStop chan struct{}
+	cacheDone chan struct{}
+	// cacheCheckInterval and cacheStaleAfter configure the watchdog (zero uses the defaults)
+	cacheCheckInterval time.Duration
+	cacheStaleAfter    time.Duration
 }
 
+// NewManager creates a Manager. Its client uses the kubeconfig at opts.KubeConfigPath if
+// set, and otherwise the in-cluster config, falling back to the default kubeconfig.
 func NewManager(opts ManagerOpts) (*Manager, error) {
-	cfg, err := clientcmd.BuildConfigFromFlags("", opts.KubeConfigPath)
+	return newManagerWithLoader(opts, defaultKubeConfigLoader)
+}
+
+func newManagerWithLoader(opts ManagerOpts, loader kubeConfigLoader) (*Manager, error) {
+	workloadKind, err := parseWorkloadKind(opts.WorkloadKind)
+	if err != nil {
+		return nil, err
+	}
+	imageTags, err := opts.ContainerCfg.ImageTagPolicy()
 	if err != nil {
-		return nil, fmt.Errorf("failed to build kube config: %w", err)
+		return nil, err
 	}
+	kc, err := loader.load(opts.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := kc.rest
+	cfg.QPS = opts.QPS
+	cfg.Burst = opts.Burst
 	clientset, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get clientset: %w", err)
 	}
 
+	maxWatches := opts.MaxReadinessWatches
+	if maxWatches <= 0 {
+		maxWatches = DefaultMaxReadinessWatches
+	}
+
+	// Agents run next to the platform in the cluster unless told otherwise
+	namespace := opts.AgentNamespace
+	if namespace == "" {
+		namespace = kc.namespace
+	}
+	if namespace == "" {
+		namespace = DefaultAgentNamespace
+	}
+
 	return &Manager{
 		clientset:      clientset,
-		agentNamespace: opts.AgentNamespace,
+		agentNamespace: namespace,
+		kubeConfigMode: kc.mode,
 		agentImage:     opts.ContainerCfg.AgentImage(),
 		nodeHost:       opts.NodeHost,
+		readiness:      readinessWatches{limit: maxWatches},
+		podTemplate:    opts.PodTemplate,
+		workloadKind:   workloadKind,
+
+		workspaceMountPath: opts.WorkspaceMountPath,
+		imagePullSecret:    opts.ContainerCfg.ImagePullSecret,
+		imageTags:          imageTags,
+		watchRetryWindow:   opts.WatchRetryWindow,
+		cacheCheckInterval: opts.CacheCheckInterval,
+		cacheStaleAfter:    opts.CacheStaleAfter,
+		agentAuthSecret:    opts.AgentAuthSecret,
+		executor:           &spdyExecutor{config: cfg, clientset: clientset},
 	}, nil
 }
 
@@ -85,25 +198,183 @@ func NewManagerWithClientset(clientset kubernetes.Interface, namespace, agentIma
 		agentNamespace: namespace,
 		agentImage:     agentImage,
 		nodeHost:       nodeHost,
+		readiness:      readinessWatches{limit: DefaultMaxReadinessWatches},
+		workloadKind:   WorkloadKindPod,
 	}
 }
 
+// NewDeploymentManagerWithClientset creates a Manager running agents as Deployments with a
+// provided clientset. This is primarily useful for testing with fake clientsets.
+func NewDeploymentManagerWithClientset(clientset kubernetes.Interface, namespace, agentImage, nodeHost string) *Manager {
+	m := NewManagerWithClientset(clientset, namespace, agentImage, nodeHost)
+	m.workloadKind = WorkloadKindDeployment
+	return m
+}
+
+// CreatePod creates an agent pod running the configured agent image, or the rollout's
+// image tag if a rollout includes the agent
 func (m *Manager) CreatePod(ctx context.Context, podID PodID) error {
-	podLabels := map[string]string{
-		"user-id":  podID.UserID,
-		"agent-id": podID.AgentID,
+	return m.CreatePodWithImage(ctx, podID, "")
+}
+
+// CreatePodWithImage creates an agent pod running image. If image is empty the pod runs
+// the image CreatePod would choose. The pod is annotated with its image tag and, if a
+// rollout is active, the rollout's ID, and counted in the rollout's metrics. In deployment
+// mode the pod is created by a Deployment, behind a Service.
+func (m *Manager) CreatePodWithImage(ctx context.Context, podID PodID, image string) error {
+	return m.CreatePodWithOptions(ctx, podID, PodOptions{Image: image})
+}
+
+// PodOptions are the options an agent pod is created with
+type PodOptions struct {
+	// Image is the image the pod runs; empty chooses it as CreatePod does
+	Image string
+	// ImageTag, if set and Image is not, runs the agent image with this tag, if the image
+	// tag policy allows it
+	ImageTag string
+	// Workspace, if set, mounts a persistent workspace into the agent container
+	Workspace *Workspace
+	// Repository, if set, is cloned into the agent's workspace before the agent starts
+	Repository *Repository
+	// Model and PermissionMode, if set, are the model and permission mode the agent starts
+	// with instead of its defaults
+	Model          string
+	PermissionMode string
+}
+
+// Env vars the agent reads its starting model and permission mode from
+const (
+	AgentModelEnv     = "AGENT_MODEL"
+	PermissionModeEnv = "PERMISSION_MODE"
+)
+
+// CreatePodWithOptions creates an agent pod as CreatePodWithImage does, with a persistent
+// workspace if opts asks for one. An existing workspace claim of the agent is reused.
+func (m *Manager) CreatePodWithOptions(ctx context.Context, podID PodID, opts PodOptions) error {
+	image := opts.Image
+	if image == "" && opts.ImageTag != "" {
+		var err error
+		if image, err = m.ImageForTag(opts.ImageTag); err != nil {
+			return err
+		}
+	}
+	image, podAnnotations, err := m.chooseImage(ctx, podID, image)
+	if err != nil {
+		return err
 	}
+	newPod := m.buildPod(podID, image, podAnnotations)
+	setAgentEnv(newPod, AgentModelEnv, opts.Model)
+	setAgentEnv(newPod, PermissionModeEnv, opts.PermissionMode)
 
-	newPod := &corev1.Pod{
+	if opts.Repository != nil && opts.Repository.AuthSecretName != "" {
+		if err := m.checkRepositorySecret(ctx, podID, opts.Repository.AuthSecretName); err != nil {
+			m.rolloutMetrics.record(podAnnotations, func(metrics *RolloutMetrics) { metrics.CreateErrors++ })
+			return err
+		}
+	}
+	createdWorkspace := false
+	if opts.Workspace != nil {
+		if createdWorkspace, err = m.ensureWorkspace(ctx, podID, *opts.Workspace); err != nil {
+			m.rolloutMetrics.record(podAnnotations, func(metrics *RolloutMetrics) { metrics.CreateErrors++ })
+			return err
+		}
+		m.mountWorkspace(newPod, podID)
+	}
+	if opts.Repository != nil {
+		m.addRepositoryClone(newPod, *opts.Repository)
+	}
+	// A claim created for a pod that could not be created is deleted with it
+	cleanupWorkspace := func() {
+		if createdWorkspace {
+			_ = m.clientset.CoreV1().PersistentVolumeClaims(m.agentNamespace).Delete(context.Background(), WorkspaceClaimName(podID), metav1.DeleteOptions{})
+		}
+	}
+
+	if m.deployments() {
+		if err := m.createDeployment(ctx, podID, newPod); err != nil {
+			cleanupWorkspace()
+			m.rolloutMetrics.record(podAnnotations, func(metrics *RolloutMetrics) { metrics.CreateErrors++ })
+			return err
+		}
+		m.rolloutMetrics.record(podAnnotations, func(metrics *RolloutMetrics) { metrics.AgentsCreated++ })
+		return nil
+	}
+
+	_, err = m.clientset.CoreV1().Pods(m.agentNamespace).Create(
+		ctx,
+		newPod,
+		metav1.CreateOptions{},
+	)
+	if err != nil {
+		cleanupWorkspace()
+		m.rolloutMetrics.record(podAnnotations, func(metrics *RolloutMetrics) { metrics.CreateErrors++ })
+		return fmt.Errorf("failed to create pod: %w", err)
+	}
+
+	// Create NodePort service if nodeHost is configured (for local dev access)
+	if m.nodeHost != "" {
+		svc, err := m.createService(ctx, podID, corev1.ServiceTypeNodePort)
+		if err != nil {
+			// Clean up pod if service creation fails
+			_ = m.closePod(context.Background(), podID)
+			cleanupWorkspace()
+			m.rolloutMetrics.record(podAnnotations, func(metrics *RolloutMetrics) { metrics.CreateErrors++ })
+			return fmt.Errorf("failed to create service: %w", err)
+		}
+		// The annotation only reports the port; addresses are always read from the service
+		if port := serviceNodePort(svc); port != 0 {
+			if err := m.AnnotatePod(ctx, podID, map[string]string{NodePortAnnotation: strconv.Itoa(int(port))}); err != nil {
+				m.log().Warn("failed to annotate pod with its node port",
+					zap.Error(err),
+					zap.String("pod", podID.Name()),
+					zap.Int32("node_port", port),
+				)
+			}
+		}
+	}
+
+	m.rolloutMetrics.record(podAnnotations, func(metrics *RolloutMetrics) { metrics.AgentsCreated++ })
+	return nil
+}
+
+// log returns the manager's logger, or a no-op logger if it has none
+func (m *Manager) log() *zap.Logger {
+	if m.logger == nil {
+		return zap.NewNop()
+	}
+	return m.logger
+}
+
+// ImageForTag returns the agent image with tag, if the image tag policy allows callers to
+// ask for it. Errors wrap ErrImageTagNotAllowed.
+func (m *Manager) ImageForTag(tag string) (string, error) {
+	if err := m.imageTags.Check(tag); err != nil {
+		return "", err
+	}
+	return imageWithTag(m.agentImage, tag), nil
+}
+
+// podSelector returns the platform's labels of podID's pod, which select it
+func podSelector(podID PodID) map[string]string {
+	selector := ownerLabels()
+	selector["user-id"] = podID.UserID
+	selector["agent-id"] = podID.AgentID
+	return selector
+}
+
+// buildPod builds podID's pod running image, with the pod template merged in
+func (m *Manager) buildPod(podID PodID, image string, annotations map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   podID.Name(),
-			Labels: podLabels,
+			Name:        podID.Name(),
+			Labels:      podSelector(podID),
+			Annotations: annotations,
 		},
 		Spec: corev1.PodSpec{
 			Containers: []corev1.Container{
 				{
-					Name:  "forge-agent",
-					Image: m.agentImage,
+					Name:  AgentContainerName,
+					Image: image,
 					Ports: []corev1.ContainerPort{
 						{ContainerPort: DefaultAgentPort},
 					},
@@ -149,37 +420,56 @@ func (m *Manager) CreatePod(ctx context.Context, podID PodID) error {
 			RestartPolicy: corev1.RestartPolicyNever,
 		},
 	}
-	_, err := m.clientset.CoreV1().Pods(m.agentNamespace).Create(
-		ctx,
-		newPod,
-		metav1.CreateOptions{},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create pod: %w", err)
+	if m.imagePullSecret != "" {
+		pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: m.imagePullSecret}}
+	}
+	m.podTemplate.apply(pod)
+	if m.agentAuthSecret != "" {
+		setAgentEnv(pod, agent.AuthTokenEnv, agent.Token(m.agentAuthSecret, podID.UserID, podID.AgentID))
 	}
+	return pod
+}
 
-	// Create NodePort service if nodeHost is configured (for local dev access)
-	if m.nodeHost != "" {
-		if err := m.createServiceForPod(ctx, podID, podLabels); err != nil {
-			// Clean up pod if service creation fails
-			_ = m.ClosePod(context.Background(), podID)
-			return fmt.Errorf("failed to create service: %w", err)
+// setAgentEnv sets env var name of pod's agent container to value, unless value is empty
+func setAgentEnv(pod *corev1.Pod, name, value string) {
+	if value == "" {
+		return
+	}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if c.Name != AgentContainerName {
+			continue
 		}
+		c.Env = slices.DeleteFunc(c.Env, func(env corev1.EnvVar) bool { return env.Name == name })
+		c.Env = append(c.Env, corev1.EnvVar{Name: name, Value: value})
 	}
+}
 
-	return nil
+// agentEnv returns the value of env var name of pod's agent container, or ""
+func agentEnv(pod *corev1.Pod, name string) string {
+	for _, c := range pod.Spec.Containers {
+		if c.Name != AgentContainerName {
+			continue
+		}
+		for _, env := range c.Env {
+			if env.Name == name {
+				return env.Value
+			}
+		}
+	}
+	return ""
 }
 
-// createServiceForPod creates a NodePort service to expose the agent pod
-func (m *Manager) createServiceForPod(ctx context.Context, podID PodID, podLabels map[string]string) error {
+// createService creates a service of serviceType exposing the agent pod
+func (m *Manager) createService(ctx context.Context, podID PodID, serviceType corev1.ServiceType) (*corev1.Service, error) {
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:   podID.Name(),
-			Labels: podLabels,
+			Labels: podSelector(podID),
 		},
 		Spec: corev1.ServiceSpec{
-			Type:     corev1.ServiceTypeNodePort,
-			Selector: podLabels,
+			Type:     serviceType,
+			Selector: podSelector(podID),
 			Ports: []corev1.ServicePort{
 				{
 					Name:       "grpc",
@@ -191,15 +481,36 @@ func (m *Manager) createServiceForPod(ctx context.Context, podID PodID, podLabel
 		},
 	}
 
-	_, err := m.clientset.CoreV1().Services(m.agentNamespace).Create(ctx, svc, metav1.CreateOptions{})
+	created, err := m.clientset.CoreV1().Services(m.agentNamespace).Create(ctx, svc, metav1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create service %s: %w", podID.Name(), err)
+		return nil, fmt.Errorf("failed to create service %s: %w", podID.Name(), err)
 	}
 
-	return nil
+	return created, nil
+}
+
+// serviceNodePort returns the node port allocated to the agent port of the service, or 0
+func serviceNodePort(svc *corev1.Service) int32 {
+	for _, port := range svc.Spec.Ports {
+		if port.Name == "grpc" || port.Port == DefaultAgentPort {
+			return port.NodePort
+		}
+	}
+	return 0
 }
 
+// GetPod returns the agent's pod. In deployment mode this is the Deployment's current pod
+// (see getDeploymentPod). Pods are read from the cache once started, and from the API
+// server if the cache does not have them yet, e.g. right after they were created.
 func (m *Manager) GetPod(ctx context.Context, podID PodID) (*corev1.Pod, error) {
+	if m.deployments() {
+		return m.getDeploymentPod(ctx, podID)
+	}
+	if c := m.podCache(); c != nil {
+		if pod, err := c.cachedPod(m.agentNamespace, podID); err == nil {
+			return pod, nil
+		}
+	}
 	pod, err := m.clientset.CoreV1().Pods(m.agentNamespace).Get(ctx, podID.Name(), metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pod %s: %w", podID.Name(), err)
@@ -207,14 +518,75 @@ func (m *Manager) GetPod(ctx context.Context, podID PodID) (*corev1.Pod, error)
 	return pod, nil
 }
 
+// podName returns the name of the agent's pod, which in deployment mode is looked up
+func (m *Manager) podName(ctx context.Context, podID PodID) (string, error) {
+	if !m.deployments() {
+		return podID.Name(), nil
+	}
+	pod, err := m.getDeploymentPod(ctx, podID)
+	if err != nil {
+		return "", err
+	}
+	return pod.Name, nil
+}
+
+// AnnotatePod merges the given annotations into the pod's metadata. In deployment mode
+// they are lost if the pod is replaced.
+func (m *Manager) AnnotatePod(ctx context.Context, podID PodID, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": annotations},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build annotation patch: %w", err)
+	}
+	name, err := m.podName(ctx, podID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.clientset.CoreV1().Pods(m.agentNamespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate pod %s: %w", podID.Name(), err)
+	}
+	return nil
+}
+
+// RemovePodAnnotation removes an annotation from the pod; removing a missing one is not an error
+func (m *Manager) RemovePodAnnotation(ctx context.Context, podID PodID, key string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": map[string]any{key: nil}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build annotation patch: %w", err)
+	}
+	name, err := m.podName(ctx, podID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.clientset.CoreV1().Pods(m.agentNamespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove annotation from pod %s: %w", podID.Name(), err)
+	}
+	return nil
+}
+
 // GetPodAddress returns the ConnectRPC base URL for the given pod.
 // If nodeHost is configured, returns the NodePort service address.
-// Otherwise, returns the pod IP (requires in-cluster access).
+// Otherwise, returns the pod IP (requires in-cluster access), or in deployment mode the
+// service DNS name, which survives the pod being replaced. The pod need not be ready, so
+// callers that open streams to the agent use GetReadyPodAddress instead.
 func (m *Manager) GetPodAddress(ctx context.Context, podID PodID) (string, error) {
 	// If nodeHost is configured, use the NodePort service
 	if m.nodeHost != "" {
 		return m.getNodePortAddress(ctx, podID)
 	}
+	if m.deployments() {
+		if _, err := m.clientset.CoreV1().Services(m.agentNamespace).Get(ctx, podID.Name(), metav1.GetOptions{}); err != nil {
+			return "", fmt.Errorf("failed to get service %s: %w", podID.Name(), err)
+		}
+		return m.serviceAddress(podID), nil
+	}
 
 	// Fall back to pod IP for in-cluster access
 	pod, err := m.GetPod(ctx, podID)
@@ -222,8 +594,57 @@ func (m *Manager) GetPodAddress(ctx context.Context, podID PodID) (string, error
 		return "", err
 	}
 
+	return podIPAddress(pod)
+}
+
+// GetPodEndpoint returns the pod along with its ConnectRPC base URL (see GetPodAddress),
+// so callers can tell later whether the address still reaches the same pod instance.
+func (m *Manager) GetPodEndpoint(ctx context.Context, podID PodID) (*corev1.Pod, string, error) {
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil {
+		return nil, "", err
+	}
+	address, err := m.podEndpoint(ctx, podID, pod)
+	return pod, address, err
+}
+
+// GetReadyPodAddress returns the ConnectRPC base URL for the given pod like GetPodAddress,
+// or an *AgentNotReadyError if the pod is not ready or is terminating. A pod in either
+// state may still have an address, but streams opened to it fail later.
+func (m *Manager) GetReadyPodAddress(ctx context.Context, podID PodID) (string, error) {
+	_, address, err := m.GetReadyPodEndpoint(ctx, podID)
+	return address, err
+}
+
+// GetReadyPodEndpoint returns the pod and its ConnectRPC base URL like GetPodEndpoint, or an
+// *AgentNotReadyError if the pod is not ready or is terminating
+func (m *Manager) GetReadyPodEndpoint(ctx context.Context, podID PodID) (*corev1.Pod, string, error) {
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := CheckPodReady(pod); err != nil {
+		return nil, "", err
+	}
+	address, err := m.podEndpoint(ctx, podID, pod)
+	return pod, address, err
+}
+
+// podEndpoint returns the ConnectRPC base URL of the agent the pod runs
+func (m *Manager) podEndpoint(ctx context.Context, podID PodID, pod *corev1.Pod) (string, error) {
+	if m.nodeHost != "" {
+		return m.getNodePortAddress(ctx, podID)
+	}
+	if m.deployments() {
+		return m.serviceAddress(podID), nil
+	}
+	return podIPAddress(pod)
+}
+
+// podIPAddress returns the in-cluster address of the pod's agent
+func podIPAddress(pod *corev1.Pod) (string, error) {
 	if pod.Status.PodIP == "" {
-		return "", fmt.Errorf("pod %s has no IP assigned (phase: %s)", podID.Name(), pod.Status.Phase)
+		return "", fmt.Errorf("pod %s has no IP assigned (phase: %s)", pod.Name, pod.Status.Phase)
 	}
 
 	return fmt.Sprintf("http://%s:%d", pod.Status.PodIP, DefaultAgentPort), nil
@@ -236,15 +657,7 @@ func (m *Manager) getNodePortAddress(ctx context.Context, podID PodID) (string,
 		return "", fmt.Errorf("failed to get service %s: %w", podID.Name(), err)
 	}
 
-	// Find the NodePort
-	var nodePort int32
-	for _, port := range svc.Spec.Ports {
-		if port.Name == "grpc" || port.Port == DefaultAgentPort {
-			nodePort = port.NodePort
-			break
-		}
-	}
-
+	nodePort := serviceNodePort(svc)
 	if nodePort == 0 {
 		return "", fmt.Errorf("service %s has no NodePort assigned", podID.Name())
 	}
@@ -253,61 +666,135 @@ func (m *Manager) getNodePortAddress(ctx context.Context, podID PodID) (string,
 }
 
 // WaitForPodReady blocks until the pod is in Ready condition or the context is cancelled.
-// Returns the pod with its assigned IP address once ready.
-func (m *Manager) WaitForPodReady(ctx context.Context, podID PodID) (*corev1.Pod, error) {
-	// Initial check - pod might already be ready
+// Returns the pod with its assigned IP address once ready. A pod that fails or gets stuck
+// (e.g. in ImagePullBackOff), or is still not ready when ctx's deadline passes, is
+// reported by a *PodFailureError.
+//
+// Concurrent callers waiting on the same pod share a single watch, and the total
+// number of open readiness watches is capped (see ReadinessWatchStats).
+func (m *Manager) WaitForPodReady(ctx context.Context, podID PodID) (_ *corev1.Pod, err error) {
+	start := time.Now()
+	defer func() { m.metrics.PodReadyWaited(time.Since(start), err) }()
+
+	// Initial check - pod might already be ready. A new Deployment has no pod yet.
 	pod, err := m.GetPod(ctx, podID)
-	if err != nil {
+	if err != nil && !(m.deployments() && apierrors.IsNotFound(err)) {
 		return nil, err
 	}
-	if isPodReady(pod) {
+	if pod != nil && IsPodReady(pod) {
 		return pod, nil
 	}
-
-	// Create a cancellable context for the watcher so it cleans up when we return
-	watchCtx, cancelWatch := context.WithCancel(ctx)
-	defer cancelWatch()
-
-	// Start watching for changes
-	events, err := m.WatchPod(watchCtx, podID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to watch pod %s: %w", podID.Name(), err)
+	if pod != nil {
+		if failure := podFailure(pod); failure != nil {
+			return nil, m.describeFailure(failure)
+		}
 	}
 
-	for event := range events {
-		if event.Err != nil {
-			// Check if it's a context cancellation from the parent
-			if ctx.Err() != nil {
-				return nil, ctx.Err()
-			}
-			return nil, fmt.Errorf("watch error while waiting for pod %s: %w", podID.Name(), event.Err)
-		}
+	w := m.joinReadinessWatch(podID)
+	defer m.leaveReadinessWatch(podID, w)
 
-		switch event.Type {
-		case watch.Added, watch.Modified:
-			if isPodReady(event.Pod) {
-				return event.Pod, nil
+	select {
+	case <-ctx.Done():
+		// The cause may say which deadline passed, e.g. AGENT_CREATE_TIMEOUT
+		if stderrors.Is(ctx.Err(), context.DeadlineExceeded) {
+			if failure := m.unreadyFailure(podID, context.Cause(ctx)); failure != nil {
+				return nil, failure
 			}
-		case watch.Deleted:
-			return nil, fmt.Errorf("pod %s was deleted while waiting for it to become ready", podID.Name())
+			return nil, context.Cause(ctx)
+		}
+		return nil, ctx.Err()
+	case <-w.done:
+		if w.err != nil {
+			return nil, w.err
 		}
+		return w.pod.DeepCopy(), nil
 	}
+}
 
-	// Channel closed without pod becoming ready
-	return nil, fmt.Errorf("watch ended unexpectedly for pod %s", podID.Name())
+// Ping checks that the Kubernetes API is reachable and that the platform may manage pods in
+// the agent namespace, by reviewing its own access to list them
+func (m *Manager) Ping(ctx context.Context) error {
+	review, err := m.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: m.agentNamespace,
+				Verb:      "list",
+				Resource:  "pods",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to review access to the Kubernetes API: %w", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("not allowed to list pods in namespace %s: %s", m.agentNamespace, review.Status.Reason)
+	}
+	return nil
 }
 
+// ListPodsForUser lists the pods of userID's agents, from the cache once started. In
+// deployment mode only each agent's current pod is listed.
 func (m *Manager) ListPodsForUser(ctx context.Context, userID string) (*corev1.PodList, error) {
-	pods, err := m.clientset.CoreV1().Pods(m.agentNamespace).List(ctx, metav1.ListOptions{
-		LabelSelector: UserIDLabel(userID),
-	})
-	if err != nil {
-		return nil, fmt.Errorf("unable to list pods for %s: %w", userID, err)
+	return m.ListPodsForUserInPhase(ctx, userID, "")
+}
+
+// ListPodsForUserInPhase lists like ListPodsForUser the pods of userID's agents that are in
+// phase, or in any phase if it is empty
+func (m *Manager) ListPodsForUserInPhase(ctx context.Context, userID string, phase corev1.PodPhase) (*corev1.PodList, error) {
+	var pods *corev1.PodList
+	if c := m.podCache(); c != nil {
+		selector, err := labels.Parse(UserIDLabel(userID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid user id %s: %w", userID, err)
+		}
+		items, err := c.cachedPods(m.agentNamespace, selector)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list pods for %s: %w", userID, err)
+		}
+		pods = &corev1.PodList{Items: items}
+	} else {
+		opts := metav1.ListOptions{LabelSelector: UserIDLabel(userID)}
+		// In deployment mode the current pod is picked among all of the agent's pods
+		if phase != "" && !m.deployments() {
+			opts.FieldSelector = fields.OneTermEqualSelector("status.phase", string(phase)).String()
+		}
+		var err error
+		pods, err = m.clientset.CoreV1().Pods(m.agentNamespace).List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list pods for %s: %w", userID, err)
+		}
+	}
+	if m.deployments() {
+		pods.Items = currentPods(pods.Items)
+	}
+	if phase != "" {
+		// The cache, and fake clientsets, ignore field selectors
+		items := pods.Items[:0]
+		for _, pod := range pods.Items {
+			if pod.Status.Phase == phase {
+				items = append(items, pod)
+			}
+		}
+		pods.Items = items
 	}
 	return pods, nil
 }
 
+// ClosePod deletes the agent's pod, or in deployment mode its Deployment, its service and
+// its workspace claim unless that is retained
 func (m *Manager) ClosePod(ctx context.Context, podID PodID) error {
+	if m.deployments() {
+		if err := m.deleteDeployment(ctx, podID); err != nil {
+			return err
+		}
+	} else if err := m.closePod(ctx, podID); err != nil {
+		return err
+	}
+	return m.deleteWorkspace(ctx, podID)
+}
+
+// closePod deletes the agent's pod and service, leaving its workspace claim
+func (m *Manager) closePod(ctx context.Context, podID PodID) error {
 	podName := podID.Name()
 
 	// Delete the service first if nodeHost is configured
@@ -329,6 +816,12 @@ func (m *Manager) ClosePod(ctx context.Context, podID PodID) error {
 }
 
 func (m *Manager) ClosePodsForUser(ctx context.Context, userID string) error {
+	if m.deployments() {
+		if err := m.deleteDeploymentsForUser(ctx, userID); err != nil {
+			return err
+		}
+		return m.deleteWorkspacesForUser(ctx, userID)
+	}
 	err := m.clientset.CoreV1().Pods(m.agentNamespace).DeleteCollection(
 		ctx,
 		metav1.DeleteOptions{},
@@ -340,10 +833,37 @@ func (m *Manager) ClosePodsForUser(ctx context.Context, userID string) error {
 		return fmt.Errorf("failed to delete pods for user %s: %w", userID, err)
 	}
 
-	return nil
+	return m.deleteWorkspacesForUser(ctx, userID)
 }
 
+// RestartPod replaces the agent's pod: it is deleted and created again, or in deployment
+// mode deleted for its Deployment to replace it. The new pod mounts the same workspace.
 func (m *Manager) RestartPod(ctx context.Context, podID PodID) error {
+	if m.deployments() {
+		pod, err := m.getDeploymentPod(ctx, podID)
+		if err != nil {
+			return fmt.Errorf("error finding pod to restart: %w", err)
+		}
+		if err := m.clientset.CoreV1().Pods(m.agentNamespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("error deleting pod during restart: %w", err)
+		}
+		return nil
+	}
+
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil {
+		return fmt.Errorf("error finding pod to restart: %w", err)
+	}
+	// The agent restarts with the settings it was created with
+	opts := PodOptions{
+		Model:          agentEnv(pod, AgentModelEnv),
+		PermissionMode: agentEnv(pod, PermissionModeEnv),
+	}
+	if hasWorkspace(pod) {
+		// ensureWorkspace finds the existing claim, so it needs no size
+		opts.Workspace = &Workspace{}
+	}
+
 	watchCtx, cancelWatch := context.WithCancel(ctx)
 	defer cancelWatch()
 
@@ -352,28 +872,71 @@ func (m *Manager) RestartPod(ctx context.Context, podID PodID) error {
 		return fmt.Errorf("error initializing watch for pod: %w", err)
 	}
 
-	if err := m.ClosePod(ctx, podID); err != nil {
+	if err := m.closePod(ctx, podID); err != nil {
 		return fmt.Errorf("error closing pod during restart: %w", err)
 	}
 
+	deleted := false
 	for event := range events {
 		if event.Err != nil {
 			return fmt.Errorf("watch error during restart: %w", event.Err)
 		}
 		if event.Type == watch.Deleted {
+			deleted = true
 			break
 		}
 	}
+	if !deleted {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("restart cancelled while waiting for pod deletion: %w", err)
+		}
+		return fmt.Errorf("watch ended before pod %s was deleted", podID.Name())
+	}
 
-	if err := m.CreatePod(ctx, podID); err != nil {
+	if err := m.CreatePodWithOptions(ctx, podID, opts); err != nil {
 		return fmt.Errorf("error creating pod during restart: %w", err)
 	}
 
 	return nil
 }
 
-// isPodReady returns true if the pod is running, has an IP, and all containers are ready
-func isPodReady(pod *corev1.Pod) bool {
+// ErrAgentNotReady is matched by errors.Is for every *AgentNotReadyError
+var ErrAgentNotReady = stderrors.New("agent is not ready")
+
+// AgentNotReadyError is returned for an agent whose pod cannot serve it yet or any more: it
+// is starting, terminating, or has stopped
+type AgentNotReadyError struct {
+	Pod   string
+	Phase corev1.PodPhase
+	// Terminating is true if the pod is being deleted
+	Terminating bool
+}
+
+func (e *AgentNotReadyError) Error() string {
+	switch {
+	case e.Terminating:
+		return "agent is terminating"
+	case e.Phase == corev1.PodPending || e.Phase == corev1.PodRunning:
+		return fmt.Sprintf("agent is starting: pod is %s", e.Phase)
+	default:
+		return fmt.Sprintf("%s: pod is %s", ErrAgentNotReady, e.Phase)
+	}
+}
+
+func (e *AgentNotReadyError) Is(target error) bool {
+	return target == ErrAgentNotReady
+}
+
+// CheckPodReady returns an *AgentNotReadyError unless the pod is ready and not being deleted
+func CheckPodReady(pod *corev1.Pod) error {
+	if pod.DeletionTimestamp == nil && IsPodReady(pod) {
+		return nil
+	}
+	return &AgentNotReadyError{Pod: pod.Name, Phase: pod.Status.Phase, Terminating: pod.DeletionTimestamp != nil}
+}
+
+// IsPodReady returns true if the pod is running, has an IP, and all containers are ready
+func IsPodReady(pod *corev1.Pod) bool {
 	if pod.Status.Phase != corev1.PodRunning {
 		return false
 	}
@@ -396,58 +959,43 @@ type PodEvent struct {
 }
 
 // WatchPod returns a channel that emits pod events.
-// Returns an error if the pod doesn't exist.
-// The channel is closed when the context is cancelled or the watch ends.
-// Caller is responsible for consuming events from the channel.
+// Returns an error if the pod doesn't exist. In deployment mode the events are those of
+// every pod of the agent's Deployment, whose pods are named after it but not PodID.Name().
+// The watch survives its server-side timeout and retriable errors: it re-lists the pods,
+// sending the changes it missed, and watches again from there, backing off while it keeps
+// failing. It ends once the pod (or Deployment) is gone, the context is cancelled, or the
+// watch cannot be re-established within the retry window. Closing the channel is the only
+// signal that it ended; failures are sent as an error event first.
+// Consumers must drain the channel until it is closed, or cancel the context to stop
+// reading early; no event is sent once the context is done.
 func (m *Manager) WatchPod(ctx context.Context, podID PodID) (<-chan PodEvent, error) {
+	var (
+		listOpts metav1.ListOptions
+		existing *corev1.Pod
+	)
 	// Check if pod exists first
-	_, err := m.GetPod(ctx, podID)
-	if err != nil {
-		return nil, fmt.Errorf("pod %s not found: %w", podID.Name(), err)
-	}
-
-	eventCh := make(chan PodEvent)
-
-	go func() {
-		defer close(eventCh)
-
-		podName := podID.Name()
-
-		// Set up watch for the specific pod
-		watcher, err := m.clientset.CoreV1().Pods(m.agentNamespace).Watch(ctx, metav1.ListOptions{
-			FieldSelector:  fmt.Sprintf("metadata.name=%s", podName),
-			TimeoutSeconds: func() *int64 { t := int64(300); return &t }(), // 5 minute timeout
-		})
+	if m.deployments() {
+		_, err := m.clientset.AppsV1().Deployments(m.agentNamespace).Get(ctx, podID.Name(), metav1.GetOptions{})
 		if err != nil {
-			eventCh <- PodEvent{Err: fmt.Errorf("failed to create watcher for pod %s: %w", podName, err)}
-			return
+			return nil, fmt.Errorf("deployment %s not found: %w", podID.Name(), err)
 		}
-		defer watcher.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				eventCh <- PodEvent{Err: ctx.Err()}
-				return
-			case event, ok := <-watcher.ResultChan():
-				if !ok {
-					eventCh <- PodEvent{Err: fmt.Errorf("watch channel closed for pod %s", podName)}
-					return
-				}
-
-				switch event.Type {
-				case watch.Added, watch.Modified, watch.Deleted:
-					pod, ok := event.Object.(*corev1.Pod)
-					if ok {
-						eventCh <- PodEvent{Type: event.Type, Pod: pod}
-					}
-				case watch.Error:
-					eventCh <- PodEvent{Err: fmt.Errorf("watch error for pod %s", podName)}
-					return
-				}
-			}
+		listOpts.LabelSelector = labels.SelectorFromSet(podSelector(podID)).String()
+	} else {
+		pod, err := m.GetPod(ctx, podID)
+		if err != nil {
+			return nil, fmt.Errorf("pod %s not found: %w", podID.Name(), err)
 		}
-	}()
+		listOpts.FieldSelector = fmt.Sprintf("metadata.name=%s", podID.Name())
+		listOpts.LabelSelector = ownerSelector
+		existing = pod
+	}
 
+	eventCh := make(chan PodEvent)
+	w := m.newPodWatch(podID, listOpts, eventCh)
+	if existing != nil {
+		// Its deletion is reported even if it happens before the first list
+		w.seen[existing.Name] = existing
+	}
+	go w.run(ctx)
 	return eventCh, nil
 }
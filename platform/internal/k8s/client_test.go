@@ -2,10 +2,16 @@ package k8s
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
@@ -24,7 +30,7 @@ func TestIsPodReady_AllConditionsMet(t *testing.T) {
 		},
 	}
 
-	if !isPodReady(pod) {
+	if !IsPodReady(pod) {
 		t.Error("expected pod to be ready when all conditions are met")
 	}
 }
@@ -40,7 +46,7 @@ func TestIsPodReady_NotRunning(t *testing.T) {
 		},
 	}
 
-	if isPodReady(pod) {
+	if IsPodReady(pod) {
 		t.Error("expected pod to not be ready when phase is not Running")
 	}
 }
@@ -56,7 +62,7 @@ func TestIsPodReady_NoIP(t *testing.T) {
 		},
 	}
 
-	if isPodReady(pod) {
+	if IsPodReady(pod) {
 		t.Error("expected pod to not be ready when no IP is assigned")
 	}
 }
@@ -72,7 +78,7 @@ func TestIsPodReady_ContainerNotReady(t *testing.T) {
 		},
 	}
 
-	if isPodReady(pod) {
+	if IsPodReady(pod) {
 		t.Error("expected pod to not be ready when container is not ready")
 	}
 }
@@ -90,11 +96,55 @@ func TestIsPodReady_MultipleContainers_OneNotReady(t *testing.T) {
 		},
 	}
 
-	if isPodReady(pod) {
+	if IsPodReady(pod) {
 		t.Error("expected pod to not be ready when one container is not ready")
 	}
 }
 
+func TestCheckPodReady(t *testing.T) {
+	ready := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent"},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			PodIP:             "10.0.0.1",
+			ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+		},
+	}
+	if err := CheckPodReady(ready); err != nil {
+		t.Fatalf("expected ready pod to pass, got %v", err)
+	}
+
+	terminating := ready.DeepCopy()
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	notReady := ready.DeepCopy()
+	notReady.Status.ContainerStatuses[0].Ready = false
+	failed := ready.DeepCopy()
+	failed.Status.Phase = corev1.PodFailed
+
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want string
+	}{
+		{"terminating", terminating, "agent is terminating"},
+		{"pending", &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}, "agent is starting: pod is Pending"},
+		{"containers not ready", notReady, "agent is starting: pod is Running"},
+		{"failed", failed, "agent is not ready: pod is Failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPodReady(tt.pod)
+			var notReadyErr *AgentNotReadyError
+			if !errors.As(err, &notReadyErr) || !errors.Is(err, ErrAgentNotReady) {
+				t.Fatalf("expected an *AgentNotReadyError, got %v", err)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, err.Error())
+			}
+		})
+	}
+}
+
 func TestIsPodReady_MultipleContainers_AllReady(t *testing.T) {
 	pod := &corev1.Pod{
 		Status: corev1.PodStatus{
@@ -107,7 +157,7 @@ func TestIsPodReady_MultipleContainers_AllReady(t *testing.T) {
 		},
 	}
 
-	if !isPodReady(pod) {
+	if !IsPodReady(pod) {
 		t.Error("expected pod to be ready when all containers are ready")
 	}
 }
@@ -122,7 +172,7 @@ func TestIsPodReady_NoContainers(t *testing.T) {
 	}
 
 	// Pod with no containers but running and has IP should be considered ready
-	if !isPodReady(pod) {
+	if !IsPodReady(pod) {
 		t.Error("expected pod to be ready when running with IP and no containers")
 	}
 }
@@ -145,6 +195,7 @@ func TestWaitForPodReady_AlreadyReady(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podID.Name(),
 			Namespace: namespace,
+			Labels:    podSelector(podID),
 		},
 		Status: corev1.PodStatus{
 			Phase: corev1.PodRunning,
@@ -177,6 +228,7 @@ func TestWaitForPodReady_BecomesReady(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podID.Name(),
 			Namespace: namespace,
+			Labels:    podSelector(podID),
 		},
 		Status: corev1.PodStatus{
 			Phase: corev1.PodPending,
@@ -219,6 +271,7 @@ func TestWaitForPodReady_BecomesReady(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podID.Name(),
 			Namespace: namespace,
+			Labels:    podSelector(podID),
 		},
 		Status: corev1.PodStatus{
 			Phase: corev1.PodRunning,
@@ -251,6 +304,7 @@ func TestWaitForPodReady_PodDeleted(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podID.Name(),
 			Namespace: namespace,
+			Labels:    podSelector(podID),
 		},
 		Status: corev1.PodStatus{
 			Phase: corev1.PodPending,
@@ -312,6 +366,7 @@ func TestWaitForPodReady_ContextCancelled(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podID.Name(),
 			Namespace: namespace,
+			Labels:    podSelector(podID),
 		},
 		Status: corev1.PodStatus{
 			Phase: corev1.PodPending,
@@ -379,3 +434,138 @@ func TestWaitForPodReady_PodNotFound(t *testing.T) {
 		t.Fatal("expected error when pod doesn't exist")
 	}
 }
+
+func TestPing_ReviewsPodAccess(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	allowed := false
+	var reviewed *authorizationv1.ResourceAttributes
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		reviewed = review.Spec.ResourceAttributes
+		review.Status.Allowed = allowed
+		return true, review, nil
+	})
+	m := NewManagerWithClientset(clientset, "agents", "agent:latest", "")
+
+	if err := m.Ping(context.Background()); err == nil {
+		t.Error("expected an error when listing pods is not allowed")
+	}
+	if reviewed == nil || reviewed.Namespace != "agents" || reviewed.Verb != "list" || reviewed.Resource != "pods" {
+		t.Errorf("expected access to list pods in agents reviewed, got %+v", reviewed)
+	}
+	allowed = true
+	if err := m.Ping(context.Background()); err != nil {
+		t.Errorf("expected no error when allowed, got %v", err)
+	}
+}
+
+// allocateNodePorts makes the fake clientset allocate nodePort to the services it creates,
+// as the API server does for NodePort services
+func allocateNodePorts(clientset *fake.Clientset, nodePort int32) {
+	clientset.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		svc := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+		if svc.Spec.Type == corev1.ServiceTypeNodePort {
+			for i := range svc.Spec.Ports {
+				svc.Spec.Ports[i].NodePort = nodePort
+			}
+		}
+		return false, nil, nil
+	})
+}
+
+func TestNodePortMode_CreateAddressDelete(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	allocateNodePorts(clientset, 30080)
+	mgr := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "localhost")
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	if err := mgr.CreatePod(ctx, podID); err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	svc, err := clientset.CoreV1().Services("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected a service: %v", err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeNodePort || svc.Spec.Selector["agent-id"] != "agent-1" {
+		t.Errorf("expected a NodePort service selecting the agent, got %s selecting %v", svc.Spec.Type, svc.Spec.Selector)
+	}
+	pod, err := mgr.GetPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := NodePort(pod); port != 30080 {
+		t.Errorf("expected the pod to record node port 30080, got %d", port)
+	}
+
+	address, err := mgr.GetPodAddress(ctx, podID)
+	if err != nil || address != "http://localhost:30080" {
+		t.Errorf("expected the node port address, got %q, %v", address, err)
+	}
+
+	if err := mgr.ClosePod(ctx, podID); err != nil {
+		t.Fatalf("failed to delete agent: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the service to be deleted, got %v", err)
+	}
+}
+
+func TestPodIPMode_CreatesNoService(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	mgr := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	if err := mgr.CreatePod(ctx, podID); err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	services, err := clientset.CoreV1().Services("test-ns").List(ctx, metav1.ListOptions{})
+	if err != nil || len(services.Items) != 0 {
+		t.Fatalf("expected no service, got %v, %v", services, err)
+	}
+
+	// Until the pod has an IP it has no address
+	if _, err := mgr.GetPodAddress(ctx, podID); err == nil {
+		t.Error("expected no address for a pod without an IP")
+	}
+	pod, err := mgr.GetPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().Pods("test-ns").UpdateStatus(ctx, readyPodFrom(pod, "10.0.0.5"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	address, err := mgr.GetPodAddress(ctx, podID)
+	if err != nil || address != "http://10.0.0.5:8080" {
+		t.Errorf("expected the pod IP address, got %q, %v", address, err)
+	}
+	if NodePort(pod) != 0 {
+		t.Errorf("expected no node port, got %d", NodePort(pod))
+	}
+}
+
+func TestNodePortMode_LogsFailedNodePortAnnotation(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	allocateNodePorts(clientset, 30080)
+	clientset.PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("patch refused")
+	})
+	core, logs := observer.New(zapcore.WarnLevel)
+	mgr := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "localhost")
+	mgr.logger = zap.New(core)
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	// The annotation only reports the port, so the agent is still created
+	if err := mgr.CreatePod(ctx, podID); err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	entries := logs.FilterMessage("failed to annotate pod with its node port").All()
+	if len(entries) != 1 || entries[0].ContextMap()["pod"] != podID.Name() || entries[0].ContextMap()["node_port"] != int32(30080) {
+		t.Errorf("expected the failed annotation logged with the pod, got %+v", logs.All())
+	}
+	if address, err := mgr.GetPodAddress(ctx, podID); err != nil || address != "http://localhost:30080" {
+		t.Errorf("expected the node port address, got %q, %v", address, err)
+	}
+}
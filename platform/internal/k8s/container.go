@@ -1,11 +1,20 @@
 package k8s
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 
 	"github.com/caarlos0/env/v11"
 )
 
+// ErrImageTagNotAllowed is returned for an agent image tag callers may not ask for
+var ErrImageTagNotAllowed = errors.New("image tag not allowed")
+
+// imageTagSyntax is the syntax of an image tag
+var imageTagSyntax = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
 // ContainerConfig holds container registry configuration
 type ContainerConfig struct {
 	// Registry is the container registry host (e.g., "ghcr.io", "docker.io")
@@ -24,6 +33,12 @@ type ContainerConfig struct {
 	// ImagePullSecret is the name of the K8s secret for private registry auth
 	// Leave empty for public images
 	ImagePullSecret string `env:"IMAGE_PULL_SECRET" envDefault:""`
+
+	// AllowedImageTags and ImageTagPattern decide which agent image tags callers may ask
+	// for when creating an agent: tags in the list, or matching the whole pattern. With
+	// neither set, callers cannot pick a tag.
+	AllowedImageTags []string `env:"AGENT_IMAGE_TAG_ALLOWLIST" envSeparator:","`
+	ImageTagPattern  string   `env:"AGENT_IMAGE_TAG_PATTERN"`
 }
 
 // NewContainerConfig creates a new ContainerConfig from environment variables
@@ -32,9 +47,44 @@ func NewContainerConfig() (*ContainerConfig, error) {
 	if err := env.Parse(cfg); err != nil {
 		return nil, fmt.Errorf("parsing container config: %w", err)
 	}
+	if _, err := cfg.ImageTagPolicy(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// ImageTagPolicy decides which agent image tags callers may ask for
+type ImageTagPolicy struct {
+	Allowed []string
+	// Pattern, if set, allows the tags it matches in full
+	Pattern *regexp.Regexp
+}
+
+// ImageTagPolicy returns the policy AllowedImageTags and ImageTagPattern configure
+func (c *ContainerConfig) ImageTagPolicy() (ImageTagPolicy, error) {
+	policy := ImageTagPolicy{Allowed: c.AllowedImageTags}
+	if c.ImageTagPattern != "" {
+		pattern, err := regexp.Compile(`^(?:` + c.ImageTagPattern + `)$`)
+		if err != nil {
+			return ImageTagPolicy{}, fmt.Errorf("invalid AGENT_IMAGE_TAG_PATTERN: %w", err)
+		}
+		policy.Pattern = pattern
+	}
+	return policy, nil
+}
+
+// Check returns an error wrapping ErrImageTagNotAllowed unless tag is a well-formed tag the
+// policy allows
+func (p ImageTagPolicy) Check(tag string) error {
+	if !imageTagSyntax.MatchString(tag) {
+		return fmt.Errorf("%w: %q is not a valid image tag", ErrImageTagNotAllowed, tag)
+	}
+	if slices.Contains(p.Allowed, tag) || (p.Pattern != nil && p.Pattern.MatchString(tag)) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q is not an allowed image tag", ErrImageTagNotAllowed, tag)
+}
+
 // AgentImage returns the full image reference for the agent
 // e.g., "ghcr.io/notzree/forge-agent:latest" or "registry:5111/forge-agent:latest"
 func (c *ContainerConfig) AgentImage() string {
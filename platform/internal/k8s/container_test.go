@@ -0,0 +1,115 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestImageTagPolicy_Check(t *testing.T) {
+	cfg := &ContainerConfig{AllowedImageTags: []string{"stable", "canary"}, ImageTagPattern: `v\d+\.\d+\.\d+`}
+	policy, err := cfg.ImageTagPolicy()
+	if err != nil {
+		t.Fatalf("failed to build policy: %v", err)
+	}
+
+	for _, tag := range []string{"stable", "canary", "v1.2.3"} {
+		if err := policy.Check(tag); err != nil {
+			t.Errorf("expected %q to be allowed, got %v", tag, err)
+		}
+	}
+	for _, tag := range []string{
+		"latest",          // not allowed
+		"v1.2.3-evil",     // the pattern must match in full
+		"xv1.2.3",         // the pattern must match in full
+		"evil/image:tag",  // not a tag
+		"stable@sha256:0", // not a tag
+		"",
+	} {
+		if err := policy.Check(tag); !errors.Is(err, ErrImageTagNotAllowed) {
+			t.Errorf("expected %q to be refused, got %v", tag, err)
+		}
+	}
+
+	if err := (ImageTagPolicy{}).Check("latest"); !errors.Is(err, ErrImageTagNotAllowed) {
+		t.Errorf("expected an empty policy to refuse every tag, got %v", err)
+	}
+	if _, err := (&ContainerConfig{ImageTagPattern: "v("}).ImageTagPolicy(); err == nil {
+		t.Error("expected an invalid pattern to be refused")
+	}
+}
+
+func TestCreatePodWithOptions_ImageTagAndPullSecret(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	m := NewManagerWithClientset(clientset, "test-ns", "ghcr.io/forge/forge-agent:latest", "")
+	m.imagePullSecret = "registry-creds"
+	m.imageTags = ImageTagPolicy{Allowed: []string{"v2"}}
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	if err := m.CreatePodWithOptions(ctx, podID, PodOptions{ImageTag: "v3"}); !errors.Is(err, ErrImageTagNotAllowed) {
+		t.Fatalf("expected a tag outside the policy to be refused, got %v", err)
+	}
+	if err := m.CreatePodWithOptions(ctx, podID, PodOptions{ImageTag: "v2"}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := pod.Spec.Containers[0].Image; image != "ghcr.io/forge/forge-agent:v2" {
+		t.Errorf("expected the agent image with tag v2, got %s", image)
+	}
+	if pod.Annotations[ImageTagAnnotation] != "v2" {
+		t.Errorf("expected the pod annotated with tag v2, got %v", pod.Annotations)
+	}
+	if len(pod.Spec.ImagePullSecrets) != 1 || pod.Spec.ImagePullSecrets[0].Name != "registry-creds" {
+		t.Errorf("expected the registry-creds pull secret, got %v", pod.Spec.ImagePullSecrets)
+	}
+}
+
+func TestCreatePodWithOptions_Settings(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	if err := m.CreatePodWithOptions(ctx, podID, PodOptions{Model: "anthropic/claude-sonnet-4", PermissionMode: "default"}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+
+	// The restart waits for the old pod's deletion to be watched
+	watchers := make(chan *watch.FakeWatcher, 1)
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+	clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		go func() { (<-watchers).Delete(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podID.Name()}}) }()
+		return false, nil, nil
+	})
+	if err := m.RestartPod(ctx, podID); err != nil {
+		t.Fatalf("failed to restart pod: %v", err)
+	}
+
+	// The restarted pod starts the agent with the same settings
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model := agentEnv(pod, AgentModelEnv); model != "anthropic/claude-sonnet-4" {
+		t.Errorf("expected %s=anthropic/claude-sonnet-4, got %q", AgentModelEnv, model)
+	}
+	if mode := agentEnv(pod, PermissionModeEnv); mode != "default" {
+		t.Errorf("expected %s=default, got %q", PermissionModeEnv, mode)
+	}
+}
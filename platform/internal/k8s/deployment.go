@@ -0,0 +1,192 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Workload kinds agents can run as
+const (
+	// WorkloadKindPod runs each agent as a bare pod, which is gone if it dies
+	WorkloadKindPod = "pod"
+	// WorkloadKindDeployment runs each agent as a single-replica Deployment behind a
+	// Service, so its pod is replaced if it dies and its address survives the replacement
+	WorkloadKindDeployment = "deployment"
+)
+
+// parseWorkloadKind returns the workload kind named by kind, "" meaning WorkloadKindPod
+func parseWorkloadKind(kind string) (string, error) {
+	switch kind {
+	case "", WorkloadKindPod:
+		return WorkloadKindPod, nil
+	case WorkloadKindDeployment:
+		return WorkloadKindDeployment, nil
+	default:
+		return "", fmt.Errorf("unknown agent workload kind %q (want %q or %q)", kind, WorkloadKindPod, WorkloadKindDeployment)
+	}
+}
+
+// deployments reports whether agents run as Deployments
+func (m *Manager) deployments() bool {
+	return m.workloadKind == WorkloadKindDeployment
+}
+
+// createDeployment creates podID's Deployment, running pod, and the Service in front of it
+func (m *Manager) createDeployment(ctx context.Context, podID PodID, pod *corev1.Pod) error {
+	// Deployments only allow pods that are restarted
+	pod.Spec.RestartPolicy = corev1.RestartPolicyAlways
+
+	// The Service comes first so that every pod the Deployment runs records its node port
+	serviceType := corev1.ServiceTypeClusterIP
+	if m.nodeHost != "" {
+		serviceType = corev1.ServiceTypeNodePort
+	}
+	svc, err := m.createService(ctx, podID, serviceType)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	if port := serviceNodePort(svc); port != 0 {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[NodePortAnnotation] = strconv.Itoa(int(port))
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        podID.Name(),
+			Labels:      podSelector(podID),
+			Annotations: pod.Annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr(int32(1)),
+			Selector: &metav1.LabelSelector{MatchLabels: podSelector(podID)},
+			// Never run two instances of an agent at once
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      pod.Labels,
+					Annotations: pod.Annotations,
+				},
+				Spec: pod.Spec,
+			},
+		},
+	}
+	if _, err := m.clientset.AppsV1().Deployments(m.agentNamespace).Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+		_ = m.clientset.CoreV1().Services(m.agentNamespace).Delete(context.Background(), podID.Name(), metav1.DeleteOptions{})
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+	return nil
+}
+
+// deleteDeployment deletes podID's Deployment, its pods and its Service
+func (m *Manager) deleteDeployment(ctx context.Context, podID PodID) error {
+	// Ignore errors - the service might not exist
+	_ = m.clientset.CoreV1().Services(m.agentNamespace).Delete(ctx, podID.Name(), metav1.DeleteOptions{})
+
+	err := m.clientset.AppsV1().Deployments(m.agentNamespace).Delete(ctx, podID.Name(), metav1.DeleteOptions{
+		PropagationPolicy: ptr(metav1.DeletePropagationBackground),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete deployment %s: %w", podID.Name(), err)
+	}
+	return nil
+}
+
+// deleteDeploymentsForUser deletes the Deployments, pods and Services of userID's agents
+func (m *Manager) deleteDeploymentsForUser(ctx context.Context, userID string) error {
+	selector := metav1.ListOptions{LabelSelector: UserIDLabel(userID)}
+	deleteOpts := metav1.DeleteOptions{PropagationPolicy: ptr(metav1.DeletePropagationBackground)}
+
+	if err := m.clientset.AppsV1().Deployments(m.agentNamespace).DeleteCollection(ctx, deleteOpts, selector); err != nil {
+		return fmt.Errorf("failed to delete deployments for user %s: %w", userID, err)
+	}
+	services, err := m.clientset.CoreV1().Services(m.agentNamespace).List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list services for user %s: %w", userID, err)
+	}
+	for _, svc := range services.Items {
+		if err := m.clientset.CoreV1().Services(m.agentNamespace).Delete(ctx, svc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete service %s: %w", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+// serviceAddress returns the in-cluster address of podID's Service
+func (m *Manager) serviceAddress(podID PodID) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", podID.Name(), m.agentNamespace, DefaultAgentPort)
+}
+
+// getDeploymentPod returns the current pod of podID's Deployment, from the cache once
+// started and if it has one. While a pod is being replaced, the ready or else newest pod
+// not being deleted is the current one.
+func (m *Manager) getDeploymentPod(ctx context.Context, podID PodID) (*corev1.Pod, error) {
+	if c := m.podCache(); c != nil {
+		pods, err := c.cachedPods(m.agentNamespace, labels.SelectorFromSet(podSelector(podID)))
+		if err == nil {
+			if pod := currentPod(pods); pod != nil {
+				return pod.DeepCopy(), nil
+			}
+		}
+	}
+	pods, err := m.clientset.CoreV1().Pods(m.agentNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(podSelector(podID)).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s: %w", podID.Name(), err)
+	}
+	pod := currentPod(pods.Items)
+	if pod == nil {
+		return nil, fmt.Errorf("failed to get pod %s: %w", podID.Name(),
+			apierrors.NewNotFound(corev1.Resource("pods"), podID.Name()))
+	}
+	return pod, nil
+}
+
+// currentPod returns the ready or else newest pod of pods not being deleted, or nil
+func currentPod(pods []corev1.Pod) *corev1.Pod {
+	var current *corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if current == nil ||
+			(IsPodReady(pod) && !IsPodReady(current)) ||
+			(IsPodReady(pod) == IsPodReady(current) && current.CreationTimestamp.Before(&pod.CreationTimestamp)) {
+			current = pod
+		}
+	}
+	return current
+}
+
+// currentPods returns the current pod of each agent among pods, by agent ID
+func currentPods(pods []corev1.Pod) []corev1.Pod {
+	byAgent := make(map[string][]corev1.Pod)
+	for _, pod := range pods {
+		agentID := pod.Labels["agent-id"]
+		byAgent[agentID] = append(byAgent[agentID], pod)
+	}
+	agentIDs := make([]string, 0, len(byAgent))
+	for agentID := range byAgent {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sort.Strings(agentIDs)
+
+	current := make([]corev1.Pod, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		if pod := currentPod(byAgent[agentID]); pod != nil {
+			current = append(current, *pod)
+		}
+	}
+	return current
+}
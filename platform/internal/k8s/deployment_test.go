@@ -0,0 +1,172 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// deploymentPod returns a ready pod of podID's Deployment, as its ReplicaSet would create it
+func deploymentPod(podID PodID, suffix string, created time.Time) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              podID.Name() + "-" + suffix,
+			Namespace:         "test-ns",
+			Labels:            podSelector(podID),
+			CreationTimestamp: metav1.NewTime(created),
+		},
+	}
+	return readyPodFrom(pod, "10.0.0."+suffix)
+}
+
+func TestDeploymentMode_CreateReadyDelete(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	watchers := make(chan *watch.FakeWatcher, 1)
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+	mgr := NewDeploymentManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	if err := mgr.CreatePod(ctx, podID); err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	deployment, err := clientset.AppsV1().Deployments("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected a deployment: %v", err)
+	}
+	if *deployment.Spec.Replicas != 1 || deployment.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyAlways {
+		t.Errorf("expected one replica restarted on failure, got %d replicas and policy %s",
+			*deployment.Spec.Replicas, deployment.Spec.Template.Spec.RestartPolicy)
+	}
+	svc, err := clientset.CoreV1().Services("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected a service: %v", err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeClusterIP {
+		t.Errorf("expected a ClusterIP service, got %s", svc.Spec.Type)
+	}
+
+	// The Deployment has no pod yet: the wait watches for its first ready pod
+	results := startWaiters(ctx, mgr, podID, 1)
+	w := nextWatcher(t, watchers)
+	pod := deploymentPod(podID, "1", time.Now())
+	if _, err := clientset.CoreV1().Pods("test-ns").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	w.Add(pod)
+	select {
+	case r := <-results:
+		if r.err != nil || r.pod.Name != pod.Name {
+			t.Fatalf("expected pod %s to be ready, got %v, %v", pod.Name, r.pod, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the pod to be ready")
+	}
+
+	address, err := mgr.GetPodAddress(ctx, podID)
+	if err != nil || address != "http://user-1-agent-1.test-ns.svc.cluster.local:8080" {
+		t.Errorf("expected the service DNS address, got %q, %v", address, err)
+	}
+
+	if err := mgr.ClosePod(ctx, podID); err != nil {
+		t.Fatalf("failed to delete agent: %v", err)
+	}
+	if _, err := clientset.AppsV1().Deployments("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the deployment to be deleted, got %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the service to be deleted, got %v", err)
+	}
+}
+
+func TestDeploymentMode_NodePortRecordedOnPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	allocateNodePorts(clientset, 30081)
+	mgr := NewDeploymentManagerWithClientset(clientset, "test-ns", "test-image:latest", "localhost")
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	if err := mgr.CreatePod(ctx, podID); err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	svc, err := clientset.CoreV1().Services("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{})
+	if err != nil || svc.Spec.Type != corev1.ServiceTypeNodePort {
+		t.Fatalf("expected a NodePort service, got %v, %v", svc, err)
+	}
+	deployment, err := clientset.AppsV1().Deployments("test-ns").Get(ctx, podID.Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := deployment.Spec.Template.Annotations[NodePortAnnotation]; got != "30081" {
+		t.Errorf("expected the pod template to record node port 30081, got %q", got)
+	}
+	address, err := mgr.GetPodAddress(ctx, podID)
+	if err != nil || address != "http://localhost:30081" {
+		t.Errorf("expected the node port address, got %q, %v", address, err)
+	}
+}
+
+func TestDeploymentMode_SelectorsScopeByUserAndAgent(t *testing.T) {
+	now := time.Now()
+	agent := PodID{UserID: "user-1", AgentID: "agent-1"}
+	sibling := PodID{UserID: "user-1", AgentID: "agent-2"}
+	other := PodID{UserID: "user-2", AgentID: "agent-1"}
+	replaced := deploymentPod(agent, "1", now.Add(-time.Minute))
+	replaced.DeletionTimestamp = &metav1.Time{Time: now}
+	clientset := fake.NewSimpleClientset(
+		replaced,
+		deploymentPod(agent, "2", now),
+		deploymentPod(sibling, "3", now),
+		deploymentPod(other, "4", now),
+	)
+	mgr := NewDeploymentManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	ctx := context.Background()
+
+	if err := mgr.CreatePod(ctx, agent); err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	deployment, err := clientset.AppsV1().Deployments("test-ns").Get(ctx, agent.Name(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := deployment.Spec.Selector.MatchLabels
+	if len(selector) != 4 || selector["user-id"] != "user-1" || selector["agent-id"] != "agent-1" || !isManaged(selector) {
+		t.Errorf("expected the deployment to select its managed pods by user-id and agent-id, got %v", selector)
+	}
+	svc, err := clientset.CoreV1().Services("test-ns").Get(ctx, agent.Name(), metav1.GetOptions{})
+	if err != nil || len(svc.Spec.Selector) != 4 || svc.Spec.Selector["agent-id"] != "agent-1" || !isManaged(svc.Spec.Selector) {
+		t.Errorf("expected the service to select managed pods by user-id and agent-id, got %v, %v", svc, err)
+	}
+
+	pod, err := mgr.GetPod(ctx, agent)
+	if err != nil || pod.Name != agent.Name()+"-2" {
+		t.Errorf("expected the agent's current pod, got %v, %v", pod, err)
+	}
+	pods, err := mgr.ListPodsForUser(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 2 || pods.Items[0].Name != agent.Name()+"-2" || pods.Items[1].Name != sibling.Name()+"-3" {
+		t.Errorf("expected the current pod of each of user-1's agents, got %v", pods.Items)
+	}
+	if _, err := mgr.GetPod(ctx, PodID{UserID: "user-3", AgentID: "agent-1"}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected not found for an agent without pods, got %v", err)
+	}
+}
+
+func TestNewManager_RejectsUnknownWorkloadKind(t *testing.T) {
+	if _, err := NewManager(ManagerOpts{WorkloadKind: "statefulset"}); err == nil {
+		t.Error("expected an unknown workload kind to be refused")
+	}
+}
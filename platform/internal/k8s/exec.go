@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// ErrExecUnavailable is returned by ExecInPod when the Manager has no executor, e.g. one
+// built from a clientset without a REST config
+var ErrExecUnavailable = stderrors.New("exec is not available")
+
+// PodExecutor runs commands in a pod's container, streaming stdin to them and their output
+// to stdout and stderr. A command exiting non-zero is reported by an exec.ExitError.
+type PodExecutor interface {
+	Exec(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// spdyExecutor runs commands through the pods/exec subresource of the API server
+type spdyExecutor struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+}
+
+// Exec implements PodExecutor
+func (e *spdyExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	req := e.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
+// SetExecutor replaces how commands are run in pods. This is primarily useful for testing
+// without an API server.
+func (m *Manager) SetExecutor(executor PodExecutor) {
+	m.executor = executor
+}
+
+// ExecResult is the outcome of a command run in a pod. Output beyond the cap ExecInPod
+// was given is dropped, and Truncated set.
+type ExecResult struct {
+	ExitCode  int
+	Stdout    string
+	Stderr    string
+	Truncated bool
+}
+
+// ExecInPod runs command in the agent container of podID's pod until it exits or ctx ends,
+// capturing up to maxOutput bytes of each of its stdout and stderr
+func (m *Manager) ExecInPod(ctx context.Context, podID PodID, command []string, maxOutput int) (*ExecResult, error) {
+	if m.executor == nil {
+		return nil, ErrExecUnavailable
+	}
+	name, err := m.podName(ctx, podID)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stderr := &cappedBuffer{max: maxOutput}, &cappedBuffer{max: maxOutput}
+	err = m.executor.Exec(ctx, m.agentNamespace, name, AgentContainerName, command, nil, stdout, stderr)
+	result := &ExecResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+	var exitErr exec.ExitError
+	if stderrors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitStatus()
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to exec in pod %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest. The buffer is
+// not embedded so its WriteString cannot bypass the cap.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
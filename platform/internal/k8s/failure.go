@@ -0,0 +1,202 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podFailureEvents is how many of the pod's latest events a PodFailureError carries
+const podFailureEvents = 5
+
+// podFailureEventsTimeout bounds fetching the events of a failed pod
+const podFailureEventsTimeout = 2 * time.Second
+
+// terminalWaitingReasons are the container waiting reasons a pod does not recover from
+// without a change to its spec or image
+var terminalWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// PodFailureError reports why an agent pod failed to become ready: it failed or is stuck in
+// a state it will not leave by itself, or it was still not ready when the wait ended
+type PodFailureError struct {
+	Pod   string
+	Phase corev1.PodPhase
+	// Reason and Message describe the state the pod is stuck in, e.g. ImagePullBackOff
+	Reason  string
+	Message string
+	// Events are the messages of the pod's latest events, oldest first
+	Events []string
+	// Terminal is true if the pod will not become ready without a change, and false if
+	// the wait ended first
+	Terminal bool
+	// Err is what ended the wait, for failures that are not terminal
+	Err error
+}
+
+func (e *PodFailureError) Error() string {
+	msg := fmt.Sprintf("pod %s failed to become ready (phase %s", e.Pod, e.Phase)
+	if e.Reason != "" {
+		msg += ", reason " + e.Reason
+	}
+	msg += ")"
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *PodFailureError) Unwrap() error {
+	return e.Err
+}
+
+// podFailure returns the failure of a pod that failed or is stuck in a state it will not
+// leave by itself, or nil. Events are not fetched.
+func podFailure(pod *corev1.Pod) *PodFailureError {
+	reason, message, terminal := podState(pod)
+	if !terminal {
+		return nil
+	}
+	return &PodFailureError{Pod: pod.Name, Phase: pod.Status.Phase, Reason: reason, Message: message, Terminal: true}
+}
+
+// UnreadyReason describes why a pod is not ready, e.g. CrashLoopBackOff, and reports
+// whether it is stuck in that state
+func UnreadyReason(pod *corev1.Pod) (reason string, stuck bool) {
+	reason, _, stuck = podState(pod)
+	return reason, stuck
+}
+
+// podState describes why a pod is not ready: the reason and message of its failure or of
+// its first waiting or terminated container, or else of a condition that is not met. It
+// reports whether the pod is stuck in that state.
+func podState(pod *corev1.Pod) (reason, message string, terminal bool) {
+	// A failed init container, such as the repository clone, fails the same way again
+	if cs, t, ok := failedInit(pod.Status.InitContainerStatuses); ok {
+		reason = "InitContainerFailed"
+		if cs.Name == RepositoryInitContainerName {
+			reason = "RepositoryCloneFailed"
+		}
+		message = strings.TrimSpace(t.Message)
+		if message == "" {
+			message = fmt.Sprintf("init container %s exited with code %d", cs.Name, t.ExitCode)
+		}
+		return reason, message, true
+	}
+	if pod.Status.Phase == corev1.PodFailed {
+		reason, message = pod.Status.Reason, pod.Status.Message
+		if cs, ok := firstTerminated(pod.Status.ContainerStatuses); ok && reason == "" {
+			reason, message = cs.State.Terminated.Reason, cs.State.Terminated.Message
+		}
+		return reason, message, true
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if w := cs.State.Waiting; w != nil && w.Reason != "" {
+			return w.Reason, w.Message, terminalWaitingReasons[w.Reason]
+		}
+	}
+	if cs, ok := firstTerminated(statuses); ok {
+		return cs.State.Terminated.Reason, cs.State.Terminated.Message, false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue && cond.Reason != "" {
+			return cond.Reason, cond.Message, false
+		}
+	}
+	return "", "", false
+}
+
+// failedInit returns the first init container status of a container that exited non-zero,
+// now or before it was restarted, and how it exited
+func failedInit(statuses []corev1.ContainerStatus) (corev1.ContainerStatus, *corev1.ContainerStateTerminated, bool) {
+	for _, cs := range statuses {
+		for _, t := range []*corev1.ContainerStateTerminated{cs.State.Terminated, cs.LastTerminationState.Terminated} {
+			if t != nil && t.ExitCode != 0 {
+				return cs, t, true
+			}
+		}
+	}
+	return corev1.ContainerStatus{}, nil, false
+}
+
+// firstTerminated returns the first container status of a terminated container
+func firstTerminated(statuses []corev1.ContainerStatus) (corev1.ContainerStatus, bool) {
+	for _, cs := range statuses {
+		if cs.State.Terminated != nil {
+			return cs, true
+		}
+	}
+	return corev1.ContainerStatus{}, false
+}
+
+// describeFailure completes failure with the pod's latest events. If the events cannot be
+// fetched, the failure goes without them.
+func (m *Manager) describeFailure(failure *PodFailureError) *PodFailureError {
+	ctx, cancel := context.WithTimeout(context.Background(), podFailureEventsTimeout)
+	defer cancel()
+
+	events, err := m.clientset.CoreV1().Events(m.agentNamespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod,involvedObject.name=" + failure.Pod,
+	})
+	if err != nil {
+		return failure
+	}
+	items := events.Items
+	sort.SliceStable(items, func(i, j int) bool { return eventTime(items[i]).Before(eventTime(items[j])) })
+	if len(items) > podFailureEvents {
+		items = items[len(items)-podFailureEvents:]
+	}
+	for _, e := range items {
+		failure.Events = append(failure.Events, strings.TrimSpace(fmt.Sprintf("%s: %s", e.Reason, e.Message)))
+	}
+	return failure
+}
+
+// eventTime returns when an event last happened
+func eventTime(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
+
+// unreadyFailure returns the failure of a pod that was still not ready when the wait for it
+// ended with err, or nil if the pod cannot be read
+func (m *Manager) unreadyFailure(podID PodID, err error) *PodFailureError {
+	ctx, cancel := context.WithTimeout(context.Background(), podFailureEventsTimeout)
+	defer cancel()
+	pod, getErr := m.GetPod(ctx, podID)
+	if getErr != nil {
+		return nil
+	}
+	reason, message, terminal := podState(pod)
+	return m.describeFailure(&PodFailureError{
+		Pod:      pod.Name,
+		Phase:    pod.Status.Phase,
+		Reason:   reason,
+		Message:  message,
+		Terminal: terminal,
+		Err:      err,
+	})
+}
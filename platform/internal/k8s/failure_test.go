@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podEvent returns an event about the pod
+func podEvent(podID PodID, name, reason, message string, at time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "test-ns"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: podID.Name(), Namespace: "test-ns"},
+		Reason:         reason,
+		Message:        message,
+		LastTimestamp:  metav1.NewTime(at),
+	}
+}
+
+// waitingPod returns the pod with its container waiting for reason
+func waitingPod(pod *corev1.Pod, reason, message string) *corev1.Pod {
+	waiting := pod.DeepCopy()
+	waiting.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  AgentContainerName,
+		State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message}},
+	}}
+	return waiting
+}
+
+func TestWaitForPodReady_ReportsStuckPods(t *testing.T) {
+	for _, tt := range []struct {
+		reason  string
+		message string
+	}{
+		{"ImagePullBackOff", `Back-off pulling image "ghcr.io/forge/forge-agent:missing"`},
+		{"CrashLoopBackOff", "back-off 10s restarting failed container"},
+	} {
+		t.Run(tt.reason, func(t *testing.T) {
+			podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+			pod := pendingPod("test-ns", podID)
+			now := time.Now()
+			mgr, watchers, _ := countingWatchManager(t, 0, pod,
+				podEvent(podID, "e1", "Scheduled", "Successfully assigned", now.Add(-2*time.Second)),
+				podEvent(podID, "e2", "Failed", "Error: "+tt.reason, now),
+				podEvent(podID, "e0", "Pulling", "Pulling image", now.Add(-time.Second)),
+			)
+
+			results := startWaiters(context.Background(), mgr, podID, 1)
+			w := nextWatcher(t, watchers)
+			w.Modify(waitingPod(pod, "ContainerCreating", ""))
+			w.Modify(waitingPod(pod, tt.reason, tt.message))
+
+			var r waitResult
+			select {
+			case r = <-results:
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for the failure")
+			}
+			var failure *PodFailureError
+			if !errors.As(r.err, &failure) {
+				t.Fatalf("expected a PodFailureError, got %v", r.err)
+			}
+			if !failure.Terminal || failure.Reason != tt.reason || failure.Message != tt.message ||
+				failure.Phase != corev1.PodPending || failure.Pod != podID.Name() {
+				t.Errorf("expected a terminal %s failure, got %+v", tt.reason, failure)
+			}
+			want := []string{"Scheduled: Successfully assigned", "Pulling: Pulling image", "Failed: Error: " + tt.reason}
+			if strings.Join(failure.Events, "|") != strings.Join(want, "|") {
+				t.Errorf("expected events %q, got %q", want, failure.Events)
+			}
+		})
+	}
+}
+
+func TestWaitForPodReady_ReportsFailedPod(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := pendingPod("test-ns", podID)
+	pod.Status.Phase = corev1.PodFailed
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+	}}
+	mgr, _, _ := countingWatchManager(t, 0, pod)
+
+	_, err := mgr.WaitForPodReady(context.Background(), podID)
+	var failure *PodFailureError
+	if !errors.As(err, &failure) || !failure.Terminal || failure.Reason != "OOMKilled" || failure.Phase != corev1.PodFailed {
+		t.Errorf("expected an OOMKilled failure, got %v", err)
+	}
+}
+
+func TestWaitForPodReady_DeadlineReportsPendingReason(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := pendingPod("test-ns", podID)
+	pod.Status.Conditions = []corev1.PodCondition{{
+		Type:    corev1.PodScheduled,
+		Status:  corev1.ConditionFalse,
+		Reason:  "Unschedulable",
+		Message: "0/3 nodes are available: 3 Insufficient memory.",
+	}}
+	mgr, _, _ := countingWatchManager(t, 0, pod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := mgr.WaitForPodReady(ctx, podID)
+
+	var failure *PodFailureError
+	if !errors.As(err, &failure) || failure.Terminal || failure.Reason != "Unschedulable" {
+		t.Fatalf("expected an Unschedulable failure that is not terminal, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the failure to wrap the deadline, got %v", err)
+	}
+}
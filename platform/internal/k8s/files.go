@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrInvalidPath is returned for a workspace path that is absolute or leaves the workspace
+	ErrInvalidPath = stderrors.New("invalid workspace path")
+	// ErrFileNotFound is returned by CopyFromPod for a path that does not exist in the pod
+	ErrFileNotFound = stderrors.New("file not found")
+)
+
+// copyStderrMaxBytes caps the stderr of tar kept for the error of a failed copy
+const copyStderrMaxBytes = 4096
+
+// CleanRelativePath cleans p, a slash-separated path relative to some directory, and
+// returns ErrInvalidPath if it is absolute or leaves that directory. An empty p is ".".
+func CleanRelativePath(p string) (string, error) {
+	if strings.ContainsRune(p, 0) || path.IsAbs(p) || strings.HasPrefix(p, `\`) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, p)
+	}
+	cleaned := path.Clean("./" + p)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %q leaves the workspace", ErrInvalidPath, p)
+	}
+	return cleaned, nil
+}
+
+// WorkspacePath returns the path in the agent container of rel, a path relative to the
+// agent's working directory
+func WorkspacePath(rel string) (string, error) {
+	cleaned, err := CleanRelativePath(rel)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(DefaultWorkspaceMountPath, cleaned), nil
+}
+
+// CopyToPod extracts tarball, a tar archive, into dir in the agent container of podID's
+// pod, creating dir if needed. Entries are written as the container's user, with the
+// agent's own access to its filesystem.
+func (m *Manager) CopyToPod(ctx context.Context, podID PodID, dir string, tarball io.Reader) error {
+	if m.executor == nil {
+		return ErrExecUnavailable
+	}
+	name, err := m.podName(ctx, podID)
+	if err != nil {
+		return err
+	}
+
+	command := []string{"sh", "-c", `mkdir -p "$1" && tar -xf - --no-same-owner -C "$1"`, "sh", dir}
+	stderr := &cappedBuffer{max: copyStderrMaxBytes}
+	if err := m.executor.Exec(ctx, m.agentNamespace, name, AgentContainerName, command, tarball, io.Discard, stderr); err != nil {
+		return fmt.Errorf("failed to copy files to %s in pod %s: %w%s", dir, name, err, stderrSuffix(stderr))
+	}
+	return nil
+}
+
+// CopyFromPod writes a tar archive of p, a file or directory in the agent container of
+// podID's pod, to w. The archive's entries are named after p's base name. It returns
+// ErrFileNotFound if p does not exist.
+func (m *Manager) CopyFromPod(ctx context.Context, podID PodID, p string, w io.Writer) error {
+	if m.executor == nil {
+		return ErrExecUnavailable
+	}
+	name, err := m.podName(ctx, podID)
+	if err != nil {
+		return err
+	}
+
+	command := []string{"tar", "-cf", "-", "-C", path.Dir(p), path.Base(p)}
+	stderr := &cappedBuffer{max: copyStderrMaxBytes}
+	if err := m.executor.Exec(ctx, m.agentNamespace, name, AgentContainerName, command, nil, w, stderr); err != nil {
+		if strings.Contains(stderr.String(), "No such file or directory") {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, p)
+		}
+		return fmt.Errorf("failed to copy %s from pod %s: %w%s", p, name, err, stderrSuffix(stderr))
+	}
+	return nil
+}
+
+// stderrSuffix formats what a failed command wrote to stderr for its error
+func stderrSuffix(stderr *cappedBuffer) string {
+	if s := strings.TrimSpace(stderr.String()); s != "" {
+		return ": " + s
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package k8s
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWorkspacePath(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want string
+	}{
+		{"", "/home/agent/workspace"},
+		{".", "/home/agent/workspace"},
+		{"src/main.go", "/home/agent/workspace/src/main.go"},
+		{"src/../out/", "/home/agent/workspace/out"},
+		{"./a//b", "/home/agent/workspace/a/b"},
+	}
+	for _, tt := range tests {
+		got, err := WorkspacePath(tt.rel)
+		if err != nil || got != tt.want {
+			t.Errorf("WorkspacePath(%q) = %q, %v; want %q", tt.rel, got, err, tt.want)
+		}
+	}
+
+	for _, rel := range []string{"..", "../etc", "a/../../b", "/etc/passwd", `\windows`, "a\x00b"} {
+		if _, err := WorkspacePath(rel); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("WorkspacePath(%q): expected ErrInvalidPath, got %v", rel, err)
+		}
+	}
+}
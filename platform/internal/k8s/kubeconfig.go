@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DefaultAgentNamespace is where agents run if no namespace is configured and the platform
+// runs outside the cluster
+const DefaultAgentNamespace = "default"
+
+// Ways a Manager's Kubernetes client config can be loaded
+const (
+	// KubeConfigInCluster uses the service account of the pod the platform runs in
+	KubeConfigInCluster = "in-cluster"
+	// KubeConfigFile uses a kubeconfig file: KUBE_CONFIG_PATH, or else $KUBECONFIG or
+	// ~/.kube/config
+	KubeConfigFile = "kubeconfig"
+)
+
+// serviceAccountNamespacePath holds the namespace of the pod's service account
+const serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// kubeConfig is a loaded client config, how it was loaded, and, in the cluster, the
+// namespace the platform runs in
+type kubeConfig struct {
+	rest      *rest.Config
+	mode      string
+	namespace string
+}
+
+// kubeConfigLoader loads the client config of a Manager. Its funcs are replaced in tests.
+type kubeConfigLoader struct {
+	inCluster func() (*rest.Config, error)
+	// fromFile loads the kubeconfig at path, or the default one if path is empty
+	fromFile func(path string) (*rest.Config, error)
+	// namespace returns the namespace of the pod's service account
+	namespace func() (string, error)
+}
+
+var defaultKubeConfigLoader = kubeConfigLoader{
+	inCluster: rest.InClusterConfig,
+	fromFile: func(path string) (*rest.Config, error) {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = path
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	},
+	namespace: func() (string, error) {
+		raw, err := os.ReadFile(serviceAccountNamespacePath)
+		return strings.TrimSpace(string(raw)), err
+	},
+}
+
+// load loads the kubeconfig at path if set, and otherwise the in-cluster config, falling
+// back to the default kubeconfig outside a cluster
+func (l kubeConfigLoader) load(path string) (*kubeConfig, error) {
+	if path != "" {
+		cfg, err := l.fromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %w", path, err)
+		}
+		return &kubeConfig{rest: cfg, mode: KubeConfigFile}, nil
+	}
+
+	cfg, inClusterErr := l.inCluster()
+	if inClusterErr == nil {
+		kc := &kubeConfig{rest: cfg, mode: KubeConfigInCluster}
+		if ns, err := l.namespace(); err == nil {
+			kc.namespace = ns
+		}
+		return kc, nil
+	}
+	cfg, err := l.fromFile("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kube config: not in a cluster (%v), and no default kubeconfig: %w", inClusterErr, err)
+	}
+	return &kubeConfig{rest: cfg, mode: KubeConfigFile}, nil
+}
+
+// KubeConfigMode returns how the manager's client config was loaded, KubeConfigInCluster
+// or KubeConfigFile; empty for a manager given its clientset
+func (m *Manager) KubeConfigMode() string {
+	return m.kubeConfigMode
+}
+
+// AgentNamespace returns the namespace agents run in
+func (m *Manager) AgentNamespace() string {
+	return m.agentNamespace
+}
@@ -0,0 +1,137 @@
+package k8s
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+// fakeKubeConfigLoader returns a loader recording the kubeconfig paths it was asked for
+func fakeKubeConfigLoader(inClusterErr, fileErr error, namespace string, paths *[]string) kubeConfigLoader {
+	return kubeConfigLoader{
+		inCluster: func() (*rest.Config, error) {
+			if inClusterErr != nil {
+				return nil, inClusterErr
+			}
+			return &rest.Config{Host: "https://in-cluster"}, nil
+		},
+		fromFile: func(path string) (*rest.Config, error) {
+			*paths = append(*paths, path)
+			if fileErr != nil {
+				return nil, fileErr
+			}
+			return &rest.Config{Host: "https://from-file"}, nil
+		},
+		namespace: func() (string, error) {
+			if namespace == "" {
+				return "", errors.New("no service account")
+			}
+			return namespace, nil
+		},
+	}
+}
+
+func TestKubeConfigLoader_PathSet(t *testing.T) {
+	var paths []string
+	kc, err := fakeKubeConfigLoader(nil, nil, "forge", &paths).load("/etc/kube/config")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if kc.mode != KubeConfigFile || kc.rest.Host != "https://from-file" {
+		t.Errorf("got mode %q host %q, want the kubeconfig file", kc.mode, kc.rest.Host)
+	}
+	if len(paths) != 1 || paths[0] != "/etc/kube/config" {
+		t.Errorf("loaded kubeconfigs %q, want only the configured one", paths)
+	}
+	if kc.namespace != "" {
+		t.Errorf("namespace = %q, want none outside the cluster", kc.namespace)
+	}
+}
+
+func TestKubeConfigLoader_PathSetFails(t *testing.T) {
+	var paths []string
+	_, err := fakeKubeConfigLoader(nil, errors.New("no such file"), "", &paths).load("/missing")
+	if err == nil || !strings.Contains(err.Error(), "/missing") {
+		t.Fatalf("load error = %v, want one naming the path", err)
+	}
+}
+
+func TestKubeConfigLoader_InCluster(t *testing.T) {
+	var paths []string
+	kc, err := fakeKubeConfigLoader(nil, nil, "forge", &paths).load("")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if kc.mode != KubeConfigInCluster || kc.rest.Host != "https://in-cluster" {
+		t.Errorf("got mode %q host %q, want in-cluster", kc.mode, kc.rest.Host)
+	}
+	if kc.namespace != "forge" {
+		t.Errorf("namespace = %q, want the service account's", kc.namespace)
+	}
+	if len(paths) != 0 {
+		t.Errorf("loaded kubeconfigs %q, want none", paths)
+	}
+}
+
+func TestKubeConfigLoader_FallsBackOutsideCluster(t *testing.T) {
+	var paths []string
+	kc, err := fakeKubeConfigLoader(rest.ErrNotInCluster, nil, "", &paths).load("")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if kc.mode != KubeConfigFile {
+		t.Errorf("mode = %q, want %q", kc.mode, KubeConfigFile)
+	}
+	if len(paths) != 1 || paths[0] != "" {
+		t.Errorf("loaded kubeconfigs %q, want the default one", paths)
+	}
+}
+
+func TestKubeConfigLoader_BothFail(t *testing.T) {
+	var paths []string
+	_, err := fakeKubeConfigLoader(rest.ErrNotInCluster, errors.New("no kubeconfig"), "", &paths).load("")
+	if err == nil {
+		t.Fatal("load succeeded, want an error")
+	}
+	for _, want := range []string{rest.ErrNotInCluster.Error(), "no kubeconfig"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestNewManager_NamespaceAndRateLimits(t *testing.T) {
+	var paths []string
+	tests := []struct {
+		name       string
+		configured string
+		inCluster  error
+		saNS       string
+		want       string
+		wantMode   string
+	}{
+		{"configured wins", "agents", nil, "forge", "agents", KubeConfigInCluster},
+		{"service account namespace", "", nil, "forge", "forge", KubeConfigInCluster},
+		{"default outside the cluster", "", rest.ErrNotInCluster, "", DefaultAgentNamespace, KubeConfigFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := fakeKubeConfigLoader(tt.inCluster, nil, tt.saNS, &paths)
+			mgr, err := newManagerWithLoader(ManagerOpts{AgentNamespace: tt.configured, QPS: 20, Burst: 40}, loader)
+			if err != nil {
+				t.Fatalf("newManagerWithLoader: %v", err)
+			}
+			if got := mgr.AgentNamespace(); got != tt.want {
+				t.Errorf("AgentNamespace() = %q, want %q", got, tt.want)
+			}
+			if got := mgr.KubeConfigMode(); got != tt.wantMode {
+				t.Errorf("KubeConfigMode() = %q, want %q", got, tt.wantMode)
+			}
+			if cfg := mgr.executor.(*spdyExecutor).config; cfg.QPS != 20 || cfg.Burst != 40 {
+				t.Errorf("client QPS/Burst = %g/%d, want 20/40", cfg.QPS, cfg.Burst)
+			}
+		})
+	}
+}
@@ -1,12 +1,149 @@
 package k8s
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ManagedByLabel and ComponentLabel mark the objects the platform manages: agent pods and
+// their Deployments, Services and workspace claims. Every selector requires them, so objects
+// that merely carry agent labels are never listed or deleted.
+const (
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedBy      = "forge-platform"
+	ComponentLabel = "forge.dev/component"
+	ComponentAgent = "agent"
 )
 
+// ownerSelector selects the objects the platform manages
+var ownerSelector = labels.SelectorFromSet(ownerLabels()).String()
+
+// unlabeledAgentSelector selects agent objects created before they were marked as managed
+// by the platform
+const unlabeledAgentSelector = "user-id,agent-id,!" + ManagedByLabel
+
+// ownerLabels returns the labels marking an object managed by the platform
+func ownerLabels() map[string]string {
+	return map[string]string{
+		ManagedByLabel: ManagedBy,
+		ComponentLabel: ComponentAgent,
+	}
+}
+
+// isManaged reports whether an object with objLabels is managed by the platform
+func isManaged(objLabels map[string]string) bool {
+	return objLabels[ManagedByLabel] == ManagedBy && objLabels[ComponentLabel] == ComponentAgent
+}
+
+// ProtocolVersionAnnotation records the agent protocol version reported by the pod's agent
+const ProtocolVersionAnnotation = "agent-protocol-version"
+
+// QuarantineAnnotation marks a quarantined agent. The platform stores a JSON description of
+// the quarantine; any other value is treated as a quarantine set by hand.
+const QuarantineAnnotation = "agent-quarantine"
+
+// ImageTagAnnotation records the agent image tag the pod was created with
+const ImageTagAnnotation = "agent-image-tag"
+
+// RolloutIDAnnotation records the rollout active when the pod was created, whether or not
+// the rollout included the agent
+const RolloutIDAnnotation = "agent-rollout-id"
+
+// NodePortAnnotation records the node port of the agent's NodePort service, when the
+// platform reaches agents through NodeHost
+const NodePortAnnotation = "agent-node-port"
+
+// NodePort returns the node port recorded on the pod, or 0 if it has none
+func NodePort(pod *corev1.Pod) int32 {
+	port, err := strconv.ParseInt(pod.Annotations[NodePortAnnotation], 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(port)
+}
+
+// UserIDLabel returns the selector of the platform's objects for userID's agents
 func UserIDLabel(userID string) string {
-	return fmt.Sprintf("user-id=%s", userID)
+	return fmt.Sprintf("%s,user-id=%s", ownerSelector, userID)
 }
+
+// AgentIDLabel returns the selector of the platform's objects for agents with agentID
 func AgentIDLabel(agentID string) string {
-	return fmt.Sprintf("agent-id=%s", agentID)
+	return fmt.Sprintf("%s,agent-id=%s", ownerSelector, agentID)
+}
+
+// MigrateManagedLabels marks the agent pods, workspace claims, Services and Deployments
+// created before ManagedByLabel existed as managed by the platform, which otherwise no longer
+// sees them. A Deployment's pod template is labeled too, which replaces its pod. It returns
+// how many objects were labeled.
+func (m *Manager) MigrateManagedLabels(ctx context.Context) (int, error) {
+	meta := map[string]any{"labels": ownerLabels()}
+	patch, err := json.Marshal(map[string]any{"metadata": meta})
+	if err != nil {
+		return 0, err
+	}
+	deploymentPatch, err := json.Marshal(map[string]any{
+		"metadata": meta,
+		"spec":     map[string]any{"template": map[string]any{"metadata": meta}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	opts := metav1.ListOptions{LabelSelector: unlabeledAgentSelector}
+	core := m.clientset.CoreV1()
+	migrated := 0
+
+	pods, err := core.Pods(m.agentNamespace).List(ctx, opts)
+	if err != nil {
+		return migrated, fmt.Errorf("failed to list pods to label: %w", err)
+	}
+	for _, pod := range pods.Items {
+		if _, err := core.Pods(m.agentNamespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return migrated, fmt.Errorf("failed to label pod %s: %w", pod.Name, err)
+		}
+		migrated++
+	}
+
+	claims, err := core.PersistentVolumeClaims(m.agentNamespace).List(ctx, opts)
+	if err != nil {
+		return migrated, fmt.Errorf("failed to list workspace claims to label: %w", err)
+	}
+	for _, claim := range claims.Items {
+		if _, err := core.PersistentVolumeClaims(m.agentNamespace).Patch(ctx, claim.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return migrated, fmt.Errorf("failed to label workspace claim %s: %w", claim.Name, err)
+		}
+		migrated++
+	}
+
+	services, err := core.Services(m.agentNamespace).List(ctx, opts)
+	if err != nil {
+		return migrated, fmt.Errorf("failed to list services to label: %w", err)
+	}
+	for _, svc := range services.Items {
+		if _, err := core.Services(m.agentNamespace).Patch(ctx, svc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return migrated, fmt.Errorf("failed to label service %s: %w", svc.Name, err)
+		}
+		migrated++
+	}
+
+	deployments := m.clientset.AppsV1().Deployments(m.agentNamespace)
+	list, err := deployments.List(ctx, opts)
+	if err != nil {
+		return migrated, fmt.Errorf("failed to list deployments to label: %w", err)
+	}
+	for _, d := range list.Items {
+		if _, err := deployments.Patch(ctx, d.Name, types.MergePatchType, deploymentPatch, metav1.PatchOptions{}); err != nil {
+			return migrated, fmt.Errorf("failed to label deployment %s: %w", d.Name, err)
+		}
+		migrated++
+	}
+	return migrated, nil
 }
@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// foreignPod returns a pod carrying agent labels that the platform did not create
+func foreignPod(name string, podLabels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-ns", Labels: podLabels}}
+}
+
+func TestListPodsForUser_IgnoresUnmanagedPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset(foreignPod("foreign", map[string]string{"user-id": "user-1"}))
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	ctx := context.Background()
+	if err := m.CreatePod(ctx, PodID{UserID: "user-1", AgentID: "agent-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	pods, err := m.ListPodsForUser(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || !isManaged(pods.Items[0].Labels) {
+		t.Errorf("expected only the managed pod, got %+v", pods.Items)
+	}
+}
+
+func TestClosePodsForUser_LeavesUnmanagedPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset(foreignPod("foreign", map[string]string{"user-id": "user-1"}))
+	// The fake clientset does not implement delete-collection
+	clientset.PrependReactor("delete-collection", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.DeleteCollectionAction).GetListRestrictions().Labels
+		pods, err := clientset.Tracker().List(corev1.SchemeGroupVersion.WithResource("pods"),
+			corev1.SchemeGroupVersion.WithKind("Pod"), "test-ns")
+		if err != nil {
+			return true, nil, err
+		}
+		for _, pod := range pods.(*corev1.PodList).Items {
+			if selector.Matches(labels.Set(pod.Labels)) {
+				if err := clientset.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"), "test-ns", pod.Name); err != nil {
+					return true, nil, err
+				}
+			}
+		}
+		return true, nil, nil
+	})
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	ctx := context.Background()
+	if err := m.CreatePod(ctx, PodID{UserID: "user-1", AgentID: "agent-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ClosePodsForUser(ctx, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	pods, err := clientset.CoreV1().Pods("test-ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "foreign" {
+		t.Errorf("expected only the unmanaged pod to remain, got %+v", pods.Items)
+	}
+}
+
+func TestMigrateManagedLabels(t *testing.T) {
+	legacy := map[string]string{"user-id": "user-1", "agent-id": "agent-1"}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-1-agent-1", Namespace: "test-ns", Labels: legacy},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: legacy}},
+		},
+	}
+	clientset := fake.NewSimpleClientset(
+		foreignPod("user-1-agent-1", legacy),
+		foreignPod("foreign", map[string]string{"user-id": "user-1"}),
+		deployment,
+	)
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	ctx := context.Background()
+
+	migrated, err := m.MigrateManagedLabels(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 2 {
+		t.Errorf("expected the pod and deployment to be labeled, got %d objects", migrated)
+	}
+
+	pods, err := m.ListPodsForUser(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "user-1-agent-1" || pods.Items[0].Labels["agent-id"] != "agent-1" {
+		t.Errorf("expected the labeled pod to be listed, got %+v", pods.Items)
+	}
+	got, err := clientset.AppsV1().Deployments("test-ns").Get(ctx, deployment.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isManaged(got.Labels) || !isManaged(got.Spec.Template.Labels) {
+		t.Errorf("expected the deployment and its pod template to be labeled, got %v and %v", got.Labels, got.Spec.Template.Labels)
+	}
+
+	if migrated, err := m.MigrateManagedLabels(ctx); err != nil || migrated != 0 {
+		t.Errorf("expected nothing left to label, got %d, %v", migrated, err)
+	}
+}
@@ -0,0 +1,571 @@
+package k8s
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"connectrpc.com/connect"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/agent"
+)
+
+// Orchestrators agents can run on
+const (
+	// OrchestratorKubernetes runs agents as pods of a Kubernetes cluster
+	OrchestratorKubernetes = "kubernetes"
+	// OrchestratorLocal runs agents as child processes of the platform (see LocalOrchestrator)
+	OrchestratorLocal = "local"
+)
+
+// Defaults of the local orchestrator
+const (
+	// DefaultLocalStopTimeout is how long the local orchestrator waits for an agent to exit
+	// after each step of stopping it
+	DefaultLocalStopTimeout = 10 * time.Second
+	// localHost is the address local agents listen on
+	localHost = "127.0.0.1"
+	// localReadyPoll is how often a started local agent is checked for listening
+	localReadyPoll = 50 * time.Millisecond
+)
+
+// Orchestrator runs the agent pods of a Manager that does not drive a Kubernetes cluster.
+// It serves the API the Manager drives, so the Manager works the same on it, and runs the
+// pods created through that API.
+type Orchestrator interface {
+	// Clientset is the API the Manager creates, reads and deletes agent pods through
+	Clientset() kubernetes.Interface
+	// NodeHost is the host the agents' node ports are reached on
+	NodeHost() string
+	// Start starts running the pods created through Clientset
+	Start(ctx context.Context) error
+	// Stop stops every agent, waiting for them to exit until ctx is done
+	Stop(ctx context.Context) error
+}
+
+// parseOrchestrator returns the orchestrator named by name, "" meaning
+// OrchestratorKubernetes
+func parseOrchestrator(name string) (string, error) {
+	switch name {
+	case "", OrchestratorKubernetes:
+		return OrchestratorKubernetes, nil
+	case OrchestratorLocal:
+		return OrchestratorLocal, nil
+	default:
+		return "", fmt.Errorf("unknown orchestrator %q (want %q or %q)", name, OrchestratorKubernetes, OrchestratorLocal)
+	}
+}
+
+// ParsePortRange parses a port range written "<first>-<last>"
+func ParsePortRange(s string) (first, last int, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("port range %q is not <first>-<last>", s)
+	}
+	if first, err = strconv.Atoi(strings.TrimSpace(from)); err == nil {
+		last, err = strconv.Atoi(strings.TrimSpace(to))
+	}
+	if err != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("port range %q is not <first>-<last> within 1-65535", s)
+	}
+	return first, last, nil
+}
+
+// LocalOrchestratorOpts configures a LocalOrchestrator
+type LocalOrchestratorOpts struct {
+	// Binary is the agent executable, run with Args
+	Binary string
+	Args   []string
+	// FirstPort and LastPort bound the ports agents are given to listen on
+	FirstPort int
+	LastPort  int
+	// Namespace is the namespace the Manager creates agent pods in
+	Namespace string
+	// StopTimeout is how long stopping an agent waits after the Shutdown RPC and after
+	// SIGTERM before moving on (0 uses DefaultLocalStopTimeout)
+	StopTimeout time.Duration
+	Logger      *zap.Logger // nil logs nothing
+}
+
+// LocalOrchestrator runs agents as child processes, for development without a cluster. Pods
+// are kept by an in-memory API (see memoryAPI): each pod created is given a free port and
+// runs the agent binary listening on it, reached through the pod's NodePort service. A pod
+// is ready once its agent listens, and fails once its agent exits. Deleting a pod asks its
+// agent to shut down, then sends SIGTERM, then SIGKILL.
+type LocalOrchestrator struct {
+	opts      LocalOrchestratorOpts
+	api       *memoryAPI
+	clientset kubernetes.Interface
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	ports    map[int]string           // pod names by the ports given to them
+	procs    map[string]*localProcess // by pod name
+	nextPort int
+	// changes are the pod creations and deletions not run yet, in order; changed is
+	// signaled when one is added, or once the orchestrator stops
+	changes  []watch.Event
+	changed  *sync.Cond
+	running  bool
+	stopping bool
+	done     chan struct{} // closed once the changes stopped being run
+}
+
+// localProcess is the agent process of a pod
+type localProcess struct {
+	pod   string
+	port  int
+	token string
+	dir   string
+	cmd   *exec.Cmd
+	// exited is closed once the process exited, and state is how it did
+	exited chan struct{}
+	state  *os.ProcessState
+	// stopping is set once the pod is deleted, so its exit is not reported
+	stopping bool
+}
+
+// NewLocalOrchestrator creates a LocalOrchestrator. Nothing runs until it is started.
+func NewLocalOrchestrator(opts LocalOrchestratorOpts) (*LocalOrchestrator, error) {
+	if opts.Binary == "" {
+		return nil, fmt.Errorf("the local orchestrator needs the agent binary")
+	}
+	if opts.FirstPort < 1 || opts.LastPort > 65535 || opts.FirstPort > opts.LastPort {
+		return nil, fmt.Errorf("invalid local agent ports %d-%d", opts.FirstPort, opts.LastPort)
+	}
+	if opts.StopTimeout <= 0 {
+		opts.StopTimeout = DefaultLocalStopTimeout
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	o := &LocalOrchestrator{
+		opts:     opts,
+		api:      newMemoryAPI(),
+		logger:   logger,
+		ports:    make(map[int]string),
+		procs:    make(map[string]*localProcess),
+		nextPort: opts.FirstPort,
+	}
+	o.changed = sync.NewCond(&o.mu)
+	o.api.admit = o.admit
+	o.api.observe = o.observe
+	o.clientset = newMemoryClientset(o.api)
+	return o, nil
+}
+
+// Clientset returns the clientset of the in-memory API the agent pods are created through
+func (o *LocalOrchestrator) Clientset() kubernetes.Interface {
+	return o.clientset
+}
+
+// NodeHost returns the host local agents listen on
+func (o *LocalOrchestrator) NodeHost() string {
+	return localHost
+}
+
+// Start starts running the agent pods as they are created, and stopping them as they are
+// deleted
+func (o *LocalOrchestrator) Start(context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.running {
+		return fmt.Errorf("the local orchestrator is started already")
+	}
+	o.running, o.done = true, make(chan struct{})
+	go o.run(o.done)
+	return nil
+}
+
+// run runs the pod creations and deletions in order until the orchestrator stops, then
+// closes done
+func (o *LocalOrchestrator) run(done chan struct{}) {
+	defer close(done)
+	for {
+		o.mu.Lock()
+		for len(o.changes) == 0 && !o.stopping {
+			o.changed.Wait()
+		}
+		if o.stopping {
+			o.mu.Unlock()
+			return
+		}
+		event := o.changes[0]
+		o.changes = o.changes[1:]
+		o.mu.Unlock()
+
+		pod := event.Object.(*corev1.Pod)
+		switch event.Type {
+		case watch.Added:
+			o.startProcess(pod)
+		case watch.Deleted:
+			go o.stopProcess(context.Background(), pod.Name)
+		}
+	}
+}
+
+// observe queues the creations and deletions of pods for run. It is called by the
+// in-memory API with its lock held, in the order of the changes.
+func (o *LocalOrchestrator) observe(change memoryChange) {
+	if change.resource != "pods" || change.event.Type == watch.Modified {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stopping {
+		return
+	}
+	o.changes = append(o.changes, change.event)
+	o.changed.Signal()
+}
+
+// Stop stops running the pod creations and deletions and stops every agent, as deleting
+// its pod would
+func (o *LocalOrchestrator) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	o.stopping = true
+	o.changes = nil
+	o.changed.Broadcast()
+	done := o.done
+	o.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+
+	// No agent starts once run returned
+	o.mu.Lock()
+	names := make([]string, 0, len(o.procs))
+	for name := range o.procs {
+		names = append(names, name)
+	}
+	o.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.stopProcess(ctx, name)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// admit gives a pod being created a free port, failing the creation if there is none, and
+// sets the node port of an agent pod's service to the port given to the pod. It is called
+// by the in-memory API with its lock held.
+func (o *LocalOrchestrator) admit(resource string, obj memoryObject) error {
+	switch obj := obj.(type) {
+	case *corev1.Pod:
+		return o.allocatePort(obj)
+	case *corev1.Service:
+		o.assignNodePort(obj)
+	}
+	return nil
+}
+
+// allocatePort gives a pod being created a free port, failing the creation if there is none
+func (o *LocalOrchestrator) allocatePort(pod *corev1.Pod) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for range o.opts.LastPort - o.opts.FirstPort + 1 {
+		port := o.nextPort
+		o.nextPort++
+		if o.nextPort > o.opts.LastPort {
+			o.nextPort = o.opts.FirstPort
+		}
+		if _, taken := o.ports[port]; taken || !portFree(port) {
+			continue
+		}
+		o.ports[port] = pod.Name
+		return nil
+	}
+	return fmt.Errorf("no free port in %d-%d for agent %s", o.opts.FirstPort, o.opts.LastPort, pod.Name)
+}
+
+// portFree reports whether nothing else listens on port
+func portFree(port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(localHost, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
+
+// assignNodePort sets the node port of an agent pod's service to the port given to the pod
+func (o *LocalOrchestrator) assignNodePort(svc *corev1.Service) {
+	if port := o.podPort(svc.Name); port != 0 {
+		for i := range svc.Spec.Ports {
+			svc.Spec.Ports[i].NodePort = int32(port)
+		}
+	}
+}
+
+// podPort returns the port given to the named pod, or 0
+func (o *LocalOrchestrator) podPort(name string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for port, pod := range o.ports {
+		if pod == name {
+			return port
+		}
+	}
+	return 0
+}
+
+// releasePort frees the port given to the named pod
+func (o *LocalOrchestrator) releasePort(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for port, pod := range o.ports {
+		if pod == name {
+			delete(o.ports, port)
+		}
+	}
+}
+
+// startProcess runs the agent of a created pod on the pod's port. The agent gets the env of
+// the platform and the literal env of the pod's agent container, and a scratch directory as
+// its working directory.
+func (o *LocalOrchestrator) startProcess(pod *corev1.Pod) {
+	port := o.podPort(pod.Name)
+	if port == 0 {
+		o.setFailed(pod.Name, "PortUnavailable", "no port was given to the agent", 1)
+		return
+	}
+	dir, err := os.MkdirTemp("", pod.Name+"-")
+	if err != nil {
+		o.releasePort(pod.Name)
+		o.setFailed(pod.Name, "StartError", fmt.Sprintf("failed to create the agent's directory: %v", err), 1)
+		return
+	}
+
+	cmd := exec.Command(o.opts.Binary, o.opts.Args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	for _, c := range pod.Spec.Containers {
+		if c.Name != AgentContainerName {
+			continue
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+			}
+		}
+	}
+	cmd.Env = append(cmd.Env, "PORT="+strconv.Itoa(port), "AGENT_CWD="+dir)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	p := &localProcess{
+		pod:    pod.Name,
+		port:   port,
+		token:  agentEnv(pod, agent.AuthTokenEnv),
+		dir:    dir,
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+	if err := cmd.Start(); err != nil {
+		_ = os.RemoveAll(dir)
+		o.releasePort(pod.Name)
+		o.setFailed(pod.Name, "StartError", fmt.Sprintf("failed to start the agent: %v", err), 1)
+		return
+	}
+	o.mu.Lock()
+	o.procs[pod.Name] = p
+	o.mu.Unlock()
+	o.logger.Info("started local agent",
+		zap.String("pod", pod.Name),
+		zap.Int("port", port),
+		zap.Int("pid", cmd.Process.Pid),
+	)
+
+	go o.waitForExit(p)
+	go o.waitForListening(p)
+}
+
+// waitForListening marks the process's pod ready once the agent listens on its port
+func (o *LocalOrchestrator) waitForListening(p *localProcess) {
+	address := net.JoinHostPort(localHost, strconv.Itoa(p.port))
+	ticker := time.NewTicker(localReadyPoll)
+	defer ticker.Stop()
+	for {
+		if conn, err := net.DialTimeout("tcp", address, localReadyPoll); err == nil {
+			_ = conn.Close()
+			break
+		}
+		select {
+		case <-p.exited:
+			return
+		case <-ticker.C:
+		}
+	}
+
+	o.updateStatus(p.pod, func(status *corev1.PodStatus) bool {
+		// An agent that exited meanwhile was reported failed already
+		select {
+		case <-p.exited:
+			return false
+		default:
+		}
+		now := metav1.Now()
+		status.Phase = corev1.PodRunning
+		status.PodIP, status.HostIP = localHost, localHost
+		status.StartTime = &now
+		status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: now}}
+		status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:  AgentContainerName,
+			Ready: true,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: now}},
+		}}
+		return true
+	})
+}
+
+// waitForExit waits for the process to exit and, unless its pod was deleted, reports the
+// pod failed, or succeeded if the agent exited with code 0. The port is freed for other
+// agents either way.
+func (o *LocalOrchestrator) waitForExit(p *localProcess) {
+	err := p.cmd.Wait()
+	o.mu.Lock()
+	p.state = p.cmd.ProcessState
+	stopping := p.stopping
+	close(p.exited)
+	o.mu.Unlock()
+	if stopping {
+		return
+	}
+
+	code := p.state.ExitCode()
+	message := fmt.Sprintf("agent exited with code %d", code)
+	if status, ok := p.state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		// As the kubelet reports containers killed by a signal
+		code = 128 + int(status.Signal())
+		message = fmt.Sprintf("agent was killed by signal %s", status.Signal())
+	}
+	o.logger.Warn("local agent exited",
+		zap.String("pod", p.pod),
+		zap.Int("exit_code", code),
+		zap.Error(err),
+	)
+	o.releasePort(p.pod)
+	if code == 0 {
+		o.setExited(p.pod, corev1.PodSucceeded, "Completed", message, 0)
+		return
+	}
+	o.setFailed(p.pod, "Error", message, int32(code))
+}
+
+// setFailed reports the named pod failed, its agent having exited with code
+func (o *LocalOrchestrator) setFailed(name, reason, message string, code int32) {
+	o.setExited(name, corev1.PodFailed, reason, message, code)
+}
+
+// setExited reports the named pod's agent exited, leaving the pod in phase
+func (o *LocalOrchestrator) setExited(name string, phase corev1.PodPhase, reason, message string, code int32) {
+	o.updateStatus(name, func(status *corev1.PodStatus) bool {
+		now := metav1.Now()
+		status.Phase = phase
+		status.Reason, status.Message = reason, message
+		status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse, Reason: reason, LastTransitionTime: now}}
+		status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name: AgentContainerName,
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				ExitCode:   code,
+				Reason:     reason,
+				Message:    message,
+				FinishedAt: now,
+			}},
+		}}
+		return true
+	})
+}
+
+// updateStatus applies update to the status of the named pod, if it still exists, and
+// writes it back if update reports a change
+func (o *LocalOrchestrator) updateStatus(name string, update func(status *corev1.PodStatus) bool) {
+	_, _ = o.api.update("pods", o.opts.Namespace, name, func(obj memoryObject) (bool, error) {
+		return update(&obj.(*corev1.Pod).Status), nil
+	})
+}
+
+// stopProcess stops the agent of the named pod: it is asked to shut down over the Shutdown
+// RPC, then sent SIGTERM, then SIGKILL, each once the previous step did not make it exit
+// within the stop timeout. Its port and directory are freed once it exited.
+func (o *LocalOrchestrator) stopProcess(ctx context.Context, name string) {
+	o.mu.Lock()
+	p := o.procs[name]
+	if p == nil {
+		o.mu.Unlock()
+		o.releasePort(name)
+		return
+	}
+	p.stopping = true
+	delete(o.procs, name)
+	o.mu.Unlock()
+
+	waitExit := func() bool {
+		timer := time.NewTimer(o.opts.StopTimeout)
+		defer timer.Stop()
+		select {
+		case <-p.exited:
+			return true
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		return false
+	}
+
+	exited := false
+	select {
+	case <-p.exited:
+		exited = true
+	default:
+		client := agent.NewClientWithOptions(fmt.Sprintf("http://%s:%d", localHost, p.port), agent.ClientOptions{AuthToken: p.token})
+		shutdownCtx, cancel := context.WithTimeout(ctx, o.opts.StopTimeout)
+		_, err := client.Shutdown(shutdownCtx, connect.NewRequest(&agentv1.ShutdownRequest{Graceful: true}))
+		cancel()
+		if err != nil {
+			o.logger.Debug("local agent did not take the shutdown request", zap.String("pod", name), zap.Error(err))
+		} else {
+			exited = waitExit()
+		}
+	}
+	if !exited {
+		if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil && !stderrors.Is(err, os.ErrProcessDone) {
+			o.logger.Warn("failed to send SIGTERM to local agent", zap.String("pod", name), zap.Error(err))
+		}
+		exited = waitExit()
+	}
+	if !exited {
+		o.logger.Warn("local agent did not exit after SIGTERM, killing it", zap.String("pod", name))
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+	}
+
+	_ = os.RemoveAll(p.dir)
+	o.releasePort(name)
+	o.logger.Info("stopped local agent", zap.String("pod", name))
+}
+
+// NewManagerWithOrchestrator creates a Manager running agents as bare pods on orchestrator,
+// in namespace
+func NewManagerWithOrchestrator(orchestrator Orchestrator, namespace, agentImage string) *Manager {
+	return NewManagerWithClientset(orchestrator.Clientset(), namespace, agentImage, orchestrator.NodeHost())
+}
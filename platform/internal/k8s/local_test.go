@@ -0,0 +1,263 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	corev1 "k8s.io/api/core/v1"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+)
+
+// Env vars making the test binary run as a local agent (see runLocalTestAgent)
+const (
+	// localTestAgentEnv is how the agent stops: "shutdown" exits on the Shutdown RPC,
+	// "sigterm" refuses it and exits on SIGTERM, "sigkill" also ignores SIGTERM
+	localTestAgentEnv = "FORGE_LOCAL_TEST_AGENT"
+	// localTestMarkerEnv names a file the agent writes how it was stopped to
+	localTestMarkerEnv = "FORGE_LOCAL_TEST_MARKER"
+)
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(localTestAgentEnv); mode != "" {
+		runLocalTestAgent(mode)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// localTestAgent is the agent the test binary runs as, answering only Shutdown
+type localTestAgent struct {
+	agentv1connect.UnimplementedAgentServiceHandler
+	mode string
+}
+
+func (a *localTestAgent) Shutdown(context.Context, *connect.Request[agentv1.ShutdownRequest]) (*connect.Response[agentv1.ShutdownResponse], error) {
+	if a.mode != "shutdown" {
+		return nil, connect.NewError(connect.CodeUnimplemented, nil)
+	}
+	markLocalTestAgent("shutdown")
+	// Exit once the response is written
+	time.AfterFunc(10*time.Millisecond, func() { os.Exit(0) })
+	return connect.NewResponse(&agentv1.ShutdownResponse{}), nil
+}
+
+// runLocalTestAgent serves the agent on $PORT until it is stopped as mode says
+func runLocalTestAgent(mode string) {
+	switch mode {
+	case "sigterm":
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+		go func() {
+			<-signals
+			markLocalTestAgent("sigterm")
+			os.Exit(0)
+		}()
+	case "sigkill":
+		signal.Ignore(syscall.SIGTERM)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(agentv1connect.NewAgentServiceHandler(&localTestAgent{mode: mode}))
+	server := &http.Server{Addr: "127.0.0.1:" + os.Getenv("PORT"), Handler: h2c.NewHandler(mux, &http2.Server{})}
+	_ = server.ListenAndServe()
+	os.Exit(1)
+}
+
+// markLocalTestAgent writes how the agent was stopped to the marker file
+func markLocalTestAgent(how string) {
+	_ = os.WriteFile(os.Getenv(localTestMarkerEnv), []byte(how), 0o600)
+}
+
+// freePort returns a port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startLocalManager returns a manager running agents as the test binary in mode, on the
+// ports first to last, and the file the agents mark how they were stopped in
+func startLocalManager(t *testing.T, mode string, first, last int) (*Manager, *LocalOrchestrator, string) {
+	t.Helper()
+	marker := filepath.Join(t.TempDir(), "stopped")
+	t.Setenv(localTestAgentEnv, mode)
+	t.Setenv(localTestMarkerEnv, marker)
+	o, err := NewLocalOrchestrator(LocalOrchestratorOpts{
+		Binary:      os.Args[0],
+		FirstPort:   first,
+		LastPort:    last,
+		Namespace:   "test-ns",
+		StopTimeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = o.Stop(context.Background()) })
+	return NewManagerWithOrchestrator(o, "test-ns", "test-image:latest"), o, marker
+}
+
+// createReadyLocalAgent creates podID's agent and waits for it to listen
+func createReadyLocalAgent(t *testing.T, m *Manager, podID PodID) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.CreatePod(ctx, podID); err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	if _, err := m.WaitForPodReady(ctx, podID); err != nil {
+		t.Fatalf("agent did not become ready: %v", err)
+	}
+	address, err := m.GetPodAddress(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+// waitForPod waits for podID's pod to satisfy done, failing the test if it does not soon
+func waitForPod(t *testing.T, m *Manager, podID PodID, done func(pod *corev1.Pod, err error) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if done(m.GetPod(context.Background(), podID)) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the agent pod")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if first, last, err := ParsePortRange("9100-9199"); err != nil || first != 9100 || last != 9199 {
+		t.Errorf("expected 9100-9199, got %d-%d, %v", first, last, err)
+	}
+	for _, s := range []string{"9100", "9199-9100", "0-10", "9100-70000", "a-b"} {
+		if _, _, err := ParsePortRange(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestLocalOrchestrator_ReportsKilledAgentFailed(t *testing.T) {
+	port := freePort(t)
+	m, o, _ := startLocalManager(t, "shutdown", port, port)
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+
+	address := createReadyLocalAgent(t, m, podID)
+	if want := "http://127.0.0.1:" + strconv.Itoa(port); address != want {
+		t.Errorf("expected the agent at %s, got %s", want, address)
+	}
+
+	o.mu.Lock()
+	p := o.procs[podID.Name()]
+	o.mu.Unlock()
+	if err := p.cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed *corev1.Pod
+	waitForPod(t, m, podID, func(pod *corev1.Pod, err error) bool {
+		failed = pod
+		return err == nil && pod.Status.Phase == corev1.PodFailed
+	})
+	if IsPodReady(failed) {
+		t.Error("expected the killed agent not to be ready")
+	}
+	reason, stuck := UnreadyReason(failed)
+	if reason != "Error" || !stuck {
+		t.Errorf("expected the agent to be stuck in Error, got %q, %v", reason, stuck)
+	}
+	if msg := failed.Status.Message; !strings.Contains(msg, "killed") {
+		t.Errorf("expected the failure to say the agent was killed, got %q", msg)
+	}
+	if port := o.podPort(podID.Name()); port != 0 {
+		t.Errorf("expected the killed agent's port to be freed, got %d", port)
+	}
+}
+
+func TestLocalOrchestrator_StopsDeletedAgent(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string // what the agent marked, empty if it was killed
+	}{
+		{"shutdown", "shutdown"},
+		{"sigterm", "sigterm"},
+		{"sigkill", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			port := freePort(t)
+			m, o, marker := startLocalManager(t, tt.mode, port, port)
+			podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+			createReadyLocalAgent(t, m, podID)
+			o.mu.Lock()
+			p := o.procs[podID.Name()]
+			o.mu.Unlock()
+
+			if err := m.ClosePod(context.Background(), podID); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-p.exited:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the agent to exit")
+			}
+			marked, _ := os.ReadFile(marker)
+			if string(marked) != tt.want {
+				t.Errorf("expected the agent to be stopped by %q, got %q", tt.want, marked)
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for o.podPort(podID.Name()) != 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("expected the agent's port to be freed")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestLocalOrchestrator_SkipsPortsInUse(t *testing.T) {
+	// Two consecutive ports, the first of them taken by something else
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	first := taken.Addr().(*net.TCPAddr).Port
+	if first == 65535 || !portFree(first+1) {
+		t.Skip("no free port next to the taken one")
+	}
+	m, _, _ := startLocalManager(t, "shutdown", first, first+1)
+
+	address := createReadyLocalAgent(t, m, PodID{UserID: "user-1", AgentID: "agent-1"})
+	if want := "http://127.0.0.1:" + strconv.Itoa(first+1); address != want {
+		t.Errorf("expected the agent on the free port %s, got %s", want, address)
+	}
+	err = m.CreatePod(context.Background(), PodID{UserID: "user-1", AgentID: "agent-2"})
+	if err == nil || !strings.Contains(err.Error(), "no free port") {
+		t.Errorf("expected the creation to fail for want of a port, got %v", err)
+	}
+}
@@ -0,0 +1,735 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// Limits of the in-memory API
+const (
+	// memoryHistory is how many changes the in-memory API keeps for watches resuming from
+	// an older resourceVersion; older ones get a "too old resource version" error and re-list
+	memoryHistory = 1000
+	// memoryWatchBuffer is how many changes a watch may fall behind before it is closed, as
+	// the API server closes watchers that do not keep up
+	memoryWatchBuffer = 100
+)
+
+// memoryObject is an object stored by the in-memory API
+type memoryObject interface {
+	runtime.Object
+	metav1.Object
+}
+
+// memoryKey identifies an object of the in-memory API
+type memoryKey struct {
+	resource  string
+	namespace string
+	name      string
+}
+
+// memoryChange is a change to an object of the in-memory API, at resourceVersion version
+type memoryChange struct {
+	resource string
+	version  uint64
+	event    watch.Event
+}
+
+// memoryAPI keeps Kubernetes objects in memory and serves them with the semantics the
+// Manager relies on: resourceVersions, label and field selectors, merge patches, and
+// watches that resume from a resourceVersion. It backs the clientset of the local
+// orchestrator (see newMemoryClientset).
+type memoryAPI struct {
+	// admit, if set, is called with every object being created and fails its creation by
+	// returning an error. It is called with mu held.
+	admit func(resource string, obj memoryObject) error
+	// observe, if set, is called with every change in order, with mu held, so it must not
+	// block or call the API
+	observe func(change memoryChange)
+
+	mu       sync.Mutex
+	objects  map[memoryKey]memoryObject
+	version  uint64
+	history  []memoryChange
+	watchers map[*memoryWatcher]struct{}
+}
+
+func newMemoryAPI() *memoryAPI {
+	return &memoryAPI{
+		objects:  make(map[memoryKey]memoryObject),
+		watchers: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+// groupResource returns the group resource of resource, for errors
+func groupResource(resource string) schema.GroupResource {
+	return schema.GroupResource{Resource: resource}
+}
+
+// create stores obj, failing if an object of the same name exists or admit refuses it
+func (a *memoryAPI) create(resource, namespace string, obj memoryObject) (memoryObject, error) {
+	obj = obj.DeepCopyObject().(memoryObject)
+	if obj.GetName() == "" {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("%s must have a name", resource))
+	}
+	obj.SetNamespace(namespace)
+	key := memoryKey{resource, namespace, obj.GetName()}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.objects[key]; ok {
+		return nil, apierrors.NewAlreadyExists(groupResource(resource), obj.GetName())
+	}
+	if a.admit != nil {
+		if err := a.admit(resource, obj); err != nil {
+			return nil, err
+		}
+	}
+	obj.SetUID(uuid.NewUUID())
+	obj.SetCreationTimestamp(metav1.Now())
+	a.store(key, obj, watch.Added)
+	return obj.DeepCopyObject().(memoryObject), nil
+}
+
+// get returns the named object
+func (a *memoryAPI) get(resource, namespace, name string) (memoryObject, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	obj, ok := a.objects[memoryKey{resource, namespace, name}]
+	if !ok {
+		return nil, apierrors.NewNotFound(groupResource(resource), name)
+	}
+	return obj.DeepCopyObject().(memoryObject), nil
+}
+
+// list returns the objects matching opts, and the resourceVersion they were listed at
+func (a *memoryAPI) list(resource, namespace string, opts metav1.ListOptions) ([]memoryObject, string, error) {
+	matches, err := newMemoryMatcher(resource, namespace, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var objects []memoryObject
+	for key, obj := range a.objects {
+		if key.resource == resource && matches(obj) {
+			objects = append(objects, obj.DeepCopyObject().(memoryObject))
+		}
+	}
+	return objects, strconv.FormatUint(a.version, 10), nil
+}
+
+// update applies change to the named object and stores the result. change reports whether
+// it changed the object; an unchanged object is not stored again.
+func (a *memoryAPI) update(resource, namespace, name string, change func(obj memoryObject) (bool, error)) (memoryObject, error) {
+	key := memoryKey{resource, namespace, name}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stored, ok := a.objects[key]
+	if !ok {
+		return nil, apierrors.NewNotFound(groupResource(resource), name)
+	}
+	obj := stored.DeepCopyObject().(memoryObject)
+	changed, err := change(obj)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return obj, nil
+	}
+	// The identity of an object does not change
+	obj.SetName(stored.GetName())
+	obj.SetNamespace(stored.GetNamespace())
+	obj.SetUID(stored.GetUID())
+	obj.SetCreationTimestamp(stored.GetCreationTimestamp())
+	a.store(key, obj, watch.Modified)
+	return obj.DeepCopyObject().(memoryObject), nil
+}
+
+// replace stores obj over the object of the same name, failing on a conflict if obj has a
+// resourceVersion other than the stored one. With status set only the status is replaced,
+// and otherwise everything but it.
+func (a *memoryAPI) replace(resource, namespace string, obj memoryObject, status bool) (memoryObject, error) {
+	return a.update(resource, namespace, obj.GetName(), func(stored memoryObject) (bool, error) {
+		if v := obj.GetResourceVersion(); v != "" && v != stored.GetResourceVersion() {
+			return false, apierrors.NewConflict(groupResource(resource), obj.GetName(),
+				fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+		}
+		replacement := reflect.ValueOf(obj.DeepCopyObject()).Elem()
+		target := reflect.ValueOf(stored).Elem()
+		if f := replacement.FieldByName("Status"); f.IsValid() {
+			if status {
+				target.FieldByName("Status").Set(f)
+				return true, nil
+			}
+			f.Set(target.FieldByName("Status"))
+		} else if status {
+			return false, apierrors.NewMethodNotSupported(groupResource(resource), "update status")
+		}
+		target.Set(replacement)
+		return true, nil
+	})
+}
+
+// patch applies a JSON merge patch to the named object
+func (a *memoryAPI) patch(resource, namespace, name string, pt types.PatchType, data []byte) (memoryObject, error) {
+	if pt != types.MergePatchType {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("the in-memory API only applies %s patches, not %s", types.MergePatchType, pt))
+	}
+	var patch any
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid patch: %v", err))
+	}
+	return a.update(resource, namespace, name, func(obj memoryObject) (bool, error) {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return false, err
+		}
+		var doc any
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return false, err
+		}
+		if raw, err = json.Marshal(mergePatch(doc, patch)); err != nil {
+			return false, err
+		}
+		// Fields the patch removed must not survive from obj
+		target := reflect.ValueOf(obj).Elem()
+		target.Set(reflect.Zero(target.Type()))
+		if err := json.Unmarshal(raw, obj); err != nil {
+			return false, apierrors.NewBadRequest(fmt.Sprintf("invalid patch: %v", err))
+		}
+		return true, nil
+	})
+}
+
+// mergePatch applies patch to doc as a JSON merge patch (RFC 7386)
+func mergePatch(doc, patch any) any {
+	fields, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	target, ok := doc.(map[string]any)
+	if !ok {
+		target = make(map[string]any)
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(target, name)
+			continue
+		}
+		target[name] = mergePatch(target[name], value)
+	}
+	return target
+}
+
+// delete removes the named object
+func (a *memoryAPI) delete(resource, namespace, name string) error {
+	key := memoryKey{resource, namespace, name}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	obj, ok := a.objects[key]
+	if !ok {
+		return apierrors.NewNotFound(groupResource(resource), name)
+	}
+	a.remove(key, obj)
+	return nil
+}
+
+// deleteCollection removes the objects matching opts
+func (a *memoryAPI) deleteCollection(resource, namespace string, opts metav1.ListOptions) error {
+	matches, err := newMemoryMatcher(resource, namespace, opts)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, obj := range a.objects {
+		if key.resource == resource && matches(obj) {
+			a.remove(key, obj)
+		}
+	}
+	return nil
+}
+
+// store stores obj at the next resourceVersion and reports the change. mu must be held.
+func (a *memoryAPI) store(key memoryKey, obj memoryObject, eventType watch.EventType) {
+	a.version++
+	obj.SetResourceVersion(strconv.FormatUint(a.version, 10))
+	a.objects[key] = obj
+	a.changed(key.resource, eventType, obj)
+}
+
+// remove removes the object at key and reports its deletion. mu must be held.
+func (a *memoryAPI) remove(key memoryKey, obj memoryObject) {
+	delete(a.objects, key)
+	a.version++
+	obj = obj.DeepCopyObject().(memoryObject)
+	obj.SetResourceVersion(strconv.FormatUint(a.version, 10))
+	a.changed(key.resource, watch.Deleted, obj)
+}
+
+// changed records a change for resuming watches and passes it to the watches and observer.
+// mu must be held.
+func (a *memoryAPI) changed(resource string, eventType watch.EventType, obj memoryObject) {
+	change := memoryChange{
+		resource: resource,
+		version:  a.version,
+		event:    watch.Event{Type: eventType, Object: obj},
+	}
+	a.history = append(a.history, change)
+	if len(a.history) > memoryHistory {
+		a.history = a.history[len(a.history)-memoryHistory:]
+	}
+	for w := range a.watchers {
+		w.send(change)
+	}
+	if a.observe != nil {
+		a.observe(change)
+	}
+}
+
+// watch watches the objects matching opts. Without a resourceVersion, or with "0", it
+// starts with an add of each of them; with one, it starts with the changes made since.
+func (a *memoryAPI) watch(ctx context.Context, resource, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	matches, err := newMemoryMatcher(resource, namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var initial []watch.Event
+	switch opts.ResourceVersion {
+	case "", "0":
+		for key, obj := range a.objects {
+			if key.resource == resource && matches(obj) {
+				initial = append(initial, watch.Event{Type: watch.Added, Object: obj.DeepCopyObject()})
+			}
+		}
+	default:
+		since, err := strconv.ParseUint(opts.ResourceVersion, 10, 64)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid resourceVersion %q", opts.ResourceVersion))
+		}
+		if len(a.history) > 0 && a.history[0].version > since+1 {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", since, a.history[0].version-1))
+		}
+		for _, change := range a.history {
+			if change.version > since && change.resource == resource && matches(change.event.Object.(memoryObject)) {
+				initial = append(initial, watch.Event{Type: change.event.Type, Object: change.event.Object.DeepCopyObject()})
+			}
+		}
+	}
+
+	w := &memoryWatcher{
+		api:      a,
+		resource: resource,
+		matches:  matches,
+		result:   make(chan watch.Event, len(initial)+memoryWatchBuffer),
+		done:     make(chan struct{}),
+	}
+	for _, event := range initial {
+		w.result <- event
+	}
+	a.watchers[w] = struct{}{}
+
+	go w.expire(ctx, opts.TimeoutSeconds)
+	return w, nil
+}
+
+// memoryWatcher is a watch of the in-memory API
+type memoryWatcher struct {
+	api      *memoryAPI
+	resource string
+	matches  func(obj memoryObject) bool
+	result   chan watch.Event
+	// done is closed once the watch is stopped, with result; both under api.mu
+	done chan struct{}
+}
+
+// send passes change to the watch if it matches. A watch too far behind is closed, as its
+// client re-watches from the last change it got. api.mu must be held.
+func (w *memoryWatcher) send(change memoryChange) {
+	if change.resource != w.resource || !w.matches(change.event.Object.(memoryObject)) {
+		return
+	}
+	select {
+	case w.result <- watch.Event{Type: change.event.Type, Object: change.event.Object.DeepCopyObject()}:
+	default:
+		w.close()
+	}
+}
+
+// close ends the watch. api.mu must be held.
+func (w *memoryWatcher) close() {
+	if _, ok := w.api.watchers[w]; !ok {
+		return
+	}
+	delete(w.api.watchers, w)
+	close(w.result)
+	close(w.done)
+}
+
+// expire stops the watch once ctx is done or, if set, its timeout has passed
+func (w *memoryWatcher) expire(ctx context.Context, timeoutSeconds *int64) {
+	var timeout <-chan time.Time
+	if timeoutSeconds != nil {
+		timer := time.NewTimer(time.Duration(*timeoutSeconds) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ctx.Done():
+	case <-timeout:
+	case <-w.done:
+		return
+	}
+	w.Stop()
+}
+
+// Stop ends the watch
+func (w *memoryWatcher) Stop() {
+	w.api.mu.Lock()
+	defer w.api.mu.Unlock()
+	w.close()
+}
+
+// ResultChan returns the changes watched, closed once the watch ends
+func (w *memoryWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// newMemoryMatcher returns whether an object of resource is in namespace and matches the
+// label and field selectors of opts
+func newMemoryMatcher(resource, namespace string, opts metav1.ListOptions) (func(obj memoryObject) bool, error) {
+	labelSelector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid label selector: %v", err))
+	}
+	fieldSelector, err := fields.ParseSelector(opts.FieldSelector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid field selector: %v", err))
+	}
+	return func(obj memoryObject) bool {
+		return (namespace == "" || obj.GetNamespace() == namespace) &&
+			labelSelector.Matches(labels.Set(obj.GetLabels())) &&
+			fieldSelector.Matches(objectFields(obj))
+	}, nil
+}
+
+// objectFields returns the fields of obj that field selectors can select on
+func objectFields(obj memoryObject) fields.Set {
+	set := fields.Set{
+		"metadata.name":      obj.GetName(),
+		"metadata.namespace": obj.GetNamespace(),
+	}
+	switch obj := obj.(type) {
+	case *corev1.Pod:
+		set["status.phase"] = string(obj.Status.Phase)
+		set["spec.nodeName"] = obj.Spec.NodeName
+	case *corev1.Event:
+		set["involvedObject.kind"] = obj.InvolvedObject.Kind
+		set["involvedObject.name"] = obj.InvolvedObject.Name
+		set["involvedObject.namespace"] = obj.InvolvedObject.Namespace
+	}
+	return set
+}
+
+// memoryResource serves a resource of the in-memory API in a namespace, as objects of type T
+type memoryResource[T any, PT interface {
+	*T
+	memoryObject
+}] struct {
+	api       *memoryAPI
+	resource  string
+	namespace string
+}
+
+func (r memoryResource[T, PT]) create(obj PT) (PT, error) {
+	created, err := r.api.create(r.resource, r.namespace, obj)
+	if err != nil {
+		return nil, err
+	}
+	return created.(PT), nil
+}
+
+func (r memoryResource[T, PT]) get(name string) (PT, error) {
+	obj, err := r.api.get(r.resource, r.namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(PT), nil
+}
+
+// list returns the objects matching opts and the resourceVersion of the list
+func (r memoryResource[T, PT]) list(opts metav1.ListOptions) ([]T, metav1.ListMeta, error) {
+	objects, version, err := r.api.list(r.resource, r.namespace, opts)
+	if err != nil {
+		return nil, metav1.ListMeta{}, err
+	}
+	items := make([]T, 0, len(objects))
+	for _, obj := range objects {
+		items = append(items, *obj.(PT))
+	}
+	return items, metav1.ListMeta{ResourceVersion: version}, nil
+}
+
+func (r memoryResource[T, PT]) replace(obj PT, status bool) (PT, error) {
+	replaced, err := r.api.replace(r.resource, r.namespace, obj, status)
+	if err != nil {
+		return nil, err
+	}
+	return replaced.(PT), nil
+}
+
+func (r memoryResource[T, PT]) patch(name string, pt types.PatchType, data []byte) (PT, error) {
+	patched, err := r.api.patch(r.resource, r.namespace, name, pt, data)
+	if err != nil {
+		return nil, err
+	}
+	return patched.(PT), nil
+}
+
+// newMemoryClientset returns a clientset of api. It serves the resources the Manager
+// manages agents with on api, allows every access review, and fails every other request.
+func newMemoryClientset(api *memoryAPI) kubernetes.Interface {
+	unserved, err := kubernetes.NewForConfigAndClient(&rest.Config{Host: "http://in-memory.invalid"}, &http.Client{Transport: unservedTransport{}})
+	if err != nil {
+		// The config is valid
+		panic(err)
+	}
+	return &memoryClientset{Interface: unserved, api: api}
+}
+
+// unservedTransport fails the requests of the API the in-memory clientset does not serve
+type unservedTransport struct{}
+
+func (unservedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("%s %s is not served by the in-memory API of the local orchestrator", req.Method, req.URL.Path)
+}
+
+// memoryClientset is the clientset of a memoryAPI (see newMemoryClientset)
+type memoryClientset struct {
+	kubernetes.Interface
+	api *memoryAPI
+}
+
+func (c *memoryClientset) CoreV1() corev1client.CoreV1Interface {
+	return memoryCoreV1{CoreV1Interface: c.Interface.CoreV1(), api: c.api}
+}
+
+func (c *memoryClientset) AuthorizationV1() authorizationv1client.AuthorizationV1Interface {
+	return memoryAuthorizationV1{AuthorizationV1Interface: c.Interface.AuthorizationV1()}
+}
+
+type memoryCoreV1 struct {
+	corev1client.CoreV1Interface
+	api *memoryAPI
+}
+
+func (v memoryCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return memoryPods{v.CoreV1Interface.Pods(namespace), memoryResource[corev1.Pod, *corev1.Pod]{v.api, "pods", namespace}}
+}
+
+func (v memoryCoreV1) Services(namespace string) corev1client.ServiceInterface {
+	return memoryServices{v.CoreV1Interface.Services(namespace), memoryResource[corev1.Service, *corev1.Service]{v.api, "services", namespace}}
+}
+
+func (v memoryCoreV1) ConfigMaps(namespace string) corev1client.ConfigMapInterface {
+	return memoryConfigMaps{v.CoreV1Interface.ConfigMaps(namespace), memoryResource[corev1.ConfigMap, *corev1.ConfigMap]{v.api, "configmaps", namespace}}
+}
+
+// PersistentVolumeClaims serves claims that can be read and deleted but not created, as
+// local agents have no volumes
+func (v memoryCoreV1) PersistentVolumeClaims(namespace string) corev1client.PersistentVolumeClaimInterface {
+	return memoryClaims{v.CoreV1Interface.PersistentVolumeClaims(namespace), memoryResource[corev1.PersistentVolumeClaim, *corev1.PersistentVolumeClaim]{v.api, "persistentvolumeclaims", namespace}}
+}
+
+// Secrets serves no secrets, as local agents get their env from the platform's
+func (v memoryCoreV1) Secrets(namespace string) corev1client.SecretInterface {
+	return memorySecrets{v.CoreV1Interface.Secrets(namespace), memoryResource[corev1.Secret, *corev1.Secret]{v.api, "secrets", namespace}}
+}
+
+// Events serves no events, as nothing reports them for local agents
+func (v memoryCoreV1) Events(namespace string) corev1client.EventInterface {
+	return memoryEvents{v.CoreV1Interface.Events(namespace), memoryResource[corev1.Event, *corev1.Event]{v.api, "events", namespace}}
+}
+
+type memoryPods struct {
+	corev1client.PodInterface
+	objects memoryResource[corev1.Pod, *corev1.Pod]
+}
+
+func (p memoryPods) Create(_ context.Context, pod *corev1.Pod, _ metav1.CreateOptions) (*corev1.Pod, error) {
+	return p.objects.create(pod)
+}
+
+func (p memoryPods) Get(_ context.Context, name string, _ metav1.GetOptions) (*corev1.Pod, error) {
+	return p.objects.get(name)
+}
+
+func (p memoryPods) List(_ context.Context, opts metav1.ListOptions) (*corev1.PodList, error) {
+	items, meta, err := p.objects.list(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.PodList{ListMeta: meta, Items: items}, nil
+}
+
+func (p memoryPods) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return p.objects.api.watch(ctx, p.objects.resource, p.objects.namespace, opts)
+}
+
+func (p memoryPods) Update(_ context.Context, pod *corev1.Pod, _ metav1.UpdateOptions) (*corev1.Pod, error) {
+	return p.objects.replace(pod, false)
+}
+
+func (p memoryPods) UpdateStatus(_ context.Context, pod *corev1.Pod, _ metav1.UpdateOptions) (*corev1.Pod, error) {
+	return p.objects.replace(pod, true)
+}
+
+func (p memoryPods) Patch(_ context.Context, name string, pt types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Pod, error) {
+	return p.objects.patch(name, pt, data)
+}
+
+func (p memoryPods) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	return p.objects.api.delete(p.objects.resource, p.objects.namespace, name)
+}
+
+func (p memoryPods) DeleteCollection(_ context.Context, _ metav1.DeleteOptions, opts metav1.ListOptions) error {
+	return p.objects.api.deleteCollection(p.objects.resource, p.objects.namespace, opts)
+}
+
+type memoryServices struct {
+	corev1client.ServiceInterface
+	objects memoryResource[corev1.Service, *corev1.Service]
+}
+
+func (s memoryServices) Create(_ context.Context, svc *corev1.Service, _ metav1.CreateOptions) (*corev1.Service, error) {
+	return s.objects.create(svc)
+}
+
+func (s memoryServices) Get(_ context.Context, name string, _ metav1.GetOptions) (*corev1.Service, error) {
+	return s.objects.get(name)
+}
+
+func (s memoryServices) List(_ context.Context, opts metav1.ListOptions) (*corev1.ServiceList, error) {
+	items, meta, err := s.objects.list(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.ServiceList{ListMeta: meta, Items: items}, nil
+}
+
+func (s memoryServices) Patch(_ context.Context, name string, pt types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Service, error) {
+	return s.objects.patch(name, pt, data)
+}
+
+func (s memoryServices) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	return s.objects.api.delete(s.objects.resource, s.objects.namespace, name)
+}
+
+type memoryConfigMaps struct {
+	corev1client.ConfigMapInterface
+	objects memoryResource[corev1.ConfigMap, *corev1.ConfigMap]
+}
+
+func (c memoryConfigMaps) Create(_ context.Context, cm *corev1.ConfigMap, _ metav1.CreateOptions) (*corev1.ConfigMap, error) {
+	return c.objects.create(cm)
+}
+
+func (c memoryConfigMaps) Get(_ context.Context, name string, _ metav1.GetOptions) (*corev1.ConfigMap, error) {
+	return c.objects.get(name)
+}
+
+func (c memoryConfigMaps) Update(_ context.Context, cm *corev1.ConfigMap, _ metav1.UpdateOptions) (*corev1.ConfigMap, error) {
+	return c.objects.replace(cm, false)
+}
+
+func (c memoryConfigMaps) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	return c.objects.api.delete(c.objects.resource, c.objects.namespace, name)
+}
+
+type memoryClaims struct {
+	corev1client.PersistentVolumeClaimInterface
+	objects memoryResource[corev1.PersistentVolumeClaim, *corev1.PersistentVolumeClaim]
+}
+
+func (c memoryClaims) Get(_ context.Context, name string, _ metav1.GetOptions) (*corev1.PersistentVolumeClaim, error) {
+	return c.objects.get(name)
+}
+
+func (c memoryClaims) List(_ context.Context, opts metav1.ListOptions) (*corev1.PersistentVolumeClaimList, error) {
+	items, meta, err := c.objects.list(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.PersistentVolumeClaimList{ListMeta: meta, Items: items}, nil
+}
+
+func (c memoryClaims) Delete(_ context.Context, name string, _ metav1.DeleteOptions) error {
+	return c.objects.api.delete(c.objects.resource, c.objects.namespace, name)
+}
+
+type memorySecrets struct {
+	corev1client.SecretInterface
+	objects memoryResource[corev1.Secret, *corev1.Secret]
+}
+
+func (s memorySecrets) Get(_ context.Context, name string, _ metav1.GetOptions) (*corev1.Secret, error) {
+	return s.objects.get(name)
+}
+
+type memoryEvents struct {
+	corev1client.EventInterface
+	objects memoryResource[corev1.Event, *corev1.Event]
+}
+
+func (e memoryEvents) List(_ context.Context, opts metav1.ListOptions) (*corev1.EventList, error) {
+	items, meta, err := e.objects.list(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.EventList{ListMeta: meta, Items: items}, nil
+}
+
+type memoryAuthorizationV1 struct {
+	authorizationv1client.AuthorizationV1Interface
+}
+
+func (v memoryAuthorizationV1) SelfSubjectAccessReviews() authorizationv1client.SelfSubjectAccessReviewInterface {
+	return memoryAccessReviews{v.AuthorizationV1Interface.SelfSubjectAccessReviews()}
+}
+
+// memoryAccessReviews allows everything: the platform owns the in-memory API
+type memoryAccessReviews struct {
+	authorizationv1client.SelfSubjectAccessReviewInterface
+}
+
+func (memoryAccessReviews) Create(_ context.Context, review *authorizationv1.SelfSubjectAccessReview, _ metav1.CreateOptions) (*authorizationv1.SelfSubjectAccessReview, error) {
+	review = review.DeepCopy()
+	review.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: true}
+	return review, nil
+}
@@ -0,0 +1,204 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func memoryPod(name string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// receive returns the next event of w, failing the test if there is none
+func receive(t *testing.T, w watch.Interface) watch.Event {
+	t.Helper()
+	select {
+	case event, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatal("expected an event, the watch ended")
+		}
+		return event
+	default:
+		t.Fatal("expected an event, got none")
+	}
+	return watch.Event{}
+}
+
+func TestMemoryAPI_WatchResumesFromResourceVersion(t *testing.T) {
+	ctx := context.Background()
+	pods := newMemoryClientset(newMemoryAPI()).CoreV1().Pods("test-ns")
+	if _, err := pods.Create(ctx, memoryPod("a", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := pods.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pods.Create(ctx, memoryPod("b", nil), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pods.Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := pods.Watch(ctx, metav1.ListOptions{ResourceVersion: list.ResourceVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if event := receive(t, w); event.Type != watch.Added || event.Object.(*corev1.Pod).Name != "b" {
+		t.Errorf("expected b to be added, got %s %s", event.Type, event.Object.(*corev1.Pod).Name)
+	}
+	if event := receive(t, w); event.Type != watch.Deleted || event.Object.(*corev1.Pod).Name != "a" {
+		t.Errorf("expected a to be deleted, got %s %s", event.Type, event.Object.(*corev1.Pod).Name)
+	}
+}
+
+func TestMemoryAPI_WatchFromExpiredResourceVersion(t *testing.T) {
+	ctx := context.Background()
+	pods := newMemoryClientset(newMemoryAPI()).CoreV1().Pods("test-ns")
+	for range memoryHistory + 1 {
+		if _, err := pods.Create(ctx, memoryPod("a", nil), metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := pods.Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := pods.Watch(ctx, metav1.ListOptions{ResourceVersion: "1"})
+	if !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected the resourceVersion to have expired, got %v", err)
+	}
+}
+
+func TestMemoryAPI_ClosesWatchFallingBehind(t *testing.T) {
+	ctx := context.Background()
+	pods := newMemoryClientset(newMemoryAPI()).CoreV1().Pods("test-ns")
+	w, err := pods.Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing reads the watch while more changes are made than it buffers
+	for range memoryWatchBuffer + 1 {
+		if _, err := pods.Create(ctx, memoryPod("a", nil), metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := pods.Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	received := 0
+	for range w.ResultChan() {
+		received++
+	}
+	if received != memoryWatchBuffer {
+		t.Errorf("expected the %d buffered changes before the watch closed, got %d", memoryWatchBuffer, received)
+	}
+	w.Stop()
+}
+
+func TestMemoryAPI_Selectors(t *testing.T) {
+	ctx := context.Background()
+	clientset := newMemoryClientset(newMemoryAPI())
+	pods := clientset.CoreV1().Pods("test-ns")
+	for _, pod := range []*corev1.Pod{
+		memoryPod("a", map[string]string{"app": "agent"}),
+		memoryPod("b", map[string]string{"app": "agent"}),
+		memoryPod("c", map[string]string{"app": "other"}),
+	} {
+		if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := pods.Get(ctx, "a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Status.Phase = corev1.PodRunning
+	if _, err := pods.UpdateStatus(ctx, a, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts metav1.ListOptions
+		want int
+	}{
+		{"label", metav1.ListOptions{LabelSelector: "app=agent"}, 2},
+		{"field", metav1.ListOptions{FieldSelector: "status.phase=Running"}, 1},
+		{"both", metav1.ListOptions{LabelSelector: "app=agent", FieldSelector: "metadata.name!=a"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := pods.List(ctx, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Items) != tt.want {
+				t.Errorf("expected %d pods, got %d", tt.want, len(list.Items))
+			}
+		})
+	}
+	if _, err := clientset.CoreV1().Pods("other-ns").Get(ctx, "a", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected pods of other namespaces not to be found, got %v", err)
+	}
+}
+
+func TestMemoryAPI_UpdateConflicts(t *testing.T) {
+	ctx := context.Background()
+	pods := newMemoryClientset(newMemoryAPI()).CoreV1().Pods("test-ns")
+	created, err := pods.Create(ctx, memoryPod("a", nil), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Labels = map[string]string{"app": "agent"}
+	if _, err := pods.Update(ctx, created, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pods.Update(ctx, created, metav1.UpdateOptions{}); !apierrors.IsConflict(err) {
+		t.Errorf("expected updating a stale pod to conflict, got %v", err)
+	}
+}
+
+func TestMemoryAPI_MergePatch(t *testing.T) {
+	ctx := context.Background()
+	pods := newMemoryClientset(newMemoryAPI()).CoreV1().Pods("test-ns")
+	pod := memoryPod("a", map[string]string{"app": "agent"})
+	pod.Annotations = map[string]string{"keep": "1", "drop": "1"}
+	created, err := pods.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := pods.Patch(ctx, "a", types.MergePatchType,
+		[]byte(`{"metadata":{"annotations":{"drop":null,"add":"2"}}}`), metav1.PatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"keep": "1", "add": "2"}
+	if len(patched.Annotations) != len(want) || patched.Annotations["keep"] != "1" || patched.Annotations["add"] != "2" {
+		t.Errorf("expected annotations %v, got %v", want, patched.Annotations)
+	}
+	if patched.Labels["app"] != "agent" || patched.UID != created.UID {
+		t.Errorf("expected the patch to keep the rest of the pod, got %+v", patched.ObjectMeta)
+	}
+
+	if _, err := pods.Patch(ctx, "a", types.StrategicMergePatchType, []byte(`{}`), metav1.PatchOptions{}); !apierrors.IsBadRequest(err) {
+		t.Errorf("expected a strategic merge patch to be refused, got %v", err)
+	}
+}
+
+func TestMemoryAPI_FailsUnservedRequests(t *testing.T) {
+	_, err := newMemoryClientset(newMemoryAPI()).AppsV1().Deployments("test-ns").Get(context.Background(), "a", metav1.GetOptions{})
+	if err == nil || !strings.Contains(err.Error(), "not served by the in-memory API") {
+		t.Errorf("expected deployments not to be served, got %v", err)
+	}
+}
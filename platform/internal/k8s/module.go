@@ -1,9 +1,13 @@
 package k8s
 
 import (
+	"fmt"
+
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 
 	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/metrics"
 )
 
 // Module provides Kubernetes components to the fx container
@@ -12,12 +16,88 @@ var Module = fx.Module("k8s",
 	fx.Provide(newManager),
 )
 
-// newManager creates a new Manager using configuration from the fx container
-func newManager(cfg *config.Config, containerCfg *ContainerConfig) (*Manager, error) {
-	return NewManager(ManagerOpts{
-		KubeConfigPath: cfg.KubeConfigPath,
-		ContainerCfg:   *containerCfg,
-		AgentNamespace: cfg.AgentNamespace,
-		NodeHost:       cfg.NodeHost,
+// newManager creates a new Manager using configuration from the fx container. Its pod cache
+// runs with the application, as does the local orchestrator if ORCHESTRATOR is local.
+func newManager(lc fx.Lifecycle, cfg *config.Config, containerCfg *ContainerConfig, m *metrics.Metrics, logger *zap.Logger) (*Manager, error) {
+	orchestrator, err := parseOrchestrator(cfg.Orchestrator)
+	if err != nil {
+		return nil, err
+	}
+	if orchestrator == OrchestratorLocal {
+		return newLocalManager(lc, cfg, containerCfg, m, logger)
+	}
+
+	podTemplate, err := LoadPodTemplate(cfg.AgentPodTemplatePath, cfg.AgentPodTemplate)
+	if err != nil {
+		return nil, err
+	}
+	mgr, err := NewManager(ManagerOpts{
+		KubeConfigPath:      cfg.KubeConfigPath,
+		ContainerCfg:        *containerCfg,
+		AgentNamespace:      cfg.AgentNamespace,
+		NodeHost:            cfg.NodeHost,
+		MaxReadinessWatches: cfg.MaxReadinessWatches,
+		PodTemplate:         *podTemplate,
+		WorkloadKind:        cfg.AgentWorkloadKind,
+		WorkspaceMountPath:  cfg.AgentWorkspaceMountPath,
+		WatchRetryWindow:    cfg.AgentWatchRetryWindow,
+		CacheCheckInterval:  cfg.AgentPodCacheCheckInterval,
+		CacheStaleAfter:     cfg.AgentPodCacheStaleAfter,
+		AgentAuthSecret:     cfg.AgentAuthSecret,
+		QPS:                 cfg.KubeClientQPS,
+		Burst:               cfg.KubeClientBurst,
 	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("kubernetes client configured",
+		zap.String("mode", mgr.KubeConfigMode()),
+		zap.String("agent_namespace", mgr.AgentNamespace()))
+	mgr.metrics = m
+	mgr.logger = logger
+	registerCache(lc, mgr)
+	return mgr, nil
+}
+
+// newLocalManager creates a Manager running agents as child processes of the platform (see
+// LocalOrchestrator). The orchestrator starts before the pod cache and stops after it.
+func newLocalManager(lc fx.Lifecycle, cfg *config.Config, containerCfg *ContainerConfig, m *metrics.Metrics, logger *zap.Logger) (*Manager, error) {
+	if kind, err := parseWorkloadKind(cfg.AgentWorkloadKind); err != nil {
+		return nil, err
+	} else if kind != WorkloadKindPod {
+		return nil, fmt.Errorf("the local orchestrator runs agents as bare pods, not as %s", kind)
+	}
+	firstPort, lastPort, err := ParsePortRange(cfg.LocalAgentPorts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOCAL_AGENT_PORTS: %w", err)
+	}
+	namespace := cfg.AgentNamespace
+	if namespace == "" {
+		namespace = DefaultAgentNamespace
+	}
+	orchestrator, err := NewLocalOrchestrator(LocalOrchestratorOpts{
+		Binary:    cfg.LocalAgentBinary,
+		Args:      cfg.LocalAgentArgs,
+		FirstPort: firstPort,
+		LastPort:  lastPort,
+		Namespace: namespace,
+		Logger:    logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStart: orchestrator.Start,
+		OnStop:  orchestrator.Stop,
+	})
+
+	mgr := NewManagerWithOrchestrator(orchestrator, namespace, containerCfg.AgentImage())
+	mgr.agentAuthSecret = cfg.AgentAuthSecret
+	logger.Info("running agents as local processes",
+		zap.String("binary", cfg.LocalAgentBinary),
+		zap.String("ports", cfg.LocalAgentPorts))
+	mgr.metrics = m
+	mgr.logger = logger
+	registerCache(lc, mgr)
+	return mgr, nil
 }
@@ -0,0 +1,221 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/forge/platform/internal/agent"
+)
+
+// AgentContainerName is the name of the agent container in agent pods
+const AgentContainerName = "forge-agent"
+
+// PodTemplateConfig holds defaults merged into every agent pod, for scheduling and
+// metadata the platform does not manage itself. The platform's own labels, annotations and
+// agent container fields (name, image, ports and its env) cannot be overridden.
+type PodTemplateConfig struct {
+	Labels             map[string]string   `json:"labels,omitempty"`
+	Annotations        map[string]string   `json:"annotations,omitempty"`
+	NodeSelector       map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations        []corev1.Toleration `json:"tolerations,omitempty"`
+	Affinity           *corev1.Affinity    `json:"affinity,omitempty"`
+	ServiceAccountName string              `json:"serviceAccountName,omitempty"`
+	PriorityClassName  string              `json:"priorityClassName,omitempty"`
+	// Container holds defaults for the agent container
+	Container ContainerTemplate `json:"container,omitempty"`
+}
+
+// ContainerTemplate holds defaults for the agent container
+type ContainerTemplate struct {
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Env is added to the agent container's env
+	Env             []corev1.EnvVar         `json:"env,omitempty"`
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+}
+
+// managedLabels are the pod labels set by the platform
+var managedLabels = []string{"user-id", "agent-id", ManagedByLabel, ComponentLabel}
+
+// managedAnnotations are the pod annotations set by the platform
+var managedAnnotations = []string{ImageTagAnnotation, RolloutIDAnnotation, ProtocolVersionAnnotation, QuarantineAnnotation}
+
+// managedEnv are the agent container env vars set by the platform
+var managedEnv = []string{"AGENT_ID", "PORT", "AGENT_CWD", "ANTHROPIC_API_KEY", "OPENCODE_API_KEY", agent.AuthTokenEnv}
+
+// LoadPodTemplate loads a pod template from the YAML file at path, or else from the
+// inline YAML. With neither it returns an empty template. Unknown fields and invalid
+// templates are errors.
+func LoadPodTemplate(path, inline string) (*PodTemplateConfig, error) {
+	data := []byte(inline)
+	source := "inline pod template"
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading pod template: %w", err)
+		}
+		source = "pod template " + path
+	}
+
+	tmpl := &PodTemplateConfig{}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return tmpl, nil
+	}
+	if err := yaml.UnmarshalStrict(data, tmpl); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", source, err)
+	}
+	if err := tmpl.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", source, err)
+	}
+	return tmpl, nil
+}
+
+// Validate reports the first problem with the template: malformed keys, names or
+// tolerations, or fields the platform manages
+func (t *PodTemplateConfig) Validate() error {
+	if err := validateLabels("label", t.Labels, managedLabels); err != nil {
+		return err
+	}
+	if err := validateLabels("node selector", t.NodeSelector, nil); err != nil {
+		return err
+	}
+	for key := range t.Annotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("annotation %q: %s", key, strings.Join(errs, "; "))
+		}
+		if slices.Contains(managedAnnotations, key) {
+			return fmt.Errorf("annotation %q is managed by the platform", key)
+		}
+	}
+	for i, tol := range t.Tolerations {
+		if err := validateToleration(tol); err != nil {
+			return fmt.Errorf("toleration %d: %w", i, err)
+		}
+	}
+	for field, name := range map[string]string{
+		"serviceAccountName": t.ServiceAccountName,
+		"priorityClassName":  t.PriorityClassName,
+	} {
+		if name == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return fmt.Errorf("%s %q: %s", field, name, strings.Join(errs, "; "))
+		}
+	}
+	for _, e := range t.Container.Env {
+		if errs := validation.IsEnvVarName(e.Name); len(errs) > 0 {
+			return fmt.Errorf("container env %q: %s", e.Name, strings.Join(errs, "; "))
+		}
+		if slices.Contains(managedEnv, e.Name) {
+			return fmt.Errorf("container env %q is managed by the platform", e.Name)
+		}
+	}
+	return nil
+}
+
+// validateLabels checks label keys and values, and that none is managed
+func validateLabels(kind string, labels map[string]string, managed []string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("%s %q: %s", kind, key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("%s %q value %q: %s", kind, key, value, strings.Join(errs, "; "))
+		}
+		if slices.Contains(managed, key) {
+			return fmt.Errorf("%s %q is managed by the platform", kind, key)
+		}
+	}
+	return nil
+}
+
+// validateToleration checks a toleration's operator and effect
+func validateToleration(tol corev1.Toleration) error {
+	switch tol.Operator {
+	case "", corev1.TolerationOpEqual:
+	case corev1.TolerationOpExists:
+		if tol.Value != "" {
+			return fmt.Errorf("value must be empty with operator %s", tol.Operator)
+		}
+	default:
+		return fmt.Errorf("unknown operator %q", tol.Operator)
+	}
+	switch tol.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return fmt.Errorf("unknown effect %q", tol.Effect)
+	}
+	if tol.Key == "" && tol.Operator != corev1.TolerationOpExists {
+		return fmt.Errorf("a toleration without a key must use operator %s", corev1.TolerationOpExists)
+	}
+	return nil
+}
+
+// apply merges the template into pod. Labels, annotations and the agent container's env
+// already on pod win over the template's.
+func (t *PodTemplateConfig) apply(pod *corev1.Pod) {
+	pod.Labels = mergeDefaults(pod.Labels, t.Labels)
+	pod.Annotations = mergeDefaults(pod.Annotations, t.Annotations)
+	if len(t.NodeSelector) > 0 {
+		pod.Spec.NodeSelector = mergeDefaults(pod.Spec.NodeSelector, t.NodeSelector)
+	}
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, t.Tolerations...)
+	if t.Affinity != nil {
+		pod.Spec.Affinity = t.Affinity.DeepCopy()
+	}
+	if t.ServiceAccountName != "" {
+		pod.Spec.ServiceAccountName = t.ServiceAccountName
+	}
+	if t.PriorityClassName != "" {
+		pod.Spec.PriorityClassName = t.PriorityClassName
+	}
+
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if c.Name != AgentContainerName {
+			continue
+		}
+		if t.Container.Resources.Limits != nil || t.Container.Resources.Requests != nil {
+			c.Resources = *t.Container.Resources.DeepCopy()
+		}
+		if t.Container.SecurityContext != nil {
+			c.SecurityContext = t.Container.SecurityContext.DeepCopy()
+		}
+		for _, e := range t.Container.Env {
+			if !hasEnv(c.Env, e.Name) {
+				c.Env = append(c.Env, *e.DeepCopy())
+			}
+		}
+	}
+}
+
+// mergeDefaults returns values with defaults added for the keys it does not have
+func mergeDefaults(values, defaults map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return values
+	}
+	merged := make(map[string]string, len(values)+len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range values {
+		merged[k] = v
+	}
+	return merged
+}
+
+// hasEnv reports whether env sets name
+func hasEnv(env []corev1.EnvVar, name string) bool {
+	for _, e := range env {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
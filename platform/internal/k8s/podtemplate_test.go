@@ -0,0 +1,194 @@
+package k8s
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/forge/platform/internal/agent"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const testPodTemplate = `
+labels:
+  team: research
+annotations:
+  cost-center: "42"
+nodeSelector:
+  pool: agents
+tolerations:
+  - key: dedicated
+    operator: Equal
+    value: agents
+    effect: NoSchedule
+affinity:
+  nodeAffinity:
+    requiredDuringSchedulingIgnoredDuringExecution:
+      nodeSelectorTerms:
+        - matchExpressions:
+            - key: zone
+              operator: In
+              values: [a, b]
+serviceAccountName: forge-agent
+priorityClassName: agents-high
+container:
+  resources:
+    requests:
+      cpu: 500m
+      memory: 1Gi
+  env:
+    - name: HTTP_PROXY
+      value: http://proxy:3128
+`
+
+// assertGolden compares pod to the golden file name in testdata, rewriting it with -update
+func assertGolden(t *testing.T, name string, pod *corev1.Pod) {
+	t.Helper()
+	got, err := json.MarshalIndent(pod, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to marshal pod: %v", err)
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("pod differs from %s (run with -update to accept):\n%s", path, got)
+	}
+}
+
+func newTemplateManager(tmpl PodTemplateConfig) *Manager {
+	m := NewManagerWithClientset(fake.NewSimpleClientset(), "agents", "ghcr.io/forge/forge-agent:v1", "")
+	m.podTemplate = tmpl
+	return m
+}
+
+func TestBuildPod_Golden(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	annotations := map[string]string{ImageTagAnnotation: "v1"}
+
+	t.Run("without template", func(t *testing.T) {
+		pod := newTemplateManager(PodTemplateConfig{}).buildPod(podID, "ghcr.io/forge/forge-agent:v1", annotations)
+		assertGolden(t, "pod_default.golden.json", pod)
+	})
+
+	t.Run("with template", func(t *testing.T) {
+		tmpl, err := LoadPodTemplate("", testPodTemplate)
+		if err != nil {
+			t.Fatalf("failed to load template: %v", err)
+		}
+		pod := newTemplateManager(*tmpl).buildPod(podID, "ghcr.io/forge/forge-agent:v1", annotations)
+		assertGolden(t, "pod_template.golden.json", pod)
+	})
+}
+
+func TestBuildPod_TemplateCannotOverrideManagedFields(t *testing.T) {
+	// Built by hand, as Validate refuses these
+	tmpl := PodTemplateConfig{
+		Labels:      map[string]string{"agent-id": "other", "team": "research"},
+		Annotations: map[string]string{ImageTagAnnotation: "evil"},
+		Container: ContainerTemplate{Env: []corev1.EnvVar{
+			{Name: "AGENT_ID", Value: "other"},
+			{Name: "PORT", Value: "1"},
+		}},
+	}
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := newTemplateManager(tmpl).buildPod(podID, "image:v1", map[string]string{ImageTagAnnotation: "v1"})
+
+	if pod.Labels["agent-id"] != "agent-1" || pod.Labels["team"] != "research" {
+		t.Errorf("expected managed labels to win and others to merge, got %v", pod.Labels)
+	}
+	if pod.Annotations[ImageTagAnnotation] != "v1" {
+		t.Errorf("expected the managed image tag annotation, got %v", pod.Annotations)
+	}
+	c := pod.Spec.Containers[0]
+	if c.Name != AgentContainerName || c.Image != "image:v1" || len(c.Ports) != 1 || c.Ports[0].ContainerPort != DefaultAgentPort {
+		t.Errorf("expected the managed container name, image and port, got %+v", c)
+	}
+	for _, e := range c.Env {
+		if (e.Name == "AGENT_ID" && e.Value != "agent-1") || (e.Name == "PORT" && e.Value != "8080") {
+			t.Errorf("expected managed env %s to win, got %q", e.Name, e.Value)
+		}
+	}
+}
+
+func TestLoadPodTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "template.yaml")
+	if err := os.WriteFile(path, []byte(testPodTemplate), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := LoadPodTemplate(path, "labels: {ignored: inline}")
+	if err != nil {
+		t.Fatalf("failed to load template file: %v", err)
+	}
+	if tmpl.ServiceAccountName != "forge-agent" || tmpl.Labels["ignored"] != "" {
+		t.Errorf("expected the file to win over the inline template, got %+v", tmpl)
+	}
+
+	empty, err := LoadPodTemplate("", "")
+	if err != nil || empty == nil {
+		t.Fatalf("expected an empty template, got %v, %v", empty, err)
+	}
+
+	for name, tc := range map[string]struct {
+		yaml string
+		want string
+	}{
+		"unknown field":           {"nodeSelecter: {pool: agents}", "unknown field"},
+		"container name":          {"container: {name: other}", "unknown field"},
+		"container image":         {"container: {image: evil:latest}", "unknown field"},
+		"managed label":           {"labels: {user-id: other}", "managed by the platform"},
+		"managed annotation":      {"annotations: {agent-image-tag: v2}", "managed by the platform"},
+		"managed env":             {"container: {env: [{name: AGENT_ID, value: x}]}", "managed by the platform"},
+		"invalid label value":     {"labels: {team: 'not valid!'}", `label "team" value`},
+		"invalid toleration":      {"tolerations: [{key: a, operator: Maybe}]", "unknown operator"},
+		"invalid service account": {"serviceAccountName: Not_Valid", "serviceAccountName"},
+		"malformed yaml":          {"labels: [", "parsing inline pod template"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadPodTemplate("", tc.yaml)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	if _, err := LoadPodTemplate(filepath.Join(dir, "missing.yaml"), ""); err == nil {
+		t.Error("expected an error for a missing template file")
+	}
+}
+
+func TestBuildPod_AgentAuthToken(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	tmpl := PodTemplateConfig{Container: ContainerTemplate{Env: []corev1.EnvVar{
+		{Name: agent.AuthTokenEnv, Value: "forged"},
+	}}}
+	m := newTemplateManager(tmpl)
+	m.agentAuthSecret = "secret"
+	pod := m.buildPod(podID, "image:v1", map[string]string{ImageTagAnnotation: "v1"})
+
+	var tokens []string
+	for _, e := range pod.Spec.Containers[0].Env {
+		if e.Name == agent.AuthTokenEnv {
+			tokens = append(tokens, e.Value)
+		}
+	}
+	if want := agent.Token("secret", "user-1", "agent-1"); len(tokens) != 1 || tokens[0] != want {
+		t.Errorf("expected only the agent's own token %q, got %v", want, tokens)
+	}
+}
@@ -0,0 +1,239 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// DefaultMaxReadinessWatches is the default cap on concurrent readiness watches
+const DefaultMaxReadinessWatches = 64
+
+// ReadinessWatchStats is a point-in-time snapshot of readiness watch usage
+type ReadinessWatchStats struct {
+	// Limit is the configured maximum number of concurrent watches (0 = unlimited)
+	Limit int `json:"limit"`
+	// Active is the number of watches currently open against the API server
+	Active int `json:"active"`
+	// Peak is the highest value Active has reached since startup
+	Peak int `json:"peak"`
+	// WaitingForSlot is the number of watches queued behind the limit
+	WaitingForSlot int `json:"waiting_for_slot"`
+	// SlotWaits is the total number of watches that acquired a slot
+	SlotWaits int64 `json:"slot_waits"`
+	// SlotWaitTotal is the cumulative time spent waiting for a slot
+	SlotWaitTotal time.Duration `json:"slot_wait_total"`
+	// Coalesced is the number of callers that joined an existing watch
+	Coalesced int64 `json:"coalesced"`
+}
+
+// readinessWaiter is a single shared watch for one pod. Every caller of
+// WaitForPodReady for the same pod waits on done and reads pod/err after it closes.
+type readinessWaiter struct {
+	done   chan struct{}
+	pod    *corev1.Pod
+	err    error
+	refs   int
+	cancel context.CancelFunc
+}
+
+// readinessWatches limits and coalesces the watches opened by WaitForPodReady.
+// The zero value is usable and imposes no limit.
+type readinessWatches struct {
+	mu      sync.Mutex
+	limit   int
+	sem     chan struct{}
+	waiters map[string]*readinessWaiter
+
+	active         int
+	peak           int
+	waitingForSlot int
+	slotWaits      int64
+	slotWaitTotal  time.Duration
+	coalesced      int64
+}
+
+// ReadinessWatchStats returns a snapshot of readiness watch usage
+func (m *Manager) ReadinessWatchStats() ReadinessWatchStats {
+	rw := &m.readiness
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return ReadinessWatchStats{
+		Limit:          rw.limit,
+		Active:         rw.active,
+		Peak:           rw.peak,
+		WaitingForSlot: rw.waitingForSlot,
+		SlotWaits:      rw.slotWaits,
+		SlotWaitTotal:  rw.slotWaitTotal,
+		Coalesced:      rw.coalesced,
+	}
+}
+
+// joinReadinessWatch returns the shared watch for the pod, starting one if none is running
+func (m *Manager) joinReadinessWatch(podID PodID) *readinessWaiter {
+	rw := &m.readiness
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.waiters == nil {
+		rw.waiters = make(map[string]*readinessWaiter)
+	}
+
+	name := podID.Name()
+	if w, ok := rw.waiters[name]; ok {
+		w.refs++
+		rw.coalesced++
+		return w
+	}
+
+	// The watch is owned by the group of waiters, not by any single caller,
+	// so it gets its own context that is cancelled when the last waiter leaves.
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &readinessWaiter{
+		done:   make(chan struct{}),
+		refs:   1,
+		cancel: cancel,
+	}
+	rw.waiters[name] = w
+
+	go m.runReadinessWatch(ctx, podID, w)
+
+	return w
+}
+
+// leaveReadinessWatch drops a caller's reference, stopping the watch if it was the last one
+func (m *Manager) leaveReadinessWatch(podID PodID, w *readinessWaiter) {
+	rw := &m.readiness
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	w.refs--
+	if w.refs > 0 {
+		return
+	}
+
+	w.cancel()
+	if rw.waiters[podID.Name()] == w {
+		delete(rw.waiters, podID.Name())
+	}
+}
+
+// runReadinessWatch acquires a watch slot, watches the pod until it is ready or
+// the watch ends, and broadcasts the outcome to every waiter.
+func (m *Manager) runReadinessWatch(ctx context.Context, podID PodID, w *readinessWaiter) {
+	defer w.cancel()
+
+	var (
+		pod *corev1.Pod
+		err error
+	)
+
+	if c := m.podCache(); c != nil {
+		// The cache's informer watches every pod, so the wait needs no watch slot
+		pod, err = m.waitUntilReady(ctx, c, podID)
+	} else if release, acquireErr := m.readiness.acquire(ctx); acquireErr != nil {
+		err = acquireErr
+	} else {
+		pod, err = m.watchUntilReady(ctx, podID)
+		release()
+	}
+
+	rw := &m.readiness
+	rw.mu.Lock()
+	if rw.waiters[podID.Name()] == w {
+		delete(rw.waiters, podID.Name())
+	}
+	w.pod = pod
+	w.err = err
+	close(w.done)
+	rw.mu.Unlock()
+}
+
+// acquire blocks until a watch slot is available or the context is done.
+// The returned function releases the slot.
+func (rw *readinessWatches) acquire(ctx context.Context) (func(), error) {
+	rw.mu.Lock()
+	if rw.limit <= 0 {
+		rw.markActiveLocked()
+		rw.mu.Unlock()
+		return rw.release(nil), nil
+	}
+	if rw.sem == nil {
+		rw.sem = make(chan struct{}, rw.limit)
+	}
+	sem := rw.sem
+	rw.waitingForSlot++
+	rw.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		rw.mu.Lock()
+		rw.waitingForSlot--
+		rw.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	waited := time.Since(start)
+
+	rw.mu.Lock()
+	rw.waitingForSlot--
+	rw.slotWaits++
+	rw.slotWaitTotal += waited
+	rw.markActiveLocked()
+	rw.mu.Unlock()
+
+	return rw.release(sem), nil
+}
+
+func (rw *readinessWatches) markActiveLocked() {
+	rw.active++
+	if rw.active > rw.peak {
+		rw.peak = rw.active
+	}
+}
+
+func (rw *readinessWatches) release(sem chan struct{}) func() {
+	return func() {
+		if sem != nil {
+			<-sem
+		}
+		rw.mu.Lock()
+		rw.active--
+		rw.mu.Unlock()
+	}
+}
+
+// watchUntilReady watches the pod until it becomes ready, fails, is deleted (outside
+// deployment mode), or the watch fails
+func (m *Manager) watchUntilReady(ctx context.Context, podID PodID) (*corev1.Pod, error) {
+	events, err := m.WatchPod(ctx, podID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pod %s: %w", podID.Name(), err)
+	}
+
+	// runReadinessWatch cancels ctx once this returns, which stops the watch undrained
+	for event := range events {
+		if event.Err != nil {
+			return nil, fmt.Errorf("watch error while waiting for pod %s: %w", podID.Name(), event.Err)
+		}
+
+		switch event.Type {
+		case watch.Added, watch.Modified, watch.Deleted:
+			if ready, err := m.readyOrFailed(event.Pod, event.Type == watch.Deleted); ready != nil || err != nil {
+				return ready, err
+			}
+		}
+	}
+
+	// Channel closed without pod becoming ready
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("watch ended unexpectedly for pod %s", podID.Name())
+}
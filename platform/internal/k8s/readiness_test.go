@@ -0,0 +1,394 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func pendingPod(namespace string, podID PodID) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podID.Name(),
+			Namespace: namespace,
+			Labels:    podSelector(podID),
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+		},
+	}
+}
+
+func readyPodFrom(pod *corev1.Pod, ip string) *corev1.Pod {
+	ready := pod.DeepCopy()
+	ready.Status.Phase = corev1.PodRunning
+	ready.Status.PodIP = ip
+	ready.Status.ContainerStatuses = []corev1.ContainerStatus{{Ready: true}}
+	return ready
+}
+
+// countingWatchManager returns a manager whose pod watches each get a fresh fake
+// watcher, published on the returned channel in the order they are opened.
+func countingWatchManager(t *testing.T, limit int, objects ...runtime.Object) (*Manager, <-chan *watch.FakeWatcher, *atomic.Int32) {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(objects...)
+	watchers := make(chan *watch.FakeWatcher, 16)
+	var count atomic.Int32
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		count.Add(1)
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+	mgr := &Manager{
+		clientset:      clientset,
+		agentNamespace: "test-ns",
+		agentImage:     "test-image:latest",
+		readiness:      readinessWatches{limit: limit},
+	}
+	return mgr, watchers, &count
+}
+
+type waitResult struct {
+	pod *corev1.Pod
+	err error
+}
+
+func startWaiters(ctx context.Context, mgr *Manager, podID PodID, n int) <-chan waitResult {
+	results := make(chan waitResult, n)
+	for range n {
+		go func() {
+			pod, err := mgr.WaitForPodReady(ctx, podID)
+			results <- waitResult{pod, err}
+		}()
+	}
+	return results
+}
+
+func nextWatcher(t *testing.T, watchers <-chan *watch.FakeWatcher) *watch.FakeWatcher {
+	t.Helper()
+	select {
+	case w := <-watchers:
+		return w
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for watch to be opened")
+		return nil
+	}
+}
+
+func waitForStats(t *testing.T, mgr *Manager, cond func(ReadinessWatchStats) bool) ReadinessWatchStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := mgr.ReadinessWatchStats()
+		if cond(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for readiness stats, last: %+v", stats)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWaitForPodReady_CoalescesWaitersOnReady(t *testing.T) {
+	podID := PodID{UserID: "user1", AgentID: "agent1"}
+	pod := pendingPod("test-ns", podID)
+	mgr, watchers, count := countingWatchManager(t, 0, pod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 10
+	results := startWaiters(ctx, mgr, podID, n)
+	fw := nextWatcher(t, watchers)
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Coalesced == n-1 })
+
+	fw.Modify(readyPodFrom(pod, "10.0.0.9"))
+
+	for range n {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				t.Fatalf("unexpected error: %v", res.err)
+			}
+			if res.pod.Status.PodIP != "10.0.0.9" {
+				t.Errorf("expected pod IP 10.0.0.9, got %s", res.pod.Status.PodIP)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for coalesced waiters to be released")
+		}
+	}
+
+	if got := count.Load(); got != 1 {
+		t.Errorf("expected 1 watch for %d waiters, got %d", n, got)
+	}
+}
+
+func TestWaitForPodReady_CoalescedWaitersReleasedOnDelete(t *testing.T) {
+	podID := PodID{UserID: "user1", AgentID: "agent1"}
+	pod := pendingPod("test-ns", podID)
+	mgr, watchers, _ := countingWatchManager(t, 0, pod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 5
+	results := startWaiters(ctx, mgr, podID, n)
+	fw := nextWatcher(t, watchers)
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Coalesced == n-1 })
+
+	fw.Delete(pod)
+
+	for range n {
+		select {
+		case res := <-results:
+			if res.err == nil {
+				t.Fatal("expected error when pod is deleted")
+			}
+			if res.pod != nil {
+				t.Error("expected nil pod when pod is deleted")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for waiters to be released on delete")
+		}
+	}
+}
+
+func TestWaitForPodReady_CoalescedWaitersSurviveWatchTimeout(t *testing.T) {
+	podID := PodID{UserID: "user1", AgentID: "agent1"}
+	pod := pendingPod("test-ns", podID)
+	mgr, watchers, count := countingWatchManager(t, 0, pod)
+	mgr.watchBackoff = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 5
+	results := startWaiters(ctx, mgr, podID, n)
+	fw := nextWatcher(t, watchers)
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Coalesced == n-1 })
+
+	// Server-side watch timeout closes the result channel, and the watch is re-established
+	fw.Stop()
+	nextWatcher(t, watchers).Modify(readyPodFrom(pod, "10.0.0.1"))
+
+	for range n {
+		select {
+		case res := <-results:
+			if res.err != nil || res.pod.Status.PodIP != "10.0.0.1" {
+				t.Fatalf("expected the ready pod after the watch was re-established, got %v, %v", res.pod, res.err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for waiters to be released after the watch timeout")
+		}
+	}
+	if got := count.Load(); got != 2 {
+		t.Errorf("expected 2 watches, got %d", got)
+	}
+}
+
+func TestWaitForPodReady_CallerCancelDoesNotAffectOtherWaiters(t *testing.T) {
+	podID := PodID{UserID: "user1", AgentID: "agent1"}
+	pod := pendingPod("test-ns", podID)
+	mgr, watchers, count := countingWatchManager(t, 0, pod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shortCtx, shortCancel := context.WithCancel(ctx)
+
+	short := startWaiters(shortCtx, mgr, podID, 1)
+	fw := nextWatcher(t, watchers)
+	long := startWaiters(ctx, mgr, podID, 1)
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Coalesced == 1 })
+
+	shortCancel()
+	select {
+	case res := <-short:
+		if res.err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", res.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for cancelled waiter")
+	}
+
+	fw.Modify(readyPodFrom(pod, "10.0.0.3"))
+
+	select {
+	case res := <-long:
+		if res.err != nil {
+			t.Fatalf("unexpected error: %v", res.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for remaining waiter")
+	}
+
+	if got := count.Load(); got != 1 {
+		t.Errorf("expected 1 watch, got %d", got)
+	}
+}
+
+func TestWaitForPodReady_LastWaiterLeavingStopsWatch(t *testing.T) {
+	podID := PodID{UserID: "user1", AgentID: "agent1"}
+	pod := pendingPod("test-ns", podID)
+	mgr, watchers, _ := countingWatchManager(t, 0, pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := startWaiters(ctx, mgr, podID, 3)
+	nextWatcher(t, watchers)
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Coalesced == 2 && s.Active == 1 })
+
+	cancel()
+	for range 3 {
+		<-results
+	}
+
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Active == 0 })
+
+	mgr.readiness.mu.Lock()
+	remaining := len(mgr.readiness.waiters)
+	mgr.readiness.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("expected no shared watches after all waiters left, got %d", remaining)
+	}
+}
+
+func TestWaitForPodReady_SemaphoreLimitsConcurrentWatches(t *testing.T) {
+	podA := PodID{UserID: "user1", AgentID: "agentA"}
+	podB := PodID{UserID: "user1", AgentID: "agentB"}
+	pendingA := pendingPod("test-ns", podA)
+	pendingB := pendingPod("test-ns", podB)
+	mgr, watchers, count := countingWatchManager(t, 1, pendingA, pendingB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resA := startWaiters(ctx, mgr, podA, 1)
+	fwA := nextWatcher(t, watchers)
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Active == 1 })
+
+	resB := startWaiters(ctx, mgr, podB, 1)
+	stats := waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.WaitingForSlot == 1 })
+	if stats.Active != 1 {
+		t.Fatalf("expected 1 active watch while second is queued, got %d", stats.Active)
+	}
+	if got := count.Load(); got != 1 {
+		t.Fatalf("expected second watch to be held back by the limit, got %d watches", got)
+	}
+
+	fwA.Modify(readyPodFrom(pendingA, "10.0.0.1"))
+	if res := <-resA; res.err != nil {
+		t.Fatalf("unexpected error for pod A: %v", res.err)
+	}
+
+	fwB := nextWatcher(t, watchers)
+	fwB.Modify(readyPodFrom(pendingB, "10.0.0.2"))
+	if res := <-resB; res.err != nil {
+		t.Fatalf("unexpected error for pod B: %v", res.err)
+	}
+
+	stats = waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.Active == 0 })
+	if stats.Peak != 1 {
+		t.Errorf("expected peak of 1 with limit 1, got %d", stats.Peak)
+	}
+	if stats.SlotWaits != 2 {
+		t.Errorf("expected 2 slot acquisitions, got %d", stats.SlotWaits)
+	}
+	if stats.SlotWaitTotal <= 0 {
+		t.Error("expected non-zero cumulative slot wait time")
+	}
+}
+
+func TestWaitForPodReady_QueuedWatchAbandonedOnCancel(t *testing.T) {
+	podA := PodID{UserID: "user1", AgentID: "agentA"}
+	podB := PodID{UserID: "user1", AgentID: "agentB"}
+	mgr, watchers, count := countingWatchManager(t, 1, pendingPod("test-ns", podA), pendingPod("test-ns", podB))
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	startWaiters(ctxA, mgr, podA, 1)
+	nextWatcher(t, watchers)
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	resB := startWaiters(ctxB, mgr, podB, 1)
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.WaitingForSlot == 1 })
+
+	cancelB()
+	if res := <-resB; res.err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", res.err)
+	}
+	waitForStats(t, mgr, func(s ReadinessWatchStats) bool { return s.WaitingForSlot == 0 })
+
+	if got := count.Load(); got != 1 {
+		t.Errorf("expected abandoned queued watch never to open, got %d watches", got)
+	}
+}
+
+func TestWaitForPodReady_ConcurrentBurstAcrossPods(t *testing.T) {
+	const pods = 20
+	objects := make([]runtime.Object, 0, pods)
+	ids := make([]PodID, 0, pods)
+	for i := range pods {
+		id := PodID{UserID: "user1", AgentID: string(rune('a' + i))}
+		ids = append(ids, id)
+		objects = append(objects, pendingPod("test-ns", id))
+	}
+
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		// Every watch immediately reports its pod as ready
+		name := action.(k8stesting.WatchActionImpl).WatchRestrictions.Fields.String()
+		w := watch.NewFakeWithChanSize(1, false)
+		for i, id := range ids {
+			if name == "metadata.name="+id.Name() {
+				w.Modify(readyPodFrom(objects[i].(*corev1.Pod), "10.0.0.1"))
+			}
+		}
+		return true, w, nil
+	})
+	mgr := &Manager{
+		clientset:      clientset,
+		agentNamespace: "test-ns",
+		readiness:      readinessWatches{limit: 4},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, pods*3)
+	for _, id := range ids {
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := mgr.WaitForPodReady(ctx, id); err != nil {
+					errs <- err
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	stats := mgr.ReadinessWatchStats()
+	if stats.Peak > 4 {
+		t.Errorf("expected peak watches <= 4, got %d", stats.Peak)
+	}
+	if stats.Active != 0 || stats.WaitingForSlot != 0 {
+		t.Errorf("expected no active or queued watches after burst, got %+v", stats)
+	}
+}
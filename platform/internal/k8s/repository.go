@@ -0,0 +1,205 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RepositoryInitContainerName names the init container cloning an agent's repository
+const RepositoryInitContainerName = "clone-repository"
+
+// Keys of a repository auth secret: the token or password, and the user name it goes with
+// (x-access-token if unset)
+const (
+	RepositorySecretPasswordKey = "password"
+	RepositorySecretUsernameKey = "username"
+)
+
+// MaxRepositoryDepth is the deepest shallow clone a Repository may ask for
+const MaxRepositoryDepth = 100000
+
+var (
+	// ErrInvalidRepository is returned for a Repository that cannot be cloned as given
+	ErrInvalidRepository = errors.New("invalid repository")
+	// ErrRepositorySecret is returned for an auth secret an agent may not use
+	ErrRepositorySecret = errors.New("repository auth secret not usable")
+)
+
+// repositoryRefSyntax is the subset of git ref names a Repository's ref may be: a branch,
+// tag or commit not starting with "-", so it is never taken for an option
+var repositoryRefSyntax = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_./-]{0,254}$`)
+
+// Repository is a git repository cloned into an agent's workspace before the agent starts
+type Repository struct {
+	// URL is the repository's http(s) URL, without credentials
+	URL string
+	// Ref is the branch, tag or commit checked out; empty checks out the default branch
+	Ref string
+	// Depth, if positive, clones only that many commits of history
+	Depth int
+	// AuthSecretName names a secret in the agent namespace holding the credentials of a
+	// private repository under RepositorySecretPasswordKey and RepositorySecretUsernameKey
+	AuthSecretName string
+}
+
+// Validate reports whether the repository can be cloned as given. Errors wrap
+// ErrInvalidRepository.
+func (r Repository) Validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil || r.URL == "" {
+		return fmt.Errorf("%w: url %q is not a valid URL", ErrInvalidRepository, r.URL)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("%w: url must be an http(s) URL", ErrInvalidRepository)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: url may not hold credentials; use auth_secret_name", ErrInvalidRepository)
+	}
+	if r.Ref != "" && (!repositoryRefSyntax.MatchString(r.Ref) || strings.Contains(r.Ref, "..") ||
+		strings.Contains(r.Ref, "//") || strings.HasSuffix(r.Ref, "/") || strings.HasSuffix(r.Ref, ".lock")) {
+		return fmt.Errorf("%w: ref %q is not a valid branch, tag or commit", ErrInvalidRepository, r.Ref)
+	}
+	if r.Depth < 0 || r.Depth > MaxRepositoryDepth {
+		return fmt.Errorf("%w: depth must be between 0 and %d", ErrInvalidRepository, MaxRepositoryDepth)
+	}
+	if r.AuthSecretName != "" {
+		if errs := validation.IsDNS1123Subdomain(r.AuthSecretName); len(errs) > 0 {
+			return fmt.Errorf("%w: auth_secret_name %q: %s", ErrInvalidRepository, r.AuthSecretName, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// checkRepositorySecret checks that podID's agent may clone with the secret name: it
+// exists, is not the platform's own, and if labeled with a user-id, is the agent's user's
+func (m *Manager) checkRepositorySecret(ctx context.Context, podID PodID, name string) error {
+	if name == AgentSecretsName {
+		return fmt.Errorf("%w: %s is reserved", ErrRepositorySecret, name)
+	}
+	secret, err := m.clientset.CoreV1().Secrets(m.agentNamespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: secret %s not found", ErrRepositorySecret, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get repository secret %s: %w", name, err)
+	}
+	if owner, ok := secret.Labels["user-id"]; ok && owner != podID.UserID {
+		return fmt.Errorf("%w: secret %s belongs to another user", ErrRepositorySecret, name)
+	}
+	if _, ok := secret.Data[RepositorySecretPasswordKey]; !ok {
+		return fmt.Errorf("%w: secret %s has no %q key", ErrRepositorySecret, name, RepositorySecretPasswordKey)
+	}
+	return nil
+}
+
+// cloneRepositoryScript clones $GIT_URL into the workspace at $GIT_DEST, checking out
+// $GIT_REF (the default branch if unset) as a branch, or detached for a tag or commit. A
+// workspace already holding a repository, such as a persistent one reused, is left as is.
+// Credentials are only kept in the init container's home, never in the workspace.
+const cloneRepositoryScript = `set -eu
+export HOME=/tmp
+cd "$GIT_DEST"
+if [ -e .git ]; then
+  echo "workspace already holds a repository, not cloning"
+  exit 0
+fi
+if [ -n "${GIT_PASSWORD:-}" ]; then
+  git config --global credential.helper '!f() { echo "username=${GIT_USERNAME:-x-access-token}"; echo "password=${GIT_PASSWORD}"; }; f'
+fi
+git init -q .
+git remote add origin "$GIT_URL"
+ref="${GIT_REF:-}"
+if [ -z "$ref" ]; then
+  ref=$(git ls-remote --symref origin HEAD | awk '/^ref:/ { sub("refs/heads/", "", $2); print $2 }')
+  ref="${ref:-HEAD}"
+fi
+git fetch -q ${GIT_DEPTH:+--depth "$GIT_DEPTH"} origin "$ref"
+if git ls-remote --exit-code --heads origin "$ref" >/dev/null; then
+  git checkout -q -B "$ref" FETCH_HEAD
+else
+  git checkout -q --detach FETCH_HEAD
+fi
+`
+
+// addRepositoryClone adds the init container cloning repo into pod's workspace, mounting
+// an emptyDir workspace shared with the agent container if the pod has no persistent one.
+// The init container runs the agent's image, as the agent container's user, so the agent
+// owns the files.
+func (m *Manager) addRepositoryClone(pod *corev1.Pod, repo Repository) {
+	if !hasWorkspace(pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         workspaceVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == AgentContainerName {
+				pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+					Name:      workspaceVolumeName,
+					MountPath: m.workspacePath(),
+				})
+			}
+		}
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "GIT_URL", Value: repo.URL},
+		{Name: "GIT_DEST", Value: m.workspacePath()},
+		{Name: "GIT_TERMINAL_PROMPT", Value: "0"},
+	}
+	if repo.Ref != "" {
+		env = append(env, corev1.EnvVar{Name: "GIT_REF", Value: repo.Ref})
+	}
+	if repo.Depth > 0 {
+		env = append(env, corev1.EnvVar{Name: "GIT_DEPTH", Value: strconv.Itoa(repo.Depth)})
+	}
+	if repo.AuthSecretName != "" {
+		env = append(env,
+			secretEnv("GIT_USERNAME", repo.AuthSecretName, RepositorySecretUsernameKey, true),
+			secretEnv("GIT_PASSWORD", repo.AuthSecretName, RepositorySecretPasswordKey, false),
+		)
+	}
+
+	clone := corev1.Container{
+		Name:    RepositoryInitContainerName,
+		Command: []string{"sh", "-c", cloneRepositoryScript},
+		Env:     env,
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      workspaceVolumeName,
+			MountPath: m.workspacePath(),
+		}},
+		// git's error is the message of the init container's failure
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == AgentContainerName {
+			clone.Image = c.Image
+			clone.ImagePullPolicy = c.ImagePullPolicy
+			if c.SecurityContext != nil {
+				clone.SecurityContext = c.SecurityContext.DeepCopy()
+			}
+		}
+	}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, clone)
+}
+
+// secretEnv returns an env var set from key of the secret name
+func secretEnv(envName, name, key string, optional bool) corev1.EnvVar {
+	ref := &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: name},
+		Key:                  key,
+	}
+	if optional {
+		ref.Optional = ptr(true)
+	}
+	return corev1.EnvVar{Name: envName, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref}}
+}
@@ -0,0 +1,282 @@
+package k8s
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RolloutConfigMapName is the ConfigMap in the agent namespace that holds the rollout policy
+const RolloutConfigMapName = "forge-agent-rollout"
+
+// rolloutConfigMapKey is the ConfigMap key holding the JSON-encoded rollout
+const rolloutConfigMapKey = "rollout.json"
+
+// Errors returned by the rollout policy methods
+var (
+	ErrNoRollout      = errors.New("no rollout policy")
+	ErrInvalidRollout = errors.New("invalid rollout policy")
+)
+
+// Rollout gradually moves new agents onto another agent image tag. An agent is on the
+// rollout if its user is in Users, or else if its pod name hashes into the first Percent
+// of 100 buckets. The hash is salted with the rollout ID, so the same agent always lands
+// in the same bucket while the rollout lasts and ramping Percent up only adds agents.
+type Rollout struct {
+	ID        string    `json:"id"`
+	ImageTag  string    `json:"image_tag"`
+	Percent   int       `json:"percent"`
+	Users     []string  `json:"users,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Includes reports whether the agent of podID runs the rollout's image tag
+func (r *Rollout) Includes(podID PodID) bool {
+	if slices.Contains(r.Users, podID.UserID) {
+		return true
+	}
+	switch {
+	case r.Percent <= 0:
+		return false
+	case r.Percent >= 100:
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(r.ID + "/" + podID.Name()))
+	return h.Sum32()%100 < uint32(r.Percent)
+}
+
+// validateRollout checks the settable fields of a rollout. Errors wrap ErrInvalidRollout.
+func validateRollout(imageTag string, percent int) error {
+	switch {
+	case imageTag == "":
+		return fmt.Errorf("%w: image_tag is required", ErrInvalidRollout)
+	case strings.ContainsAny(imageTag, ":/@ \t\n"):
+		return fmt.Errorf("%w: image_tag %q must be a bare tag", ErrInvalidRollout, imageTag)
+	case percent < 0 || percent > 100:
+		return fmt.Errorf("%w: percent must be between 0 and 100", ErrInvalidRollout)
+	}
+	return nil
+}
+
+// GetRollout returns the rollout policy, or ErrNoRollout if there is none
+func (m *Manager) GetRollout(ctx context.Context) (*Rollout, error) {
+	cm, err := m.clientset.CoreV1().ConfigMaps(m.agentNamespace).Get(ctx, RolloutConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrNoRollout
+		}
+		return nil, fmt.Errorf("failed to get rollout policy: %w", err)
+	}
+	raw, ok := cm.Data[rolloutConfigMapKey]
+	if !ok {
+		return nil, ErrNoRollout
+	}
+	var rollout Rollout
+	if err := json.Unmarshal([]byte(raw), &rollout); err != nil {
+		return nil, fmt.Errorf("failed to decode rollout policy: %w", err)
+	}
+	return &rollout, nil
+}
+
+// SetRollout creates or replaces the rollout policy. Changing only the percentage or the
+// users keeps the rollout's ID, and with it every agent's bucket; a new image tag starts
+// a new rollout with a new ID.
+func (m *Manager) SetRollout(ctx context.Context, imageTag string, percent int, users []string) (*Rollout, error) {
+	if err := validateRollout(imageTag, percent); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	rollout := &Rollout{
+		ID:        newRolloutID(),
+		ImageTag:  imageTag,
+		Percent:   percent,
+		Users:     users,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	existing, err := m.GetRollout(ctx)
+	switch {
+	case err == nil:
+		if existing.ImageTag == imageTag {
+			rollout.ID, rollout.CreatedAt = existing.ID, existing.CreatedAt
+		}
+	case !errors.Is(err, ErrNoRollout):
+		return nil, err
+	}
+
+	raw, err := json.Marshal(rollout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode rollout policy: %w", err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: RolloutConfigMapName},
+		Data:       map[string]string{rolloutConfigMapKey: string(raw)},
+	}
+	configMaps := m.clientset.CoreV1().ConfigMaps(m.agentNamespace)
+	if existing == nil {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	} else {
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store rollout policy: %w", err)
+	}
+	return rollout, nil
+}
+
+// DeleteRollout ends the rollout; new agents run the configured agent image again.
+// Agents already on the rollout keep their image. Returns ErrNoRollout if there is none.
+func (m *Manager) DeleteRollout(ctx context.Context) error {
+	err := m.clientset.CoreV1().ConfigMaps(m.agentNamespace).Delete(ctx, RolloutConfigMapName, metav1.DeleteOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ErrNoRollout
+		}
+		return fmt.Errorf("failed to delete rollout policy: %w", err)
+	}
+	return nil
+}
+
+// chooseImage returns the image and annotations for a new agent pod. An explicit image
+// bypasses the rollout; otherwise the agent runs the rollout's tag if the rollout
+// includes it, and the configured agent image if not.
+func (m *Manager) chooseImage(ctx context.Context, podID PodID, image string) (string, map[string]string, error) {
+	if image != "" {
+		return image, map[string]string{ImageTagAnnotation: imageTag(image)}, nil
+	}
+
+	image = m.agentImage
+	annotations := map[string]string{}
+	rollout, err := m.GetRollout(ctx)
+	switch {
+	case err == nil:
+		annotations[RolloutIDAnnotation] = rollout.ID
+		if rollout.Includes(podID) {
+			image = imageWithTag(image, rollout.ImageTag)
+		}
+	case !errors.Is(err, ErrNoRollout):
+		return "", nil, err
+	}
+	annotations[ImageTagAnnotation] = imageTag(image)
+	return image, annotations, nil
+}
+
+// imageTag returns the tag of an image reference, "latest" if it has none
+func imageTag(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+// imageWithTag returns image with its tag replaced by tag
+func imageWithTag(image, tag string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + ":" + tag
+}
+
+// newRolloutID returns a random rollout ID
+func newRolloutID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return "rollout-" + hex.EncodeToString(b)
+}
+
+// RolloutMetrics counts how agents of one rollout cohort fared on this replica since it
+// started. Agents created while no rollout was active have an empty RolloutID, and
+// agents a rollout did not include share its ID but not its image tag.
+type RolloutMetrics struct {
+	RolloutID     string `json:"rollout_id"`
+	ImageTag      string `json:"image_tag"`
+	AgentsCreated int64  `json:"agents_created"`
+	CreateErrors  int64  `json:"create_errors"`
+	StartFailures int64  `json:"start_failures"`
+	RequestErrors int64  `json:"request_errors"`
+}
+
+// rolloutCohort identifies the agents counted together by RolloutMetrics
+type rolloutCohort struct {
+	rolloutID string
+	imageTag  string
+}
+
+// rolloutMetrics holds the RolloutMetrics of every cohort seen
+type rolloutMetrics struct {
+	mu      sync.Mutex
+	cohorts map[rolloutCohort]*RolloutMetrics
+}
+
+// record applies update to the metrics of the cohort annotations describe
+func (r *rolloutMetrics) record(annotations map[string]string, update func(*RolloutMetrics)) {
+	cohort := rolloutCohort{
+		rolloutID: annotations[RolloutIDAnnotation],
+		imageTag:  annotations[ImageTagAnnotation],
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cohorts == nil {
+		r.cohorts = make(map[rolloutCohort]*RolloutMetrics)
+	}
+	metrics, ok := r.cohorts[cohort]
+	if !ok {
+		metrics = &RolloutMetrics{RolloutID: cohort.rolloutID, ImageTag: cohort.imageTag}
+		r.cohorts[cohort] = metrics
+	}
+	update(metrics)
+}
+
+// RolloutMetrics returns the metrics of every cohort, ordered by rollout ID and image tag
+func (m *Manager) RolloutMetrics() []RolloutMetrics {
+	m.rolloutMetrics.mu.Lock()
+	defer m.rolloutMetrics.mu.Unlock()
+	metrics := make([]RolloutMetrics, 0, len(m.rolloutMetrics.cohorts))
+	for _, cohort := range m.rolloutMetrics.cohorts {
+		metrics = append(metrics, *cohort)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].RolloutID != metrics[j].RolloutID {
+			return metrics[i].RolloutID < metrics[j].RolloutID
+		}
+		return metrics[i].ImageTag < metrics[j].ImageTag
+	})
+	return metrics
+}
+
+// RecordStartFailure counts an agent whose pod was created but never became ready against
+// its rollout cohort. Agents whose pod cannot be read are not counted.
+func (m *Manager) RecordStartFailure(ctx context.Context, podID PodID) {
+	m.recordForPod(ctx, podID, func(metrics *RolloutMetrics) { metrics.StartFailures++ })
+}
+
+// RecordRequestError counts a failed request against its agent's rollout cohort. Agents
+// whose pod cannot be read are not counted.
+func (m *Manager) RecordRequestError(ctx context.Context, podID PodID) {
+	m.recordForPod(ctx, podID, func(metrics *RolloutMetrics) { metrics.RequestErrors++ })
+}
+
+// recordForPod applies update to the metrics of the pod's cohort
+func (m *Manager) recordForPod(ctx context.Context, podID PodID, update func(*RolloutMetrics)) {
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil {
+		return
+	}
+	m.rolloutMetrics.record(pod.Annotations, update)
+}
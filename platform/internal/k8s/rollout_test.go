@@ -0,0 +1,225 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newRolloutTestManager() *Manager {
+	return NewManagerWithClientset(fake.NewSimpleClientset(), "default", "registry:5111/forge-agent:stable", "")
+}
+
+func TestRollout_CRUD(t *testing.T) {
+	m := newRolloutTestManager()
+	ctx := context.Background()
+
+	if _, err := m.GetRollout(ctx); !errors.Is(err, ErrNoRollout) {
+		t.Fatalf("expected ErrNoRollout before any rollout, got %v", err)
+	}
+	if err := m.DeleteRollout(ctx); !errors.Is(err, ErrNoRollout) {
+		t.Fatalf("expected ErrNoRollout deleting a missing rollout, got %v", err)
+	}
+
+	created, err := m.SetRollout(ctx, "v2", 10, []string{"alice"})
+	if err != nil {
+		t.Fatalf("SetRollout failed: %v", err)
+	}
+	got, err := m.GetRollout(ctx)
+	if err != nil {
+		t.Fatalf("GetRollout failed: %v", err)
+	}
+	if got.ID != created.ID || got.ImageTag != "v2" || got.Percent != 10 || len(got.Users) != 1 {
+		t.Errorf("expected the stored rollout to match %+v, got %+v", created, got)
+	}
+
+	ramped, err := m.SetRollout(ctx, "v2", 50, nil)
+	if err != nil {
+		t.Fatalf("ramping failed: %v", err)
+	}
+	if ramped.ID != created.ID || !ramped.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("expected ramping the same tag to keep rollout %s, got %s", created.ID, ramped.ID)
+	}
+
+	replaced, err := m.SetRollout(ctx, "v3", 10, nil)
+	if err != nil {
+		t.Fatalf("replacing failed: %v", err)
+	}
+	if replaced.ID == created.ID {
+		t.Error("expected a new image tag to start a new rollout")
+	}
+
+	if err := m.DeleteRollout(ctx); err != nil {
+		t.Fatalf("DeleteRollout failed: %v", err)
+	}
+	if _, err := m.GetRollout(ctx); !errors.Is(err, ErrNoRollout) {
+		t.Errorf("expected ErrNoRollout after deleting, got %v", err)
+	}
+}
+
+func TestRollout_Validation(t *testing.T) {
+	m := newRolloutTestManager()
+	tests := []struct {
+		name    string
+		tag     string
+		percent int
+	}{
+		{"missing tag", "", 10},
+		{"image reference as tag", "forge-agent:v2", 10},
+		{"negative percent", "v2", -1},
+		{"percent over 100", "v2", 101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.SetRollout(context.Background(), tt.tag, tt.percent, nil); !errors.Is(err, ErrInvalidRollout) {
+				t.Errorf("expected ErrInvalidRollout, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRollout_IncludesEdges(t *testing.T) {
+	for _, percent := range []int{0, 100} {
+		rollout := &Rollout{ID: "rollout-test", ImageTag: "v2", Percent: percent}
+		for i := range 200 {
+			podID := PodID{UserID: "user", AgentID: fmt.Sprintf("agent-%d", i)}
+			if got := rollout.Includes(podID); got != (percent == 100) {
+				t.Fatalf("at %d%%, expected Includes(%s) to be %v", percent, podID.Name(), percent == 100)
+			}
+		}
+	}
+
+	allowlisted := &Rollout{ID: "rollout-test", ImageTag: "v2", Percent: 0, Users: []string{"alice"}}
+	if !allowlisted.Includes(PodID{UserID: "alice", AgentID: "a"}) {
+		t.Error("expected an allowlisted user to be included at 0%")
+	}
+}
+
+func TestRollout_IncludesIsDeterministicAndMonotonic(t *testing.T) {
+	low := &Rollout{ID: "rollout-test", ImageTag: "v2", Percent: 20}
+	high := &Rollout{ID: "rollout-test", ImageTag: "v2", Percent: 60}
+
+	included := 0
+	for i := range 1000 {
+		podID := PodID{UserID: fmt.Sprintf("user-%d", i%10), AgentID: fmt.Sprintf("agent-%d", i)}
+		first := low.Includes(podID)
+		if low.Includes(podID) != first {
+			t.Fatalf("expected %s to be assigned the same way every time", podID.Name())
+		}
+		if first && !high.Includes(podID) {
+			t.Fatalf("expected %s to stay on the rollout when ramping from 20%% to 60%%", podID.Name())
+		}
+		if first {
+			included++
+		}
+	}
+	// 20% of 1000 agents, with generous slack for the hash
+	if included < 120 || included > 280 {
+		t.Errorf("expected about 200 of 1000 agents at 20%%, got %d", included)
+	}
+}
+
+func TestCreatePod_AnnotatesRolloutAndTag(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		percent int
+		image   string
+		want    string
+	}{
+		{"0% keeps the configured image", 0, "", "registry:5111/forge-agent:stable"},
+		{"100% runs the rollout tag", 100, "", "registry:5111/forge-agent:v2"},
+		{"explicit image bypasses the rollout", 100, "other/agent:pinned", "other/agent:pinned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newRolloutTestManager()
+			rollout, err := m.SetRollout(ctx, "v2", tt.percent, nil)
+			if err != nil {
+				t.Fatalf("SetRollout failed: %v", err)
+			}
+			podID := PodID{UserID: "user", AgentID: "agent"}
+			if err := m.CreatePodWithImage(ctx, podID, tt.image); err != nil {
+				t.Fatalf("CreatePodWithImage failed: %v", err)
+			}
+			pod, err := m.GetPod(ctx, podID)
+			if err != nil {
+				t.Fatalf("GetPod failed: %v", err)
+			}
+			if got := pod.Spec.Containers[0].Image; got != tt.want {
+				t.Errorf("expected image %q, got %q", tt.want, got)
+			}
+			if got := pod.Annotations[ImageTagAnnotation]; got != imageTag(tt.want) {
+				t.Errorf("expected tag annotation %q, got %q", imageTag(tt.want), got)
+			}
+			wantRollout := rollout.ID
+			if tt.image != "" {
+				wantRollout = ""
+			}
+			if got := pod.Annotations[RolloutIDAnnotation]; got != wantRollout {
+				t.Errorf("expected rollout annotation %q, got %q", wantRollout, got)
+			}
+		})
+	}
+}
+
+func TestRolloutMetrics_LabeledByCohort(t *testing.T) {
+	m := newRolloutTestManager()
+	ctx := context.Background()
+
+	baseline := PodID{UserID: "user", AgentID: "before-rollout"}
+	if err := m.CreatePod(ctx, baseline); err != nil {
+		t.Fatalf("CreatePod failed: %v", err)
+	}
+	rollout, err := m.SetRollout(ctx, "v2", 0, []string{"canary-user"})
+	if err != nil {
+		t.Fatalf("SetRollout failed: %v", err)
+	}
+	control := PodID{UserID: "user", AgentID: "control"}
+	canary := PodID{UserID: "canary-user", AgentID: "canary"}
+	for _, podID := range []PodID{control, canary} {
+		if err := m.CreatePod(ctx, podID); err != nil {
+			t.Fatalf("CreatePod failed: %v", err)
+		}
+	}
+	m.RecordRequestError(ctx, canary)
+	m.RecordRequestError(ctx, canary)
+	m.RecordStartFailure(ctx, control)
+	m.RecordRequestError(ctx, PodID{UserID: "user", AgentID: "missing"})
+	// Creating an existing pod fails and counts against the agent's cohort
+	if err := m.CreatePod(ctx, canary); err == nil {
+		t.Fatal("expected creating an existing pod to fail")
+	}
+
+	want := []RolloutMetrics{
+		{RolloutID: "", ImageTag: "stable", AgentsCreated: 1},
+		{RolloutID: rollout.ID, ImageTag: "stable", AgentsCreated: 1, StartFailures: 1},
+		{RolloutID: rollout.ID, ImageTag: "v2", AgentsCreated: 1, CreateErrors: 1, RequestErrors: 2},
+	}
+	got := m.RolloutMetrics()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d cohorts, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("cohort %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestImageWithTag(t *testing.T) {
+	tests := []struct{ image, want string }{
+		{"forge-agent", "forge-agent:v2"},
+		{"forge-agent:latest", "forge-agent:v2"},
+		{"registry:5111/forge-agent", "registry:5111/forge-agent:v2"},
+		{"registry:5111/ns/forge-agent:latest", "registry:5111/ns/forge-agent:v2"},
+	}
+	for _, tt := range tests {
+		if got := imageWithTag(tt.image, "v2"); got != tt.want {
+			t.Errorf("imageWithTag(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package k8s
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// DefaultWatchRetryWindow is how long WatchPod keeps trying to re-establish a failing watch
+// by default
+const DefaultWatchRetryWindow = 2 * time.Minute
+
+const (
+	// watchTimeoutSeconds is the server-side timeout of each pod watch; WatchPod resumes
+	// once it ends
+	watchTimeoutSeconds = 300
+	// watchBackoffBase and watchBackoffMax are the default bounds of the delay between
+	// attempts to re-establish a failing watch
+	watchBackoffBase = 200 * time.Millisecond
+	watchBackoffMax  = 10 * time.Second
+)
+
+var (
+	// errPodGone ends a pod watch whose pod, or Deployment, no longer exists
+	errPodGone = stderrors.New("no longer exists")
+	// errWatchClosed is a watch closed before it delivered anything or timed out
+	errWatchClosed = stderrors.New("watch closed early")
+)
+
+// podWatch follows an agent's pods for WatchPod, re-listing and re-watching across watch
+// closures and retriable errors
+type podWatch struct {
+	m      *Manager
+	podID  PodID
+	opts   metav1.ListOptions
+	events chan<- PodEvent
+	// backoff and maxBackoff bound the delay between attempts to re-establish a failing
+	// watch, which are abandoned after retryWindow
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	retryWindow time.Duration
+	// seen is the last state of each pod sent, by name
+	seen map[string]*corev1.Pod
+}
+
+// newPodWatch returns a watch of podID's pods, sending on events
+func (m *Manager) newPodWatch(podID PodID, opts metav1.ListOptions, events chan<- PodEvent) *podWatch {
+	w := &podWatch{
+		m:           m,
+		podID:       podID,
+		opts:        opts,
+		events:      events,
+		backoff:     m.watchBackoff,
+		maxBackoff:  m.watchMaxBackoff,
+		retryWindow: m.watchRetryWindow,
+		seen:        make(map[string]*corev1.Pod),
+	}
+	if w.backoff <= 0 {
+		w.backoff = watchBackoffBase
+	}
+	if w.maxBackoff <= 0 {
+		w.maxBackoff = watchBackoffMax
+	}
+	if w.retryWindow <= 0 {
+		w.retryWindow = DefaultWatchRetryWindow
+	}
+	return w
+}
+
+// run follows the pods until the watch fails for good, the pod is gone, or ctx ends, and
+// then closes the events channel. A failure is sent before the channel is closed; the end
+// of ctx is not.
+func (w *podWatch) run(ctx context.Context) {
+	defer close(w.events)
+
+	var failingSince time.Time
+	delay := w.backoff
+	for {
+		healthy, err := w.once(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case stderrors.Is(err, errPodGone):
+			w.emit(ctx, PodEvent{Err: fmt.Errorf("pod %s %w", w.podID.Name(), err)})
+			return
+		case err != nil && !retriableWatchError(err):
+			w.emit(ctx, PodEvent{Err: fmt.Errorf("watch error for pod %s: %w", w.podID.Name(), err)})
+			return
+		case healthy:
+			failingSince = time.Time{}
+			delay = w.backoff
+		case failingSince.IsZero():
+			failingSince = time.Now()
+		case time.Since(failingSince) > w.retryWindow:
+			w.emit(ctx, PodEvent{Err: fmt.Errorf("gave up re-establishing the watch for pod %s after %s: %w",
+				w.podID.Name(), w.retryWindow, err)})
+			return
+		}
+
+		if !healthy {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, w.maxBackoff)
+		}
+	}
+}
+
+// once re-lists the pods and watches them from the listed resourceVersion until the watch
+// ends. It reports whether the watch was healthy: it ended without error after delivering
+// events or staying open for a while, as when its server-side timeout ends it.
+func (w *podWatch) once(ctx context.Context) (bool, error) {
+	resourceVersion, err := w.relist(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	opts := w.opts
+	opts.ResourceVersion = resourceVersion
+	opts.TimeoutSeconds = ptr(int64(watchTimeoutSeconds))
+	watcher, err := w.m.clientset.CoreV1().Pods(w.m.agentNamespace).Watch(ctx, opts)
+	if err != nil {
+		return false, fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Stop()
+
+	opened := time.Now()
+	delivered := false
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				if delivered || time.Since(opened) >= w.maxBackoff {
+					return true, nil
+				}
+				return false, errWatchClosed
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+				if pod, ok := event.Object.(*corev1.Pod); ok && w.matches(pod) {
+					delivered = true
+					if !w.send(ctx, event.Type, pod) {
+						return false, ctx.Err()
+					}
+				}
+			case watch.Error:
+				// A "too old resource version" error is resolved by the next re-list
+				return false, apierrors.FromObject(event.Object)
+			}
+		}
+	}
+}
+
+// relist lists the pods, sends the changes missed since they were last seen, and returns
+// the resourceVersion to watch from. It fails with errPodGone once the pod or Deployment
+// is gone.
+func (w *podWatch) relist(ctx context.Context) (string, error) {
+	list, err := w.m.clientset.CoreV1().Pods(w.m.agentNamespace).List(ctx, w.opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	listed := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if !w.matches(pod) {
+			continue
+		}
+		listed[pod.Name] = true
+		eventType := watch.Added
+		if _, ok := w.seen[pod.Name]; ok {
+			eventType = watch.Modified
+		}
+		if !w.send(ctx, eventType, pod) {
+			return "", ctx.Err()
+		}
+	}
+	for name, pod := range w.seen {
+		if !listed[name] && !w.send(ctx, watch.Deleted, pod) {
+			return "", ctx.Err()
+		}
+	}
+
+	if len(listed) == 0 {
+		if !w.m.deployments() {
+			return "", errPodGone
+		}
+		_, err := w.m.clientset.AppsV1().Deployments(w.m.agentNamespace).Get(ctx, w.podID.Name(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return "", errPodGone
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get deployment: %w", err)
+		}
+	}
+	return list.ResourceVersion, nil
+}
+
+// matches reports whether pod is one of the watched pods: a pod the platform manages, which
+// outside deployment mode is named after the agent
+func (w *podWatch) matches(pod *corev1.Pod) bool {
+	if !isManaged(pod.Labels) {
+		return false
+	}
+	return w.m.deployments() || pod.Name == w.podID.Name()
+}
+
+// send sends a pod event, unless it repeats the pod's last state sent. It reports false if
+// ctx ended first.
+func (w *podWatch) send(ctx context.Context, eventType watch.EventType, pod *corev1.Pod) bool {
+	last, ok := w.seen[pod.Name]
+	if eventType == watch.Deleted {
+		if !ok {
+			return true
+		}
+		delete(w.seen, pod.Name)
+	} else {
+		if ok && pod.ResourceVersion != "" && pod.ResourceVersion == last.ResourceVersion {
+			return true
+		}
+		w.seen[pod.Name] = pod
+	}
+	return w.emit(ctx, PodEvent{Type: eventType, Pod: pod})
+}
+
+// emit sends event unless ctx ends first, as it does once the consumer stops reading. It
+// reports whether the event was sent.
+func (w *podWatch) emit(ctx context.Context, event PodEvent) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retriableWatchError reports whether a failed list or watch may succeed if retried
+func retriableWatchError(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsUnexpectedServerError(err) || isConnectionError(err)
+}
+
+// isConnectionError reports whether err is not an API status, as when the API server
+// cannot be reached
+func isConnectionError(err error) bool {
+	var status apierrors.APIStatus
+	return !stderrors.As(err, &status)
+}
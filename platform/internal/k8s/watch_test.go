@@ -0,0 +1,227 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// podAt returns pod at resourceVersion rv with the given phase
+func podAt(pod *corev1.Pod, rv string, phase corev1.PodPhase) *corev1.Pod {
+	p := pod.DeepCopy()
+	p.ResourceVersion = rv
+	p.Status.Phase = phase
+	return p
+}
+
+// storePod replaces the pod the fake API server lists
+func storePod(t *testing.T, m *Manager, pod *corev1.Pod) {
+	t.Helper()
+	tracker := m.clientset.(*fake.Clientset).Tracker()
+	if err := tracker.Update(corev1.SchemeGroupVersion.WithResource("pods"), pod, pod.Namespace); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan PodEvent) PodEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("expected an event, the channel was closed")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a pod event")
+		return PodEvent{}
+	}
+}
+
+// expectClosed expects an error event, then the channel to be closed
+func expectClosed(t *testing.T, events <-chan PodEvent) error {
+	t.Helper()
+	event := nextEvent(t, events)
+	if event.Err == nil {
+		t.Fatalf("expected an error event, got %s of %s", event.Type, event.Pod.ResourceVersion)
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("expected the channel to be closed after the error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the channel to be closed")
+	}
+	return event.Err
+}
+
+// resumableWatchManager returns a manager retrying watches without delay, whose pod
+// watches each get a fresh fake watcher
+func resumableWatchManager(t *testing.T, pod *corev1.Pod) (*Manager, <-chan *watch.FakeWatcher) {
+	t.Helper()
+	mgr, watchers, _ := countingWatchManager(t, 0, pod)
+	mgr.watchBackoff = time.Millisecond
+	mgr.watchMaxBackoff = time.Millisecond
+	return mgr, watchers
+}
+
+func TestWatchPod_ResumesAfterClosure(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := podAt(pendingPod("test-ns", podID), "1", corev1.PodPending)
+	mgr, watchers := resumableWatchManager(t, pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := mgr.WatchPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := nextWatcher(t, watchers)
+	running := podAt(pod, "2", corev1.PodRunning)
+	storePod(t, mgr, running)
+	first.Modify(running)
+	if event := nextEvent(t, events); event.Type != watch.Modified || event.Pod.ResourceVersion != "2" {
+		t.Fatalf("expected the pod at 2, got %s at %s", event.Type, event.Pod.ResourceVersion)
+	}
+
+	// The re-list finds the state already sent, which is not sent again
+	first.Stop()
+	second := nextWatcher(t, watchers)
+	second.Modify(podAt(pod, "3", corev1.PodSucceeded))
+	if event := nextEvent(t, events); event.Type != watch.Modified || event.Pod.ResourceVersion != "3" {
+		t.Fatalf("expected the pod at 3 without repeating 2, got %s at %s", event.Type, event.Pod.ResourceVersion)
+	}
+}
+
+func TestWatchPod_RelistSendsMissedChanges(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := podAt(pendingPod("test-ns", podID), "1", corev1.PodPending)
+	mgr, watchers := resumableWatchManager(t, pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := mgr.WatchPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := nextWatcher(t, watchers)
+	// The pod changes while no watch is open
+	storePod(t, mgr, podAt(pod, "2", corev1.PodRunning))
+	first.Stop()
+
+	if event := nextEvent(t, events); event.Type != watch.Modified || event.Pod.ResourceVersion != "2" {
+		t.Fatalf("expected the missed change, got %s at %s", event.Type, event.Pod.ResourceVersion)
+	}
+	nextWatcher(t, watchers)
+}
+
+func TestWatchPod_ExpiredResourceVersionRelists(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := podAt(pendingPod("test-ns", podID), "1", corev1.PodPending)
+	mgr, watchers := resumableWatchManager(t, pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := mgr.WatchPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nextWatcher(t, watchers).Error(&apierrors.NewResourceExpired("too old resource version: 1 (5)").ErrStatus)
+	nextWatcher(t, watchers).Modify(podAt(pod, "6", corev1.PodRunning))
+	if event := nextEvent(t, events); event.Err != nil || event.Pod.ResourceVersion != "6" {
+		t.Fatalf("expected the watch to resume, got %+v", event)
+	}
+}
+
+func TestWatchPod_NonRetriableErrorEnds(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := podAt(pendingPod("test-ns", podID), "1", corev1.PodPending)
+	mgr, watchers := resumableWatchManager(t, pod)
+
+	events, err := mgr.WatchPod(context.Background(), podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forbidden := apierrors.NewForbidden(corev1.Resource("pods"), pod.Name, errors.New("denied"))
+	nextWatcher(t, watchers).Error(&forbidden.ErrStatus)
+	if err := expectClosed(t, events); !apierrors.IsForbidden(err) {
+		t.Errorf("expected the forbidden error, got %v", err)
+	}
+}
+
+func TestWatchPod_GivesUpAfterRetryWindow(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := podAt(pendingPod("test-ns", podID), "1", corev1.PodPending)
+	clientset := fake.NewSimpleClientset(pod)
+	clientset.PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, apierrors.NewGenericServerResponse(http.StatusServiceUnavailable, "watch", corev1.Resource("pods"), "", "", 0, false)
+	})
+	mgr := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	mgr.watchBackoff = time.Millisecond
+	mgr.watchMaxBackoff = 5 * time.Millisecond
+	mgr.watchRetryWindow = 50 * time.Millisecond
+
+	events, err := mgr.WatchPod(context.Background(), podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expectClosed(t, events); !apierrors.IsServiceUnavailable(err) {
+		t.Errorf("expected the watch to give up with the last error, got %v", err)
+	}
+}
+
+func TestWatchPod_EndsOncePodIsGone(t *testing.T) {
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := podAt(pendingPod("test-ns", podID), "1", corev1.PodPending)
+	mgr, watchers := resumableWatchManager(t, pod)
+	clientset := mgr.clientset.(*fake.Clientset)
+
+	events, err := mgr.WatchPod(context.Background(), podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The deletion is missed by the open watch
+	first := nextWatcher(t, watchers)
+	if err := clientset.CoreV1().Pods("test-ns").Delete(context.Background(), pod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	first.Stop()
+
+	if event := nextEvent(t, events); event.Type != watch.Deleted || event.Pod.Name != pod.Name {
+		t.Fatalf("expected the deletion found by the re-list, got %+v", event)
+	}
+	if err := expectClosed(t, events); !errors.Is(err, errPodGone) {
+		t.Errorf("expected the watch to end with the pod gone, got %v", err)
+	}
+}
+
+func TestWatchPod_CancelWithoutReadingDoesNotLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	pod := podAt(pendingPod("test-ns", podID), "1", corev1.PodPending)
+	mgr, watchers := resumableWatchManager(t, pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := mgr.WatchPod(ctx, podID); err != nil {
+		t.Fatal(err)
+	}
+	// The watch blocks sending this event, which the consumer never reads
+	nextWatcher(t, watchers).Modify(podAt(pod, "2", corev1.PodRunning))
+	cancel()
+}
@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultWorkspaceMountPath is where the agent's workspace volume is mounted by default,
+// the agent's working directory
+const DefaultWorkspaceMountPath = "/home/agent/workspace"
+
+// WorkspaceRetainAnnotation marks a workspace claim kept when its agent is deleted
+const WorkspaceRetainAnnotation = "agent-workspace-retain"
+
+// workspaceVolumeName names the workspace volume in agent pods
+const workspaceVolumeName = "workspace"
+
+// Workspace is a persistent volume holding an agent's workspace, so that its files survive
+// the agent's pod being replaced
+type Workspace struct {
+	Size resource.Quantity
+	// StorageClass is the claim's storage class; empty uses the cluster's default
+	StorageClass string
+	// Retain keeps the claim when the agent is deleted
+	Retain bool
+}
+
+// WorkspaceClaimName returns the name of podID's workspace claim
+func WorkspaceClaimName(podID PodID) string {
+	return podID.Name() + "-workspace"
+}
+
+// ensureWorkspace creates podID's workspace claim, or reuses the one left by a previous pod
+// of the agent. It reports whether it created the claim.
+func (m *Manager) ensureWorkspace(ctx context.Context, podID PodID, ws Workspace) (bool, error) {
+	claims := m.clientset.CoreV1().PersistentVolumeClaims(m.agentNamespace)
+	if _, err := claims.Get(ctx, WorkspaceClaimName(podID), metav1.GetOptions{}); err == nil {
+		return false, nil
+	} else if !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get workspace claim %s: %w", WorkspaceClaimName(podID), err)
+	}
+
+	if _, err := claims.Create(ctx, workspaceClaim(podID, ws), metav1.CreateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to create workspace claim %s: %w", WorkspaceClaimName(podID), err)
+	}
+	return true, nil
+}
+
+// workspaceClaim builds podID's workspace claim
+func workspaceClaim(podID PodID, ws Workspace) *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   WorkspaceClaimName(podID),
+			Labels: podSelector(podID),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: ws.Size},
+			},
+		},
+	}
+	if ws.StorageClass != "" {
+		claim.Spec.StorageClassName = ptr(ws.StorageClass)
+	}
+	if ws.Retain {
+		claim.Annotations = map[string]string{WorkspaceRetainAnnotation: "true"}
+	}
+	return claim
+}
+
+// mountWorkspace mounts podID's workspace claim into pod's agent container
+func (m *Manager) mountWorkspace(pod *corev1.Pod, podID PodID) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: workspaceVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: WorkspaceClaimName(podID)},
+		},
+	})
+	mountPath := m.workspacePath()
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == AgentContainerName {
+			pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      workspaceVolumeName,
+				MountPath: mountPath,
+			})
+		}
+	}
+}
+
+// workspacePath returns where workspaces are mounted in agent containers
+func (m *Manager) workspacePath() string {
+	if m.workspaceMountPath == "" {
+		return DefaultWorkspaceMountPath
+	}
+	return m.workspaceMountPath
+}
+
+// hasWorkspace reports whether pod mounts a workspace claim
+func hasWorkspace(pod *corev1.Pod) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == workspaceVolumeName && v.PersistentVolumeClaim != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteWorkspace deletes podID's workspace claim, unless it is retained. An agent without
+// one is not an error.
+func (m *Manager) deleteWorkspace(ctx context.Context, podID PodID) error {
+	claims := m.clientset.CoreV1().PersistentVolumeClaims(m.agentNamespace)
+	claim, err := claims.Get(ctx, WorkspaceClaimName(podID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get workspace claim %s: %w", WorkspaceClaimName(podID), err)
+	}
+	if claim.Annotations[WorkspaceRetainAnnotation] == "true" {
+		return nil
+	}
+	if err := claims.Delete(ctx, claim.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete workspace claim %s: %w", claim.Name, err)
+	}
+	return nil
+}
+
+// deleteWorkspacesForUser deletes the workspace claims of userID's agents that are not retained
+func (m *Manager) deleteWorkspacesForUser(ctx context.Context, userID string) error {
+	claims := m.clientset.CoreV1().PersistentVolumeClaims(m.agentNamespace)
+	list, err := claims.List(ctx, metav1.ListOptions{LabelSelector: UserIDLabel(userID)})
+	if err != nil {
+		return fmt.Errorf("failed to list workspace claims for user %s: %w", userID, err)
+	}
+	for _, claim := range list.Items {
+		if claim.Annotations[WorkspaceRetainAnnotation] == "true" {
+			continue
+		}
+		if err := claims.Delete(ctx, claim.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete workspace claim %s: %w", claim.Name, err)
+		}
+	}
+	return nil
+}
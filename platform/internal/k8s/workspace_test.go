@@ -0,0 +1,147 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newWorkspaceManager() (*Manager, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset()
+	m := NewManagerWithClientset(clientset, "test-ns", "test-image:latest", "")
+	m.workspaceMountPath = "/workspace"
+	return m, clientset
+}
+
+func getClaim(t *testing.T, clientset *fake.Clientset, podID PodID) (*corev1.PersistentVolumeClaim, error) {
+	t.Helper()
+	return clientset.CoreV1().PersistentVolumeClaims("test-ns").Get(context.Background(), WorkspaceClaimName(podID), metav1.GetOptions{})
+}
+
+func TestCreatePodWithOptions_Workspace(t *testing.T) {
+	m, clientset := newWorkspaceManager()
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	err := m.CreatePodWithOptions(ctx, podID, PodOptions{Workspace: &Workspace{
+		Size:         resource.MustParse("5Gi"),
+		StorageClass: "fast",
+	}})
+	if err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+
+	claim, err := getClaim(t, clientset, podID)
+	if err != nil {
+		t.Fatalf("expected a workspace claim: %v", err)
+	}
+	size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size.String() != "5Gi" || claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName != "fast" ||
+		len(claim.Spec.AccessModes) != 1 || claim.Spec.AccessModes[0] != corev1.ReadWriteOnce {
+		t.Errorf("expected a 5Gi ReadWriteOnce claim of class fast, got %+v", claim.Spec)
+	}
+	if claim.Labels["user-id"] != "user-1" || claim.Labels["agent-id"] != "agent-1" {
+		t.Errorf("expected the claim to carry the agent's labels, got %v", claim.Labels)
+	}
+
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].PersistentVolumeClaim == nil ||
+		pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName != WorkspaceClaimName(podID) {
+		t.Fatalf("expected the claim as a volume, got %+v", pod.Spec.Volumes)
+	}
+	mounts := pod.Spec.Containers[0].VolumeMounts
+	if len(mounts) != 1 || mounts[0].Name != pod.Spec.Volumes[0].Name || mounts[0].MountPath != "/workspace" {
+		t.Errorf("expected the workspace mounted at /workspace, got %+v", mounts)
+	}
+}
+
+func TestClosePod_DeletesOrRetainsWorkspace(t *testing.T) {
+	for _, retain := range []bool{false, true} {
+		m, clientset := newWorkspaceManager()
+		podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+		ctx := context.Background()
+
+		ws := &Workspace{Size: resource.MustParse("1Gi"), Retain: retain}
+		if err := m.CreatePodWithOptions(ctx, podID, PodOptions{Workspace: ws}); err != nil {
+			t.Fatalf("failed to create pod: %v", err)
+		}
+		if err := m.ClosePod(ctx, podID); err != nil {
+			t.Fatalf("failed to close pod: %v", err)
+		}
+
+		_, err := getClaim(t, clientset, podID)
+		if retain && err != nil {
+			t.Errorf("expected a retained claim to be kept, got %v", err)
+		}
+		if !retain && !apierrors.IsNotFound(err) {
+			t.Errorf("expected the claim to be deleted, got %v", err)
+		}
+	}
+}
+
+func TestClosePod_WithoutWorkspace(t *testing.T) {
+	m, _ := newWorkspaceManager()
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	if err := m.CreatePod(context.Background(), podID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ClosePod(context.Background(), podID); err != nil {
+		t.Errorf("expected an agent without a workspace to close, got %v", err)
+	}
+}
+
+func TestRestartPod_ReusesWorkspace(t *testing.T) {
+	m, clientset := newWorkspaceManager()
+	podID := PodID{UserID: "user-1", AgentID: "agent-1"}
+	ctx := context.Background()
+
+	ws := &Workspace{Size: resource.MustParse("1Gi")}
+	if err := m.CreatePodWithOptions(ctx, podID, PodOptions{Workspace: ws}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+	before, err := getClaim(t, clientset, podID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mark the claim to tell it from one created again
+	before.Annotations = map[string]string{"test": "original"}
+	if _, err := clientset.CoreV1().PersistentVolumeClaims("test-ns").Update(ctx, before, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restart waits for the old pod's deletion to be watched
+	watchers := make(chan *watch.FakeWatcher, 1)
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+	clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		go func() { (<-watchers).Delete(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podID.Name()}}) }()
+		return false, nil, nil
+	})
+
+	if err := m.RestartPod(ctx, podID); err != nil {
+		t.Fatalf("failed to restart pod: %v", err)
+	}
+
+	after, err := getClaim(t, clientset, podID)
+	if err != nil || after.Annotations["test"] != "original" {
+		t.Errorf("expected the original claim to survive the restart, got %v, %v", after, err)
+	}
+	pod, err := m.GetPod(ctx, podID)
+	if err != nil || !hasWorkspace(pod) {
+		t.Errorf("expected the new pod to mount the workspace, got %v, %v", pod, err)
+	}
+}
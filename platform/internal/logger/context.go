@@ -0,0 +1,37 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// HeaderRequestID carries the ID of the API request a call is made for, on responses to
+// clients and on RPCs to agents
+const HeaderRequestID = "X-Request-Id"
+
+type (
+	loggerContextKey    struct{}
+	requestIDContextKey struct{}
+)
+
+// WithRequest returns ctx carrying the request ID of the API request it serves, and a
+// logger that tags its entries with it
+func WithRequest(ctx context.Context, logger *zap.Logger, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDContextKey{}, requestID)
+	return context.WithValue(ctx, loggerContextKey{}, logger.With(zap.String("request_id", requestID)))
+}
+
+// FromContext returns the request-scoped logger of ctx, or fallback if ctx has none
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// RequestIDFrom returns the request ID ctx carries, or "" if it has none
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
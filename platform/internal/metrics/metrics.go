@@ -0,0 +1,369 @@
+package metrics
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.uber.org/fx"
+)
+
+// namespace prefixes the names of the platform's metrics
+const namespace = "forge"
+
+// Outcomes of the operations metrics are recorded for
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// circuitHashChars is how many hex characters of a circuit's ID label its open circuit
+// gauge, bounding the series to 16^circuitHashChars whatever the number of webhook URLs
+const circuitHashChars = 2
+
+// Module provides the metrics registry and the platform's metrics to the fx container
+var Module = fx.Module("metrics",
+	fx.Provide(NewRegistry),
+	fx.Provide(newMetrics),
+)
+
+// NewRegistry creates a registry holding the Go runtime and process collectors
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// newMetrics creates the platform's metrics in the registry of the fx container
+func newMetrics(reg *prometheus.Registry) *Metrics {
+	return New(reg)
+}
+
+// Metrics are the platform's metrics. A nil *Metrics records nothing, so components
+// constructed without one (e.g. in tests) need no checks.
+type Metrics struct {
+	reg prometheus.Registerer
+
+	agentPods        *prometheus.CounterVec
+	agentCreate      prometheus.Histogram
+	podReadyWait     *prometheus.HistogramVec
+	webhookAttempts  *prometheus.CounterVec
+	agentRPCErrors   *prometheus.CounterVec
+	messageStreams   prometheus.Gauge
+	agentFailures    prometheus.Counter
+	agentRestarts    *prometheus.CounterVec
+	agentConnDials   *prometheus.CounterVec
+	agentConnReuses  prometheus.Counter
+	deliveriesPurged *prometheus.CounterVec
+	podCacheStale    prometheus.Gauge
+	podCacheRebuilds prometheus.Counter
+}
+
+// New creates the platform's metrics and registers them with reg
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		reg: reg,
+		agentPods: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "agent_pod_operations_total",
+			Help:      "Agent pod creations and deletions, by operation and outcome.",
+		}, []string{"operation", "outcome"}),
+		agentCreate: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "agent_create_duration_seconds",
+			Help:      "Time to create an agent until its pod is ready, for successful creations.",
+			Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+		}),
+		podReadyWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "agent_pod_ready_wait_seconds",
+			Help:      "Time spent in WaitForPodReady, by outcome.",
+			Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300},
+		}, []string{"outcome"}),
+		webhookAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_delivery_attempts_total",
+			Help:      `Webhook delivery attempts, by response status code or "error" if there was no response.`,
+		}, []string{"status"}),
+		agentRPCErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "agent_rpc_errors_total",
+			Help:      "Failed RPCs to agents, by Connect error code.",
+		}, []string{"code"}),
+		messageStreams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "agent_message_streams",
+			Help:      "Message streams open to agents.",
+		}),
+		agentFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "agent_failures_detected_total",
+			Help:      "Running agents the health monitor found dead.",
+		}),
+		agentRestarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "agent_restarts_total",
+			Help:      "Restarts of dead agents by the health monitor, by outcome.",
+		}, []string{"outcome"}),
+		agentConnDials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "agent_connection_dials_total",
+			Help:      "Connections dialed to agents, by outcome.",
+		}, []string{"outcome"}),
+		agentConnReuses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "agent_connection_reuses_total",
+			Help:      "RPCs to agents sent over an already open connection.",
+		}),
+		deliveriesPurged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_deliveries_purged_total",
+			Help:      "Webhook delivery records deleted by retention, by delivery status.",
+		}, []string{"status"}),
+		podCacheStale: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "agent_pod_cache_staleness_seconds",
+			Help:      "How long the agent pod cache has disagreed with the API server, 0 while it agrees.",
+		}),
+		podCacheRebuilds: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "agent_pod_cache_rebuilds_total",
+			Help:      "Rebuilds of the agent pod cache after it went stale.",
+		}),
+	}
+	reg.MustRegister(m.agentPods, m.agentCreate, m.podReadyWait, m.webhookAttempts, m.agentRPCErrors, m.messageStreams,
+		m.agentFailures, m.agentRestarts, m.agentConnDials, m.agentConnReuses, m.deliveriesPurged,
+		m.podCacheStale, m.podCacheRebuilds)
+	return m
+}
+
+// outcome returns the outcome of an operation that returned err
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// AgentCreated records an agent creation that took took and returned err
+func (m *Metrics) AgentCreated(took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.agentPods.WithLabelValues("create", outcome(err)).Inc()
+	if err == nil {
+		m.agentCreate.Observe(took.Seconds())
+	}
+}
+
+// AgentDeleted records an agent deletion that returned err
+func (m *Metrics) AgentDeleted(err error) {
+	if m == nil {
+		return
+	}
+	m.agentPods.WithLabelValues("delete", outcome(err)).Inc()
+}
+
+// AgentFailureDetected records a running agent found dead
+func (m *Metrics) AgentFailureDetected() {
+	if m == nil {
+		return
+	}
+	m.agentFailures.Inc()
+}
+
+// AgentRestarted records a restart of a dead agent that returned err
+func (m *Metrics) AgentRestarted(err error) {
+	if m == nil {
+		return
+	}
+	m.agentRestarts.WithLabelValues(outcome(err)).Inc()
+}
+
+// AgentConnDialed records a connection dial to an agent that returned err
+func (m *Metrics) AgentConnDialed(err error) {
+	if m == nil {
+		return
+	}
+	m.agentConnDials.WithLabelValues(outcome(err)).Inc()
+}
+
+// DeliveriesPurged records n webhook delivery records with the given status deleted by retention
+func (m *Metrics) DeliveriesPurged(status string, n int64) {
+	if m == nil {
+		return
+	}
+	m.deliveriesPurged.WithLabelValues(status).Add(float64(n))
+}
+
+// AgentConnReused records an RPC to an agent sent over an already open connection
+func (m *Metrics) AgentConnReused() {
+	if m == nil {
+		return
+	}
+	m.agentConnReuses.Inc()
+}
+
+// PodCacheStale records how long the agent pod cache has disagreed with the API server,
+// 0 once it agrees again
+func (m *Metrics) PodCacheStale(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.podCacheStale.Set(d.Seconds())
+}
+
+// PodCacheRebuilt records a rebuild of the agent pod cache after it went stale
+func (m *Metrics) PodCacheRebuilt() {
+	if m == nil {
+		return
+	}
+	m.podCacheRebuilds.Inc()
+}
+
+// PodReadyWaited records a WaitForPodReady call that took took and returned err
+func (m *Metrics) PodReadyWaited(took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.podReadyWait.WithLabelValues(outcome(err)).Observe(took.Seconds())
+}
+
+// WebhookAttempted records a webhook delivery attempt answered with statusCode, or 0 if
+// it got no response
+func (m *Metrics) WebhookAttempted(statusCode int) {
+	if m == nil {
+		return
+	}
+	status := OutcomeError
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	m.webhookAttempts.WithLabelValues(status).Inc()
+}
+
+// MessageStreamOpened records a message stream opened to an agent. The returned func
+// records it closed, and must be called once.
+func (m *Metrics) MessageStreamOpened() (closed func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.messageStreams.Inc()
+	return m.messageStreams.Dec
+}
+
+// ObserveWebSocketConnections exports the number of open WebSocket connections, as
+// reported by count at each scrape
+func (m *Metrics) ObserveWebSocketConnections(count func() int) {
+	if m == nil {
+		return
+	}
+	m.reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "WebSocket connections open to agent streams.",
+	}, func() float64 { return float64(count()) }))
+}
+
+// ObserveOpenCircuits exports the number of open webhook circuit breakers, as reported by
+// openIDs at each scrape. Circuits are counted per url_hash, the first characters of
+// their hex ID, so that the number of series stays bounded.
+func (m *Metrics) ObserveOpenCircuits(openIDs func() []string) {
+	if m == nil {
+		return
+	}
+	m.reg.MustRegister(&openCircuitsCollector{
+		openIDs: openIDs,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "webhook", "open_circuits"),
+			"Open webhook circuit breakers, by a prefix of the hashed webhook URL.",
+			[]string{"url_hash"}, nil,
+		),
+	})
+}
+
+// openCircuitsCollector collects the open circuit gauge from the circuits open at scrape
+// time, so circuits that closed leave no stale series
+type openCircuitsCollector struct {
+	openIDs func() []string
+	desc    *prometheus.Desc
+}
+
+func (c *openCircuitsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *openCircuitsCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[string]int)
+	for _, id := range c.openIDs() {
+		if len(id) > circuitHashChars {
+			id = id[:circuitHashChars]
+		}
+		counts[id]++
+	}
+	for hash, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), hash)
+	}
+}
+
+// AgentRPCInterceptor returns a client interceptor counting the RPCs to agents that fail,
+// by error code. Streams count the error their responses end with, other than io.EOF.
+func (m *Metrics) AgentRPCInterceptor() connect.Interceptor {
+	return rpcErrorInterceptor{m: m}
+}
+
+// rpcError records a failed agent RPC
+func (m *Metrics) rpcError(err error) {
+	if m == nil || err == nil || stderrors.Is(err, io.EOF) {
+		return
+	}
+	m.agentRPCErrors.WithLabelValues(connect.CodeOf(err).String()).Inc()
+}
+
+type rpcErrorInterceptor struct {
+	m *Metrics
+}
+
+func (i rpcErrorInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		resp, err := next(ctx, req)
+		if req.Spec().IsClient {
+			i.m.rpcError(err)
+		}
+		return resp, err
+	}
+}
+
+func (i rpcErrorInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		return &rpcErrorConn{StreamingClientConn: next(ctx, spec), m: i.m}
+	}
+}
+
+func (i rpcErrorInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+// rpcErrorConn records the error a stream's responses end with, once
+type rpcErrorConn struct {
+	connect.StreamingClientConn
+	m      *Metrics
+	failed bool
+}
+
+func (c *rpcErrorConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	if err != nil && !c.failed {
+		c.failed = true
+		c.m.rpcError(err)
+	}
+	return err
+}
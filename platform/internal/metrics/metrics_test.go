@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/gen/agent/v1/agentv1connect"
+)
+
+func TestMetrics_RecordAgentOperations(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+
+	m.AgentCreated(3*time.Second, nil)
+	m.AgentCreated(time.Minute, errors.New("pod never became ready"))
+	m.AgentDeleted(nil)
+	closed := m.MessageStreamOpened()
+	m.MessageStreamOpened()
+	closed()
+
+	want := `
+# HELP forge_agent_pod_operations_total Agent pod creations and deletions, by operation and outcome.
+# TYPE forge_agent_pod_operations_total counter
+forge_agent_pod_operations_total{operation="create",outcome="error"} 1
+forge_agent_pod_operations_total{operation="create",outcome="success"} 1
+forge_agent_pod_operations_total{operation="delete",outcome="success"} 1
+# HELP forge_agent_message_streams Message streams open to agents.
+# TYPE forge_agent_message_streams gauge
+forge_agent_message_streams 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "forge_agent_pod_operations_total", "forge_agent_message_streams"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(m.agentCreate); n != 1 {
+		t.Errorf("expected the create histogram collected, got %d series", n)
+	}
+}
+
+func TestMetrics_NilRecordsNothing(t *testing.T) {
+	var m *Metrics
+	m.AgentCreated(time.Second, nil)
+	m.AgentFailureDetected()
+	m.AgentRestarted(nil)
+	m.AgentConnDialed(nil)
+	m.AgentConnReused()
+	m.PodCacheStale(time.Minute)
+	m.PodCacheRebuilt()
+	m.WebhookAttempted(http.StatusOK)
+	m.ObserveWebSocketConnections(func() int { return 1 })
+	m.MessageStreamOpened()()
+	m.rpcError(connect.NewError(connect.CodeUnavailable, errors.New("down")))
+}
+
+func TestMetrics_OpenCircuitsAreBucketed(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+	open := []string{"ab01", "ab02", "cd03"}
+	m.ObserveOpenCircuits(func() []string { return open })
+
+	want := `
+# HELP forge_webhook_open_circuits Open webhook circuit breakers, by a prefix of the hashed webhook URL.
+# TYPE forge_webhook_open_circuits gauge
+forge_webhook_open_circuits{url_hash="ab"} 2
+forge_webhook_open_circuits{url_hash="cd"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "forge_webhook_open_circuits"); err != nil {
+		t.Error(err)
+	}
+
+	// Circuits that closed leave no series behind
+	open = nil
+	if n, err := testutil.GatherAndCount(reg, "forge_webhook_open_circuits"); err != nil || n != 0 {
+		t.Errorf("expected no series once circuits closed, got %d (%v)", n, err)
+	}
+}
+
+func TestAgentRPCInterceptor_CountsErrorsByCode(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle(agentv1connect.NewAgentServiceHandler(agentv1connect.UnimplementedAgentServiceHandler{}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+	client := agentv1connect.NewAgentServiceClient(http.DefaultClient, server.URL, connect.WithInterceptors(m.AgentRPCInterceptor()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for range 2 {
+		if _, err := client.GetStatus(ctx, connect.NewRequest(&agentv1.GetStatusRequest{})); err == nil {
+			t.Fatal("expected GetStatus unimplemented")
+		}
+	}
+
+	want := `
+# HELP forge_agent_rpc_errors_total Failed RPCs to agents, by Connect error code.
+# TYPE forge_agent_rpc_errors_total counter
+forge_agent_rpc_errors_total{code="unimplemented"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "forge_agent_rpc_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
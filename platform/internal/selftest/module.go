@@ -0,0 +1,53 @@
+package selftest
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/agent/processor"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// Module provides the self test runner to the fx container
+var Module = fx.Module("selftest",
+	fx.Provide(newRunner),
+)
+
+// newRunner creates a Runner using configuration from the fx container. When an interval
+// is configured the self test also runs on that schedule while the app runs. On stop, a
+// running self test is cancelled and its agent deleted before the app stops.
+func newRunner(lc fx.Lifecycle, p *processor.Processor, webhookDelivery *webhook.DeliveryService, pool *pgxpool.Pool, cfg *config.Config, logger *zap.Logger) *Runner {
+	r := NewRunner(p, webhookDelivery, sqlc.New(pool), configFrom(cfg), logger)
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			if r.cfg.Interval > 0 {
+				r.wg.Add(1)
+				go func() {
+					defer r.wg.Done()
+					r.runSchedule(r.ctx, r.cfg.Interval)
+				}()
+			}
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			done := make(chan struct{})
+			go func() {
+				r.Stop()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return r
+}
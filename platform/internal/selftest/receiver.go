@@ -0,0 +1,146 @@
+package selftest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/forge/platform/internal/webhook"
+)
+
+// maxReceiverBody caps the webhook bodies the receiver reads
+const maxReceiverBody = 1 << 20
+
+// receiver is the webhook endpoint a self test delivers to. It listens on loopback only
+// and collects the payloads of one request, checking each one's signature.
+type receiver struct {
+	requestID string
+	secret    string
+	listener  net.Listener
+	server    *http.Server
+
+	mu       sync.Mutex
+	payloads []webhook.Payload
+	final    chan webhook.Payload
+	rejected error
+}
+
+// startReceiver starts a receiver for requestID on an ephemeral loopback port
+func startReceiver(requestID, secret string) (*receiver, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listening on loopback: %w", err)
+	}
+	r := &receiver{
+		requestID: requestID,
+		secret:    secret,
+		listener:  listener,
+		final:     make(chan webhook.Payload, 1),
+	}
+	r.server = &http.Server{Handler: http.HandlerFunc(r.handle)}
+	go func() { _ = r.server.Serve(listener) }()
+	return r, nil
+}
+
+// Addr returns the host:port the receiver listens on
+func (r *receiver) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// URL returns the webhook URL of the receiver
+func (r *receiver) URL() string {
+	return "http://" + r.Addr() + "/webhook"
+}
+
+// handle accepts one delivered payload
+func (r *receiver) handle(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxReceiverBody))
+	if err != nil {
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+	if !r.validSignature(req.Header, body) {
+		r.reject(errors.New("webhook signature did not match"))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var payload webhook.Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.reject(fmt.Errorf("webhook body is not a payload: %w", err))
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	// Deliveries of other requests cannot reach a per-run port, but are ignored regardless
+	if payload.RequestID != r.requestID {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	r.mu.Lock()
+	r.payloads = append(r.payloads, payload)
+	r.mu.Unlock()
+	if payload.IsFinal {
+		select {
+		case r.final <- payload:
+		default:
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// validSignature checks the X-Forge-Signature of a delivery against the receiver's secret
+func (r *receiver) validSignature(header http.Header, body []byte) bool {
+	ok, err := webhook.VerifySignature(header.Get(webhook.TimestampHeader), body, r.secret, header.Get(webhook.SignatureHeader))
+	return ok && err == nil
+}
+
+// reject records the first delivery the receiver refused
+func (r *receiver) reject(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rejected == nil {
+		r.rejected = err
+	}
+}
+
+// awaitFinal waits for the request's final payload. It fails if the final payload reports
+// an error or an unsuccessful completion, or, once ctx is done, with the reason no final
+// payload arrived.
+func (r *receiver) awaitFinal(ctx context.Context) (webhook.Payload, error) {
+	select {
+	case payload := <-r.final:
+		if payload.EventType == webhook.EventTypeError {
+			return payload, fmt.Errorf("agent reported an error: %s", payloadError(payload))
+		}
+		if !payload.Success {
+			return payload, errors.New("agent completed without success")
+		}
+		return payload, nil
+	case <-ctx.Done():
+		r.mu.Lock()
+		received, rejected := len(r.payloads), r.rejected
+		r.mu.Unlock()
+		if rejected != nil {
+			return webhook.Payload{}, fmt.Errorf("no final webhook received (%d payloads accepted): %w", received, rejected)
+		}
+		return webhook.Payload{}, fmt.Errorf("no final webhook received (%d payloads accepted): %w", received, ctx.Err())
+	}
+}
+
+// payloadError returns the error message of an error payload
+func payloadError(payload webhook.Payload) string {
+	if payload.Error != nil {
+		return payload.Error.Code + ": " + payload.Error.Message
+	}
+	return "unknown error"
+}
+
+// Close stops the receiver
+func (r *receiver) Close() error {
+	return r.server.Close()
+}
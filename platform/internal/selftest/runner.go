@@ -0,0 +1,359 @@
+// Package selftest runs an end-to-end smoke test of a deployed platform: it creates an
+// agent, sends it a canned message, waits for the request's webhook on a receiver the
+// platform runs itself, and deletes the agent again.
+package selftest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+// ErrRunning is returned when a self test is started while another one is running
+var ErrRunning = errors.New("self test already running")
+
+// ErrNoReport is returned when no self test has been run yet
+var ErrNoReport = errors.New("no self test report")
+
+// Steps of a self test, in the order they run
+const (
+	StepStartReceiver = "start_receiver"
+	StepCreateAgent   = "create_agent"
+	StepSendMessage   = "send_message"
+	StepAwaitWebhook  = "await_webhook"
+	StepDeleteAgent   = "delete_agent"
+)
+
+// Outcomes of a step or a whole self test
+const (
+	OutcomePassed  = "passed"
+	OutcomeFailed  = "failed"
+	OutcomeSkipped = "skipped" // an earlier step failed
+)
+
+// What started a self test
+const (
+	TriggerManual    = "manual"
+	TriggerScheduled = "scheduled"
+)
+
+// cannedMessage is the message sent to the self test agent
+const cannedMessage = "This is a platform self test. Reply with the single word OK."
+
+// cleanupTimeout bounds deleting the agent, which runs even after the self test timed out
+const cleanupTimeout = 30 * time.Second
+
+// reportRetention is how long self test reports are kept
+const reportRetention = 30 * 24 * time.Hour
+
+// StepResult is how one step of a self test went
+type StepResult struct {
+	Step       string `json:"step"`
+	Outcome    string `json:"outcome"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report is the result of a self test run
+type Report struct {
+	Trigger    string       `json:"trigger"`
+	Outcome    string       `json:"outcome"`
+	Image      string       `json:"image,omitempty"` // empty for the configured agent image
+	AgentID    string       `json:"agent_id,omitempty"`
+	RequestID  string       `json:"request_id"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	DurationMs int64        `json:"duration_ms"`
+	Steps      []StepResult `json:"steps"`
+}
+
+// Agents is the part of the agent processor a self test runs through
+type Agents interface {
+	CreateAgentWithImage(ctx context.Context, userID, image string) (*k8s.PodID, error)
+	SendMessageWithWebhook(ctx context.Context, userID, agentID, requestID, content string, webhookCfg webhook.Config) error
+	DeleteAgent(ctx context.Context, userID, agentID string, graceful bool) error
+}
+
+// addressExempter lets webhook deliveries reach the receiver despite the URL policy
+type addressExempter interface {
+	ExemptAddress(addr string) (release func())
+}
+
+// reportStore persists self test reports
+type reportStore interface {
+	InsertSelftestReport(ctx context.Context, arg *sqlc.InsertSelftestReportParams) error
+	GetLatestSelftestReport(ctx context.Context) (*sqlc.SelftestReport, error)
+	DeleteSelftestReportsBefore(ctx context.Context, createdAt time.Time) (int64, error)
+}
+
+// Config holds how self tests are run
+type Config struct {
+	// Image is the agent image the self test agent runs; empty uses the configured agent image
+	Image string
+	// UserID owns the self test agents
+	UserID string
+	// Timeout bounds a run up to receiving the webhook; deleting the agent has its own bound
+	Timeout time.Duration
+	// Interval runs the self test on a schedule; 0 runs it only on demand
+	Interval time.Duration
+}
+
+// configFrom returns the self test settings from cfg
+func configFrom(cfg *config.Config) Config {
+	return Config{
+		Image:    cfg.SelftestAgentImage,
+		UserID:   cfg.SelftestUserID,
+		Timeout:  cfg.SelftestTimeout,
+		Interval: cfg.SelftestInterval,
+	}
+}
+
+// Runner runs self tests, one at a time, and stores their reports
+type Runner struct {
+	agents Agents
+	exempt addressExempter
+	store  reportStore
+	cfg    Config
+	logger *zap.Logger
+	now    func() time.Time
+
+	running atomic.Bool
+
+	// ctx is cancelled when the platform stops, ending runs started in the background
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRunner creates a self test runner
+func NewRunner(agents Agents, exempt addressExempter, store reportStore, cfg Config, logger *zap.Logger) *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		agents: agents,
+		exempt: exempt,
+		store:  store,
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start runs a self test in the background, returning ErrRunning if one is running already
+func (r *Runner) Start(trigger string) error {
+	if !r.running.CompareAndSwap(false, true) {
+		return ErrRunning
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.running.Store(false)
+		r.run(r.ctx, trigger)
+	}()
+	return nil
+}
+
+// Run runs a self test and returns its report, which is also stored. It returns
+// ErrRunning if a self test is running already; a failed self test is not an error.
+func (r *Runner) Run(ctx context.Context, trigger string) (*Report, error) {
+	if !r.running.CompareAndSwap(false, true) {
+		return nil, ErrRunning
+	}
+	defer r.running.Store(false)
+	return r.run(ctx, trigger), nil
+}
+
+// Stop cancels a running self test and waits for it to clean up
+func (r *Runner) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Latest returns the report of the most recent self test
+func (r *Runner) Latest(ctx context.Context) (*Report, error) {
+	row, err := r.store.GetLatestSelftestReport(ctx)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoReport
+		}
+		return nil, fmt.Errorf("getting latest self test report: %w", err)
+	}
+	var report Report
+	if err := json.Unmarshal(row.Report, &report); err != nil {
+		return nil, fmt.Errorf("decoding self test report: %w", err)
+	}
+	return &report, nil
+}
+
+// run runs the steps of a self test. Once a step fails the rest are skipped, except that
+// an agent that was created is always deleted.
+func (r *Runner) run(ctx context.Context, trigger string) *Report {
+	report := &Report{
+		Trigger:   trigger,
+		Outcome:   OutcomePassed,
+		Image:     r.cfg.Image,
+		RequestID: "selftest-" + randomHex(8),
+		StartedAt: r.now().UTC(),
+	}
+	r.logger.Info("starting self test",
+		zap.String("trigger", trigger),
+		zap.String("request_id", report.RequestID),
+	)
+
+	runCtx := ctx
+	if r.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, r.cfg.Timeout)
+		defer cancel()
+	}
+
+	// step runs fn as the named step, unless an earlier step failed and it is not a
+	// cleanup step that always runs
+	step := func(name string, always bool, fn func() error) {
+		if report.Outcome == OutcomeFailed && !always {
+			report.Steps = append(report.Steps, StepResult{Step: name, Outcome: OutcomeSkipped})
+			return
+		}
+		start := r.now()
+		err := fn()
+		result := StepResult{Step: name, Outcome: OutcomePassed, DurationMs: r.now().Sub(start).Milliseconds()}
+		if err != nil {
+			result.Outcome = OutcomeFailed
+			result.Error = err.Error()
+			report.Outcome = OutcomeFailed
+		}
+		report.Steps = append(report.Steps, result)
+	}
+
+	var recv *receiver
+	step(StepStartReceiver, false, func() error {
+		var err error
+		recv, err = startReceiver(report.RequestID, randomHex(32))
+		return err
+	})
+	if recv != nil {
+		defer func() { _ = recv.Close() }()
+		release := r.exempt.ExemptAddress(recv.Addr())
+		defer release()
+	}
+
+	var podID *k8s.PodID
+	step(StepCreateAgent, false, func() error {
+		var err error
+		podID, err = r.agents.CreateAgentWithImage(runCtx, r.cfg.UserID, r.cfg.Image)
+		if err == nil {
+			report.AgentID = podID.AgentID
+		}
+		return err
+	})
+
+	step(StepSendMessage, false, func() error {
+		return r.agents.SendMessageWithWebhook(runCtx, r.cfg.UserID, podID.AgentID, report.RequestID, cannedMessage,
+			webhook.Config{URL: recv.URL(), Secret: recv.secret})
+	})
+
+	step(StepAwaitWebhook, false, func() error {
+		_, err := recv.awaitFinal(runCtx)
+		return err
+	})
+
+	if podID == nil {
+		report.Steps = append(report.Steps, StepResult{Step: StepDeleteAgent, Outcome: OutcomeSkipped})
+	} else {
+		step(StepDeleteAgent, true, func() error {
+			// The run may have timed out or been cancelled, the agent is deleted regardless
+			cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+			defer cancel()
+			return r.agents.DeleteAgent(cleanupCtx, r.cfg.UserID, podID.AgentID, false)
+		})
+	}
+
+	report.FinishedAt = r.now().UTC()
+	report.DurationMs = report.FinishedAt.Sub(report.StartedAt).Milliseconds()
+	r.logReport(report)
+	r.saveReport(report)
+	return report
+}
+
+// logReport logs how a self test went
+func (r *Runner) logReport(report *Report) {
+	fields := []zap.Field{
+		zap.String("trigger", report.Trigger),
+		zap.String("request_id", report.RequestID),
+		zap.String("agent_id", report.AgentID),
+		zap.Int64("duration_ms", report.DurationMs),
+	}
+	if report.Outcome == OutcomePassed {
+		r.logger.Info("self test passed", fields...)
+		return
+	}
+	for _, s := range report.Steps {
+		if s.Outcome == OutcomeFailed {
+			fields = append(fields, zap.String("failed_step", s.Step), zap.String("error", s.Error))
+			break
+		}
+	}
+	r.logger.Error("self test failed", fields...)
+}
+
+// saveReport stores a report and prunes old ones. Failing to store it is only logged;
+// the report is still returned to the caller and logged.
+func (r *Runner) saveReport(report *Report) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	raw, err := json.Marshal(report)
+	if err == nil {
+		err = r.store.InsertSelftestReport(ctx, &sqlc.InsertSelftestReportParams{
+			Trigger: report.Trigger,
+			Outcome: report.Outcome,
+			Report:  raw,
+		})
+	}
+	if err != nil {
+		r.logger.Error("failed to store self test report", zap.Error(err), zap.String("request_id", report.RequestID))
+		return
+	}
+	if _, err := r.store.DeleteSelftestReportsBefore(ctx, r.now().Add(-reportRetention)); err != nil {
+		r.logger.Warn("failed to prune self test reports", zap.Error(err))
+	}
+}
+
+// runSchedule runs a self test every interval until ctx is done. A tick that comes while
+// a self test is still running is skipped.
+func (r *Runner) runSchedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Run(ctx, TriggerScheduled); errors.Is(err, ErrRunning) {
+				r.logger.Warn("skipping scheduled self test, the previous one is still running")
+			}
+		}
+	}
+}
+
+// randomHex returns n random bytes, hex-encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
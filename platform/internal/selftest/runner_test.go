@@ -0,0 +1,365 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/k8s"
+	"github.com/forge/platform/internal/sqlc/gen"
+	"github.com/forge/platform/internal/webhook"
+)
+
+const testImage = "registry.local/forge-agent-selftest:tiny"
+
+// fakeAgents stands in for the processor. Messages are answered by send, which posts
+// webhooks to the receiver the way the outbox would.
+type fakeAgents struct {
+	mu        sync.Mutex
+	images    []string
+	deleted   []string
+	createErr error
+	deleteErr error
+	send      func(ctx context.Context, requestID string, webhookCfg webhook.Config) error
+}
+
+func (f *fakeAgents) CreateAgentWithImage(_ context.Context, userID, image string) (*k8s.PodID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images = append(f.images, image)
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	return k8s.NewPodID(userID, "agent-selftest"), nil
+}
+
+func (f *fakeAgents) SendMessageWithWebhook(ctx context.Context, _, _, requestID, _ string, webhookCfg webhook.Config) error {
+	if f.send == nil {
+		return nil
+	}
+	return f.send(ctx, requestID, webhookCfg)
+}
+
+func (f *fakeAgents) DeleteAgent(ctx context.Context, _, agentID string, _ bool) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, agentID)
+	return f.deleteErr
+}
+
+type fakeExempter struct {
+	mu     sync.Mutex
+	active map[string]bool
+	seen   []string
+}
+
+func (f *fakeExempter) ExemptAddress(addr string) func() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active == nil {
+		f.active = make(map[string]bool)
+	}
+	f.active[addr] = true
+	f.seen = append(f.seen, addr)
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.active, addr)
+	}
+}
+
+type fakeReportStore struct {
+	mu      sync.Mutex
+	reports []*sqlc.SelftestReport
+}
+
+func (f *fakeReportStore) InsertSelftestReport(_ context.Context, arg *sqlc.InsertSelftestReportParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reports = append(f.reports, &sqlc.SelftestReport{
+		ID:        int64(len(f.reports) + 1),
+		Trigger:   arg.Trigger,
+		Outcome:   arg.Outcome,
+		Report:    arg.Report,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+func (f *fakeReportStore) GetLatestSelftestReport(context.Context) (*sqlc.SelftestReport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.reports) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return f.reports[len(f.reports)-1], nil
+}
+
+func (f *fakeReportStore) DeleteSelftestReportsBefore(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+// postPayload delivers a payload to url signed with secret, like the outbox does
+func postPayload(ctx context.Context, url, secret string, payload webhook.Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Forge-Timestamp", timestamp)
+	req.Header.Set("X-Forge-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// replyWith answers the message with an event followed by final, signed with the request's secret
+func replyWith(final webhook.Payload) func(context.Context, string, webhook.Config) error {
+	return func(ctx context.Context, requestID string, webhookCfg webhook.Config) error {
+		event := webhook.Payload{EventType: webhook.EventTypeEvent, RequestID: requestID, Seq: 1}
+		if err := postPayload(ctx, webhookCfg.URL, webhookCfg.Secret, event); err != nil {
+			return err
+		}
+		final.RequestID = requestID
+		final.Seq = 2
+		final.IsFinal = true
+		return postPayload(ctx, webhookCfg.URL, webhookCfg.Secret, final)
+	}
+}
+
+func newTestRunner(agents *fakeAgents, timeout time.Duration) (*Runner, *fakeExempter, *fakeReportStore) {
+	exempt := &fakeExempter{}
+	store := &fakeReportStore{}
+	r := NewRunner(agents, exempt, store, Config{Image: testImage, UserID: "selftest", Timeout: timeout}, zap.NewNop())
+	return r, exempt, store
+}
+
+// outcomes lists each step of a report with its outcome, e.g. "create_agent=passed"
+func outcomes(report *Report) string {
+	parts := make([]string, len(report.Steps))
+	for i, s := range report.Steps {
+		parts[i] = s.Step + "=" + s.Outcome
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestRun_PassesAndCleansUp(t *testing.T) {
+	agents := &fakeAgents{send: replyWith(webhook.Payload{EventType: webhook.EventTypeComplete, Success: true})}
+	r, exempt, _ := newTestRunner(agents, 5*time.Second)
+
+	report, err := r.Run(context.Background(), TriggerManual)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Outcome != OutcomePassed {
+		t.Fatalf("expected the self test to pass, got %s: %+v", report.Outcome, report.Steps)
+	}
+	want := "start_receiver=passed create_agent=passed send_message=passed await_webhook=passed delete_agent=passed"
+	if got := outcomes(report); got != want {
+		t.Errorf("expected steps %q, got %q", want, got)
+	}
+	if len(agents.images) != 1 || agents.images[0] != testImage {
+		t.Errorf("expected the agent to run %s, got %v", testImage, agents.images)
+	}
+	if len(agents.deleted) != 1 || agents.deleted[0] != "agent-selftest" {
+		t.Errorf("expected the agent to be deleted, got %v", agents.deleted)
+	}
+	if len(exempt.seen) != 1 || !strings.HasPrefix(exempt.seen[0], "127.0.0.1:") || len(exempt.active) != 0 {
+		t.Errorf("expected the loopback receiver to be exempted for the run only, got seen %v active %v", exempt.seen, exempt.active)
+	}
+
+	latest, err := r.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if latest.RequestID != report.RequestID || latest.Outcome != OutcomePassed || len(latest.Steps) != 5 {
+		t.Errorf("expected the stored report to match the run, got %+v", latest)
+	}
+}
+
+func TestRun_CreateFailureSkipsRemainingSteps(t *testing.T) {
+	agents := &fakeAgents{createErr: errors.New("image pull backoff")}
+	r, _, store := newTestRunner(agents, 5*time.Second)
+
+	report, _ := r.Run(context.Background(), TriggerScheduled)
+	want := "start_receiver=passed create_agent=failed send_message=skipped await_webhook=skipped delete_agent=skipped"
+	if got := outcomes(report); got != want {
+		t.Errorf("expected steps %q, got %q", want, got)
+	}
+	if report.Outcome != OutcomeFailed || !strings.Contains(report.Steps[1].Error, "image pull backoff") {
+		t.Errorf("expected the create error in a failed report, got %+v", report)
+	}
+	if len(agents.deleted) != 0 {
+		t.Errorf("expected no agent to delete, got %v", agents.deleted)
+	}
+	if len(store.reports) != 1 || store.reports[0].Outcome != OutcomeFailed || store.reports[0].Trigger != TriggerScheduled {
+		t.Errorf("expected the failed report to be stored, got %+v", store.reports)
+	}
+}
+
+func TestRun_CleansUpAfterFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(context.Context, string, webhook.Config) error
+		want string
+		err  string
+	}{
+		{
+			name: "send fails",
+			send: func(context.Context, string, webhook.Config) error { return errors.New("agent unreachable") },
+			want: "send_message=failed await_webhook=skipped delete_agent=passed",
+			err:  "agent unreachable",
+		},
+		{
+			name: "timeout without a final webhook",
+			send: func(ctx context.Context, requestID string, webhookCfg webhook.Config) error {
+				return postPayload(ctx, webhookCfg.URL, webhookCfg.Secret,
+					webhook.Payload{EventType: webhook.EventTypeEvent, RequestID: requestID, Seq: 1})
+			},
+			want: "send_message=passed await_webhook=failed delete_agent=passed",
+			err:  "1 payloads accepted",
+		},
+		{
+			name: "agent reports an error",
+			send: replyWith(webhook.Payload{
+				EventType: webhook.EventTypeError,
+				Error:     &webhook.ErrorPayload{Code: "STREAM_ERROR", Message: "model overloaded"},
+			}),
+			want: "send_message=passed await_webhook=failed delete_agent=passed",
+			err:  "model overloaded",
+		},
+		{
+			name: "signature does not match",
+			send: func(ctx context.Context, requestID string, webhookCfg webhook.Config) error {
+				return postPayload(ctx, webhookCfg.URL, "wrong-secret", webhook.Payload{
+					EventType: webhook.EventTypeComplete, RequestID: requestID, Seq: 1, IsFinal: true, Success: true,
+				})
+			},
+			want: "send_message=passed await_webhook=failed delete_agent=passed",
+			err:  "signature did not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents := &fakeAgents{send: tt.send}
+			r, _, _ := newTestRunner(agents, 200*time.Millisecond)
+
+			report, _ := r.Run(context.Background(), TriggerManual)
+			if report.Outcome != OutcomeFailed {
+				t.Fatalf("expected the self test to fail, got %+v", report.Steps)
+			}
+			if got := outcomes(report); !strings.HasSuffix(got, tt.want) {
+				t.Errorf("expected steps ending %q, got %q", tt.want, got)
+			}
+			var failed StepResult
+			for _, s := range report.Steps {
+				if s.Outcome == OutcomeFailed {
+					failed = s
+				}
+			}
+			if !strings.Contains(failed.Error, tt.err) {
+				t.Errorf("expected the failed step's error to mention %q, got %q", tt.err, failed.Error)
+			}
+			if len(agents.deleted) != 1 {
+				t.Errorf("expected the agent to be deleted after the failure, got %v", agents.deleted)
+			}
+		})
+	}
+}
+
+func TestRun_DeleteFailureFailsReport(t *testing.T) {
+	agents := &fakeAgents{
+		send:      replyWith(webhook.Payload{EventType: webhook.EventTypeComplete, Success: true}),
+		deleteErr: errors.New("forbidden"),
+	}
+	r, _, _ := newTestRunner(agents, 5*time.Second)
+
+	report, _ := r.Run(context.Background(), TriggerManual)
+	if report.Outcome != OutcomeFailed || report.Steps[4].Outcome != OutcomeFailed {
+		t.Errorf("expected a failed cleanup to fail the self test, got %s: %q", report.Outcome, outcomes(report))
+	}
+}
+
+func TestStart_RunsOneAtATime(t *testing.T) {
+	release := make(chan struct{})
+	agents := &fakeAgents{send: func(ctx context.Context, requestID string, webhookCfg webhook.Config) error {
+		<-release
+		return replyWith(webhook.Payload{EventType: webhook.EventTypeComplete, Success: true})(ctx, requestID, webhookCfg)
+	}}
+	r, _, store := newTestRunner(agents, 5*time.Second)
+
+	if err := r.Start(TriggerManual); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := r.Start(TriggerManual); !errors.Is(err, ErrRunning) {
+		t.Errorf("expected ErrRunning while a self test runs, got %v", err)
+	}
+	if _, err := r.Run(context.Background(), TriggerScheduled); !errors.Is(err, ErrRunning) {
+		t.Errorf("expected ErrRunning for a scheduled run, got %v", err)
+	}
+
+	close(release)
+	r.wg.Wait()
+	if len(store.reports) != 1 || store.reports[0].Outcome != OutcomePassed {
+		t.Fatalf("expected one passed report, got %+v", store.reports)
+	}
+	if err := r.Start(TriggerManual); err != nil {
+		t.Errorf("expected a new self test to start once the last one finished, got %v", err)
+	}
+	r.Stop()
+}
+
+func TestStop_CancelsRunAndDeletesAgent(t *testing.T) {
+	agents := &fakeAgents{send: func(ctx context.Context, _ string, _ webhook.Config) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	r, _, store := newTestRunner(agents, time.Minute)
+
+	if err := r.Start(TriggerManual); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.Stop()
+
+	if len(agents.deleted) != 1 {
+		t.Errorf("expected the agent to be deleted despite the cancellation, got %v", agents.deleted)
+	}
+	if len(store.reports) != 1 || store.reports[0].Outcome != OutcomeFailed {
+		t.Errorf("expected a failed report, got %+v", store.reports)
+	}
+}
+
+func TestLatest_NoReport(t *testing.T) {
+	r, _, _ := newTestRunner(&fakeAgents{}, time.Second)
+	if _, err := r.Latest(context.Background()); !errors.Is(err, ErrNoReport) {
+		t.Errorf("expected ErrNoReport, got %v", err)
+	}
+}
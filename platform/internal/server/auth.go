@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	stderrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	applogger "github.com/forge/platform/internal/logger"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// adminPathPrefix starts the routes only admins may call
+const adminPathPrefix = "/api/v1/admin/"
+
+// unauthenticatedPaths are the routes callers need no API key for: the health checks, and
+// the metrics scraped by Prometheus
+var unauthenticatedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// apiKeyLookup looks up stored API keys by their hash
+type apiKeyLookup interface {
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*sqlc.ApiKey, error)
+}
+
+// APIKeyAuthenticator authenticates callers by the API keys issued to them, and by the
+// bootstrap admin key from the environment if one is configured
+type APIKeyAuthenticator struct {
+	keys         apiKeyLookup
+	bootstrapKey string
+}
+
+// NewAPIKeyAuthenticator creates an authenticator looking keys up in keys. A non-empty
+// bootstrapKey is accepted as an admin key, for provisioning the first keys.
+func NewAPIKeyAuthenticator(keys apiKeyLookup, bootstrapKey string) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{keys: keys, bootstrapKey: bootstrapKey}
+}
+
+// newAPIKeyAuthenticator creates an APIKeyAuthenticator using configuration from the fx
+// container
+func newAPIKeyAuthenticator(pool *pgxpool.Pool, cfg *config.Config, logger *zap.Logger) handler.Authenticator {
+	if cfg.BootstrapAdminAPIKey == "" {
+		logger.Info("no bootstrap admin API key configured, only stored API keys are accepted")
+	}
+	return NewAPIKeyAuthenticator(sqlc.New(pool), cfg.BootstrapAdminAPIKey)
+}
+
+// Authenticate implements handler.Authenticator
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, token string) (handler.Principal, error) {
+	if a.bootstrapKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.bootstrapKey)) == 1 {
+		return handler.Principal{Admin: true}, nil
+	}
+
+	key, err := a.keys.GetAPIKeyByHash(ctx, handler.HashAPIKey(token))
+	if err != nil {
+		if stderrors.Is(err, pgx.ErrNoRows) {
+			return handler.Principal{}, fmt.Errorf("%w: unknown API key", handler.ErrInvalidToken)
+		}
+		return handler.Principal{}, fmt.Errorf("looking up API key: %w", err)
+	}
+	if key.RevokedAt.Valid {
+		return handler.Principal{}, fmt.Errorf("%w: API key was revoked", handler.ErrInvalidToken)
+	}
+	return handler.Principal{UserID: key.UserID, Admin: key.Admin}, nil
+}
+
+// authMiddleware resolves the Principal of every request but the unauthenticatedPaths from its API
+// key, refusing requests without a valid one with 401. Admin routes are refused with 403 to
+// callers that are not admins, and requests for another user than the caller's (see
+// handler.RequireUser) are refused with 403 too.
+func authMiddleware(authenticator handler.Authenticator, logger *zap.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		requireUser := handler.RequireUser(next)
+		return func(c echo.Context) error {
+			if unauthenticatedPaths[c.Path()] {
+				return next(c)
+			}
+
+			token := handler.RequestToken(c.Request())
+			if token == "" {
+				return errors.Unauthorized("an API key is required")
+			}
+			principal, err := authenticator.Authenticate(c.Request().Context(), token)
+			if err != nil {
+				if stderrors.Is(err, handler.ErrInvalidToken) {
+					return errors.Unauthorized(err.Error())
+				}
+				applogger.FromContext(c.Request().Context(), logger).Warn("failed to authenticate request", zap.Error(err))
+				return errors.ServiceUnavailable("failed to authenticate")
+			}
+			handler.SetPrincipal(c, principal)
+
+			if strings.HasPrefix(c.Path(), adminPathPrefix) && !principal.Admin {
+				return errors.Forbidden("admin API key required")
+			}
+			return requireUser(c)
+		}
+	}
+}
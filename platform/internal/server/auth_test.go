@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeAPIKeys serves stored API keys by hash
+type fakeAPIKeys map[string]*sqlc.ApiKey
+
+func (f fakeAPIKeys) GetAPIKeyByHash(_ context.Context, keyHash string) (*sqlc.ApiKey, error) {
+	if key, ok := f[keyHash]; ok {
+		return key, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f fakeAPIKeys) add(key string, stored sqlc.ApiKey) {
+	f[handler.HashAPIKey(key)] = &stored
+}
+
+// failingAPIKeys fails every lookup, as when the database is down
+type failingAPIKeys struct{}
+
+func (failingAPIKeys) GetAPIKeyByHash(context.Context, string) (*sqlc.ApiKey, error) {
+	return nil, stderrors.New("connection refused")
+}
+
+func setupAuth(keys apiKeyLookup) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	e.Use(authMiddleware(NewAPIKeyAuthenticator(keys, "bootstrap-key"), zap.NewNop()))
+
+	ok := func(c echo.Context) error {
+		p, _ := handler.PrincipalFrom(c)
+		return c.String(http.StatusOK, p.UserID)
+	}
+	e.GET("/healthz", ok)
+	e.GET("/metrics", ok)
+	e.GET("/api/v1/users/:user_id/agents", ok, handler.RequireUser)
+	e.GET("/api/v1/requests/:request_id/artifacts", ok)
+	e.GET("/api/v1/admin/streams", ok)
+	return e
+}
+
+func TestAuth_AuthenticatesRequests(t *testing.T) {
+	keys := fakeAPIKeys{}
+	keys.add("fk_user1", sqlc.ApiKey{ID: 1, UserID: "user1"})
+	keys.add("fk_revoked", sqlc.ApiKey{ID: 2, UserID: "user1", RevokedAt: sql.NullTime{Time: time.Now(), Valid: true}})
+	keys.add("fk_admin", sqlc.ApiKey{ID: 3, UserID: "ops", Admin: true})
+	e := setupAuth(keys)
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"health checks need no key", "/healthz", nil, http.StatusOK},
+		{"metrics need no key", "/metrics", nil, http.StatusOK},
+		{"missing key", "/api/v1/users/user1/agents", nil, http.StatusUnauthorized},
+		{"unknown key", "/api/v1/users/user1/agents", http.Header{"X-Api-Key": {"fk_unknown"}}, http.StatusUnauthorized},
+		{"revoked key", "/api/v1/users/user1/agents", http.Header{"Authorization": {"Bearer fk_revoked"}}, http.StatusUnauthorized},
+		{"bearer key", "/api/v1/users/user1/agents", http.Header{"Authorization": {"Bearer fk_user1"}}, http.StatusOK},
+		{"X-API-Key header", "/api/v1/users/user1/agents", http.Header{"X-Api-Key": {"fk_user1"}}, http.StatusOK},
+		{"another user's path", "/api/v1/users/user2/agents", http.Header{"X-Api-Key": {"fk_user1"}}, http.StatusForbidden},
+		{"another user's query param", "/api/v1/requests/req_1/artifacts?user_id=user2", http.Header{"X-Api-Key": {"fk_user1"}}, http.StatusForbidden},
+		{"admin route for a user key", "/api/v1/admin/streams", http.Header{"X-Api-Key": {"fk_user1"}}, http.StatusForbidden},
+		{"admin key acts for any user", "/api/v1/users/user2/agents", http.Header{"X-Api-Key": {"fk_admin"}}, http.StatusOK},
+		{"admin route for an admin key", "/api/v1/admin/streams", http.Header{"X-Api-Key": {"fk_admin"}}, http.StatusOK},
+		{"bootstrap key is an admin key", "/api/v1/admin/streams", http.Header{"Authorization": {"Bearer bootstrap-key"}}, http.StatusOK},
+		{"query token on a plain request", "/api/v1/users/user1/agents?token=fk_user1", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestAuth_FailedLookupIsUnavailable(t *testing.T) {
+	e := setupAuth(failingAPIKeys{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/user1/agents", nil)
+	req.Header.Set(handler.HeaderAPIKey, "fk_user1")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// NewEcho creates a new Echo instance. It serves requests once Serve is invoked.
+func NewEcho(cfg *config.Config) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = false
+	e.HidePort = true
+	e.Server.ReadTimeout = cfg.ReadTimeout
+	e.Server.WriteTimeout = cfg.WriteTimeout
+	return e
+}
+
+// Serve serves e on the configured port while the app runs. It should be invoked after
+// every other module, so that its stop hook runs first: on stop, the server stops taking
+// requests and waits up to MESSAGE_DRAIN_TIMEOUT for in-flight ones, such as SSE streams,
+// before closing their connections. Background work is drained by the modules stopped after.
+func Serve(lc fx.Lifecycle, e *echo.Echo, cfg *config.Config, logger *zap.Logger) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			addr := fmt.Sprintf(":%d", cfg.Port)
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %w", addr, err)
+			}
+			e.Listener = ln
+
+			logger.Info("Starting HTTP server", zap.String("addr", ln.Addr().String()))
+			go func() {
+				if err := e.Server.Serve(ln); err != nil && err != http.ErrServerClosed {
+					logger.Error("Server error", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			logger.Info("Stopping HTTP server")
+			drainCtx, cancel := context.WithTimeout(ctx, cfg.MessageDrainTimeout)
+			defer cancel()
+			if err := e.Shutdown(drainCtx); err != nil {
+				if !stderrors.Is(err, context.DeadlineExceeded) {
+					return err
+				}
+				logger.Warn("closing HTTP requests still in flight for shutdown")
+				return e.Close()
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+)
+
+func TestServe_DrainsRequestsOnStop(t *testing.T) {
+	cfg := &config.Config{Port: 0, MessageDrainTimeout: 200 * time.Millisecond}
+	e := NewEcho(cfg)
+	entered := make(chan struct{}, 2)
+	e.GET("/slow", func(c echo.Context) error {
+		entered <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/stuck", func(c echo.Context) error {
+		entered <- struct{}{}
+		<-c.Request().Context().Done()
+		return nil
+	})
+
+	lc := fxtest.NewLifecycle(t)
+	Serve(lc, e, cfg, zap.NewNop())
+	lc.RequireStart()
+	url := "http://" + e.Listener.Addr().String()
+
+	type result struct {
+		code int
+		err  error
+	}
+	get := func(path string) <-chan result {
+		results := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(url + path)
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			resp.Body.Close()
+			results <- result{code: resp.StatusCode}
+		}()
+		return results
+	}
+	slow, stuck := get("/slow"), get("/stuck")
+	<-entered
+	<-entered
+
+	start := time.Now()
+	lc.RequireStop()
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("expected stop within the drain timeout, took %s", took)
+	}
+	if r := <-slow; r.err != nil || r.code != http.StatusOK {
+		t.Errorf("expected the in-flight request to finish, got %d (%v)", r.code, r.err)
+	}
+	if r := <-stuck; r.err == nil {
+		t.Errorf("expected the stuck request's connection closed, got %d", r.code)
+	}
+	if r := <-get("/slow"); r.err == nil {
+		t.Errorf("expected no requests taken after stop, got %d", r.code)
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	applogger "github.com/forge/platform/internal/logger"
+	sqlc "github.com/forge/platform/internal/sqlc/gen"
+)
+
+// Idempotency headers: the key a client retries a request with, and the header marking a
+// response replayed for a retry
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+const (
+	// idempotencyPruneInterval is how often expired keys are deleted
+	idempotencyPruneInterval = time.Minute
+	// idempotencyKeyMaxLength is the longest idempotency key accepted
+	idempotencyKeyMaxLength = 255
+	// idempotencyResponseMaxLength is the largest response stored for replay; requests with
+	// larger responses free their key
+	idempotencyResponseMaxLength = 1 << 20
+)
+
+// Error codes of requests refused over their idempotency key
+const (
+	// ErrorCodeIdempotencyKeyReused is returned for a key retried with a different body
+	ErrorCodeIdempotencyKeyReused = "idempotency_key_reused"
+	// ErrorCodeIdempotencyKeyInProgress is returned for a key whose first request is still
+	// being handled
+	ErrorCodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+)
+
+// idempotencyStore stores idempotency keys and the responses to their requests
+type idempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, arg *sqlc.ReserveIdempotencyKeyParams) (int64, error)
+	GetIdempotencyKey(ctx context.Context, keyHash string) (*sqlc.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, arg *sqlc.CompleteIdempotencyKeyParams) error
+	DeleteIdempotencyKey(ctx context.Context, keyHash string) error
+	DeleteIdempotencyKeysBefore(ctx context.Context, expiresAt time.Time) (int64, error)
+}
+
+// IdempotencyKeys remembers the successful responses to requests sent with an idempotency
+// key for a TTL, so a client retrying a request gets its response again instead of
+// creating a second agent or sending a second message
+type IdempotencyKeys struct {
+	store  idempotencyStore
+	ttl    time.Duration
+	now    func() time.Time // replaced in tests
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewIdempotencyKeys creates IdempotencyKeys keeping responses in store for ttl. A zero
+// ttl ignores idempotency keys.
+func NewIdempotencyKeys(store idempotencyStore, ttl time.Duration, logger *zap.Logger) *IdempotencyKeys {
+	return &IdempotencyKeys{store: store, ttl: ttl, now: time.Now, logger: logger}
+}
+
+// newIdempotencyKeys creates IdempotencyKeys using configuration from the fx container
+func newIdempotencyKeys(pool *pgxpool.Pool, cfg *config.Config, logger *zap.Logger) *IdempotencyKeys {
+	return NewIdempotencyKeys(sqlc.New(pool), cfg.IdempotencyKeyTTL, logger)
+}
+
+// idempotentRoute reports whether requests to a route may carry an idempotency key:
+// agent creations and single messages
+func idempotentRoute(method, path string) bool {
+	return method == http.MethodPost && (strings.HasSuffix(path, "/agents") || strings.HasSuffix(path, "/messages"))
+}
+
+// keyedByBody reports whether requests to a route without an Idempotency-Key header are
+// keyed by their body: messages, by their request_id
+func keyedByBody(path string) bool {
+	return strings.HasSuffix(path, "/messages")
+}
+
+// idempotencyBodyLimit is the most of a request body read to hash it, the body limit of
+// its route
+func idempotencyBodyLimit(limits handler.BodyLimits, path string) int64 {
+	if keyedByBody(path) {
+		return limits.Message
+	}
+	return limits.Default
+}
+
+// idempotencyKey returns the key a request was sent with: its Idempotency-Key header, or
+// for messages the request_id in its body
+func idempotencyKey(c echo.Context, body []byte) string {
+	if key := c.Request().Header.Get(HeaderIdempotencyKey); key != "" {
+		return key
+	}
+	if !keyedByBody(c.Path()) {
+		return ""
+	}
+	var req struct {
+		RequestID string `json:"request_id"`
+	}
+	_ = json.Unmarshal(body, &req)
+	return req.RequestID
+}
+
+// idempotencyMiddleware replays the stored 2xx response to an agent creation or message
+// retried with the same idempotency key within keys' TTL, and refuses with 409 a key
+// retried with a different body or while its first request is being handled. Keys are
+// scoped to the caller and the request's path and user. Failed requests free their key
+// for a retry. Messages streamed over SSE are not replayed. If the store fails, requests
+// are let through. Bodies are only read when they may carry a key, and refused with 413
+// past their route's body limit before being buffered whole.
+func idempotencyMiddleware(keys *IdempotencyKeys, bodyLimits handler.BodyLimits, logger *zap.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if keys == nil || keys.ttl <= 0 || !idempotentRoute(req.Method, c.Path()) ||
+				strings.Contains(req.Header.Get(echo.HeaderAccept), "text/event-stream") {
+				return next(c)
+			}
+
+			if req.Header.Get(HeaderIdempotencyKey) == "" && !keyedByBody(c.Path()) {
+				return next(c)
+			}
+
+			body, err := handler.ReadBody(c, idempotencyBodyLimit(bodyLimits, c.Path()))
+			if err != nil {
+				return err
+			}
+
+			key := idempotencyKey(c, body)
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > idempotencyKeyMaxLength {
+				return errors.BadRequest("idempotency key must be at most 255 characters")
+			}
+
+			ctx := req.Context()
+			log := applogger.FromContext(ctx, logger)
+			keyHash := hashIdempotencyKey(idempotencyCaller(c), req.URL.Path, c.QueryParam("user_id"), key)
+			requestHash := sha256.Sum256(body)
+
+			stored, err := keys.reserve(ctx, keyHash, hex.EncodeToString(requestHash[:]))
+			if err != nil {
+				log.Warn("idempotency key store failed, letting request through", zap.Error(err))
+				return next(c)
+			}
+			if stored != nil {
+				return replayIdempotent(c, stored, hex.EncodeToString(requestHash[:]))
+			}
+
+			// Handle the request, keeping a copy of the response to replay
+			rec := &responseCapture{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rec
+			err = next(c)
+			c.Response().Writer = rec.ResponseWriter
+
+			// The key outlives the request, whose context may be done
+			ctx = context.WithoutCancel(ctx)
+			status := c.Response().Status
+			if err != nil || status < 200 || status >= 300 || rec.overflow {
+				if delErr := keys.store.DeleteIdempotencyKey(ctx, keyHash); delErr != nil {
+					log.Warn("failed to free idempotency key", zap.Error(delErr))
+				}
+				return err
+			}
+			if err := keys.store.CompleteIdempotencyKey(ctx, &sqlc.CompleteIdempotencyKeyParams{
+				KeyHash:      keyHash,
+				StatusCode:   pgtype.Int4{Int32: int32(status), Valid: true},
+				ContentType:  sql.NullString{String: c.Response().Header().Get(echo.HeaderContentType), Valid: true},
+				ResponseBody: rec.body.Bytes(),
+			}); err != nil {
+				log.Warn("failed to store idempotent response", zap.Error(err))
+			}
+			return nil
+		}
+	}
+}
+
+// reserve claims keyHash for a request with requestHash, taking over an expired claim. If
+// the key is claimed already it returns the claim.
+func (k *IdempotencyKeys) reserve(ctx context.Context, keyHash, requestHash string) (*sqlc.IdempotencyKey, error) {
+	now := k.now()
+	k.prune(ctx, now)
+	reserved, err := k.store.ReserveIdempotencyKey(ctx, &sqlc.ReserveIdempotencyKeyParams{
+		KeyHash:     keyHash,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(k.ttl),
+	})
+	if err != nil || reserved > 0 {
+		return nil, err
+	}
+	stored, err := k.store.GetIdempotencyKey(ctx, keyHash)
+	if stderrors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("idempotency key freed while reserving it: %w", err)
+	}
+	return stored, err
+}
+
+// prune deletes expired keys, at most once per idempotencyPruneInterval
+func (k *IdempotencyKeys) prune(ctx context.Context, now time.Time) {
+	k.mu.Lock()
+	if now.Sub(k.lastPrune) < idempotencyPruneInterval {
+		k.mu.Unlock()
+		return
+	}
+	k.lastPrune = now
+	k.mu.Unlock()
+
+	if n, err := k.store.DeleteIdempotencyKeysBefore(ctx, now); err != nil {
+		k.logger.Warn("failed to prune idempotency keys", zap.Error(err))
+	} else if n > 0 {
+		k.logger.Debug("pruned idempotency keys", zap.Int64("deleted", n))
+	}
+}
+
+// replayIdempotent answers a request retrying the key of stored
+func replayIdempotent(c echo.Context, stored *sqlc.IdempotencyKey, requestHash string) error {
+	if stored.RequestHash != requestHash {
+		return errors.Conflict("idempotency key was already used with a different request body").
+			WithErrorCode(ErrorCodeIdempotencyKeyReused)
+	}
+	if !stored.StatusCode.Valid {
+		return errors.Conflict("a request with this idempotency key is still in progress").
+			WithErrorCode(ErrorCodeIdempotencyKeyInProgress)
+	}
+	c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+	return c.Blob(int(stored.StatusCode.Int32), stored.ContentType.String, stored.ResponseBody)
+}
+
+// idempotencyCaller returns who a request was sent by, scoping its idempotency key
+func idempotencyCaller(c echo.Context) string {
+	if p, ok := handler.PrincipalFrom(c); ok {
+		if p.Admin {
+			return "admin"
+		}
+		if p.UserID != "" {
+			return "user:" + p.UserID
+		}
+	}
+	return "ip:" + c.RealIP()
+}
+
+// hashIdempotencyKey returns the stored hash of a key sent by caller to path for userID
+func hashIdempotencyKey(caller, path, userID, key string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{caller, path, userID, key}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// responseCapture copies a response as it is written, up to idempotencyResponseMaxLength
+type responseCapture struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *responseCapture) Write(b []byte) (int, error) {
+	if r.body.Len()+len(b) > idempotencyResponseMaxLength {
+		r.overflow = true
+	} else {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	sqlc "github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeIdempotencyStore keeps idempotency keys in memory
+type fakeIdempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]*sqlc.IdempotencyKey
+}
+
+func (f *fakeIdempotencyStore) ReserveIdempotencyKey(_ context.Context, arg *sqlc.ReserveIdempotencyKeyParams) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if k, ok := f.keys[arg.KeyHash]; ok && k.ExpiresAt.After(arg.CreatedAt) {
+		return 0, nil
+	}
+	f.keys[arg.KeyHash] = &sqlc.IdempotencyKey{
+		KeyHash:     arg.KeyHash,
+		RequestHash: arg.RequestHash,
+		CreatedAt:   arg.CreatedAt,
+		ExpiresAt:   arg.ExpiresAt,
+	}
+	return 1, nil
+}
+
+func (f *fakeIdempotencyStore) GetIdempotencyKey(_ context.Context, keyHash string) (*sqlc.IdempotencyKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k, ok := f.keys[keyHash]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	stored := *k
+	return &stored, nil
+}
+
+func (f *fakeIdempotencyStore) CompleteIdempotencyKey(_ context.Context, arg *sqlc.CompleteIdempotencyKeyParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if k, ok := f.keys[arg.KeyHash]; ok {
+		k.StatusCode, k.ContentType, k.ResponseBody = arg.StatusCode, arg.ContentType, arg.ResponseBody
+	}
+	return nil
+}
+
+func (f *fakeIdempotencyStore) DeleteIdempotencyKey(_ context.Context, keyHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.keys, keyHash)
+	return nil
+}
+
+func (f *fakeIdempotencyStore) DeleteIdempotencyKeysBefore(_ context.Context, expiresAt time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for hash, k := range f.keys {
+		if k.ExpiresAt.Before(expiresAt) {
+			delete(f.keys, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// testBodyLimits are the default REQUEST_BODY_MAX_BYTES and MESSAGE_BODY_MAX_BYTES
+var testBodyLimits = handler.BodyLimits{Default: 64 << 10, Message: 1 << 20}
+
+// setupIdempotencyTest serves a message route counting the messages it handles behind the
+// idempotency middleware, with keys kept for an hour
+func setupIdempotencyTest(t *testing.T) (*echo.Echo, *fakeClock, *int) {
+	t.Helper()
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	keys := NewIdempotencyKeys(&fakeIdempotencyStore{keys: make(map[string]*sqlc.IdempotencyKey)}, time.Hour, zap.NewNop())
+	keys.now = clock.now
+
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	e.Use(idempotencyMiddleware(keys, testBodyLimits, zap.NewNop()))
+	handled := new(int)
+	e.POST("/api/v1/agents/:agent_id/messages", func(c echo.Context) error {
+		*handled++
+		var req struct {
+			Content string `json:"content"`
+		}
+		if err := c.Bind(&req); err != nil || req.Content == "" {
+			return errors.BadRequest("content is required")
+		}
+		return c.JSON(http.StatusAccepted, map[string]any{"handled": *handled})
+	})
+	return e, clock, handled
+}
+
+func postIdempotent(e *echo.Echo, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents/a1/messages?user_id=u1", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotency_ReplaysIdenticalRequest(t *testing.T) {
+	e, _, handled := setupIdempotencyTest(t)
+
+	first := postIdempotent(e, "key-1", `{"content":"hello"}`)
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", first.Code, first.Body.String())
+	}
+	replay := postIdempotent(e, "key-1", `{"content":"hello"}`)
+	if replay.Code != http.StatusAccepted || replay.Body.String() != first.Body.String() {
+		t.Errorf("expected the first response replayed, got %d: %s", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get(HeaderIdempotentReplayed) != "true" {
+		t.Errorf("expected the %s header on the replay", HeaderIdempotentReplayed)
+	}
+	if !strings.HasPrefix(replay.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		t.Errorf("expected the stored content type, got %q", replay.Header().Get(echo.HeaderContentType))
+	}
+	if *handled != 1 {
+		t.Errorf("expected the request handled once, got %d", *handled)
+	}
+
+	// The body's request_id keys messages sent without the header
+	postIdempotent(e, "", `{"content":"hi","request_id":"req-1"}`)
+	postIdempotent(e, "", `{"content":"hi","request_id":"req-1"}`)
+	postIdempotent(e, "", `{"content":"hi"}`)
+	postIdempotent(e, "", `{"content":"hi"}`)
+	if *handled != 4 {
+		t.Errorf("expected request_id to key messages and unkeyed ones to be handled, got %d handled", *handled)
+	}
+}
+
+func TestIdempotency_RefusesKeyReusedWithDifferentBody(t *testing.T) {
+	e, _, handled := setupIdempotencyTest(t)
+
+	postIdempotent(e, "key-1", `{"content":"hello"}`)
+	rec := postIdempotent(e, "key-1", `{"content":"goodbye"}`)
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if body.Error != ErrorCodeIdempotencyKeyReused {
+		t.Errorf("expected error %q, got %q", ErrorCodeIdempotencyKeyReused, body.Error)
+	}
+	if *handled != 1 {
+		t.Errorf("expected only the first request handled, got %d", *handled)
+	}
+}
+
+func TestIdempotency_FreesKeyOfFailedRequest(t *testing.T) {
+	e, _, handled := setupIdempotencyTest(t)
+
+	if rec := postIdempotent(e, "key-1", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	if rec := postIdempotent(e, "key-1", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected the retry handled again, got %d", rec.Code)
+	}
+	if *handled != 2 {
+		t.Errorf("expected both requests handled, got %d", *handled)
+	}
+}
+
+func TestIdempotency_KeyExpires(t *testing.T) {
+	e, clock, handled := setupIdempotencyTest(t)
+
+	postIdempotent(e, "key-1", `{"content":"hello"}`)
+	clock.advance(59 * time.Minute)
+	if rec := postIdempotent(e, "key-1", `{"content":"hello"}`); rec.Header().Get(HeaderIdempotentReplayed) != "true" {
+		t.Fatalf("expected a replay within the TTL, got %d", rec.Code)
+	}
+
+	clock.advance(time.Minute)
+	rec := postIdempotent(e, "key-1", `{"content":"goodbye"}`)
+	if rec.Code != http.StatusAccepted || rec.Header().Get(HeaderIdempotentReplayed) != "" {
+		t.Fatalf("expected the expired key handled as new, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if *handled != 2 {
+		t.Errorf("expected the request handled again after expiry, got %d", *handled)
+	}
+}
+
+func TestIdempotency_BoundsBodyRead(t *testing.T) {
+	e, _, handled := setupIdempotencyTest(t)
+	var created int
+	e.POST("/api/v1/agents", func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		created = len(body)
+		return c.NoContent(http.StatusCreated)
+	})
+
+	// A keyed body past the route's limit is refused before it is buffered
+	rec := postIdempotent(e, "key-1", `{"content":"`+strings.Repeat("a", int(testBodyLimits.Message))+`"}`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if *handled != 0 {
+		t.Errorf("expected the request not handled, got %d", *handled)
+	}
+
+	// Without a key, agent creations reach the route unread, to be limited there
+	body := strings.Repeat(" ", int(testBodyLimits.Default)+1)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/agents", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || created != len(body) {
+		t.Errorf("expected the whole body passed on unread, got %d with %d bytes", rec.Code, created)
+	}
+}
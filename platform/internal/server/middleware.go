@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	applogger "github.com/forge/platform/internal/logger"
+)
+
+// SetupMiddleware configures all middleware for the Echo instance
+func SetupMiddleware(e *echo.Echo, cfg *config.Config, authenticator handler.Authenticator, limiter RateLimiter, idempotency *IdempotencyKeys, logger *zap.Logger) error {
+	rateLimits, err := rateLimitsFrom(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Recover from panics
+	e.Use(middleware.Recover())
+
+	// Request ID for tracing, and a logger tagging entries with it on the request's context
+	e.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		RequestIDHandler: func(c echo.Context, requestID string) {
+			ctx := applogger.WithRequest(c.Request().Context(), logger, requestID)
+			c.SetRequest(c.Request().WithContext(ctx))
+		},
+	}))
+
+	// CORS
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     cfg.CORSAllowedOrigins,
+			AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+			AllowHeaders:     []string{echo.HeaderContentType, echo.HeaderAuthorization, handler.HeaderAPIKey, HeaderIdempotencyKey},
+			AllowCredentials: true,
+			MaxAge:           86400,
+		}))
+	} else {
+		e.Use(middleware.CORS())
+	}
+
+	// Request logging
+	e.Use(requestLoggerMiddleware(logger))
+
+	// API key authentication, after request logging so refused requests are logged too
+	e.Use(authMiddleware(authenticator, logger))
+
+	// Rate limiting, after authentication so requests are limited per user
+	e.Use(rateLimitMiddleware(limiter, rateLimits, logger))
+
+	// Idempotency keys, after rate limiting so replays count against the caller's limit
+	e.Use(idempotencyMiddleware(idempotency, handler.NewBodyLimits(cfg), logger))
+
+	// Custom error handler
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(logger)
+	return nil
+}
+
+// requestLoggerMiddleware logs every request with its outcome and caller: at Debug if it
+// succeeded, and at Info otherwise. Errors are handled here, so the status logged is the one
+// the client got.
+func requestLoggerMiddleware(fallback *zap.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			level := zap.DebugLevel
+			if status < 200 || status >= 300 {
+				level = zap.InfoLevel
+			}
+			fields := []zap.Field{
+				zap.String("method", c.Request().Method),
+				zap.String("path", c.Path()),
+				zap.Int("status", status),
+				zap.Duration("latency", time.Since(start)),
+			}
+			if p, ok := handler.PrincipalFrom(c); ok {
+				fields = append(fields, zap.String("user_id", p.UserID), zap.Bool("admin", p.Admin))
+			}
+			applogger.FromContext(c.Request().Context(), fallback).Log(level, "request", fields...)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+)
+
+func setupMiddlewareTest(t *testing.T) (*echo.Echo, *observer.ObservedLogs) {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	e := echo.New()
+	cfg := &config.Config{RateLimitCreate: "0", RateLimitMessage: "0", RateLimitRead: "0"}
+	authenticator := NewAPIKeyAuthenticator(fakeAPIKeys{}, "admin-key")
+	if err := SetupMiddleware(e, cfg, authenticator, NewMemoryRateLimiter(), nil, zap.New(core)); err != nil {
+		t.Fatalf("failed to set up middleware: %v", err)
+	}
+	e.GET("/api/v1/things/:id", func(c echo.Context) error {
+		if c.Param("id") == "missing" {
+			return errors.NotFound("thing not found")
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	return e, logs
+}
+
+func TestMiddleware_RequestIDInErrors(t *testing.T) {
+	e, _ := setupMiddlewareTest(t)
+
+	for _, path := range []string{"/api/v1/things/missing", "/api/v1/nothing"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer admin-key")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		var body struct {
+			RequestID string `json:"request_id"`
+		}
+		header := rec.Header().Get(echo.HeaderXRequestID)
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected a 404 error body, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+		if header == "" || body.RequestID != header {
+			t.Errorf("%s: expected request_id %q as in the header, got %q", path, header, body.RequestID)
+		}
+	}
+}
+
+func TestMiddleware_LogsRequests(t *testing.T) {
+	e, logs := setupMiddlewareTest(t)
+
+	serve := func(path string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer admin-key")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Header().Get(echo.HeaderXRequestID)
+	}
+	okID := serve("/api/v1/things/1")
+	missingID := serve("/api/v1/things/missing")
+
+	entries := logs.FilterMessage("request").AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 requests logged, got %d", len(entries))
+	}
+	for i, want := range []struct {
+		level     zapcore.Level
+		status    int64
+		requestID string
+	}{
+		{zapcore.DebugLevel, http.StatusOK, okID},
+		{zapcore.InfoLevel, http.StatusNotFound, missingID},
+	} {
+		entry := entries[i]
+		fields := entry.ContextMap()
+		if entry.Level != want.level || fields["status"] != want.status || fields["request_id"] != want.requestID ||
+			fields["path"] != "/api/v1/things/:id" || fields["admin"] != true {
+			t.Errorf("expected a %s entry with status %d and request_id %s, got %s %v", want.level, want.status, want.requestID, entry.Level, fields)
+		}
+	}
+}
+
+func TestMiddleware_CORSPreflight(t *testing.T) {
+	e := echo.New()
+	cfg := &config.Config{
+		RateLimitCreate:    "0",
+		RateLimitMessage:   "0",
+		RateLimitRead:      "0",
+		CORSAllowedOrigins: []string{"https://app.example.com"},
+	}
+	authenticator := NewAPIKeyAuthenticator(fakeAPIKeys{}, "admin-key")
+	if err := SetupMiddleware(e, cfg, authenticator, NewMemoryRateLimiter(), nil, zap.NewNop()); err != nil {
+		t.Fatalf("failed to set up middleware: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/agents/agent1", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://app.example.com")
+	req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodPatch)
+	req.Header.Set(echo.HeaderAccessControlRequestHeaders, HeaderIdempotencyKey)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the preflight answered with %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	if methods := rec.Header().Get(echo.HeaderAccessControlAllowMethods); !strings.Contains(methods, http.MethodPatch) {
+		t.Errorf("expected PATCH among the allowed methods, got %q", methods)
+	}
+	if headers := rec.Header().Get(echo.HeaderAccessControlAllowHeaders); !strings.Contains(headers, HeaderIdempotencyKey) {
+		t.Errorf("expected %s among the allowed headers, got %q", HeaderIdempotencyKey, headers)
+	}
+}
@@ -0,0 +1,14 @@
+package server
+
+import (
+	"go.uber.org/fx"
+)
+
+// Module provides the server components to the fx container
+var Module = fx.Module("server",
+	fx.Provide(NewEcho),
+	fx.Provide(newAPIKeyAuthenticator),
+	fx.Provide(fx.Annotate(NewMemoryRateLimiter, fx.As(new(RateLimiter)))),
+	fx.Provide(newIdempotencyKeys),
+	fx.Invoke(SetupMiddleware),
+)
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+	applogger "github.com/forge/platform/internal/logger"
+)
+
+// Route groups rate limited together
+const (
+	RateLimitGroupCreate  = "create"  // agent creation
+	RateLimitGroupMessage = "message" // messages, batches and interrupts
+	RateLimitGroupRead    = "read"    // GET requests
+)
+
+// RateLimit allows Requests requests per Per, in bursts of up to Requests. The zero
+// RateLimit allows any number.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateLimit parses a limit written as "<requests>/<duration>", e.g. "60/1m". "0"
+// allows any number.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "0" {
+		return RateLimit{}, nil
+	}
+	requests, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not <requests>/<duration>", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid request count", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid duration", s)
+	}
+	if n == 0 {
+		return RateLimit{}, nil
+	}
+	return RateLimit{Requests: n, Per: d}, nil
+}
+
+// unlimited reports whether l allows any number of requests
+func (l RateLimit) unlimited() bool {
+	return l.Requests == 0
+}
+
+// RateLimiter keeps a token bucket per key. The in-memory implementation limits each
+// replica on its own; one backed by a shared store can limit across replicas.
+type RateLimiter interface {
+	// Allow takes a token from key's bucket under limit. If the bucket is empty it returns
+	// false and how long until a token is available.
+	Allow(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// rateLimiterSweepInterval is how often the in-memory limiter drops buckets that have
+// refilled, which behave like new ones
+const rateLimiterSweepInterval = time.Minute
+
+// MemoryRateLimiter is a RateLimiter keeping its buckets in memory
+type MemoryRateLimiter struct {
+	now func() time.Time // replaced in tests
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket holds tokens as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+// NewMemoryRateLimiter creates an in-memory RateLimiter
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow implements RateLimiter
+func (m *MemoryRateLimiter) Allow(_ context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	if limit.unlimited() {
+		return true, 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok || b.limit != limit {
+		b = &tokenBucket{tokens: float64(limit.Requests), last: now, limit: limit}
+		m.buckets[key] = b
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, b.untilToken(), nil
+}
+
+// refill adds the tokens earned since the bucket was last used, up to its burst
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Requests), b.tokens+elapsed.Seconds()*b.rate())
+		b.last = now
+	}
+}
+
+// rate is how many tokens the bucket earns per second
+func (b *tokenBucket) rate() float64 {
+	return float64(b.limit.Requests) / b.limit.Per.Seconds()
+}
+
+// untilToken is how long until the bucket holds a whole token, rounded up
+func (b *tokenBucket) untilToken() time.Duration {
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate() * float64(time.Second)))
+}
+
+// sweep drops the buckets that have refilled since they were last used
+func (m *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < rateLimiterSweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= b.limit.Per {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// RateLimits are the limits of each route group, and of routes overriding their group's
+type RateLimits struct {
+	Groups map[string]RateLimit
+	// Routes overrides the limit of single routes, keyed by "<method> <route>", e.g.
+	// "POST /api/v1/users/:user_id/agents". They get a bucket of their own.
+	Routes map[string]RateLimit
+}
+
+// rateLimitsFrom parses the rate limits configured in cfg
+func rateLimitsFrom(cfg *config.Config) (RateLimits, error) {
+	limits := RateLimits{Groups: make(map[string]RateLimit), Routes: make(map[string]RateLimit)}
+	for group, s := range map[string]string{
+		RateLimitGroupCreate:  cfg.RateLimitCreate,
+		RateLimitGroupMessage: cfg.RateLimitMessage,
+		RateLimitGroupRead:    cfg.RateLimitRead,
+	} {
+		limit, err := ParseRateLimit(s)
+		if err != nil {
+			return RateLimits{}, fmt.Errorf("%s rate limit: %w", group, err)
+		}
+		limits.Groups[group] = limit
+	}
+	for route, s := range cfg.RateLimitOverrides {
+		limit, err := ParseRateLimit(s)
+		if err != nil {
+			return RateLimits{}, fmt.Errorf("rate limit override for %s: %w", route, err)
+		}
+		limits.Routes[route] = limit
+	}
+	return limits, nil
+}
+
+// rateLimitGroup returns the route group of a request, or "" for routes not rate limited
+func rateLimitGroup(method, path string) string {
+	switch {
+	case method == http.MethodPost && strings.HasSuffix(path, "/agents"):
+		return RateLimitGroupCreate
+	case method == http.MethodPost && (strings.Contains(path, "/messages") || strings.HasSuffix(path, "/interrupt")):
+		return RateLimitGroupMessage
+	case method == http.MethodGet:
+		return RateLimitGroupRead
+	default:
+		return ""
+	}
+}
+
+// rateLimitMiddleware limits requests per caller, the authenticated user or else the
+// client IP, in a bucket per route group or overridden route. Requests over the limit are
+// refused with 429 and a Retry-After header. If the limiter fails, requests are let through.
+func rateLimitMiddleware(limiter RateLimiter, limits RateLimits, logger *zap.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method, path := c.Request().Method, c.Path()
+			if unauthenticatedPaths[path] {
+				return next(c)
+			}
+
+			bucket := method + " " + path
+			limit, ok := limits.Routes[bucket]
+			if !ok {
+				bucket = rateLimitGroup(method, path)
+				if bucket == "" {
+					return next(c)
+				}
+				limit = limits.Groups[bucket]
+			}
+
+			caller := "ip:" + c.RealIP()
+			if p, ok := handler.PrincipalFrom(c); ok && p.UserID != "" {
+				caller = "user:" + p.UserID
+			}
+			allowed, retryAfter, err := limiter.Allow(c.Request().Context(), bucket+"|"+caller, limit)
+			if err != nil {
+				applogger.FromContext(c.Request().Context(), logger).Warn("rate limiter failed, letting request through", zap.String("bucket", bucket), zap.Error(err))
+				return next(c)
+			}
+			if !allowed {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+				return errors.TooManyRequests(fmt.Sprintf("rate limit of %d requests per %s exceeded", limit.Requests, limit.Per)).
+					WithDetails(map[string]any{"retry_after_seconds": seconds})
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/errors"
+	"github.com/forge/platform/internal/handler"
+)
+
+// fakeClock is a clock tests move by hand
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time          { return f.t }
+func (f *fakeClock) advance(d time.Duration) { f.t = f.t.Add(d) }
+
+func newTestRateLimiter() (*MemoryRateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := NewMemoryRateLimiter()
+	limiter.now = clock.now
+	return limiter, clock
+}
+
+func TestMemoryRateLimiter_RefillsBuckets(t *testing.T) {
+	limiter, clock := newTestRateLimiter()
+	limit := RateLimit{Requests: 3, Per: 30 * time.Second} // a token every 10s
+	allow := func() (bool, time.Duration) {
+		t.Helper()
+		ok, retryAfter, err := limiter.Allow(context.Background(), "user1", limit)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return ok, retryAfter
+	}
+
+	for i := range 3 {
+		if ok, _ := allow(); !ok {
+			t.Fatalf("expected request %d of the burst allowed", i+1)
+		}
+	}
+	if ok, retryAfter := allow(); ok || retryAfter != 10*time.Second {
+		t.Errorf("expected an empty bucket with a token in 10s, got %v and %s", ok, retryAfter)
+	}
+
+	clock.advance(4 * time.Second)
+	if ok, retryAfter := allow(); ok || retryAfter != 6*time.Second {
+		t.Errorf("expected a token in 6s, got %v and %s", ok, retryAfter)
+	}
+	clock.advance(6 * time.Second)
+	if ok, _ := allow(); !ok {
+		t.Error("expected a token after 10s")
+	}
+	if ok, _ := allow(); ok {
+		t.Error("expected only one token after 10s")
+	}
+
+	// A bucket refills to its burst and no further
+	clock.advance(time.Hour)
+	for i := range 3 {
+		if ok, _ := allow(); !ok {
+			t.Fatalf("expected request %d of the refilled burst allowed", i+1)
+		}
+	}
+	if ok, _ := allow(); ok {
+		t.Error("expected the refilled bucket to hold only its burst")
+	}
+	if ok, _, _ := limiter.Allow(context.Background(), "user2", limit); !ok {
+		t.Error("expected other keys unaffected")
+	}
+}
+
+func TestMemoryRateLimiter_SweepsRefilledBuckets(t *testing.T) {
+	limiter, clock := newTestRateLimiter()
+	limit := RateLimit{Requests: 1, Per: time.Second}
+	_, _, _ = limiter.Allow(context.Background(), "user1", limit)
+	clock.advance(rateLimiterSweepInterval)
+	_, _, _ = limiter.Allow(context.Background(), "user2", limit)
+
+	if _, ok := limiter.buckets["user1"]; ok || len(limiter.buckets) != 1 {
+		t.Errorf("expected only user2's bucket kept, got %d buckets", len(limiter.buckets))
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    RateLimit
+		wantErr bool
+	}{
+		{"60/1m", RateLimit{Requests: 60, Per: time.Minute}, false},
+		{"5/30s", RateLimit{Requests: 5, Per: 30 * time.Second}, false},
+		{"0", RateLimit{}, false},
+		{"0/1m", RateLimit{}, false},
+		{"60", RateLimit{}, true},
+		{"x/1m", RateLimit{}, true},
+		{"60/0s", RateLimit{}, true},
+		{"-1/1m", RateLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRateLimit(%q) = %+v, %v; want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRateLimitsFrom_ParsesOverrides(t *testing.T) {
+	t.Setenv("RATE_LIMIT_OVERRIDES", "POST /api/v1/users/:user_id/agents=1/1h,GET /api/v1/admin/streams=0")
+	cfg, err := config.New()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	limits, err := rateLimitsFrom(cfg)
+	if err != nil {
+		t.Fatalf("failed to parse rate limits: %v", err)
+	}
+	if got := limits.Routes["POST /api/v1/users/:user_id/agents"]; got != (RateLimit{Requests: 1, Per: time.Hour}) {
+		t.Errorf("expected the create override, got %+v", got)
+	}
+	if got, ok := limits.Routes["GET /api/v1/admin/streams"]; !ok || !got.unlimited() {
+		t.Errorf("expected streams unlimited, got %+v", got)
+	}
+	if got := limits.Groups[RateLimitGroupMessage]; got != (RateLimit{Requests: 60, Per: time.Minute}) {
+		t.Errorf("expected the default message limit, got %+v", got)
+	}
+
+	cfg.RateLimitRead = "lots"
+	if _, err := rateLimitsFrom(cfg); err == nil {
+		t.Error("expected an invalid limit refused")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter, clock := newTestRateLimiter()
+	limits := RateLimits{
+		Groups: map[string]RateLimit{
+			RateLimitGroupCreate:  {Requests: 1, Per: time.Minute},
+			RateLimitGroupMessage: {Requests: 2, Per: time.Minute},
+			RateLimitGroupRead:    {Requests: 2, Per: time.Minute},
+		},
+		Routes: map[string]RateLimit{
+			"POST /api/v1/users/:user_id/agents/:agent_id/messages/batch": {Requests: 1, Per: time.Hour},
+		},
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = errors.HTTPErrorHandler(zap.NewNop())
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if user := c.Request().Header.Get("X-Test-User"); user != "" {
+				handler.SetPrincipal(c, handler.Principal{UserID: user})
+			}
+			return next(c)
+		}
+	})
+	e.Use(rateLimitMiddleware(limiter, limits, zap.NewNop()))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/healthz", ok)
+	e.POST("/api/v1/users/:user_id/agents", ok)
+	e.GET("/api/v1/users/:user_id/agents", ok)
+	e.POST("/api/v1/users/:user_id/agents/:agent_id/messages", ok)
+	e.POST("/api/v1/users/:user_id/agents/:agent_id/messages/batch", ok)
+	e.DELETE("/api/v1/users/:user_id/agents/:agent_id", ok)
+
+	serve := func(method, path, user, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/api/v1/users/user1/agents", "user1", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the first create allowed, got %d", rec.Code)
+	}
+	rec := serve(http.MethodPost, "/api/v1/users/user1/agents", "user1", "10.0.0.2")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After 60 for the user's second create, got %d and %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve(http.MethodPost, "/api/v1/users/user2/agents", "user2", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Errorf("expected another user's create allowed from the same IP, got %d", rec.Code)
+	}
+
+	// Groups have buckets of their own, as do overridden routes
+	for i := range 2 {
+		if rec := serve(http.MethodPost, "/api/v1/users/user1/agents/agent1/messages", "user1", "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Errorf("expected message %d allowed, got %d", i+1, rec.Code)
+		}
+	}
+	if rec := serve(http.MethodPost, "/api/v1/users/user1/agents/agent1/messages/batch", "user1", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Errorf("expected the batch in its own bucket, got %d", rec.Code)
+	}
+	if rec := serve(http.MethodPost, "/api/v1/users/user1/agents/agent1/messages/batch", "user1", "10.0.0.1"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("expected the batch override's limit, got %d and %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Unauthenticated callers are limited by IP
+	for range 2 {
+		serve(http.MethodGet, "/api/v1/users/user1/agents", "", "10.0.0.9")
+	}
+	if rec := serve(http.MethodGet, "/api/v1/users/user1/agents", "", "10.0.0.9"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the IP's third read limited, got %d", rec.Code)
+	}
+	if rec := serve(http.MethodGet, "/api/v1/users/user1/agents", "", "10.0.0.10"); rec.Code != http.StatusOK {
+		t.Errorf("expected another IP's read allowed, got %d", rec.Code)
+	}
+
+	// Health checks and routes outside the groups are not limited
+	for range 5 {
+		if rec := serve(http.MethodGet, "/healthz", "", "10.0.0.9"); rec.Code != http.StatusOK {
+			t.Fatalf("expected health checks unlimited, got %d", rec.Code)
+		}
+		if rec := serve(http.MethodDelete, "/api/v1/users/user1/agents/agent1", "user1", "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("expected deletes unlimited, got %d", rec.Code)
+		}
+	}
+
+	clock.advance(time.Minute)
+	if rec := serve(http.MethodPost, "/api/v1/users/user1/agents", "user1", "10.0.0.1"); rec.Code != http.StatusOK {
+		t.Errorf("expected a create allowed once the bucket refilled, got %d", rec.Code)
+	}
+}
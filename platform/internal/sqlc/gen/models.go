@@ -9,24 +9,188 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type AgentMessage struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	AgentID   string    `json:"agent_id"`
+	SessionID string    `json:"session_id"`
+	RequestID string    `json:"request_id"`
+	Seq       int64     `json:"seq"`
+	Role      string    `json:"role"`
+	Payload   []byte    `json:"payload"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AgentResponseArchive struct {
+	RequestID string    `json:"request_id"`
+	Seq       int64     `json:"seq"`
+	Response  []byte    `json:"response"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ApiKey struct {
+	ID        int64        `json:"id"`
+	UserID    string       `json:"user_id"`
+	Name      string       `json:"name"`
+	KeyHash   string       `json:"key_hash"`
+	Admin     bool         `json:"admin"`
+	CreatedAt time.Time    `json:"created_at"`
+	RevokedAt sql.NullTime `json:"revoked_at"`
+}
+
+type DeliveryReceipt struct {
+	ID        int64     `json:"id"`
+	RequestID string    `json:"request_id"`
+	FromSeq   int64     `json:"from_seq"`
+	ToSeq     int64     `json:"to_seq"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdempotencyKey struct {
+	KeyHash      string         `json:"key_hash"`
+	RequestHash  string         `json:"request_hash"`
+	StatusCode   pgtype.Int4    `json:"status_code"`
+	ContentType  sql.NullString `json:"content_type"`
+	ResponseBody []byte         `json:"response_body"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+}
+
+type LifecycleWebhook struct {
+	ID        int64          `json:"id"`
+	UserID    string         `json:"user_id"`
+	AgentID   sql.NullString `json:"agent_id"`
+	Url       string         `json:"url"`
+	Secret    sql.NullString `json:"secret"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+type RequestArtifact struct {
+	RequestID string    `json:"request_id"`
+	Name      string    `json:"name"`
+	UserID    string    `json:"user_id"`
+	AgentID   string    `json:"agent_id"`
+	MediaType string    `json:"media_type"`
+	SizeBytes int64     `json:"size_bytes"`
+	Data      []byte    `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RequestBatch struct {
+	BatchID         string         `json:"batch_id"`
+	AgentID         string         `json:"agent_id"`
+	StepCount       int32          `json:"step_count"`
+	ContinueOnError bool           `json:"continue_on_error"`
+	Status          string         `json:"status"`
+	CurrentStep     int32          `json:"current_step"`
+	FailedSteps     int32          `json:"failed_steps"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	CompletedAt     sql.NullTime   `json:"completed_at"`
+	UserID          sql.NullString `json:"user_id"`
+}
+
+type RequestUsage struct {
+	RequestID        string    `json:"request_id"`
+	UserID           string    `json:"user_id"`
+	AgentID          string    `json:"agent_id"`
+	InputTokens      int64     `json:"input_tokens"`
+	OutputTokens     int64     `json:"output_tokens"`
+	CacheReadTokens  int64     `json:"cache_read_tokens"`
+	CacheWriteTokens int64     `json:"cache_write_tokens"`
+	CostUsd          float64   `json:"cost_usd"`
+	DurationMs       int64     `json:"duration_ms"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type SelftestReport struct {
+	ID        int64     `json:"id"`
+	Trigger   string    `json:"trigger"`
+	Outcome   string    `json:"outcome"`
+	Report    []byte    `json:"report"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserBudget struct {
+	UserID    string    `json:"user_id"`
+	LimitUsd  float64   `json:"limit_usd"`
+	Period    string    `json:"period"`
+	Soft      bool      `json:"soft"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type WebhookDelivery struct {
-	ID                  uuid.UUID      `json:"id"`
-	RequestID           string         `json:"request_id"`
-	AgentID             string         `json:"agent_id"`
-	WebhookUrl          string         `json:"webhook_url"`
-	WebhookSecretHash   sql.NullString `json:"webhook_secret_hash"`
-	Seq                 int64          `json:"seq"`
-	LastEventType       sql.NullString `json:"last_event_type"`
-	Status              string         `json:"status"`
-	AttemptCount        int32          `json:"attempt_count"`
-	LastAttemptAt       sql.NullTime   `json:"last_attempt_at"`
-	NextRetryAt         sql.NullTime   `json:"next_retry_at"`
-	LastError           sql.NullString `json:"last_error"`
-	ConsecutiveFailures int32          `json:"consecutive_failures"`
-	CircuitOpenUntil    sql.NullTime   `json:"circuit_open_until"`
-	CreatedAt           time.Time      `json:"created_at"`
-	UpdatedAt           time.Time      `json:"updated_at"`
-	CompletedAt         sql.NullTime   `json:"completed_at"`
+	ID                     uuid.UUID      `json:"id"`
+	RequestID              string         `json:"request_id"`
+	AgentID                string         `json:"agent_id"`
+	WebhookUrl             string         `json:"webhook_url"`
+	WebhookSecretHash      sql.NullString `json:"webhook_secret_hash"`
+	Seq                    int64          `json:"seq"`
+	LastEventType          sql.NullString `json:"last_event_type"`
+	Status                 string         `json:"status"`
+	AttemptCount           int32          `json:"attempt_count"`
+	LastAttemptAt          sql.NullTime   `json:"last_attempt_at"`
+	NextRetryAt            sql.NullTime   `json:"next_retry_at"`
+	LastError              sql.NullString `json:"last_error"`
+	ConsecutiveFailures    int32          `json:"consecutive_failures"`
+	CircuitOpenUntil       sql.NullTime   `json:"circuit_open_until"`
+	CreatedAt              time.Time      `json:"created_at"`
+	UpdatedAt              time.Time      `json:"updated_at"`
+	CompletedAt            sql.NullTime   `json:"completed_at"`
+	IncludeThinking        bool           `json:"include_thinking"`
+	BatchID                sql.NullString `json:"batch_id"`
+	BatchStep              pgtype.Int4    `json:"batch_step"`
+	EndpointIndex          int32          `json:"endpoint_index"`
+	WebhookHeaderNames     []string       `json:"webhook_header_names"`
+	WebhookBearerTokenHash sql.NullString `json:"webhook_bearer_token_hash"`
+	UserID                 sql.NullString `json:"user_id"`
+}
+
+type WebhookDeliveryAttempt struct {
+	ID         int64          `json:"id"`
+	RequestID  string         `json:"request_id"`
+	Seq        int64          `json:"seq"`
+	WebhookUrl string         `json:"webhook_url"`
+	Attempt    int32          `json:"attempt"`
+	StatusCode pgtype.Int4    `json:"status_code"`
+	DurationMs int32          `json:"duration_ms"`
+	Error      sql.NullString `json:"error"`
+	CreatedAt  time.Time      `json:"created_at"`
+	DeliveryID string         `json:"delivery_id"`
+}
+
+type WebhookEvent struct {
+	RequestID string    `json:"request_id"`
+	Seq       int64     `json:"seq"`
+	EventType string    `json:"event_type"`
+	Payload   []byte    `json:"payload"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookOutbox struct {
+	ID                     int64          `json:"id"`
+	RequestID              string         `json:"request_id"`
+	Seq                    int64          `json:"seq"`
+	WebhookUrl             string         `json:"webhook_url"`
+	WebhookSecret          sql.NullString `json:"webhook_secret"`
+	Payload                []byte         `json:"payload"`
+	Status                 string         `json:"status"`
+	AttemptCount           int32          `json:"attempt_count"`
+	NextAttemptAt          time.Time      `json:"next_attempt_at"`
+	LastError              sql.NullString `json:"last_error"`
+	CreatedAt              time.Time      `json:"created_at"`
+	UpdatedAt              time.Time      `json:"updated_at"`
+	DeliveredAt            sql.NullTime   `json:"delivered_at"`
+	WebhookHeaders         []byte         `json:"webhook_headers"`
+	WebhookBearerToken     sql.NullString `json:"webhook_bearer_token"`
+	WebhookSecondarySecret sql.NullString `json:"webhook_secondary_secret"`
+	Ordered                bool           `json:"ordered"`
+	SchemaVersion          string         `json:"schema_version"`
+	RateLimitRps           float64        `json:"rate_limit_rps"`
+	RateBurst              int32          `json:"rate_burst"`
 }
@@ -6,27 +6,126 @@ package sqlc
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Querier interface {
+	ArchiveAgentResponse(ctx context.Context, arg *ArchiveAgentResponseParams) error
+	// Claims due events by pushing next_attempt_at out to lease_until, so an event whose
+	// worker dies is retried once the lease expires. Only the lowest pending seq of each
+	// request/URL is claimable, which keeps deliveries in order.
+	ClaimOutboxEvents(ctx context.Context, arg *ClaimOutboxEventsParams) ([]*WebhookOutbox, error)
 	CloseCircuitForURL(ctx context.Context, webhookUrl string) error
+	CompleteIdempotencyKey(ctx context.Context, arg *CompleteIdempotencyKeyParams) error
+	CountDeliveriesBefore(ctx context.Context, arg *CountDeliveriesBeforeParams) (int64, error)
+	// Events of a request, to any endpoint, still waiting to be delivered before seq
+	CountPendingOutboxEventsBefore(ctx context.Context, arg *CountPendingOutboxEventsBeforeParams) (int32, error)
+	CreateAPIKey(ctx context.Context, arg *CreateAPIKeyParams) (*ApiKey, error)
+	CreateLifecycleWebhook(ctx context.Context, arg *CreateLifecycleWebhookParams) (*LifecycleWebhook, error)
+	CreateRequestBatch(ctx context.Context, arg *CreateRequestBatchParams) (*RequestBatch, error)
 	CreateWebhookDelivery(ctx context.Context, arg *CreateWebhookDeliveryParams) (*WebhookDelivery, error)
+	DeadLetterOutboxEvent(ctx context.Context, arg *DeadLetterOutboxEventParams) error
+	DeleteAgentMessagesBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteArchivedAgentResponsesBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteDeliveredOutboxEventsBefore(ctx context.Context, deliveredAt sql.NullTime) (int64, error)
+	// Deletes up to batch_size delivery records with the given status last updated before the
+	// cutoff, so a large purge takes many short locks instead of one long one
+	DeleteDeliveriesBefore(ctx context.Context, arg *DeleteDeliveriesBeforeParams) (int64, error)
+	DeleteDeliveryReceiptsBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteIdempotencyKey(ctx context.Context, keyHash string) error
+	DeleteIdempotencyKeysBefore(ctx context.Context, expiresAt time.Time) (int64, error)
+	DeleteLifecycleWebhook(ctx context.Context, arg *DeleteLifecycleWebhookParams) (int64, error)
+	DeleteRequestArtifactsBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteSelftestReportsBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteUserBudget(ctx context.Context, userID string) (int64, error)
+	DeleteWebhookDeliveryAttemptsBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteWebhookEventsBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	EnqueueOutboxEvent(ctx context.Context, arg *EnqueueOutboxEventParams) error
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*ApiKey, error)
 	GetActiveDeliveriesForAgent(ctx context.Context, agentID string) ([]*WebhookDelivery, error)
+	GetArchivedAgentResponse(ctx context.Context, arg *GetArchivedAgentResponseParams) (*AgentResponseArchive, error)
 	GetConsecutiveFailures(ctx context.Context, webhookUrl string) (int32, error)
+	GetIdempotencyKey(ctx context.Context, keyHash string) (*IdempotencyKey, error)
+	// Highest seq stored for an agent, 0 if none
+	GetLatestAgentMessageSeq(ctx context.Context, arg *GetLatestAgentMessageSeqParams) (int64, error)
+	GetLatestSelftestReport(ctx context.Context) (*SelftestReport, error)
+	GetLifecycleWebhook(ctx context.Context, arg *GetLifecycleWebhookParams) (*LifecycleWebhook, error)
+	GetOutboxStats(ctx context.Context, deadLettersSince time.Time) (*GetOutboxStatsRow, error)
 	GetPendingRetries(ctx context.Context, limit int32) ([]*WebhookDelivery, error)
+	GetRequestArtifact(ctx context.Context, arg *GetRequestArtifactParams) (*RequestArtifact, error)
+	GetRequestBatch(ctx context.Context, batchID string) (*RequestBatch, error)
+	GetUserBudget(ctx context.Context, userID string) (*UserBudget, error)
+	// Returns the record of the request's first endpoint, which stands for the request
 	GetWebhookDelivery(ctx context.Context, requestID string) (*WebhookDelivery, error)
+	// Number of delivery attempts of a request, and the status code of the latest one
+	// (0 if it got no response or there were none)
+	GetWebhookDeliveryAttemptSummary(ctx context.Context, requestID string) (*GetWebhookDeliveryAttemptSummaryRow, error)
 	GetWebhookDeliveryByID(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error)
+	GetWebhookEvent(ctx context.Context, arg *GetWebhookEventParams) (*WebhookEvent, error)
+	// Stores a message unless the agent's session already has one with its seq
+	InsertAgentMessage(ctx context.Context, arg *InsertAgentMessageParams) (int64, error)
+	InsertDeliveryReceipt(ctx context.Context, arg *InsertDeliveryReceiptParams) error
+	InsertSelftestReport(ctx context.Context, arg *InsertSelftestReportParams) error
+	InsertWebhookDeliveryAttempts(ctx context.Context, arg []*InsertWebhookDeliveryAttemptsParams) (int64, error)
 	IsCircuitOpen(ctx context.Context, webhookUrl string) (bool, error)
+	// An agent's messages from from_seq onwards, oldest first, capped at max_rows
+	ListAgentMessages(ctx context.Context, arg *ListAgentMessagesParams) ([]*AgentMessage, error)
+	ListBatchDeliveries(ctx context.Context, batchID sql.NullString) ([]*WebhookDelivery, error)
+	ListDeadLetters(ctx context.Context, limit int32) ([]*WebhookOutbox, error)
+	// Records made before owners were stored have no user_id and are listed for every user
+	ListDeliveriesByAgent(ctx context.Context, arg *ListDeliveriesByAgentParams) ([]*WebhookDelivery, error)
+	ListDeliveryReceipts(ctx context.Context, requestID string) ([]*DeliveryReceipt, error)
+	ListLifecycleWebhooks(ctx context.Context, userID string) ([]*LifecycleWebhook, error)
+	// Webhooks registered for all of the user's agents, or for this one
+	ListLifecycleWebhooksForAgent(ctx context.Context, arg *ListLifecycleWebhooksForAgentParams) ([]*LifecycleWebhook, error)
+	ListOutboxEventsForSeq(ctx context.Context, arg *ListOutboxEventsForSeqParams) ([]*WebhookOutbox, error)
+	// The pending events of a request/URL after seq, in seq order
+	ListPendingOutboxEventsAfter(ctx context.Context, arg *ListPendingOutboxEventsAfterParams) ([]*WebhookOutbox, error)
+	ListRequestArtifacts(ctx context.Context, arg *ListRequestArtifactsParams) ([]*ListRequestArtifactsRow, error)
+	// Endpoint delivery records newest first; each filter applies only when set
+	ListWebhookDeliveries(ctx context.Context, arg *ListWebhookDeliveriesParams) ([]*WebhookDelivery, error)
+	// Attempts of a request in the order they were made, capped at max_attempts
+	ListWebhookDeliveryAttempts(ctx context.Context, arg *ListWebhookDeliveryAttemptsParams) ([]*WebhookDeliveryAttempt, error)
+	ListWebhookDeliveryEndpoints(ctx context.Context, requestID string) ([]*WebhookDelivery, error)
+	ListWebhookEvents(ctx context.Context, arg *ListWebhookEventsParams) ([]*WebhookEvent, error)
+	ListWebhookEventsFollowingSeq(ctx context.Context, arg *ListWebhookEventsFollowingSeqParams) ([]*WebhookEvent, error)
+	ListWebhookEventsPrecedingSeq(ctx context.Context, arg *ListWebhookEventsPrecedingSeqParams) ([]*WebhookEvent, error)
 	MarkDeliveryCompleted(ctx context.Context, requestID string) error
 	MarkDeliveryFailed(ctx context.Context, requestID string) error
+	MarkOutboxDelivered(ctx context.Context, id int64) error
+	MarkOutboxEventsDelivered(ctx context.Context, ids []int64) error
 	OpenCircuitForURL(ctx context.Context, arg *OpenCircuitForURLParams) error
 	RecordDeliveryAttempt(ctx context.Context, requestID string) error
+	// Records a failed attempt to one endpoint of a request; next_retry_at is NULL once it gives up
 	RecordDeliveryFailure(ctx context.Context, arg *RecordDeliveryFailureParams) error
-	RecordDeliverySuccess(ctx context.Context, requestID string) error
+	RecordDeliverySuccess(ctx context.Context, arg *RecordDeliverySuccessParams) error
+	// Returns a claimed event without counting the attempt (e.g. circuit breaker open)
+	ReleaseOutboxEvent(ctx context.Context, arg *ReleaseOutboxEventParams) error
+	// Replaces the payload of a claimed event and queues it again with fresh attempts
+	ReplaceOutboxPayload(ctx context.Context, arg *ReplaceOutboxPayloadParams) error
+	// Claims the key for a request being handled, taking over a key that expired
+	ReserveIdempotencyKey(ctx context.Context, arg *ReserveIdempotencyKeyParams) (int64, error)
+	RescheduleOutboxEvent(ctx context.Context, arg *RescheduleOutboxEventParams) error
+	RevokeAPIKey(ctx context.Context, id int64) (int64, error)
+	SetDeliveryBatchStep(ctx context.Context, arg *SetDeliveryBatchStepParams) error
+	// A user's usage of requests made in [from_time, to_time), summed per agent and UTC day
+	SumRequestUsage(ctx context.Context, arg *SumRequestUsageParams) ([]*SumRequestUsageRow, error)
+	// Cost of a user's requests made since the given time
+	SumUserCostSince(ctx context.Context, arg *SumUserCostSinceParams) (float64, error)
+	// Keeps each agent's newest max_per_agent messages
+	TrimAgentMessages(ctx context.Context, maxPerAgent int64) (int64, error)
 	UpdateDeliverySeq(ctx context.Context, arg *UpdateDeliverySeqParams) error
 	UpdateDeliveryStatus(ctx context.Context, arg *UpdateDeliveryStatusParams) error
+	UpdateLifecycleWebhook(ctx context.Context, arg *UpdateLifecycleWebhookParams) (*LifecycleWebhook, error)
+	UpdateRequestBatchProgress(ctx context.Context, arg *UpdateRequestBatchProgressParams) error
+	UpsertRequestArtifact(ctx context.Context, arg *UpsertRequestArtifactParams) error
+	// Stores a request's usage, replacing what was stored for it before
+	UpsertRequestUsage(ctx context.Context, arg *UpsertRequestUsageParams) error
+	UpsertUserBudget(ctx context.Context, arg *UpsertUserBudgetParams) (*UserBudget, error)
+	UpsertWebhookEvent(ctx context.Context, arg *UpsertWebhookEventParams) error
 }
 
 var _ Querier = (*Queries)(nil)
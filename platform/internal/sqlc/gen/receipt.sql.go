@@ -0,0 +1,73 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: receipt.sql
+
+package sqlc
+
+import (
+	"context"
+	"time"
+)
+
+const deleteDeliveryReceiptsBefore = `-- name: DeleteDeliveryReceiptsBefore :execrows
+DELETE FROM delivery_receipts
+WHERE created_at < $1
+`
+
+func (q *Queries) DeleteDeliveryReceiptsBefore(ctx context.Context, createdAt time.Time) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteDeliveryReceiptsBefore, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const insertDeliveryReceipt = `-- name: InsertDeliveryReceipt :exec
+INSERT INTO delivery_receipts (
+    request_id, from_seq, to_seq
+) VALUES ($1, $2, $3)
+`
+
+type InsertDeliveryReceiptParams struct {
+	RequestID string `json:"request_id"`
+	FromSeq   int64  `json:"from_seq"`
+	ToSeq     int64  `json:"to_seq"`
+}
+
+func (q *Queries) InsertDeliveryReceipt(ctx context.Context, arg *InsertDeliveryReceiptParams) error {
+	_, err := q.db.Exec(ctx, insertDeliveryReceipt, arg.RequestID, arg.FromSeq, arg.ToSeq)
+	return err
+}
+
+const listDeliveryReceipts = `-- name: ListDeliveryReceipts :many
+SELECT id, request_id, from_seq, to_seq, created_at FROM delivery_receipts
+WHERE request_id = $1
+ORDER BY from_seq, to_seq
+`
+
+func (q *Queries) ListDeliveryReceipts(ctx context.Context, requestID string) ([]*DeliveryReceipt, error) {
+	rows, err := q.db.Query(ctx, listDeliveryReceipts, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*DeliveryReceipt{}
+	for rows.Next() {
+		var i DeliveryReceipt
+		if err := rows.Scan(
+			&i.ID,
+			&i.RequestID,
+			&i.FromSeq,
+			&i.ToSeq,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -8,6 +8,7 @@ package sqlc
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -23,18 +24,41 @@ func (q *Queries) CloseCircuitForURL(ctx context.Context, webhookUrl string) err
 	return err
 }
 
+const countDeliveriesBefore = `-- name: CountDeliveriesBefore :one
+SELECT COUNT(*) FROM webhook_deliveries
+WHERE status = $1 AND updated_at < $2
+`
+
+type CountDeliveriesBeforeParams struct {
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (q *Queries) CountDeliveriesBefore(ctx context.Context, arg *CountDeliveriesBeforeParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countDeliveriesBefore, arg.Status, arg.UpdatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createWebhookDelivery = `-- name: CreateWebhookDelivery :one
 INSERT INTO webhook_deliveries (
-    request_id, agent_id, webhook_url, webhook_secret_hash
-) VALUES ($1, $2, $3, $4)
-RETURNING id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at
+    request_id, agent_id, webhook_url, webhook_secret_hash, include_thinking, endpoint_index,
+    webhook_header_names, webhook_bearer_token_hash, user_id
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id
 `
 
 type CreateWebhookDeliveryParams struct {
-	RequestID         string         `json:"request_id"`
-	AgentID           string         `json:"agent_id"`
-	WebhookUrl        string         `json:"webhook_url"`
-	WebhookSecretHash sql.NullString `json:"webhook_secret_hash"`
+	RequestID              string         `json:"request_id"`
+	AgentID                string         `json:"agent_id"`
+	WebhookUrl             string         `json:"webhook_url"`
+	WebhookSecretHash      sql.NullString `json:"webhook_secret_hash"`
+	IncludeThinking        bool           `json:"include_thinking"`
+	EndpointIndex          int32          `json:"endpoint_index"`
+	WebhookHeaderNames     []string       `json:"webhook_header_names"`
+	WebhookBearerTokenHash sql.NullString `json:"webhook_bearer_token_hash"`
+	UserID                 sql.NullString `json:"user_id"`
 }
 
 func (q *Queries) CreateWebhookDelivery(ctx context.Context, arg *CreateWebhookDeliveryParams) (*WebhookDelivery, error) {
@@ -43,6 +67,11 @@ func (q *Queries) CreateWebhookDelivery(ctx context.Context, arg *CreateWebhookD
 		arg.AgentID,
 		arg.WebhookUrl,
 		arg.WebhookSecretHash,
+		arg.IncludeThinking,
+		arg.EndpointIndex,
+		arg.WebhookHeaderNames,
+		arg.WebhookBearerTokenHash,
+		arg.UserID,
 	)
 	var i WebhookDelivery
 	err := row.Scan(
@@ -63,12 +92,57 @@ func (q *Queries) CreateWebhookDelivery(ctx context.Context, arg *CreateWebhookD
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.CompletedAt,
+		&i.IncludeThinking,
+		&i.BatchID,
+		&i.BatchStep,
+		&i.EndpointIndex,
+		&i.WebhookHeaderNames,
+		&i.WebhookBearerTokenHash,
+		&i.UserID,
 	)
 	return &i, err
 }
 
+const deleteDeliveriesBefore = `-- name: DeleteDeliveriesBefore :execrows
+DELETE FROM webhook_deliveries
+WHERE id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = $1 AND updated_at < $2
+    LIMIT $3
+)
+`
+
+type DeleteDeliveriesBeforeParams struct {
+	Status    string    `json:"status"`
+	Before    time.Time `json:"before"`
+	BatchSize int32     `json:"batch_size"`
+}
+
+// Deletes up to batch_size delivery records with the given status last updated before the
+// cutoff, so a large purge takes many short locks instead of one long one
+func (q *Queries) DeleteDeliveriesBefore(ctx context.Context, arg *DeleteDeliveriesBeforeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteDeliveriesBefore, arg.Status, arg.Before, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteWebhookEventsBefore = `-- name: DeleteWebhookEventsBefore :execrows
+DELETE FROM webhook_events
+WHERE created_at < $1
+`
+
+func (q *Queries) DeleteWebhookEventsBefore(ctx context.Context, createdAt time.Time) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebhookEventsBefore, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getActiveDeliveriesForAgent = `-- name: GetActiveDeliveriesForAgent :many
-SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at FROM webhook_deliveries
+SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id FROM webhook_deliveries
 WHERE agent_id = $1
   AND status IN ('pending', 'delivering')
 ORDER BY created_at DESC
@@ -101,6 +175,13 @@ func (q *Queries) GetActiveDeliveriesForAgent(ctx context.Context, agentID strin
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.CompletedAt,
+			&i.IncludeThinking,
+			&i.BatchID,
+			&i.BatchStep,
+			&i.EndpointIndex,
+			&i.WebhookHeaderNames,
+			&i.WebhookBearerTokenHash,
+			&i.UserID,
 		); err != nil {
 			return nil, err
 		}
@@ -127,7 +208,7 @@ func (q *Queries) GetConsecutiveFailures(ctx context.Context, webhookUrl string)
 }
 
 const getPendingRetries = `-- name: GetPendingRetries :many
-SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at FROM webhook_deliveries
+SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id FROM webhook_deliveries
 WHERE status = 'pending'
   AND next_retry_at IS NOT NULL
   AND next_retry_at <= NOW()
@@ -163,6 +244,13 @@ func (q *Queries) GetPendingRetries(ctx context.Context, limit int32) ([]*Webhoo
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.CompletedAt,
+			&i.IncludeThinking,
+			&i.BatchID,
+			&i.BatchStep,
+			&i.EndpointIndex,
+			&i.WebhookHeaderNames,
+			&i.WebhookBearerTokenHash,
+			&i.UserID,
 		); err != nil {
 			return nil, err
 		}
@@ -175,9 +263,10 @@ func (q *Queries) GetPendingRetries(ctx context.Context, limit int32) ([]*Webhoo
 }
 
 const getWebhookDelivery = `-- name: GetWebhookDelivery :one
-SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at FROM webhook_deliveries WHERE request_id = $1
+SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id FROM webhook_deliveries WHERE request_id = $1 AND endpoint_index = 0
 `
 
+// Returns the record of the request's first endpoint, which stands for the request
 func (q *Queries) GetWebhookDelivery(ctx context.Context, requestID string) (*WebhookDelivery, error) {
 	row := q.db.QueryRow(ctx, getWebhookDelivery, requestID)
 	var i WebhookDelivery
@@ -199,12 +288,19 @@ func (q *Queries) GetWebhookDelivery(ctx context.Context, requestID string) (*We
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.CompletedAt,
+		&i.IncludeThinking,
+		&i.BatchID,
+		&i.BatchStep,
+		&i.EndpointIndex,
+		&i.WebhookHeaderNames,
+		&i.WebhookBearerTokenHash,
+		&i.UserID,
 	)
 	return &i, err
 }
 
 const getWebhookDeliveryByID = `-- name: GetWebhookDeliveryByID :one
-SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at FROM webhook_deliveries WHERE id = $1
+SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id FROM webhook_deliveries WHERE id = $1
 `
 
 func (q *Queries) GetWebhookDeliveryByID(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
@@ -228,6 +324,36 @@ func (q *Queries) GetWebhookDeliveryByID(ctx context.Context, id uuid.UUID) (*We
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.CompletedAt,
+		&i.IncludeThinking,
+		&i.BatchID,
+		&i.BatchStep,
+		&i.EndpointIndex,
+		&i.WebhookHeaderNames,
+		&i.WebhookBearerTokenHash,
+		&i.UserID,
+	)
+	return &i, err
+}
+
+const getWebhookEvent = `-- name: GetWebhookEvent :one
+SELECT request_id, seq, event_type, payload, created_at FROM webhook_events
+WHERE request_id = $1 AND seq = $2
+`
+
+type GetWebhookEventParams struct {
+	RequestID string `json:"request_id"`
+	Seq       int64  `json:"seq"`
+}
+
+func (q *Queries) GetWebhookEvent(ctx context.Context, arg *GetWebhookEventParams) (*WebhookEvent, error) {
+	row := q.db.QueryRow(ctx, getWebhookEvent, arg.RequestID, arg.Seq)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.RequestID,
+		&i.Seq,
+		&i.EventType,
+		&i.Payload,
+		&i.CreatedAt,
 	)
 	return &i, err
 }
@@ -248,9 +374,310 @@ func (q *Queries) IsCircuitOpen(ctx context.Context, webhookUrl string) (bool, e
 	return is_open, err
 }
 
+const listDeliveriesByAgent = `-- name: ListDeliveriesByAgent :many
+SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id FROM webhook_deliveries
+WHERE agent_id = $1 AND (user_id = $3::text OR user_id IS NULL) AND endpoint_index = 0
+ORDER BY created_at DESC
+LIMIT $2
+`
+
+type ListDeliveriesByAgentParams struct {
+	AgentID string `json:"agent_id"`
+	Limit   int32  `json:"limit"`
+	UserID  string `json:"user_id"`
+}
+
+// Records made before owners were stored have no user_id and are listed for every user
+
+func (q *Queries) ListDeliveriesByAgent(ctx context.Context, arg *ListDeliveriesByAgentParams) ([]*WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listDeliveriesByAgent, arg.AgentID, arg.Limit, arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*WebhookDelivery{}
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.RequestID,
+			&i.AgentID,
+			&i.WebhookUrl,
+			&i.WebhookSecretHash,
+			&i.Seq,
+			&i.LastEventType,
+			&i.Status,
+			&i.AttemptCount,
+			&i.LastAttemptAt,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.CircuitOpenUntil,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CompletedAt,
+			&i.IncludeThinking,
+			&i.BatchID,
+			&i.BatchStep,
+			&i.EndpointIndex,
+			&i.WebhookHeaderNames,
+			&i.WebhookBearerTokenHash,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookDeliveries = `-- name: ListWebhookDeliveries :many
+SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id FROM webhook_deliveries
+WHERE ($1::text IS NULL OR agent_id = $1)
+  AND ($2::text IS NULL OR status = $2)
+  AND ($3::timestamptz IS NULL OR created_at > $3)
+  AND ($4::text IS NULL OR starts_with(webhook_url, $4))
+ORDER BY created_at DESC, id DESC
+LIMIT $6 OFFSET $5
+`
+
+type ListWebhookDeliveriesParams struct {
+	AgentID          sql.NullString `json:"agent_id"`
+	Status           sql.NullString `json:"status"`
+	CreatedAfter     sql.NullTime   `json:"created_after"`
+	WebhookUrlPrefix sql.NullString `json:"webhook_url_prefix"`
+	SkipRows         int32          `json:"skip_rows"`
+	MaxRows          int32          `json:"max_rows"`
+}
+
+// Endpoint delivery records newest first; each filter applies only when set
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, arg *ListWebhookDeliveriesParams) ([]*WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveries,
+		arg.AgentID,
+		arg.Status,
+		arg.CreatedAfter,
+		arg.WebhookUrlPrefix,
+		arg.SkipRows,
+		arg.MaxRows,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*WebhookDelivery{}
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.RequestID,
+			&i.AgentID,
+			&i.WebhookUrl,
+			&i.WebhookSecretHash,
+			&i.Seq,
+			&i.LastEventType,
+			&i.Status,
+			&i.AttemptCount,
+			&i.LastAttemptAt,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.CircuitOpenUntil,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CompletedAt,
+			&i.IncludeThinking,
+			&i.BatchID,
+			&i.BatchStep,
+			&i.EndpointIndex,
+			&i.WebhookHeaderNames,
+			&i.WebhookBearerTokenHash,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookDeliveryEndpoints = `-- name: ListWebhookDeliveryEndpoints :many
+SELECT id, request_id, agent_id, webhook_url, webhook_secret_hash, seq, last_event_type, status, attempt_count, last_attempt_at, next_retry_at, last_error, consecutive_failures, circuit_open_until, created_at, updated_at, completed_at, include_thinking, batch_id, batch_step, endpoint_index, webhook_header_names, webhook_bearer_token_hash, user_id FROM webhook_deliveries
+WHERE request_id = $1
+ORDER BY endpoint_index
+`
+
+func (q *Queries) ListWebhookDeliveryEndpoints(ctx context.Context, requestID string) ([]*WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveryEndpoints, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*WebhookDelivery{}
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.RequestID,
+			&i.AgentID,
+			&i.WebhookUrl,
+			&i.WebhookSecretHash,
+			&i.Seq,
+			&i.LastEventType,
+			&i.Status,
+			&i.AttemptCount,
+			&i.LastAttemptAt,
+			&i.NextRetryAt,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.CircuitOpenUntil,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CompletedAt,
+			&i.IncludeThinking,
+			&i.BatchID,
+			&i.BatchStep,
+			&i.EndpointIndex,
+			&i.WebhookHeaderNames,
+			&i.WebhookBearerTokenHash,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEvents = `-- name: ListWebhookEvents :many
+SELECT request_id, seq, event_type, payload, created_at FROM webhook_events
+WHERE request_id = $1
+  AND seq >= $2
+ORDER BY seq
+`
+
+type ListWebhookEventsParams struct {
+	RequestID string `json:"request_id"`
+	FromSeq   int64  `json:"from_seq"`
+}
+
+func (q *Queries) ListWebhookEvents(ctx context.Context, arg *ListWebhookEventsParams) ([]*WebhookEvent, error) {
+	rows, err := q.db.Query(ctx, listWebhookEvents, arg.RequestID, arg.FromSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*WebhookEvent{}
+	for rows.Next() {
+		var i WebhookEvent
+		if err := rows.Scan(
+			&i.RequestID,
+			&i.Seq,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEventsFollowingSeq = `-- name: ListWebhookEventsFollowingSeq :many
+SELECT request_id, seq, event_type, payload, created_at FROM webhook_events
+WHERE request_id = $1
+  AND seq > $2
+ORDER BY seq
+LIMIT $3
+`
+
+type ListWebhookEventsFollowingSeqParams struct {
+	RequestID string `json:"request_id"`
+	Seq       int64  `json:"seq"`
+	MaxEvents int32  `json:"max_events"`
+}
+
+func (q *Queries) ListWebhookEventsFollowingSeq(ctx context.Context, arg *ListWebhookEventsFollowingSeqParams) ([]*WebhookEvent, error) {
+	rows, err := q.db.Query(ctx, listWebhookEventsFollowingSeq, arg.RequestID, arg.Seq, arg.MaxEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*WebhookEvent{}
+	for rows.Next() {
+		var i WebhookEvent
+		if err := rows.Scan(
+			&i.RequestID,
+			&i.Seq,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookEventsPrecedingSeq = `-- name: ListWebhookEventsPrecedingSeq :many
+SELECT request_id, seq, event_type, payload, created_at FROM webhook_events
+WHERE request_id = $1
+  AND seq < $2
+ORDER BY seq DESC
+LIMIT $3
+`
+
+type ListWebhookEventsPrecedingSeqParams struct {
+	RequestID string `json:"request_id"`
+	Seq       int64  `json:"seq"`
+	MaxEvents int32  `json:"max_events"`
+}
+
+func (q *Queries) ListWebhookEventsPrecedingSeq(ctx context.Context, arg *ListWebhookEventsPrecedingSeqParams) ([]*WebhookEvent, error) {
+	rows, err := q.db.Query(ctx, listWebhookEventsPrecedingSeq, arg.RequestID, arg.Seq, arg.MaxEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*WebhookEvent{}
+	for rows.Next() {
+		var i WebhookEvent
+		if err := rows.Scan(
+			&i.RequestID,
+			&i.Seq,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const markDeliveryCompleted = `-- name: MarkDeliveryCompleted :exec
 UPDATE webhook_deliveries
-SET status = 'completed', completed_at = NOW(), updated_at = NOW(), consecutive_failures = 0
+SET status = 'completed', completed_at = NOW(), updated_at = NOW()
 WHERE request_id = $1
 `
 
@@ -301,41 +728,60 @@ func (q *Queries) RecordDeliveryAttempt(ctx context.Context, requestID string) e
 
 const recordDeliveryFailure = `-- name: RecordDeliveryFailure :exec
 UPDATE webhook_deliveries
-SET last_error = $2,
+SET attempt_count = attempt_count + 1,
+    last_attempt_at = NOW(),
+    last_error = $3,
     consecutive_failures = consecutive_failures + 1,
-    next_retry_at = $3,
+    next_retry_at = $4,
     updated_at = NOW()
-WHERE request_id = $1
+WHERE request_id = $1 AND webhook_url = $2
 `
 
 type RecordDeliveryFailureParams struct {
 	RequestID   string         `json:"request_id"`
+	WebhookUrl  string         `json:"webhook_url"`
 	LastError   sql.NullString `json:"last_error"`
 	NextRetryAt sql.NullTime   `json:"next_retry_at"`
 }
 
+// Records a failed attempt to one endpoint of a request; next_retry_at is NULL once it gives up
 func (q *Queries) RecordDeliveryFailure(ctx context.Context, arg *RecordDeliveryFailureParams) error {
-	_, err := q.db.Exec(ctx, recordDeliveryFailure, arg.RequestID, arg.LastError, arg.NextRetryAt)
+	_, err := q.db.Exec(ctx, recordDeliveryFailure,
+		arg.RequestID,
+		arg.WebhookUrl,
+		arg.LastError,
+		arg.NextRetryAt,
+	)
 	return err
 }
 
 const recordDeliverySuccess = `-- name: RecordDeliverySuccess :exec
 UPDATE webhook_deliveries
-SET consecutive_failures = 0,
+SET attempt_count = attempt_count + 1,
+    last_attempt_at = NOW(),
+    consecutive_failures = 0,
     last_error = NULL,
     next_retry_at = NULL,
     updated_at = NOW()
-WHERE request_id = $1
+WHERE request_id = $1 AND webhook_url = $2
 `
 
-func (q *Queries) RecordDeliverySuccess(ctx context.Context, requestID string) error {
-	_, err := q.db.Exec(ctx, recordDeliverySuccess, requestID)
+type RecordDeliverySuccessParams struct {
+	RequestID  string `json:"request_id"`
+	WebhookUrl string `json:"webhook_url"`
+}
+
+func (q *Queries) RecordDeliverySuccess(ctx context.Context, arg *RecordDeliverySuccessParams) error {
+	_, err := q.db.Exec(ctx, recordDeliverySuccess, arg.RequestID, arg.WebhookUrl)
 	return err
 }
 
 const updateDeliverySeq = `-- name: UpdateDeliverySeq :exec
 UPDATE webhook_deliveries
-SET seq = $2, last_event_type = $3, updated_at = NOW()
+SET seq = $2,
+    last_event_type = $3,
+    status = CASE WHEN status = 'pending' THEN 'delivering' ELSE status END,
+    updated_at = NOW()
 WHERE request_id = $1
 `
 
@@ -365,3 +811,28 @@ func (q *Queries) UpdateDeliveryStatus(ctx context.Context, arg *UpdateDeliveryS
 	_, err := q.db.Exec(ctx, updateDeliveryStatus, arg.RequestID, arg.Status)
 	return err
 }
+
+const upsertWebhookEvent = `-- name: UpsertWebhookEvent :exec
+INSERT INTO webhook_events (
+    request_id, seq, event_type, payload
+) VALUES ($1, $2, $3, $4)
+ON CONFLICT (request_id, seq) DO UPDATE
+SET event_type = EXCLUDED.event_type, payload = EXCLUDED.payload, created_at = NOW()
+`
+
+type UpsertWebhookEventParams struct {
+	RequestID string `json:"request_id"`
+	Seq       int64  `json:"seq"`
+	EventType string `json:"event_type"`
+	Payload   []byte `json:"payload"`
+}
+
+func (q *Queries) UpsertWebhookEvent(ctx context.Context, arg *UpsertWebhookEventParams) error {
+	_, err := q.db.Exec(ctx, upsertWebhookEvent,
+		arg.RequestID,
+		arg.Seq,
+		arg.EventType,
+		arg.Payload,
+	)
+	return err
+}
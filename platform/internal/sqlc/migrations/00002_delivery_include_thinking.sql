@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- Whether the consumer asked to receive model reasoning content for this request
+ALTER TABLE webhook_deliveries ADD COLUMN include_thinking BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS include_thinking;
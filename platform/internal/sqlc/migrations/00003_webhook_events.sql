@@ -0,0 +1,19 @@
+-- +goose Up
+
+-- Payloads delivered (or attempted) for each request, kept so they can be redelivered
+CREATE TABLE webhook_events (
+    request_id TEXT NOT NULL,
+    seq BIGINT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (request_id, seq)
+);
+
+CREATE INDEX idx_webhook_events_created ON webhook_events(created_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_webhook_events_created;
+DROP TABLE IF EXISTS webhook_events;
@@ -0,0 +1,35 @@
+-- +goose Up
+
+-- Durable queue of webhook payloads waiting to be delivered.
+-- Rows are written in the same transaction as the delivery seq update and drained by
+-- the delivery worker pool, so accepted events survive a platform restart.
+CREATE TABLE webhook_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    request_id TEXT NOT NULL,
+    seq BIGINT NOT NULL,
+    webhook_url TEXT NOT NULL,
+    webhook_secret TEXT, -- needed to sign deliveries after a restart; deleted rows take it with them
+    payload JSONB NOT NULL,
+
+    -- Delivery state
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, delivered, dead_letter
+    attempt_count INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+
+    -- Timestamps
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ,
+
+    UNIQUE(request_id, webhook_url, seq)
+);
+
+CREATE INDEX idx_webhook_outbox_pending ON webhook_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_outbox_dead_letter ON webhook_outbox(updated_at) WHERE status = 'dead_letter';
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_webhook_outbox_dead_letter;
+DROP INDEX IF EXISTS idx_webhook_outbox_pending;
+DROP TABLE IF EXISTS webhook_outbox;
@@ -0,0 +1,34 @@
+-- +goose Up
+
+-- Ordered batches of messages sent to one agent over a single connection.
+-- Each step is tracked as its own webhook delivery, linked back by batch_id/batch_step.
+CREATE TABLE request_batches (
+    batch_id TEXT PRIMARY KEY,
+    agent_id TEXT NOT NULL,
+    step_count INT NOT NULL,
+    continue_on_error BOOLEAN NOT NULL DEFAULT FALSE,
+
+    -- Execution state
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, in_progress, completed, failed
+    current_step INT NOT NULL DEFAULT 0,
+    failed_steps INT NOT NULL DEFAULT 0,
+
+    -- Timestamps
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+ALTER TABLE webhook_deliveries
+    ADD COLUMN batch_id TEXT REFERENCES request_batches(batch_id) ON DELETE CASCADE,
+    ADD COLUMN batch_step INT;
+
+CREATE INDEX idx_webhook_deliveries_batch ON webhook_deliveries(batch_id, batch_step) WHERE batch_id IS NOT NULL;
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_webhook_deliveries_batch;
+ALTER TABLE webhook_deliveries
+    DROP COLUMN IF EXISTS batch_step,
+    DROP COLUMN IF EXISTS batch_id;
+DROP TABLE IF EXISTS request_batches;
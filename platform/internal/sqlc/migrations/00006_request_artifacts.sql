@@ -0,0 +1,23 @@
+-- +goose Up
+
+-- Files produced by agents while handling a request. Small ones are also inlined in the
+-- request's final webhook; all of them can be downloaded by the user who owns the agent.
+CREATE TABLE request_artifacts (
+    request_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    agent_id TEXT NOT NULL,
+    media_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (request_id, name)
+);
+
+CREATE INDEX idx_request_artifacts_created ON request_artifacts(created_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_request_artifacts_created;
+DROP TABLE IF EXISTS request_artifacts;
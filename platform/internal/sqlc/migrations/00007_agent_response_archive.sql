@@ -0,0 +1,19 @@
+-- +goose Up
+
+-- Raw agent responses as received over the stream (serialized protobuf), kept when
+-- WEBHOOK_RAW_ARCHIVE is on so support can compare them with the converted payloads.
+CREATE TABLE agent_response_archive (
+    request_id TEXT NOT NULL,
+    seq BIGINT NOT NULL,
+    response BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (request_id, seq)
+);
+
+CREATE INDEX idx_agent_response_archive_created ON agent_response_archive(created_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_agent_response_archive_created;
+DROP TABLE IF EXISTS agent_response_archive;
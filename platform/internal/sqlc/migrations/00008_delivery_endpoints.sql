@@ -0,0 +1,15 @@
+-- +goose Up
+
+-- A request can fan out to several webhook endpoints, each tracked in its own row.
+-- endpoint_index orders a request's endpoints; endpoint 0 is reported as the request's status.
+ALTER TABLE webhook_deliveries ADD COLUMN endpoint_index INT NOT NULL DEFAULT 0;
+ALTER TABLE webhook_deliveries DROP CONSTRAINT webhook_deliveries_request_id_key;
+ALTER TABLE webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_request_id_webhook_url_key UNIQUE (request_id, webhook_url);
+
+-- +goose Down
+
+DELETE FROM webhook_deliveries WHERE endpoint_index > 0;
+ALTER TABLE webhook_deliveries DROP CONSTRAINT IF EXISTS webhook_deliveries_request_id_webhook_url_key;
+ALTER TABLE webhook_deliveries ADD CONSTRAINT webhook_deliveries_request_id_key UNIQUE (request_id);
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS endpoint_index;
@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- Custom headers and bearer tokens sent with webhook deliveries. The delivery record keeps
+-- only the header names and a hash of the token, for status lookups and redelivery checks.
+ALTER TABLE webhook_deliveries ADD COLUMN webhook_header_names TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE webhook_deliveries ADD COLUMN webhook_bearer_token_hash TEXT;
+
+ALTER TABLE webhook_outbox ADD COLUMN webhook_headers JSONB; -- needed to send deliveries after a restart, like webhook_secret
+ALTER TABLE webhook_outbox ADD COLUMN webhook_bearer_token TEXT;
+
+-- +goose Down
+
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS webhook_bearer_token;
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS webhook_headers;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS webhook_bearer_token_hash;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS webhook_header_names;
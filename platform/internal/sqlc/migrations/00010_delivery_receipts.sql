@@ -0,0 +1,22 @@
+-- +goose Up
+
+-- Seq ranges of a request's events that the consumer confirmed it durably processed.
+-- Ranges are stored as acknowledged and coalesced when read; redelivery skips them.
+CREATE TABLE delivery_receipts (
+    id BIGSERIAL PRIMARY KEY,
+    request_id TEXT NOT NULL,
+    from_seq BIGINT NOT NULL,
+    to_seq BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    CHECK (from_seq >= 1 AND to_seq >= from_seq)
+);
+
+CREATE INDEX idx_delivery_receipts_request ON delivery_receipts(request_id, from_seq);
+CREATE INDEX idx_delivery_receipts_created ON delivery_receipts(created_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_delivery_receipts_created;
+DROP INDEX IF EXISTS idx_delivery_receipts_request;
+DROP TABLE IF EXISTS delivery_receipts;
@@ -0,0 +1,18 @@
+-- +goose Up
+
+-- Reports of the end-to-end self test (create an agent, message it, receive its webhook,
+-- delete it). The report is stored whole; outcome is kept alongside for querying.
+CREATE TABLE selftest_reports (
+    id BIGSERIAL PRIMARY KEY,
+    trigger TEXT NOT NULL,
+    outcome TEXT NOT NULL,
+    report JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_selftest_reports_created ON selftest_reports(created_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_selftest_reports_created;
+DROP TABLE IF EXISTS selftest_reports;
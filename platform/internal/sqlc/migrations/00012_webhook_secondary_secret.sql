@@ -0,0 +1,9 @@
+-- +goose Up
+
+-- Second signing secret of an endpoint whose secret is being rotated; deliveries carry a
+-- signature for each secret while both are set
+ALTER TABLE webhook_outbox ADD COLUMN webhook_secondary_secret TEXT;
+
+-- +goose Down
+
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS webhook_secondary_secret;
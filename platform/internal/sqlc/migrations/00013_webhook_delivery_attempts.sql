@@ -0,0 +1,29 @@
+-- +goose Up
+
+-- One row per HTTP attempt to deliver a webhook event to one endpoint. status_code is NULL
+-- when no response was received; error is truncated and never includes response bodies
+-- beyond a short snippet.
+CREATE TABLE webhook_delivery_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    request_id TEXT NOT NULL,
+    seq BIGINT NOT NULL,
+    webhook_url TEXT NOT NULL,
+    attempt INT NOT NULL,
+    status_code INT,
+    duration_ms INT NOT NULL,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_delivery_attempts_request ON webhook_delivery_attempts(request_id, id);
+CREATE INDEX idx_webhook_delivery_attempts_created ON webhook_delivery_attempts(created_at);
+
+-- Listing deliveries newest first, optionally by status
+CREATE INDEX idx_webhook_deliveries_created ON webhook_deliveries(created_at DESC);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_webhook_deliveries_created;
+DROP INDEX IF EXISTS idx_webhook_delivery_attempts_created;
+DROP INDEX IF EXISTS idx_webhook_delivery_attempts_request;
+DROP TABLE IF EXISTS webhook_delivery_attempts;
@@ -0,0 +1,27 @@
+-- +goose Up
+
+-- Each agent's message history, as the payloads its responses were relayed as, so it can
+-- be read after the agent's pod is gone. Agents number their responses per session, so a
+-- response relayed again (e.g. after a stream was resumed) is stored once.
+CREATE TABLE agent_messages (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    agent_id TEXT NOT NULL,
+    session_id TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    seq BIGINT NOT NULL,
+    role TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    UNIQUE (agent_id, session_id, seq)
+);
+
+CREATE INDEX idx_agent_messages_agent_seq ON agent_messages(agent_id, seq);
+CREATE INDEX idx_agent_messages_created ON agent_messages(created_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_agent_messages_created;
+DROP INDEX IF EXISTS idx_agent_messages_agent_seq;
+DROP TABLE IF EXISTS agent_messages;
@@ -0,0 +1,21 @@
+-- +goose Up
+
+-- API keys callers authenticate with, each scoped to one user (or, for admin keys, allowed
+-- to act for any user). Only a SHA-256 hash of the key is stored; the key itself is shown
+-- once, when it is created. Revoked keys are kept for auditing.
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    key_hash TEXT NOT NULL UNIQUE,
+    admin BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys(user_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_api_keys_user;
+DROP TABLE IF EXISTS api_keys;
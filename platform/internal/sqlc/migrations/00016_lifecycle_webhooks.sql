@@ -0,0 +1,20 @@
+-- +goose Up
+
+-- Webhooks told about agent lifecycle events (created, ready, deleted, failed). A webhook
+-- without an agent_id receives the events of all of the user's agents.
+CREATE TABLE lifecycle_webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    agent_id TEXT,
+    url TEXT NOT NULL,
+    secret TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_lifecycle_webhooks_user ON lifecycle_webhooks(user_id, agent_id);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_lifecycle_webhooks_user;
+DROP TABLE IF EXISTS lifecycle_webhooks;
@@ -0,0 +1,21 @@
+-- +goose Up
+
+-- Responses to POSTs sent with an idempotency key, replayed when the same request is sent
+-- again before the key expires. key_hash identifies the caller, route and key; status_code
+-- is NULL while the first request is still being handled.
+CREATE TABLE idempotency_keys (
+    key_hash TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL, -- SHA256 of the request body
+    status_code INT,
+    content_type TEXT,
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_idempotency_keys_expires;
+DROP TABLE IF EXISTS idempotency_keys;
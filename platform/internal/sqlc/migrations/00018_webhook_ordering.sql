@@ -0,0 +1,9 @@
+-- +goose Up
+
+-- Whether the events of a request are delivered to an endpoint strictly in seq order. Unordered
+-- events are claimed as soon as they are due, so several can be in flight at once.
+ALTER TABLE webhook_outbox ADD COLUMN ordered BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS ordered;
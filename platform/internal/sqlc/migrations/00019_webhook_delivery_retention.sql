@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- Finds the delivery records due for deletion by retention (see DeleteDeliveriesBefore)
+CREATE INDEX idx_webhook_deliveries_status_updated ON webhook_deliveries(status, updated_at);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_webhook_deliveries_status_updated;
@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- The payload schema version an outbox event is delivered in; empty for the current one
+ALTER TABLE webhook_outbox ADD COLUMN schema_version TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS schema_version;
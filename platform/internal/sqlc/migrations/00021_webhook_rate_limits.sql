@@ -0,0 +1,11 @@
+-- +goose Up
+
+-- Per-request overrides of the rate deliveries to an endpoint are paced at; 0 keeps the
+-- configured WEBHOOK_RATE_LIMIT_RPS / WEBHOOK_RATE_BURST
+ALTER TABLE webhook_outbox ADD COLUMN rate_limit_rps DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE webhook_outbox ADD COLUMN rate_burst INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS rate_burst;
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS rate_limit_rps;
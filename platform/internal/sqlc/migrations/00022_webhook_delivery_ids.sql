@@ -0,0 +1,8 @@
+-- +goose Up
+
+-- The X-Forge-Delivery-Id sent with each attempt; empty for attempts made before it existed
+ALTER TABLE webhook_delivery_attempts ADD COLUMN delivery_id TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+
+ALTER TABLE webhook_delivery_attempts DROP COLUMN IF EXISTS delivery_id;
@@ -0,0 +1,22 @@
+-- +goose Up
+
+-- Tokens and cost an agent's model used for each request, summed over the request's
+-- assistant messages
+CREATE TABLE request_usage (
+    request_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    agent_id TEXT NOT NULL,
+    input_tokens BIGINT NOT NULL DEFAULT 0,
+    output_tokens BIGINT NOT NULL DEFAULT 0,
+    cache_read_tokens BIGINT NOT NULL DEFAULT 0,
+    cache_write_tokens BIGINT NOT NULL DEFAULT 0,
+    cost_usd DOUBLE PRECISION NOT NULL DEFAULT 0,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_request_usage_user_created ON request_usage(user_id, created_at);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS request_usage;
@@ -0,0 +1,15 @@
+-- +goose Up
+
+-- The most a user's agents may cost per period; soft budgets only warn once spent
+CREATE TABLE user_budgets (
+    user_id TEXT PRIMARY KEY,
+    limit_usd DOUBLE PRECISION NOT NULL,
+    period TEXT NOT NULL DEFAULT 'monthly',
+    soft BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS user_budgets;
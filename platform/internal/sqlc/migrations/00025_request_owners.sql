@@ -0,0 +1,10 @@
+-- +goose Up
+
+-- The user who sent each request or batch; NULL for records made before it was stored
+ALTER TABLE webhook_deliveries ADD COLUMN user_id TEXT;
+ALTER TABLE request_batches ADD COLUMN user_id TEXT;
+
+-- +goose Down
+
+ALTER TABLE request_batches DROP COLUMN IF EXISTS user_id;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,10 @@
+// Package migrations holds the database schema as goose migrations, embedded into the
+// binary so it can migrate the database itself (see db.Migrate)
+package migrations
+
+import "embed"
+
+// FS holds the migration files
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,14 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (
+    user_id, name, key_hash, admin
+) VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetAPIKeyByHash :one
+SELECT * FROM api_keys
+WHERE key_hash = $1;
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL;
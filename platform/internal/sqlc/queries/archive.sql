@@ -0,0 +1,14 @@
+-- name: ArchiveAgentResponse :exec
+INSERT INTO agent_response_archive (
+    request_id, seq, response
+) VALUES ($1, $2, $3)
+ON CONFLICT (request_id, seq) DO UPDATE
+SET response = EXCLUDED.response, created_at = NOW();
+
+-- name: GetArchivedAgentResponse :one
+SELECT * FROM agent_response_archive
+WHERE request_id = $1 AND seq = $2;
+
+-- name: DeleteArchivedAgentResponsesBefore :execrows
+DELETE FROM agent_response_archive
+WHERE created_at < $1;
@@ -0,0 +1,23 @@
+-- name: UpsertRequestArtifact :exec
+INSERT INTO request_artifacts (
+    request_id, name, user_id, agent_id, media_type, size_bytes, data
+) VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (request_id, name) DO UPDATE
+SET media_type = EXCLUDED.media_type,
+    size_bytes = EXCLUDED.size_bytes,
+    data = EXCLUDED.data,
+    created_at = NOW();
+
+-- name: ListRequestArtifacts :many
+SELECT request_id, name, user_id, agent_id, media_type, size_bytes, created_at
+FROM request_artifacts
+WHERE request_id = $1 AND user_id = $2
+ORDER BY name;
+
+-- name: GetRequestArtifact :one
+SELECT * FROM request_artifacts
+WHERE request_id = $1 AND name = $2 AND user_id = $3;
+
+-- name: DeleteRequestArtifactsBefore :execrows
+DELETE FROM request_artifacts
+WHERE created_at < $1;
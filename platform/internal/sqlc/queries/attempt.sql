@@ -0,0 +1,23 @@
+-- name: InsertWebhookDeliveryAttempts :copyfrom
+INSERT INTO webhook_delivery_attempts (
+    request_id, seq, webhook_url, attempt, status_code, duration_ms, error, created_at, delivery_id
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: ListWebhookDeliveryAttempts :many
+-- Attempts of a request in the order they were made, capped at max_attempts
+SELECT * FROM webhook_delivery_attempts
+WHERE request_id = $1
+ORDER BY id
+LIMIT sqlc.arg(max_attempts);
+
+-- name: GetWebhookDeliveryAttemptSummary :one
+-- Number of delivery attempts of a request, and the status code of the latest one
+-- (0 if it got no response or there were none)
+SELECT COUNT(*)::int AS attempt_count,
+       COALESCE((ARRAY_AGG(status_code ORDER BY id DESC))[1], 0)::int AS last_status
+FROM webhook_delivery_attempts
+WHERE request_id = $1;
+
+-- name: DeleteWebhookDeliveryAttemptsBefore :execrows
+DELETE FROM webhook_delivery_attempts
+WHERE created_at < $1;
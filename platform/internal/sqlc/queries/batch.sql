@@ -0,0 +1,27 @@
+-- name: CreateRequestBatch :one
+INSERT INTO request_batches (
+    batch_id, agent_id, step_count, continue_on_error, user_id
+) VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetRequestBatch :one
+SELECT * FROM request_batches WHERE batch_id = $1;
+
+-- name: UpdateRequestBatchProgress :exec
+UPDATE request_batches
+SET status = $2,
+    current_step = $3,
+    failed_steps = $4,
+    completed_at = CASE WHEN $2 IN ('completed', 'failed') THEN NOW() ELSE completed_at END,
+    updated_at = NOW()
+WHERE batch_id = $1;
+
+-- name: SetDeliveryBatchStep :exec
+UPDATE webhook_deliveries
+SET batch_id = sqlc.arg(batch_id)::text, batch_step = sqlc.arg(batch_step)::int, updated_at = NOW()
+WHERE request_id = $1;
+
+-- name: ListBatchDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE batch_id = $1 AND endpoint_index = 0
+ORDER BY batch_step;
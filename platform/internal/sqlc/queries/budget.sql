@@ -0,0 +1,17 @@
+-- name: UpsertUserBudget :one
+INSERT INTO user_budgets (user_id, limit_usd, period, soft)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id) DO UPDATE SET
+    limit_usd = EXCLUDED.limit_usd,
+    period = EXCLUDED.period,
+    soft = EXCLUDED.soft,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetUserBudget :one
+SELECT * FROM user_budgets
+WHERE user_id = $1;
+
+-- name: DeleteUserBudget :execrows
+DELETE FROM user_budgets
+WHERE user_id = $1;
@@ -0,0 +1,32 @@
+-- name: ReserveIdempotencyKey :execrows
+-- Claims the key for a request being handled, taking over a key that expired
+INSERT INTO idempotency_keys (
+    key_hash, request_hash, created_at, expires_at
+) VALUES ($1, $2, $3, $4)
+ON CONFLICT (key_hash) DO UPDATE
+SET request_hash = EXCLUDED.request_hash,
+    status_code = NULL,
+    content_type = NULL,
+    response_body = NULL,
+    created_at = EXCLUDED.created_at,
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at <= EXCLUDED.created_at;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE key_hash = $1;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status_code = $2,
+    content_type = $3,
+    response_body = $4
+WHERE key_hash = $1;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE key_hash = $1;
+
+-- name: DeleteIdempotencyKeysBefore :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < $1;
@@ -0,0 +1,32 @@
+-- name: CreateLifecycleWebhook :one
+INSERT INTO lifecycle_webhooks (
+    user_id, agent_id, url, secret
+) VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetLifecycleWebhook :one
+SELECT * FROM lifecycle_webhooks
+WHERE id = $1 AND user_id = $2;
+
+-- name: ListLifecycleWebhooks :many
+SELECT * FROM lifecycle_webhooks
+WHERE user_id = $1
+ORDER BY id;
+
+-- name: ListLifecycleWebhooksForAgent :many
+-- Webhooks registered for all of the user's agents, or for this one
+SELECT * FROM lifecycle_webhooks
+WHERE user_id = $1 AND (agent_id IS NULL OR agent_id = $2)
+ORDER BY id;
+
+-- name: UpdateLifecycleWebhook :one
+UPDATE lifecycle_webhooks
+SET url = $3,
+    secret = $4,
+    updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: DeleteLifecycleWebhook :execrows
+DELETE FROM lifecycle_webhooks
+WHERE id = $1 AND user_id = $2;
@@ -0,0 +1,34 @@
+-- name: InsertAgentMessage :execrows
+-- Stores a message unless the agent's session already has one with its seq
+INSERT INTO agent_messages (
+    user_id, agent_id, session_id, request_id, seq, role, payload
+) VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (agent_id, session_id, seq) DO NOTHING;
+
+-- name: ListAgentMessages :many
+-- An agent's messages from from_seq onwards, oldest first, capped at max_rows
+SELECT * FROM agent_messages
+WHERE user_id = $1 AND agent_id = $2 AND seq >= sqlc.arg(from_seq)
+ORDER BY seq, id
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetLatestAgentMessageSeq :one
+-- Highest seq stored for an agent, 0 if none
+SELECT COALESCE(MAX(seq), 0)::bigint AS latest_seq
+FROM agent_messages
+WHERE user_id = $1 AND agent_id = $2;
+
+-- name: DeleteAgentMessagesBefore :execrows
+DELETE FROM agent_messages
+WHERE created_at < $1;
+
+-- name: TrimAgentMessages :execrows
+-- Keeps each agent's newest max_per_agent messages
+DELETE FROM agent_messages
+WHERE id IN (
+    SELECT ranked.id FROM (
+        SELECT m.id, ROW_NUMBER() OVER (PARTITION BY m.agent_id ORDER BY m.id DESC) AS n
+        FROM agent_messages m
+    ) ranked
+    WHERE ranked.n > sqlc.arg(max_per_agent)::bigint
+);
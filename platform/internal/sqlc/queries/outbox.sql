@@ -0,0 +1,104 @@
+-- name: EnqueueOutboxEvent :exec
+INSERT INTO webhook_outbox (
+    request_id, seq, webhook_url, webhook_secret, payload, webhook_headers, webhook_bearer_token,
+    webhook_secondary_secret, ordered, schema_version, rate_limit_rps, rate_burst
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (request_id, webhook_url, seq) DO NOTHING;
+
+-- name: ClaimOutboxEvents :many
+-- Claims due events by pushing next_attempt_at out to lease_until, so an event whose
+-- worker dies is retried once the lease expires. Only the lowest pending seq of each
+-- request/URL is claimable, which keeps deliveries in order; unordered events are
+-- claimable as soon as they are due.
+UPDATE webhook_outbox
+SET attempt_count = attempt_count + 1,
+    next_attempt_at = sqlc.arg(lease_until)::timestamptz,
+    updated_at = NOW()
+WHERE id IN (
+    SELECT o.id FROM webhook_outbox o
+    WHERE o.status = 'pending'
+      AND o.next_attempt_at <= NOW()
+      AND (NOT o.ordered OR NOT EXISTS (
+          SELECT 1 FROM webhook_outbox earlier
+          WHERE earlier.request_id = o.request_id
+            AND earlier.webhook_url = o.webhook_url
+            AND earlier.status = 'pending'
+            AND earlier.seq < o.seq
+      ))
+    ORDER BY o.id
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkOutboxDelivered :exec
+UPDATE webhook_outbox
+SET status = 'delivered', delivered_at = NOW(), last_error = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkOutboxEventsDelivered :exec
+UPDATE webhook_outbox
+SET status = 'delivered', delivered_at = NOW(), last_error = NULL, updated_at = NOW()
+WHERE id = ANY(sqlc.arg(ids)::bigint[]);
+
+-- name: ListPendingOutboxEventsAfter :many
+-- The pending events of a request/URL after seq, in seq order
+SELECT * FROM webhook_outbox
+WHERE request_id = $1
+  AND webhook_url = $2
+  AND seq > $3
+  AND status = 'pending'
+ORDER BY seq
+LIMIT sqlc.arg(max_events);
+
+-- name: RescheduleOutboxEvent :exec
+UPDATE webhook_outbox
+SET next_attempt_at = $2, last_error = $3, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ReleaseOutboxEvent :exec
+-- Returns a claimed event without counting the attempt (e.g. circuit breaker open)
+UPDATE webhook_outbox
+SET next_attempt_at = $2, attempt_count = GREATEST(attempt_count - 1, 0), updated_at = NOW()
+WHERE id = $1;
+
+-- name: ReplaceOutboxPayload :exec
+-- Replaces the payload of a claimed event and queues it again with fresh attempts
+UPDATE webhook_outbox
+SET payload = $2, attempt_count = 0, next_attempt_at = NOW(), last_error = $3, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeadLetterOutboxEvent :exec
+UPDATE webhook_outbox
+SET status = 'dead_letter', last_error = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ListDeadLetters :many
+SELECT * FROM webhook_outbox
+WHERE status = 'dead_letter'
+ORDER BY updated_at DESC
+LIMIT $1;
+
+-- name: GetOutboxStats :one
+SELECT
+    COUNT(*) FILTER (WHERE status = 'pending')::int AS pending,
+    COALESCE(MIN(created_at) FILTER (WHERE status = 'pending'), NOW())::timestamptz AS oldest_pending_at,
+    COUNT(*) FILTER (WHERE status = 'dead_letter' AND updated_at >= sqlc.arg(dead_letters_since))::int AS recent_dead_letters
+FROM webhook_outbox;
+
+-- name: DeleteDeliveredOutboxEventsBefore :execrows
+DELETE FROM webhook_outbox
+WHERE status = 'delivered'
+  AND delivered_at < $1;
+
+-- name: ListOutboxEventsForSeq :many
+SELECT * FROM webhook_outbox
+WHERE request_id = $1 AND seq = $2
+ORDER BY id;
+
+-- name: CountPendingOutboxEventsBefore :one
+-- Events of a request, to any endpoint, still waiting to be delivered before seq
+SELECT COUNT(*)::int FROM webhook_outbox
+WHERE request_id = $1
+  AND seq < $2
+  AND status = 'pending';
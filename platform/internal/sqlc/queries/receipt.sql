@@ -0,0 +1,13 @@
+-- name: InsertDeliveryReceipt :exec
+INSERT INTO delivery_receipts (
+    request_id, from_seq, to_seq
+) VALUES ($1, $2, $3);
+
+-- name: ListDeliveryReceipts :many
+SELECT * FROM delivery_receipts
+WHERE request_id = $1
+ORDER BY from_seq, to_seq;
+
+-- name: DeleteDeliveryReceiptsBefore :execrows
+DELETE FROM delivery_receipts
+WHERE created_at < $1;
@@ -0,0 +1,13 @@
+-- name: InsertSelftestReport :exec
+INSERT INTO selftest_reports (
+    trigger, outcome, report
+) VALUES ($1, $2, $3);
+
+-- name: GetLatestSelftestReport :one
+SELECT * FROM selftest_reports
+ORDER BY created_at DESC, id DESC
+LIMIT 1;
+
+-- name: DeleteSelftestReportsBefore :execrows
+DELETE FROM selftest_reports
+WHERE created_at < $1;
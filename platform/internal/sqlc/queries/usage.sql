@@ -0,0 +1,36 @@
+-- name: UpsertRequestUsage :exec
+-- Stores a request's usage, replacing what was stored for it before
+INSERT INTO request_usage (
+    request_id, user_id, agent_id, input_tokens, output_tokens, cache_read_tokens,
+    cache_write_tokens, cost_usd, duration_ms, created_at
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (request_id) DO UPDATE SET
+    input_tokens = EXCLUDED.input_tokens,
+    output_tokens = EXCLUDED.output_tokens,
+    cache_read_tokens = EXCLUDED.cache_read_tokens,
+    cache_write_tokens = EXCLUDED.cache_write_tokens,
+    cost_usd = EXCLUDED.cost_usd,
+    duration_ms = EXCLUDED.duration_ms;
+
+-- name: SumRequestUsage :many
+-- A user's usage of requests made in [from_time, to_time), summed per agent and UTC day
+SELECT
+    agent_id,
+    date_trunc('day', created_at, 'UTC')::timestamptz AS day,
+    COUNT(*)::int AS requests,
+    SUM(input_tokens)::bigint AS input_tokens,
+    SUM(output_tokens)::bigint AS output_tokens,
+    SUM(cache_read_tokens)::bigint AS cache_read_tokens,
+    SUM(cache_write_tokens)::bigint AS cache_write_tokens,
+    SUM(cost_usd)::double precision AS cost_usd,
+    SUM(duration_ms)::bigint AS duration_ms
+FROM request_usage
+WHERE user_id = $1 AND created_at >= sqlc.arg(from_time) AND created_at < sqlc.arg(to_time)
+GROUP BY agent_id, day
+ORDER BY day, agent_id;
+
+-- name: SumUserCostSince :one
+-- Cost of a user's requests made since the given time
+SELECT COALESCE(SUM(cost_usd), 0)::double precision AS cost_usd
+FROM request_usage
+WHERE user_id = $1 AND created_at >= sqlc.arg(since);
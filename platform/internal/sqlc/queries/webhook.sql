@@ -1,11 +1,18 @@
 -- name: CreateWebhookDelivery :one
 INSERT INTO webhook_deliveries (
-    request_id, agent_id, webhook_url, webhook_secret_hash
-) VALUES ($1, $2, $3, $4)
+    request_id, agent_id, webhook_url, webhook_secret_hash, include_thinking, endpoint_index,
+    webhook_header_names, webhook_bearer_token_hash, user_id
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING *;
 
 -- name: GetWebhookDelivery :one
-SELECT * FROM webhook_deliveries WHERE request_id = $1;
+-- Returns the record of the request's first endpoint, which stands for the request
+SELECT * FROM webhook_deliveries WHERE request_id = $1 AND endpoint_index = 0;
+
+-- name: ListWebhookDeliveryEndpoints :many
+SELECT * FROM webhook_deliveries
+WHERE request_id = $1
+ORDER BY endpoint_index;
 
 -- name: GetWebhookDeliveryByID :one
 SELECT * FROM webhook_deliveries WHERE id = $1;
@@ -17,12 +24,15 @@ WHERE request_id = $1;
 
 -- name: UpdateDeliverySeq :exec
 UPDATE webhook_deliveries
-SET seq = $2, last_event_type = $3, updated_at = NOW()
+SET seq = $2,
+    last_event_type = $3,
+    status = CASE WHEN status = 'pending' THEN 'delivering' ELSE status END,
+    updated_at = NOW()
 WHERE request_id = $1;
 
 -- name: MarkDeliveryCompleted :exec
 UPDATE webhook_deliveries
-SET status = 'completed', completed_at = NOW(), updated_at = NOW(), consecutive_failures = 0
+SET status = 'completed', completed_at = NOW(), updated_at = NOW()
 WHERE request_id = $1;
 
 -- name: MarkDeliveryFailed :exec
@@ -38,20 +48,25 @@ SET attempt_count = attempt_count + 1,
 WHERE request_id = $1;
 
 -- name: RecordDeliveryFailure :exec
+-- Records a failed attempt to one endpoint of a request; next_retry_at is NULL once it gives up
 UPDATE webhook_deliveries
-SET last_error = $2,
+SET attempt_count = attempt_count + 1,
+    last_attempt_at = NOW(),
+    last_error = $3,
     consecutive_failures = consecutive_failures + 1,
-    next_retry_at = $3,
+    next_retry_at = $4,
     updated_at = NOW()
-WHERE request_id = $1;
+WHERE request_id = $1 AND webhook_url = $2;
 
 -- name: RecordDeliverySuccess :exec
 UPDATE webhook_deliveries
-SET consecutive_failures = 0,
+SET attempt_count = attempt_count + 1,
+    last_attempt_at = NOW(),
+    consecutive_failures = 0,
     last_error = NULL,
     next_retry_at = NULL,
     updated_at = NOW()
-WHERE request_id = $1;
+WHERE request_id = $1 AND webhook_url = $2;
 
 -- name: OpenCircuitForURL :exec
 UPDATE webhook_deliveries
@@ -72,6 +87,13 @@ WHERE status = 'pending'
 ORDER BY next_retry_at
 LIMIT $1;
 
+-- name: ListDeliveriesByAgent :many
+-- Records made before owners were stored have no user_id and are listed for every user
+SELECT * FROM webhook_deliveries
+WHERE agent_id = $1 AND (user_id = sqlc.arg(user_id)::text OR user_id IS NULL) AND endpoint_index = 0
+ORDER BY created_at DESC
+LIMIT $2;
+
 -- name: GetActiveDeliveriesForAgent :many
 SELECT * FROM webhook_deliveries
 WHERE agent_id = $1
@@ -91,3 +113,62 @@ SELECT COALESCE(MAX(consecutive_failures), 0)::int AS failures
 FROM webhook_deliveries
 WHERE webhook_url = $1
   AND status IN ('pending', 'delivering');
+
+-- name: UpsertWebhookEvent :exec
+INSERT INTO webhook_events (
+    request_id, seq, event_type, payload
+) VALUES ($1, $2, $3, $4)
+ON CONFLICT (request_id, seq) DO UPDATE
+SET event_type = EXCLUDED.event_type, payload = EXCLUDED.payload, created_at = NOW();
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE request_id = $1
+  AND seq >= sqlc.arg(from_seq)
+ORDER BY seq;
+
+-- name: DeleteWebhookEventsBefore :execrows
+DELETE FROM webhook_events
+WHERE created_at < $1;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE request_id = $1 AND seq = $2;
+
+-- name: ListWebhookEventsPrecedingSeq :many
+SELECT * FROM webhook_events
+WHERE request_id = $1
+  AND seq < sqlc.arg(seq)
+ORDER BY seq DESC
+LIMIT sqlc.arg(max_events);
+
+-- name: ListWebhookEventsFollowingSeq :many
+SELECT * FROM webhook_events
+WHERE request_id = $1
+  AND seq > sqlc.arg(seq)
+ORDER BY seq
+LIMIT sqlc.arg(max_events);
+
+-- name: ListWebhookDeliveries :many
+-- Endpoint delivery records newest first; each filter applies only when set
+SELECT * FROM webhook_deliveries
+WHERE (sqlc.narg(agent_id)::text IS NULL OR agent_id = sqlc.narg(agent_id))
+  AND (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status))
+  AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at > sqlc.narg(created_after))
+  AND (sqlc.narg(webhook_url_prefix)::text IS NULL OR starts_with(webhook_url, sqlc.narg(webhook_url_prefix)))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_rows) OFFSET sqlc.arg(skip_rows);
+
+-- name: DeleteDeliveriesBefore :execrows
+-- Deletes up to batch_size delivery records with the given status last updated before the
+-- cutoff, so a large purge takes many short locks instead of one long one
+DELETE FROM webhook_deliveries
+WHERE id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = sqlc.arg(status) AND updated_at < sqlc.arg(before)
+    LIMIT sqlc.arg(batch_size)
+);
+
+-- name: CountDeliveriesBefore :one
+SELECT COUNT(*) FROM webhook_deliveries
+WHERE status = $1 AND updated_at < $2;
@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// ArchiveResponse keeps the raw agent response for a request's seq, so the converted
+// payload can later be compared with what the agent actually sent. It does nothing
+// unless WebhookRawArchive is enabled.
+func (s *DeliveryService) ArchiveResponse(ctx context.Context, requestID string, resp *agentv1.AgentResponse) error {
+	if !s.cfg.WebhookRawArchive {
+		return nil
+	}
+
+	raw, err := proto.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("marshaling agent response: %w", err)
+	}
+	if err := s.queries.ArchiveAgentResponse(ctx, &sqlc.ArchiveAgentResponseParams{
+		RequestID: requestID,
+		Seq:       int64(resp.GetSeq()),
+		Response:  raw,
+	}); err != nil {
+		return fmt.Errorf("archiving agent response: %w", err)
+	}
+	return nil
+}
+
+// archivedResponseJSON returns the archived agent response for a request's seq as JSON,
+// or nil if it was not archived
+func (s *DeliveryService) archivedResponseJSON(ctx context.Context, requestID string, seq int64) ([]byte, error) {
+	archived, err := s.queries.GetArchivedAgentResponse(ctx, &sqlc.GetArchivedAgentResponseParams{
+		RequestID: requestID,
+		Seq:       seq,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting archived agent response: %w", err)
+	}
+
+	var resp agentv1.AgentResponse
+	if err := proto.Unmarshal(archived.Response, &resp); err != nil {
+		return nil, fmt.Errorf("decoding archived agent response: %w", err)
+	}
+	return protojson.Marshal(&resp)
+}
+
+// pruneArchive deletes archived agent responses stored before the given time
+func (s *DeliveryService) pruneArchive(ctx context.Context, before time.Time) (int64, error) {
+	n, err := s.queries.DeleteArchivedAgentResponsesBefore(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("pruning archived agent responses: %w", err)
+	}
+	return n, nil
+}
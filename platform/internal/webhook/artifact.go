@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// defaultArtifactMediaType is used for artifacts the agent sent without a media type
+const defaultArtifactMediaType = "application/octet-stream"
+
+// maxArtifactNameLength caps artifact names, which are used as download file names
+const maxArtifactNameLength = 255
+
+var (
+	// ErrArtifactNotFound is returned when a request has no artifact by that name for the user
+	ErrArtifactNotFound = errors.New("artifact not found")
+	// ErrArtifactTooLarge is returned when an artifact exceeds ArtifactMaxBytes
+	ErrArtifactTooLarge = errors.New("artifact exceeds the maximum size")
+	// ErrInvalidArtifactName is returned for names that are empty, too long, or contain path separators
+	ErrInvalidArtifactName = errors.New("invalid artifact name")
+)
+
+// ArtifactURL returns the API path an artifact is downloaded from
+func ArtifactURL(requestID, name string) string {
+	return "/api/v1/requests/" + url.PathEscape(requestID) + "/artifacts/" + url.PathEscape(name)
+}
+
+// SaveArtifact stores an artifact the agent produced for a request and returns it as listed
+// on the request's final payload: with its content inline if it is at most
+// ArtifactInlineMaxBytes, otherwise by URL only. Storing an artifact again under the same
+// name replaces it.
+func (s *DeliveryService) SaveArtifact(ctx context.Context, userID, agentID, requestID string, artifact *agentv1.ArtifactPayload) (Artifact, error) {
+	name := artifact.GetName()
+	if err := validateArtifactName(name); err != nil {
+		return Artifact{}, err
+	}
+	size := int64(len(artifact.GetData()))
+	if s.cfg.ArtifactMaxBytes > 0 && size > s.cfg.ArtifactMaxBytes {
+		return Artifact{}, fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrArtifactTooLarge, name, size, s.cfg.ArtifactMaxBytes)
+	}
+	mediaType := artifact.GetMediaType()
+	if mediaType == "" {
+		mediaType = defaultArtifactMediaType
+	}
+
+	err := s.queries.UpsertRequestArtifact(ctx, &sqlc.UpsertRequestArtifactParams{
+		RequestID: requestID,
+		Name:      name,
+		UserID:    userID,
+		AgentID:   agentID,
+		MediaType: mediaType,
+		SizeBytes: size,
+		Data:      artifact.GetData(),
+	})
+	if err != nil {
+		return Artifact{}, fmt.Errorf("storing artifact %s: %w", name, err)
+	}
+
+	ref := Artifact{
+		Name:      name,
+		MediaType: mediaType,
+		Size:      size,
+		URL:       ArtifactURL(requestID, name),
+	}
+	if size <= s.cfg.ArtifactInlineMaxBytes {
+		ref.Data = artifact.GetData()
+	}
+	return ref, nil
+}
+
+// ListArtifacts returns the artifacts stored for a request owned by userID, by name,
+// without their content
+func (s *DeliveryService) ListArtifacts(ctx context.Context, requestID, userID string) ([]Artifact, error) {
+	rows, err := s.queries.ListRequestArtifacts(ctx, &sqlc.ListRequestArtifactsParams{
+		RequestID: requestID,
+		UserID:    userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing artifacts: %w", err)
+	}
+
+	artifacts := make([]Artifact, 0, len(rows))
+	for _, row := range rows {
+		artifacts = append(artifacts, Artifact{
+			Name:      row.Name,
+			MediaType: row.MediaType,
+			Size:      row.SizeBytes,
+			URL:       ArtifactURL(row.RequestID, row.Name),
+		})
+	}
+	return artifacts, nil
+}
+
+// GetArtifact returns a stored artifact with its content. It returns ErrArtifactNotFound
+// if the request has no artifact by that name or it belongs to another user.
+func (s *DeliveryService) GetArtifact(ctx context.Context, requestID, name, userID string) (*sqlc.RequestArtifact, error) {
+	artifact, err := s.queries.GetRequestArtifact(ctx, &sqlc.GetRequestArtifactParams{
+		RequestID: requestID,
+		Name:      name,
+		UserID:    userID,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrArtifactNotFound
+		}
+		return nil, fmt.Errorf("getting artifact: %w", err)
+	}
+	return artifact, nil
+}
+
+// pruneArtifacts deletes artifacts stored before the given time
+func (s *DeliveryService) pruneArtifacts(ctx context.Context, before time.Time) (int64, error) {
+	n, err := s.queries.DeleteRequestArtifactsBefore(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("pruning artifacts: %w", err)
+	}
+	return n, nil
+}
+
+// validateArtifactName checks that a name is usable as a download file name
+func validateArtifactName(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("%w: %q", ErrInvalidArtifactName, name)
+	case len(name) > maxArtifactNameLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidArtifactName, maxArtifactNameLength)
+	case strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("%w: %q contains a path separator or NUL", ErrInvalidArtifactName, name)
+	}
+	return nil
+}
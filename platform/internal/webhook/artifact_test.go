@@ -0,0 +1,183 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeArtifactQuerier stores artifacts in memory. Unimplemented querier
+// methods panic via the nil embedded interface.
+type fakeArtifactQuerier struct {
+	sqlc.Querier
+	mu        sync.Mutex
+	artifacts map[string]*sqlc.RequestArtifact // by request_id + "/" + name
+}
+
+func newFakeArtifactQuerier() *fakeArtifactQuerier {
+	return &fakeArtifactQuerier{artifacts: make(map[string]*sqlc.RequestArtifact)}
+}
+
+func (f *fakeArtifactQuerier) UpsertRequestArtifact(_ context.Context, arg *sqlc.UpsertRequestArtifactParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.artifacts[arg.RequestID+"/"+arg.Name] = &sqlc.RequestArtifact{
+		RequestID: arg.RequestID,
+		Name:      arg.Name,
+		UserID:    arg.UserID,
+		AgentID:   arg.AgentID,
+		MediaType: arg.MediaType,
+		SizeBytes: arg.SizeBytes,
+		Data:      arg.Data,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (f *fakeArtifactQuerier) ListRequestArtifacts(_ context.Context, arg *sqlc.ListRequestArtifactsParams) ([]*sqlc.ListRequestArtifactsRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows := []*sqlc.ListRequestArtifactsRow{}
+	for _, a := range f.artifacts {
+		if a.RequestID == arg.RequestID && a.UserID == arg.UserID {
+			rows = append(rows, &sqlc.ListRequestArtifactsRow{
+				RequestID: a.RequestID,
+				Name:      a.Name,
+				UserID:    a.UserID,
+				AgentID:   a.AgentID,
+				MediaType: a.MediaType,
+				SizeBytes: a.SizeBytes,
+				CreatedAt: a.CreatedAt,
+			})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows, nil
+}
+
+func (f *fakeArtifactQuerier) GetRequestArtifact(_ context.Context, arg *sqlc.GetRequestArtifactParams) (*sqlc.RequestArtifact, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.artifacts[arg.RequestID+"/"+arg.Name]
+	if !ok || a.UserID != arg.UserID {
+		return nil, pgx.ErrNoRows
+	}
+	return a, nil
+}
+
+// artifactLimits stores artifacts of up to inlineMax bytes inline and refuses ones over maxSize
+func artifactLimits(inlineMax, maxSize int64) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.ArtifactInlineMaxBytes = inlineMax
+		cfg.ArtifactMaxBytes = maxSize
+	}
+}
+
+func TestSaveArtifact_InlinesSmallAndLinksLarge(t *testing.T) {
+	querier := newFakeArtifactQuerier()
+	s := newTestService(querier, artifactLimits(8, 64))
+	ctx := context.Background()
+
+	small, err := s.SaveArtifact(ctx, "user1", "agent1", "req-1", &agentv1.ArtifactPayload{
+		Name: "notes.txt", MediaType: "text/plain", Data: []byte("12345678"),
+	})
+	if err != nil {
+		t.Fatalf("SaveArtifact: %v", err)
+	}
+	if string(small.Data) != "12345678" || small.Size != 8 || small.URL != "/api/v1/requests/req-1/artifacts/notes.txt" {
+		t.Errorf("expected the artifact at the inline limit to be inlined, got %+v", small)
+	}
+
+	large, err := s.SaveArtifact(ctx, "user1", "agent1", "req-1", &agentv1.ArtifactPayload{
+		Name: "report.md", MediaType: "text/markdown", Data: []byte("123456789"),
+	})
+	if err != nil {
+		t.Fatalf("SaveArtifact: %v", err)
+	}
+	if large.Data != nil || large.Size != 9 || large.URL != "/api/v1/requests/req-1/artifacts/report.md" {
+		t.Errorf("expected the artifact over the inline limit to be linked only, got %+v", large)
+	}
+
+	// Both are stored regardless of how they are listed on the payload
+	for _, name := range []string{"notes.txt", "report.md"} {
+		if _, err := s.GetArtifact(ctx, "req-1", name, "user1"); err != nil {
+			t.Errorf("expected %s to be stored: %v", name, err)
+		}
+	}
+}
+
+func TestSaveArtifact_RejectsOversizedAndInvalid(t *testing.T) {
+	querier := newFakeArtifactQuerier()
+	s := newTestService(querier, artifactLimits(8, 16))
+	ctx := context.Background()
+
+	_, err := s.SaveArtifact(ctx, "user1", "agent1", "req-1", &agentv1.ArtifactPayload{
+		Name: "big.bin", Data: bytes.Repeat([]byte{1}, 17),
+	})
+	if !errors.Is(err, ErrArtifactTooLarge) {
+		t.Errorf("expected ErrArtifactTooLarge, got %v", err)
+	}
+
+	for _, name := range []string{"", "..", "dir/file.txt", `dir\file.txt`, strings.Repeat("a", maxArtifactNameLength+1)} {
+		_, err := s.SaveArtifact(ctx, "user1", "agent1", "req-1", &agentv1.ArtifactPayload{Name: name, Data: []byte("x")})
+		if !errors.Is(err, ErrInvalidArtifactName) {
+			t.Errorf("name %q: expected ErrInvalidArtifactName, got %v", name, err)
+		}
+	}
+
+	if len(querier.artifacts) != 0 {
+		t.Errorf("expected nothing to be stored, got %d artifacts", len(querier.artifacts))
+	}
+}
+
+func TestSaveArtifact_DefaultsMediaType(t *testing.T) {
+	s := newTestService(newFakeArtifactQuerier(), artifactLimits(8, 0))
+
+	ref, err := s.SaveArtifact(context.Background(), "user1", "agent1", "req-1", &agentv1.ArtifactPayload{Name: "out", Data: []byte("x")})
+	if err != nil {
+		t.Fatalf("SaveArtifact: %v", err)
+	}
+	if ref.MediaType != defaultArtifactMediaType {
+		t.Errorf("expected media type %s, got %s", defaultArtifactMediaType, ref.MediaType)
+	}
+}
+
+func TestArtifacts_ScopedToOwner(t *testing.T) {
+	s := newTestService(newFakeArtifactQuerier(), artifactLimits(8, 64))
+	ctx := context.Background()
+	if _, err := s.SaveArtifact(ctx, "user1", "agent1", "req-1", &agentv1.ArtifactPayload{Name: "a.txt", Data: []byte("x")}); err != nil {
+		t.Fatalf("SaveArtifact: %v", err)
+	}
+
+	if _, err := s.GetArtifact(ctx, "req-1", "a.txt", "user2"); !errors.Is(err, ErrArtifactNotFound) {
+		t.Errorf("expected ErrArtifactNotFound for another user, got %v", err)
+	}
+	if artifacts, err := s.ListArtifacts(ctx, "req-1", "user2"); err != nil || len(artifacts) != 0 {
+		t.Errorf("expected no artifacts listed for another user, got %v (err %v)", artifacts, err)
+	}
+
+	artifacts, err := s.ListArtifacts(ctx, "req-1", "user1")
+	if err != nil {
+		t.Fatalf("ListArtifacts: %v", err)
+	}
+	if len(artifacts) != 1 || artifacts[0].Name != "a.txt" || artifacts[0].Data != nil {
+		t.Errorf("expected a.txt listed without content, got %+v", artifacts)
+	}
+}
+
+func TestArtifactURL_EscapesSegments(t *testing.T) {
+	if got := ArtifactURL("req 1", "my report.md"); got != "/api/v1/requests/req%201/artifacts/my%20report.md" {
+		t.Errorf("unexpected URL %s", got)
+	}
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// asyncDeliveryTimeout bounds a single async delivery, including its retries
+const asyncDeliveryTimeout = 2 * time.Minute
+
+var (
+	// ErrAsyncQueueFull is returned when the async delivery queue has no room for a payload
+	ErrAsyncQueueFull = errors.New("webhook async delivery queue is full")
+	// ErrAsyncQueueClosed is returned when a payload is queued after shutdown began
+	ErrAsyncQueueClosed = errors.New("webhook async delivery queue is closed")
+)
+
+// AsyncStats counts payloads handled by the async delivery pool since startup
+type AsyncStats struct {
+	Queued    uint64 `json:"queued"`
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+type asyncJob struct {
+	id      uint64
+	cfg     Config
+	payload Payload
+}
+
+// asyncPool is a bounded queue of payloads drained by a fixed set of workers
+type asyncPool struct {
+	// mu guards closed so a send never races with closing jobs
+	mu     sync.RWMutex
+	closed bool
+	jobs   chan asyncJob
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+	busy   atomic.Int32
+
+	queued    atomic.Uint64
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newAsyncPool(queueSize int) *asyncPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &asyncPool{
+		jobs:   make(chan asyncJob, max(queueSize, 0)),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// DeliverAsync queues a webhook payload for delivery by the async worker pool.
+// It never blocks: if the queue is full the payload is dropped and ErrAsyncQueueFull is returned.
+func (s *DeliveryService) DeliverAsync(webhookCfg Config, payload Payload) error {
+	p := s.asyncPool
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.dropped.Add(1)
+		return ErrAsyncQueueClosed
+	}
+
+	job := asyncJob{id: s.async.start(), cfg: webhookCfg, payload: payload}
+	select {
+	case p.jobs <- job:
+		p.queued.Add(1)
+		return nil
+	default:
+		s.async.finish(job.id, false)
+		p.dropped.Add(1)
+		s.logger.Warn("webhook async queue full, dropping payload",
+			zap.String("request_id", payload.RequestID),
+			zap.String("event_type", string(payload.EventType)),
+			zap.Int("queue_size", cap(p.jobs)),
+		)
+		return ErrAsyncQueueFull
+	}
+}
+
+// AsyncStats returns the async delivery counters
+func (s *DeliveryService) AsyncStats() AsyncStats {
+	p := s.asyncPool
+	return AsyncStats{
+		Queued:    p.queued.Load(),
+		Delivered: p.delivered.Load(),
+		Failed:    p.failed.Load(),
+		Dropped:   p.dropped.Load(),
+	}
+}
+
+// startAsyncWorkers starts n workers draining the async queue until stopAsyncWorkers is called
+func (s *DeliveryService) startAsyncWorkers(n int) {
+	p := s.asyncPool
+	for range max(n, 1) {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				s.runAsyncJob(p.ctx, job)
+			}
+		}()
+	}
+}
+
+// runAsyncJob delivers one queued payload and records the outcome
+func (s *DeliveryService) runAsyncJob(ctx context.Context, job asyncJob) {
+	p := s.asyncPool
+	p.busy.Add(1)
+	defer p.busy.Add(-1)
+
+	ctx, cancel := context.WithTimeout(ctx, asyncDeliveryTimeout)
+	defer cancel()
+
+	err := s.Deliver(ctx, job.cfg, job.payload)
+	s.async.finish(job.id, err != nil)
+	if err != nil {
+		p.failed.Add(1)
+		s.logger.Error("async webhook delivery failed",
+			zap.Error(err),
+			zap.String("request_id", job.payload.RequestID),
+			zap.String("webhook_url", job.cfg.URL),
+		)
+		return
+	}
+	p.delivered.Add(1)
+}
+
+// stopAsyncWorkers stops accepting payloads and waits for queued and in-flight deliveries
+// to finish. If ctx ends first, remaining deliveries are cancelled and ctx's error is returned.
+func (s *DeliveryService) stopAsyncWorkers(ctx context.Context) error {
+	p := s.asyncPool
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
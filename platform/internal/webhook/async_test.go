@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// startBlockingWebhookServer holds every request until release is closed or the client gives up
+func startBlockingWebhookServer(t *testing.T) (server *httptest.Server, received *atomic.Int32, release chan struct{}) {
+	t.Helper()
+	received = &atomic.Int32{}
+	release = make(chan struct{})
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Read the body so the server notices when the client hangs up
+		_, _ = io.Copy(io.Discard, r.Body)
+		received.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, received, release
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// asyncWorkers runs async deliveries on workers workers behind a queue of queueSize
+func asyncWorkers(workers, queueSize int) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WebhookAsyncWorkers = workers
+		cfg.WebhookAsyncQueueSize = queueSize
+	}
+}
+
+func TestDeliverAsync_QueueFullIsReported(t *testing.T) {
+	server, received, release := startBlockingWebhookServer(t)
+	s := newTestService(nil, asyncWorkers(1, 1))
+	s.startAsyncWorkers(1)
+	cfg := Config{URL: server.URL}
+	payloads := testPayloads("req-1", 1, 2, 3)
+
+	// The only worker picks up the first payload and blocks on the server
+	if err := s.DeliverAsync(cfg, payloads[0]); err != nil {
+		t.Fatalf("first DeliverAsync: %v", err)
+	}
+	waitFor(t, "the worker to pick up the first payload", func() bool { return received.Load() == 1 })
+
+	// The second fills the queue, the third has nowhere to go
+	if err := s.DeliverAsync(cfg, payloads[1]); err != nil {
+		t.Fatalf("second DeliverAsync: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.DeliverAsync(cfg, payloads[2]) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrAsyncQueueFull) {
+			t.Fatalf("expected ErrAsyncQueueFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DeliverAsync blocked on a full queue")
+	}
+
+	close(release)
+	if err := s.stopAsyncWorkers(context.Background()); err != nil {
+		t.Fatalf("stopAsyncWorkers: %v", err)
+	}
+
+	stats := s.AsyncStats()
+	if stats != (AsyncStats{Queued: 2, Delivered: 2, Dropped: 1}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestStopAsyncWorkers_WaitsForInFlightDeliveries(t *testing.T) {
+	server, received, release := startBlockingWebhookServer(t)
+	s := newTestService(nil, asyncWorkers(2, 10))
+	s.startAsyncWorkers(2)
+
+	for _, payload := range testPayloads("req-1", 1, 2) {
+		if err := s.DeliverAsync(Config{URL: server.URL}, payload); err != nil {
+			t.Fatalf("DeliverAsync: %v", err)
+		}
+	}
+	waitFor(t, "both deliveries to start", func() bool { return received.Load() == 2 })
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.stopAsyncWorkers(context.Background()) }()
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("stop returned before in-flight deliveries finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("stopAsyncWorkers: %v", err)
+	}
+	if stats := s.AsyncStats(); stats.Delivered != 2 {
+		t.Errorf("expected 2 delivered, got %+v", stats)
+	}
+
+	// Nothing is accepted once shutdown has begun
+	if err := s.DeliverAsync(Config{URL: server.URL}, testPayloads("req-1", 3)[0]); !errors.Is(err, ErrAsyncQueueClosed) {
+		t.Errorf("expected ErrAsyncQueueClosed after stop, got %v", err)
+	}
+}
+
+func TestStopAsyncWorkers_CancelsAtDeadline(t *testing.T) {
+	server, received, _ := startBlockingWebhookServer(t)
+	s := newTestService(nil, asyncWorkers(1, 10))
+	s.startAsyncWorkers(1)
+
+	if err := s.DeliverAsync(Config{URL: server.URL}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("DeliverAsync: %v", err)
+	}
+	waitFor(t, "the delivery to start", func() bool { return received.Load() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := s.stopAsyncWorkers(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stop took %s, expected it to give up near the deadline", elapsed)
+	}
+	if stats := s.AsyncStats(); stats.Failed != 1 || stats.Delivered != 0 {
+		t.Errorf("expected the cancelled delivery to count as failed, got %+v", stats)
+	}
+}
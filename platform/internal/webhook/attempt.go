@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// MaxAttemptError caps the length of the error stored for a delivery attempt, which
+// bounds how much of an endpoint's response body is kept
+const MaxAttemptError = 512
+
+// MaxListedAttempts caps the delivery attempts returned for one request
+const MaxListedAttempts = 500
+
+// Delivery attempts are queued and stored in batches off the delivery path
+const (
+	attemptQueueSize     = 4096
+	attemptBatchSize     = 256
+	attemptFlushInterval = time.Second
+	attemptFlushTimeout  = 5 * time.Second
+)
+
+// DeliveryFilter selects delivery records; zero fields match every record
+type DeliveryFilter struct {
+	AgentID          string
+	Status           string
+	CreatedAfter     time.Time
+	WebhookURLPrefix string
+}
+
+// ListDeliveries returns the endpoint delivery records matching filter, newest first,
+// skipping the first offset
+func (s *DeliveryService) ListDeliveries(ctx context.Context, filter DeliveryFilter, limit, offset int32) ([]*sqlc.WebhookDelivery, error) {
+	deliveries, err := s.queries.ListWebhookDeliveries(ctx, &sqlc.ListWebhookDeliveriesParams{
+		AgentID:          sql.NullString{String: filter.AgentID, Valid: filter.AgentID != ""},
+		Status:           sql.NullString{String: filter.Status, Valid: filter.Status != ""},
+		CreatedAfter:     sql.NullTime{Time: filter.CreatedAfter, Valid: !filter.CreatedAfter.IsZero()},
+		WebhookUrlPrefix: sql.NullString{String: filter.WebhookURLPrefix, Valid: filter.WebhookURLPrefix != ""},
+		MaxRows:          limit,
+		SkipRows:         offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// ListAttempts returns the first MaxListedAttempts delivery attempts of a request, to any
+// of its endpoints, in the order they were made
+func (s *DeliveryService) ListAttempts(ctx context.Context, requestID string) ([]*sqlc.WebhookDeliveryAttempt, error) {
+	attempts, err := s.queries.ListWebhookDeliveryAttempts(ctx, &sqlc.ListWebhookDeliveryAttemptsParams{
+		RequestID:   requestID,
+		MaxAttempts: MaxListedAttempts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing webhook delivery attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// recordAttempt queues one delivery attempt to be stored by runAttemptRecorder, so
+// deliveries never wait on the database. Attempts are dropped, with a warning, when the
+// queue is full; recording them never fails the delivery.
+func (s *DeliveryService) recordAttempt(webhookCfg Config, payload Payload, attempt int, result DeliveryResult, start time.Time, took time.Duration) {
+	s.metrics.WebhookAttempted(result.StatusCode)
+
+	arg := &sqlc.InsertWebhookDeliveryAttemptsParams{
+		RequestID:  payload.RequestID,
+		Seq:        int64(payload.Seq),
+		WebhookUrl: webhookCfg.URL,
+		Attempt:    int32(attempt),
+		DurationMs: int32(took.Milliseconds()),
+		CreatedAt:  start,
+		DeliveryID: result.DeliveryID,
+	}
+	if result.StatusCode != 0 {
+		arg.StatusCode = pgtype.Int4{Int32: int32(result.StatusCode), Valid: true}
+	}
+	if result.Error != nil {
+		arg.Error = sql.NullString{String: s.RedactError(webhookCfg.URL, result.Error.Error()), Valid: true}
+	}
+	select {
+	case s.attempts <- arg:
+	default:
+		s.logger.Warn("webhook delivery attempt queue full, dropping attempt record",
+			zap.String("request_id", payload.RequestID),
+			zap.Uint64("seq", payload.Seq),
+		)
+	}
+}
+
+// runAttemptRecorder stores queued delivery attempts in batches of up to attemptBatchSize,
+// at least every attemptFlushInterval, until ctx is cancelled. Attempts still queued then
+// are stored before it returns.
+func (s *DeliveryService) runAttemptRecorder(ctx context.Context) {
+	ticker := time.NewTicker(attemptFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*sqlc.InsertWebhookDeliveryAttemptsParams, 0, attemptBatchSize)
+	for {
+		select {
+		case arg := <-s.attempts:
+			batch = append(batch, arg)
+			if len(batch) < attemptBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), attemptFlushTimeout)
+			defer cancel()
+			s.insertAttempts(flushCtx, batch)
+			s.flushAttempts(flushCtx)
+			return
+		}
+		s.insertAttempts(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// flushAttempts stores every queued delivery attempt
+func (s *DeliveryService) flushAttempts(ctx context.Context) {
+	batch := make([]*sqlc.InsertWebhookDeliveryAttemptsParams, 0, attemptBatchSize)
+	for {
+		select {
+		case arg := <-s.attempts:
+			batch = append(batch, arg)
+			if len(batch) == attemptBatchSize {
+				s.insertAttempts(ctx, batch)
+				batch = batch[:0]
+			}
+		default:
+			s.insertAttempts(ctx, batch)
+			return
+		}
+	}
+}
+
+// insertAttempts stores a batch of delivery attempts, logging failures
+func (s *DeliveryService) insertAttempts(ctx context.Context, batch []*sqlc.InsertWebhookDeliveryAttemptsParams) {
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := s.queries.InsertWebhookDeliveryAttempts(ctx, batch); err != nil {
+		s.logger.Warn("failed to record webhook delivery attempts",
+			zap.Error(err),
+			zap.Int("count", len(batch)),
+		)
+	}
+}
+
+// AttemptSummary returns how many delivery attempts were made for a request and the status
+// code of the latest one, 0 if it got no response
+func (s *DeliveryService) AttemptSummary(ctx context.Context, requestID string) (count, lastStatus int32, err error) {
+	summary, err := s.queries.GetWebhookDeliveryAttemptSummary(ctx, requestID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("summarizing webhook delivery attempts: %w", err)
+	}
+	return summary.AttemptCount, summary.LastStatus, nil
+}
+
+// RedactError returns a delivery error message as it may be shown to operators: the
+// webhook URL and the bypass token, which HTTP client errors quote, are redacted, and it is
+// truncated to MaxAttemptError bytes
+func (s *DeliveryService) RedactError(webhookURL, msg string) string {
+	if token := s.cfg.VercelBypassToken; token != "" {
+		msg = strings.ReplaceAll(msg, token, "[redacted]")
+	}
+	msg = strings.ReplaceAll(msg, webhookURL, RedactURL(webhookURL))
+	if len(msg) > MaxAttemptError {
+		msg = strings.ToValidUTF8(msg[:MaxAttemptError], "")
+	}
+	return msg
+}
+
+// pruneAttempts deletes delivery attempts made before the given time
+func (s *DeliveryService) pruneAttempts(ctx context.Context, before time.Time) (int64, error) {
+	n, err := s.queries.DeleteWebhookDeliveryAttemptsBefore(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("pruning webhook delivery attempts: %w", err)
+	}
+	return n, nil
+}
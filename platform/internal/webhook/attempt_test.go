@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+func TestDeliver_RecordsEachAttempt(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(strings.Repeat("x", 4096)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	querier := newFakeEventQuerier()
+	s := newTestService(querier, func(cfg *config.Config) {
+		cfg.WebhookMaxRetries = 3
+		cfg.WebhookRetryMaxDelay = 10 * time.Millisecond
+	})
+
+	if err := s.deliver(context.Background(), Config{URL: server.URL + "/hook"}, testPayloads("req-1", 7)[0]); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	s.flushAttempts(context.Background())
+
+	attempts, err := s.ListAttempts(context.Background(), "req-1")
+	if err != nil {
+		t.Fatalf("ListAttempts: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 recorded attempts, got %d", len(attempts))
+	}
+	failed, succeeded := attempts[0], attempts[1]
+	if failed.Attempt != 1 || failed.Seq != 7 || failed.StatusCode.Int32 != http.StatusServiceUnavailable {
+		t.Errorf("unexpected first attempt %+v", failed)
+	}
+	if !failed.Error.Valid || len(failed.Error.String) > MaxAttemptError {
+		t.Errorf("expected the failed attempt's error truncated to %d bytes, got %d", MaxAttemptError, len(failed.Error.String))
+	}
+	if succeeded.Attempt != 2 || succeeded.StatusCode.Int32 != http.StatusOK || succeeded.Error.Valid {
+		t.Errorf("unexpected second attempt %+v", succeeded)
+	}
+}
+
+func TestDeliver_RecordedAttemptErrorIsRedacted(t *testing.T) {
+	querier := newFakeEventQuerier()
+	s := newTestService(querier, func(cfg *config.Config) {
+		cfg.WebhookTimeout = time.Second
+		cfg.VercelBypassToken = "bypass-secret"
+	})
+
+	// Nothing listens on the port, so the client error quotes the request URL
+	url := "http://127.0.0.1:1/hooks/token-in-path"
+	_ = s.deliver(context.Background(), Config{URL: url}, testPayloads("req-1", 1)[0])
+	s.flushAttempts(context.Background())
+
+	attempts, _ := s.ListAttempts(context.Background(), "req-1")
+	if len(attempts) != 1 {
+		t.Fatalf("expected 1 recorded attempt, got %d", len(attempts))
+	}
+	got := attempts[0]
+	if got.StatusCode.Valid {
+		t.Errorf("expected no status code without a response, got %d", got.StatusCode.Int32)
+	}
+	if strings.Contains(got.Error.String, "token-in-path") || strings.Contains(got.Error.String, "bypass-secret") {
+		t.Errorf("expected the URL and bypass token redacted, got %q", got.Error.String)
+	}
+}
+
+func TestAttemptRecorder_StoresInBatchesAndFlushesOnStop(t *testing.T) {
+	server, _ := startWebhookServer(t, http.StatusOK)
+	querier := newFakeEventQuerier()
+	s := newTestService(querier)
+
+	// Recorded attempts wait in the queue; delivering does not touch the attempts table
+	for _, payload := range testPayloads("req-1", 1, 2, 3) {
+		if err := s.deliver(context.Background(), Config{URL: server.URL}, payload); err != nil {
+			t.Fatalf("deliver: %v", err)
+		}
+	}
+	if attempts, _ := s.ListAttempts(context.Background(), "req-1"); len(attempts) != 0 {
+		t.Fatalf("expected no attempts stored before the recorder runs, got %d", len(attempts))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runAttemptRecorder(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		querier.mu.Lock()
+		stored, batches := len(querier.attempts), querier.attemptBatches
+		querier.mu.Unlock()
+		if stored == 3 {
+			if batches != 1 {
+				t.Errorf("expected the queued attempts stored in one batch, got %d", batches)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 stored attempts, got %d", stored)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Attempts recorded right before stopping are still stored
+	if err := s.deliver(context.Background(), Config{URL: server.URL}, testPayloads("req-2", 1)[0]); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	cancel()
+	<-done
+
+	count, lastStatus, err := s.AttemptSummary(context.Background(), "req-2")
+	if err != nil {
+		t.Fatalf("AttemptSummary: %v", err)
+	}
+	if count != 1 || lastStatus != http.StatusOK {
+		t.Errorf("expected 1 attempt with status 200, got %d with %d", count, lastStatus)
+	}
+}
+
+func TestRecordAttempt_DropsWhenQueueFull(t *testing.T) {
+	s := newTestService(newFakeEventQuerier())
+	s.attempts = make(chan *sqlc.InsertWebhookDeliveryAttemptsParams, 1)
+
+	result := DeliveryResult{StatusCode: http.StatusOK}
+	s.recordAttempt(Config{URL: "https://example.com"}, testPayloads("req-1", 1)[0], 1, result, time.Now(), time.Millisecond)
+	s.recordAttempt(Config{URL: "https://example.com"}, testPayloads("req-1", 2)[0], 1, result, time.Now(), time.Millisecond)
+
+	if got := len(s.attempts); got != 1 {
+		t.Errorf("expected the second attempt dropped, got %d queued", got)
+	}
+}
+
+func TestRedactError_TruncatesOnRuneBoundary(t *testing.T) {
+	s := newTestService(newFakeEventQuerier())
+	msg := s.RedactError("https://example.com", strings.Repeat("é", MaxAttemptError))
+	if len(msg) > MaxAttemptError || !strings.HasPrefix(msg, "é") || strings.ContainsRune(msg, '�') {
+		t.Errorf("expected a valid UTF-8 error of at most %d bytes, got %d bytes", MaxAttemptError, len(msg))
+	}
+}
+
+func TestDeliver_CountsAttemptsByStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	reg := prometheus.NewRegistry()
+	s := newTestService(newFakeEventQuerier(), func(cfg *config.Config) {
+		cfg.WebhookTimeout = time.Second
+		cfg.WebhookMaxRetries = 2
+		cfg.WebhookRetryMaxDelay = time.Millisecond
+	})
+	s.metrics = metrics.New(reg)
+
+	_ = s.deliver(context.Background(), Config{URL: server.URL + "/hook"}, testPayloads("req-1", 1)[0])
+
+	want := `
+# HELP forge_webhook_delivery_attempts_total Webhook delivery attempts, by response status code or "error" if there was no response.
+# TYPE forge_webhook_delivery_attempts_total counter
+forge_webhook_delivery_attempts_total{status="500"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "forge_webhook_delivery_attempts_total"); err != nil {
+		t.Error(err)
+	}
+}
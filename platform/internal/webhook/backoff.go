@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// backoff computes the delay before each webhook retry: exponential growth from base by
+// multiplier, capped at maxDelay, with a jitter fraction of it randomized so retries of
+// many requests failing together spread out instead of arriving in waves.
+type backoff struct {
+	base       time.Duration
+	maxDelay   time.Duration
+	multiplier float64
+	// jitter is the fraction of each delay that is randomized: 0 is none, 1 is full jitter
+	jitter float64
+	// rand returns a float in [0, 1); replaced in tests
+	rand func() float64
+}
+
+// newBackoff builds the retry policy from config
+func newBackoff(cfg *config.Config) *backoff {
+	return &backoff{
+		base:       max(cfg.WebhookRetryBase, 0),
+		maxDelay:   max(cfg.WebhookRetryMaxDelay, 0),
+		multiplier: max(cfg.WebhookRetryMultiplier, 1),
+		jitter:     min(max(cfg.WebhookRetryJitter, 0), 1),
+		rand:       rand.Float64,
+	}
+}
+
+// delay returns how long to wait before retry number retry (1 for the first retry).
+// A positive retryAfter from the endpoint is used instead, capped at the maximum delay.
+func (b *backoff) delay(retry int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return b.capped(float64(retryAfter))
+	}
+
+	exp := b.capped(float64(b.base) * math.Pow(b.multiplier, float64(max(retry, 1)-1)))
+	if b.jitter == 0 {
+		return exp
+	}
+	// Full jitter over the randomized fraction: [exp*(1-jitter), exp)
+	fixed := float64(exp) * (1 - b.jitter)
+	return time.Duration(fixed + b.rand()*float64(exp)*b.jitter)
+}
+
+// capped converts d to a duration no longer than maxDelay (if set)
+func (b *backoff) capped(d float64) time.Duration {
+	if b.maxDelay > 0 && d > float64(b.maxDelay) {
+		return b.maxDelay
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+// It returns 0 if the header is missing, invalid, or in the past.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// isRetryableStatus reports whether a failed delivery with this status code is worth
+// retrying. Client errors are permanent, except 408 and 429 which ask to try again later.
+func isRetryableStatus(statusCode int) bool {
+	if statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return statusCode < 400 || statusCode >= 500
+}
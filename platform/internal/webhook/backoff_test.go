@@ -0,0 +1,198 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+func TestBackoffDelay_ExponentialCappedSchedule(t *testing.T) {
+	b := newBackoff(&config.Config{
+		WebhookRetryBase:       time.Second,
+		WebhookRetryMaxDelay:   10 * time.Second,
+		WebhookRetryMultiplier: 2,
+	})
+
+	want := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, w := range want {
+		if got := b.delay(i+1, 0); got != w*time.Second {
+			t.Errorf("retry %d: expected %s, got %s", i+1, w*time.Second, got)
+		}
+	}
+}
+
+func TestBackoffDelay_FullJitterUsesInjectedRand(t *testing.T) {
+	b := newBackoff(&config.Config{
+		WebhookRetryBase:       time.Second,
+		WebhookRetryMaxDelay:   time.Minute,
+		WebhookRetryMultiplier: 3,
+		WebhookRetryJitter:     1,
+	})
+	rolls := []float64{0, 0.5, 0.25, 0.999}
+	next := 0
+	b.rand = func() float64 {
+		r := rolls[next]
+		next++
+		return r
+	}
+
+	// Each delay is uniform in [0, base*3^(n-1))
+	want := []time.Duration{0, 1500 * time.Millisecond, 2250 * time.Millisecond, 26973 * time.Millisecond}
+	for i, w := range want {
+		if got := b.delay(i+1, 0); got != w {
+			t.Errorf("retry %d: expected %s, got %s", i+1, w, got)
+		}
+	}
+}
+
+func TestBackoffDelay_PartialJitterKeepsFixedShare(t *testing.T) {
+	b := newBackoff(&config.Config{
+		WebhookRetryBase:       4 * time.Second,
+		WebhookRetryMaxDelay:   time.Minute,
+		WebhookRetryMultiplier: 2,
+		WebhookRetryJitter:     0.25,
+	})
+	b.rand = func() float64 { return 0.5 }
+
+	// 8s with a quarter randomized: 6s fixed + 0.5 * 2s
+	if got := b.delay(2, 0); got != 7*time.Second {
+		t.Errorf("expected 7s, got %s", got)
+	}
+}
+
+func TestBackoffDelay_RetryAfterOverridesAndIsCapped(t *testing.T) {
+	b := newBackoff(&config.Config{
+		WebhookRetryBase:       time.Second,
+		WebhookRetryMaxDelay:   30 * time.Second,
+		WebhookRetryMultiplier: 2,
+		WebhookRetryJitter:     1,
+	})
+	b.rand = func() float64 {
+		t.Fatal("rand should not be used when Retry-After is given")
+		return 0
+	}
+
+	if got := b.delay(1, 12*time.Second); got != 12*time.Second {
+		t.Errorf("expected Retry-After of 12s to be honored, got %s", got)
+	}
+	if got := b.delay(1, time.Hour); got != 30*time.Second {
+		t.Errorf("expected Retry-After to be capped at 30s, got %s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"-3", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q): expected %s, got %s", tt.header, tt.want, got)
+		}
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	for status, want := range map[int]bool{
+		0:   true, // transport error
+		400: false,
+		404: false,
+		408: true,
+		429: true,
+		500: true,
+		503: true,
+	} {
+		if got := isRetryableStatus(status); got != want {
+			t.Errorf("status %d: expected retryable=%v, got %v", status, want, got)
+		}
+	}
+}
+
+func TestDeliverOnce_PopulatesRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	s := newTestService(nil, asyncWorkers(1, 1))
+
+	result := s.deliverOnce(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1)[0], 1)
+	if result.Success || result.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 failure, got %+v", result)
+	}
+	if result.RetryAfter != 7*time.Second {
+		t.Errorf("expected RetryAfter 7s, got %s", result.RetryAfter)
+	}
+}
+
+func TestDeliver_RetriesRateLimitedDelivery(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	// The hour-long Retry-After is capped at the max delay
+	s := newTestService(newFakeEventQuerier(), func(cfg *config.Config) {
+		cfg.WebhookMaxRetries = 3
+		cfg.WebhookRetryMaxDelay = 10 * time.Millisecond
+	})
+
+	start := time.Now()
+	if err := s.deliver(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("expected a retry after 429, got %d attempts", attempts.Load())
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("retry took %s, expected Retry-After to be capped", elapsed)
+	}
+}
+
+func TestProcessOutboxEvent_SchedulesRetryFromRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "20")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if err := s.Enqueue(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	claimAndProcess(t, s, querier)
+
+	row := querier.row(t, "req-1", 1)
+	if row.Status != OutboxStatusPending {
+		t.Fatalf("expected a pending retry, got %+v", row)
+	}
+	if want := now.Add(20 * time.Second); !row.NextAttemptAt.Equal(want) {
+		t.Errorf("expected next attempt at %s, got %s", want, row.NextAttemptAt)
+	}
+}
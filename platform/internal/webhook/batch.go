@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// Batch statuses stored in request_batches.status
+const (
+	BatchStatusPending    = "pending"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusCompleted  = "completed"
+	BatchStatusFailed     = "failed"
+)
+
+// ErrBatchNotFound is returned when no batch record exists for a batch ID
+var ErrBatchNotFound = errors.New("request batch not found")
+
+// CreateBatch records a new batch of stepCount messages a user sent to an agent
+func (s *DeliveryService) CreateBatch(ctx context.Context, batchID, userID, agentID string, stepCount int, continueOnError bool) error {
+	_, err := s.queries.CreateRequestBatch(ctx, &sqlc.CreateRequestBatchParams{
+		BatchID:         batchID,
+		AgentID:         agentID,
+		StepCount:       int32(stepCount),
+		ContinueOnError: continueOnError,
+		UserID:          sql.NullString{String: userID, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("creating request batch: %w", err)
+	}
+	return nil
+}
+
+// AttachToBatch links a request's delivery record to a step of a batch
+func (s *DeliveryService) AttachToBatch(ctx context.Context, requestID, batchID string, step int) error {
+	return s.queries.SetDeliveryBatchStep(ctx, &sqlc.SetDeliveryBatchStepParams{
+		RequestID: requestID,
+		BatchID:   batchID,
+		BatchStep: int32(step),
+	})
+}
+
+// UpdateBatchProgress records a batch's status, the step it is on, and how many steps failed
+func (s *DeliveryService) UpdateBatchProgress(ctx context.Context, batchID, status string, currentStep, failedSteps int) error {
+	return s.queries.UpdateRequestBatchProgress(ctx, &sqlc.UpdateRequestBatchProgressParams{
+		BatchID:     batchID,
+		Status:      status,
+		CurrentStep: int32(currentStep),
+		FailedSteps: int32(failedSteps),
+	})
+}
+
+// GetBatch returns a batch record and the delivery records of the steps started so far,
+// ordered by step. It returns ErrBatchNotFound if the batch was never recorded.
+func (s *DeliveryService) GetBatch(ctx context.Context, batchID string) (*sqlc.RequestBatch, []*sqlc.WebhookDelivery, error) {
+	batch, err := s.queries.GetRequestBatch(ctx, batchID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrBatchNotFound
+		}
+		return nil, nil, fmt.Errorf("getting request batch: %w", err)
+	}
+
+	deliveries, err := s.queries.ListBatchDeliveries(ctx, sql.NullString{String: batchID, Valid: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing batch deliveries: %w", err)
+	}
+	return batch, deliveries, nil
+}
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// Periods a budget's spend is counted over, starting at midnight UTC
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+)
+
+// ErrBudgetNotFound is returned for users without a budget
+var ErrBudgetNotFound = errors.New("budget not found")
+
+// ErrInvalidBudget is returned for budgets with a negative limit or an unknown period
+var ErrInvalidBudget = errors.New("invalid budget")
+
+// Budget is the most a user's agents may cost per period. Once the cost of the requests
+// made in the current period reaches the limit, new messages are refused, or only warned
+// about if the budget is soft.
+type Budget struct {
+	UserID    string    `json:"user_id"`
+	LimitUSD  float64   `json:"limit_usd"`
+	Period    string    `json:"period"`
+	Soft      bool      `json:"soft"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PeriodBounds returns the start and end of the budget period now falls in
+func (b *Budget) PeriodBounds(now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	if b.Period == BudgetPeriodDaily {
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// ValidateBudget checks a budget's limit and period. Errors wrap ErrInvalidBudget.
+func ValidateBudget(b Budget) error {
+	if b.LimitUSD < 0 {
+		return fmt.Errorf("%w: limit_usd must not be negative", ErrInvalidBudget)
+	}
+	if b.Period != BudgetPeriodDaily && b.Period != BudgetPeriodMonthly {
+		return fmt.Errorf("%w: period must be one of %s, %s", ErrInvalidBudget, BudgetPeriodDaily, BudgetPeriodMonthly)
+	}
+	return nil
+}
+
+// budgetFromRow converts a stored budget
+func budgetFromRow(row *sqlc.UserBudget) *Budget {
+	return &Budget{
+		UserID:    row.UserID,
+		LimitUSD:  row.LimitUsd,
+		Period:    row.Period,
+		Soft:      row.Soft,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
+
+// SetBudget stores a user's budget, replacing the one set before
+func (s *DeliveryService) SetBudget(ctx context.Context, b Budget) (*Budget, error) {
+	if err := ValidateBudget(b); err != nil {
+		return nil, err
+	}
+	row, err := s.queries.UpsertUserBudget(ctx, &sqlc.UpsertUserBudgetParams{
+		UserID:   b.UserID,
+		LimitUsd: b.LimitUSD,
+		Period:   b.Period,
+		Soft:     b.Soft,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storing budget: %w", err)
+	}
+	return budgetFromRow(row), nil
+}
+
+// GetBudget returns a user's budget, or ErrBudgetNotFound if they have none
+func (s *DeliveryService) GetBudget(ctx context.Context, userID string) (*Budget, error) {
+	row, err := s.queries.GetUserBudget(ctx, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBudgetNotFound
+		}
+		return nil, fmt.Errorf("getting budget: %w", err)
+	}
+	return budgetFromRow(row), nil
+}
+
+// DeleteBudget removes a user's budget, or returns ErrBudgetNotFound if they have none
+func (s *DeliveryService) DeleteBudget(ctx context.Context, userID string) error {
+	n, err := s.queries.DeleteUserBudget(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("deleting budget: %w", err)
+	}
+	if n == 0 {
+		return ErrBudgetNotFound
+	}
+	return nil
+}
+
+// SumCostSince returns the cost of a user's requests made since the given time
+func (s *DeliveryService) SumCostSince(ctx context.Context, userID string, since time.Time) (float64, error) {
+	cost, err := s.queries.SumUserCostSince(ctx, &sqlc.SumUserCostSinceParams{
+		UserID: userID,
+		Since:  since,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("summing user cost: %w", err)
+	}
+	return cost, nil
+}
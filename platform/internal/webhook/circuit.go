@@ -0,0 +1,217 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CircuitState is the state of the circuit breaker for one webhook URL
+type CircuitState string
+
+const (
+	// CircuitClosed lets every delivery through
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen skips deliveries until the open period ends
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single probe delivery through to test the endpoint
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// circuitProbeWait is how long deliveries wait while another caller's probe is in flight
+const circuitProbeWait = time.Second
+
+// circuitState is the breaker for one webhook URL, guarded by DeliveryService.circuitMu
+type circuitState struct {
+	state      CircuitState
+	failures   int
+	openUntil  time.Time
+	lastFailed time.Time
+
+	// opens counts consecutive trips without a successful delivery; each one doubles the open period
+	opens int
+
+	// probeStarted is set while a half-open probe is in flight
+	probeStarted time.Time
+}
+
+// CircuitInfo describes the circuit breaker for one webhook URL
+type CircuitInfo struct {
+	// ID identifies the circuit without exposing its URL (see CircuitID)
+	ID          string
+	URL         string
+	State       CircuitState
+	Failures    int
+	OpenUntil   time.Time // zero unless open
+	LastFailure time.Time // zero if the URL has not failed
+}
+
+// CircuitID returns the identifier of the circuit for a webhook URL
+func CircuitID(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// openCircuitIDs returns the IDs of the open circuits
+func (s *DeliveryService) openCircuitIDs() []string {
+	var ids []string
+	for _, c := range s.Circuits() {
+		if c.State == CircuitOpen {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids
+}
+
+// allowDelivery reports whether a delivery to url may be attempted now. When it may not,
+// retryAt is when to try again. Once an open circuit's period ends, exactly one caller is
+// let through as a probe; its outcome (recordSuccess/recordFailure) closes or reopens it.
+func (s *DeliveryService) allowDelivery(url string) (retryAt time.Time, ok bool) {
+	s.circuitMu.Lock()
+	defer s.circuitMu.Unlock()
+
+	state, exists := s.circuitStates[url]
+	if !exists || state.state == CircuitClosed {
+		return time.Time{}, true
+	}
+
+	now := s.now()
+	if state.state == CircuitOpen {
+		if now.Before(state.openUntil) {
+			return state.openUntil, false
+		}
+		state.state = CircuitHalfOpen
+		state.probeStarted = time.Time{}
+	}
+
+	// Half-open: a probe that never reported back (e.g. cancelled on shutdown) is abandoned
+	if !state.probeStarted.IsZero() && now.Sub(state.probeStarted) < s.probeTimeout() {
+		return now.Add(circuitProbeWait), false
+	}
+	state.probeStarted = now
+	s.logger.Info("circuit breaker half-open, probing",
+		zap.String("webhook_url", url),
+	)
+	return time.Time{}, true
+}
+
+// probeTimeout is how long a half-open probe may take before another is allowed
+func (s *DeliveryService) probeTimeout() time.Duration {
+	return max(2*s.cfg.WebhookTimeout, 30*time.Second)
+}
+
+// recordFailure records a delivery failure for circuit breaker logic
+func (s *DeliveryService) recordFailure(url string, _ error) {
+	s.circuitMu.Lock()
+	defer s.circuitMu.Unlock()
+
+	state, ok := s.circuitStates[url]
+	if !ok {
+		state = &circuitState{state: CircuitClosed}
+		s.circuitStates[url] = state
+	}
+
+	now := s.now()
+	state.failures++
+	state.lastFailed = now
+
+	switch state.state {
+	case CircuitHalfOpen:
+		// The probe failed
+		s.tripCircuit(url, state, now)
+	case CircuitClosed:
+		if state.failures >= s.cfg.WebhookCircuitThreshold {
+			s.tripCircuit(url, state, now)
+		}
+	}
+}
+
+// tripCircuit opens a circuit for an exponentially growing period, capped at
+// WebhookCircuitMaxTimeout
+func (s *DeliveryService) tripCircuit(url string, state *circuitState, now time.Time) {
+	state.opens++
+	maxPeriod := s.cfg.WebhookCircuitMaxTimeout
+	period := s.cfg.WebhookCircuitTimeout
+	for i := 1; i < state.opens && period < math.MaxInt64/2 && (maxPeriod <= 0 || period < maxPeriod); i++ {
+		period *= 2
+	}
+	if maxPeriod > 0 {
+		period = min(period, maxPeriod)
+	}
+
+	state.state = CircuitOpen
+	state.openUntil = now.Add(period)
+	state.probeStarted = time.Time{}
+	s.logger.Warn("circuit breaker opened",
+		zap.String("webhook_url", url),
+		zap.Int("failures", state.failures),
+		zap.Int("opens", state.opens),
+		zap.Time("open_until", state.openUntil),
+	)
+}
+
+// recordSuccess records a successful delivery and closes the circuit
+func (s *DeliveryService) recordSuccess(url string) {
+	s.circuitMu.Lock()
+	defer s.circuitMu.Unlock()
+
+	if state, ok := s.circuitStates[url]; ok {
+		if state.failures > 0 || state.state != CircuitClosed {
+			s.logger.Info("circuit breaker reset after success",
+				zap.String("webhook_url", url),
+			)
+		}
+		*state = circuitState{state: CircuitClosed, lastFailed: state.lastFailed}
+	}
+}
+
+// Circuits returns the circuit breaker state of every webhook URL that has failed, by URL
+func (s *DeliveryService) Circuits() []CircuitInfo {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+
+	now := s.now()
+	circuits := make([]CircuitInfo, 0, len(s.circuitStates))
+	for url, state := range s.circuitStates {
+		info := CircuitInfo{
+			ID:          CircuitID(url),
+			URL:         url,
+			State:       state.state,
+			Failures:    state.failures,
+			LastFailure: state.lastFailed,
+		}
+		if state.state == CircuitOpen {
+			if now.Before(state.openUntil) {
+				info.OpenUntil = state.openUntil
+			} else {
+				// The next delivery attempt will probe
+				info.State = CircuitHalfOpen
+			}
+		}
+		circuits = append(circuits, info)
+	}
+	sort.Slice(circuits, func(i, j int) bool { return circuits[i].URL < circuits[j].URL })
+	return circuits
+}
+
+// ResetCircuit closes the circuit with the given ID, clears its failure history, and
+// returns its new state. It returns false if no circuit has that ID.
+func (s *DeliveryService) ResetCircuit(id string) (CircuitInfo, bool) {
+	s.circuitMu.Lock()
+	defer s.circuitMu.Unlock()
+
+	for url, state := range s.circuitStates {
+		if CircuitID(url) == id {
+			*state = circuitState{state: CircuitClosed}
+			s.logger.Info("circuit breaker reset manually",
+				zap.String("webhook_url", url),
+			)
+			return CircuitInfo{ID: id, URL: url, State: CircuitClosed}, true
+		}
+	}
+	return CircuitInfo{}, false
+}
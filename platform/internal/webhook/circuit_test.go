@@ -0,0 +1,232 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// circuitStart is when circuit breaker tests' clocks start
+var circuitStart = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// circuitConfig opens an endpoint's circuit after threshold failures, for timeout at first
+// and up to maxTimeout
+func circuitConfig(threshold int, timeout, maxTimeout time.Duration) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WebhookCircuitThreshold = threshold
+		cfg.WebhookCircuitTimeout = timeout
+		cfg.WebhookCircuitMaxTimeout = maxTimeout
+	}
+}
+
+func circuitFor(t *testing.T, s *DeliveryService, url string) CircuitInfo {
+	t.Helper()
+	for _, c := range s.Circuits() {
+		if c.URL == url {
+			return c
+		}
+	}
+	t.Fatalf("no circuit for %s", url)
+	return CircuitInfo{}
+}
+
+func TestCircuit_OpensAfterThreshold(t *testing.T) {
+	s := newTestService(nil, circuitConfig(3, time.Minute, time.Hour))
+	clock := withTestClock(s, circuitStart)
+	url := "https://hooks.example.com/a"
+
+	for range 2 {
+		s.recordFailure(url, nil)
+	}
+	if _, ok := s.allowDelivery(url); !ok {
+		t.Fatal("expected the circuit to stay closed below the threshold")
+	}
+
+	s.recordFailure(url, nil)
+	retryAt, ok := s.allowDelivery(url)
+	if ok {
+		t.Fatal("expected the circuit to open at the threshold")
+	}
+	if want := clock.Now().Add(time.Minute); !retryAt.Equal(want) {
+		t.Errorf("expected retry at %s, got %s", want, retryAt)
+	}
+
+	c := circuitFor(t, s, url)
+	if c.State != CircuitOpen || c.Failures != 3 || !c.OpenUntil.Equal(retryAt) || c.ID != CircuitID(url) {
+		t.Errorf("unexpected circuit %+v", c)
+	}
+}
+
+func TestCircuit_HalfOpenAllowsSingleProbe(t *testing.T) {
+	s := newTestService(nil, circuitConfig(1, time.Minute, time.Hour))
+	clock := withTestClock(s, circuitStart)
+	url := "https://hooks.example.com/a"
+	s.recordFailure(url, nil)
+	clock.Advance(time.Minute)
+
+	if state := circuitFor(t, s, url).State; state != CircuitHalfOpen {
+		t.Errorf("expected half_open once the open period ends, got %s", state)
+	}
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := s.allowDelivery(url); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 1 {
+		t.Errorf("expected exactly 1 probe to be allowed, got %d", allowed.Load())
+	}
+
+	// Waiting callers are told to come back shortly
+	retryAt, ok := s.allowDelivery(url)
+	if ok || !retryAt.Equal(clock.Now().Add(circuitProbeWait)) {
+		t.Errorf("expected to wait for the probe, got ok=%v retryAt=%s", ok, retryAt)
+	}
+}
+
+func TestCircuit_ProbeSuccessCloses(t *testing.T) {
+	s := newTestService(nil, circuitConfig(1, time.Minute, time.Hour))
+	clock := withTestClock(s, circuitStart)
+	url := "https://hooks.example.com/a"
+	s.recordFailure(url, nil)
+	clock.Advance(time.Minute)
+
+	if _, ok := s.allowDelivery(url); !ok {
+		t.Fatal("expected the probe to be allowed")
+	}
+	s.recordSuccess(url)
+
+	c := circuitFor(t, s, url)
+	if c.State != CircuitClosed || c.Failures != 0 || !c.OpenUntil.IsZero() {
+		t.Errorf("expected a closed circuit after a successful probe, got %+v", c)
+	}
+	for range 3 {
+		if _, ok := s.allowDelivery(url); !ok {
+			t.Fatal("expected deliveries through a closed circuit")
+		}
+	}
+}
+
+func TestCircuit_ProbeFailureReopensWithGrowingPeriod(t *testing.T) {
+	s := newTestService(nil, circuitConfig(1, time.Minute, 3*time.Minute))
+	clock := withTestClock(s, circuitStart)
+	url := "https://hooks.example.com/a"
+	s.recordFailure(url, nil)
+
+	// Each failed probe doubles the open period, up to the cap
+	for i, want := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		clock.Advance(time.Hour)
+		if _, ok := s.allowDelivery(url); !ok {
+			t.Fatalf("probe %d: expected to be allowed", i+1)
+		}
+		s.recordFailure(url, nil)
+
+		retryAt, ok := s.allowDelivery(url)
+		if ok {
+			t.Fatalf("probe %d: expected the circuit to reopen", i+1)
+		}
+		if got := retryAt.Sub(clock.Now()); got != want {
+			t.Errorf("probe %d: expected open for %s, got %s", i+1, want, got)
+		}
+	}
+
+	// A successful probe starts the schedule over
+	clock.Advance(time.Hour)
+	s.allowDelivery(url)
+	s.recordSuccess(url)
+	s.recordFailure(url, nil)
+	if retryAt, _ := s.allowDelivery(url); retryAt.Sub(clock.Now()) != time.Minute {
+		t.Errorf("expected the base open period after recovery, got %s", retryAt.Sub(clock.Now()))
+	}
+}
+
+func TestCircuit_AbandonedProbeAllowsAnother(t *testing.T) {
+	s := newTestService(nil, circuitConfig(1, time.Minute, time.Hour))
+	clock := withTestClock(s, circuitStart)
+	url := "https://hooks.example.com/a"
+	s.recordFailure(url, nil)
+	clock.Advance(time.Minute)
+
+	if _, ok := s.allowDelivery(url); !ok {
+		t.Fatal("expected the probe to be allowed")
+	}
+	// The probe never reports back
+	clock.Advance(s.probeTimeout())
+	if _, ok := s.allowDelivery(url); !ok {
+		t.Error("expected a new probe once the first one timed out")
+	}
+}
+
+func TestResetCircuit(t *testing.T) {
+	s := newTestService(nil, circuitConfig(1, time.Minute, time.Hour))
+	url := "https://hooks.example.com/a"
+	s.recordFailure(url, nil)
+
+	if _, ok := s.ResetCircuit("unknown"); ok {
+		t.Error("expected reset of an unknown circuit to report false")
+	}
+	if info, ok := s.ResetCircuit(CircuitID(url)); !ok || info.State != CircuitClosed || info.URL != url {
+		t.Fatalf("expected reset to close the circuit, got %+v (found %v)", info, ok)
+	}
+	if c := circuitFor(t, s, url); c.State != CircuitClosed || c.Failures != 0 {
+		t.Errorf("expected a closed circuit after reset, got %+v", c)
+	}
+	if _, ok := s.allowDelivery(url); !ok {
+		t.Error("expected deliveries after reset")
+	}
+}
+
+func TestDeliver_ConcurrentCallersSendOneProbe(t *testing.T) {
+	server, received, release := startBlockingWebhookServer(t)
+	s := newTestService(nil, circuitConfig(1, time.Minute, time.Hour))
+	clock := withTestClock(s, circuitStart)
+	s.recordFailure(server.URL, nil)
+	clock.Advance(time.Minute)
+
+	const callers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.deliver(context.Background(), Config{URL: server.URL}, testPayloads("req-1", uint64(i+1))[0])
+		}()
+	}
+
+	// Everyone but the probe is turned away while it is in flight
+	waitFor(t, "the other callers to be turned away", func() bool { return len(errs) == callers-1 })
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	if received.Load() != 1 || succeeded != 1 {
+		t.Errorf("expected a single probe delivery, got %d received and %d succeeded", received.Load(), succeeded)
+	}
+	if state := circuitFor(t, s, server.URL).State; state != CircuitClosed {
+		t.Errorf("expected the successful probe to close the circuit, got %s", state)
+	}
+
+	// Once closed, deliveries flow again
+	if err := s.deliver(context.Background(), Config{URL: server.URL}, testPayloads("req-2", 1)[0]); err != nil {
+		t.Errorf("expected delivery through the closed circuit, got %v", err)
+	}
+}
@@ -2,13 +2,79 @@ package webhook
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	agentv1 "github.com/forge/platform/gen/agent/v1"
 )
 
+// Payload schema versions. A consumer can pin the version its deliveries are encoded in
+// (see Config.SchemaVersion), so when Payload's fields are renamed the encoders of older
+// versions keep sending the names those consumers know.
+const (
+	SchemaVersion202401 = "2024-01"
+
+	// CurrentSchemaVersion is the schema of deliveries that pin no version
+	CurrentSchemaVersion = SchemaVersion202401
+)
+
+// SchemaHeader names the schema version of a delivery's payload
+const SchemaHeader = "X-Forge-Event-Schema"
+
+// ErrUnsupportedSchemaVersion is returned for payload schema versions the platform cannot encode
+var ErrUnsupportedSchemaVersion = errors.New("unsupported payload schema version")
+
+// schemaEncoders encode a payload in each supported schema version
+var schemaEncoders = map[string]func(Payload) ([]byte, error){
+	SchemaVersion202401: encodePayload202401,
+}
+
+// SchemaVersions returns the supported payload schema versions, oldest first
+func SchemaVersions() []string {
+	versions := make([]string, 0, len(schemaEncoders))
+	for version := range schemaEncoders {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// ValidateSchemaVersion checks that a requested payload schema version is supported; empty
+// stands for CurrentSchemaVersion. Errors wrap ErrUnsupportedSchemaVersion and list the
+// supported versions.
+func ValidateSchemaVersion(version string) error {
+	if _, ok := schemaEncoders[version]; ok || version == "" {
+		return nil
+	}
+	return fmt.Errorf("%w %q, supported versions are %s", ErrUnsupportedSchemaVersion, version, strings.Join(SchemaVersions(), ", "))
+}
+
+// EncodePayload marshals a payload for delivery in the given schema version, or
+// CurrentSchemaVersion if version is empty, setting its SchemaVersion
+func EncodePayload(payload Payload, version string) ([]byte, error) {
+	if version == "" {
+		version = CurrentSchemaVersion
+	}
+	encode, ok := schemaEncoders[version]
+	if !ok {
+		return nil, ValidateSchemaVersion(version)
+	}
+	payload.SchemaVersion = version
+	return encode(payload)
+}
+
+// encodePayload202401 encodes the 2024-01 schema, which Payload's JSON tags describe
+func encodePayload202401(payload Payload) ([]byte, error) {
+	return json.Marshal(payload)
+}
+
 // AgentResponseToPayload converts a protobuf AgentResponse to a webhook Payload.
 // This is a pass-through conversion - the platform does not parse the OpenCode event JSON.
+// Artifact responses are not converted: they are stored (see SaveArtifact) and listed on
+// the request's final payload instead.
 func AgentResponseToPayload(resp *agentv1.AgentResponse, agentID, requestID string) Payload {
 	timestamp := time.UnixMilli(resp.GetTimestamp())
 	if resp.GetTimestamp() == 0 {
@@ -111,6 +177,75 @@ func ErrorToPayload(agentID, requestID string, seq uint64, errorCode, message st
 	}
 }
 
+// CompleteToPayload creates a completion webhook payload
+func CompleteToPayload(agentID, requestID string, seq uint64, success bool) Payload {
+	return Payload{
+		EventType: EventTypeComplete,
+		AgentID:   agentID,
+		RequestID: requestID,
+		Seq:       seq,
+		Timestamp: time.Now(),
+		IsFinal:   true,
+		Success:   success,
+	}
+}
+
+// ResultToPayload creates a final agent.result payload for a request that ended with status
+func ResultToPayload(agentID, requestID string, seq uint64, status string) Payload {
+	return Payload{
+		EventType: EventTypeResult,
+		AgentID:   agentID,
+		RequestID: requestID,
+		Seq:       seq,
+		Timestamp: time.Now(),
+		IsFinal:   true,
+		Status:    status,
+	}
+}
+
+// DroppedEventPayload creates the agent.event_dropped payload delivered in place of an
+// event of an ordered request that could not be delivered. It keeps the event's seq, and
+// is final if the event was, so the consumer sees the gap and can fetch the event again
+// through redelivery.
+func DroppedEventPayload(dropped Payload) Payload {
+	return Payload{
+		EventType: EventTypeEventDropped,
+		AgentID:   dropped.AgentID,
+		RequestID: dropped.RequestID,
+		SessionID: dropped.SessionID,
+		Seq:       dropped.Seq,
+		Timestamp: time.Now(),
+		IsFinal:   dropped.IsFinal,
+		Error: &ErrorPayload{
+			Code:        ErrorCodeEventDropped,
+			Message:     fmt.Sprintf("event %d could not be delivered", dropped.Seq),
+			Recoverable: true,
+		},
+		DroppedEventType: dropped.EventType,
+		BatchID:          dropped.BatchID,
+		Step:             dropped.Step,
+	}
+}
+
+// EventBatchPayload creates the agent.event_batch payload delivering consecutive
+// agent.event payloads of a request at once. It takes the seq, timestamp and agent state of
+// the last event, so the request's seqs keep increasing across deliveries.
+func EventBatchPayload(events []Payload) Payload {
+	last := events[len(events)-1]
+	return Payload{
+		EventType:  EventTypeEventBatch,
+		AgentID:    last.AgentID,
+		RequestID:  last.RequestID,
+		SessionID:  last.SessionID,
+		Seq:        last.Seq,
+		Timestamp:  last.Timestamp,
+		AgentState: last.AgentState,
+		BatchID:    last.BatchID,
+		Step:       last.Step,
+		Events:     events,
+	}
+}
+
 // agentStateToString converts the protobuf AgentState enum to a human-readable string
 func agentStateToString(state agentv1.AgentState) string {
 	switch state {
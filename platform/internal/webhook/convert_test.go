@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// goldenPayloads returns one payload of every type, with fixed timestamps
+func goldenPayloads() map[string]Payload {
+	at := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	response := func(seq uint64) *agentv1.AgentResponse {
+		return &agentv1.AgentResponse{
+			SessionId: "session-1",
+			Seq:       seq,
+			Timestamp: at.UnixMilli(),
+			State:     agentv1.AgentState_AGENT_STATE_PROCESSING,
+		}
+	}
+
+	event := response(1)
+	event.Payload = &agentv1.AgentResponse_Event{Event: &agentv1.EventPayload{
+		EventType: "message.updated",
+		EventJson: []byte(`{"type":"message.updated","properties":{"id":"msg-1"}}`),
+	}}
+	failed := response(2)
+	failed.Payload = &agentv1.AgentResponse_Error{Error: &agentv1.ErrorPayload{
+		Code:    "MODEL_ERROR",
+		Message: "model unavailable",
+	}}
+	complete := response(3)
+	complete.Payload = &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}
+
+	step := 1
+	eventPayload := AgentResponseToPayload(event, "agent-1", "req-1")
+	eventPayload.BatchID = "batch-1"
+	eventPayload.Step = &step
+
+	completePayload := AgentResponseToPayload(complete, "agent-1", "req-1")
+	completePayload.Artifacts = []Artifact{{
+		Name:      "report.txt",
+		MediaType: "text/plain",
+		Size:      5,
+		Data:      []byte("hello"),
+		URL:       "https://forge.example.com/api/v1/requests/req-1/artifacts/report.txt",
+	}}
+
+	nextEvent := eventPayload
+	nextEvent.Seq = 2
+	nextEvent.Event = json.RawMessage(`{"type":"message.updated","properties":{"id":"msg-2"}}`)
+
+	result := ResultToPayload("agent-1", "req-1", 4, ResultStatusInterrupted)
+	result.Timestamp = at
+	dropped := DroppedEventPayload(eventPayload)
+	dropped.Timestamp = at
+	dropped.DeliveryAttempt = 2
+
+	return map[string]Payload{
+		"event":         eventPayload,
+		"error":         AgentResponseToPayload(failed, "agent-1", "req-1"),
+		"complete":      completePayload,
+		"result":        result,
+		"event_dropped": dropped,
+		"event_batch":   EventBatchPayload([]Payload{eventPayload, nextEvent}),
+		"lifecycle": {
+			EventType: EventTypeAgentFailed,
+			AgentID:   "agent-1",
+			RequestID: LifecycleRequestID("user-1", "agent-1"),
+			Seq:       uint64(at.UnixNano()),
+			Timestamp: at,
+			Lifecycle: &LifecyclePayload{
+				UserID:         "user-1",
+				PodPhase:       "Failed",
+				Reason:         "OOMKilled",
+				AgentCreatedAt: &at,
+			},
+		},
+	}
+}
+
+func TestEncodePayload_Golden(t *testing.T) {
+	for _, version := range SchemaVersions() {
+		for name, payload := range goldenPayloads() {
+			t.Run(version+"/"+name, func(t *testing.T) {
+				body, err := EncodePayload(payload, version)
+				if err != nil {
+					t.Fatalf("EncodePayload: %v", err)
+				}
+				var got bytes.Buffer
+				if err := json.Indent(&got, body, "", "  "); err != nil {
+					t.Fatalf("indenting payload: %v", err)
+				}
+				got.WriteByte('\n')
+
+				path := filepath.Join("testdata", "payload_"+name+"."+version+".golden.json")
+				if *update {
+					if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+						t.Fatalf("failed to update golden file: %v", err)
+					}
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("failed to read golden file: %v", err)
+				}
+				if got.String() != string(want) {
+					t.Errorf("payload differs from %s (run with -update to accept):\n%s", path, got.String())
+				}
+			})
+		}
+	}
+}
+
+func TestEncodePayload_DefaultsToCurrentVersion(t *testing.T) {
+	body, err := EncodePayload(goldenPayloads()["result"], "")
+	if err != nil {
+		t.Fatalf("EncodePayload: %v", err)
+	}
+	var decoded Payload
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	if decoded.SchemaVersion != CurrentSchemaVersion {
+		t.Errorf("schema_version = %q, want %q", decoded.SchemaVersion, CurrentSchemaVersion)
+	}
+}
+
+func TestValidateSchemaVersion(t *testing.T) {
+	for _, version := range append(SchemaVersions(), "") {
+		if err := ValidateSchemaVersion(version); err != nil {
+			t.Errorf("ValidateSchemaVersion(%q) = %v, want nil", version, err)
+		}
+	}
+
+	err := ValidateSchemaVersion("2019-06")
+	if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Fatalf("expected ErrUnsupportedSchemaVersion, got %v", err)
+	}
+	if !strings.Contains(err.Error(), CurrentSchemaVersion) {
+		t.Errorf("error %q does not list the supported versions", err)
+	}
+	if _, err := EncodePayload(Payload{}, "2019-06"); !errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Errorf("EncodePayload: expected ErrUnsupportedSchemaVersion, got %v", err)
+	}
+}
+
+func TestOutboxWorkers_SendPinnedSchemaVersion(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+
+	webhookCfg := Config{URL: server.URL, SchemaVersion: SchemaVersion202401}
+	if err := s.Enqueue(context.Background(), webhookCfg, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	claimAndProcess(t, s, querier)
+
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(got))
+	}
+	if header := got[0].header.Get(SchemaHeader); header != SchemaVersion202401 {
+		t.Errorf("%s = %q, want %q", SchemaHeader, header, SchemaVersion202401)
+	}
+	if got[0].payload.SchemaVersion != SchemaVersion202401 {
+		t.Errorf("schema_version = %q, want %q", got[0].payload.SchemaVersion, SchemaVersion202401)
+	}
+}
@@ -2,86 +2,196 @@ package webhook
 
 import (
 	"bytes"
+	"cmp"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
+	"crypto/tls"
 	"database/sql"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 
 	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/metrics"
 	"github.com/forge/platform/internal/sqlc/gen"
 )
 
-// RetryDelays defines the delay before each retry attempt
-var RetryDelays = []time.Duration{
-	0,                // Attempt 1: immediate
-	1 * time.Second,  // Attempt 2: 1s
-	5 * time.Second,  // Attempt 3: 5s
-	30 * time.Second, // Attempt 4: 30s
-	60 * time.Second, // Attempt 5: 60s
-}
+// Delivery statuses stored in webhook_deliveries.status
+const (
+	DeliveryStatusPending    = "pending"
+	DeliveryStatusDelivering = "delivering"
+	DeliveryStatusCompleted  = "completed"
+	DeliveryStatusFailed     = "failed"
+)
+
+// ErrDeliveryNotFound is returned when no delivery record exists for a request
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+// ErrDuplicateRequest is returned when a delivery record already exists for a request ID,
+// i.e. the request was sent before
+var ErrDuplicateRequest = errors.New("request ID already used")
+
+// ErrorCodeDuplicateRequest is the error code of a request refused for reusing the ID of
+// one in progress
+const ErrorCodeDuplicateRequest = "duplicate_request"
 
 // DeliveryService handles webhook delivery with retries and circuit breaker
 type DeliveryService struct {
-	client  *http.Client
-	logger  *zap.Logger
-	queries *sqlc.Queries
-	pool    *pgxpool.Pool
-	cfg     *config.Config
+	client *http.Client
+	// urlPolicy refuses webhook destinations on private networks (see ValidateURL)
+	urlPolicy *urlPolicy
+	logger    *zap.Logger
+	queries   sqlc.Querier
+	pool      *pgxpool.Pool
+	cfg       *config.Config
+
+	// Retry schedule, and the clock used to schedule retries (replaced in tests)
+	backoff *backoff
+	now     func() time.Time
 
 	// Circuit breaker state (in-memory, per webhook URL)
 	circuitMu     sync.RWMutex
 	circuitStates map[string]*circuitState
-}
 
-type circuitState struct {
-	failures   int
-	openUntil  time.Time
-	lastFailed time.Time
+	// Delivery rate limit state (in-memory, per webhook URL; see takeDeliveryToken)
+	rateMu      sync.Mutex
+	rateBuckets map[string]*rateBucket
+
+	// Bounded pool for async deliveries, and their in-flight/dead-letter tracking for readiness
+	asyncPool *asyncPool
+	async     asyncTracker
+
+	// Outbox workers: wake-up signal for new events and the number mid-delivery
+	outboxWake chan struct{}
+	outboxBusy atomic.Int32
+
+	// Delivery attempts waiting to be stored (see runAttemptRecorder)
+	attempts chan *sqlc.InsertWebhookDeliveryAttemptsParams
+
+	// Latest probe of each webhook URL, and the TLS config probes use (replaced in tests)
+	probes         probeCache
+	probeTLSConfig *tls.Config
+
+	// metrics counts delivery attempts; nil records nothing
+	metrics *metrics.Metrics
 }
 
 // NewDeliveryService creates a new webhook delivery service
 func NewDeliveryService(pool *pgxpool.Pool, cfg *config.Config, logger *zap.Logger) *DeliveryService {
+	policy := loadURLPolicy(cfg, logger)
 	return &DeliveryService{
-		client: &http.Client{
-			Timeout: cfg.WebhookTimeout,
-		},
+		client:        newHTTPClient(cfg.WebhookTimeout, policy),
+		urlPolicy:     policy,
 		logger:        logger,
 		queries:       sqlc.New(pool),
 		pool:          pool,
 		cfg:           cfg,
+		backoff:       newBackoff(cfg),
+		now:           time.Now,
+		circuitStates: make(map[string]*circuitState),
+		rateBuckets:   make(map[string]*rateBucket),
+		asyncPool:     newAsyncPool(cfg.WebhookAsyncQueueSize),
+		outboxWake:    make(chan struct{}, 1),
+		attempts:      make(chan *sqlc.InsertWebhookDeliveryAttemptsParams, attemptQueueSize),
+	}
+}
+
+// NewDeliveryServiceWithQuerier creates a delivery service backed by the given querier.
+// This is useful for testing with a fake querier.
+func NewDeliveryServiceWithQuerier(queries sqlc.Querier, cfg *config.Config, logger *zap.Logger) *DeliveryService {
+	policy := loadURLPolicy(cfg, logger)
+	return &DeliveryService{
+		client:        newHTTPClient(cfg.WebhookTimeout, policy),
+		urlPolicy:     policy,
+		logger:        logger,
+		queries:       queries,
+		cfg:           cfg,
+		backoff:       newBackoff(cfg),
+		now:           time.Now,
 		circuitStates: make(map[string]*circuitState),
+		rateBuckets:   make(map[string]*rateBucket),
+		asyncPool:     newAsyncPool(cfg.WebhookAsyncQueueSize),
+		outboxWake:    make(chan struct{}, 1),
+		attempts:      make(chan *sqlc.InsertWebhookDeliveryAttemptsParams, attemptQueueSize),
 	}
 }
 
-// Deliver sends a webhook payload synchronously with retries
+// loadURLPolicy builds the webhook destination policy, logging config entries it skips
+func loadURLPolicy(cfg *config.Config, logger *zap.Logger) *urlPolicy {
+	policy, err := newURLPolicy(cfg)
+	if err != nil {
+		logger.Error("ignoring invalid webhook URL policy entries", zap.Error(err))
+	}
+	return policy
+}
+
+// Deliver stores the payload for redelivery and sends it synchronously with retries to
+// each endpoint of webhookCfg in parallel, so a failing endpoint does not hold up the others.
+// It returns an error only if no endpoint received the payload.
 func (s *DeliveryService) Deliver(ctx context.Context, webhookCfg Config, payload Payload) error {
-	// Check circuit breaker
-	if s.isCircuitOpen(webhookCfg.URL) {
-		s.logger.Warn("circuit breaker open, skipping delivery",
-			zap.String("webhook_url", webhookCfg.URL),
+	// Store before delivering so events dropped by the circuit breaker can be replayed
+	if err := s.PersistEvent(ctx, payload); err != nil {
+		s.logger.Warn("failed to store webhook event",
+			zap.Error(err),
 			zap.String("request_id", payload.RequestID),
+			zap.Uint64("seq", payload.Seq),
 		)
-		return fmt.Errorf("circuit breaker open for %s", webhookCfg.URL)
 	}
 
+	endpoints := webhookCfg.Endpoints()
+	if len(endpoints) == 1 {
+		return s.deliver(ctx, endpoints[0], payload)
+	}
+
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.deliver(ctx, endpoint, payload)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			s.logger.Warn("webhook endpoint failed",
+				zap.Error(err),
+				zap.String("webhook_url", endpoints[i].URL),
+				zap.String("request_id", payload.RequestID),
+				zap.Uint64("seq", payload.Seq),
+			)
+		}
+	}
+	if failed == len(endpoints) {
+		return fmt.Errorf("delivery failed to all %d webhook endpoints: %w", failed, errors.Join(errs...))
+	}
+	return nil
+}
+
+// deliver sends a webhook payload synchronously with retries
+func (s *DeliveryService) deliver(ctx context.Context, webhookCfg Config, payload Payload) error {
 	var lastErr error
-	maxRetries := min(s.cfg.WebhookMaxRetries, len(RetryDelays))
+	var retryAfter time.Duration
+	maxRetries := max(s.cfg.WebhookMaxRetries, 1)
 
 	for attempt := range maxRetries {
 		if attempt > 0 {
-			delay := RetryDelays[attempt]
+			delay := s.backoff.delay(attempt, retryAfter)
 			s.logger.Debug("retrying webhook delivery",
 				zap.Int("attempt", attempt+1),
 				zap.Duration("delay", delay),
@@ -95,17 +205,32 @@ func (s *DeliveryService) Deliver(ctx context.Context, webhookCfg Config, payloa
 			}
 		}
 
-		result := s.deliverOnce(ctx, webhookCfg, payload)
+		// Check circuit breaker; a failed attempt may have tripped it
+		if _, ok := s.allowDelivery(webhookCfg.URL); !ok {
+			s.logger.Warn("circuit breaker open, skipping delivery",
+				zap.String("webhook_url", webhookCfg.URL),
+				zap.String("request_id", payload.RequestID),
+			)
+			return fmt.Errorf("circuit breaker open for %s", webhookCfg.URL)
+		}
+
+		result := s.deliverOnce(ctx, webhookCfg, payload, attempt+1)
 		if result.Success {
 			s.recordSuccess(webhookCfg.URL)
 			return nil
 		}
 
+		// The destination is refused by policy, retrying cannot help
+		if errors.Is(result.Error, ErrURLRejected) {
+			return result.Error
+		}
+
 		lastErr = result.Error
+		retryAfter = result.RetryAfter
 		s.recordFailure(webhookCfg.URL, result.Error)
 
-		// Don't retry on 4xx errors (client error)
-		if result.StatusCode >= 400 && result.StatusCode < 500 {
+		// Don't retry on 4xx errors (client error), unless asked to try again later
+		if !isRetryableStatus(result.StatusCode) {
 			s.logger.Warn("webhook returned client error, not retrying",
 				zap.Int("status_code", result.StatusCode),
 				zap.String("request_id", payload.RequestID),
@@ -117,25 +242,30 @@ func (s *DeliveryService) Deliver(ctx context.Context, webhookCfg Config, payloa
 	return fmt.Errorf("webhook delivery failed after %d attempts: %w", maxRetries, lastErr)
 }
 
-// DeliverAsync sends a webhook payload asynchronously
-func (s *DeliveryService) DeliverAsync(webhookCfg Config, payload Payload) {
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-		defer cancel()
+// deliverOnce makes a single webhook delivery attempt and records it as the given attempt
+// number (see recordAttempt)
+func (s *DeliveryService) deliverOnce(ctx context.Context, webhookCfg Config, payload Payload, attempt int) DeliveryResult {
+	if webhookCfg.Unordered {
+		payload.DeliveryAttempt = attempt
+	}
+	start := s.now()
+	result := s.send(ctx, webhookCfg, payload)
+	s.recordAttempt(webhookCfg, payload, attempt, result, start, s.now().Sub(start))
+	return result
+}
 
-		if err := s.Deliver(ctx, webhookCfg, payload); err != nil {
-			s.logger.Error("async webhook delivery failed",
-				zap.Error(err),
-				zap.String("request_id", payload.RequestID),
-				zap.String("webhook_url", webhookCfg.URL),
-			)
-		}
-	}()
+// send posts a payload to the webhook once, under a new delivery ID (see DeliveryIDHeader)
+func (s *DeliveryService) send(ctx context.Context, webhookCfg Config, payload Payload) DeliveryResult {
+	deliveryID := uuid.NewString()
+	result := s.sendRequest(ctx, webhookCfg, payload, deliveryID)
+	result.DeliveryID = deliveryID
+	return result
 }
 
-// deliverOnce makes a single webhook delivery attempt
-func (s *DeliveryService) deliverOnce(ctx context.Context, webhookCfg Config, payload Payload) DeliveryResult {
-	body, err := json.Marshal(payload)
+// sendRequest posts a payload to the webhook once as the delivery deliveryID
+func (s *DeliveryService) sendRequest(ctx context.Context, webhookCfg Config, payload Payload, deliveryID string) DeliveryResult {
+	schemaVersion := cmp.Or(webhookCfg.SchemaVersion, CurrentSchemaVersion)
+	body, err := EncodePayload(payload, schemaVersion)
 	if err != nil {
 		return DeliveryResult{
 			Success: false,
@@ -163,14 +293,19 @@ func (s *DeliveryService) deliverOnce(ctx context.Context, webhookCfg Config, pa
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "Forge-Platform/1.0")
+	req.Header.Set(SchemaHeader, schemaVersion)
+	req.Header.Set(DeliveryIDHeader, deliveryID)
+	req.Header.Set(RequestIDHeader, payload.RequestID)
+	req.Header.Set(SeqHeader, strconv.FormatUint(payload.Seq, 10))
 
-	// Add HMAC signature if secret is configured
+	// Add HMAC signatures if a secret is configured (see SignatureHeader)
 	if webhookCfg.Secret != "" {
 		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-		signature := s.computeSignature(timestamp, body, webhookCfg.Secret)
-		req.Header.Set("X-Forge-Signature", "sha256="+signature)
-		req.Header.Set("X-Forge-Timestamp", timestamp)
+		req.Header.Set(SignatureHeader, signatureHeader(timestamp, body, webhookCfg.Secret, webhookCfg.SecondarySecret))
+		req.Header.Set(SignatureV2Header, signatureV2Header(timestamp, deliveryID, body, webhookCfg.Secret, webhookCfg.SecondarySecret))
+		req.Header.Set(TimestampHeader, timestamp)
 	}
+	setCustomHeaders(req, webhookCfg)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
@@ -181,7 +316,7 @@ func (s *DeliveryService) deliverOnce(ctx context.Context, webhookCfg Config, pa
 	}
 	defer resp.Body.Close()
 
-	// Read response body for logging
+	// Read the start of the response body for logging
 	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
 
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
@@ -190,8 +325,9 @@ func (s *DeliveryService) deliverOnce(ctx context.Context, webhookCfg Config, pa
 			zap.Int("status_code", resp.StatusCode),
 		)
 		return DeliveryResult{
-			Success:    true,
-			StatusCode: resp.StatusCode,
+			Success:      true,
+			StatusCode:   resp.StatusCode,
+			ResponseBody: string(respBody),
 		}
 	}
 
@@ -202,97 +338,55 @@ func (s *DeliveryService) deliverOnce(ctx context.Context, webhookCfg Config, pa
 	)
 
 	return DeliveryResult{
-		Success:    false,
-		StatusCode: resp.StatusCode,
-		Error:      fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, string(respBody)),
-	}
-}
-
-// computeSignature computes the HMAC-SHA256 signature for a webhook payload
-func (s *DeliveryService) computeSignature(timestamp string, body []byte, secret string) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(timestamp))
-	mac.Write([]byte("."))
-	mac.Write(body)
-	return hex.EncodeToString(mac.Sum(nil))
-}
-
-// isCircuitOpen checks if the circuit breaker is open for a URL
-func (s *DeliveryService) isCircuitOpen(url string) bool {
-	s.circuitMu.RLock()
-	defer s.circuitMu.RUnlock()
-
-	state, ok := s.circuitStates[url]
-	if !ok {
-		return false
-	}
-
-	if state.openUntil.After(time.Now()) {
-		return true
-	}
-
-	return false
-}
-
-// recordFailure records a delivery failure for circuit breaker logic
-func (s *DeliveryService) recordFailure(url string, _ error) {
-	s.circuitMu.Lock()
-	defer s.circuitMu.Unlock()
-
-	state, ok := s.circuitStates[url]
-	if !ok {
-		state = &circuitState{}
-		s.circuitStates[url] = state
-	}
-
-	state.failures++
-	state.lastFailed = time.Now()
-
-	if state.failures >= s.cfg.WebhookCircuitThreshold {
-		state.openUntil = time.Now().Add(s.cfg.WebhookCircuitTimeout)
-		s.logger.Warn("circuit breaker opened",
-			zap.String("webhook_url", url),
-			zap.Int("failures", state.failures),
-			zap.Time("open_until", state.openUntil),
-		)
+		Success:      false,
+		StatusCode:   resp.StatusCode,
+		Error:        fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, string(respBody)),
+		RetryAfter:   parseRetryAfter(resp.Header.Get("Retry-After"), s.now()),
+		ResponseBody: string(respBody),
 	}
 }
 
-// recordSuccess records a successful delivery and resets circuit breaker
-func (s *DeliveryService) recordSuccess(url string) {
-	s.circuitMu.Lock()
-	defer s.circuitMu.Unlock()
+// CreateDeliveryRecord creates a webhook delivery record in the database for each endpoint
+// of webhookCfg, numbered in order so the first stands for the request. userID is the
+// user who sent the request.
+func (s *DeliveryService) CreateDeliveryRecord(ctx context.Context, requestID, userID, agentID string, webhookCfg Config) error {
+	return s.inTx(ctx, func(q sqlc.Querier) error {
+		for i, endpoint := range webhookCfg.Endpoints() {
+			var secretHash, tokenHash sql.NullString
+			if endpoint.Secret != "" {
+				secretHash = sql.NullString{String: hashSecret(endpoint.Secret), Valid: true}
+			}
+			if endpoint.BearerToken != "" {
+				tokenHash = sql.NullString{String: hashSecret(endpoint.BearerToken), Valid: true}
+			}
 
-	if state, ok := s.circuitStates[url]; ok {
-		if state.failures > 0 {
-			s.logger.Info("circuit breaker reset after success",
-				zap.String("webhook_url", url),
-			)
+			// Header values may be credentials, so only the names are recorded
+			_, err := q.CreateWebhookDelivery(ctx, &sqlc.CreateWebhookDeliveryParams{
+				RequestID:              requestID,
+				AgentID:                agentID,
+				WebhookUrl:             endpoint.URL,
+				WebhookSecretHash:      secretHash,
+				IncludeThinking:        !webhookCfg.OmitThinking,
+				EndpointIndex:          int32(i),
+				WebhookHeaderNames:     headerNames(endpoint.Headers),
+				WebhookBearerTokenHash: tokenHash,
+				UserID:                 sql.NullString{String: userID, Valid: true},
+			})
+			if isUniqueViolation(err) {
+				return fmt.Errorf("creating webhook delivery record for %s: %w", requestID, ErrDuplicateRequest)
+			}
+			if err != nil {
+				return fmt.Errorf("creating webhook delivery record: %w", err)
+			}
 		}
-		state.failures = 0
-		state.openUntil = time.Time{}
-	}
-}
-
-// CreateDeliveryRecord creates a webhook delivery record in the database
-func (s *DeliveryService) CreateDeliveryRecord(ctx context.Context, requestID, agentID string, webhookCfg Config) error {
-	var secretHash sql.NullString
-	if webhookCfg.Secret != "" {
-		hash := sha256.Sum256([]byte(webhookCfg.Secret))
-		secretHash = sql.NullString{String: hex.EncodeToString(hash[:]), Valid: true}
-	}
-
-	_, err := s.queries.CreateWebhookDelivery(ctx, &sqlc.CreateWebhookDeliveryParams{
-		RequestID:         requestID,
-		AgentID:           agentID,
-		WebhookUrl:        webhookCfg.URL,
-		WebhookSecretHash: secretHash,
+		return nil
 	})
-	if err != nil {
-		return fmt.Errorf("creating webhook delivery record: %w", err)
-	}
+}
 
-	return nil
+// isUniqueViolation reports whether err is Postgres refusing a duplicate key
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
 }
 
 // UpdateDeliverySeq updates the sequence number for a delivery
@@ -313,3 +407,42 @@ func (s *DeliveryService) MarkDeliveryCompleted(ctx context.Context, requestID s
 func (s *DeliveryService) MarkDeliveryFailed(ctx context.Context, requestID string) error {
 	return s.queries.MarkDeliveryFailed(ctx, requestID)
 }
+
+// GetDelivery returns the delivery record for a request
+func (s *DeliveryService) GetDelivery(ctx context.Context, requestID string) (*sqlc.WebhookDelivery, error) {
+	delivery, err := s.queries.GetWebhookDelivery(ctx, requestID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrDeliveryNotFound
+		}
+		return nil, fmt.Errorf("getting webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// ListDeliveryEndpoints returns the delivery record of each endpoint of a request, in the
+// order they were given. It returns ErrDeliveryNotFound if the request was never recorded.
+func (s *DeliveryService) ListDeliveryEndpoints(ctx context.Context, requestID string) ([]*sqlc.WebhookDelivery, error) {
+	endpoints, err := s.queries.ListWebhookDeliveryEndpoints(ctx, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("listing webhook delivery endpoints: %w", err)
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrDeliveryNotFound
+	}
+	return endpoints, nil
+}
+
+// ListDeliveriesForAgent returns the most recent delivery records a user made for an agent,
+// newest first. Records made before owners were stored are included for every user.
+func (s *DeliveryService) ListDeliveriesForAgent(ctx context.Context, userID, agentID string, limit int32) ([]*sqlc.WebhookDelivery, error) {
+	deliveries, err := s.queries.ListDeliveriesByAgent(ctx, &sqlc.ListDeliveriesByAgentParams{
+		AgentID: agentID,
+		Limit:   limit,
+		UserID:  userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
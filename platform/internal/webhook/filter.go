@@ -0,0 +1,40 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// EventTypes lists every webhook event type, in the order they are documented
+var EventTypes = []EventType{EventTypeEvent, EventTypeError, EventTypeComplete, EventTypeResult}
+
+// ErrUnknownEventType is returned for event type filters naming a type that does not exist
+var ErrUnknownEventType = errors.New("unknown webhook event type")
+
+// ParseEventTypes converts an event type filter from its API form, rejecting unknown types.
+// Errors wrap ErrUnknownEventType and list the valid types.
+func ParseEventTypes(names []string) ([]EventType, error) {
+	types := make([]EventType, 0, len(names))
+	for _, name := range names {
+		t := EventType(name)
+		if !slices.Contains(EventTypes, t) {
+			valid := make([]string, len(EventTypes))
+			for i, v := range EventTypes {
+				valid[i] = string(v)
+			}
+			return nil, fmt.Errorf("%w %q; valid values: %s", ErrUnknownEventType, name, strings.Join(valid, ", "))
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// Delivers reports whether payload passes the config's event type filter. Final payloads
+// always do, so consumers learn how every request ended.
+func (c Config) Delivers(payload Payload) bool {
+	return payload.IsFinal || len(c.EventTypes) == 0 || slices.Contains(c.EventTypes, payload.EventType)
+}
@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseEventTypes(t *testing.T) {
+	types, err := ParseEventTypes([]string{"agent.error", "agent.complete", "agent.error"})
+	if err != nil {
+		t.Fatalf("ParseEventTypes: %v", err)
+	}
+	if len(types) != 2 || types[0] != EventTypeError || types[1] != EventTypeComplete {
+		t.Errorf("expected deduplicated types in order, got %v", types)
+	}
+
+	_, err = ParseEventTypes([]string{"agent.event", "agent.done"})
+	if !errors.Is(err, ErrUnknownEventType) {
+		t.Fatalf("expected ErrUnknownEventType, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"agent.done"`) || !strings.Contains(err.Error(), "agent.event, agent.error, agent.complete, agent.result") {
+		t.Errorf("expected the error to name the type and list valid values, got %q", err)
+	}
+}
+
+func TestConfigDelivers(t *testing.T) {
+	filtered := Config{EventTypes: []EventType{EventTypeError}}
+	tests := []struct {
+		name    string
+		cfg     Config
+		payload Payload
+		want    bool
+	}{
+		{"no filter", Config{}, Payload{EventType: EventTypeEvent}, true},
+		{"filtered out", filtered, Payload{EventType: EventTypeEvent}, false},
+		{"listed type", filtered, Payload{EventType: EventTypeError}, true},
+		{"final is never filtered", filtered, Payload{EventType: EventTypeComplete, IsFinal: true}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.Delivers(tt.payload); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// MaxCustomHeaders is the most custom headers a webhook endpoint can have
+const MaxCustomHeaders = 20
+
+// ErrInvalidHeader is returned for custom webhook headers that cannot be sent
+var ErrInvalidHeader = errors.New("invalid webhook header")
+
+// reservedHeaders are set by the platform or the HTTP client and cannot be customized.
+// X-Forge-* headers are reserved as well.
+var reservedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// ValidateHeaders checks custom headers for a webhook endpoint: names and values must be
+// valid, names must not be reserved (Host, Content-Length, Transfer-Encoding, Connection
+// or X-Forge-*), and Authorization cannot be set alongside a bearer token.
+// Errors wrap ErrInvalidHeader.
+func ValidateHeaders(headers map[string]string, bearerToken string) error {
+	if len(headers) > MaxCustomHeaders {
+		return fmt.Errorf("%w: at most %d headers are allowed", ErrInvalidHeader, MaxCustomHeaders)
+	}
+	if !httpguts.ValidHeaderFieldValue(bearerToken) {
+		return fmt.Errorf("%w: bearer token contains invalid characters", ErrInvalidHeader)
+	}
+
+	for name, value := range headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("%w: %q is not a valid header name", ErrInvalidHeader, name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if reservedHeaders[canonical] || strings.HasPrefix(canonical, "X-Forge-") {
+			return fmt.Errorf("%w: %s is set by the platform", ErrInvalidHeader, canonical)
+		}
+		if canonical == "Authorization" && bearerToken != "" {
+			return fmt.Errorf("%w: Authorization cannot be set alongside a bearer token", ErrInvalidHeader)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("%w: value of %s contains invalid characters", ErrInvalidHeader, canonical)
+		}
+	}
+	return nil
+}
+
+// setCustomHeaders adds an endpoint's custom headers and bearer token to a delivery,
+// overriding the platform's defaults for any header they share
+func setCustomHeaders(req *http.Request, webhookCfg Config) {
+	for name, value := range webhookCfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if webhookCfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+webhookCfg.BearerToken)
+	}
+}
+
+// headerNames returns the canonical names of custom headers, sorted
+func headerNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hashSecret returns the hex SHA256 of a secret, for storing without the secret itself
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateHeaders(t *testing.T) {
+	tooMany := make(map[string]string, MaxCustomHeaders+1)
+	for i := range MaxCustomHeaders + 1 {
+		tooMany["X-Custom-"+strings.Repeat("a", i+1)] = "v"
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		token   string
+		valid   bool
+	}{
+		{"none", nil, "", true},
+		{"custom headers and token", map[string]string{"X-Tenant-ID": "acme", "User-Agent": "custom"}, "token", true},
+		{"authorization without token", map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, "", true},
+		{"host", map[string]string{"host": "internal"}, "", false},
+		{"content length", map[string]string{"Content-Length": "0"}, "", false},
+		{"forge header", map[string]string{"x-forge-signature": "sha256=forged"}, "", false},
+		{"authorization with token", map[string]string{"authorization": "Basic x"}, "token", false},
+		{"invalid name", map[string]string{"X Tenant": "acme"}, "", false},
+		{"invalid value", map[string]string{"X-Tenant": "acme\r\nX-Injected: 1"}, "", false},
+		{"invalid token", nil, "tok\nen", false},
+		{"too many", tooMany, "", false},
+	}
+	for _, tt := range tests {
+		err := ValidateHeaders(tt.headers, tt.token)
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected valid, got %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("%s: expected ErrInvalidHeader, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestOutboxWorkers_SendCustomHeadersAndBearerToken(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+
+	webhookCfg := Config{
+		URL:         server.URL,
+		Secret:      "secret",
+		Headers:     map[string]string{"x-tenant-id": "acme", "User-Agent": "acme-relay"},
+		BearerToken: "s3cr3t-token",
+	}
+	if err := s.CreateDeliveryRecord(context.Background(), "req-1", "user-1", "agent-1", webhookCfg); err != nil {
+		t.Fatalf("CreateDeliveryRecord: %v", err)
+	}
+	if err := s.Enqueue(context.Background(), webhookCfg, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	claimAndProcess(t, s, querier)
+
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(got))
+	}
+	header := got[0].header
+	if header.Get("Authorization") != "Bearer s3cr3t-token" || header.Get("X-Tenant-Id") != "acme" {
+		t.Errorf("expected the custom headers and bearer token, got %v", header)
+	}
+	if header.Get("User-Agent") != "acme-relay" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected custom headers to override defaults they name only, got %v", header)
+	}
+	if got[0].signature == "" {
+		t.Error("expected the delivery to still be signed")
+	}
+
+	// The delivery record keeps header names and a token hash, never the values
+	record := querier.deliveries[0]
+	if strings.Join(record.WebhookHeaderNames, ",") != "User-Agent,X-Tenant-Id" {
+		t.Errorf("expected sorted header names, got %v", record.WebhookHeaderNames)
+	}
+	if !record.WebhookBearerTokenHash.Valid || record.WebhookBearerTokenHash.String != hashSecret("s3cr3t-token") {
+		t.Errorf("expected the token hash, got %+v", record.WebhookBearerTokenHash)
+	}
+}
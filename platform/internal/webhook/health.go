@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// deadLetterWindow is the window over which the dead-letter rate is measured
+const deadLetterWindow = 5 * time.Minute
+
+// HealthStatus is the readiness state of the webhook subsystem
+type HealthStatus string
+
+const (
+	// HealthOK means deliveries are keeping up
+	HealthOK HealthStatus = "ok"
+	// HealthDegraded means deliveries are backing up but still flowing
+	HealthDegraded HealthStatus = "degraded"
+	// HealthFailing means events are at risk of being dropped
+	HealthFailing HealthStatus = "failing"
+)
+
+// HealthThresholds configures when the webhook subsystem is reported degraded or failing.
+// A zero threshold disables that check.
+type HealthThresholds struct {
+	// QueueDegradedRatio and QueueFailingRatio compare queue depth to capacity (0-1)
+	QueueDegradedRatio float64
+	QueueFailingRatio  float64
+	// PendingAgeDegraded and PendingAgeFailing bound the age of the oldest pending delivery
+	PendingAgeDegraded time.Duration
+	PendingAgeFailing  time.Duration
+	// DeadLetterRateDegraded and DeadLetterRateFailing bound dead letters per minute
+	DeadLetterRateDegraded float64
+	DeadLetterRateFailing  float64
+}
+
+// HealthSnapshot is a point-in-time view of webhook delivery load
+type HealthSnapshot struct {
+	// WorkersBusy and WorkersTotal describe delivery worker usage (WorkersTotal 0 = unbounded)
+	WorkersBusy  int `json:"workers_busy"`
+	WorkersTotal int `json:"workers_total"`
+	// QueueDepth and QueueCapacity describe deliveries waiting to be sent (QueueCapacity 0 = unbounded)
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+	// OldestPendingAge is how long the oldest undelivered payload has been waiting
+	OldestPendingAge time.Duration `json:"-"`
+	// DeadLetters is the number of deliveries given up on within the last deadLetterWindow
+	DeadLetters int `json:"dead_letters"`
+}
+
+// DeadLetterRate returns dead letters per minute over the measurement window
+func (s HealthSnapshot) DeadLetterRate() float64 {
+	return float64(s.DeadLetters) / deadLetterWindow.Minutes()
+}
+
+// HealthReport is the webhook section of the readiness response
+type HealthReport struct {
+	Status HealthStatus `json:"status"`
+	HealthSnapshot
+	WorkerUtilization    float64  `json:"worker_utilization"`
+	QueueUtilization     float64  `json:"queue_utilization"`
+	OldestPendingAgeMs   int64    `json:"oldest_pending_age_ms"`
+	DeadLetterRatePerMin float64  `json:"dead_letter_rate_per_min"`
+	Reasons              []string `json:"reasons,omitempty"`
+}
+
+// EvaluateHealth computes the webhook health status from a snapshot.
+// The status is the worst of the individual checks, and Reasons lists every check that tripped.
+func EvaluateHealth(snap HealthSnapshot, th HealthThresholds) HealthReport {
+	report := HealthReport{
+		Status:               HealthOK,
+		HealthSnapshot:       snap,
+		WorkerUtilization:    ratio(snap.WorkersBusy, snap.WorkersTotal),
+		QueueUtilization:     ratio(snap.QueueDepth, snap.QueueCapacity),
+		OldestPendingAgeMs:   snap.OldestPendingAge.Milliseconds(),
+		DeadLetterRatePerMin: snap.DeadLetterRate(),
+	}
+
+	trip := func(status HealthStatus, reason string) {
+		if status == HealthFailing || report.Status == HealthOK {
+			report.Status = status
+		}
+		report.Reasons = append(report.Reasons, reason)
+	}
+
+	if snap.QueueCapacity > 0 {
+		switch {
+		case th.QueueFailingRatio > 0 && report.QueueUtilization >= th.QueueFailingRatio:
+			trip(HealthFailing, fmt.Sprintf("queue %d/%d at or above failing ratio %.2f", snap.QueueDepth, snap.QueueCapacity, th.QueueFailingRatio))
+		case th.QueueDegradedRatio > 0 && report.QueueUtilization >= th.QueueDegradedRatio:
+			trip(HealthDegraded, fmt.Sprintf("queue %d/%d at or above degraded ratio %.2f", snap.QueueDepth, snap.QueueCapacity, th.QueueDegradedRatio))
+		}
+	}
+
+	switch {
+	case th.PendingAgeFailing > 0 && snap.OldestPendingAge >= th.PendingAgeFailing:
+		trip(HealthFailing, fmt.Sprintf("oldest pending delivery is %s old (failing at %s)", snap.OldestPendingAge.Round(time.Second), th.PendingAgeFailing))
+	case th.PendingAgeDegraded > 0 && snap.OldestPendingAge >= th.PendingAgeDegraded:
+		trip(HealthDegraded, fmt.Sprintf("oldest pending delivery is %s old (degraded at %s)", snap.OldestPendingAge.Round(time.Second), th.PendingAgeDegraded))
+	}
+
+	rate := report.DeadLetterRatePerMin
+	switch {
+	case th.DeadLetterRateFailing > 0 && rate >= th.DeadLetterRateFailing:
+		trip(HealthFailing, fmt.Sprintf("dead letters at %.2f/min (failing at %.2f/min)", rate, th.DeadLetterRateFailing))
+	case th.DeadLetterRateDegraded > 0 && rate >= th.DeadLetterRateDegraded:
+		trip(HealthDegraded, fmt.Sprintf("dead letters at %.2f/min (degraded at %.2f/min)", rate, th.DeadLetterRateDegraded))
+	}
+
+	return report
+}
+
+func ratio(n, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// asyncTracker tracks in-flight async deliveries and recent dead letters.
+// The zero value is ready to use.
+type asyncTracker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	inFlight    map[uint64]time.Time
+	deadLetters []time.Time
+}
+
+// start records a new in-flight delivery and returns its id
+func (t *asyncTracker) start() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.inFlight == nil {
+		t.inFlight = make(map[uint64]time.Time)
+	}
+	t.nextID++
+	t.inFlight[t.nextID] = time.Now()
+	return t.nextID
+}
+
+// finish removes a delivery, recording it as a dead letter if it failed
+func (t *asyncTracker) finish(id uint64, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.inFlight, id)
+	if failed {
+		t.deadLetters = append(t.deadLetters, time.Now())
+	}
+}
+
+// snapshot returns the current in-flight count, oldest in-flight age and recent dead letters
+func (t *asyncTracker) snapshot(now time.Time) (inFlight int, oldest time.Duration, deadLetters int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, started := range t.inFlight {
+		if age := now.Sub(started); age > oldest {
+			oldest = age
+		}
+	}
+
+	// Drop dead letters that have aged out of the window
+	cutoff := now.Add(-deadLetterWindow)
+	i := 0
+	for i < len(t.deadLetters) && t.deadLetters[i].Before(cutoff) {
+		i++
+	}
+	t.deadLetters = t.deadLetters[i:]
+
+	return len(t.inFlight), oldest, len(t.deadLetters)
+}
+
+// HealthSnapshot returns the current webhook delivery load across the outbox workers and
+// the async pool. The queue is the pending outbox plus queued and in-flight async deliveries,
+// and dead letters count both outbox and async deliveries given up on.
+func (s *DeliveryService) HealthSnapshot(ctx context.Context) (HealthSnapshot, error) {
+	now := time.Now()
+	inFlight, oldest, deadLetters := s.async.snapshot(now)
+	snap := HealthSnapshot{
+		WorkersBusy:      int(s.outboxBusy.Load() + s.asyncPool.busy.Load()),
+		WorkersTotal:     max(s.cfg.WebhookWorkers, 1) + max(s.cfg.WebhookAsyncWorkers, 1),
+		QueueDepth:       inFlight,
+		QueueCapacity:    s.cfg.WebhookOutboxCapacity,
+		OldestPendingAge: oldest,
+		DeadLetters:      deadLetters,
+	}
+
+	if snap.QueueCapacity > 0 {
+		snap.QueueCapacity += cap(s.asyncPool.jobs)
+	}
+
+	stats, err := s.queries.GetOutboxStats(ctx, now.Add(-deadLetterWindow))
+	if err != nil {
+		return snap, fmt.Errorf("reading outbox stats: %w", err)
+	}
+	snap.QueueDepth += int(stats.Pending)
+	snap.DeadLetters += int(stats.RecentDeadLetters)
+	if stats.Pending > 0 {
+		snap.OldestPendingAge = max(snap.OldestPendingAge, now.Sub(stats.OldestPendingAt))
+	}
+
+	return snap, nil
+}
+
+// Health evaluates the current webhook delivery load against the configured thresholds.
+// If the outbox can't be read the report is at least degraded.
+func (s *DeliveryService) Health(ctx context.Context) HealthReport {
+	snap, err := s.HealthSnapshot(ctx)
+	report := EvaluateHealth(snap, HealthThresholds{
+		QueueDegradedRatio:     s.cfg.WebhookQueueDegradedRatio,
+		QueueFailingRatio:      s.cfg.WebhookQueueFailingRatio,
+		PendingAgeDegraded:     s.cfg.WebhookPendingAgeDegraded,
+		PendingAgeFailing:      s.cfg.WebhookPendingAgeFailing,
+		DeadLetterRateDegraded: s.cfg.WebhookDeadLetterRateDegraded,
+		DeadLetterRateFailing:  s.cfg.WebhookDeadLetterRateFailing,
+	})
+	if err != nil {
+		if report.Status == HealthOK {
+			report.Status = HealthDegraded
+		}
+		report.Reasons = append(report.Reasons, err.Error())
+	}
+	return report
+}
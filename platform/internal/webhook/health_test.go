@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var testThresholds = HealthThresholds{
+	QueueDegradedRatio:     0.75,
+	QueueFailingRatio:      0.95,
+	PendingAgeDegraded:     time.Minute,
+	PendingAgeFailing:      5 * time.Minute,
+	DeadLetterRateDegraded: 1,
+	DeadLetterRateFailing:  10,
+}
+
+func TestEvaluateHealth(t *testing.T) {
+	tests := []struct {
+		name        string
+		snap        HealthSnapshot
+		want        HealthStatus
+		wantReasons int
+	}{
+		{
+			name: "idle",
+			snap: HealthSnapshot{},
+			want: HealthOK,
+		},
+		{
+			name: "queue below degraded ratio",
+			snap: HealthSnapshot{QueueDepth: 74, QueueCapacity: 100},
+			want: HealthOK,
+		},
+		{
+			name:        "queue at degraded ratio",
+			snap:        HealthSnapshot{QueueDepth: 75, QueueCapacity: 100},
+			want:        HealthDegraded,
+			wantReasons: 1,
+		},
+		{
+			name:        "queue at failing ratio",
+			snap:        HealthSnapshot{QueueDepth: 95, QueueCapacity: 100},
+			want:        HealthFailing,
+			wantReasons: 1,
+		},
+		{
+			name: "unbounded queue never trips the ratio",
+			snap: HealthSnapshot{QueueDepth: 10000},
+			want: HealthOK,
+		},
+		{
+			name:        "old pending delivery",
+			snap:        HealthSnapshot{QueueDepth: 1, OldestPendingAge: 2 * time.Minute},
+			want:        HealthDegraded,
+			wantReasons: 1,
+		},
+		{
+			name:        "very old pending delivery",
+			snap:        HealthSnapshot{QueueDepth: 1, OldestPendingAge: 5 * time.Minute},
+			want:        HealthFailing,
+			wantReasons: 1,
+		},
+		{
+			name:        "dead letters degraded",
+			snap:        HealthSnapshot{DeadLetters: 5}, // 1/min over the window
+			want:        HealthDegraded,
+			wantReasons: 1,
+		},
+		{
+			name:        "dead letters failing",
+			snap:        HealthSnapshot{DeadLetters: 50},
+			want:        HealthFailing,
+			wantReasons: 1,
+		},
+		{
+			name: "failing wins over degraded and all reasons are listed",
+			snap: HealthSnapshot{
+				QueueDepth:       80,
+				QueueCapacity:    100,
+				OldestPendingAge: 10 * time.Minute,
+				DeadLetters:      5,
+			},
+			want:        HealthFailing,
+			wantReasons: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := EvaluateHealth(tt.snap, testThresholds)
+			if report.Status != tt.want {
+				t.Errorf("expected status %s, got %s (reasons: %v)", tt.want, report.Status, report.Reasons)
+			}
+			if len(report.Reasons) != tt.wantReasons {
+				t.Errorf("expected %d reasons, got %v", tt.wantReasons, report.Reasons)
+			}
+		})
+	}
+}
+
+func TestEvaluateHealth_ZeroThresholdsDisableChecks(t *testing.T) {
+	report := EvaluateHealth(HealthSnapshot{
+		QueueDepth:       100,
+		QueueCapacity:    100,
+		OldestPendingAge: time.Hour,
+		DeadLetters:      1000,
+	}, HealthThresholds{})
+
+	if report.Status != HealthOK {
+		t.Errorf("expected ok with no thresholds, got %s: %v", report.Status, report.Reasons)
+	}
+}
+
+func TestHealthReport_JSON(t *testing.T) {
+	report := EvaluateHealth(HealthSnapshot{
+		WorkersBusy:      3,
+		WorkersTotal:     4,
+		QueueDepth:       80,
+		QueueCapacity:    100,
+		OldestPendingAge: 1500 * time.Millisecond,
+		DeadLetters:      10,
+	}, testThresholds)
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	want := map[string]any{
+		"status":                   "degraded",
+		"workers_busy":             float64(3),
+		"workers_total":            float64(4),
+		"worker_utilization":       0.75,
+		"queue_depth":              float64(80),
+		"queue_capacity":           float64(100),
+		"queue_utilization":        0.8,
+		"oldest_pending_age_ms":    float64(1500),
+		"dead_letters":             float64(10),
+		"dead_letter_rate_per_min": float64(2),
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, got[key])
+		}
+	}
+	if reasons, ok := got["reasons"].([]any); !ok || len(reasons) != 2 {
+		t.Errorf("expected 2 reasons, got %v", got["reasons"])
+	}
+
+	// Reasons are omitted when healthy
+	data, _ = json.Marshal(EvaluateHealth(HealthSnapshot{}, testThresholds))
+	var healthy map[string]any
+	_ = json.Unmarshal(data, &healthy)
+	if _, ok := healthy["reasons"]; ok {
+		t.Errorf("expected no reasons when healthy, got %s", data)
+	}
+}
+
+func TestAsyncTracker(t *testing.T) {
+	var tr asyncTracker
+	now := time.Now()
+
+	a := tr.start()
+	b := tr.start()
+	tr.inFlight[a] = now.Add(-30 * time.Second)
+
+	inFlight, oldest, dead := tr.snapshot(now)
+	if inFlight != 2 || oldest < 30*time.Second || dead != 0 {
+		t.Fatalf("unexpected snapshot: inFlight=%d oldest=%s dead=%d", inFlight, oldest, dead)
+	}
+
+	tr.finish(a, true)
+	tr.finish(b, false)
+	// A dead letter from before the window no longer counts
+	tr.deadLetters = append([]time.Time{now.Add(-2 * deadLetterWindow)}, tr.deadLetters...)
+
+	inFlight, oldest, dead = tr.snapshot(time.Now())
+	if inFlight != 0 || oldest != 0 || dead != 1 {
+		t.Errorf("unexpected snapshot after finish: inFlight=%d oldest=%s dead=%d", inFlight, oldest, dead)
+	}
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// testConfig is the config webhook tests start from: each delivery is tried once within 5s,
+// and endpoints' circuits only open after 100 failures
+func testConfig() config.Config {
+	return config.Config{
+		WebhookTimeout:          5 * time.Second,
+		WebhookMaxRetries:       1,
+		WebhookCircuitThreshold: 100,
+		WebhookCircuitTimeout:   time.Minute,
+	}
+}
+
+// newTestService creates a delivery service over querier, a new fakeEventQuerier if nil,
+// configured with testConfig as changed by each of overrides in turn
+func newTestService(querier sqlc.Querier, overrides ...func(cfg *config.Config)) *DeliveryService {
+	cfg := testConfig()
+	for _, override := range overrides {
+		override(&cfg)
+	}
+	if querier == nil {
+		querier = newFakeEventQuerier()
+	}
+	return NewDeliveryServiceWithQuerier(querier, &cfg, zap.NewNop())
+}
+
+// withTestClock makes s read the time from a testClock starting at start
+func withTestClock(s *DeliveryService, start time.Time) *testClock {
+	clock := &testClock{now: start}
+	s.now = clock.Now
+	return clock
+}
+
+// testClock is a settable clock
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeEventQuerier stores webhook events in memory. Unimplemented querier
+// methods panic via the nil embedded interface.
+type fakeEventQuerier struct {
+	sqlc.Querier
+	mu             sync.Mutex
+	events         map[string]map[int64]*sqlc.WebhookEvent
+	attempts       []*sqlc.WebhookDeliveryAttempt
+	attemptBatches int
+}
+
+func newFakeEventQuerier() *fakeEventQuerier {
+	return &fakeEventQuerier{events: make(map[string]map[int64]*sqlc.WebhookEvent)}
+}
+
+func (f *fakeEventQuerier) UpsertWebhookEvent(_ context.Context, arg *sqlc.UpsertWebhookEventParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.events[arg.RequestID] == nil {
+		f.events[arg.RequestID] = make(map[int64]*sqlc.WebhookEvent)
+	}
+	f.events[arg.RequestID][arg.Seq] = &sqlc.WebhookEvent{
+		RequestID: arg.RequestID,
+		Seq:       arg.Seq,
+		EventType: arg.EventType,
+		Payload:   arg.Payload,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (f *fakeEventQuerier) InsertWebhookDeliveryAttempts(_ context.Context, arg []*sqlc.InsertWebhookDeliveryAttemptsParams) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range arg {
+		f.attempts = append(f.attempts, &sqlc.WebhookDeliveryAttempt{
+			ID:         int64(len(f.attempts) + 1),
+			RequestID:  a.RequestID,
+			Seq:        a.Seq,
+			WebhookUrl: a.WebhookUrl,
+			Attempt:    a.Attempt,
+			StatusCode: a.StatusCode,
+			DurationMs: a.DurationMs,
+			Error:      a.Error,
+			CreatedAt:  a.CreatedAt,
+			DeliveryID: a.DeliveryID,
+		})
+	}
+	f.attemptBatches++
+	return int64(len(arg)), nil
+}
+
+func (f *fakeEventQuerier) GetWebhookDeliveryAttemptSummary(_ context.Context, requestID string) (*sqlc.GetWebhookDeliveryAttemptSummaryRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	summary := &sqlc.GetWebhookDeliveryAttemptSummaryRow{}
+	for _, a := range f.attempts {
+		if a.RequestID == requestID {
+			summary.AttemptCount++
+			summary.LastStatus = a.StatusCode.Int32
+		}
+	}
+	return summary, nil
+}
+
+func (f *fakeEventQuerier) ListWebhookDeliveryAttempts(_ context.Context, arg *sqlc.ListWebhookDeliveryAttemptsParams) ([]*sqlc.WebhookDeliveryAttempt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := []*sqlc.WebhookDeliveryAttempt{}
+	for _, a := range f.attempts {
+		if a.RequestID == arg.RequestID && len(items) < int(arg.MaxAttempts) {
+			items = append(items, a)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeEventQuerier) ListWebhookEvents(_ context.Context, arg *sqlc.ListWebhookEventsParams) ([]*sqlc.WebhookEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := []*sqlc.WebhookEvent{}
+	for seq, event := range f.events[arg.RequestID] {
+		if seq >= arg.FromSeq {
+			items = append(items, event)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Seq < items[j].Seq })
+	return items, nil
+}
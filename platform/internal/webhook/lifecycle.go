@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// lifecycleRequestPrefix starts the request ID an agent's lifecycle events are delivered
+// under, as they belong to no request of their own
+const lifecycleRequestPrefix = "lifecycle_"
+
+// DeliverLifecycleEvent queues an agent lifecycle payload for the outbox workers, once for
+// each lifecycle webhook userID registered for all of their agents or for payload's agent.
+// It returns the number of webhooks the payload was queued for; none being registered is not
+// an error.
+func (s *DeliveryService) DeliverLifecycleEvent(ctx context.Context, userID string, payload Payload) (int, error) {
+	hooks, err := s.queries.ListLifecycleWebhooksForAgent(ctx, &sqlc.ListLifecycleWebhooksForAgentParams{
+		UserID:  userID,
+		AgentID: sql.NullString{String: payload.AgentID, Valid: true},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing lifecycle webhooks: %w", err)
+	}
+	if len(hooks) == 0 {
+		return 0, nil
+	}
+
+	// An agent's events share a request, numbered by when they happened, so the outbox
+	// delivers them in order
+	if payload.Timestamp.IsZero() {
+		payload.Timestamp = s.now()
+	}
+	payload.RequestID = LifecycleRequestID(userID, payload.AgentID)
+	payload.Seq = uint64(payload.Timestamp.UnixNano())
+
+	webhookCfg := Config{URL: hooks[0].Url, Secret: hooks[0].Secret.String}
+	for _, hook := range hooks[1:] {
+		webhookCfg.Fanout = append(webhookCfg.Fanout, Endpoint{URL: hook.Url, Secret: hook.Secret.String})
+	}
+	if err := s.Enqueue(ctx, webhookCfg, payload); err != nil {
+		return 0, fmt.Errorf("queueing %s event: %w", payload.EventType, err)
+	}
+	return len(hooks), nil
+}
+
+// LifecycleRequestID returns the request ID the lifecycle events of userID's agent are
+// delivered under
+func LifecycleRequestID(userID, agentID string) string {
+	return lifecycleRequestPrefix + userID + "_" + agentID
+}
@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// MessageRoleAssistant is the role of stored messages the agent sent
+const MessageRoleAssistant = "assistant"
+
+// RecordMessage stores a payload converted from an agent response in the agent's message
+// history, which is read once the agent's pod is gone. Messages are keyed by the agent's
+// session and seq, so a response relayed again, e.g. after a stream was resumed, is stored
+// once.
+func (s *DeliveryService) RecordMessage(ctx context.Context, userID string, payload Payload) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling agent message: %w", err)
+	}
+	if _, err := s.queries.InsertAgentMessage(ctx, &sqlc.InsertAgentMessageParams{
+		UserID:    userID,
+		AgentID:   payload.AgentID,
+		SessionID: payload.SessionID,
+		RequestID: payload.RequestID,
+		Seq:       int64(payload.Seq),
+		Role:      MessageRoleAssistant,
+		Payload:   raw,
+	}); err != nil {
+		return fmt.Errorf("storing agent message: %w", err)
+	}
+	return nil
+}
+
+// ListMessages returns up to limit of an agent's stored messages from fromSeq onwards,
+// oldest first, along with the highest seq stored for the agent
+func (s *DeliveryService) ListMessages(ctx context.Context, userID, agentID string, fromSeq uint64, limit int32) ([]Payload, uint64, error) {
+	rows, err := s.queries.ListAgentMessages(ctx, &sqlc.ListAgentMessagesParams{
+		UserID:  userID,
+		AgentID: agentID,
+		FromSeq: int64(fromSeq),
+		MaxRows: limit,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing agent messages: %w", err)
+	}
+	latest, err := s.queries.GetLatestAgentMessageSeq(ctx, &sqlc.GetLatestAgentMessageSeqParams{
+		UserID:  userID,
+		AgentID: agentID,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("getting latest agent message seq: %w", err)
+	}
+
+	messages := make([]Payload, 0, len(rows))
+	for _, row := range rows {
+		var payload Payload
+		if err := json.Unmarshal(row.Payload, &payload); err != nil {
+			return nil, 0, fmt.Errorf("decoding agent message seq %d: %w", row.Seq, err)
+		}
+		messages = append(messages, payload)
+	}
+	return messages, uint64(max(latest, 0)), nil
+}
+
+// PruneMessages deletes stored agent messages created before the given time, unless before
+// is zero, and then each agent's oldest messages beyond maxPerAgent, unless it is 0
+func (s *DeliveryService) PruneMessages(ctx context.Context, before time.Time, maxPerAgent int64) (int64, error) {
+	var n int64
+	if !before.IsZero() {
+		deleted, err := s.queries.DeleteAgentMessagesBefore(ctx, before)
+		if err != nil {
+			return 0, fmt.Errorf("pruning agent messages: %w", err)
+		}
+		n += deleted
+	}
+	if maxPerAgent > 0 {
+		trimmed, err := s.queries.TrimAgentMessages(ctx, maxPerAgent)
+		if err != nil {
+			return n, fmt.Errorf("trimming agent messages: %w", err)
+		}
+		n += trimmed
+	}
+	return n, nil
+}
+
+// runMessagePruner periodically prunes stored agent messages older than retention, or
+// beyond maxPerAgent per agent, until ctx is done
+func (s *DeliveryService) runMessagePruner(ctx context.Context, retention time.Duration, maxPerAgent int64) {
+	ticker := time.NewTicker(eventPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		var before time.Time
+		if retention > 0 {
+			before = time.Now().Add(-retention)
+		}
+		n, err := s.PruneMessages(ctx, before, maxPerAgent)
+		if err != nil {
+			s.logger.Warn("failed to prune agent messages", zap.Error(err))
+		} else if n > 0 {
+			s.logger.Info("pruned agent messages", zap.Int64("deleted", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeMessageQuerier stores agent messages in memory. Unimplemented querier
+// methods panic via the nil embedded interface.
+type fakeMessageQuerier struct {
+	sqlc.Querier
+	messages []*sqlc.AgentMessage
+	now      time.Time // created_at of the next message
+}
+
+func (f *fakeMessageQuerier) InsertAgentMessage(_ context.Context, arg *sqlc.InsertAgentMessageParams) (int64, error) {
+	for _, m := range f.messages {
+		if m.AgentID == arg.AgentID && m.SessionID == arg.SessionID && m.Seq == arg.Seq {
+			return 0, nil
+		}
+	}
+	f.messages = append(f.messages, &sqlc.AgentMessage{
+		ID:        int64(len(f.messages) + 1),
+		UserID:    arg.UserID,
+		AgentID:   arg.AgentID,
+		SessionID: arg.SessionID,
+		RequestID: arg.RequestID,
+		Seq:       arg.Seq,
+		Role:      arg.Role,
+		Payload:   arg.Payload,
+		CreatedAt: f.now,
+	})
+	return 1, nil
+}
+
+func (f *fakeMessageQuerier) ListAgentMessages(_ context.Context, arg *sqlc.ListAgentMessagesParams) ([]*sqlc.AgentMessage, error) {
+	items := []*sqlc.AgentMessage{}
+	for _, m := range f.messages {
+		if m.UserID == arg.UserID && m.AgentID == arg.AgentID && m.Seq >= arg.FromSeq && len(items) < int(arg.MaxRows) {
+			items = append(items, m)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeMessageQuerier) GetLatestAgentMessageSeq(_ context.Context, arg *sqlc.GetLatestAgentMessageSeqParams) (int64, error) {
+	var latest int64
+	for _, m := range f.messages {
+		if m.UserID == arg.UserID && m.AgentID == arg.AgentID {
+			latest = max(latest, m.Seq)
+		}
+	}
+	return latest, nil
+}
+
+func (f *fakeMessageQuerier) DeleteAgentMessagesBefore(_ context.Context, before time.Time) (int64, error) {
+	kept := f.messages[:0]
+	for _, m := range f.messages {
+		if !m.CreatedAt.Before(before) {
+			kept = append(kept, m)
+		}
+	}
+	n := int64(len(f.messages) - len(kept))
+	f.messages = kept
+	return n, nil
+}
+
+func (f *fakeMessageQuerier) TrimAgentMessages(_ context.Context, maxPerAgent int64) (int64, error) {
+	counts := make(map[string]int64)
+	var kept []*sqlc.AgentMessage
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		m := f.messages[i]
+		if counts[m.AgentID]++; counts[m.AgentID] <= maxPerAgent {
+			kept = append([]*sqlc.AgentMessage{m}, kept...)
+		}
+	}
+	n := int64(len(f.messages) - len(kept))
+	f.messages = kept
+	return n, nil
+}
+
+func messagePayload(agentID string, seq uint64) Payload {
+	return AgentResponseToPayload(&agentv1.AgentResponse{
+		SessionId: "ses-1",
+		Seq:       seq,
+		Payload:   &agentv1.AgentResponse_Event{Event: &agentv1.EventPayload{EventType: "message.updated", EventJson: []byte(`{}`)}},
+	}, agentID, "req-1")
+}
+
+func TestRecordMessage_StoresEachSeqOnce(t *testing.T) {
+	querier := &fakeMessageQuerier{}
+	s := newTestService(querier)
+	ctx := context.Background()
+
+	// Seq 2 is relayed again, as after a stream was caught up
+	for _, seq := range []uint64{1, 2, 2, 3} {
+		if err := s.RecordMessage(ctx, "user1", messagePayload("agent1", seq)); err != nil {
+			t.Fatalf("RecordMessage: %v", err)
+		}
+	}
+
+	messages, latest, err := s.ListMessages(ctx, "user1", "agent1", 2, 10)
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if len(querier.messages) != 3 || latest != 3 {
+		t.Fatalf("expected seqs 1-3 stored once, got %d messages up to seq %d", len(querier.messages), latest)
+	}
+	if len(messages) != 2 || messages[0].Seq != 2 || messages[1].Seq != 3 {
+		t.Fatalf("expected seqs 2 and 3, got %+v", messages)
+	}
+	if m := messages[0]; m.AgentID != "agent1" || m.SessionID != "ses-1" || m.RequestID != "req-1" || m.OpenCodeEventType != "message.updated" {
+		t.Errorf("expected the payload as relayed, got %+v", m)
+	}
+
+	if messages, latest, err := s.ListMessages(ctx, "user2", "agent1", 0, 10); err != nil || len(messages) != 0 || latest != 0 {
+		t.Errorf("expected no messages of another user, got %d up to seq %d (%v)", len(messages), latest, err)
+	}
+}
+
+func TestPruneMessages(t *testing.T) {
+	start := time.Now()
+	querier := &fakeMessageQuerier{now: start.Add(-2 * time.Hour)}
+	s := newTestService(querier)
+	ctx := context.Background()
+
+	record := func(agentID string, seqs ...uint64) {
+		for _, seq := range seqs {
+			if err := s.RecordMessage(ctx, "user1", messagePayload(agentID, seq)); err != nil {
+				t.Fatalf("RecordMessage: %v", err)
+			}
+		}
+	}
+	record("agent1", 1, 2)
+	querier.now = start
+	record("agent1", 3, 4, 5)
+	record("agent2", 1)
+
+	n, err := s.PruneMessages(ctx, start.Add(-time.Hour), 0)
+	if err != nil || n != 2 {
+		t.Fatalf("expected the 2 old messages pruned, got %d (%v)", n, err)
+	}
+
+	n, err = s.PruneMessages(ctx, time.Time{}, 2)
+	if err != nil || n != 1 {
+		t.Fatalf("expected agent1's oldest message trimmed, got %d (%v)", n, err)
+	}
+	messages, _, err := s.ListMessages(ctx, "user1", "agent1", 0, 10)
+	if err != nil || len(messages) != 2 || messages[0].Seq != 4 {
+		t.Errorf("expected agent1's seqs 4 and 5 kept, got %+v (%v)", messages, err)
+	}
+	if messages, _, _ := s.ListMessages(ctx, "user1", "agent2", 0, 10); len(messages) != 1 {
+		t.Errorf("expected agent2's message kept, got %d", len(messages))
+	}
+}
@@ -1,11 +1,15 @@
 package webhook
 
 import (
+	"context"
+	"sync"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
 	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/metrics"
 )
 
 // Module provides webhook components to the fx container
@@ -13,7 +17,89 @@ var Module = fx.Module("webhook",
 	fx.Provide(newDeliveryService),
 )
 
-// newDeliveryService creates a new DeliveryService using configuration from the fx container
-func newDeliveryService(pool *pgxpool.Pool, cfg *config.Config, logger *zap.Logger) *DeliveryService {
-	return NewDeliveryService(pool, cfg, logger)
+// newDeliveryService creates a new DeliveryService using configuration from the fx container.
+// The outbox and async workers run while the app runs, and when event retention or agent
+// message limits are enabled stored events and messages are pruned in the background, as are
+// delivery records once their retention is up. On stop, queued async deliveries are drained
+// until the stop deadline, and then the delivery attempts the workers recorded are stored. Startup fails if the webhook URL policy config is invalid.
+// Delivery attempts and open circuits are exported as metrics.
+func newDeliveryService(lc fx.Lifecycle, pool *pgxpool.Pool, cfg *config.Config, m *metrics.Metrics, logger *zap.Logger) (*DeliveryService, error) {
+	// A deny list that is partly ignored would let deliveries reach blocked networks
+	if _, err := newURLPolicy(cfg); err != nil {
+		return nil, err
+	}
+	s := NewDeliveryService(pool, cfg, logger)
+	s.metrics = m
+	m.ObserveOpenCircuits(s.openCircuitIDs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorderCtx, stopRecorder := context.WithCancel(context.Background())
+	recorderDone := make(chan struct{})
+	var wg sync.WaitGroup
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			s.startAsyncWorkers(cfg.WebhookAsyncWorkers)
+
+			go func() {
+				defer close(recorderDone)
+				s.runAttemptRecorder(recorderCtx)
+			}()
+
+			waitWorkers := s.runOutboxWorkers(ctx, cfg.WebhookWorkers)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				waitWorkers()
+			}()
+
+			if cfg.WebhookEventRetention > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.runEventPruner(ctx, cfg.WebhookEventRetention)
+				}()
+			}
+			if cfg.WebhookDeliveryRetention > 0 || cfg.WebhookFailedDeliveryRetention > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.runDeliveryPurger(ctx, cfg.WebhookPurgeInterval)
+				}()
+			}
+			if cfg.AgentMessageRetention > 0 || cfg.AgentMessageMaxPerAgent > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.runMessagePruner(ctx, cfg.AgentMessageRetention, cfg.AgentMessageMaxPerAgent)
+				}()
+			}
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			if err := s.stopAsyncWorkers(stopCtx); err != nil {
+				logger.Warn("async webhook deliveries cancelled before they finished", zap.Error(err))
+			}
+
+			cancel()
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+			}
+
+			// Stopped last, so it stores the attempts of deliveries that just finished
+			stopRecorder()
+			select {
+			case <-recorderDone:
+			case <-stopCtx.Done():
+			}
+			return nil
+		},
+	})
+
+	return s, nil
 }
@@ -0,0 +1,351 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// Outbox statuses stored in webhook_outbox.status
+const (
+	OutboxStatusPending    = "pending"
+	OutboxStatusDelivered  = "delivered"
+	OutboxStatusDeadLetter = "dead_letter"
+)
+
+// minOutboxLease is the shortest time a claimed outbox event stays invisible to other workers
+const minOutboxLease = 30 * time.Second
+
+// Enqueue records a payload as the latest event of its delivery and queues it for the outbox
+// workers, once per endpoint of webhookCfg, in a single transaction. Once Enqueue returns, the
+// payload is delivered at least once to each endpoint even if the process restarts. Endpoints
+// are delivered to independently, so one that is failing does not hold up the others.
+func (s *DeliveryService) Enqueue(ctx context.Context, webhookCfg Config, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling payload: %w", err)
+	}
+
+	err = s.inTx(ctx, func(q sqlc.Querier) error {
+		if err := q.UpdateDeliverySeq(ctx, &sqlc.UpdateDeliverySeqParams{
+			RequestID:     payload.RequestID,
+			Seq:           int64(payload.Seq),
+			LastEventType: sql.NullString{String: string(payload.EventType), Valid: true},
+		}); err != nil {
+			return fmt.Errorf("updating delivery seq: %w", err)
+		}
+
+		for _, endpoint := range webhookCfg.Endpoints() {
+			var headers []byte
+			if len(endpoint.Headers) > 0 {
+				if headers, err = json.Marshal(endpoint.Headers); err != nil {
+					return fmt.Errorf("marshaling webhook headers: %w", err)
+				}
+			}
+			if err := q.EnqueueOutboxEvent(ctx, &sqlc.EnqueueOutboxEventParams{
+				RequestID:              payload.RequestID,
+				Seq:                    int64(payload.Seq),
+				WebhookUrl:             endpoint.URL,
+				WebhookSecret:          sql.NullString{String: endpoint.Secret, Valid: endpoint.Secret != ""},
+				Payload:                body,
+				WebhookHeaders:         headers,
+				WebhookBearerToken:     sql.NullString{String: endpoint.BearerToken, Valid: endpoint.BearerToken != ""},
+				WebhookSecondarySecret: sql.NullString{String: endpoint.SecondarySecret, Valid: endpoint.SecondarySecret != ""},
+				Ordered:                !webhookCfg.Unordered,
+				SchemaVersion:          webhookCfg.SchemaVersion,
+				RateLimitRps:           webhookCfg.RateLimitRPS,
+				RateBurst:              int32(webhookCfg.RateBurst),
+			}); err != nil {
+				return fmt.Errorf("enqueueing webhook event: %w", err)
+			}
+		}
+
+		// Keep a copy for redelivery alongside the outbox row
+		if err := q.UpsertWebhookEvent(ctx, &sqlc.UpsertWebhookEventParams{
+			RequestID: payload.RequestID,
+			Seq:       int64(payload.Seq),
+			EventType: string(payload.EventType),
+			Payload:   body,
+		}); err != nil {
+			return fmt.Errorf("storing webhook event: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.wakeOutbox()
+	return nil
+}
+
+// DeadLetters returns the most recently abandoned outbox events, newest first
+func (s *DeliveryService) DeadLetters(ctx context.Context, limit int32) ([]*sqlc.WebhookOutbox, error) {
+	events, err := s.queries.ListDeadLetters(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("listing dead letters: %w", err)
+	}
+	return events, nil
+}
+
+// inTx runs fn in a database transaction.
+// Services built with NewDeliveryServiceWithQuerier have no pool and run fn directly on their querier.
+func (s *DeliveryService) inTx(ctx context.Context, fn func(q sqlc.Querier) error) error {
+	if s.pool == nil {
+		return fn(s.queries)
+	}
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return fn(sqlc.New(tx))
+	})
+}
+
+// wakeOutbox nudges an idle outbox worker so new events don't wait for the next poll
+func (s *DeliveryService) wakeOutbox() {
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// outboxLease returns how long a claimed event is hidden from other workers.
+// It must outlast a delivery attempt, or a slow webhook would be sent twice.
+func (s *DeliveryService) outboxLease() time.Duration {
+	return max(2*s.cfg.WebhookTimeout, minOutboxLease)
+}
+
+// runOutboxWorkers starts n workers draining the outbox until ctx is done.
+// The returned function blocks until every worker has exited.
+func (s *DeliveryService) runOutboxWorkers(ctx context.Context, n int) (wait func()) {
+	var wg sync.WaitGroup
+	for range max(n, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runOutboxWorker(ctx)
+		}()
+	}
+	return wg.Wait
+}
+
+// runOutboxWorker claims and delivers outbox events one at a time until ctx is done.
+// When the outbox is empty it sleeps for the poll interval or until an event is enqueued.
+func (s *DeliveryService) runOutboxWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := s.queries.ClaimOutboxEvents(ctx, &sqlc.ClaimOutboxEventsParams{
+			LeaseUntil: time.Now().Add(s.outboxLease()),
+			BatchSize:  1,
+		})
+		if err != nil && ctx.Err() == nil {
+			s.logger.Warn("failed to claim outbox events", zap.Error(err))
+		}
+
+		if len(events) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-s.outboxWake:
+			case <-time.After(s.cfg.WebhookOutboxPollInterval):
+			}
+			continue
+		}
+
+		for _, event := range events {
+			s.outboxBusy.Add(1)
+			s.processOutboxEvent(ctx, event)
+			s.outboxBusy.Add(-1)
+		}
+	}
+}
+
+// processOutboxEvent makes one delivery attempt for a claimed event and records the outcome:
+// delivered, rescheduled with backoff, or dead-lettered after a client error or the last attempt.
+// An event held back by the circuit breaker or the delivery rate limit is released for later
+// without spending an attempt.
+func (s *DeliveryService) processOutboxEvent(ctx context.Context, event *sqlc.WebhookOutbox) {
+	logger := s.logger.With(
+		zap.Int64("outbox_id", event.ID),
+		zap.String("request_id", event.RequestID),
+		zap.Int64("seq", event.Seq),
+		zap.Int32("attempt", event.AttemptCount),
+	)
+
+	var payload Payload
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		s.deadLetter(ctx, logger, event, fmt.Errorf("decoding payload: %w", err))
+		return
+	}
+	webhookCfg := Config{
+		URL:             event.WebhookUrl,
+		Secret:          event.WebhookSecret.String,
+		SecondarySecret: event.WebhookSecondarySecret.String,
+		BearerToken:     event.WebhookBearerToken.String,
+		Unordered:       !event.Ordered,
+		SchemaVersion:   event.SchemaVersion,
+		RateLimitRPS:    event.RateLimitRps,
+		RateBurst:       int(event.RateBurst),
+	}
+	if len(event.WebhookHeaders) > 0 {
+		if err := json.Unmarshal(event.WebhookHeaders, &webhookCfg.Headers); err != nil {
+			s.deadLetter(ctx, logger, event, fmt.Errorf("decoding webhook headers: %w", err))
+			return
+		}
+	}
+
+	// Hold the event until the circuit lets deliveries through, without spending an attempt
+	if retryAt, ok := s.allowDelivery(webhookCfg.URL); !ok {
+		if err := s.queries.ReleaseOutboxEvent(ctx, &sqlc.ReleaseOutboxEventParams{
+			ID:            event.ID,
+			NextAttemptAt: retryAt,
+		}); err != nil {
+			logger.Warn("failed to release outbox event", zap.Error(err))
+		}
+		return
+	}
+
+	// Pace deliveries to the URL, holding the event back without spending an attempt
+	if wait := s.takeDeliveryToken(webhookCfg, bypassesRateLimit(payload)); wait > 0 {
+		if err := s.queries.ReleaseOutboxEvent(ctx, &sqlc.ReleaseOutboxEventParams{
+			ID:            event.ID,
+			NextAttemptAt: s.now().Add(wait),
+		}); err != nil {
+			logger.Warn("failed to release outbox event", zap.Error(err))
+		}
+		return
+	}
+	delivered, coalesced := s.coalesceEvents(ctx, logger, event, webhookCfg, payload)
+
+	result := s.deliverOnce(ctx, webhookCfg, delivered, int(event.AttemptCount))
+	if result.Success {
+		s.recordSuccess(webhookCfg.URL)
+		// Mark the coalesced events first, so none is claimed once the event is delivered
+		if len(coalesced) > 0 {
+			if err := s.queries.MarkOutboxEventsDelivered(ctx, coalesced); err != nil {
+				// They are sent again on their own
+				logger.Warn("failed to mark coalesced outbox events delivered", zap.Error(err))
+			}
+		}
+		if err := s.queries.MarkOutboxDelivered(ctx, event.ID); err != nil {
+			// The lease will expire and the event is sent again
+			logger.Warn("failed to mark outbox event delivered", zap.Error(err))
+		}
+		if err := s.queries.RecordDeliverySuccess(ctx, &sqlc.RecordDeliverySuccessParams{
+			RequestID:  event.RequestID,
+			WebhookUrl: event.WebhookUrl,
+		}); err != nil {
+			logger.Warn("failed to record endpoint delivery", zap.Error(err))
+		}
+		return
+	}
+
+	// Shutting down: leave the event claimed so it is retried once the lease expires
+	if ctx.Err() != nil {
+		return
+	}
+
+	// The destination is refused by policy, retrying cannot help, nor can a placeholder
+	if errors.Is(result.Error, ErrURLRejected) {
+		s.deadLetter(ctx, logger, event, result.Error)
+		return
+	}
+
+	s.recordFailure(webhookCfg.URL, result.Error)
+
+	if !isRetryableStatus(result.StatusCode) {
+		s.giveUp(ctx, logger, event, payload, result.Error)
+		return
+	}
+	if int(event.AttemptCount) >= max(s.cfg.WebhookMaxRetries, 1) {
+		s.giveUp(ctx, logger, event, payload, fmt.Errorf("giving up after %d attempts: %w", event.AttemptCount, result.Error))
+		return
+	}
+
+	delay := s.backoff.delay(int(event.AttemptCount), result.RetryAfter)
+	nextAttempt := s.now().Add(delay)
+	lastError := sql.NullString{String: result.Error.Error(), Valid: true}
+	logger.Debug("rescheduling webhook delivery",
+		zap.Error(result.Error),
+		zap.Duration("delay", delay),
+	)
+	if err := s.queries.RescheduleOutboxEvent(ctx, &sqlc.RescheduleOutboxEventParams{
+		ID:            event.ID,
+		NextAttemptAt: nextAttempt,
+		LastError:     lastError,
+	}); err != nil {
+		logger.Warn("failed to reschedule outbox event", zap.Error(err))
+	}
+	if err := s.queries.RecordDeliveryFailure(ctx, &sqlc.RecordDeliveryFailureParams{
+		RequestID:   event.RequestID,
+		WebhookUrl:  event.WebhookUrl,
+		LastError:   lastError,
+		NextRetryAt: sql.NullTime{Time: nextAttempt, Valid: true},
+	}); err != nil {
+		logger.Warn("failed to record endpoint failure", zap.Error(err))
+	}
+}
+
+// giveUp abandons an outbox event that could not be delivered. An event of an ordered
+// request is replaced by its agent.event_dropped payload (see DroppedEventPayload), queued
+// in its place with fresh attempts, so the request's next event does not follow it
+// unannounced; the original stays stored for redelivery. Anything else, including a
+// placeholder that could not be delivered either, is dead-lettered.
+func (s *DeliveryService) giveUp(ctx context.Context, logger *zap.Logger, event *sqlc.WebhookOutbox, payload Payload, cause error) {
+	if !event.Ordered || payload.EventType == EventTypeEventDropped {
+		s.deadLetter(ctx, logger, event, cause)
+		return
+	}
+	body, err := json.Marshal(DroppedEventPayload(payload))
+	if err != nil {
+		s.deadLetter(ctx, logger, event, cause)
+		return
+	}
+
+	logger.Error("webhook delivery abandoned, delivering agent.event_dropped instead", zap.Error(cause))
+	lastError := sql.NullString{String: cause.Error(), Valid: true}
+	if err := s.queries.ReplaceOutboxPayload(ctx, &sqlc.ReplaceOutboxPayloadParams{
+		ID:        event.ID,
+		Payload:   body,
+		LastError: lastError,
+	}); err != nil {
+		// The lease will expire and the event is given up on again
+		logger.Warn("failed to replace outbox payload", zap.Error(err))
+		return
+	}
+	if err := s.queries.RecordDeliveryFailure(ctx, &sqlc.RecordDeliveryFailureParams{
+		RequestID:   event.RequestID,
+		WebhookUrl:  event.WebhookUrl,
+		LastError:   lastError,
+		NextRetryAt: sql.NullTime{Time: s.now(), Valid: true},
+	}); err != nil {
+		logger.Warn("failed to record endpoint failure", zap.Error(err))
+	}
+	s.wakeOutbox()
+}
+
+// deadLetter gives up on an outbox event, keeping it for inspection, and records the failure
+// against its endpoint of the request
+func (s *DeliveryService) deadLetter(ctx context.Context, logger *zap.Logger, event *sqlc.WebhookOutbox, cause error) {
+	logger.Error("webhook delivery dead-lettered", zap.Error(cause))
+	lastError := sql.NullString{String: cause.Error(), Valid: true}
+	if err := s.queries.DeadLetterOutboxEvent(ctx, &sqlc.DeadLetterOutboxEventParams{
+		ID:        event.ID,
+		LastError: lastError,
+	}); err != nil {
+		logger.Warn("failed to dead-letter outbox event", zap.Error(err))
+	}
+	if err := s.queries.RecordDeliveryFailure(ctx, &sqlc.RecordDeliveryFailureParams{
+		RequestID:  event.RequestID,
+		WebhookUrl: event.WebhookUrl,
+		LastError:  lastError,
+	}); err != nil {
+		logger.Warn("failed to record endpoint failure", zap.Error(err))
+	}
+}
@@ -0,0 +1,711 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeOutboxQuerier keeps the outbox, delivery seqs and per-endpoint delivery records in
+// memory, following the claim semantics of the SQL queries (due, lowest pending seq per
+// request/URL).
+type fakeOutboxQuerier struct {
+	*fakeEventQuerier
+	nextID     int64
+	outbox     map[int64]*sqlc.WebhookOutbox
+	seqs       map[string]int64
+	deliveries []*sqlc.WebhookDelivery
+}
+
+func newFakeOutboxQuerier() *fakeOutboxQuerier {
+	return &fakeOutboxQuerier{
+		fakeEventQuerier: newFakeEventQuerier(),
+		outbox:           make(map[int64]*sqlc.WebhookOutbox),
+		seqs:             make(map[string]int64),
+	}
+}
+
+func (f *fakeOutboxQuerier) CreateWebhookDelivery(_ context.Context, arg *sqlc.CreateWebhookDeliveryParams) (*sqlc.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := &sqlc.WebhookDelivery{
+		RequestID:              arg.RequestID,
+		AgentID:                arg.AgentID,
+		WebhookUrl:             arg.WebhookUrl,
+		WebhookSecretHash:      arg.WebhookSecretHash,
+		IncludeThinking:        arg.IncludeThinking,
+		EndpointIndex:          arg.EndpointIndex,
+		WebhookHeaderNames:     arg.WebhookHeaderNames,
+		WebhookBearerTokenHash: arg.WebhookBearerTokenHash,
+		UserID:                 arg.UserID,
+		Status:                 DeliveryStatusPending,
+	}
+	f.deliveries = append(f.deliveries, d)
+	return d, nil
+}
+
+func (f *fakeOutboxQuerier) ListWebhookDeliveryEndpoints(_ context.Context, requestID string) ([]*sqlc.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := []*sqlc.WebhookDelivery{}
+	for _, d := range f.deliveries {
+		if d.RequestID == requestID {
+			c := *d
+			items = append(items, &c)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].EndpointIndex < items[j].EndpointIndex })
+	return items, nil
+}
+
+// endpoint returns the delivery record of a request endpoint, or nil; f.mu must be held
+func (f *fakeOutboxQuerier) endpoint(requestID, url string) *sqlc.WebhookDelivery {
+	for _, d := range f.deliveries {
+		if d.RequestID == requestID && d.WebhookUrl == url {
+			return d
+		}
+	}
+	return nil
+}
+
+func (f *fakeOutboxQuerier) RecordDeliverySuccess(_ context.Context, arg *sqlc.RecordDeliverySuccessParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d := f.endpoint(arg.RequestID, arg.WebhookUrl); d != nil {
+		d.AttemptCount++
+		d.ConsecutiveFailures = 0
+		d.LastError = sql.NullString{}
+	}
+	return nil
+}
+
+func (f *fakeOutboxQuerier) RecordDeliveryFailure(_ context.Context, arg *sqlc.RecordDeliveryFailureParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d := f.endpoint(arg.RequestID, arg.WebhookUrl); d != nil {
+		d.AttemptCount++
+		d.ConsecutiveFailures++
+		d.LastError = arg.LastError
+		d.NextRetryAt = arg.NextRetryAt
+	}
+	return nil
+}
+
+func (f *fakeOutboxQuerier) UpdateDeliverySeq(_ context.Context, arg *sqlc.UpdateDeliverySeqParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seqs[arg.RequestID] = arg.Seq
+	return nil
+}
+
+func (f *fakeOutboxQuerier) EnqueueOutboxEvent(_ context.Context, arg *sqlc.EnqueueOutboxEventParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, row := range f.outbox {
+		if row.RequestID == arg.RequestID && row.WebhookUrl == arg.WebhookUrl && row.Seq == arg.Seq {
+			return nil
+		}
+	}
+	f.nextID++
+	now := time.Now()
+	f.outbox[f.nextID] = &sqlc.WebhookOutbox{
+		ID:                     f.nextID,
+		RequestID:              arg.RequestID,
+		Seq:                    arg.Seq,
+		WebhookUrl:             arg.WebhookUrl,
+		WebhookSecret:          arg.WebhookSecret,
+		WebhookHeaders:         arg.WebhookHeaders,
+		WebhookBearerToken:     arg.WebhookBearerToken,
+		WebhookSecondarySecret: arg.WebhookSecondarySecret,
+		Ordered:                arg.Ordered,
+		SchemaVersion:          arg.SchemaVersion,
+		RateLimitRps:           arg.RateLimitRps,
+		RateBurst:              arg.RateBurst,
+		Payload:                arg.Payload,
+		Status:                 OutboxStatusPending,
+		NextAttemptAt:          now,
+		CreatedAt:              now,
+		UpdatedAt:              now,
+	}
+	return nil
+}
+
+func (f *fakeOutboxQuerier) ClaimOutboxEvents(_ context.Context, arg *sqlc.ClaimOutboxEventsParams) ([]*sqlc.WebhookOutbox, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := make([]int64, 0, len(f.outbox))
+	for id := range f.outbox {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	claimed := []*sqlc.WebhookOutbox{}
+	now := time.Now()
+	for _, id := range ids {
+		if len(claimed) >= int(arg.BatchSize) {
+			break
+		}
+		row := f.outbox[id]
+		if row.Status != OutboxStatusPending || row.NextAttemptAt.After(now) || (row.Ordered && f.hasEarlierPending(row)) {
+			continue
+		}
+		row.AttemptCount++
+		row.NextAttemptAt = arg.LeaseUntil
+		copied := *row
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+func (f *fakeOutboxQuerier) CountPendingOutboxEventsBefore(_ context.Context, arg *sqlc.CountPendingOutboxEventsBeforeParams) (int32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var pending int32
+	for _, row := range f.outbox {
+		if row.RequestID == arg.RequestID && row.Seq < arg.Seq && row.Status == OutboxStatusPending {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (f *fakeOutboxQuerier) hasEarlierPending(row *sqlc.WebhookOutbox) bool {
+	for _, other := range f.outbox {
+		if other.RequestID == row.RequestID && other.WebhookUrl == row.WebhookUrl &&
+			other.Status == OutboxStatusPending && other.Seq < row.Seq {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeOutboxQuerier) MarkOutboxDelivered(_ context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outbox[id].Status = OutboxStatusDelivered
+	return nil
+}
+
+func (f *fakeOutboxQuerier) MarkOutboxEventsDelivered(_ context.Context, ids []int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range ids {
+		f.outbox[id].Status = OutboxStatusDelivered
+	}
+	return nil
+}
+
+func (f *fakeOutboxQuerier) ListPendingOutboxEventsAfter(_ context.Context, arg *sqlc.ListPendingOutboxEventsAfterParams) ([]*sqlc.WebhookOutbox, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows := []*sqlc.WebhookOutbox{}
+	for _, row := range f.outbox {
+		if row.RequestID == arg.RequestID && row.WebhookUrl == arg.WebhookUrl && row.Seq > arg.Seq && row.Status == OutboxStatusPending {
+			copied := *row
+			rows = append(rows, &copied)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Seq < rows[j].Seq })
+	if len(rows) > int(arg.MaxEvents) {
+		rows = rows[:arg.MaxEvents]
+	}
+	return rows, nil
+}
+
+func (f *fakeOutboxQuerier) RescheduleOutboxEvent(_ context.Context, arg *sqlc.RescheduleOutboxEventParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outbox[arg.ID].NextAttemptAt = arg.NextAttemptAt
+	f.outbox[arg.ID].LastError = arg.LastError
+	return nil
+}
+
+func (f *fakeOutboxQuerier) ReleaseOutboxEvent(_ context.Context, arg *sqlc.ReleaseOutboxEventParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outbox[arg.ID].NextAttemptAt = arg.NextAttemptAt
+	f.outbox[arg.ID].AttemptCount = max(f.outbox[arg.ID].AttemptCount-1, 0)
+	return nil
+}
+
+func (f *fakeOutboxQuerier) ReplaceOutboxPayload(_ context.Context, arg *sqlc.ReplaceOutboxPayloadParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	row := f.outbox[arg.ID]
+	row.Payload = arg.Payload
+	row.AttemptCount = 0
+	row.NextAttemptAt = time.Now()
+	row.LastError = arg.LastError
+	return nil
+}
+
+func (f *fakeOutboxQuerier) DeadLetterOutboxEvent(_ context.Context, arg *sqlc.DeadLetterOutboxEventParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outbox[arg.ID].Status = OutboxStatusDeadLetter
+	f.outbox[arg.ID].LastError = arg.LastError
+	f.outbox[arg.ID].UpdatedAt = time.Now()
+	return nil
+}
+
+func (f *fakeOutboxQuerier) GetOutboxStats(_ context.Context, since time.Time) (*sqlc.GetOutboxStatsRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := &sqlc.GetOutboxStatsRow{OldestPendingAt: time.Now()}
+	for _, row := range f.outbox {
+		switch {
+		case row.Status == OutboxStatusPending:
+			stats.Pending++
+			if row.CreatedAt.Before(stats.OldestPendingAt) {
+				stats.OldestPendingAt = row.CreatedAt
+			}
+		case row.Status == OutboxStatusDeadLetter && !row.UpdatedAt.Before(since):
+			stats.RecentDeadLetters++
+		}
+	}
+	return stats, nil
+}
+
+// row returns a copy of the outbox row for a request seq
+func (f *fakeOutboxQuerier) row(t *testing.T, requestID string, seq int64) sqlc.WebhookOutbox {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, row := range f.outbox {
+		if row.RequestID == requestID && row.Seq == seq {
+			return *row
+		}
+	}
+	t.Fatalf("no outbox row for %s/%d", requestID, seq)
+	return sqlc.WebhookOutbox{}
+}
+
+// makeDue makes every pending event claimable now
+func (f *fakeOutboxQuerier) makeDue() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, row := range f.outbox {
+		row.NextAttemptAt = time.Now()
+	}
+}
+
+// claimAndProcess claims the next due event and makes one delivery attempt
+func claimAndProcess(t *testing.T, s *DeliveryService, querier *fakeOutboxQuerier) {
+	t.Helper()
+	events, err := querier.ClaimOutboxEvents(context.Background(), &sqlc.ClaimOutboxEventsParams{
+		LeaseUntil: time.Now().Add(time.Minute),
+		BatchSize:  1,
+	})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected to claim 1 event, got %d (err %v)", len(events), err)
+	}
+	s.processOutboxEvent(context.Background(), events[0])
+}
+
+// outboxConfig runs the outbox workers, polling every 10ms, and retries deliveries up to
+// maxRetries times
+func outboxConfig(maxRetries int) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WebhookMaxRetries = maxRetries
+		cfg.WebhookRetryBase = time.Second
+		cfg.WebhookRetryMaxDelay = time.Minute
+		cfg.WebhookRetryMultiplier = 2
+		cfg.WebhookWorkers = 4
+		cfg.WebhookOutboxPollInterval = 10 * time.Millisecond
+		cfg.WebhookOutboxCapacity = 100
+		cfg.WebhookAsyncWorkers = 2
+		cfg.WebhookAsyncQueueSize = 10
+	}
+}
+
+func TestEnqueue_RecordsSeqOutboxAndStoredEvent(t *testing.T) {
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+
+	payload := testPayloads("req-1", 4)[0]
+	cfg := Config{URL: "https://example.com/hook", Secret: "whsec"}
+	if err := s.Enqueue(context.Background(), cfg, payload); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	// Enqueueing the same seq again is a no-op
+	if err := s.Enqueue(context.Background(), cfg, payload); err != nil {
+		t.Fatalf("Enqueue again: %v", err)
+	}
+
+	if got := querier.seqs["req-1"]; got != 4 {
+		t.Errorf("expected delivery seq 4, got %d", got)
+	}
+	if len(querier.outbox) != 1 {
+		t.Fatalf("expected 1 outbox row, got %d", len(querier.outbox))
+	}
+	row := querier.row(t, "req-1", 4)
+	if row.Status != OutboxStatusPending || row.WebhookUrl != cfg.URL || row.WebhookSecret.String != "whsec" {
+		t.Errorf("unexpected outbox row: %+v", row)
+	}
+	if stored, err := s.StoredEvents(context.Background(), "req-1", 0); err != nil || len(stored) != 1 {
+		t.Errorf("expected 1 stored event, got %d (err %v)", len(stored), err)
+	}
+}
+
+func TestOutboxWorkers_DeliverEventsEnqueuedBeforeRestart(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	cfg := Config{URL: server.URL, Secret: "whsec"}
+
+	// Events are enqueued by a process that stops before delivering them
+	before := newTestService(querier, outboxConfig(5))
+	for _, payload := range testPayloads("req-1", 1, 2, 3) {
+		if err := before.Enqueue(context.Background(), cfg, payload); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	// A fresh process drains them with several workers
+	after := newTestService(querier, outboxConfig(5))
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := after.runOutboxWorkers(ctx, 4)
+	defer func() {
+		cancel()
+		wait()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	got := received()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 deliveries, got %d", len(got))
+	}
+	for i, w := range got {
+		if w.payload.Seq != uint64(i+1) {
+			t.Errorf("delivery %d: expected seq %d, got %d", i, i+1, w.payload.Seq)
+		}
+		if w.signature == "" {
+			t.Errorf("delivery %d: expected a signature", i)
+		}
+	}
+
+	deadline = time.Now().Add(time.Second)
+	for querier.row(t, "req-1", 3).Status != OutboxStatusDelivered && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for seq := int64(1); seq <= 3; seq++ {
+		if status := querier.row(t, "req-1", seq).Status; status != OutboxStatusDelivered {
+			t.Errorf("seq %d: expected delivered, got %s", seq, status)
+		}
+	}
+}
+
+func TestProcessOutboxEvent_RetriesThenDeadLetters(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusInternalServerError)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(2))
+
+	// Unordered, so the event is dead-lettered rather than replaced by agent.event_dropped
+	if err := s.Enqueue(context.Background(), Config{URL: server.URL, Unordered: true}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	claimAndProcess(t, s, querier)
+	row := querier.row(t, "req-1", 1)
+	if row.Status != OutboxStatusPending || row.AttemptCount != 1 || !row.LastError.Valid {
+		t.Fatalf("expected a pending retry after the first failure, got %+v", row)
+	}
+	if !row.NextAttemptAt.After(time.Now()) {
+		t.Errorf("expected the retry to be scheduled in the future, got %s", row.NextAttemptAt)
+	}
+
+	querier.makeDue()
+	claimAndProcess(t, s, querier)
+	row = querier.row(t, "req-1", 1)
+	if row.Status != OutboxStatusDeadLetter || row.AttemptCount != 2 {
+		t.Errorf("expected a dead letter after 2 attempts, got %+v", row)
+	}
+	if len(received()) != 2 {
+		t.Errorf("expected 2 delivery attempts, got %d", len(received()))
+	}
+
+	snap, err := s.HealthSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("HealthSnapshot: %v", err)
+	}
+	if snap.DeadLetters != 1 || snap.QueueDepth != 0 {
+		t.Errorf("expected 1 dead letter and an empty queue, got %+v", snap)
+	}
+}
+
+func TestProcessOutboxEvent_ClientErrorDeadLettersImmediately(t *testing.T) {
+	server, _ := startWebhookServer(t, http.StatusBadRequest)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+
+	if err := s.Enqueue(context.Background(), Config{URL: server.URL, Unordered: true}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	claimAndProcess(t, s, querier)
+	if row := querier.row(t, "req-1", 1); row.Status != OutboxStatusDeadLetter {
+		t.Errorf("expected a dead letter after a 4xx, got %+v", row)
+	}
+}
+
+func TestProcessOutboxEvent_CircuitOpenReleasesWithoutAttempt(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+	s.cfg.WebhookCircuitThreshold = 1
+	s.recordFailure(server.URL, nil)
+
+	if err := s.Enqueue(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	claimAndProcess(t, s, querier)
+	row := querier.row(t, "req-1", 1)
+	if row.Status != OutboxStatusPending || row.AttemptCount != 0 {
+		t.Errorf("expected the event released without spending an attempt, got %+v", row)
+	}
+	openUntil := s.Circuits()[0].OpenUntil
+	if !row.NextAttemptAt.Equal(openUntil) {
+		t.Errorf("expected next attempt at %s, got %s", openUntil, row.NextAttemptAt)
+	}
+	if len(received()) != 0 {
+		t.Errorf("expected no delivery while the circuit is open, got %d", len(received()))
+	}
+}
+
+func TestHealthSnapshot_CountsOutbox(t *testing.T) {
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+
+	for _, payload := range testPayloads("req-1", 1, 2) {
+		if err := s.Enqueue(context.Background(), Config{URL: "https://example.com/hook"}, payload); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	querier.outbox[1].CreatedAt = time.Now().Add(-2 * time.Minute)
+
+	snap, err := s.HealthSnapshot(context.Background())
+	if err != nil {
+		t.Fatalf("HealthSnapshot: %v", err)
+	}
+	if snap.QueueDepth != 2 || snap.QueueCapacity != 110 || snap.WorkersTotal != 6 {
+		t.Errorf("unexpected snapshot: %+v", snap)
+	}
+	if snap.OldestPendingAge < 2*time.Minute {
+		t.Errorf("expected oldest pending age of at least 2m, got %s", snap.OldestPendingAge)
+	}
+}
+
+func TestOutboxWorkers_FanOutIsolatesFailingEndpoint(t *testing.T) {
+	healthy, healthyReceived := startWebhookServer(t, http.StatusOK)
+	failing, failingReceived := startWebhookServer(t, http.StatusInternalServerError)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(1))
+	cfg := Config{URL: healthy.URL, Fanout: []Endpoint{{URL: failing.URL, Secret: "audit"}}}
+
+	if err := s.CreateDeliveryRecord(context.Background(), "req-1", "user-1", "agent-1", cfg); err != nil {
+		t.Fatalf("CreateDeliveryRecord: %v", err)
+	}
+	for _, payload := range testPayloads("req-1", 1, 2, 3) {
+		if err := s.Enqueue(context.Background(), cfg, payload); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.runOutboxWorkers(ctx, 4)
+	defer func() {
+		cancel()
+		wait()
+	}()
+	// The failing endpoint is sent each event, then the agent.event_dropped replacing it
+	waitFor(t, "both endpoints to receive every event", func() bool {
+		return len(healthyReceived()) == 3 && len(failingReceived()) == 6
+	})
+
+	// The healthy endpoint gets every event in order despite the other one failing
+	for i, w := range healthyReceived() {
+		if w.payload.Seq != uint64(i+1) {
+			t.Errorf("delivery %d: expected seq %d, got %d", i, i+1, w.payload.Seq)
+		}
+	}
+	for i, w := range failingReceived() {
+		if w.signature == "" {
+			t.Error("expected deliveries to the fan-out endpoint to be signed with its secret")
+		}
+		wantType := EventTypeEvent
+		if i%2 == 1 {
+			wantType = EventTypeEventDropped
+		}
+		if w.payload.Seq != uint64(i/2+1) || w.payload.EventType != wantType {
+			t.Errorf("failing endpoint delivery %d: got %s seq %d, want %s seq %d", i, w.payload.EventType, w.payload.Seq, wantType, i/2+1)
+		}
+	}
+
+	waitFor(t, "the failing endpoint's events to be dead-lettered", func() bool {
+		snap, err := querier.GetOutboxStats(context.Background(), time.Time{})
+		return err == nil && snap.RecentDeadLetters == 3 && snap.Pending == 0
+	})
+	endpoints, err := s.ListDeliveryEndpoints(context.Background(), "req-1")
+	if err != nil || len(endpoints) != 2 {
+		t.Fatalf("expected 2 endpoint records, got %d (err %v)", len(endpoints), err)
+	}
+	if ok := endpoints[0]; ok.WebhookUrl != healthy.URL || ok.EndpointIndex != 0 || ok.AttemptCount != 3 || ok.ConsecutiveFailures != 0 {
+		t.Errorf("unexpected healthy endpoint record %+v", ok)
+	}
+	if bad := endpoints[1]; bad.WebhookUrl != failing.URL || bad.EndpointIndex != 1 || bad.ConsecutiveFailures != 6 || !bad.LastError.Valid {
+		t.Errorf("unexpected failing endpoint record %+v", bad)
+	}
+}
+
+func TestDeliver_FanOutFailsOnlyWhenEveryEndpointFails(t *testing.T) {
+	healthy, healthyReceived := startWebhookServer(t, http.StatusOK)
+	failing, failingReceived := startWebhookServer(t, http.StatusInternalServerError)
+	s := newTestService(newFakeOutboxQuerier(), outboxConfig(1))
+
+	cfg := Config{URL: failing.URL, Fanout: []Endpoint{{URL: healthy.URL}}}
+	if err := s.Deliver(context.Background(), cfg, testPayloads("req-1", 1)[0]); err != nil {
+		t.Errorf("expected delivery to succeed while one endpoint is healthy, got %v", err)
+	}
+	if len(healthyReceived()) != 1 || len(failingReceived()) != 1 {
+		t.Errorf("expected both endpoints to be tried, got %d and %d", len(healthyReceived()), len(failingReceived()))
+	}
+
+	cfg = Config{URL: failing.URL, Fanout: []Endpoint{{URL: failing.URL + "/other"}}}
+	if err := s.Deliver(context.Background(), cfg, testPayloads("req-1", 2)[0]); err == nil {
+		t.Error("expected an error when every endpoint fails")
+	}
+}
+
+// startFailingSeqServer starts a webhook server refusing every agent.event with seq failSeq
+// with a 500 and accepting everything else, returning the payloads it accepted in order.
+// If set, the refusal waits for hold to close, so a test can see what is delivered meanwhile.
+func startFailingSeqServer(t *testing.T, failSeq uint64, hold <-chan struct{}) (*httptest.Server, func() []Payload) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		accepted []Payload
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload Payload
+		_ = json.Unmarshal(body, &payload)
+		if payload.EventType == EventTypeEvent && payload.Seq == failSeq {
+			if hold != nil {
+				select {
+				case <-hold:
+				case <-time.After(5 * time.Second):
+				}
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		accepted = append(accepted, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []Payload {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Payload(nil), accepted...)
+	}
+}
+
+func TestOutboxWorkers_OrderedReplacesFailedEventWithDropped(t *testing.T) {
+	server, accepted := startFailingSeqServer(t, 2, nil)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(1))
+	for _, payload := range testPayloads("req-1", 1, 2, 3) {
+		if err := s.Enqueue(context.Background(), Config{URL: server.URL}, payload); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.runOutboxWorkers(ctx, 4)
+	defer func() {
+		cancel()
+		wait()
+	}()
+	waitFor(t, "every seq to be accepted", func() bool { return len(accepted()) == 3 })
+
+	// The consumer sees seq 2 as dropped, before seq 3
+	got := accepted()
+	want := []EventType{EventTypeEvent, EventTypeEventDropped, EventTypeEvent}
+	for i, p := range got {
+		if p.Seq != uint64(i+1) || p.EventType != want[i] {
+			t.Errorf("delivery %d: got %s seq %d, want %s seq %d", i, p.EventType, p.Seq, want[i], i+1)
+		}
+		if p.DeliveryAttempt != 0 {
+			t.Errorf("delivery %d: ordered payloads carry no delivery_attempt, got %d", i, p.DeliveryAttempt)
+		}
+	}
+	dropped := got[1]
+	if dropped.DroppedEventType != EventTypeEvent || dropped.Error == nil || dropped.Error.Code != ErrorCodeEventDropped || !dropped.Error.Recoverable {
+		t.Errorf("unexpected agent.event_dropped payload %+v", dropped)
+	}
+	if row := querier.row(t, "req-1", 2); row.Status != OutboxStatusDelivered {
+		t.Errorf("expected the placeholder of seq 2 delivered, got %s", row.Status)
+	}
+}
+
+func TestOutboxWorkers_UnorderedDeliversPastFailingEvent(t *testing.T) {
+	hold := make(chan struct{})
+	server, accepted := startFailingSeqServer(t, 2, hold)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(1))
+	cfg := Config{URL: server.URL, Unordered: true}
+	for _, payload := range testPayloads("req-1", 1, 2, 3) {
+		if err := s.Enqueue(context.Background(), cfg, payload); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.runOutboxWorkers(ctx, 4)
+	defer func() {
+		cancel()
+		wait()
+	}()
+
+	// Seq 3 is delivered while seq 2 is still in flight
+	waitFor(t, "seqs 1 and 3 to be accepted", func() bool { return len(accepted()) == 2 })
+	close(hold)
+	waitFor(t, "seq 2 to be dead-lettered", func() bool {
+		querier.mu.Lock()
+		defer querier.mu.Unlock()
+		for _, row := range querier.outbox {
+			if row.Seq == 2 {
+				return row.Status == OutboxStatusDeadLetter
+			}
+		}
+		return false
+	})
+
+	seqs := map[uint64]bool{}
+	for _, p := range accepted() {
+		seqs[p.Seq] = true
+		if p.EventType != EventTypeEvent || p.DeliveryAttempt != 1 {
+			t.Errorf("seq %d: got %s with delivery_attempt %d, want agent.event with 1", p.Seq, p.EventType, p.DeliveryAttempt)
+		}
+	}
+	if !seqs[1] || !seqs[3] {
+		t.Errorf("expected seqs 1 and 3 accepted, got %v", seqs)
+	}
+}
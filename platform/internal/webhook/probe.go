@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrorCodeProbeFailed is the API error code for a webhook URL refused because a recent
+// probe of it failed (see WebhookProbeStrict)
+const ErrorCodeProbeFailed = "WEBHOOK_PROBE_FAILED"
+
+// EventTypePing is the event type of the signed ping a probe sends. It is only sent by
+// probes and never appears in a request's events.
+const EventTypePing EventType = "webhook.ping"
+
+// ErrProbeFailed is returned by CheckProbe for a URL whose recent probe failed when probes
+// are strict
+var ErrProbeFailed = errors.New("webhook URL failed a recent probe")
+
+// maxProbeEntries bounds the probe cache; past it, expired entries are dropped
+const maxProbeEntries = 10000
+
+// probeEntry is the latest probe of one webhook URL
+type probeEntry struct {
+	checkedAt time.Time
+	err       error // nil if the probe passed
+	running   bool
+}
+
+// probeCache remembers how the latest probe of each webhook URL went
+type probeCache struct {
+	mu      sync.Mutex
+	entries map[string]*probeEntry
+	wg      sync.WaitGroup // probes running in the background
+}
+
+// CheckProbe reports a failed probe of webhookCfg's URL made within WebhookProbeTTL. If the
+// URL has no probe that recent, one is started in the background, and its result applies
+// to later requests using the URL. A recent failure is returned as a warning, or as an
+// error wrapping ErrProbeFailed when WebhookProbeStrict is set. Probing is off when
+// WebhookProbeTTL is 0.
+func (s *DeliveryService) CheckProbe(webhookCfg Config) (warning string, err error) {
+	ttl := s.cfg.WebhookProbeTTL
+	if ttl <= 0 {
+		return "", nil
+	}
+	now := s.now()
+
+	s.probes.mu.Lock()
+	if s.probes.entries == nil {
+		s.probes.entries = make(map[string]*probeEntry)
+	}
+	entry, ok := s.probes.entries[webhookCfg.URL]
+	fresh := ok && !entry.checkedAt.IsZero() && now.Sub(entry.checkedAt) < ttl
+	if !fresh && (!ok || !entry.running) {
+		if !ok && len(s.probes.entries) >= maxProbeEntries {
+			s.probes.dropExpired(now, ttl)
+		}
+		if !ok {
+			entry = &probeEntry{}
+			s.probes.entries[webhookCfg.URL] = entry
+		}
+		entry.running = true
+		s.probes.wg.Add(1)
+		go s.runProbe(webhookCfg, entry)
+	}
+	var probeErr error
+	var checkedAt time.Time
+	if fresh {
+		probeErr, checkedAt = entry.err, entry.checkedAt
+	}
+	s.probes.mu.Unlock()
+
+	if probeErr == nil {
+		return "", nil
+	}
+	msg := fmt.Sprintf("webhook %s failed a probe %s ago: %s",
+		RedactURL(webhookCfg.URL), now.Sub(checkedAt).Truncate(time.Second), s.RedactError(webhookCfg.URL, probeErr.Error()))
+	if s.cfg.WebhookProbeStrict {
+		return "", fmt.Errorf("%w: %s", ErrProbeFailed, msg)
+	}
+	return msg, nil
+}
+
+// dropExpired removes entries whose probe expired and is not running. The caller holds mu.
+func (c *probeCache) dropExpired(now time.Time, ttl time.Duration) {
+	for url, entry := range c.entries {
+		if !entry.running && now.Sub(entry.checkedAt) >= ttl {
+			delete(c.entries, url)
+		}
+	}
+}
+
+// runProbe probes webhookCfg's URL and records the result in entry
+func (s *DeliveryService) runProbe(webhookCfg Config, entry *probeEntry) {
+	defer s.probes.wg.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.WebhookTimeout)
+	defer cancel()
+
+	err := s.probe(ctx, webhookCfg)
+	if err != nil {
+		s.logger.Info("webhook probe failed",
+			zap.String("webhook_url", RedactURL(webhookCfg.URL)),
+			zap.String("error", s.RedactError(webhookCfg.URL, err.Error())),
+		)
+	}
+
+	s.probes.mu.Lock()
+	defer s.probes.mu.Unlock()
+	entry.checkedAt = s.now()
+	entry.err = err
+	entry.running = false
+}
+
+// probe checks that webhookCfg's URL can be reached: its host resolves, accepts a
+// connection and, for https, completes a TLS handshake. With WebhookProbePing it is also
+// sent a signed ping, which fails the probe if it gets no response or a 5xx.
+func (s *DeliveryService) probe(ctx context.Context, webhookCfg Config) error {
+	u, err := url.Parse(webhookCfg.URL)
+	if err != nil {
+		return fmt.Errorf("parsing URL: %w", err)
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	if _, err := netip.ParseAddr(host); err != nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return fmt.Errorf("resolving host: %w", err)
+		}
+	}
+
+	dialer := &net.Dialer{}
+	dial := dialer.DialContext
+	if s.urlPolicy.checksAddresses() {
+		dial = s.urlPolicy.dialContext(dialer)
+	}
+	conn, err := dial(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close()
+
+	if u.Scheme == "https" {
+		tlsCfg := &tls.Config{}
+		if s.probeTLSConfig != nil {
+			tlsCfg = s.probeTLSConfig.Clone()
+		}
+		tlsCfg.ServerName = host
+		if err := tls.Client(conn, tlsCfg).HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("TLS handshake: %w", err)
+		}
+	}
+
+	if !s.cfg.WebhookProbePing {
+		return nil
+	}
+	result := s.send(ctx, webhookCfg, Payload{EventType: EventTypePing, Timestamp: s.now().UTC()})
+	if result.Error != nil && (result.StatusCode == 0 || result.StatusCode >= 500) {
+		return fmt.Errorf("ping: %w", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// closedURL returns a URL on a local port nothing listens on
+func closedURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return "http://" + addr + "/hook"
+}
+
+// probeConfig times probes out after 2s and trusts their results for a minute
+func probeConfig(cfg *config.Config) {
+	cfg.WebhookTimeout = 2 * time.Second
+	cfg.WebhookProbeTTL = time.Minute
+}
+
+func TestCheckProbe_HealthyURLHasNoWarning(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	s := newTestService(nil, probeConfig)
+	webhookCfg := Config{URL: server.URL + "/hook"}
+
+	for range 2 {
+		warning, err := s.CheckProbe(webhookCfg)
+		if warning != "" || err != nil {
+			t.Fatalf("expected no warning, got %q, %v", warning, err)
+		}
+		s.probes.wg.Wait()
+	}
+	if got := len(received()); got != 0 {
+		t.Errorf("expected no ping without WebhookProbePing, got %d requests", got)
+	}
+}
+
+func TestCheckProbe_WarnsAfterFailedProbe(t *testing.T) {
+	s := newTestService(nil, probeConfig)
+	webhookCfg := Config{URL: closedURL(t)}
+
+	// The first use only starts the probe
+	if warning, err := s.CheckProbe(webhookCfg); warning != "" || err != nil {
+		t.Fatalf("expected no warning before the probe ran, got %q, %v", warning, err)
+	}
+	s.probes.wg.Wait()
+
+	warning, err := s.CheckProbe(webhookCfg)
+	if err != nil {
+		t.Fatalf("expected a warning, not an error: %v", err)
+	}
+	if !strings.Contains(warning, "connecting") {
+		t.Errorf("expected the warning to explain the failure, got %q", warning)
+	}
+	if strings.Contains(warning, "/hook") {
+		t.Errorf("expected the URL redacted, got %q", warning)
+	}
+}
+
+func TestCheckProbe_ReprobesAfterTTL(t *testing.T) {
+	s := newTestService(nil, probeConfig)
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	webhookCfg := Config{URL: closedURL(t)}
+	_, _ = s.CheckProbe(webhookCfg)
+	s.probes.wg.Wait()
+
+	now = now.Add(30 * time.Second)
+	if warning, _ := s.CheckProbe(webhookCfg); warning == "" {
+		t.Fatal("expected the failure reported within the TTL")
+	}
+
+	// Once the probe expires the failure is no longer reported, and the URL is probed again
+	now = now.Add(time.Minute)
+	if warning, _ := s.CheckProbe(webhookCfg); warning != "" {
+		t.Fatalf("expected no warning once the probe expired, got %q", warning)
+	}
+	s.probes.wg.Wait()
+	s.probes.mu.Lock()
+	checkedAt := s.probes.entries[webhookCfg.URL].checkedAt
+	s.probes.mu.Unlock()
+	if !checkedAt.Equal(now) {
+		t.Errorf("expected a re-probe at %v, last probe at %v", now, checkedAt)
+	}
+}
+
+func TestCheckProbe_StrictRejects(t *testing.T) {
+	s := newTestService(nil, probeConfig, func(cfg *config.Config) { cfg.WebhookProbeStrict = true })
+	webhookCfg := Config{URL: closedURL(t)}
+
+	_, _ = s.CheckProbe(webhookCfg)
+	s.probes.wg.Wait()
+
+	if _, err := s.CheckProbe(webhookCfg); !errors.Is(err, ErrProbeFailed) {
+		t.Errorf("expected ErrProbeFailed, got %v", err)
+	}
+}
+
+func TestCheckProbe_TLSHandshakeFailure(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	s := newTestService(nil, probeConfig)
+	webhookCfg := Config{URL: server.URL + "/hook"}
+
+	_, _ = s.CheckProbe(webhookCfg)
+	s.probes.wg.Wait()
+
+	// The test server's certificate is self-signed
+	if warning, _ := s.CheckProbe(webhookCfg); !strings.Contains(warning, "TLS handshake") {
+		t.Errorf("expected a TLS handshake warning, got %q", warning)
+	}
+
+	trusted := newTestService(nil, probeConfig)
+	trusted.probeTLSConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	_, _ = trusted.CheckProbe(webhookCfg)
+	trusted.probes.wg.Wait()
+	if warning, _ := trusted.CheckProbe(webhookCfg); warning != "" {
+		t.Errorf("expected no warning once the certificate is trusted, got %q", warning)
+	}
+}
+
+func TestCheckProbe_Ping(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		wantWarn bool
+	}{
+		{"accepted", http.StatusOK, false},
+		{"client error", http.StatusUnauthorized, false},
+		{"server error", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, received := startWebhookServer(t, tt.status)
+			s := newTestService(nil, probeConfig, func(cfg *config.Config) { cfg.WebhookProbePing = true })
+			webhookCfg := Config{URL: server.URL + "/hook", Secret: "ping-secret"}
+
+			_, _ = s.CheckProbe(webhookCfg)
+			s.probes.wg.Wait()
+
+			warning, _ := s.CheckProbe(webhookCfg)
+			if (warning != "") != tt.wantWarn {
+				t.Errorf("expected warning=%v, got %q", tt.wantWarn, warning)
+			}
+			got := received()
+			if len(got) != 1 {
+				t.Fatalf("expected one ping, got %d", len(got))
+			}
+			if got[0].payload.EventType != EventTypePing || got[0].signature == "" {
+				t.Errorf("expected a signed %s event, got %+v", EventTypePing, got[0].payload)
+			}
+		})
+	}
+}
+
+func TestCheckProbe_DisabledWithZeroTTL(t *testing.T) {
+	s := newTestService(nil, probeConfig)
+	s.cfg.WebhookProbeTTL = 0
+
+	if warning, err := s.CheckProbe(Config{URL: closedURL(t)}); warning != "" || err != nil {
+		t.Fatalf("expected no warning, got %q, %v", warning, err)
+	}
+	s.probes.wg.Wait()
+	if len(s.probes.entries) != 0 {
+		t.Errorf("expected no probe, got %d entries", len(s.probes.entries))
+	}
+}
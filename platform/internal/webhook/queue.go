@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// drainTimeout bounds how long a payload that could not be queued waits for the earlier
+// events of its request to leave the outbox before it is delivered directly
+const drainTimeout = 5 * time.Minute
+
+var (
+	// ErrRequestFinalized is returned when a payload is sent after its request's final payload
+	ErrRequestFinalized = errors.New("request already has a final payload")
+	// ErrEarlierEventsPending is returned when a payload cannot be delivered directly because
+	// earlier events of its request are still pending in the outbox
+	ErrEarlierEventsPending = errors.New("earlier events of the request are still pending")
+)
+
+// RequestQueue sends the payloads of one request in seq order, ending with exactly one
+// final payload: no IsFinal payload is queued or delivered while an earlier event of the
+// request is still pending. Payloads go through the outbox, whose workers only deliver the
+// lowest pending seq of a request per endpoint. A payload that cannot be queued is
+// delivered directly instead, once no earlier event of the request is pending in the
+// outbox. Requests whose config is Unordered give up these guarantees for throughput. A
+// RequestQueue is not safe for concurrent use.
+type RequestQueue struct {
+	s          *DeliveryService
+	webhookCfg Config
+	requestID  string
+
+	lastSeq   uint64
+	queued    bool // an event went through the outbox
+	finalized bool
+}
+
+// NewRequestQueue creates the delivery queue of a request
+func (s *DeliveryService) NewRequestQueue(requestID string, webhookCfg Config) *RequestQueue {
+	return &RequestQueue{s: s, webhookCfg: webhookCfg, requestID: requestID}
+}
+
+// Config returns the webhook config the queue delivers to
+func (q *RequestQueue) Config() Config {
+	return q.webhookCfg
+}
+
+// Skip records that the request reached seq without sending anything, e.g. for an event
+// the consumer filtered out
+func (q *RequestQueue) Skip(seq uint64) {
+	q.lastSeq = max(q.lastSeq, seq)
+}
+
+// NextSeq returns the seq of a payload the platform adds after the request's events, such
+// as an error ending the request
+func (q *RequestQueue) NextSeq() uint64 {
+	return q.lastSeq + 1
+}
+
+// Finalized reports whether the request's final payload was sent
+func (q *RequestQueue) Finalized() bool {
+	return q.finalized
+}
+
+// Send queues payload for delivery, or delivers it directly if it cannot be queued (see
+// RequestQueue). A final payload whose seq does not follow every earlier payload is moved
+// after them, and once one was sent, further payloads are refused with
+// ErrRequestFinalized. It returns an error only if the payload reached no endpoint.
+func (q *RequestQueue) Send(ctx context.Context, payload Payload) error {
+	if q.finalized {
+		return ErrRequestFinalized
+	}
+	if payload.IsFinal {
+		if payload.Seq <= q.lastSeq {
+			payload.Seq = q.NextSeq()
+		}
+		q.finalized = true
+	}
+	q.lastSeq = max(q.lastSeq, payload.Seq)
+
+	err := q.s.Enqueue(ctx, q.webhookCfg, payload)
+	if err == nil {
+		q.queued = true
+		return nil
+	}
+	q.s.logger.Error("failed to enqueue webhook, delivering directly",
+		zap.Error(err),
+		zap.String("request_id", q.requestID),
+		zap.Uint64("seq", payload.Seq),
+	)
+
+	// Events sent directly were delivered before Send returned, so only the outbox can
+	// still hold earlier ones
+	if q.queued && !q.webhookCfg.Unordered {
+		drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+		defer cancel()
+		if err := q.s.awaitDrained(drainCtx, q.requestID, payload.Seq); err != nil {
+			return err
+		}
+	}
+	_ = q.s.UpdateDeliverySeq(ctx, q.requestID, int64(payload.Seq), payload.EventType)
+	return q.s.Deliver(ctx, q.webhookCfg, payload)
+}
+
+// awaitDrained waits until no event of requestID before seq is pending in the outbox
+func (s *DeliveryService) awaitDrained(ctx context.Context, requestID string, seq uint64) error {
+	interval := max(s.cfg.WebhookOutboxPollInterval, 10*time.Millisecond)
+	for {
+		pending, err := s.queries.CountPendingOutboxEventsBefore(ctx, &sqlc.CountPendingOutboxEventsBeforeParams{
+			RequestID: requestID,
+			Seq:       int64(seq),
+		})
+		if err != nil {
+			return fmt.Errorf("%w: checking the outbox: %w", ErrEarlierEventsPending, err)
+		}
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %d still queued: %w", ErrEarlierEventsPending, pending, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
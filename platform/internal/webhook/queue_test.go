@@ -0,0 +1,240 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// flakyOutboxQuerier fails to enqueue the given seqs
+type flakyOutboxQuerier struct {
+	*fakeOutboxQuerier
+	failSeqs map[int64]bool
+}
+
+func (f *flakyOutboxQuerier) EnqueueOutboxEvent(ctx context.Context, arg *sqlc.EnqueueOutboxEventParams) error {
+	if f.failSeqs[arg.Seq] {
+		return errors.New("outbox unavailable")
+	}
+	return f.fakeOutboxQuerier.EnqueueOutboxEvent(ctx, arg)
+}
+
+// startConsumer serves a webhook consumer that rejects the first failFirst attempts of each
+// seq with a 500, and returns the payloads it accepted, in order
+func startConsumer(t *testing.T, failFirst int) (*httptest.Server, func() []Payload) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		attempts = make(map[uint64]int)
+		accepted []Payload
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload Payload
+		_ = json.Unmarshal(body, &payload)
+
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[payload.Seq]++
+		if attempts[payload.Seq] <= failFirst {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		accepted = append(accepted, payload)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []Payload {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Payload(nil), accepted...)
+	}
+}
+
+// finalPayload returns the final error of a request
+func finalPayload(requestID string, seq uint64) Payload {
+	return ErrorToPayload("agent-1", requestID, seq, "STREAM_ERROR", "boom", false)
+}
+
+// checkEndsWithOneFinal fails unless the seqs received are increasing and exactly one
+// payload, the last, is final
+func checkEndsWithOneFinal(t *testing.T, got []Payload) {
+	t.Helper()
+	if len(got) == 0 {
+		t.Fatal("expected deliveries, got none")
+	}
+	for i, payload := range got {
+		if i > 0 && payload.Seq <= got[i-1].Seq {
+			t.Errorf("delivery %d: seq %d after seq %d", i, payload.Seq, got[i-1].Seq)
+		}
+		if payload.IsFinal != (i == len(got)-1) {
+			t.Errorf("delivery %d (seq %d): expected is_final %v", i, payload.Seq, i == len(got)-1)
+		}
+	}
+}
+
+// queueConfig retries deliveries up to 5 times, 5-20ms apart, and polls the outbox every 10ms
+func queueConfig(cfg *config.Config) {
+	cfg.WebhookMaxRetries = 5
+	cfg.WebhookRetryBase = 5 * time.Millisecond
+	cfg.WebhookRetryMaxDelay = 20 * time.Millisecond
+	cfg.WebhookRetryMultiplier = 2
+	cfg.WebhookOutboxPollInterval = 10 * time.Millisecond
+}
+
+func TestRequestQueue_FinalIsDeliveredLastWhateverFails(t *testing.T) {
+	tests := []struct {
+		name      string
+		failSeqs  []int64 // not enqueued, so delivered directly
+		failFirst int     // attempts the consumer rejects per seq
+	}{
+		{"nothing fails", nil, 0},
+		{"consumer rejects first attempts", nil, 2},
+		{"final not enqueued", []int64{4}, 0},
+		{"final not enqueued behind retries", []int64{4}, 2},
+		{"event not enqueued", []int64{2}, 1},
+		{"nothing enqueued", []int64{1, 2, 3, 4}, 1},
+		{"first event and final not enqueued", []int64{1, 4}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, accepted := startConsumer(t, tt.failFirst)
+			querier := &flakyOutboxQuerier{fakeOutboxQuerier: newFakeOutboxQuerier(), failSeqs: make(map[int64]bool)}
+			for _, seq := range tt.failSeqs {
+				querier.failSeqs[seq] = true
+			}
+			s := newTestService(querier, queueConfig)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			wait := s.runOutboxWorkers(ctx, 4)
+			defer func() {
+				cancel()
+				wait()
+			}()
+
+			queue := s.NewRequestQueue("req-1", Config{URL: server.URL})
+			for _, payload := range testPayloads("req-1", 1, 2, 3) {
+				if err := queue.Send(ctx, payload); err != nil {
+					t.Fatalf("Send seq %d: %v", payload.Seq, err)
+				}
+			}
+			if err := queue.Send(ctx, finalPayload("req-1", queue.NextSeq())); err != nil {
+				t.Fatalf("Send final: %v", err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for len(accepted()) < 4 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			got := accepted()
+			if len(got) != 4 {
+				t.Fatalf("expected 4 deliveries, got %d", len(got))
+			}
+			checkEndsWithOneFinal(t, got)
+		})
+	}
+}
+
+func TestRequestQueue_DirectFinalWaitsForOutbox(t *testing.T) {
+	server, accepted := startConsumer(t, 0)
+	querier := &flakyOutboxQuerier{fakeOutboxQuerier: newFakeOutboxQuerier(), failSeqs: map[int64]bool{3: true}}
+	s := newTestService(querier, queueConfig)
+
+	queue := s.NewRequestQueue("req-1", Config{URL: server.URL})
+	for _, payload := range testPayloads("req-1", 1, 2) {
+		if err := queue.Send(context.Background(), payload); err != nil {
+			t.Fatalf("Send seq %d: %v", payload.Seq, err)
+		}
+	}
+
+	// The final cannot be queued, and no worker drains the events ahead of it yet
+	sent := make(chan error, 1)
+	go func() { sent <- queue.Send(context.Background(), finalPayload("req-1", 3)) }()
+	time.Sleep(50 * time.Millisecond)
+	if got := accepted(); len(got) != 0 {
+		t.Fatalf("expected the final to wait for the outbox, got %d deliveries", len(got))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := s.runOutboxWorkers(ctx, 2)
+	defer func() {
+		cancel()
+		wait()
+	}()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("Send final: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("final was not sent after the outbox drained")
+	}
+	checkEndsWithOneFinal(t, accepted())
+}
+
+func TestRequestQueue_DirectFinalGivesUpWhileEventsPending(t *testing.T) {
+	server, accepted := startConsumer(t, 0)
+	querier := &flakyOutboxQuerier{fakeOutboxQuerier: newFakeOutboxQuerier(), failSeqs: map[int64]bool{2: true}}
+	s := newTestService(querier, queueConfig)
+
+	queue := s.NewRequestQueue("req-1", Config{URL: server.URL})
+	if err := queue.Send(context.Background(), testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Send seq 1: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := queue.Send(ctx, finalPayload("req-1", 2))
+	if !errors.Is(err, ErrEarlierEventsPending) {
+		t.Fatalf("expected ErrEarlierEventsPending, got %v", err)
+	}
+	if got := accepted(); len(got) != 0 {
+		t.Errorf("expected nothing delivered ahead of the pending event, got %d deliveries", len(got))
+	}
+}
+
+func TestRequestQueue_OneFinalAfterEveryEvent(t *testing.T) {
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, queueConfig)
+	queue := s.NewRequestQueue("req-1", Config{URL: "https://example.com/hook"})
+
+	if err := queue.Send(context.Background(), testPayloads("req-1", 2)[0]); err != nil {
+		t.Fatalf("Send seq 2: %v", err)
+	}
+	queue.Skip(5) // filtered out
+	if got := queue.NextSeq(); got != 6 {
+		t.Errorf("expected next seq 6, got %d", got)
+	}
+
+	// A final with a stale seq, like one reported without any, is moved after the events
+	if err := queue.Send(context.Background(), finalPayload("req-1", 0)); err != nil {
+		t.Fatalf("Send final: %v", err)
+	}
+	if !queue.Finalized() {
+		t.Error("expected the queue to be finalized")
+	}
+	if row := querier.row(t, "req-1", 6); row.Status != OutboxStatusPending {
+		t.Errorf("expected the final queued as seq 6, got %+v", row)
+	}
+
+	for _, payload := range []Payload{testPayloads("req-1", 7)[0], finalPayload("req-1", 8)} {
+		if err := queue.Send(context.Background(), payload); !errors.Is(err, ErrRequestFinalized) {
+			t.Errorf("seq %d: expected ErrRequestFinalized, got %v", payload.Seq, err)
+		}
+	}
+	if n := len(querier.outbox); n != 2 {
+		t.Errorf("expected 2 outbox rows, got %d", n)
+	}
+	if got := querier.seqs["req-1"]; got != 6 {
+		t.Errorf("expected delivery seq 6, got %d", got)
+	}
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// maxCoalescedEvents caps the events of one agent.event_batch payload
+const maxCoalescedEvents = 100
+
+// rateBucketSweepSize is the number of buckets above which full (idle) buckets are dropped
+const rateBucketSweepSize = 1024
+
+// rateBucket is the token bucket pacing deliveries to one webhook URL, guarded by
+// DeliveryService.rateMu
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimit returns the delivery rate and burst for webhookCfg; a rate of 0 is unlimited
+func (s *DeliveryService) rateLimit(webhookCfg Config) (rps float64, burst int) {
+	rps, burst = s.cfg.WebhookRateLimitRPS, s.cfg.WebhookRateBurst
+	if webhookCfg.RateLimitRPS > 0 {
+		rps = webhookCfg.RateLimitRPS
+	}
+	if webhookCfg.RateBurst > 0 {
+		burst = webhookCfg.RateBurst
+	}
+	return rps, max(burst, 1)
+}
+
+// bypassesRateLimit reports whether a payload is delivered without waiting for a token:
+// final payloads, results and errors are never held back
+func bypassesRateLimit(payload Payload) bool {
+	return payload.IsFinal || payload.EventType == EventTypeError || payload.EventType == EventTypeResult
+}
+
+// takeDeliveryToken takes a token from the bucket of webhookCfg's URL, returning 0 if the
+// delivery may go ahead now and otherwise how long until a token is available. A forced
+// take always goes ahead, leaving the bucket in debt so the deliveries after it wait longer.
+func (s *DeliveryService) takeDeliveryToken(webhookCfg Config, force bool) time.Duration {
+	rps, burst := s.rateLimit(webhookCfg)
+	if rps <= 0 {
+		return 0
+	}
+
+	s.rateMu.Lock()
+	defer s.rateMu.Unlock()
+
+	now := s.now()
+	bucket, ok := s.rateBuckets[webhookCfg.URL]
+	if !ok {
+		if len(s.rateBuckets) >= rateBucketSweepSize {
+			s.sweepRateBuckets(now, rps, burst)
+		}
+		bucket = &rateBucket{tokens: float64(burst), last: now}
+		s.rateBuckets[webhookCfg.URL] = bucket
+	}
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens = min(float64(burst), bucket.tokens+elapsed.Seconds()*rps)
+		bucket.last = now
+	}
+
+	if bucket.tokens >= 1 || force {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / rps * float64(time.Second))
+}
+
+// sweepRateBuckets drops the buckets that have refilled since their last delivery, which
+// behave exactly like a new bucket. Caller must hold rateMu.
+func (s *DeliveryService) sweepRateBuckets(now time.Time, rps float64, burst int) {
+	for url, bucket := range s.rateBuckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*rps >= float64(burst) {
+			delete(s.rateBuckets, url)
+		}
+	}
+}
+
+// coalesceEvents folds the pending agent.event payloads following a claimed event of an
+// ordered request into one agent.event_batch payload, once the event has been held back
+// longer than WebhookRateMaxDelay. It returns the payload to deliver and the IDs of the
+// outbox events folded into it, which are delivered along with the claimed event. Folding
+// stops at the first event that is not a non-final agent.event, so those are delivered on
+// their own, in order.
+func (s *DeliveryService) coalesceEvents(ctx context.Context, logger *zap.Logger, event *sqlc.WebhookOutbox, webhookCfg Config, payload Payload) (Payload, []int64) {
+	if rps, _ := s.rateLimit(webhookCfg); rps <= 0 || s.cfg.WebhookRateMaxDelay <= 0 {
+		return payload, nil
+	}
+	if !event.Ordered || payload.EventType != EventTypeEvent || bypassesRateLimit(payload) ||
+		s.now().Sub(event.CreatedAt) <= s.cfg.WebhookRateMaxDelay {
+		return payload, nil
+	}
+
+	following, err := s.queries.ListPendingOutboxEventsAfter(ctx, &sqlc.ListPendingOutboxEventsAfterParams{
+		RequestID:  event.RequestID,
+		WebhookUrl: event.WebhookUrl,
+		Seq:        event.Seq,
+		MaxEvents:  maxCoalescedEvents - 1,
+	})
+	if err != nil {
+		logger.Warn("failed to list events to coalesce", zap.Error(err))
+		return payload, nil
+	}
+
+	events := []Payload{payload}
+	var ids []int64
+	for _, next := range following {
+		var p Payload
+		if err := json.Unmarshal(next.Payload, &p); err != nil || p.EventType != EventTypeEvent || bypassesRateLimit(p) {
+			break
+		}
+		events = append(events, p)
+		ids = append(ids, next.ID)
+	}
+	if len(ids) == 0 {
+		return payload, nil
+	}
+	logger.Debug("coalescing rate-limited webhook events", zap.Int("events", len(events)))
+	return EventBatchPayload(events), ids
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// rateMaxDelay holds deliveries over an endpoint's rate limit back for up to maxDelay. The
+// tests' clocks start at the wall-clock time the fake outbox stamps rows with.
+func rateMaxDelay(maxDelay time.Duration) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.WebhookRateMaxDelay = maxDelay
+	}
+}
+
+func TestTakeDeliveryToken(t *testing.T) {
+	s := newTestService(newFakeOutboxQuerier(), outboxConfig(5), rateMaxDelay(0))
+	clock := withTestClock(s, time.Now())
+	s.cfg.WebhookRateLimitRPS = 2
+	s.cfg.WebhookRateBurst = 2
+	cfg := Config{URL: "https://hooks.example.com/a"}
+
+	for i := range 2 {
+		if wait := s.takeDeliveryToken(cfg, false); wait != 0 {
+			t.Fatalf("delivery %d within the burst waited %s", i+1, wait)
+		}
+	}
+	if wait := s.takeDeliveryToken(cfg, false); wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms for a token at 2 rps, got %s", wait)
+	}
+	if wait := s.takeDeliveryToken(Config{URL: "https://hooks.example.com/b"}, false); wait != 0 {
+		t.Errorf("expected another URL to have its own bucket, waited %s", wait)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if wait := s.takeDeliveryToken(cfg, false); wait != 0 {
+		t.Errorf("expected a token after 500ms, waited %s", wait)
+	}
+
+	// A forced take goes into debt, which the next delivery waits off
+	if wait := s.takeDeliveryToken(cfg, true); wait != 0 {
+		t.Errorf("expected a forced take not to wait, got %s", wait)
+	}
+	if wait := s.takeDeliveryToken(cfg, false); wait != time.Second {
+		t.Errorf("expected to wait 1s after a forced take, got %s", wait)
+	}
+
+	s.cfg.WebhookRateLimitRPS = 0
+	if wait := s.takeDeliveryToken(cfg, false); wait != 0 {
+		t.Errorf("expected no wait without a rate limit, got %s", wait)
+	}
+}
+
+func TestRateLimit_ConfigOverrides(t *testing.T) {
+	s := newTestService(newFakeOutboxQuerier(), outboxConfig(5), rateMaxDelay(0))
+	s.cfg.WebhookRateLimitRPS = 2
+	s.cfg.WebhookRateBurst = 10
+
+	if rps, burst := s.rateLimit(Config{}); rps != 2 || burst != 10 {
+		t.Errorf("expected the configured 2 rps, burst 10, got %g, %d", rps, burst)
+	}
+	if rps, burst := s.rateLimit(Config{RateLimitRPS: 50, RateBurst: 3}); rps != 50 || burst != 3 {
+		t.Errorf("expected the overridden 50 rps, burst 3, got %g, %d", rps, burst)
+	}
+}
+
+func TestOutboxWorkers_PaceDeliveriesPerURL(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5), rateMaxDelay(time.Hour))
+	clock := withTestClock(s, time.Now())
+
+	cfg := Config{URL: server.URL, RateLimitRPS: 1, RateBurst: 1}
+	for _, payload := range append(testPayloads("req-1", 1, 2), finalPayload("req-2", 1)) {
+		if err := s.Enqueue(context.Background(), cfg, payload); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	claimAndProcess(t, s, querier)
+	if got := len(received()); got != 1 {
+		t.Fatalf("expected the first event to use the burst, got %d deliveries", got)
+	}
+
+	// The bucket is empty: the next event is held back for a second without spending an attempt
+	claimAndProcess(t, s, querier)
+	row := querier.row(t, "req-1", 2)
+	if len(received()) != 1 || row.Status != OutboxStatusPending {
+		t.Fatalf("expected seq 2 to be held back, got %d deliveries and status %s", len(received()), row.Status)
+	}
+	if !row.NextAttemptAt.Equal(clock.Now().Add(time.Second)) || row.AttemptCount != 0 {
+		t.Errorf("expected seq 2 released until +1s with no attempt spent, got %s (attempts %d)",
+			row.NextAttemptAt.Sub(clock.Now()), row.AttemptCount)
+	}
+
+	// Final payloads are never held back
+	claimAndProcess(t, s, querier)
+	got := received()
+	if len(got) != 2 || got[1].payload.EventType != EventTypeError {
+		t.Fatalf("expected the final payload to be delivered at once, got %d deliveries", len(got))
+	}
+
+	// It spent a token too, so seq 2 goes once both have refilled
+	clock.Advance(2 * time.Second)
+	querier.makeDue()
+	claimAndProcess(t, s, querier)
+	got = received()
+	if len(got) != 3 || got[2].payload.Seq != 2 {
+		t.Fatalf("expected seq 2 to be delivered after 2s, got %d deliveries", len(got))
+	}
+}
+
+func TestOutboxWorkers_CoalesceBackloggedEvents(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5), rateMaxDelay(5*time.Second))
+	clock := withTestClock(s, time.Now())
+
+	cfg := Config{URL: server.URL, RateLimitRPS: 1, RateBurst: 1}
+	for _, payload := range append(testPayloads("req-1", 1, 2, 3, 4), finalPayload("req-1", 5)) {
+		if err := s.Enqueue(context.Background(), cfg, payload); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	// Within the max delay events go one by one
+	claimAndProcess(t, s, querier)
+
+	// Past it, the backlogged agent.events go as one batch, stopping at the final payload
+	clock.Advance(10 * time.Second)
+	claimAndProcess(t, s, querier)
+	got := received()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(got))
+	}
+	batch := got[1].payload
+	if batch.EventType != EventTypeEventBatch || batch.Seq != 4 {
+		t.Fatalf("expected an agent.event_batch with seq 4, got %s with seq %d", batch.EventType, batch.Seq)
+	}
+	var seqs []uint64
+	for _, event := range batch.Events {
+		if event.EventType != EventTypeEvent {
+			t.Errorf("expected only agent.event payloads in the batch, got %s", event.EventType)
+		}
+		seqs = append(seqs, event.Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 2 || seqs[1] != 3 || seqs[2] != 4 {
+		t.Errorf("expected seqs [2 3 4] in order, got %v", seqs)
+	}
+	for _, seq := range []int64{2, 3, 4} {
+		if status := querier.row(t, "req-1", seq).Status; status != OutboxStatusDelivered {
+			t.Errorf("seq %d: status %s, want delivered", seq, status)
+		}
+	}
+
+	// The final payload is delivered on its own
+	claimAndProcess(t, s, querier)
+	got = received()
+	if len(got) != 3 || got[2].payload.EventType != EventTypeError || got[2].payload.Seq != 5 {
+		t.Fatalf("expected the final payload to follow the batch, got %d deliveries", len(got))
+	}
+}
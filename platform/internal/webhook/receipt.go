@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// MaxReceiptRanges is the most seq ranges a single receipt can acknowledge
+const MaxReceiptRanges = 100
+
+// ErrInvalidReceipt is returned for receipts acknowledging seqs that were never delivered
+var ErrInvalidReceipt = errors.New("invalid delivery receipt")
+
+// SeqRange is an inclusive range of event seqs
+type SeqRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// Contains reports whether seq is within the range
+func (r SeqRange) Contains(seq uint64) bool {
+	return seq >= r.From && seq <= r.To
+}
+
+// MergeSeqRanges sorts ranges and coalesces the ones that overlap or are adjacent
+func MergeSeqRanges(ranges []SeqRange) []SeqRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := append([]SeqRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From < sorted[j].From })
+
+	merged := []SeqRange{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.From <= last.To+1 {
+			last.To = max(last.To, r.To)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// ValidateReceipt checks that ranges acknowledge only seqs from 1 to lastSeq, the last seq
+// delivered for the request. Errors wrap ErrInvalidReceipt.
+func ValidateReceipt(ranges []SeqRange, lastSeq uint64) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("%w: at least one range is required", ErrInvalidReceipt)
+	}
+	if len(ranges) > MaxReceiptRanges {
+		return fmt.Errorf("%w: at most %d ranges are allowed", ErrInvalidReceipt, MaxReceiptRanges)
+	}
+	for _, r := range ranges {
+		switch {
+		case r.From < 1 || r.To < r.From:
+			return fmt.Errorf("%w: range %d-%d is empty or starts before seq 1", ErrInvalidReceipt, r.From, r.To)
+		case r.To > lastSeq:
+			return fmt.Errorf("%w: range %d-%d goes past the last delivered seq %d", ErrInvalidReceipt, r.From, r.To, lastSeq)
+		}
+	}
+	return nil
+}
+
+// RecordReceipt stores acknowledged seq ranges for a request and returns all of its
+// acknowledged ranges, merged. Ranges must already be validated (see ValidateReceipt).
+func (s *DeliveryService) RecordReceipt(ctx context.Context, requestID string, ranges []SeqRange) ([]SeqRange, error) {
+	for _, r := range MergeSeqRanges(ranges) {
+		if err := s.queries.InsertDeliveryReceipt(ctx, &sqlc.InsertDeliveryReceiptParams{
+			RequestID: requestID,
+			FromSeq:   int64(r.From),
+			ToSeq:     int64(r.To),
+		}); err != nil {
+			return nil, fmt.Errorf("storing delivery receipt: %w", err)
+		}
+	}
+	return s.AcknowledgedRanges(ctx, requestID)
+}
+
+// AcknowledgedRanges returns the seq ranges the consumer acknowledged for a request, merged
+func (s *DeliveryService) AcknowledgedRanges(ctx context.Context, requestID string) ([]SeqRange, error) {
+	receipts, err := s.queries.ListDeliveryReceipts(ctx, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("listing delivery receipts: %w", err)
+	}
+	ranges := make([]SeqRange, len(receipts))
+	for i, r := range receipts {
+		ranges[i] = SeqRange{From: uint64(r.FromSeq), To: uint64(r.ToSeq)}
+	}
+	return MergeSeqRanges(ranges), nil
+}
+
+// SkipAcknowledged returns the payloads whose seq is in none of the acknowledged ranges,
+// in their original order
+func SkipAcknowledged(payloads []Payload, acked []SeqRange) []Payload {
+	if len(acked) == 0 {
+		return payloads
+	}
+	kept := make([]Payload, 0, len(payloads))
+	for _, payload := range payloads {
+		i := sort.Search(len(acked), func(i int) bool { return acked[i].To >= payload.Seq })
+		if i < len(acked) && acked[i].Contains(payload.Seq) {
+			continue
+		}
+		kept = append(kept, payload)
+	}
+	return kept
+}
+
+// pruneReceipts deletes receipts recorded before the given time
+func (s *DeliveryService) pruneReceipts(ctx context.Context, before time.Time) (int64, error) {
+	n, err := s.queries.DeleteDeliveryReceiptsBefore(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("pruning delivery receipts: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergeSeqRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []SeqRange
+		want []SeqRange
+	}{
+		{"empty", nil, nil},
+		{"single", []SeqRange{{3, 5}}, []SeqRange{{3, 5}}},
+		{"unsorted disjoint", []SeqRange{{8, 9}, {1, 2}}, []SeqRange{{1, 2}, {8, 9}}},
+		{"overlapping", []SeqRange{{1, 5}, {4, 7}}, []SeqRange{{1, 7}}},
+		{"adjacent", []SeqRange{{1, 3}, {4, 4}, {5, 6}}, []SeqRange{{1, 6}}},
+		{"contained", []SeqRange{{1, 10}, {3, 4}, {11, 12}}, []SeqRange{{1, 12}}},
+		{"duplicates", []SeqRange{{2, 2}, {2, 2}, {5, 5}}, []SeqRange{{2, 2}, {5, 5}}},
+	}
+	for _, tt := range tests {
+		if got := MergeSeqRanges(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: MergeSeqRanges(%v) = %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidateReceipt(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []SeqRange
+		valid  bool
+	}{
+		{"within delivered seqs", []SeqRange{{1, 4}, {6, 10}}, true},
+		{"no ranges", nil, false},
+		{"starts at 0", []SeqRange{{0, 3}}, false},
+		{"reversed", []SeqRange{{4, 3}}, false},
+		{"past last seq", []SeqRange{{9, 11}}, false},
+		{"too many ranges", make([]SeqRange, MaxReceiptRanges+1), false},
+	}
+	for _, tt := range tests {
+		err := ValidateReceipt(tt.ranges, 10)
+		if tt.valid && err != nil {
+			t.Errorf("%s: expected valid, got %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidReceipt) {
+			t.Errorf("%s: expected ErrInvalidReceipt, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestSkipAcknowledged(t *testing.T) {
+	payloads := testPayloads("req-1", 1, 2, 3, 4, 5, 6, 7, 8)
+	acked := MergeSeqRanges([]SeqRange{{2, 3}, {6, 7}, {3, 4}})
+
+	var seqs []uint64
+	for _, p := range SkipAcknowledged(payloads, acked) {
+		seqs = append(seqs, p.Seq)
+	}
+	if want := []uint64{1, 5, 8}; !reflect.DeepEqual(seqs, want) {
+		t.Errorf("expected seqs %v to remain, got %v", want, seqs)
+	}
+	if got := SkipAcknowledged(payloads, nil); len(got) != len(payloads) {
+		t.Errorf("expected nothing skipped without receipts, got %d of %d", len(got), len(payloads))
+	}
+}
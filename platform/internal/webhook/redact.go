@@ -0,0 +1,18 @@
+package webhook
+
+import "net/url"
+
+// RedactURL keeps only the scheme and host of a webhook URL, since the
+// path, query and userinfo commonly carry tokens.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "[redacted]"
+	}
+
+	redacted := u.Scheme + "://" + u.Host
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		redacted += "/[redacted]"
+	}
+	return redacted
+}
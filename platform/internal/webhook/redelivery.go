@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// eventPruneInterval is how often stored events older than the retention window are deleted
+const eventPruneInterval = time.Hour
+
+var (
+	// ErrNoStoredEvents is returned when a request has no stored events to replay
+	ErrNoStoredEvents = errors.New("no stored webhook events")
+	// ErrSecretMismatch is returned when a redelivery secret does not match the original
+	ErrSecretMismatch = errors.New("webhook secret does not match the original delivery")
+	// ErrBearerTokenMismatch is returned when a redelivery bearer token does not match the original
+	ErrBearerTokenMismatch = errors.New("webhook bearer token does not match the original delivery")
+)
+
+// PersistEvent stores a payload so it can be redelivered later.
+// Storing the same request_id/seq again replaces the previous payload.
+func (s *DeliveryService) PersistEvent(ctx context.Context, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling payload: %w", err)
+	}
+
+	if err := s.queries.UpsertWebhookEvent(ctx, &sqlc.UpsertWebhookEventParams{
+		RequestID: payload.RequestID,
+		Seq:       int64(payload.Seq),
+		EventType: string(payload.EventType),
+		Payload:   body,
+	}); err != nil {
+		return fmt.Errorf("storing webhook event: %w", err)
+	}
+
+	return nil
+}
+
+// StoredEvents returns the stored payloads for a request with seq >= fromSeq, in seq order.
+// It returns ErrNoStoredEvents if there are none.
+func (s *DeliveryService) StoredEvents(ctx context.Context, requestID string, fromSeq int64) ([]Payload, error) {
+	events, err := s.queries.ListWebhookEvents(ctx, &sqlc.ListWebhookEventsParams{
+		RequestID: requestID,
+		FromSeq:   fromSeq,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing webhook events: %w", err)
+	}
+	if len(events) == 0 {
+		return nil, ErrNoStoredEvents
+	}
+
+	payloads := make([]Payload, 0, len(events))
+	for _, event := range events {
+		var payload Payload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("decoding stored event %d: %w", event.Seq, err)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	return payloads, nil
+}
+
+// RedeliveryConfig resolves the webhook config for replaying a request's events.
+// Only hashes of the original secret and bearer token are stored, so the caller must supply
+// the same ones. An override URL only changes where the events are sent: they are still
+// signed with the original secret, so a replay cannot be used to re-sign them with another.
+// Custom header values are not stored at all and are taken from override, as are a
+// secondary secret to sign with during a secret rotation and the schema version to encode
+// the events in.
+func RedeliveryConfig(delivery *sqlc.WebhookDelivery, override Config) (Config, error) {
+	cfg := Config{
+		URL:             delivery.WebhookUrl,
+		Secret:          override.Secret,
+		SecondarySecret: override.SecondarySecret,
+		Headers:         override.Headers,
+		BearerToken:     override.BearerToken,
+		SchemaVersion:   override.SchemaVersion,
+	}
+	if override.URL != "" {
+		cfg.URL = override.URL
+	}
+
+	if delivery.WebhookSecretHash.Valid && !matchesHash(override.Secret, delivery.WebhookSecretHash.String) {
+		return Config{}, ErrSecretMismatch
+	}
+	if delivery.WebhookBearerTokenHash.Valid && !matchesHash(override.BearerToken, delivery.WebhookBearerTokenHash.String) {
+		return Config{}, ErrBearerTokenMismatch
+	}
+
+	return cfg, nil
+}
+
+// matchesHash reports whether secret hashes to hash (see hashSecret), in constant time
+func matchesHash(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(hash)) == 1
+}
+
+// ReplayEvents re-sends stored payloads in order, stopping at the first payload that
+// cannot be delivered. Each delivery is signed with the current timestamp.
+// It returns the number of payloads delivered.
+func (s *DeliveryService) ReplayEvents(ctx context.Context, webhookCfg Config, payloads []Payload) (int, error) {
+	for i, payload := range payloads {
+		if err := s.deliver(ctx, webhookCfg, payload); err != nil {
+			return i, fmt.Errorf("redelivering seq %d: %w", payload.Seq, err)
+		}
+	}
+	return len(payloads), nil
+}
+
+// PruneEvents deletes stored events created before the given time, along with outbox
+// events that were delivered before it and artifacts, raw responses, receipts and delivery
+// attempts stored before it
+func (s *DeliveryService) PruneEvents(ctx context.Context, before time.Time) (int64, error) {
+	n, err := s.queries.DeleteWebhookEventsBefore(ctx, before)
+	if err != nil {
+		return 0, fmt.Errorf("pruning webhook events: %w", err)
+	}
+
+	delivered, err := s.queries.DeleteDeliveredOutboxEventsBefore(ctx, sql.NullTime{Time: before, Valid: true})
+	if err != nil {
+		return n, fmt.Errorf("pruning delivered outbox events: %w", err)
+	}
+
+	artifacts, err := s.pruneArtifacts(ctx, before)
+	if err != nil {
+		return n + delivered, err
+	}
+
+	archived, err := s.pruneArchive(ctx, before)
+	if err != nil {
+		return n + delivered + artifacts, err
+	}
+
+	receipts, err := s.pruneReceipts(ctx, before)
+	if err != nil {
+		return n + delivered + artifacts + archived, err
+	}
+
+	attempts, err := s.pruneAttempts(ctx, before)
+	if err != nil {
+		return n + delivered + artifacts + archived + receipts, err
+	}
+
+	return n + delivered + artifacts + archived + receipts + attempts, nil
+}
+
+// runEventPruner periodically deletes stored events older than the retention window until ctx is done
+func (s *DeliveryService) runEventPruner(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(eventPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.PruneEvents(ctx, time.Now().Add(-retention))
+		if err != nil {
+			s.logger.Warn("failed to prune webhook events", zap.Error(err))
+		} else if n > 0 {
+			s.logger.Info("pruned webhook events", zap.Int64("deleted", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
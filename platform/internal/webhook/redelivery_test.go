@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// receivedWebhook is a webhook request captured by a test server
+type receivedWebhook struct {
+	payload   Payload
+	body      []byte
+	signature string
+	timestamp string
+	header    http.Header
+}
+
+// startWebhookServer records every webhook it receives and replies with status
+func startWebhookServer(t *testing.T, status int) (*httptest.Server, func() []receivedWebhook) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		received []receivedWebhook
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload Payload
+		_ = json.Unmarshal(body, &payload)
+
+		mu.Lock()
+		received = append(received, receivedWebhook{
+			payload:   payload,
+			body:      body,
+			signature: r.Header.Get("X-Forge-Signature"),
+			timestamp: r.Header.Get("X-Forge-Timestamp"),
+			header:    r.Header.Clone(),
+		})
+		mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []receivedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedWebhook(nil), received...)
+	}
+}
+
+func testPayloads(requestID string, seqs ...uint64) []Payload {
+	payloads := make([]Payload, 0, len(seqs))
+	for _, seq := range seqs {
+		payloads = append(payloads, Payload{
+			EventType: EventTypeEvent,
+			AgentID:   "agent-1",
+			RequestID: requestID,
+			Seq:       seq,
+			Timestamp: time.Now().Add(-time.Hour),
+			Event:     json.RawMessage(`{"type":"message.updated","seq":` + strconv.FormatUint(seq, 10) + `}`),
+		})
+	}
+	return payloads
+}
+
+func TestDeliver_PersistsEventsDroppedByCircuitBreaker(t *testing.T) {
+	querier := newFakeEventQuerier()
+	// The circuit opens after the first failure
+	s := newTestService(querier, circuitConfig(1, time.Minute, 0))
+	down, received := startWebhookServer(t, http.StatusInternalServerError)
+
+	cfg := Config{URL: down.URL}
+	for _, payload := range testPayloads("req-1", 1, 2, 3) {
+		_ = s.Deliver(context.Background(), cfg, payload)
+	}
+
+	// The first failure opens the circuit, so later events never reach the endpoint
+	if got := len(received()); got != 1 {
+		t.Fatalf("expected 1 attempted delivery before the circuit opened, got %d", got)
+	}
+
+	stored, err := s.StoredEvents(context.Background(), "req-1", 0)
+	if err != nil {
+		t.Fatalf("StoredEvents: %v", err)
+	}
+	if len(stored) != 3 {
+		t.Fatalf("expected all 3 events to be stored, got %d", len(stored))
+	}
+}
+
+func TestReplayEvents_DeliversInOrder(t *testing.T) {
+	querier := newFakeEventQuerier()
+	s := newTestService(querier)
+	for _, payload := range testPayloads("req-1", 3, 1, 2) {
+		if err := s.PersistEvent(context.Background(), payload); err != nil {
+			t.Fatalf("PersistEvent: %v", err)
+		}
+	}
+
+	stored, err := s.StoredEvents(context.Background(), "req-1", 0)
+	if err != nil {
+		t.Fatalf("StoredEvents: %v", err)
+	}
+
+	server, received := startWebhookServer(t, http.StatusOK)
+	n, err := s.ReplayEvents(context.Background(), Config{URL: server.URL}, stored)
+	if err != nil {
+		t.Fatalf("ReplayEvents: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 delivered, got %d", n)
+	}
+
+	got := received()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 webhooks, got %d", len(got))
+	}
+	for i, r := range got {
+		if r.payload.Seq != uint64(i+1) {
+			t.Errorf("webhook %d: expected seq %d, got %d", i, i+1, r.payload.Seq)
+		}
+	}
+}
+
+func TestReplayEvents_PartialReplayFromSeq(t *testing.T) {
+	querier := newFakeEventQuerier()
+	s := newTestService(querier)
+	for _, payload := range testPayloads("req-1", 1, 2, 3, 4) {
+		_ = s.PersistEvent(context.Background(), payload)
+	}
+
+	stored, err := s.StoredEvents(context.Background(), "req-1", 3)
+	if err != nil {
+		t.Fatalf("StoredEvents: %v", err)
+	}
+
+	server, received := startWebhookServer(t, http.StatusOK)
+	if _, err := s.ReplayEvents(context.Background(), Config{URL: server.URL}, stored); err != nil {
+		t.Fatalf("ReplayEvents: %v", err)
+	}
+
+	got := received()
+	if len(got) != 2 || got[0].payload.Seq != 3 || got[1].payload.Seq != 4 {
+		t.Fatalf("expected seqs [3 4], got %+v", got)
+	}
+
+	if _, err := s.StoredEvents(context.Background(), "req-1", 5); !errors.Is(err, ErrNoStoredEvents) {
+		t.Errorf("expected ErrNoStoredEvents past the last seq, got %v", err)
+	}
+}
+
+func TestReplayEvents_ResignsWithCurrentTimestamp(t *testing.T) {
+	querier := newFakeEventQuerier()
+	s := newTestService(querier)
+	_ = s.PersistEvent(context.Background(), testPayloads("req-1", 1)[0])
+
+	stored, err := s.StoredEvents(context.Background(), "req-1", 0)
+	if err != nil {
+		t.Fatalf("StoredEvents: %v", err)
+	}
+
+	server, received := startWebhookServer(t, http.StatusOK)
+	secret := "s3cret"
+	before := time.Now().Unix()
+	if _, err := s.ReplayEvents(context.Background(), Config{URL: server.URL, Secret: secret}, stored); err != nil {
+		t.Fatalf("ReplayEvents: %v", err)
+	}
+
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 webhook, got %d", len(got))
+	}
+
+	ts, err := strconv.ParseInt(got[0].timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp header %q: %v", got[0].timestamp, err)
+	}
+	if ts < before || ts > time.Now().Unix() {
+		t.Errorf("expected signature timestamp to be the replay time, got %d", ts)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(got[0].timestamp + "."))
+	mac.Write(got[0].body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got[0].signature != want {
+		t.Errorf("expected signature %s, got %s", want, got[0].signature)
+	}
+}
+
+func TestReplayEvents_StopsAtFirstFailure(t *testing.T) {
+	s := newTestService(newFakeEventQuerier())
+	server, received := startWebhookServer(t, http.StatusBadRequest)
+
+	n, err := s.ReplayEvents(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1, 2))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n != 0 || len(received()) != 1 {
+		t.Errorf("expected replay to stop after the first failure, delivered=%d attempts=%d", n, len(received()))
+	}
+}
+
+func TestRedeliveryConfig(t *testing.T) {
+	hash := sha256.Sum256([]byte("original"))
+	delivery := &sqlc.WebhookDelivery{
+		WebhookUrl:        "https://example.com/hook",
+		WebhookSecretHash: sql.NullString{String: hex.EncodeToString(hash[:]), Valid: true},
+	}
+
+	cfg, err := RedeliveryConfig(delivery, Config{Secret: "original"})
+	if err != nil || cfg.URL != delivery.WebhookUrl || cfg.Secret != "original" {
+		t.Errorf("expected original URL and secret, got %+v, %v", cfg, err)
+	}
+
+	if _, err := RedeliveryConfig(delivery, Config{Secret: "wrong"}); !errors.Is(err, ErrSecretMismatch) {
+		t.Errorf("expected ErrSecretMismatch, got %v", err)
+	}
+	if _, err := RedeliveryConfig(delivery, Config{}); !errors.Is(err, ErrSecretMismatch) {
+		t.Errorf("expected ErrSecretMismatch without a secret, got %v", err)
+	}
+
+	cfg, err = RedeliveryConfig(delivery, Config{URL: "https://other.example.com", Secret: "original"})
+	if err != nil || cfg.URL != "https://other.example.com" || cfg.Secret != "original" {
+		t.Errorf("expected override URL and original secret, got %+v, %v", cfg, err)
+	}
+	if _, err := RedeliveryConfig(delivery, Config{URL: "https://other.example.com", Secret: "new"}); !errors.Is(err, ErrSecretMismatch) {
+		t.Errorf("expected an override URL to still need the original secret, got %v", err)
+	}
+}
+
+func TestRedeliveryConfig_BearerToken(t *testing.T) {
+	delivery := &sqlc.WebhookDelivery{
+		WebhookUrl:             "https://example.com/hook",
+		WebhookBearerTokenHash: sql.NullString{String: hashSecret("token"), Valid: true},
+	}
+	headers := map[string]string{"X-Tenant": "acme"}
+
+	cfg, err := RedeliveryConfig(delivery, Config{BearerToken: "token", Headers: headers})
+	if err != nil || cfg.BearerToken != "token" || cfg.Headers["X-Tenant"] != "acme" {
+		t.Errorf("expected the original token and given headers, got %+v, %v", cfg, err)
+	}
+	if _, err := RedeliveryConfig(delivery, Config{BearerToken: "wrong"}); !errors.Is(err, ErrBearerTokenMismatch) {
+		t.Errorf("expected ErrBearerTokenMismatch, got %v", err)
+	}
+	if _, err := RedeliveryConfig(delivery, Config{URL: "https://other.example.com"}); !errors.Is(err, ErrBearerTokenMismatch) {
+		t.Errorf("expected an override URL to still need the original token, got %v", err)
+	}
+}
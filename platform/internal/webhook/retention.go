@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// PurgeResult counts the delivery records a purge deleted, or would delete in a dry run
+type PurgeResult struct {
+	DryRun    bool  `json:"dry_run"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+}
+
+// PurgeDeliveries deletes the delivery records of requests that completed more than
+// WEBHOOK_DELIVERY_RETENTION ago or failed more than WEBHOOK_FAILED_DELIVERY_RETENTION ago,
+// WEBHOOK_PURGE_BATCH_SIZE rows per statement. A retention of 0 keeps those records. With
+// dryRun set nothing is deleted, and the records that would be are counted instead.
+func (s *DeliveryService) PurgeDeliveries(ctx context.Context, dryRun bool) (PurgeResult, error) {
+	result := PurgeResult{DryRun: dryRun}
+	now := s.now()
+	var err error
+	if s.cfg.WebhookDeliveryRetention > 0 {
+		result.Completed, err = s.purgeDeliveries(ctx, DeliveryStatusCompleted, now.Add(-s.cfg.WebhookDeliveryRetention), dryRun)
+		if err != nil {
+			return result, err
+		}
+	}
+	if s.cfg.WebhookFailedDeliveryRetention > 0 {
+		result.Failed, err = s.purgeDeliveries(ctx, DeliveryStatusFailed, now.Add(-s.cfg.WebhookFailedDeliveryRetention), dryRun)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// purgeDeliveries deletes the delivery records with status last updated before the given
+// time in batches until none are left, or counts them if dryRun is set
+func (s *DeliveryService) purgeDeliveries(ctx context.Context, status string, before time.Time, dryRun bool) (int64, error) {
+	if dryRun {
+		n, err := s.queries.CountDeliveriesBefore(ctx, &sqlc.CountDeliveriesBeforeParams{
+			Status:    status,
+			UpdatedAt: before,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("counting %s deliveries: %w", status, err)
+		}
+		return n, nil
+	}
+
+	batchSize := max(s.cfg.WebhookPurgeBatchSize, 1)
+	var total int64
+	for {
+		n, err := s.queries.DeleteDeliveriesBefore(ctx, &sqlc.DeleteDeliveriesBeforeParams{
+			Status:    status,
+			Before:    before,
+			BatchSize: int32(batchSize),
+		})
+		total += n
+		s.metrics.DeliveriesPurged(status, n)
+		if err != nil {
+			return total, fmt.Errorf("purging %s deliveries: %w", status, err)
+		}
+		if n < int64(batchSize) || ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+	}
+}
+
+// runDeliveryPurger purges expired delivery records every interval until ctx is done
+func (s *DeliveryService) runDeliveryPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := s.PurgeDeliveries(ctx, false)
+		if err != nil && ctx.Err() == nil {
+			s.logger.Warn("failed to purge webhook deliveries", zap.Error(err))
+		} else if result.Completed+result.Failed > 0 {
+			s.logger.Info("purged webhook deliveries",
+				zap.Int64("completed", result.Completed),
+				zap.Int64("failed", result.Failed),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/metrics"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeRetentionQuerier holds delivery records in memory and counts the delete statements
+type fakeRetentionQuerier struct {
+	sqlc.Querier
+	deliveries []*sqlc.WebhookDelivery
+	deletes    int
+}
+
+func (f *fakeRetentionQuerier) seed(status string, age time.Duration, n int) {
+	for range n {
+		f.deliveries = append(f.deliveries, &sqlc.WebhookDelivery{Status: status, UpdatedAt: time.Now().Add(-age)})
+	}
+}
+
+func (f *fakeRetentionQuerier) CountDeliveriesBefore(_ context.Context, arg *sqlc.CountDeliveriesBeforeParams) (int64, error) {
+	var n int64
+	for _, d := range f.deliveries {
+		if d.Status == arg.Status && d.UpdatedAt.Before(arg.UpdatedAt) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeRetentionQuerier) DeleteDeliveriesBefore(_ context.Context, arg *sqlc.DeleteDeliveriesBeforeParams) (int64, error) {
+	f.deletes++
+	var n int64
+	kept := f.deliveries[:0]
+	for _, d := range f.deliveries {
+		if n < int64(arg.BatchSize) && d.Status == arg.Status && d.UpdatedAt.Before(arg.Before) {
+			n++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	f.deliveries = kept
+	return n, nil
+}
+
+func (f *fakeRetentionQuerier) remaining(status string) int {
+	n := 0
+	for _, d := range f.deliveries {
+		if d.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// seedRetention stores completed and failed records on both sides of their retention,
+// and records still in progress that are never purged
+func seedRetention(querier *fakeRetentionQuerier) {
+	querier.seed(DeliveryStatusCompleted, 48*time.Hour, 5)
+	querier.seed(DeliveryStatusCompleted, time.Hour, 1)
+	querier.seed(DeliveryStatusFailed, 96*time.Hour, 2)
+	querier.seed(DeliveryStatusFailed, 48*time.Hour, 1)
+	querier.seed(DeliveryStatusDelivering, 96*time.Hour, 1)
+}
+
+// retentionConfig keeps deliveries for a day, failed ones for three, and purges two at a time
+func retentionConfig(cfg *config.Config) {
+	cfg.WebhookDeliveryRetention = 24 * time.Hour
+	cfg.WebhookFailedDeliveryRetention = 72 * time.Hour
+	cfg.WebhookPurgeBatchSize = 2
+}
+
+func TestPurgeDeliveries_DeletesExpiredInBatches(t *testing.T) {
+	querier := &fakeRetentionQuerier{}
+	seedRetention(querier)
+	s := newTestService(querier, retentionConfig)
+	reg := prometheus.NewRegistry()
+	s.metrics = metrics.New(reg)
+
+	result, err := s.PurgeDeliveries(context.Background(), false)
+	if err != nil {
+		t.Fatalf("PurgeDeliveries: %v", err)
+	}
+	if result != (PurgeResult{Completed: 5, Failed: 2}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	// 5 completed take 3 batches of 2; 2 failed take a full batch and an empty one
+	if querier.deletes != 5 {
+		t.Errorf("expected 5 delete statements, got %d", querier.deletes)
+	}
+	if c, f, p := querier.remaining(DeliveryStatusCompleted), querier.remaining(DeliveryStatusFailed), querier.remaining(DeliveryStatusDelivering); c != 1 || f != 1 || p != 1 {
+		t.Errorf("expected 1 completed, 1 failed and 1 delivering record left, got %d, %d and %d", c, f, p)
+	}
+
+	want := `
+# HELP forge_webhook_deliveries_purged_total Webhook delivery records deleted by retention, by delivery status.
+# TYPE forge_webhook_deliveries_purged_total counter
+forge_webhook_deliveries_purged_total{status="completed"} 5
+forge_webhook_deliveries_purged_total{status="failed"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "forge_webhook_deliveries_purged_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPurgeDeliveries_DryRunOnlyCounts(t *testing.T) {
+	querier := &fakeRetentionQuerier{}
+	seedRetention(querier)
+	s := newTestService(querier, retentionConfig)
+
+	result, err := s.PurgeDeliveries(context.Background(), true)
+	if err != nil {
+		t.Fatalf("PurgeDeliveries: %v", err)
+	}
+	if result != (PurgeResult{DryRun: true, Completed: 5, Failed: 2}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if querier.deletes != 0 || len(querier.deliveries) != 10 {
+		t.Errorf("expected nothing deleted, got %d statements and %d records left", querier.deletes, len(querier.deliveries))
+	}
+}
+
+func TestPurgeDeliveries_ZeroRetentionKeepsRecords(t *testing.T) {
+	querier := &fakeRetentionQuerier{}
+	seedRetention(querier)
+	s := newTestService(querier, retentionConfig)
+	s.cfg.WebhookFailedDeliveryRetention = 0
+
+	result, err := s.PurgeDeliveries(context.Background(), false)
+	if err != nil {
+		t.Fatalf("PurgeDeliveries: %v", err)
+	}
+	if result.Failed != 0 || querier.remaining(DeliveryStatusFailed) != 3 {
+		t.Errorf("expected failed records kept, got result %+v and %d left", result, querier.remaining(DeliveryStatusFailed))
+	}
+	if result.Completed != 5 {
+		t.Errorf("expected 5 completed records purged, got %d", result.Completed)
+	}
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signed deliveries carry two headers:
+//
+//	X-Forge-Timestamp: 1736937000
+//	X-Forge-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// The timestamp is in Unix seconds and the HMAC key is the endpoint's secret. While a
+// secret is being rotated the endpoint has a primary and a secondary secret, and the
+// signature header lists one signature per secret, primary first, separated by commas:
+//
+//	X-Forge-Signature: sha256=<primary>,sha256=<secondary>
+//
+// A delivery is authentic if any listed signature matches a secret the consumer holds,
+// so consumers can switch secrets at any point during the rotation window.
+//
+// Signed deliveries also carry a v2 signature, in the same format, that covers the
+// delivery's X-Forge-Delivery-Id as well:
+//
+//	X-Forge-Signature-V2: sha256=<hex HMAC-SHA256 of "<timestamp>.<delivery id>.<body>">
+//
+// so a captured delivery cannot be replayed under another delivery ID.
+const (
+	SignatureHeader   = "X-Forge-Signature"
+	SignatureV2Header = "X-Forge-Signature-V2"
+	TimestampHeader   = "X-Forge-Timestamp"
+)
+
+// Every delivery, signed or not, identifies itself with three headers. X-Forge-Delivery-Id
+// is a UUID unique to each attempt, retries and redeliveries included; X-Forge-Request-Id
+// and X-Forge-Seq name the event and stay the same across attempts, so consumers can
+// deduplicate on the (request ID, seq) pair.
+const (
+	DeliveryIDHeader = "X-Forge-Delivery-Id"
+	RequestIDHeader  = "X-Forge-Request-Id"
+	SeqHeader        = "X-Forge-Seq"
+)
+
+// MaxClockSkew is how far a delivery's timestamp may be from the verifier's clock
+// before VerifySignature refuses it, limiting how long a captured delivery can be replayed
+const MaxClockSkew = 5 * time.Minute
+
+// signaturePrefix prefixes each signature in the signature header
+const signaturePrefix = "sha256="
+
+// Errors returned by VerifySignature
+var (
+	ErrMalformedSignature = errors.New("malformed webhook signature header")
+	ErrMalformedTimestamp = errors.New("malformed webhook timestamp")
+	ErrTimestampSkew      = errors.New("webhook timestamp outside allowed clock skew")
+)
+
+// computeSignature computes the hex HMAC-SHA256 signature of a delivery
+func computeSignature(timestamp string, body []byte, secret string) string {
+	return hmacHex(secret, body, timestamp)
+}
+
+// computeSignatureV2 computes the hex HMAC-SHA256 v2 signature of a delivery
+func computeSignatureV2(timestamp, deliveryID string, body []byte, secret string) string {
+	return hmacHex(secret, body, timestamp, deliveryID)
+}
+
+// hmacHex returns the hex HMAC-SHA256 of the fields and body, each followed by a "."
+func hmacHex(secret string, body []byte, fields ...string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, field := range fields {
+		mac.Write([]byte(field))
+		mac.Write([]byte("."))
+	}
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signatureHeader returns the signature header value for a delivery signed with each
+// non-empty secret, in order
+func signatureHeader(timestamp string, body []byte, secrets ...string) string {
+	return joinSignatures(func(secret string) string { return computeSignature(timestamp, body, secret) }, secrets)
+}
+
+// signatureV2Header returns the v2 signature header value for a delivery signed with each
+// non-empty secret, in order
+func signatureV2Header(timestamp, deliveryID string, body []byte, secrets ...string) string {
+	return joinSignatures(func(secret string) string { return computeSignatureV2(timestamp, deliveryID, body, secret) }, secrets)
+}
+
+// joinSignatures lists the signatures sign makes with each non-empty secret
+func joinSignatures(sign func(secret string) string, secrets []string) string {
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			signatures = append(signatures, signaturePrefix+sign(secret))
+		}
+	}
+	return strings.Join(signatures, ",")
+}
+
+// VerifySignature checks a delivery's X-Forge-Signature header against secret. timestamp
+// is the X-Forge-Timestamp header and body the raw request body. It returns true if any
+// signature in the header matches, comparing in constant time.
+//
+// An error is returned, along with false, if the timestamp is not Unix seconds
+// (ErrMalformedTimestamp), is more than MaxClockSkew from the current time
+// (ErrTimestampSkew), or the header is not a comma-separated list of "sha256=<hex>"
+// signatures (ErrMalformedSignature).
+func VerifySignature(timestamp string, body []byte, secret, header string) (bool, error) {
+	return verifySignature(timestamp, body, secret, header, time.Now(), MaxClockSkew)
+}
+
+// VerifySignatureV2 checks a delivery's X-Forge-Signature-V2 header against secret, like
+// VerifySignature. deliveryID is the X-Forge-Delivery-Id header, which the v2 signature
+// covers; consumers that also record the delivery IDs they have processed can refuse
+// replays within MaxClockSkew too.
+func VerifySignatureV2(timestamp, deliveryID string, body []byte, secret, header string) (bool, error) {
+	return verifyHeader(timestamp, header, computeSignatureV2(timestamp, deliveryID, body, secret), time.Now(), MaxClockSkew)
+}
+
+// verifySignature is VerifySignature checking the timestamp against now
+func verifySignature(timestamp string, body []byte, secret, header string, now time.Time, maxSkew time.Duration) (bool, error) {
+	return verifyHeader(timestamp, header, computeSignature(timestamp, body, secret), now, maxSkew)
+}
+
+// verifyHeader checks the timestamp against now and whether any signature in header is
+// the expected hex signature
+func verifyHeader(timestamp, header, expectedHex string, now time.Time, maxSkew time.Duration) (bool, error) {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("%w: %q", ErrMalformedTimestamp, timestamp)
+	}
+	if skew := now.Sub(time.Unix(unix, 0)).Abs(); skew > maxSkew {
+		return false, fmt.Errorf("%w: %s off, at most %s allowed", ErrTimestampSkew, skew.Truncate(time.Second), maxSkew)
+	}
+
+	signatures, err := parseSignatureHeader(header)
+	if err != nil {
+		return false, err
+	}
+	expected, _ := hex.DecodeString(expectedHex)
+	matched := false
+	for _, signature := range signatures {
+		// Every signature is compared, so timing does not reveal which one matched
+		if hmac.Equal(signature, expected) {
+			matched = true
+		}
+	}
+	return matched, nil
+}
+
+// parseSignatureHeader decodes the signatures listed in a signature header
+func parseSignatureHeader(header string) ([][]byte, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, fmt.Errorf("%w: header is empty", ErrMalformedSignature)
+	}
+	parts := strings.Split(header, ",")
+	signatures := make([][]byte, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		encoded, ok := strings.CutPrefix(part, signaturePrefix)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q does not start with %q", ErrMalformedSignature, part, signaturePrefix)
+		}
+		signature, err := hex.DecodeString(encoded)
+		if err != nil || len(signature) != sha256.Size {
+			return nil, fmt.Errorf("%w: %q is not a hex SHA256 HMAC", ErrMalformedSignature, part)
+		}
+		signatures = append(signatures, signature)
+	}
+	return signatures, nil
+}
@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+func TestVerifySignature_RoundTrip(t *testing.T) {
+	body := []byte(`{"event_type":"agent.complete"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	header := signatureHeader(timestamp, body, "primary")
+
+	if ok, err := VerifySignature(timestamp, body, "primary", header); !ok || err != nil {
+		t.Errorf("expected the signature to verify, got %v, %v", ok, err)
+	}
+	if ok, err := VerifySignature(timestamp, body, "other", header); ok || err != nil {
+		t.Errorf("expected a wrong secret not to verify without error, got %v, %v", ok, err)
+	}
+	if ok, err := VerifySignature(timestamp, []byte(`{"event_type":"agent.error"}`), "primary", header); ok || err != nil {
+		t.Errorf("expected a changed body not to verify without error, got %v, %v", ok, err)
+	}
+}
+
+func TestVerifySignatureV2_RoundTrip(t *testing.T) {
+	body := []byte(`{"event_type":"agent.complete"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	header := signatureV2Header(timestamp, "delivery-1", body, "primary", "secondary")
+
+	for _, secret := range []string{"primary", "secondary"} {
+		if ok, err := VerifySignatureV2(timestamp, "delivery-1", body, secret, header); !ok || err != nil {
+			t.Errorf("%s: expected the signature to verify, got %v, %v", secret, ok, err)
+		}
+	}
+	if ok, err := VerifySignatureV2(timestamp, "delivery-2", body, "primary", header); ok || err != nil {
+		t.Errorf("expected another delivery ID not to verify without error, got %v, %v", ok, err)
+	}
+	v1 := signatureHeader(timestamp, body, "primary")
+	if ok, err := VerifySignatureV2(timestamp, "delivery-1", body, "primary", v1); ok || err != nil {
+		t.Errorf("expected a v1 signature not to verify as v2, got %v, %v", ok, err)
+	}
+}
+
+func TestDeliver_AttemptsCarryDistinctDeliveryIDs(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		headers []http.Header
+		bodies  [][]byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		bodies = append(bodies, body)
+		retry := len(headers) < 3
+		mu.Unlock()
+		if retry {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	querier := newFakeEventQuerier()
+	s := newTestService(querier, func(cfg *config.Config) {
+		cfg.WebhookMaxRetries = 3
+		cfg.WebhookRetryMaxDelay = 10 * time.Millisecond
+	})
+
+	if err := s.deliver(context.Background(), Config{URL: server.URL, Secret: "secret"}, testPayloads("req-1", 7)[0]); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	s.flushAttempts(context.Background())
+	attempts, err := s.ListAttempts(context.Background(), "req-1")
+	if err != nil {
+		t.Fatalf("ListAttempts: %v", err)
+	}
+	if len(headers) != 3 || len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d requests and %d records", len(headers), len(attempts))
+	}
+
+	seen := make(map[string]bool)
+	for i, header := range headers {
+		deliveryID := header.Get(DeliveryIDHeader)
+		if deliveryID == "" || seen[deliveryID] {
+			t.Errorf("attempt %d: expected a new delivery ID, got %q", i+1, deliveryID)
+		}
+		seen[deliveryID] = true
+		if header.Get(RequestIDHeader) != "req-1" || header.Get(SeqHeader) != "7" {
+			t.Errorf("attempt %d: expected request req-1 seq 7, got %q seq %q", i+1, header.Get(RequestIDHeader), header.Get(SeqHeader))
+		}
+		if attempts[i].DeliveryID != deliveryID {
+			t.Errorf("attempt %d: recorded delivery ID %q, sent %q", i+1, attempts[i].DeliveryID, deliveryID)
+		}
+		timestamp := header.Get(TimestampHeader)
+		if ok, err := VerifySignatureV2(timestamp, deliveryID, bodies[i], "secret", header.Get(SignatureV2Header)); !ok || err != nil {
+			t.Errorf("attempt %d: expected a valid v2 signature, got %v, %v", i+1, ok, err)
+		}
+		if ok, err := VerifySignature(timestamp, bodies[i], "secret", header.Get(SignatureHeader)); !ok || err != nil {
+			t.Errorf("attempt %d: expected the v1 signature to still verify, got %v, %v", i+1, ok, err)
+		}
+	}
+}
+
+func TestVerifySignature_RejectsClockSkew(t *testing.T) {
+	body := []byte(`{}`)
+	signedAt := time.Unix(1736937000, 0)
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	header := signatureHeader(timestamp, body, "secret")
+
+	tests := []struct {
+		name string
+		now  time.Time
+		ok   bool
+	}{
+		{"on time", signedAt.Add(2 * time.Second), true},
+		{"at the limit", signedAt.Add(MaxClockSkew), true},
+		{"too old", signedAt.Add(MaxClockSkew + time.Second), false},
+		{"from the future", signedAt.Add(-MaxClockSkew - time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := verifySignature(timestamp, body, "secret", header, tt.now, MaxClockSkew)
+			if ok != tt.ok {
+				t.Errorf("expected %v, got %v (%v)", tt.ok, ok, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrTimestampSkew) {
+				t.Errorf("expected ErrTimestampSkew, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifySignature_MalformedInput(t *testing.T) {
+	body := []byte(`{}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	valid := signatureHeader(timestamp, body, "secret")
+
+	tests := []struct {
+		name      string
+		timestamp string
+		header    string
+		want      error
+	}{
+		{"empty header", timestamp, "", ErrMalformedSignature},
+		{"missing prefix", timestamp, strings.TrimPrefix(valid, "sha256="), ErrMalformedSignature},
+		{"other algorithm", timestamp, "sha1=" + strings.TrimPrefix(valid, "sha256="), ErrMalformedSignature},
+		{"not hex", timestamp, "sha256=zz", ErrMalformedSignature},
+		{"truncated", timestamp, valid[:len(valid)-2], ErrMalformedSignature},
+		{"empty entry", timestamp, valid + ",", ErrMalformedSignature},
+		{"malformed entry after a valid one", timestamp, valid + ",sha256", ErrMalformedSignature},
+		{"timestamp not a number", "yesterday", valid, ErrMalformedTimestamp},
+		{"empty timestamp", "", valid, ErrMalformedTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifySignature(tt.timestamp, body, "secret", tt.header)
+			if ok || !errors.Is(err, tt.want) {
+				t.Errorf("expected false and %v, got %v, %v", tt.want, ok, err)
+			}
+		})
+	}
+}
+
+func TestOutboxWorkers_SignWithBothSecretsDuringRotation(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+
+	// Both secrets are kept with the queued event, so a restart mid-rotation still signs with both
+	cfg := Config{URL: server.URL, Secret: "new-secret", SecondarySecret: "old-secret"}
+	if err := s.CreateDeliveryRecord(context.Background(), "req-1", "user-1", "agent-1", cfg); err != nil {
+		t.Fatalf("CreateDeliveryRecord: %v", err)
+	}
+	if err := s.Enqueue(context.Background(), cfg, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	claimAndProcess(t, s, querier)
+
+	cfg.SecondarySecret = ""
+	if err := s.Enqueue(context.Background(), cfg, testPayloads("req-1", 2)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	claimAndProcess(t, s, querier)
+
+	got := received()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(got))
+	}
+	rotating, rotated := got[0], got[1]
+	if n := strings.Count(rotating.signature, "sha256="); n != 2 {
+		t.Fatalf("expected two signatures during the rotation, got %q", rotating.signature)
+	}
+	if !strings.HasPrefix(rotating.signature, signatureHeader(rotating.timestamp, rotating.body, "new-secret")+",") {
+		t.Errorf("expected the primary secret's signature first, got %q", rotating.signature)
+	}
+
+	// Consumers holding either secret accept deliveries during the overlap, and only the
+	// new one afterwards
+	for _, tt := range []struct {
+		delivery receivedWebhook
+		secret   string
+		want     bool
+	}{
+		{rotating, "new-secret", true},
+		{rotating, "old-secret", true},
+		{rotating, "unrelated", false},
+		{rotated, "new-secret", true},
+		{rotated, "old-secret", false},
+	} {
+		ok, err := VerifySignature(tt.delivery.timestamp, tt.delivery.body, tt.secret, tt.delivery.signature)
+		if err != nil || ok != tt.want {
+			t.Errorf("seq %d with %s: expected %v, got %v, %v", tt.delivery.payload.Seq, tt.secret, tt.want, ok, err)
+		}
+	}
+}
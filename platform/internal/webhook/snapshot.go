@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// snapshotContextEvents is how many events before and after the requested seq a snapshot summarizes
+const snapshotContextEvents = 5
+
+// ErrSnapshotNotFound is returned when nothing is stored for a request's seq
+var ErrSnapshotNotFound = errors.New("no stored data for request seq")
+
+// EventSummary briefly describes a stored event
+type EventSummary struct {
+	Seq               int64
+	EventType         string
+	OpenCodeEventType string
+	IsFinal           bool
+	CreatedAt         time.Time
+}
+
+// RequestSnapshot is everything stored about one event of a request, for debugging what a
+// consumer received. Each part comes from its own store and is empty if it was not kept
+// or has been pruned.
+type RequestSnapshot struct {
+	RequestID string
+	Seq       int64
+
+	// Payload is the converted payload as stored for redelivery
+	Payload json.RawMessage
+	// Raw is the agent response as JSON; only kept when WebhookRawArchive is enabled
+	Raw json.RawMessage
+	// Deliveries are the outbox rows for the seq, one per webhook URL
+	Deliveries []*sqlc.WebhookOutbox
+
+	// Before and After summarize the stored events around the seq, in seq order
+	Before []EventSummary
+	After  []EventSummary
+}
+
+// SnapshotAt assembles what is stored about a request's event at seq: the converted
+// payload, the raw agent response, the outbox deliveries, and summaries of the events
+// around it. It returns ErrSnapshotNotFound if none of the stores has the seq.
+func (s *DeliveryService) SnapshotAt(ctx context.Context, requestID string, seq int64) (*RequestSnapshot, error) {
+	snapshot := &RequestSnapshot{RequestID: requestID, Seq: seq}
+
+	event, err := s.queries.GetWebhookEvent(ctx, &sqlc.GetWebhookEventParams{RequestID: requestID, Seq: seq})
+	switch {
+	case err == nil:
+		snapshot.Payload = event.Payload
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, fmt.Errorf("getting webhook event: %w", err)
+	}
+
+	raw, err := s.archivedResponseJSON(ctx, requestID, seq)
+	if err != nil {
+		// A bad archive entry should not hide the rest of the snapshot
+		s.logger.Warn("failed to load archived agent response",
+			zap.Error(err),
+			zap.String("request_id", requestID),
+			zap.Int64("seq", seq),
+		)
+	}
+	snapshot.Raw = raw
+
+	snapshot.Deliveries, err = s.queries.ListOutboxEventsForSeq(ctx, &sqlc.ListOutboxEventsForSeqParams{RequestID: requestID, Seq: seq})
+	if err != nil {
+		return nil, fmt.Errorf("listing outbox events: %w", err)
+	}
+
+	if snapshot.Payload == nil && snapshot.Raw == nil && len(snapshot.Deliveries) == 0 {
+		return nil, ErrSnapshotNotFound
+	}
+
+	before, err := s.queries.ListWebhookEventsPrecedingSeq(ctx, &sqlc.ListWebhookEventsPrecedingSeqParams{
+		RequestID: requestID,
+		Seq:       seq,
+		MaxEvents: snapshotContextEvents,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing preceding events: %w", err)
+	}
+	// Preceding events come newest first
+	snapshot.Before = make([]EventSummary, len(before))
+	for i, e := range before {
+		snapshot.Before[len(before)-1-i] = summarizeEvent(e)
+	}
+
+	after, err := s.queries.ListWebhookEventsFollowingSeq(ctx, &sqlc.ListWebhookEventsFollowingSeqParams{
+		RequestID: requestID,
+		Seq:       seq,
+		MaxEvents: snapshotContextEvents,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing following events: %w", err)
+	}
+	snapshot.After = make([]EventSummary, 0, len(after))
+	for _, e := range after {
+		snapshot.After = append(snapshot.After, summarizeEvent(e))
+	}
+
+	return snapshot, nil
+}
+
+// summarizeEvent describes a stored event without its content
+func summarizeEvent(event *sqlc.WebhookEvent) EventSummary {
+	summary := EventSummary{
+		Seq:       event.Seq,
+		EventType: event.EventType,
+		CreatedAt: event.CreatedAt,
+	}
+	var payload Payload
+	if err := json.Unmarshal(event.Payload, &payload); err == nil {
+		summary.OpenCodeEventType = payload.OpenCodeEventType
+		summary.IsFinal = payload.IsFinal
+	}
+	return summary
+}
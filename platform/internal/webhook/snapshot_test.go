@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+	"github.com/forge/platform/internal/config"
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeSnapshotQuerier adds the raw archive and outbox rows to fakeEventQuerier
+type fakeSnapshotQuerier struct {
+	*fakeEventQuerier
+	archive map[int64][]byte // by seq
+	outbox  []*sqlc.WebhookOutbox
+}
+
+func newFakeSnapshotQuerier() *fakeSnapshotQuerier {
+	return &fakeSnapshotQuerier{
+		fakeEventQuerier: newFakeEventQuerier(),
+		archive:          make(map[int64][]byte),
+	}
+}
+
+func (f *fakeSnapshotQuerier) GetWebhookEvent(_ context.Context, arg *sqlc.GetWebhookEventParams) (*sqlc.WebhookEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if event, ok := f.events[arg.RequestID][arg.Seq]; ok {
+		return event, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (f *fakeSnapshotQuerier) sortedEvents(requestID string) []*sqlc.WebhookEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	events := []*sqlc.WebhookEvent{}
+	for _, e := range f.events[requestID] {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return events
+}
+
+func (f *fakeSnapshotQuerier) ListWebhookEventsPrecedingSeq(_ context.Context, arg *sqlc.ListWebhookEventsPrecedingSeqParams) ([]*sqlc.WebhookEvent, error) {
+	events := f.sortedEvents(arg.RequestID)
+	items := []*sqlc.WebhookEvent{}
+	for i := len(events) - 1; i >= 0 && len(items) < int(arg.MaxEvents); i-- {
+		if events[i].Seq < arg.Seq {
+			items = append(items, events[i])
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeSnapshotQuerier) ListWebhookEventsFollowingSeq(_ context.Context, arg *sqlc.ListWebhookEventsFollowingSeqParams) ([]*sqlc.WebhookEvent, error) {
+	items := []*sqlc.WebhookEvent{}
+	for _, e := range f.sortedEvents(arg.RequestID) {
+		if e.Seq > arg.Seq && len(items) < int(arg.MaxEvents) {
+			items = append(items, e)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeSnapshotQuerier) ArchiveAgentResponse(_ context.Context, arg *sqlc.ArchiveAgentResponseParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.archive[arg.Seq] = arg.Response
+	return nil
+}
+
+func (f *fakeSnapshotQuerier) GetArchivedAgentResponse(_ context.Context, arg *sqlc.GetArchivedAgentResponseParams) (*sqlc.AgentResponseArchive, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	raw, ok := f.archive[arg.Seq]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return &sqlc.AgentResponseArchive{RequestID: arg.RequestID, Seq: arg.Seq, Response: raw}, nil
+}
+
+func (f *fakeSnapshotQuerier) ListOutboxEventsForSeq(_ context.Context, arg *sqlc.ListOutboxEventsForSeqParams) ([]*sqlc.WebhookOutbox, error) {
+	items := []*sqlc.WebhookOutbox{}
+	for _, row := range f.outbox {
+		if row.RequestID == arg.RequestID && row.Seq == arg.Seq {
+			items = append(items, row)
+		}
+	}
+	return items, nil
+}
+
+// archiveRaw archives the agent's raw responses
+func archiveRaw(cfg *config.Config) {
+	cfg.WebhookRawArchive = true
+}
+
+// persistSnapshotEvents stores events 1-12 of req-1
+func persistSnapshotEvents(t *testing.T, s *DeliveryService) {
+	t.Helper()
+	for _, p := range testPayloads("req-1", 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12) {
+		if err := s.PersistEvent(context.Background(), p); err != nil {
+			t.Fatalf("PersistEvent: %v", err)
+		}
+	}
+}
+
+func archiveTestResponse(t *testing.T, s *DeliveryService, seq uint64) {
+	t.Helper()
+	resp := &agentv1.AgentResponse{
+		RequestId: "req-1",
+		Seq:       seq,
+		Payload: &agentv1.AgentResponse_Event{
+			Event: &agentv1.EventPayload{EventType: "message.updated", EventJson: []byte(`{}`)},
+		},
+	}
+	if err := s.ArchiveResponse(context.Background(), "req-1", resp); err != nil {
+		t.Fatalf("ArchiveResponse: %v", err)
+	}
+}
+
+func summarySeqs(summaries []EventSummary) []int64 {
+	seqs := make([]int64, len(summaries))
+	for i, s := range summaries {
+		seqs[i] = s.Seq
+	}
+	return seqs
+}
+
+func TestSnapshotAt_AssemblesAllStores(t *testing.T) {
+	querier := newFakeSnapshotQuerier()
+	s := newTestService(querier, archiveRaw)
+	persistSnapshotEvents(t, s)
+	archiveTestResponse(t, s, 7)
+	querier.outbox = append(querier.outbox, &sqlc.WebhookOutbox{
+		RequestID: "req-1", Seq: 7, WebhookUrl: "https://hooks.example.com/a", Status: OutboxStatusDelivered, AttemptCount: 2,
+	})
+
+	snapshot, err := s.SnapshotAt(context.Background(), "req-1", 7)
+	if err != nil {
+		t.Fatalf("SnapshotAt: %v", err)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(snapshot.Payload, &payload); err != nil || payload.Seq != 7 {
+		t.Errorf("expected the stored payload for seq 7, got %s (err %v)", snapshot.Payload, err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(snapshot.Raw, &raw); err != nil || raw["seq"] != "7" || raw["event"] == nil {
+		t.Errorf("expected the raw response as JSON, got %s (err %v)", snapshot.Raw, err)
+	}
+	if len(snapshot.Deliveries) != 1 || snapshot.Deliveries[0].AttemptCount != 2 {
+		t.Errorf("expected the outbox row for seq 7, got %+v", snapshot.Deliveries)
+	}
+
+	// Five events on each side, in seq order
+	if got := summarySeqs(snapshot.Before); len(got) != 5 || got[0] != 2 || got[4] != 6 {
+		t.Errorf("expected preceding seqs 2-6, got %v", got)
+	}
+	if got := summarySeqs(snapshot.After); len(got) != 5 || got[0] != 8 || got[4] != 12 {
+		t.Errorf("expected following seqs 8-12, got %v", got)
+	}
+	if snapshot.After[0].EventType != string(EventTypeEvent) {
+		t.Errorf("expected summaries to carry the event type, got %+v", snapshot.After[0])
+	}
+}
+
+func TestSnapshotAt_MissingArchiveAndDeliveries(t *testing.T) {
+	s := newTestService(newFakeSnapshotQuerier(), archiveRaw)
+	persistSnapshotEvents(t, s)
+
+	snapshot, err := s.SnapshotAt(context.Background(), "req-1", 2)
+	if err != nil {
+		t.Fatalf("SnapshotAt: %v", err)
+	}
+	if snapshot.Payload == nil {
+		t.Error("expected the stored payload")
+	}
+	if snapshot.Raw != nil || len(snapshot.Deliveries) != 0 {
+		t.Errorf("expected no raw response or deliveries, got %s and %+v", snapshot.Raw, snapshot.Deliveries)
+	}
+	if got := summarySeqs(snapshot.Before); len(got) != 1 || got[0] != 1 {
+		t.Errorf("expected only seq 1 before, got %v", got)
+	}
+}
+
+func TestSnapshotAt_PrunedPayloadKeepsOtherStores(t *testing.T) {
+	querier := newFakeSnapshotQuerier()
+	s := newTestService(querier, archiveRaw)
+	persistSnapshotEvents(t, s)
+	archiveTestResponse(t, s, 20)
+	querier.outbox = append(querier.outbox, &sqlc.WebhookOutbox{
+		RequestID: "req-1", Seq: 20, WebhookUrl: "https://hooks.example.com/a", Status: OutboxStatusPending, NextAttemptAt: time.Now(),
+	})
+
+	snapshot, err := s.SnapshotAt(context.Background(), "req-1", 20)
+	if err != nil {
+		t.Fatalf("SnapshotAt: %v", err)
+	}
+	if snapshot.Payload != nil {
+		t.Errorf("expected no stored payload, got %s", snapshot.Payload)
+	}
+	if snapshot.Raw == nil || len(snapshot.Deliveries) != 1 {
+		t.Errorf("expected the raw response and the delivery, got %s and %+v", snapshot.Raw, snapshot.Deliveries)
+	}
+	if got := summarySeqs(snapshot.Before); len(got) != 5 || got[4] != 12 {
+		t.Errorf("expected the last five stored events before, got %v", got)
+	}
+	if len(snapshot.After) != 0 {
+		t.Errorf("expected nothing after, got %v", summarySeqs(snapshot.After))
+	}
+}
+
+func TestSnapshotAt_CorruptArchiveIsSkipped(t *testing.T) {
+	querier := newFakeSnapshotQuerier()
+	s := newTestService(querier, archiveRaw)
+	persistSnapshotEvents(t, s)
+	querier.archive[3] = []byte{0xff, 0xff, 0xff}
+
+	snapshot, err := s.SnapshotAt(context.Background(), "req-1", 3)
+	if err != nil {
+		t.Fatalf("SnapshotAt: %v", err)
+	}
+	if snapshot.Raw != nil || snapshot.Payload == nil {
+		t.Errorf("expected the payload without the unreadable raw response, got payload %s raw %s", snapshot.Payload, snapshot.Raw)
+	}
+}
+
+func TestSnapshotAt_NothingStored(t *testing.T) {
+	s := newTestService(newFakeSnapshotQuerier(), archiveRaw)
+	persistSnapshotEvents(t, s)
+
+	if _, err := s.SnapshotAt(context.Background(), "req-1", 99); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("expected ErrSnapshotNotFound, got %v", err)
+	}
+	if _, err := s.SnapshotAt(context.Background(), "req-2", 1); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("expected ErrSnapshotNotFound for an unknown request, got %v", err)
+	}
+}
+
+func TestArchiveResponse_DisabledByDefault(t *testing.T) {
+	querier := newFakeSnapshotQuerier()
+	s := newTestService(querier)
+
+	if err := s.ArchiveResponse(context.Background(), "req-1", &agentv1.AgentResponse{Seq: 1}); err != nil {
+		t.Fatalf("ArchiveResponse: %v", err)
+	}
+	if len(querier.archive) != 0 {
+		t.Errorf("expected nothing archived, got %d responses", len(querier.archive))
+	}
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+)
+
+// TestDeliveryTimeout bounds a test delivery, unless WebhookTimeout is shorter
+const TestDeliveryTimeout = 5 * time.Second
+
+// Request and agent IDs of sample payloads
+const (
+	testRequestID = "req_test"
+	testAgentID   = "agent_test"
+)
+
+// SampleEventTypes lists the event types a test delivery can send a sample of
+var SampleEventTypes = append(slices.Clone(EventTypes),
+	EventTypeEventDropped,
+	EventTypeEventBatch,
+	EventTypeAgentCreated,
+	EventTypeAgentReady,
+	EventTypeAgentDeleted,
+	EventTypeAgentFailed,
+)
+
+// TestDeliveryResult is how an endpoint answered a test delivery
+type TestDeliveryResult struct {
+	EventType EventType `json:"event_type"`
+	// DeliveryID is the X-Forge-Delivery-Id the test delivery was sent with
+	DeliveryID string `json:"delivery_id"`
+	Delivered  bool   `json:"delivered"`
+	// StatusCode is the endpoint's HTTP status; 0 if it did not answer
+	StatusCode int   `json:"status_code,omitempty"`
+	LatencyMS  int64 `json:"latency_ms"`
+	// Response is the start of the endpoint's response body
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// WouldRetry reports whether a real delivery failing this way would be retried
+	WouldRetry bool `json:"would_retry"`
+	// Signed reports whether the delivery carried a signature (see SignatureHeader)
+	Signed bool `json:"signed"`
+}
+
+// SamplePayload returns a representative payload of eventType, as the converters of real
+// payloads build it, marked as a test. Unknown event types return an error wrapping
+// ErrUnknownEventType that lists the valid ones.
+func SamplePayload(eventType EventType, now time.Time) (Payload, error) {
+	response := &agentv1.AgentResponse{
+		SessionId: "session_test",
+		Seq:       1,
+		Timestamp: now.UnixMilli(),
+		State:     agentv1.AgentState_AGENT_STATE_PROCESSING,
+	}
+
+	var payload Payload
+	switch eventType {
+	case EventTypeEvent:
+		response.Payload = &agentv1.AgentResponse_Event{Event: &agentv1.EventPayload{
+			EventType: "message.part.updated",
+			EventJson: []byte(`{"type":"message.part.updated","properties":{"part":{"type":"text","text":"Hello from Forge"}}}`),
+		}}
+		payload = AgentResponseToPayload(response, testAgentID, testRequestID)
+	case EventTypeError:
+		response.State = agentv1.AgentState_AGENT_STATE_ERROR
+		response.Payload = &agentv1.AgentResponse_Error{Error: &agentv1.ErrorPayload{
+			Code:    "TEST_ERROR",
+			Message: "sample error sent by a test delivery",
+		}}
+		payload = AgentResponseToPayload(response, testAgentID, testRequestID)
+	case EventTypeComplete:
+		response.State = agentv1.AgentState_AGENT_STATE_IDLE
+		response.Payload = &agentv1.AgentResponse_Complete{Complete: &agentv1.CompletePayload{Success: true}}
+		payload = AgentResponseToPayload(response, testAgentID, testRequestID)
+	case EventTypeResult:
+		payload = ResultToPayload(testAgentID, testRequestID, 1, ResultStatusInterrupted)
+	case EventTypeEventDropped:
+		payload = DroppedEventPayload(Payload{
+			EventType: EventTypeEvent,
+			AgentID:   testAgentID,
+			RequestID: testRequestID,
+			SessionID: response.GetSessionId(),
+			Seq:       1,
+		})
+	case EventTypeEventBatch:
+		events := make([]Payload, 2)
+		for i := range events {
+			response.Seq = uint64(i + 1)
+			response.Payload = &agentv1.AgentResponse_Event{Event: &agentv1.EventPayload{
+				EventType: "message.part.updated",
+				EventJson: []byte(`{"type":"message.part.updated","properties":{"part":{"type":"text","text":"Hello from Forge"}}}`),
+			}}
+			events[i] = AgentResponseToPayload(response, testAgentID, testRequestID)
+			events[i].Timestamp = now
+		}
+		payload = EventBatchPayload(events)
+	case EventTypeAgentCreated, EventTypeAgentReady, EventTypeAgentDeleted, EventTypeAgentFailed:
+		payload = Payload{
+			EventType: eventType,
+			AgentID:   testAgentID,
+			RequestID: LifecycleRequestID("user_test", testAgentID),
+			Seq:       uint64(now.UnixNano()),
+			Lifecycle: &LifecyclePayload{UserID: "user_test", PodPhase: "Running", AgentCreatedAt: &now},
+		}
+	default:
+		valid := make([]string, len(SampleEventTypes))
+		for i, t := range SampleEventTypes {
+			valid[i] = string(t)
+		}
+		return Payload{}, fmt.Errorf("%w %q; valid values: %s", ErrUnknownEventType, eventType, strings.Join(valid, ", "))
+	}
+	payload.Timestamp = now
+	payload.Test = true
+	return payload, nil
+}
+
+// SendTestDelivery sends a sample payload of eventType (agent.event if empty) to
+// webhookCfg's URL once, signed like a real delivery, and reports how the endpoint
+// answered. The URL is validated as for real deliveries; refused URLs return an error
+// wrapping ErrURLRejected. Test deliveries are not retried, recorded or counted by the
+// circuit breaker.
+func (s *DeliveryService) SendTestDelivery(ctx context.Context, webhookCfg Config, eventType EventType) (TestDeliveryResult, error) {
+	if eventType == "" {
+		eventType = EventTypeEvent
+	}
+	payload, err := SamplePayload(eventType, s.now().UTC())
+	if err != nil {
+		return TestDeliveryResult{}, err
+	}
+	if err := s.ValidateURL(ctx, webhookCfg.URL); err != nil {
+		return TestDeliveryResult{}, err
+	}
+
+	timeout := TestDeliveryTimeout
+	if s.cfg.WebhookTimeout > 0 {
+		timeout = min(timeout, s.cfg.WebhookTimeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := s.now()
+	result := s.send(ctx, webhookCfg, payload)
+	if errors.Is(result.Error, ErrURLRejected) {
+		return TestDeliveryResult{}, result.Error
+	}
+
+	testResult := TestDeliveryResult{
+		EventType:  eventType,
+		DeliveryID: result.DeliveryID,
+		Delivered:  result.Success,
+		StatusCode: result.StatusCode,
+		LatencyMS:  s.now().Sub(start).Milliseconds(),
+		Response:   result.ResponseBody,
+		WouldRetry: !result.Success && isRetryableStatus(result.StatusCode),
+		Signed:     webhookCfg.Secret != "",
+	}
+	if result.Error != nil {
+		testResult.Error = s.RedactError(webhookCfg.URL, result.Error.Error())
+	}
+	return testResult, nil
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+func TestSendTestDelivery(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		wantDelivered bool
+		wantRetry     bool
+	}{
+		{"ok", http.StatusOK, true, false},
+		{"server error", http.StatusInternalServerError, false, true},
+		{"client error", http.StatusBadRequest, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, received := startWebhookServer(t, tt.status)
+			s := newTestService(newFakeOutboxQuerier(), outboxConfig(5))
+
+			result, err := s.SendTestDelivery(context.Background(), Config{URL: server.URL}, "")
+			if err != nil {
+				t.Fatalf("SendTestDelivery: %v", err)
+			}
+			if result.Delivered != tt.wantDelivered || result.StatusCode != tt.status || result.WouldRetry != tt.wantRetry {
+				t.Errorf("got %+v, want delivered=%v status=%d would_retry=%v", result, tt.wantDelivered, tt.status, tt.wantRetry)
+			}
+			if tt.wantDelivered != (result.Error == "") {
+				t.Errorf("error = %q, want one only for failed deliveries", result.Error)
+			}
+
+			got := received()
+			if len(got) != 1 {
+				t.Fatalf("expected a single attempt, got %d", len(got))
+			}
+			if payload := got[0].payload; !payload.Test || payload.EventType != EventTypeEvent {
+				t.Errorf("expected a test agent.event payload, got %+v", payload)
+			}
+			if got[0].signature != "" || result.Signed {
+				t.Error("expected an unsigned delivery without a secret")
+			}
+		})
+	}
+}
+
+func TestSendTestDelivery_Signed(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	s := newTestService(newFakeOutboxQuerier(), outboxConfig(5))
+
+	result, err := s.SendTestDelivery(context.Background(), Config{URL: server.URL, Secret: "secret"}, EventTypeComplete)
+	if err != nil {
+		t.Fatalf("SendTestDelivery: %v", err)
+	}
+	if !result.Signed {
+		t.Error("expected the result to report a signed delivery")
+	}
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(got))
+	}
+	if ok, err := VerifySignature(got[0].timestamp, got[0].body, "secret", got[0].signature); !ok || err != nil {
+		t.Errorf("expected a valid signature, got %v (%v)", ok, err)
+	}
+	if got[0].payload.EventType != EventTypeComplete || !got[0].payload.Test {
+		t.Errorf("expected a test agent.complete payload, got %+v", got[0].payload)
+	}
+}
+
+func TestSendTestDelivery_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	s := newTestService(newFakeOutboxQuerier(), outboxConfig(5))
+	s.cfg.WebhookTimeout = 50 * time.Millisecond
+
+	result, err := s.SendTestDelivery(context.Background(), Config{URL: server.URL}, EventTypeError)
+	if err != nil {
+		t.Fatalf("SendTestDelivery: %v", err)
+	}
+	if result.Delivered || result.StatusCode != 0 || !result.WouldRetry || result.Error == "" {
+		t.Errorf("expected an unanswered delivery that would be retried, got %+v", result)
+	}
+}
+
+func TestSendTestDelivery_Rejected(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	s := newTestService(newFakeOutboxQuerier(), func(cfg *config.Config) {
+		cfg.WebhookTimeout = time.Second
+		cfg.WebhookBlockPrivateNetworks = true
+	})
+
+	if _, err := s.SendTestDelivery(context.Background(), Config{URL: server.URL}, ""); !errors.Is(err, ErrURLRejected) {
+		t.Errorf("expected ErrURLRejected, got %v", err)
+	}
+	if _, err := s.SendTestDelivery(context.Background(), Config{URL: server.URL}, "agent.done"); !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("expected ErrUnknownEventType, got %v", err)
+	}
+	if len(received()) != 0 {
+		t.Error("expected nothing to be delivered")
+	}
+}
+
+func TestSamplePayload(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	for _, eventType := range SampleEventTypes {
+		payload, err := SamplePayload(eventType, now)
+		if err != nil {
+			t.Fatalf("%s: %v", eventType, err)
+		}
+		if payload.EventType != eventType || !payload.Test || !payload.Timestamp.Equal(now) {
+			t.Errorf("%s: got %+v", eventType, payload)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// thinkingKeys are object keys whose string or object values hold reasoning content.
+// Numeric values under these keys (e.g. token counts) are usage and are kept.
+var thinkingKeys = map[string]bool{
+	"thinking":  true,
+	"reasoning": true,
+}
+
+// StripThinking removes model reasoning content from a payload.
+//
+// The platform does not parse OpenCode events, so this is a best-effort, key-based
+// scrub of the raw event JSON:
+//   - an event about a reasoning block (e.g. message.part.updated for a "reasoning"
+//     part, or a thinking delta) is dropped entirely
+//   - reasoning blocks embedded in arrays (e.g. the parts of an assistant message)
+//     are removed
+//   - string or object values under "thinking"/"reasoning" keys are removed
+//
+// Token counts are left untouched so reasoning is still reflected in usage.
+// It returns false if the payload should not be delivered at all. Final payloads
+// are never dropped, since consumers rely on them to detect completion.
+func StripThinking(p Payload) (Payload, bool) {
+	if p.EventType != EventTypeEvent || len(p.Event) == 0 {
+		return p, true
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(p.Event))
+	dec.UseNumber()
+	var event any
+	if err := dec.Decode(&event); err != nil {
+		// Not JSON we can inspect - pass it through untouched
+		return p, true
+	}
+
+	scrubbed, isThinking, changed := scrubThinking(event)
+	if isThinking {
+		if !p.IsFinal {
+			return p, false
+		}
+		p.Event = nil
+		return p, true
+	}
+	if !changed {
+		return p, true
+	}
+
+	raw, err := json.Marshal(scrubbed)
+	if err != nil {
+		return p, true
+	}
+	p.Event = raw
+	return p, true
+}
+
+// scrubThinking walks a decoded JSON value and removes reasoning content.
+// isThinking reports that the value itself (or a single-valued field of it) is a
+// reasoning block, in which case the caller should discard the whole value.
+func scrubThinking(v any) (out any, isThinking bool, changed bool) {
+	switch val := v.(type) {
+	case map[string]any:
+		if t, ok := val["type"].(string); ok && isThinkingType(t) {
+			return val, true, false
+		}
+		for key, child := range val {
+			if thinkingKeys[key] {
+				switch child.(type) {
+				case string, map[string]any, []any:
+					delete(val, key)
+					changed = true
+					continue
+				}
+			}
+			scrubbed, childThinking, childChanged := scrubThinking(child)
+			if childThinking {
+				return val, true, false
+			}
+			if childChanged {
+				val[key] = scrubbed
+				changed = true
+			}
+		}
+		return val, false, changed
+
+	case []any:
+		kept := val[:0]
+		for _, elem := range val {
+			scrubbed, elemThinking, elemChanged := scrubThinking(elem)
+			if elemThinking {
+				changed = true
+				continue
+			}
+			if elemChanged {
+				changed = true
+			}
+			kept = append(kept, scrubbed)
+		}
+		return kept, false, changed
+
+	default:
+		return v, false, false
+	}
+}
+
+// isThinkingType reports whether a block/part "type" denotes reasoning content.
+// This covers OpenCode "reasoning" parts as well as provider-style
+// "thinking", "redacted_thinking" and "thinking_delta" blocks.
+func isThinkingType(t string) bool {
+	t = strings.ToLower(t)
+	return strings.HasPrefix(t, "reasoning") ||
+		strings.HasPrefix(t, "thinking") ||
+		t == "redacted_thinking"
+}
@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	agentv1 "github.com/forge/platform/gen/agent/v1"
+)
+
+func eventResponse(seq uint64, eventType, eventJSON string) *agentv1.AgentResponse {
+	return &agentv1.AgentResponse{
+		Seq: seq,
+		Payload: &agentv1.AgentResponse_Event{
+			Event: &agentv1.EventPayload{
+				EventType: eventType,
+				EventJson: []byte(eventJSON),
+			},
+		},
+	}
+}
+
+func decodeEvent(t *testing.T, p Payload) map[string]any {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(p.Event, &event); err != nil {
+		t.Fatalf("failed to decode event JSON %q: %v", p.Event, err)
+	}
+	return event
+}
+
+func TestStripThinking_DropsReasoningPartEvents(t *testing.T) {
+	resp := eventResponse(3, "message.part.updated", `{
+		"type": "message.part.updated",
+		"properties": {
+			"part": {"id": "prt_1", "type": "reasoning", "text": "let me think"},
+			"delta": " think"
+		}
+	}`)
+	payload := AgentResponseToPayload(resp, "agent-1", "req-1")
+
+	if _, keep := StripThinking(payload); keep {
+		t.Fatal("expected reasoning part event to be dropped")
+	}
+}
+
+func TestStripThinking_DropsThinkingDeltas(t *testing.T) {
+	resp := eventResponse(4, "content_block_delta", `{
+		"type": "content_block_delta",
+		"index": 0,
+		"delta": {"type": "thinking_delta", "thinking": "hmm"}
+	}`)
+	payload := AgentResponseToPayload(resp, "agent-1", "req-1")
+
+	if _, keep := StripThinking(payload); keep {
+		t.Fatal("expected thinking delta to be dropped")
+	}
+}
+
+func TestStripThinking_KeepsTextPartEvents(t *testing.T) {
+	raw := `{"type":"message.part.updated","properties":{"part":{"id":"prt_2","type":"text","text":"hello"}}}`
+	payload := AgentResponseToPayload(eventResponse(5, "message.part.updated", raw), "agent-1", "req-1")
+
+	got, keep := StripThinking(payload)
+	if !keep {
+		t.Fatal("expected text part event to be kept")
+	}
+	if string(got.Event) != raw {
+		t.Errorf("expected unchanged event JSON, got %s", got.Event)
+	}
+}
+
+func TestStripThinking_RemovesEmbeddedBlocksAndKeepsUsage(t *testing.T) {
+	resp := eventResponse(6, "message.updated", `{
+		"type": "message.updated",
+		"properties": {
+			"info": {
+				"role": "assistant",
+				"reasoning": "summary of my thoughts",
+				"tokens": {"input": 10, "output": 20, "reasoning": 123456789012}
+			},
+			"parts": [
+				{"type": "reasoning", "text": "secret"},
+				{"type": "text", "text": "answer"},
+				{"type": "redacted_thinking", "data": "abc"}
+			],
+			"content": [
+				{"type": "thinking", "thinking": "secret", "signature": "sig"},
+				{"type": "text", "text": "answer"}
+			]
+		}
+	}`)
+	payload := AgentResponseToPayload(resp, "agent-1", "req-1")
+
+	got, keep := StripThinking(payload)
+	if !keep {
+		t.Fatal("expected assistant message event to be kept")
+	}
+
+	props := decodeEvent(t, got)["properties"].(map[string]any)
+	info := props["info"].(map[string]any)
+	if _, ok := info["reasoning"]; ok {
+		t.Error("expected reasoning summary to be removed from message info")
+	}
+
+	tokens := info["tokens"].(map[string]any)
+	if tokens["reasoning"] != float64(123456789012) {
+		t.Errorf("expected reasoning token count to be preserved, got %v", tokens["reasoning"])
+	}
+
+	for _, key := range []string{"parts", "content"} {
+		blocks := props[key].([]any)
+		if len(blocks) != 1 {
+			t.Fatalf("expected 1 remaining block in %s, got %d: %v", key, len(blocks), blocks)
+		}
+		if typ := blocks[0].(map[string]any)["type"]; typ != "text" {
+			t.Errorf("expected remaining %s block to be text, got %v", key, typ)
+		}
+	}
+}
+
+func TestStripThinking_PassesThroughNonEventPayloads(t *testing.T) {
+	errPayload := ErrorToPayload("agent-1", "req-1", 7, "STREAM_ERROR", "boom", false)
+	if got, keep := StripThinking(errPayload); !keep || got.Error == nil {
+		t.Error("expected error payload to pass through unchanged")
+	}
+
+	complete := CompleteToPayload("agent-1", "req-1", 8, true)
+	if got, keep := StripThinking(complete); !keep || !got.Success {
+		t.Error("expected complete payload to pass through unchanged")
+	}
+}
+
+func TestStripThinking_PassesThroughInvalidJSON(t *testing.T) {
+	payload := AgentResponseToPayload(eventResponse(9, "raw", `not json`), "agent-1", "req-1")
+
+	got, keep := StripThinking(payload)
+	if !keep || string(got.Event) != "not json" {
+		t.Errorf("expected invalid JSON to pass through, got keep=%v event=%s", keep, got.Event)
+	}
+}
+
+func TestStripThinking_NeverDropsFinalPayloads(t *testing.T) {
+	payload := Payload{
+		EventType: EventTypeEvent,
+		IsFinal:   true,
+		Event:     json.RawMessage(`{"type":"session.completed","properties":{"part":{"type":"reasoning"}}}`),
+	}
+
+	got, keep := StripThinking(payload)
+	if !keep {
+		t.Fatal("expected final payload to be kept")
+	}
+	if got.Event != nil {
+		t.Errorf("expected reasoning content to be removed from final payload, got %s", got.Event)
+	}
+}
@@ -15,17 +15,119 @@ const (
 	EventTypeError EventType = "agent.error"
 	// EventTypeComplete is for stream completion
 	EventTypeComplete EventType = "agent.complete"
+	// EventTypeResult is for requests that end without the agent finishing them (see Status)
+	EventTypeResult EventType = "agent.result"
+	// EventTypeEventDropped stands in for an event of an ordered request that could not be
+	// delivered (see DroppedEventPayload)
+	EventTypeEventDropped EventType = "agent.event_dropped"
+	// EventTypeEventBatch carries consecutive agent.event payloads held back by the
+	// delivery rate limit, in seq order (see Payload.Events)
+	EventTypeEventBatch EventType = "agent.event_batch"
+)
+
+// ErrorCodeEventDropped is the error code of an agent.event_dropped payload
+const ErrorCodeEventDropped = "EVENT_DROPPED"
+
+// ResultStatusInterrupted is the status of an agent.result ending a request that was interrupted
+const ResultStatusInterrupted = "interrupted"
+
+// Agent lifecycle event types, delivered to lifecycle webhooks (see DeliverLifecycleEvent)
+const (
+	// EventTypeAgentCreated is for an agent whose pod was created
+	EventTypeAgentCreated EventType = "agent.created"
+	// EventTypeAgentReady is for an agent whose pod became ready
+	EventTypeAgentReady EventType = "agent.ready"
+	// EventTypeAgentDeleted is for an agent whose pod was deleted
+	EventTypeAgentDeleted EventType = "agent.deleted"
+	// EventTypeAgentFailed is for an agent whose pod failed
+	EventTypeAgentFailed EventType = "agent.failed"
 )
 
 // Config holds webhook delivery configuration
 type Config struct {
 	URL    string
 	Secret string // optional HMAC secret
+
+	// SecondarySecret also signs deliveries while Secret is being rotated (see SignatureHeader)
+	SecondarySecret string
+
+	// Headers are sent with every delivery to URL, after the platform's own headers
+	// (see ValidateHeaders). BearerToken, if set, is sent as "Authorization: Bearer <token>".
+	Headers     map[string]string
+	BearerToken string
+
+	// Fanout lists further endpoints that receive every payload alongside URL
+	Fanout []Endpoint
+
+	// OmitThinking strips model reasoning content from delivered events (see StripThinking)
+	OmitThinking bool
+
+	// EventTypes, if set, limits deliveries to payloads of these types; final payloads are
+	// delivered regardless (see Delivers)
+	EventTypes []EventType
+
+	// Unordered lets a request's events be delivered to an endpoint in parallel, each
+	// carrying its delivery attempt, instead of one at a time in seq order. In order, an
+	// event that cannot be delivered is replaced by an agent.event_dropped payload.
+	Unordered bool
+
+	// SchemaVersion pins the payload schema deliveries are encoded in; empty for
+	// CurrentSchemaVersion (see EncodePayload)
+	SchemaVersion string
+
+	// RateLimitRPS and RateBurst override WEBHOOK_RATE_LIMIT_RPS and WEBHOOK_RATE_BURST
+	// for deliveries of the request; 0 keeps the configured value
+	RateLimitRPS float64
+	RateBurst    int
+}
+
+// Endpoint is an additional webhook destination of a Config
+type Endpoint struct {
+	URL             string
+	Secret          string // optional HMAC secret
+	SecondarySecret string // also signs deliveries during a secret rotation
+	Headers         map[string]string
+	BearerToken     string
+}
+
+// Endpoints splits a config into one single-endpoint config per destination, URL first
+func (c Config) Endpoints() []Config {
+	endpoints := make([]Config, 0, 1+len(c.Fanout))
+	endpoints = append(endpoints, Config{
+		URL:             c.URL,
+		Secret:          c.Secret,
+		SecondarySecret: c.SecondarySecret,
+		Headers:         c.Headers,
+		BearerToken:     c.BearerToken,
+		OmitThinking:    c.OmitThinking,
+		Unordered:       c.Unordered,
+		SchemaVersion:   c.SchemaVersion,
+		RateLimitRPS:    c.RateLimitRPS,
+		RateBurst:       c.RateBurst,
+	})
+	for _, e := range c.Fanout {
+		endpoints = append(endpoints, Config{
+			URL:             e.URL,
+			Secret:          e.Secret,
+			SecondarySecret: e.SecondarySecret,
+			Headers:         e.Headers,
+			BearerToken:     e.BearerToken,
+			OmitThinking:    c.OmitThinking,
+			Unordered:       c.Unordered,
+			SchemaVersion:   c.SchemaVersion,
+			RateLimitRPS:    c.RateLimitRPS,
+			RateBurst:       c.RateBurst,
+		})
+	}
+	return endpoints
 }
 
 // Payload represents a webhook payload sent to consumers.
 // The Event field contains raw OpenCode event JSON - the platform does not parse it.
 type Payload struct {
+	// SchemaVersion is the schema the payload was encoded in, set on delivery
+	SchemaVersion string `json:"schema_version,omitempty"`
+
 	EventType EventType `json:"event_type"`
 	AgentID   string    `json:"agent_id"`
 	RequestID string    `json:"request_id"`
@@ -50,6 +152,42 @@ type Payload struct {
 
 	// For agent.complete
 	Success bool `json:"success,omitempty"`
+
+	// For agent.result - how the request ended, e.g. "interrupted"
+	Status string `json:"status,omitempty"`
+
+	// For final payloads - files the agent produced for the request (see Artifact)
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// For agent lifecycle events
+	Lifecycle *LifecyclePayload `json:"lifecycle,omitempty"`
+
+	// For messages sent as part of a batch - the batch and the zero-based step index
+	BatchID string `json:"batch_id,omitempty"`
+	Step    *int   `json:"step,omitempty"`
+
+	// For agent.event_dropped - the type of the event that could not be delivered
+	DroppedEventType EventType `json:"dropped_event_type,omitempty"`
+
+	// For agent.event_batch - the coalesced agent.event payloads, in seq order
+	Events []Payload `json:"events,omitempty"`
+
+	// For unordered requests - which attempt at delivering the payload this is, from 1
+	DeliveryAttempt int `json:"delivery_attempt,omitempty"`
+
+	// Test marks sample payloads sent by a test delivery (see SendTestDelivery)
+	Test bool `json:"test,omitempty"`
+}
+
+// LifecyclePayload describes an agent lifecycle event
+type LifecyclePayload struct {
+	UserID string `json:"user_id"`
+	// PodPhase is the phase of the agent's pod when the event happened, if known
+	PodPhase string `json:"pod_phase,omitempty"`
+	// Reason says why the event happened, e.g. why the pod failed
+	Reason string `json:"reason,omitempty"`
+	// AgentCreatedAt is when the agent was created, if known
+	AgentCreatedAt *time.Time `json:"agent_created_at,omitempty"`
 }
 
 // ErrorPayload is the payload for agent.error events
@@ -59,10 +197,25 @@ type ErrorPayload struct {
 	Recoverable bool   `json:"recoverable"`
 }
 
+// Artifact is a file the agent produced for a request, listed on the request's final
+// payload. Artifacts up to the inline size limit carry their content in Data; every
+// artifact can also be downloaded from URL.
+type Artifact struct {
+	Name      string `json:"name"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+	Data      []byte `json:"data,omitempty"` // base64-encoded in JSON
+	URL       string `json:"url"`
+}
+
 // DeliveryResult represents the result of a webhook delivery attempt
 type DeliveryResult struct {
 	Success    bool
 	StatusCode int
 	Error      error
 	RetryAfter time.Duration
+	// ResponseBody is the start of the endpoint's response, up to 1 KiB
+	ResponseBody string
+	// DeliveryID is the attempt's X-Forge-Delivery-Id
+	DeliveryID string
 }
@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// ErrURLRejected is returned for webhook URLs the platform refuses to call, e.g. ones that
+// point at the platform's own network (SSRF protection)
+var ErrURLRejected = errors.New("webhook URL rejected")
+
+// ErrorCodeURLRejected is the API error code returned when a webhook URL is rejected
+const ErrorCodeURLRejected = "webhook_url_rejected"
+
+// privateNetworks are refused as webhook destinations when WebhookBlockPrivateNetworks is set,
+// in addition to loopback, link-local, unspecified and multicast addresses
+var privateNetworks = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, used by some cluster networks
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// urlPolicy decides which hosts webhooks may be sent to. It is checked when a webhook URL
+// is accepted and again when each delivery connects, so a DNS record changed after
+// validation cannot redirect deliveries to a refused address.
+type urlPolicy struct {
+	blockPrivate bool
+	// allowedHosts are exempt from the address checks (host names lowercased, or IPs)
+	allowedHosts map[string]bool
+	blocked      []netip.Prefix
+
+	// exemptAddrs are host:port addresses the platform itself listens on for a while (e.g.
+	// the self test receiver), exempt from the checks at dial time only
+	exemptMu    sync.RWMutex
+	exemptAddrs map[string]bool
+
+	// lookup resolves a host name; replaced in tests
+	lookup func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// newURLPolicy builds the webhook destination policy from config. It returns an error
+// listing invalid WebhookBlockedCIDRs entries along with a policy that skips them.
+func newURLPolicy(cfg *config.Config) (*urlPolicy, error) {
+	p := &urlPolicy{
+		blockPrivate: cfg.WebhookBlockPrivateNetworks,
+		allowedHosts: make(map[string]bool, len(cfg.WebhookAllowedHosts)),
+		exemptAddrs:  make(map[string]bool),
+		lookup: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		},
+	}
+	for _, host := range cfg.WebhookAllowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			p.allowedHosts[host] = true
+		}
+	}
+
+	var invalid []string
+	for _, entry := range cfg.WebhookBlockedCIDRs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			// A bare address blocks just that address
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				invalid = append(invalid, entry)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.blocked = append(p.blocked, prefix.Masked())
+	}
+	if len(invalid) > 0 {
+		return p, fmt.Errorf("invalid WEBHOOK_BLOCKED_CIDRS entries: %s", strings.Join(invalid, ", "))
+	}
+	return p, nil
+}
+
+// checksAddresses reports whether any destination address is refused by the policy
+func (p *urlPolicy) checksAddresses() bool {
+	return p.blockPrivate || len(p.blocked) > 0
+}
+
+// validate checks that rawURL is an http(s) URL whose host resolves only to allowed addresses
+func (p *urlPolicy) validate(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrURLRejected, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrURLRejected)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrURLRejected)
+	}
+
+	_, err = p.resolve(ctx, host)
+	return err
+}
+
+// resolve returns the addresses host may be reached at, refusing the host if any of its
+// addresses is refused. Allowed hosts are returned unresolved, as a nil slice.
+func (p *urlPolicy) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if p.allowedHosts[host] || !p.checksAddresses() {
+		return nil, nil
+	}
+
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else {
+		addrs, err = p.lookup(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("%w: resolving %s: %v", ErrURLRejected, host, err)
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("%w: %s has no addresses", ErrURLRejected, host)
+		}
+	}
+
+	for _, addr := range addrs {
+		if reason := p.refusal(addr.Unmap()); reason != "" {
+			return nil, fmt.Errorf("%w: %s resolves to %s address %s", ErrURLRejected, host, reason, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// refusal returns why addr may not be called, or "" if it may
+func (p *urlPolicy) refusal(addr netip.Addr) string {
+	for _, prefix := range p.blocked {
+		if prefix.Contains(addr) {
+			return "blocked"
+		}
+	}
+	if !p.blockPrivate {
+		return ""
+	}
+
+	switch {
+	case addr.IsLoopback():
+		return "loopback"
+	case addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast():
+		return "link-local"
+	case addr.IsUnspecified():
+		return "unspecified"
+	case addr.IsMulticast():
+		return "multicast"
+	}
+	for _, prefix := range privateNetworks {
+		if prefix.Contains(addr) {
+			return "private"
+		}
+	}
+	return ""
+}
+
+// exempt reports whether the host:port address is exempt from the checks
+func (p *urlPolicy) exempt(addr string) bool {
+	p.exemptMu.RLock()
+	defer p.exemptMu.RUnlock()
+	return p.exemptAddrs[addr]
+}
+
+// dialContext connects to addr only at addresses the policy allows, dialing the checked
+// address itself so the host is not resolved a second time
+func (p *urlPolicy) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if p.exempt(addr) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := p.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if addrs == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		var lastErr error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// newHTTPClient returns the client webhooks are delivered with, enforcing the policy
+// whenever it connects. Proxies are not used while addresses are checked, since the
+// proxy would make the connection the policy is meant to refuse.
+func newHTTPClient(timeout time.Duration, policy *urlPolicy) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if policy.checksAddresses() {
+		transport.Proxy = nil
+		transport.DialContext = policy.dialContext(&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		})
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// ValidateURL checks that a webhook URL may be called: it must be http(s), and unless its
+// host is in WebhookAllowedHosts, every address the host resolves to must be outside the
+// refused networks (private, loopback and link-local when WebhookBlockPrivateNetworks is
+// set, and WebhookBlockedCIDRs). Errors wrap ErrURLRejected.
+func (s *DeliveryService) ValidateURL(ctx context.Context, rawURL string) error {
+	return s.urlPolicy.validate(ctx, rawURL)
+}
+
+// ExemptAddress lets deliveries connect to the host:port address (e.g. "127.0.0.1:41234")
+// even if the policy refuses its host, until release is called. It is meant for receivers
+// the platform runs itself; URLs submitted through the API are still validated as usual.
+func (s *DeliveryService) ExemptAddress(addr string) (release func()) {
+	p := s.urlPolicy
+	p.exemptMu.Lock()
+	p.exemptAddrs[addr] = true
+	p.exemptMu.Unlock()
+
+	return func() {
+		p.exemptMu.Lock()
+		delete(p.exemptAddrs, addr)
+		p.exemptMu.Unlock()
+		// Kept-alive connections would otherwise still reach the address
+		s.client.CloseIdleConnections()
+	}
+}
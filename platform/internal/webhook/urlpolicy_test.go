@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/config"
+)
+
+// staticLookup resolves every host to addrs
+func staticLookup(addrs ...string) func(context.Context, string) ([]netip.Addr, error) {
+	return func(context.Context, string) ([]netip.Addr, error) {
+		resolved := make([]netip.Addr, len(addrs))
+		for i, a := range addrs {
+			resolved[i] = netip.MustParseAddr(a)
+		}
+		return resolved, nil
+	}
+}
+
+// blockPrivateNetworks refuses webhook URLs resolving to private networks
+func blockPrivateNetworks(cfg *config.Config) {
+	cfg.WebhookBlockPrivateNetworks = true
+}
+
+func TestValidateURL_RejectsRefusedDestinations(t *testing.T) {
+	s := newTestService(newFakeOutboxQuerier(), func(cfg *config.Config) {
+		cfg.WebhookBlockPrivateNetworks = true
+		cfg.WebhookBlockedCIDRs = []string{"203.0.113.0/24", "198.51.100.7"}
+	})
+	s.urlPolicy.lookup = staticLookup("93.184.216.34")
+
+	tests := []struct {
+		url    string
+		reject bool
+	}{
+		{"https://hooks.example.com/forge", false},
+		{"http://93.184.216.34:8080/forge", false},
+		{"ftp://hooks.example.com/forge", true},
+		{"file:///etc/passwd", true},
+		{"https:///no-host", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://127.0.0.1:8080/", true},
+		{"http://[::1]/", true},
+		{"http://0.0.0.0/", true},
+		{"http://10.0.0.5/", true},
+		{"http://172.20.1.1/", true},
+		{"http://192.168.1.10/", true},
+		{"http://[fd00::1]/", true},
+		{"http://[::ffff:10.0.0.1]/", true},
+		{"http://203.0.113.9/", true},
+		{"http://198.51.100.7/", true},
+		{"http://198.51.100.8/", false},
+	}
+	for _, tt := range tests {
+		err := s.ValidateURL(context.Background(), tt.url)
+		if tt.reject && !errors.Is(err, ErrURLRejected) {
+			t.Errorf("%s: expected ErrURLRejected, got %v", tt.url, err)
+		}
+		if !tt.reject && err != nil {
+			t.Errorf("%s: expected to be allowed, got %v", tt.url, err)
+		}
+	}
+}
+
+func TestValidateURL_ResolvesHostNames(t *testing.T) {
+	s := newTestService(newFakeOutboxQuerier(), blockPrivateNetworks)
+
+	// A public name that also resolves to a private address is refused
+	s.urlPolicy.lookup = staticLookup("93.184.216.34", "10.1.2.3")
+	if err := s.ValidateURL(context.Background(), "https://rebind.example.com/"); !errors.Is(err, ErrURLRejected) {
+		t.Errorf("expected a name resolving to a private address to be rejected, got %v", err)
+	}
+
+	s.urlPolicy.lookup = func(context.Context, string) ([]netip.Addr, error) {
+		return nil, errors.New("no such host")
+	}
+	if err := s.ValidateURL(context.Background(), "https://missing.example.com/"); !errors.Is(err, ErrURLRejected) {
+		t.Errorf("expected an unresolvable host to be rejected, got %v", err)
+	}
+}
+
+func TestValidateURL_AllowedHostsSkipChecks(t *testing.T) {
+	s := newTestService(newFakeOutboxQuerier(), func(cfg *config.Config) {
+		cfg.WebhookBlockPrivateNetworks = true
+		cfg.WebhookAllowedHosts = []string{"Internal.Example.com", "10.0.0.5"}
+	})
+	s.urlPolicy.lookup = staticLookup("10.1.2.3")
+
+	for _, url := range []string{"http://internal.example.com/hook", "http://10.0.0.5/hook"} {
+		if err := s.ValidateURL(context.Background(), url); err != nil {
+			t.Errorf("%s: expected allowed host to pass, got %v", url, err)
+		}
+	}
+	if err := s.ValidateURL(context.Background(), "http://other.example.com/"); !errors.Is(err, ErrURLRejected) {
+		t.Errorf("expected hosts outside the allow list to be checked, got %v", err)
+	}
+}
+
+func TestValidateURL_DisabledChecksOnlyScheme(t *testing.T) {
+	s := newTestService(newFakeOutboxQuerier())
+
+	if err := s.ValidateURL(context.Background(), "http://127.0.0.1:8080/"); err != nil {
+		t.Errorf("expected loopback to be allowed with blocking off, got %v", err)
+	}
+	if err := s.ValidateURL(context.Background(), "gopher://hooks.example.com/"); !errors.Is(err, ErrURLRejected) {
+		t.Errorf("expected the scheme to be checked with blocking off, got %v", err)
+	}
+}
+
+func TestNewURLPolicy_ReportsInvalidCIDRs(t *testing.T) {
+	policy, err := newURLPolicy(&config.Config{
+		WebhookBlockedCIDRs: []string{"10.96.0.0/12", "not-a-cidr", " ", "fd00::/8"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for the invalid entry")
+	}
+	if len(policy.blocked) != 2 {
+		t.Errorf("expected the valid entries to be kept, got %v", policy.blocked)
+	}
+}
+
+func TestDeliver_RefusedAtDialTimeWithoutRetry(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	s := newTestService(newFakeOutboxQuerier(), blockPrivateNetworks)
+
+	err := s.deliver(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1)[0])
+	if !errors.Is(err, ErrURLRejected) {
+		t.Fatalf("expected ErrURLRejected delivering to loopback, got %v", err)
+	}
+	if len(received()) != 0 {
+		t.Errorf("expected no request to reach the server, got %d", len(received()))
+	}
+	for _, c := range s.Circuits() {
+		if c.URL == server.URL {
+			t.Errorf("expected a rejected URL not to count against its circuit, got %+v", c)
+		}
+	}
+}
+
+func TestDeliver_ExemptAddressReachesLoopbackUntilReleased(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	s := newTestService(newFakeOutboxQuerier(), blockPrivateNetworks)
+
+	release := s.ExemptAddress(server.Listener.Addr().String())
+	if err := s.deliver(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("expected the exempt address to be reached, got %v", err)
+	}
+	if err := s.ValidateURL(context.Background(), server.URL); !errors.Is(err, ErrURLRejected) {
+		t.Errorf("expected API validation to still refuse the address, got %v", err)
+	}
+
+	release()
+	err := s.deliver(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 2)[0])
+	if !errors.Is(err, ErrURLRejected) {
+		t.Fatalf("expected ErrURLRejected once released, got %v", err)
+	}
+	if len(received()) != 1 {
+		t.Errorf("expected 1 request to reach the server, got %d", len(received()))
+	}
+}
+
+func TestDeliver_DNSChangeAfterValidationIsRefused(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	s := newTestService(newFakeOutboxQuerier(), blockPrivateNetworks)
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	url := "http://rebind.example.com:" + port + "/hook"
+
+	// The name resolves publicly when validated, then to loopback when delivered
+	var lookups atomic.Int32
+	s.urlPolicy.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		if lookups.Add(1) == 1 {
+			return staticLookup("93.184.216.34")(ctx, host)
+		}
+		return staticLookup("127.0.0.1")(ctx, host)
+	}
+
+	if err := s.ValidateURL(context.Background(), url); err != nil {
+		t.Fatalf("ValidateURL: %v", err)
+	}
+	err := s.deliver(context.Background(), Config{URL: url}, testPayloads("req-1", 1)[0])
+	if !errors.Is(err, ErrURLRejected) {
+		t.Fatalf("expected the delivery to be refused at dial time, got %v", err)
+	}
+	if len(received()) != 0 {
+		t.Errorf("expected no request to reach the server, got %d", len(received()))
+	}
+}
+
+func TestProcessOutboxEvent_RejectedURLDeadLetters(t *testing.T) {
+	server, received := startWebhookServer(t, http.StatusOK)
+	querier := newFakeOutboxQuerier()
+	s := newTestService(querier, outboxConfig(5))
+	policy, _ := newURLPolicy(&config.Config{WebhookBlockPrivateNetworks: true})
+	s.urlPolicy = policy
+	s.client = newHTTPClient(5*time.Second, policy)
+
+	if err := s.Enqueue(context.Background(), Config{URL: server.URL}, testPayloads("req-1", 1)[0]); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	claimAndProcess(t, s, querier)
+
+	if row := querier.row(t, "req-1", 1); row.Status != OutboxStatusDeadLetter {
+		t.Errorf("expected a rejected URL to dead-letter without retrying, got %+v", row)
+	}
+	if len(received()) != 0 {
+		t.Errorf("expected no request to reach the server, got %d", len(received()))
+	}
+}
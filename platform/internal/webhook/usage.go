@@ -0,0 +1,209 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// Usage groupings of SumUsage
+const (
+	UsageGroupByAgent = "agent"
+	UsageGroupByDay   = "day"
+)
+
+// UsageDayFormat is the format of the UTC days usage is bucketed by
+const UsageDayFormat = "2006-01-02"
+
+// Usage is the tokens and cost an agent's model used, and how long the agent worked
+type Usage struct {
+	InputTokens      int64   `json:"input_tokens"`
+	OutputTokens     int64   `json:"output_tokens"`
+	CacheReadTokens  int64   `json:"cache_read_tokens"`
+	CacheWriteTokens int64   `json:"cache_write_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	DurationMs       int64   `json:"duration_ms"`
+}
+
+// Add adds other to u
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheReadTokens += other.CacheReadTokens
+	u.CacheWriteTokens += other.CacheWriteTokens
+	u.CostUSD += other.CostUSD
+	u.DurationMs += other.DurationMs
+}
+
+// IsZero reports whether no tokens, cost or time were used
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+// openCodeMessageEvent is the part of an OpenCode message.updated event carrying usage
+type openCodeMessageEvent struct {
+	Type       string `json:"type"`
+	Properties struct {
+		Info struct {
+			ID     string  `json:"id"`
+			Role   string  `json:"role"`
+			Cost   float64 `json:"cost"`
+			Tokens struct {
+				Input     int64 `json:"input"`
+				Output    int64 `json:"output"`
+				Reasoning int64 `json:"reasoning"`
+				Cache     struct {
+					Read  int64 `json:"read"`
+					Write int64 `json:"write"`
+				} `json:"cache"`
+			} `json:"tokens"`
+		} `json:"info"`
+	} `json:"properties"`
+}
+
+// MessageUsage returns the ID and usage of the assistant message an OpenCode
+// message.updated event is about, and false for any other payload. Reasoning tokens are
+// billed as output, so they are counted in OutputTokens.
+func MessageUsage(p Payload) (string, Usage, bool) {
+	if p.EventType != EventTypeEvent || len(p.Event) == 0 {
+		return "", Usage{}, false
+	}
+	var event openCodeMessageEvent
+	if err := json.Unmarshal(p.Event, &event); err != nil || event.Type != "message.updated" {
+		return "", Usage{}, false
+	}
+	info := event.Properties.Info
+	if info.Role != MessageRoleAssistant || info.ID == "" {
+		return "", Usage{}, false
+	}
+	return info.ID, Usage{
+		InputTokens:      info.Tokens.Input,
+		OutputTokens:     info.Tokens.Output + info.Tokens.Reasoning,
+		CacheReadTokens:  info.Tokens.Cache.Read,
+		CacheWriteTokens: info.Tokens.Cache.Write,
+		CostUSD:          info.Cost,
+	}, true
+}
+
+// UsageTracker sums a request's usage from the payloads relayed for it. OpenCode updates
+// an assistant message as it streams, each update carrying the message's usage so far, so
+// the latest update of each message counts.
+type UsageTracker struct {
+	mu       sync.Mutex
+	messages map[string]Usage
+}
+
+// NewUsageTracker creates an empty usage tracker
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{messages: make(map[string]Usage)}
+}
+
+// Observe records the usage a payload reports, if any
+func (t *UsageTracker) Observe(p Payload) {
+	id, usage, ok := MessageUsage(p)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages[id] = usage
+}
+
+// Total returns the usage summed over the messages observed so far
+func (t *UsageTracker) Total() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var total Usage
+	for _, usage := range t.messages {
+		total.Add(usage)
+	}
+	return total
+}
+
+// UsageEntry is the usage summed over a user's requests for one agent or one UTC day. A
+// pending entry is the usage so far of a single request still in progress.
+type UsageEntry struct {
+	AgentID   string `json:"agent_id,omitempty"`
+	Day       string `json:"day,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Requests  int    `json:"requests"`
+	Pending   bool   `json:"pending,omitempty"`
+	Usage
+}
+
+// RecordUsage stores a request's usage, made at the given time, replacing what was stored
+// for it before
+func (s *DeliveryService) RecordUsage(ctx context.Context, userID, agentID, requestID string, usage Usage, at time.Time) error {
+	if err := s.queries.UpsertRequestUsage(ctx, &sqlc.UpsertRequestUsageParams{
+		RequestID:        requestID,
+		UserID:           userID,
+		AgentID:          agentID,
+		InputTokens:      usage.InputTokens,
+		OutputTokens:     usage.OutputTokens,
+		CacheReadTokens:  usage.CacheReadTokens,
+		CacheWriteTokens: usage.CacheWriteTokens,
+		CostUsd:          usage.CostUSD,
+		DurationMs:       usage.DurationMs,
+		CreatedAt:        at,
+	}); err != nil {
+		return fmt.Errorf("storing request usage: %w", err)
+	}
+	return nil
+}
+
+// SumUsage returns the stored usage of a user's requests made in [from, to), summed per
+// agent, ordered by agent ID, or per UTC day, ordered by day, depending on groupBy
+func (s *DeliveryService) SumUsage(ctx context.Context, userID string, from, to time.Time, groupBy string) ([]UsageEntry, error) {
+	rows, err := s.queries.SumRequestUsage(ctx, &sqlc.SumRequestUsageParams{
+		UserID:   userID,
+		FromTime: from,
+		ToTime:   to,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("summing request usage: %w", err)
+	}
+
+	byKey := make(map[string]*UsageEntry)
+	for _, row := range rows {
+		key := row.AgentID
+		if groupBy == UsageGroupByDay {
+			key = row.Day.UTC().Format(UsageDayFormat)
+		}
+		entry, ok := byKey[key]
+		if !ok {
+			entry = &UsageEntry{}
+			if groupBy == UsageGroupByDay {
+				entry.Day = key
+			} else {
+				entry.AgentID = key
+			}
+			byKey[key] = entry
+		}
+		entry.Requests += int(row.Requests)
+		entry.Add(Usage{
+			InputTokens:      row.InputTokens,
+			OutputTokens:     row.OutputTokens,
+			CacheReadTokens:  row.CacheReadTokens,
+			CacheWriteTokens: row.CacheWriteTokens,
+			CostUSD:          row.CostUsd,
+			DurationMs:       row.DurationMs,
+		})
+	}
+
+	entries := make([]UsageEntry, 0, len(byKey))
+	for _, entry := range byKey {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Day != entries[j].Day {
+			return entries[i].Day < entries[j].Day
+		}
+		return entries[i].AgentID < entries[j].AgentID
+	})
+	return entries, nil
+}
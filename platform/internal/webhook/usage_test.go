@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/forge/platform/internal/sqlc/gen"
+)
+
+// fakeUsageQuerier stores request usage rows and sums them per agent and UTC day as the
+// SumRequestUsage query does
+type fakeUsageQuerier struct {
+	sqlc.Querier
+	rows map[string]*sqlc.UpsertRequestUsageParams
+}
+
+func (f *fakeUsageQuerier) UpsertRequestUsage(_ context.Context, arg *sqlc.UpsertRequestUsageParams) error {
+	if existing, ok := f.rows[arg.RequestID]; ok {
+		row := *arg
+		row.UserID, row.AgentID, row.CreatedAt = existing.UserID, existing.AgentID, existing.CreatedAt
+		arg = &row
+	}
+	f.rows[arg.RequestID] = arg
+	return nil
+}
+
+func (f *fakeUsageQuerier) SumRequestUsage(_ context.Context, arg *sqlc.SumRequestUsageParams) ([]*sqlc.SumRequestUsageRow, error) {
+	type key struct {
+		agentID string
+		day     time.Time
+	}
+	sums := make(map[key]*sqlc.SumRequestUsageRow)
+	for _, row := range f.rows {
+		if row.UserID != arg.UserID || row.CreatedAt.Before(arg.FromTime) || !row.CreatedAt.Before(arg.ToTime) {
+			continue
+		}
+		day := row.CreatedAt.UTC().Truncate(24 * time.Hour)
+		sum, ok := sums[key{row.AgentID, day}]
+		if !ok {
+			sum = &sqlc.SumRequestUsageRow{AgentID: row.AgentID, Day: day}
+			sums[key{row.AgentID, day}] = sum
+		}
+		sum.Requests++
+		sum.InputTokens += row.InputTokens
+		sum.OutputTokens += row.OutputTokens
+		sum.CacheReadTokens += row.CacheReadTokens
+		sum.CacheWriteTokens += row.CacheWriteTokens
+		sum.CostUsd += row.CostUsd
+		sum.DurationMs += row.DurationMs
+	}
+	items := []*sqlc.SumRequestUsageRow{}
+	for _, sum := range sums {
+		items = append(items, sum)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Day.Equal(items[j].Day) {
+			return items[i].Day.Before(items[j].Day)
+		}
+		return items[i].AgentID < items[j].AgentID
+	})
+	return items, nil
+}
+
+// messageUpdated returns an agent.event payload of an OpenCode message.updated event
+func messageUpdated(t *testing.T, id, role string, input, output, reasoning, cacheRead int64, cost float64) Payload {
+	t.Helper()
+	event := map[string]any{
+		"type": "message.updated",
+		"properties": map[string]any{
+			"info": map[string]any{
+				"id":   id,
+				"role": role,
+				"cost": cost,
+				"tokens": map[string]any{
+					"input":     input,
+					"output":    output,
+					"reasoning": reasoning,
+					"cache":     map[string]any{"read": cacheRead, "write": 0},
+				},
+			},
+		},
+	}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("marshaling event: %v", err)
+	}
+	return Payload{EventType: EventTypeEvent, OpenCodeEventType: "message.updated", Event: raw}
+}
+
+func TestUsageTracker_SumsLatestUpdateOfEachMessage(t *testing.T) {
+	tracker := NewUsageTracker()
+	tracker.Observe(messageUpdated(t, "msg-1", "assistant", 100, 10, 0, 0, 0.001))
+	// A later update of the same message replaces its usage
+	tracker.Observe(messageUpdated(t, "msg-1", "assistant", 100, 40, 5, 50, 0.004))
+	tracker.Observe(messageUpdated(t, "msg-2", "assistant", 200, 20, 0, 100, 0.002))
+	// User messages, other events and non-events carry no usage
+	tracker.Observe(messageUpdated(t, "msg-3", "user", 999, 999, 0, 0, 9))
+	tracker.Observe(Payload{EventType: EventTypeEvent, Event: json.RawMessage(`{"type":"session.idle"}`)})
+	tracker.Observe(Payload{EventType: EventTypeEvent, Event: json.RawMessage(`not json`)})
+	tracker.Observe(Payload{EventType: EventTypeComplete, IsFinal: true})
+
+	got := tracker.Total()
+	want := Usage{InputTokens: 300, OutputTokens: 65, CacheReadTokens: 150}
+	if math.Abs(got.CostUSD-0.006) > 1e-9 {
+		t.Errorf("expected cost 0.006, got %g", got.CostUSD)
+	}
+	got.CostUSD = 0
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestSumUsage_GroupsByAgentAndDay(t *testing.T) {
+	querier := &fakeUsageQuerier{rows: make(map[string]*sqlc.UpsertRequestUsageParams)}
+	s := newTestService(querier)
+	ctx := context.Background()
+
+	midnight := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	record := func(userID, agentID, requestID string, at time.Time, usage Usage) {
+		t.Helper()
+		if err := s.RecordUsage(ctx, userID, agentID, requestID, usage, at); err != nil {
+			t.Fatalf("RecordUsage: %v", err)
+		}
+	}
+	record("user1", "agent-a", "req-1", midnight.Add(-time.Minute), Usage{InputTokens: 10, OutputTokens: 1, CostUSD: 0.5, DurationMs: 1000})
+	record("user1", "agent-a", "req-2", midnight, Usage{InputTokens: 20, OutputTokens: 2, CostUSD: 0.25, DurationMs: 2000})
+	record("user1", "agent-b", "req-3", midnight.Add(time.Hour), Usage{InputTokens: 40, CacheReadTokens: 7, CostUSD: 0.125, DurationMs: 4000})
+	// Stored again, as once a resent request ended: replaced, not added
+	record("user1", "agent-b", "req-3", midnight.Add(2*time.Hour), Usage{InputTokens: 80, CacheReadTokens: 7, CostUSD: 0.125, DurationMs: 4000})
+	record("user2", "agent-c", "req-4", midnight, Usage{InputTokens: 1000})
+	record("user1", "agent-a", "req-5", midnight.Add(48*time.Hour), Usage{InputTokens: 1000})
+
+	from, to := midnight.Add(-24*time.Hour), midnight.Add(24*time.Hour)
+	byAgent, err := s.SumUsage(ctx, "user1", from, to, UsageGroupByAgent)
+	if err != nil {
+		t.Fatalf("SumUsage: %v", err)
+	}
+	wantByAgent := []UsageEntry{
+		{AgentID: "agent-a", Requests: 2, Usage: Usage{InputTokens: 30, OutputTokens: 3, CostUSD: 0.75, DurationMs: 3000}},
+		{AgentID: "agent-b", Requests: 1, Usage: Usage{InputTokens: 80, CacheReadTokens: 7, CostUSD: 0.125, DurationMs: 4000}},
+	}
+	if len(byAgent) != len(wantByAgent) || byAgent[0] != wantByAgent[0] || byAgent[1] != wantByAgent[1] {
+		t.Errorf("by agent: expected %+v, got %+v", wantByAgent, byAgent)
+	}
+
+	// The requests either side of midnight UTC fall on different days
+	byDay, err := s.SumUsage(ctx, "user1", from, to, UsageGroupByDay)
+	if err != nil {
+		t.Fatalf("SumUsage: %v", err)
+	}
+	wantByDay := []UsageEntry{
+		{Day: "2024-03-01", Requests: 1, Usage: Usage{InputTokens: 10, OutputTokens: 1, CostUSD: 0.5, DurationMs: 1000}},
+		{Day: "2024-03-02", Requests: 2, Usage: Usage{InputTokens: 100, OutputTokens: 2, CacheReadTokens: 7, CostUSD: 0.375, DurationMs: 6000}},
+	}
+	if len(byDay) != len(wantByDay) || byDay[0] != wantByDay[0] || byDay[1] != wantByDay[1] {
+		t.Errorf("by day: expected %+v, got %+v", wantByDay, byDay)
+	}
+}